@@ -0,0 +1,258 @@
+// Command loadgen drives a mix of AuthService calls against a running instance
+// and reports per-operation latency percentiles.
+//
+//	go run ./cmd/loadgen -addr localhost:50051 -users 50 -concurrency 32 -duration 30s -mix login=1,refresh=4,validate=15
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	pb "github.com/andro-kes/auth_service/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+type session struct {
+	mu       sync.Mutex
+	username string
+	password string
+	userID   string
+	access   string
+	refresh  string
+}
+
+type op struct {
+	name   string
+	weight int
+	run    func(ctx context.Context, c pb.AuthServiceClient, s *session) error
+}
+
+var ops = map[string]func(ctx context.Context, c pb.AuthServiceClient, s *session) error{
+	"login":    doLogin,
+	"refresh":  doRefresh,
+	"validate": doValidate,
+}
+
+func main() {
+	addr := flag.String("addr", "localhost:50051", "gRPC address of the auth service")
+	users := flag.Int("users", 20, "number of test users to register")
+	concurrency := flag.Int("concurrency", 16, "number of concurrent workers")
+	duration := flag.Duration("duration", 30*time.Second, "test duration")
+	timeout := flag.Duration("timeout", 5*time.Second, "per-call timeout")
+	mix := flag.String("mix", "login=1,refresh=4,validate=15", "weighted operation mix (login, refresh, validate)")
+	prefix := flag.String("prefix", "loadgen", "username prefix of test users")
+	flag.Parse()
+
+	plan, err := parseMix(*mix)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "invalid -mix:", err)
+		os.Exit(2)
+	}
+
+	conn, err := grpc.NewClient(*addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "dial:", err)
+		os.Exit(1)
+	}
+	defer conn.Close()
+	client := pb.NewAuthServiceClient(conn)
+
+	ctx := context.Background()
+	sessions, err := prepare(ctx, client, *prefix, *users, *timeout)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "prepare:", err)
+		os.Exit(1)
+	}
+
+	rec := newRecorder()
+	deadline := time.Now().Add(*duration)
+	start := time.Now()
+
+	var wg sync.WaitGroup
+	for w := 0; w < *concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for time.Now().Before(deadline) {
+				o := plan.pick()
+				s := sessions[rand.IntN(len(sessions))]
+
+				callCtx, cancel := context.WithTimeout(ctx, *timeout)
+				t0 := time.Now()
+				err := o.run(callCtx, client, s)
+				rec.add(o.name, time.Since(t0), err)
+				cancel()
+			}
+		}()
+	}
+	wg.Wait()
+
+	rec.report(os.Stdout, time.Since(start))
+}
+
+// prepare registers (or reuses) the test users and logs each of them in once.
+func prepare(ctx context.Context, c pb.AuthServiceClient, prefix string, n int, timeout time.Duration) ([]*session, error) {
+	sessions := make([]*session, 0, n)
+	for i := 0; i < n; i++ {
+		s := &session{
+			username: fmt.Sprintf("%s_%d", prefix, i),
+			password: "loadgen-password-" + strconv.Itoa(i),
+		}
+		callCtx, cancel := context.WithTimeout(ctx, timeout)
+		// the user may exist from a previous run; Login below is authoritative
+		_, _ = c.Register(callCtx, &pb.RegisterRequest{Username: s.username, Password: s.password})
+		cancel()
+
+		callCtx, cancel = context.WithTimeout(ctx, timeout)
+		err := doLogin(callCtx, c, s)
+		cancel()
+		if err != nil {
+			return nil, fmt.Errorf("login %s: %w", s.username, err)
+		}
+		sessions = append(sessions, s)
+	}
+	return sessions, nil
+}
+
+func doLogin(ctx context.Context, c pb.AuthServiceClient, s *session) error {
+	resp, err := c.Login(ctx, &pb.LoginRequest{Username: s.username, Password: s.password})
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.userID, s.access, s.refresh = resp.UserId, resp.AccessToken, resp.RefreshToken
+	s.mu.Unlock()
+	return nil
+}
+
+func doRefresh(ctx context.Context, c pb.AuthServiceClient, s *session) error {
+	// rotation invalidates the old token, so calls for one session are serialized
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	resp, err := c.Refresh(ctx, &pb.RefreshRequest{RefreshToken: s.refresh, ExpectedUserId: s.userID})
+	if err != nil {
+		return err
+	}
+	s.access, s.refresh = resp.AccessToken, resp.RefreshToken
+	return nil
+}
+
+func doValidate(ctx context.Context, c pb.AuthServiceClient, s *session) error {
+	s.mu.Lock()
+	access := s.access
+	s.mu.Unlock()
+
+	_, err := c.Validate(ctx, &pb.ValidateRequest{AccessToken: access})
+	return err
+}
+
+type mixPlan struct {
+	ops   []op
+	total int
+}
+
+func parseMix(spec string) (*mixPlan, error) {
+	plan := &mixPlan{}
+	for _, part := range strings.Split(spec, ",") {
+		name, weight, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return nil, fmt.Errorf("expected name=weight, got %q", part)
+		}
+		run, known := ops[name]
+		if !known {
+			return nil, fmt.Errorf("unknown operation %q", name)
+		}
+		w, err := strconv.Atoi(weight)
+		if err != nil || w < 0 {
+			return nil, fmt.Errorf("invalid weight for %s: %q", name, weight)
+		}
+		if w == 0 {
+			continue
+		}
+		plan.ops = append(plan.ops, op{name: name, weight: w, run: run})
+		plan.total += w
+	}
+	if plan.total == 0 {
+		return nil, fmt.Errorf("mix has no operations")
+	}
+	return plan, nil
+}
+
+func (p *mixPlan) pick() op {
+	n := rand.IntN(p.total)
+	for _, o := range p.ops {
+		if n < o.weight {
+			return o
+		}
+		n -= o.weight
+	}
+	return p.ops[len(p.ops)-1]
+}
+
+type recorder struct {
+	mu        sync.Mutex
+	latencies map[string][]time.Duration
+	errors    map[string]int
+}
+
+func newRecorder() *recorder {
+	return &recorder{
+		latencies: make(map[string][]time.Duration),
+		errors:    make(map[string]int),
+	}
+}
+
+func (r *recorder) add(name string, d time.Duration, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.latencies[name] = append(r.latencies[name], d)
+	if err != nil {
+		r.errors[name]++
+	}
+}
+
+func (r *recorder) report(w io.Writer, elapsed time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	names := make([]string, 0, len(r.latencies))
+	for name := range r.latencies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintf(w, "%-10s %8s %8s %8s %10s %10s %10s %10s\n", "op", "calls", "errors", "rps", "p50", "p90", "p99", "max")
+	for _, name := range names {
+		l := r.latencies[name]
+		sort.Slice(l, func(i, j int) bool { return l[i] < l[j] })
+		fmt.Fprintf(w, "%-10s %8d %8d %8.1f %10s %10s %10s %10s\n",
+			name, len(l), r.errors[name], float64(len(l))/elapsed.Seconds(),
+			percentile(l, 0.50), percentile(l, 0.90), percentile(l, 0.99), l[len(l)-1])
+	}
+}
+
+// percentile expects sorted input.
+func percentile(sorted []time.Duration, q float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	idx := int(q*float64(len(sorted))+0.5) - 1
+	if idx < 0 {
+		idx = 0
+	}
+	if idx >= len(sorted) {
+		idx = len(sorted) - 1
+	}
+	return sorted[idx].Round(time.Microsecond)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/andro-kes/auth_service/internal/logger"
+	pb "github.com/andro-kes/auth_service/proto"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+const (
+	healthCheckInterval = 5 * time.Second
+	healthCheckTimeout  = 2 * time.Second
+)
+
+// Health services reporting a single dependency; the server as a whole ("")
+// and the auth service are serving only when both are.
+const (
+	healthPostgres = "postgres"
+	healthRedis    = "redis"
+)
+
+// watchHealth pings Postgres and Redis every healthCheckInterval until ctx
+// is done and publishes the results on hs.
+func watchHealth(ctx context.Context, hs *health.Server, pool *pgxpool.Pool, rdb redis.UniversalClient) {
+	ticker := time.NewTicker(healthCheckInterval)
+	defer ticker.Stop()
+	for {
+		pgErr := ping(ctx, pool.Ping)
+		redisErr := ping(ctx, func(ctx context.Context) error { return rdb.Ping(ctx).Err() })
+
+		setHealth(hs, healthPostgres, pgErr)
+		setHealth(hs, healthRedis, redisErr)
+		overall := healthpb.HealthCheckResponse_SERVING
+		if pgErr != nil || redisErr != nil {
+			overall = healthpb.HealthCheckResponse_NOT_SERVING
+		}
+		hs.SetServingStatus("", overall)
+		hs.SetServingStatus(pb.AuthService_ServiceDesc.ServiceName, overall)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func ping(ctx context.Context, fn func(context.Context) error) error {
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+	return fn(ctx)
+}
+
+// setHealth publishes the status of a dependency, logging transitions to
+// NOT_SERVING.
+func setHealth(hs *health.Server, service string, err error) {
+	if err == nil {
+		hs.SetServingStatus(service, healthpb.HealthCheckResponse_SERVING)
+		return
+	}
+	if prev, _ := hs.Check(context.Background(), &healthpb.HealthCheckRequest{Service: service}); prev.GetStatus() != healthpb.HealthCheckResponse_NOT_SERVING {
+		logger.Logger().Warn("dependency unhealthy", zap.String("dependency", service), zap.Error(err))
+	}
+	hs.SetServingStatus(service, healthpb.HealthCheckResponse_NOT_SERVING)
+}
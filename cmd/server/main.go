@@ -2,19 +2,28 @@ package main
 
 import (
 	"context"
+	"errors"
 	"net"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
+	"github.com/andro-kes/auth_service/internal/chaos"
+	"github.com/andro-kes/auth_service/internal/config"
+	"github.com/andro-kes/auth_service/internal/httpapi"
 	"github.com/andro-kes/auth_service/internal/logger"
+	"github.com/andro-kes/auth_service/internal/metrics"
 	"github.com/andro-kes/auth_service/internal/migrate"
 	"github.com/andro-kes/auth_service/internal/rpc"
+	"github.com/andro-kes/auth_service/internal/tracing"
 	pb "github.com/andro-kes/auth_service/proto"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 )
 
 func main() {
@@ -32,12 +41,38 @@ func main() {
 	zl := logger.Logger()
 	defer logger.Sync()
 
-	// migrate
-	dbURL := os.Getenv("DB_URL")
-	if dbURL == "" {
-		panic("DB_URL must be set")
+	appCfg, err := config.Load()
+	if err != nil {
+		panic("invalid configuration: " + err.Error())
+	}
+
+	faults, err := chaos.FromEnv()
+	if err != nil {
+		panic("invalid chaos configuration: " + err.Error())
 	}
-	if err := migrate.AutoMigrate(dbURL, zl); err != nil {
+	if chaos.Enabled {
+		zl.Warn("chaos build: fault injection is available",
+			zap.Bool("postgres", faults.Postgres != nil),
+			zap.Bool("redis", faults.Redis != nil))
+	}
+
+	if tracing.Enabled() {
+		shutdownTracing, err := tracing.Setup(context.Background())
+		if err != nil {
+			panic("tracing setup error: " + err.Error())
+		}
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := shutdownTracing(ctx); err != nil {
+				zl.Error("tracing shutdown error", zap.Error(err))
+			}
+		}()
+		zl.Info("OpenTelemetry tracing enabled")
+	}
+
+	// migrate
+	if err := migrate.AutoMigrate(appCfg.DBURL, zl); err != nil {
 		panic("migrations error: " + err.Error())
 	}
 
@@ -45,36 +80,111 @@ func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	pool, err := NewPool(ctx)
+	pool, err := NewPool(ctx, appCfg.DBURL, faults.Postgres)
 	if err != nil {
 		panic("failed to create pool: " + err.Error())
 	}
 	defer pool.Close()
 
 	// gRPC server init
-	addr := os.Getenv("GRPC_ADDR")
-	if addr == "" {
-		panic("GRPC_ADDR must be set")
-	}
-	listen, err := net.Listen("tcp", addr)
+	listen, err := net.Listen("tcp", appCfg.GRPCAddr)
 	if err != nil {
 		panic("listen error: " + err.Error())
 	}
 
-	rpcAuth, err := rpc.NewAuthServer(ctx, pool)
+	rdb, err := NewRedis(ctx, appCfg.Redis, faults.Redis)
+	if err != nil {
+		panic("failed to connect to redis: " + err.Error())
+	}
+	defer rdb.Close()
+
+	rpcAuth, err := rpc.NewAuthServer(ctx, pool, rdb, appCfg)
 	if err != nil {
 		panic("error creating auth server: " + err.Error())
 	}
-	grpcServer := grpc.NewServer()
+
+	go func() {
+		if err := rpcAuth.TokenService.SyncRevocations(ctx); err != nil && ctx.Err() == nil {
+			zl.Error("revocation sync stopped", zap.Error(err))
+		}
+	}()
+	go rpcAuth.TokenService.PruneRefreshStore(ctx, time.Hour)
+	if appCfg.Users.PurgeAfter > 0 {
+		go rpcAuth.UserService.PurgeDeletedUsers(ctx, time.Hour, appCfg.Users.PurgeAfter)
+	}
+	if appCfg.Users.LoginAttemptRetention > 0 {
+		go rpcAuth.UserService.PurgeLoginAttempts(ctx, time.Hour, appCfg.Users.LoginAttemptRetention)
+	}
+
+	var serverOpts []grpc.ServerOption
+	if appCfg.TLS.Enabled() {
+		creds, err := serverCredentials(appCfg.TLS)
+		if err != nil {
+			panic("tls config error: " + err.Error())
+		}
+		serverOpts = append(serverOpts, grpc.Creds(creds))
+		zl.Info("gRPC TLS enabled", zap.Bool("mtls", appCfg.TLS.MutualTLS()))
+	}
+	serverOpts = append(serverOpts, grpc.ChainUnaryInterceptor(
+		tracing.UnaryServerInterceptor(),
+		rpc.LoggingInterceptor,
+		metrics.UnaryServerInterceptor(),
+	))
+	grpcServer := grpc.NewServer(serverOpts...)
 	pb.RegisterAuthServiceServer(grpcServer, rpcAuth)
+	healthServer := health.NewServer()
+	healthpb.RegisterHealthServer(grpcServer, healthServer)
+	go watchHealth(ctx, healthServer, pool, rdb)
 
-	serveErr := make(chan error, 1)
+	serveErr := make(chan error, 3)
 	go func() {
 		if err := grpcServer.Serve(listen); err != nil {
 			serveErr <- err
 		}
 	}()
 
+	var httpServer *http.Server
+	if appCfg.HTTP.Addr != "" {
+		handler, err := httpapi.New(ctx, rpcAuth, rpcAuth.TokenService, rpcAuth.SAML, appCfg.HTTP)
+		if err != nil {
+			panic("http gateway error: " + err.Error())
+		}
+		httpServer = &http.Server{
+			Addr:              appCfg.HTTP.Addr,
+			Handler:           handler,
+			ReadHeaderTimeout: 5 * time.Second,
+		}
+		go func() {
+			var err error
+			if appCfg.TLS.Enabled() {
+				err = httpServer.ListenAndServeTLS(appCfg.TLS.CertFile, appCfg.TLS.KeyFile)
+			} else {
+				err = httpServer.ListenAndServe()
+			}
+			if !errors.Is(err, http.ErrServerClosed) {
+				serveErr <- err
+			}
+		}()
+		zl.Info("HTTP gateway listening", zap.String("addr", appCfg.HTTP.Addr))
+	}
+
+	var metricsServer *http.Server
+	if appCfg.HTTP.MetricsAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("GET /metrics", metrics.Handler())
+		metricsServer = &http.Server{
+			Addr:              appCfg.HTTP.MetricsAddr,
+			Handler:           mux,
+			ReadHeaderTimeout: 5 * time.Second,
+		}
+		go func() {
+			if err := metricsServer.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+				serveErr <- err
+			}
+		}()
+		zl.Info("metrics listening", zap.String("addr", appCfg.HTTP.MetricsAddr))
+	}
+
 	shutdown := make(chan os.Signal, 1)
 	signal.Notify(shutdown, os.Interrupt, syscall.SIGTERM)
 
@@ -82,14 +192,25 @@ func main() {
 	case sig := <-shutdown:
 		zl.Info("shutdown signal received", zap.String("signal", sig.String()))
 	case err := <-serveErr:
-		zl.Error("gRPC server error", zap.Error(err))
+		zl.Error("server error", zap.Error(err))
 	}
 
+	// stop routing new traffic here before draining
+	healthServer.Shutdown()
+	if httpServer != nil {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		if err := httpServer.Shutdown(shutdownCtx); err != nil {
+			zl.Error("HTTP shutdown error", zap.Error(err))
+		}
+		cancel()
+	}
+	if metricsServer != nil {
+		_ = metricsServer.Close()
+	}
 	grpcServer.GracefulStop()
 }
 
-func NewPool(ctx context.Context) (*pgxpool.Pool, error) {
-	dbURL := os.Getenv("DB_URL")
+func NewPool(ctx context.Context, dbURL string, faults *chaos.Injector) (*pgxpool.Pool, error) {
 	cfg, err := pgxpool.ParseConfig(dbURL)
 	if err != nil {
 		return nil, err
@@ -99,6 +220,12 @@ func NewPool(ctx context.Context) (*pgxpool.Pool, error) {
 	cfg.MinConns = 2
 	cfg.MaxConnLifetime = 30 * time.Minute
 	cfg.HealthCheckPeriod = 1 * time.Minute
+	if faults != nil {
+		cfg.PrepareConn = faults.PrepareConn()
+	}
+	if tracing.Enabled() {
+		cfg.ConnConfig.Tracer = tracing.PgxTracer()
+	}
 
 	pool, err := pgxpool.NewWithConfig(ctx, cfg)
 	if err != nil {
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/andro-kes/auth_service/internal/chaos"
+	"github.com/andro-kes/auth_service/internal/config"
+	"github.com/andro-kes/auth_service/internal/metrics"
+	"github.com/andro-kes/auth_service/internal/tracing"
+	"github.com/redis/go-redis/v9"
+)
+
+// NewRedis connects to the configured Redis deployment: a single node, a
+// Sentinel-managed master or a cluster.
+func NewRedis(ctx context.Context, cfg config.Redis, faults *chaos.Injector) (redis.UniversalClient, error) {
+	opts, err := redisOptions(cfg)
+	if err != nil {
+		return nil, err
+	}
+	rdb := redis.NewUniversalClient(opts)
+	rdb.AddHook(metrics.RedisHook())
+	if tracing.Enabled() {
+		rdb.AddHook(tracing.RedisHook())
+	}
+	if faults != nil {
+		rdb.AddHook(faults.RedisHook())
+	}
+
+	pingCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	if err := rdb.Ping(pingCtx).Err(); err != nil {
+		_ = rdb.Close()
+		return nil, err
+	}
+	return rdb, nil
+}
+
+// redisOptions maps the configured Redis deployment to client options.
+func redisOptions(cfg config.Redis) (*redis.UniversalOptions, error) {
+	opts := &redis.UniversalOptions{
+		Addrs:         cfg.Addrs,
+		MasterName:    cfg.MasterName,
+		IsClusterMode: cfg.Cluster,
+		Username:      cfg.Username,
+		Password:      cfg.Password,
+		DB:            cfg.DB,
+	}
+	if cfg.TLS {
+		tlsCfg, err := redisTLSConfig(cfg)
+		if err != nil {
+			return nil, err
+		}
+		opts.TLSConfig = tlsCfg
+	}
+	return opts, nil
+}
+
+func redisTLSConfig(cfg config.Redis) (*tls.Config, error) {
+	tlsCfg := &tls.Config{
+		ServerName: cfg.ServerName,
+		MinVersion: tls.VersionTLS12,
+	}
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read Redis CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.CAFile)
+		}
+		tlsCfg.RootCAs = pool
+	}
+	if cfg.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load Redis client key pair: %w", err)
+		}
+		tlsCfg.Certificates = []tls.Certificate{cert}
+	}
+	return tlsCfg, nil
+}
@@ -0,0 +1,56 @@
+// Package alert forwards high-severity security events to an external
+// alerting system.
+package alert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Alert is a single notification.
+type Alert struct {
+	Severity string            `json:"severity"`
+	Title    string            `json:"title"`
+	Fields   map[string]string `json:"fields,omitempty"`
+	Time     time.Time         `json:"time"`
+}
+
+// Sender delivers alerts.
+type Sender interface {
+	Send(ctx context.Context, a Alert) error
+}
+
+// Webhook posts alerts as JSON to a URL.
+type Webhook struct {
+	url    string
+	client *http.Client
+}
+
+func NewWebhook(url string) *Webhook {
+	return &Webhook{url: url, client: &http.Client{Timeout: 5 * time.Second}}
+}
+
+func (w *Webhook) Send(ctx context.Context, a Alert) error {
+	body, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("alert webhook: unexpected status %s", resp.Status)
+	}
+	return nil
+}
@@ -2,9 +2,13 @@ package autherr
 
 import (
 	"encoding/json"
+	"time"
 
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/protoadapt"
+	"google.golang.org/protobuf/types/known/durationpb"
 )
 
 // AuthError is a small error type intended for use in RPC responses.
@@ -16,6 +20,12 @@ type AuthError struct {
 
 	// grpcCode is not serialized to JSON but is used when converting to gRPC status/errors.
 	grpcCode codes.Code `json:"-"`
+
+	// details are attached to the gRPC status, e.g. RetryInfo.
+	details []protoadapt.MessageV1
+
+	// sentinel is the predefined error this one is a copy of, if any.
+	sentinel *AuthError
 }
 
 // Ensure AuthError implements error.
@@ -26,6 +36,21 @@ func (e *AuthError) Error() string {
 	return e.Message
 }
 
+// Is reports whether e is a copy of target made with WithMessage or
+// WithDetails, so that errors.Is matches copies with their sentinel.
+func (e *AuthError) Is(target error) bool {
+	t, ok := target.(*AuthError)
+	return ok && e != nil && e.sentinel != nil && e.sentinel == t
+}
+
+// origin returns the predefined error e was copied from, or e itself.
+func (e *AuthError) origin() *AuthError {
+	if e.sentinel != nil {
+		return e.sentinel
+	}
+	return e
+}
+
 // MarshalJSON ensures only the message (and optionally code name) are exposed to JSON clients.
 func (e *AuthError) MarshalJSON() ([]byte, error) {
 	if e == nil {
@@ -58,7 +83,27 @@ func (e *AuthError) WithMessage(msg string) *AuthError {
 	if e == nil {
 		return New(msg, codes.Internal)
 	}
-	return &AuthError{Message: msg, grpcCode: e.grpcCode}
+	return &AuthError{Message: msg, grpcCode: e.grpcCode, details: e.details, sentinel: e.origin()}
+}
+
+// WithDetails returns a copy of the error with details added to its gRPC
+// status.
+func (e *AuthError) WithDetails(details ...protoadapt.MessageV1) *AuthError {
+	if e == nil {
+		return nil
+	}
+	return &AuthError{
+		Message:  e.Message,
+		grpcCode: e.grpcCode,
+		details:  append(append([]protoadapt.MessageV1(nil), e.details...), details...),
+		sentinel: e.origin(),
+	}
+}
+
+// WithRetryDelay returns a copy of the error that tells clients to retry
+// after d.
+func (e *AuthError) WithRetryDelay(d time.Duration) *AuthError {
+	return e.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(d)})
 }
 
 // GRPCStatus returns a *status.Status suitable for returning from gRPC handlers.
@@ -66,7 +111,15 @@ func (e *AuthError) GRPCStatus() *status.Status {
 	if e == nil {
 		return status.New(codes.Internal, "internal error")
 	}
-	return status.New(e.grpcCode, e.Message)
+	st := status.New(e.grpcCode, e.Message)
+	if len(e.details) == 0 {
+		return st
+	}
+	withDetails, err := st.WithDetails(e.details...)
+	if err != nil {
+		return st
+	}
+	return withDetails
 }
 
 // GRPCError returns an error that can be returned from a gRPC method (status.Error).
@@ -92,26 +145,42 @@ func ToGRPCError(err error) error {
 }
 
 // Predefined common errors for the auth microservice.
-// You may use these directly or create copies with WithMessage when you need contextual text.
+// You may use these directly or create copies with WithMessage when you need contextual text;
+// compare with errors.Is so that copies match too.
 var (
 	// user creation/login issues
 	ErrCreateUser = New("failed to create user", codes.Internal)
 	ErrLoginUser  = New("invalid credentials", codes.Unauthenticated)
+	ErrUserExists = New("user already exists", codes.AlreadyExists)
+	ErrInvalidMFA = New("invalid verification code", codes.Unauthenticated)
+	// ErrLastLoginMethod refuses to remove the only way a user logs in.
+	ErrLastLoginMethod = New("cannot remove the last login method; set a password or link another account first", codes.FailedPrecondition)
 
 	// token related
-	ErrInvalidToken    = New("invalid token", codes.Unauthenticated)
-	ErrTokenExpired    = New("token expired", codes.Unauthenticated)
-	ErrNoToken         = New("no token provided", codes.Unauthenticated)
-	ErrTokenGeneration = New("failed to generate token", codes.Internal)
+	ErrInvalidToken       = New("invalid token", codes.Unauthenticated)
+	ErrTokenExpired       = New("token expired", codes.Unauthenticated)
+	ErrNoToken            = New("no token provided", codes.Unauthenticated)
+	ErrTokenGeneration    = New("failed to generate token", codes.Internal)
+	ErrInvalidDPoPProof   = New("invalid DPoP proof", codes.Unauthenticated)
+	ErrTokenReplayed      = New("token already used", codes.Unauthenticated)
+	ErrRotationInProgress = New("refresh already in progress", codes.Aborted)
 
 	// storage related (single canonical value)
 	ErrStorageError = New("storage error", codes.Internal)
 
 	// authorization / access
-	ErrForbidden = New("forbidden", codes.PermissionDenied)
-	ErrNotFound  = New("not found", codes.NotFound)
+	ErrForbidden       = New("forbidden", codes.PermissionDenied)
+	ErrAccountDisabled = New("account disabled", codes.PermissionDenied)
+	ErrAccountLocked   = New("account locked after too many failed logins, try again later", codes.PermissionDenied)
+	ErrTooManySessions = New("too many active sessions", codes.ResourceExhausted)
+	ErrNotFound        = New("not found", codes.NotFound)
+	ErrConflict        = New("already exists", codes.AlreadyExists)
 
 	// generic
 	ErrBadRequest   = New("bad request", codes.InvalidArgument)
 	ErrHashPassword = New("failed to hash password", codes.Internal)
+	ErrOverloaded   = New("server is busy, retry later", codes.ResourceExhausted)
+	ErrDelivery     = New("failed to deliver message", codes.Unavailable)
+	ErrUnavailable  = New("temporarily unavailable, retry later", codes.Unavailable)
+	ErrTooSoon      = New("too soon, try again later", codes.FailedPrecondition)
 )
@@ -0,0 +1,177 @@
+// Package chaos injects latency and errors into the Postgres and Redis layers
+// to exercise timeout, retry and degraded-mode behaviour under controlled
+// failures.
+//
+// Injection is only available in binaries built with the "chaos" build tag:
+//
+//	go build -tags chaos -o bin/auth_service_chaos ./cmd/server
+//
+// and is then configured through CHAOS_PG_* and CHAOS_REDIS_* variables (see
+// FromEnv). Regular builds ignore those variables.
+package chaos
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrInjected is returned for injected failures.
+var ErrInjected = errors.New("chaos: injected failure")
+
+// Config describes the faults injected into one dependency.
+type Config struct {
+	// Latency is added to every operation.
+	Latency time.Duration
+	// Jitter adds a uniformly distributed extra delay in [0, Jitter).
+	Jitter time.Duration
+	// ErrorRate is the probability in [0, 1] that an operation fails.
+	ErrorRate float64
+}
+
+func (c Config) active() bool {
+	return c.Latency > 0 || c.Jitter > 0 || c.ErrorRate > 0
+}
+
+// Injector applies a Config to operations.
+type Injector struct {
+	cfg Config
+}
+
+// NewInjector returns nil for a config that injects nothing.
+func NewInjector(cfg Config) *Injector {
+	if !cfg.active() {
+		return nil
+	}
+	return &Injector{cfg: cfg}
+}
+
+// Inject delays the caller and possibly returns ErrInjected. It returns the
+// context error if ctx ends during the delay. A nil Injector does nothing.
+func (i *Injector) Inject(ctx context.Context) error {
+	if i == nil {
+		return nil
+	}
+	delay := i.cfg.Latency
+	if i.cfg.Jitter > 0 {
+		delay += time.Duration(rand.Int64N(int64(i.cfg.Jitter)))
+	}
+	if delay > 0 {
+		t := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+	if i.cfg.ErrorRate > 0 && rand.Float64() < i.cfg.ErrorRate {
+		return ErrInjected
+	}
+	return nil
+}
+
+// PrepareConn returns a pgxpool.Config.PrepareConn hook that injects faults
+// every time a connection is acquired, i.e. once per query or transaction.
+func (i *Injector) PrepareConn() func(context.Context, *pgx.Conn) (bool, error) {
+	return func(ctx context.Context, _ *pgx.Conn) (bool, error) {
+		return true, i.Inject(ctx)
+	}
+}
+
+// RedisHook returns a go-redis hook that injects faults into every command
+// and pipeline.
+func (i *Injector) RedisHook() redis.Hook {
+	return redisHook{inj: i}
+}
+
+type redisHook struct {
+	inj *Injector
+}
+
+func (h redisHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (h redisHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		if err := h.inj.Inject(ctx); err != nil {
+			cmd.SetErr(err)
+			return err
+		}
+		return next(ctx, cmd)
+	}
+}
+
+func (h redisHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		if err := h.inj.Inject(ctx); err != nil {
+			for _, cmd := range cmds {
+				cmd.SetErr(err)
+			}
+			return err
+		}
+		return next(ctx, cmds)
+	}
+}
+
+// Injectors holds the per-dependency injectors. Nil fields inject nothing.
+type Injectors struct {
+	Postgres *Injector
+	Redis    *Injector
+}
+
+// FromEnv reads CHAOS_{PG,REDIS}_{LATENCY,JITTER,ERROR_RATE}. Without the
+// "chaos" build tag it always returns empty Injectors.
+func FromEnv() (Injectors, error) {
+	if !Enabled {
+		return Injectors{}, nil
+	}
+	pg, err := configFromEnv("CHAOS_PG")
+	if err != nil {
+		return Injectors{}, err
+	}
+	rd, err := configFromEnv("CHAOS_REDIS")
+	if err != nil {
+		return Injectors{}, err
+	}
+	return Injectors{Postgres: NewInjector(pg), Redis: NewInjector(rd)}, nil
+}
+
+func configFromEnv(prefix string) (Config, error) {
+	var cfg Config
+	var err error
+	if cfg.Latency, err = durationEnv(prefix + "_LATENCY"); err != nil {
+		return cfg, err
+	}
+	if cfg.Jitter, err = durationEnv(prefix + "_JITTER"); err != nil {
+		return cfg, err
+	}
+	if v := strings.TrimSpace(os.Getenv(prefix + "_ERROR_RATE")); v != "" {
+		cfg.ErrorRate, err = strconv.ParseFloat(v, 64)
+		if err != nil || cfg.ErrorRate < 0 || cfg.ErrorRate > 1 {
+			return cfg, fmt.Errorf("%s_ERROR_RATE: expected a number in [0, 1], got %q", prefix, v)
+		}
+	}
+	return cfg, nil
+}
+
+func durationEnv(key string) (time.Duration, error) {
+	v := strings.TrimSpace(os.Getenv(key))
+	if v == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("%s: invalid duration %q", key, v)
+	}
+	return d, nil
+}
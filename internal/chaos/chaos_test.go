@@ -0,0 +1,38 @@
+package chaos
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestInjector(t *testing.T) {
+	if NewInjector(Config{}) != nil {
+		t.Fatal("expected nil injector for an empty config")
+	}
+	var nilInj *Injector
+	if err := nilInj.Inject(context.Background()); err != nil {
+		t.Fatalf("nil injector must not fail, got %v", err)
+	}
+
+	failing := NewInjector(Config{ErrorRate: 1})
+	if err := failing.Inject(context.Background()); !errors.Is(err, ErrInjected) {
+		t.Fatalf("expected ErrInjected, got %v", err)
+	}
+
+	slow := NewInjector(Config{Latency: 20 * time.Millisecond})
+	start := time.Now()
+	if err := slow.Inject(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if time.Since(start) < 20*time.Millisecond {
+		t.Fatal("expected injected latency")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	if err := NewInjector(Config{Latency: time.Second}).Inject(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline error, got %v", err)
+	}
+}
@@ -0,0 +1,6 @@
+//go:build chaos
+
+package chaos
+
+// Enabled reports whether the binary was built with fault injection support.
+const Enabled = true
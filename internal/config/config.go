@@ -0,0 +1,1316 @@
+package config
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds the process configuration. Values are read from environment
+// variables by Load; see README for the full list.
+type Config struct {
+	// GRPCAddr is the address the gRPC server listens on.
+	GRPCAddr string
+	// DBURL is the Postgres connection string.
+	DBURL string
+	// SecretKey is the HMAC secret used to sign access tokens.
+	SecretKey string
+	// CryptoMode selects the crypto provider: "standard" or "fips".
+	CryptoMode string
+	// AdminAPIKey authorizes admin RPCs; empty disables them.
+	AdminAPIKey string
+	// SecurityAlertWebhook receives high-severity security events as JSON;
+	// empty disables external alerting.
+	SecurityAlertWebhook string
+	// JWTBearerAudience is the "aud" required in service account
+	// assertions; empty disables the JWT bearer grant.
+	JWTBearerAudience string
+	// DeviceBinding is the policy for refresh tokens rotated from another
+	// device: "off" (default), "warn" or "enforce".
+	DeviceBinding string
+	// TokenFormat is the access token format: "jwt" (default), "opaque",
+	// "paseto-v4-local" or "paseto-v4-public".
+	TokenFormat string
+
+	PASETO PASETO
+
+	Tokens Tokens
+
+	Redis Redis
+
+	Signing Signing
+
+	TLS TLS
+
+	HTTP HTTP
+
+	Mail Mail
+
+	SMS SMS
+
+	Hashing Hashing
+
+	Passwords Passwords
+
+	Users Users
+
+	MFA MFA
+
+	LoginLinks LoginLinks
+
+	PasswordResets PasswordResets
+
+	Federation Federation
+
+	LDAP []LDAPDirectory
+
+	ValidationCache ValidationCache
+
+	ScopedTokens ScopedTokens
+
+	ReferenceTokens ReferenceTokens
+
+	LoginBackoff LoginBackoff
+
+	Risk Risk
+
+	RateLimit RateLimit
+}
+
+// Risk configures the risk evaluation of logins.
+type Risk struct {
+	// Enabled turns the evaluation on.
+	Enabled bool
+	// NewDevice and ImpossibleTravel are "allow", "mfa" (default) or
+	// "deny".
+	NewDevice        string
+	ImpossibleTravel string
+	// MaxTravelSpeed in km/h above which travel counts as impossible.
+	MaxTravelSpeed int
+	// History is how long devices and locations of a user are remembered.
+	History time.Duration
+}
+
+// RateLimit configures per-IP request limits on the credential RPCs and the
+// cap on concurrent sessions per user.
+type RateLimit struct {
+	// Requests allowed per client IP and Window; 0 disables the limit.
+	Requests int
+	Window   time.Duration
+	// MaxSessions caps active sessions per user; 0 means unlimited.
+	MaxSessions int
+}
+
+// LoginBackoff configures delays after consecutive failed logins.
+type LoginBackoff struct {
+	// Threshold is the number of failures tolerated without delay.
+	Threshold int
+	// BaseDelay is the first delay; it doubles with each further failure.
+	BaseDelay time.Duration
+	// MaxDelay caps the delay; 0 disables backoff.
+	MaxDelay time.Duration
+	// Window is how long failures are remembered.
+	Window time.Duration
+	// LockoutThreshold is the number of consecutive failures of an account
+	// that lock it for LockoutDuration; 0 disables the lockout.
+	LockoutThreshold int
+	LockoutDuration  time.Duration
+	// UnlockURL is the page the link mailed to the owner of a locked
+	// account opens, with the unlock token in the "token" query parameter;
+	// empty mails the bare token.
+	UnlockURL string
+}
+
+// HTTP configures the REST gateway and ops endpoints.
+type HTTP struct {
+	// Addr is the HTTP listen address; empty disables the HTTP surface.
+	Addr string
+	// MetricsAddr serves /metrics alone on a separate listener, so that
+	// metrics can be kept off the public address.
+	MetricsAddr string
+	CORS        CORS
+	// Issuer is Tokens.Issuer, which the OIDC discovery document describes.
+	Issuer string
+}
+
+// CORS configures cross-origin access for browser clients.
+type CORS struct {
+	// AllowedOrigins lists origins allowed to call the API; "*" allows any.
+	AllowedOrigins []string
+	// AllowCredentials lets browsers send cookies and HTTP auth.
+	AllowCredentials bool
+	// MaxAge is how long browsers may cache preflight results.
+	MaxAge time.Duration
+}
+
+// Mail configures outgoing email. Without SMTPAddr messages are only logged.
+type Mail struct {
+	SMTPAddr     string
+	From         string
+	SMTPUsername string
+	SMTPPassword string
+}
+
+// SMS configures outgoing text messages. Without Provider messages are only
+// logged.
+type SMS struct {
+	// Provider is "twilio" or "sns".
+	Provider string
+	// TwilioAccountSID, TwilioAuthToken and TwilioFrom (a phone number or
+	// messaging service SID) configure Twilio.
+	TwilioAccountSID string
+	TwilioAuthToken  string
+	TwilioFrom       string
+	// SNSRegion and the static AWS credentials configure Amazon SNS.
+	SNSRegion          string
+	AWSAccessKeyID     string
+	AWSSecretAccessKey string
+}
+
+// Redis describes the Redis deployment: a single node, a Sentinel-managed
+// master or a cluster.
+type Redis struct {
+	// Addrs is the node, the sentinels or the cluster seed nodes.
+	Addrs []string
+	// MasterName selects Sentinel: the name of the monitored master.
+	MasterName string
+	// Cluster forces cluster mode with a single configuration endpoint;
+	// several Addrs without MasterName imply it.
+	Cluster bool
+
+	// Username and Password authenticate with AUTH; Username selects an
+	// ACL user (Redis 6+).
+	Username string
+	Password string
+	// DB is the database index; clusters only have 0.
+	DB int
+
+	// TLS enables TLS to Redis. CAFile replaces the system roots; CertFile
+	// and KeyFile present a client certificate.
+	TLS        bool
+	CAFile     string
+	CertFile   string
+	KeyFile    string
+	ServerName string
+
+	// DegradedMode keeps validating access tokens while Redis is
+	// unreachable; refresh operations then fail with Unavailable and ask
+	// clients to retry after DegradedRetryAfter.
+	DegradedMode       bool
+	DegradedRetryAfter time.Duration
+}
+
+// Tokens configures the lifetimes of issued tokens.
+type Tokens struct {
+	// AccessTTL is the lifetime of access tokens.
+	AccessTTL time.Duration
+	// RefreshTTL is the lifetime of refresh tokens and thus of idle sessions.
+	RefreshTTL time.Duration
+	// RememberMeTTL replaces RefreshTTL for sessions of logins with
+	// remember_me set; 0 treats them like any other login.
+	RememberMeTTL time.Duration
+	// Store is where refresh tokens are kept: "redis" (default) or
+	// "postgres", which writes them to Postgres too and restores them from
+	// there when Redis loses them.
+	Store string
+	// MaxSessionLifetime caps a session however often it is refreshed; 0
+	// leaves sessions unbounded.
+	MaxSessionLifetime time.Duration
+	// VersionCheck embeds the user's token version in access tokens and
+	// rejects tokens of older versions.
+	VersionCheck bool
+	// MetadataClaims are the keys of the user's profile metadata copied into
+	// access tokens.
+	MetadataClaims []string
+	// Leeway tolerates clock skew when checking the expiry and "nbf" of
+	// access tokens.
+	Leeway time.Duration
+	// RotationGrace is how long a rotated refresh token may still be
+	// presented to retry the rotation; 0 disables the grace period.
+	RotationGrace time.Duration
+	// RefreshPepper keys the HMAC refresh tokens are stored under; empty
+	// stores them under plain SHA-256.
+	RefreshPepper string
+	// Issuer is the public URL of the service, stamped on tokens as "iss";
+	// the OIDC discovery document is served for it.
+	Issuer string
+}
+
+// ScopedTokens configures short-lived scoped access tokens.
+type ScopedTokens struct {
+	// TTL is the lifetime of scoped tokens.
+	TTL time.Duration
+	// OneTimeScopes lists scopes whose tokens are valid for a single call.
+	OneTimeScopes []string
+	// OneTimeClients lists client IDs that always receive one-time tokens,
+	// matched against the common name of the mTLS client certificate.
+	OneTimeClients []string
+}
+
+// Signing configures a migration to a new access token signing key. Without
+// a new key tokens are signed with SecretKey.
+type Signing struct {
+	// KeyFile is a PEM private key (RSA, ECDSA or Ed25519) to sign with.
+	KeyFile string
+	// Algorithm pins the algorithm KeyFile must sign with: RS256, ES256,
+	// ES384 or EdDSA. Empty accepts whatever the key implies.
+	Algorithm string
+	// PreviousKeyFiles are keys rotated out earlier; tokens they signed
+	// are accepted until the files are removed from the list.
+	PreviousKeyFiles []string
+	// PreviousSecrets are HMAC secrets rotated out earlier; tokens they
+	// signed keep validating while they are listed.
+	PreviousSecrets []string
+	// NextSecret is a new HMAC secret to sign with instead of SecretKey.
+	NextSecret string
+	// MigrationCutoff is when tokens signed with SecretKey stop validating;
+	// zero rejects them as soon as a new key is configured.
+	MigrationCutoff time.Time
+}
+
+// PASETO holds the keys of the PASETO token formats.
+type PASETO struct {
+	// LocalKey is the hex-encoded 32-byte key of v4.local tokens.
+	LocalKey string
+	// SigningKeyFile is a PEM Ed25519 private key signing v4.public tokens.
+	SigningKeyFile string
+}
+
+// ReferenceTokens configures opaque reference access tokens and the
+// introspection endpoint that resolves them.
+type ReferenceTokens struct {
+	// Clients lists client IDs that receive reference tokens instead of JWTs.
+	Clients []string
+	// IntrospectionKey authorizes Introspect calls; empty disables them.
+	IntrospectionKey string
+	// CacheTTL is how long introspection results may be cached by callers.
+	CacheTTL time.Duration
+}
+
+// ValidationCache configures the in-process cache of validated access tokens.
+type ValidationCache struct {
+	// Size is the maximum number of cached tokens; 0 disables the cache.
+	Size int
+	// TTL bounds how long a validation result is reused.
+	TTL time.Duration
+}
+
+// Hashing bounds concurrent password hashing.
+type Hashing struct {
+	MaxParallel  int
+	QueueDepth   int
+	QueueTimeout time.Duration
+}
+
+// Passwords configures the policy new passwords must satisfy.
+type Passwords struct {
+	MinLength int
+	// MaxLength is in bytes; bcrypt ignores anything past 72.
+	MaxLength int
+	// MinClasses of lower case, upper case, digits and symbols, 0 to 4.
+	MinClasses int
+	// BannedFile lists banned passwords, one per line, on top of a built-in
+	// list of the most common ones.
+	BannedFile string
+	// MinScore is the minimum estimated strength, 0 (off) to 4.
+	MinScore int
+	// Algorithm hashes new passwords: "bcrypt" (default) or "argon2id".
+	// Hashes of other algorithms are replaced on the next login.
+	Algorithm string
+	// BcryptCost is the bcrypt work factor, 4 to 31. Hashes of another cost
+	// are replaced on the next login.
+	BcryptCost int
+	// Pepper keys an HMAC applied to passwords before hashing; hashes made
+	// without it are replaced on the next login. Empty disables it.
+	Pepper string
+	// History is how many previous passwords, besides the current one, a
+	// new password must differ from; 0 disables the check.
+	History int
+}
+
+// Users configures account lifecycle.
+type Users struct {
+	// PurgeAfter is how long soft-deleted users are kept before they are
+	// removed for good; 0 keeps them.
+	PurgeAfter time.Duration
+	// LoginAttemptRetention is how long failed logins are kept for
+	// auditing; 0 keeps them.
+	LoginAttemptRetention time.Duration
+	// RequireApproval keeps new registrations pending until an admin
+	// approves them.
+	RequireApproval bool
+	// RequireInvite makes registration require an invite code.
+	RequireInvite bool
+	// UsernameCooldown is the minimum time between username changes;
+	// UsernameGrace is how long a previous username stays reserved.
+	UsernameCooldown time.Duration
+	UsernameGrace    time.Duration
+}
+
+// MFA configures two-factor authentication.
+type MFA struct {
+	// Issuer names the service in authenticator apps.
+	Issuer string
+}
+
+// LoginLinks configures passwordless login with one-time links sent by
+// email.
+type LoginLinks struct {
+	// Enabled turns passwordless login on.
+	Enabled bool
+	// URL is the page the mailed link opens, with the token in the "token"
+	// query parameter; empty mails the bare token.
+	URL string
+	// TTL is how long a link is valid.
+	TTL time.Duration
+	// Requests allowed per login and Window; 0 disables the limit.
+	Requests int
+	Window   time.Duration
+}
+
+// PasswordResets configures the reset tokens mailed to users who forgot
+// their password.
+type PasswordResets struct {
+	// URL is the page the mailed link opens, with the token in the "token"
+	// query parameter; empty mails the bare token.
+	URL string
+	// TTL is how long a reset token is valid.
+	TTL time.Duration
+	// Requests allowed per login and Window; 0 disables the limit.
+	Requests int
+	Window   time.Duration
+}
+
+// Federation configures the OAuth clients of the external identity
+// providers users may log in with. A provider is enabled by its client ID.
+type Federation struct {
+	GoogleClientID     string
+	GoogleClientSecret string
+	GitHubClientID     string
+	GitHubClientSecret string
+	// LinkByEmail links the first federated login to the account with the
+	// same email when the provider verified it.
+	LinkByEmail bool
+	// OIDC are the generic OpenID Connect providers, such as enterprise
+	// identity providers.
+	OIDC []OIDCProvider
+	// SAMLBaseURL is the public URL of the HTTP gateway, which the entity
+	// IDs and ACS URLs of SAML connections are built from.
+	SAMLBaseURL string
+	// SAML are the SAML 2.0 identity providers.
+	SAML []SAMLProvider
+}
+
+// OIDCProvider configures an OpenID Connect provider, read from the
+// OIDC_<NAME>_* variables of a name in OIDC_PROVIDERS.
+type OIDCProvider struct {
+	// Name is the provider in login requests, e.g. "okta".
+	Name string
+	// DiscoveryURL is the issuer URL or its discovery document.
+	DiscoveryURL string
+	ClientID     string
+	ClientSecret string
+	// Claims maps user fields (username, email, email_verified, first_name,
+	// last_name, display_name) to the claims they are read from.
+	Claims map[string]string
+	// TrustEmail treats the provider's emails as verified.
+	TrustEmail bool
+}
+
+// oidcClaimFields are the user fields OIDC claims may be mapped to.
+var oidcClaimFields = []string{"username", "email", "email_verified", "first_name", "last_name", "display_name"}
+
+// SAMLProvider configures a SAML identity provider, read from the
+// SAML_<NAME>_* variables of a name in SAML_PROVIDERS.
+type SAMLProvider struct {
+	// Name is the provider in login requests and SAML URLs.
+	Name string
+	// IdPMetadataFile holds the metadata of the identity provider.
+	IdPMetadataFile string
+	// EntityID is the service provider's; it defaults to the URL of its
+	// metadata.
+	EntityID string
+	// ReturnURL receives the browser after the ACS, with a one-time code.
+	ReturnURL string
+	// Attributes maps user fields (username, email, first_name, last_name,
+	// display_name) to the attributes they are read from, first match
+	// first.
+	Attributes map[string][]string
+	// TrustEmail treats the IdP's emails as verified.
+	TrustEmail bool
+	// AllowIdPInitiated accepts logins started at the IdP.
+	AllowIdPInitiated bool
+}
+
+// LDAPDirectory configures an LDAP or Active Directory server that
+// password logins through some clients are checked against, read from the
+// LDAP_<NAME>_* variables of a name in LDAP_DIRECTORIES.
+type LDAPDirectory struct {
+	// Name is the provider of the identities of directory users.
+	Name string
+	// URL is ldap://host[:port] or ldaps://host[:port].
+	URL      string
+	StartTLS bool
+	// CAFile verifies the server instead of the system roots.
+	CAFile       string
+	BindDN       string
+	BindPassword string
+	BaseDN       string
+	// UserFilter finds a user's entry, with "{login}" for the login.
+	UserFilter string
+	// ActiveDirectory selects the attribute defaults of Active Directory.
+	ActiveDirectory bool
+	// Attributes maps identity fields (subject, username, email,
+	// first_name, last_name, display_name) to entry attributes.
+	Attributes map[string]string
+	TrustEmail bool
+	Timeout    time.Duration
+	// Clients are the IDs of the clients whose logins use the directory;
+	// "*" selects it for the logins of all other clients and without one.
+	Clients []string
+}
+
+// ldapAttributeFields are the identity fields LDAP attributes may be
+// mapped to.
+var ldapAttributeFields = []string{"subject", "username", "email", "first_name", "last_name", "display_name"}
+
+// samlAttributeFields are the user fields SAML attributes may be mapped to.
+var samlAttributeFields = []string{"username", "email", "first_name", "last_name", "display_name"}
+
+// TLS configures transport security of the gRPC listener.
+type TLS struct {
+	// CertFile and KeyFile enable TLS when both are set.
+	CertFile string
+	KeyFile  string
+	// ClientCAFile enables mTLS: clients must present a certificate signed by this CA.
+	ClientCAFile string
+	// BindRefreshTokens binds issued refresh tokens to the thumbprint of the
+	// presenting client certificate. Only meaningful with mTLS.
+	BindRefreshTokens bool
+}
+
+// Enabled reports whether the listener should serve TLS.
+func (t TLS) Enabled() bool {
+	return t.CertFile != "" && t.KeyFile != ""
+}
+
+// MutualTLS reports whether client certificates are required.
+func (t TLS) MutualTLS() bool {
+	return t.Enabled() && t.ClientCAFile != ""
+}
+
+// Load reads the configuration from the environment and validates it.
+func Load() (*Config, error) {
+	cfg := &Config{
+		GRPCAddr:    os.Getenv("GRPC_ADDR"),
+		DBURL:       os.Getenv("DB_URL"),
+		SecretKey:   os.Getenv("SECRET_KEY"),
+		CryptoMode:  os.Getenv("CRYPTO_MODE"),
+		AdminAPIKey: os.Getenv("ADMIN_API_KEY"),
+
+		SecurityAlertWebhook: os.Getenv("SECURITY_ALERT_WEBHOOK"),
+		JWTBearerAudience:    os.Getenv("JWT_BEARER_AUDIENCE"),
+		TokenFormat:          os.Getenv("TOKEN_FORMAT"),
+		DeviceBinding:        os.Getenv("REFRESH_DEVICE_BINDING"),
+		TLS: TLS{
+			CertFile:     os.Getenv("TLS_CERT_FILE"),
+			KeyFile:      os.Getenv("TLS_KEY_FILE"),
+			ClientCAFile: os.Getenv("TLS_CLIENT_CA_FILE"),
+		},
+		HTTP: HTTP{
+			Addr:        os.Getenv("HTTP_ADDR"),
+			MetricsAddr: os.Getenv("METRICS_ADDR"),
+			CORS:        CORS{AllowedOrigins: getList("CORS_ALLOWED_ORIGINS")},
+		},
+		Mail: Mail{
+			SMTPAddr:     os.Getenv("SMTP_ADDR"),
+			From:         os.Getenv("MAIL_FROM"),
+			SMTPUsername: os.Getenv("SMTP_USERNAME"),
+			SMTPPassword: os.Getenv("SMTP_PASSWORD"),
+		},
+		ScopedTokens: ScopedTokens{
+			OneTimeScopes:  getList("ONE_TIME_TOKEN_SCOPES"),
+			OneTimeClients: getList("ONE_TIME_TOKEN_CLIENTS"),
+		},
+		Signing: Signing{
+			KeyFile:          os.Getenv("SIGNING_KEY_FILE"),
+			Algorithm:        os.Getenv("SIGNING_ALGORITHM"),
+			PreviousKeyFiles: getList("PREVIOUS_SIGNING_KEY_FILES"),
+			PreviousSecrets:  getList("PREVIOUS_SECRET_KEYS"),
+			NextSecret:       os.Getenv("NEXT_SECRET_KEY"),
+		},
+		Tokens: Tokens{
+			Store:         os.Getenv("REFRESH_TOKEN_STORE"),
+			RefreshPepper: os.Getenv("REFRESH_TOKEN_PEPPER"),
+			Issuer:        strings.TrimSuffix(os.Getenv("TOKEN_ISSUER"), "/"),
+		},
+		Redis: Redis{
+			Addrs:      getList("REDIS_ADDR"),
+			MasterName: os.Getenv("REDIS_MASTER_NAME"),
+			Username:   os.Getenv("REDIS_USERNAME"),
+			Password:   os.Getenv("REDIS_PASSWORD"),
+			CAFile:     os.Getenv("REDIS_TLS_CA_FILE"),
+			CertFile:   os.Getenv("REDIS_TLS_CERT_FILE"),
+			KeyFile:    os.Getenv("REDIS_TLS_KEY_FILE"),
+			ServerName: os.Getenv("REDIS_TLS_SERVER_NAME"),
+		},
+		MFA: MFA{
+			Issuer: os.Getenv("MFA_ISSUER"),
+		},
+		LoginLinks: LoginLinks{
+			URL: os.Getenv("LOGIN_LINK_URL"),
+		},
+		PasswordResets: PasswordResets{
+			URL: os.Getenv("PASSWORD_RESET_URL"),
+		},
+		LoginBackoff: LoginBackoff{
+			UnlockURL: os.Getenv("ACCOUNT_UNLOCK_URL"),
+		},
+		Federation: Federation{
+			GoogleClientID:     os.Getenv("GOOGLE_CLIENT_ID"),
+			GoogleClientSecret: os.Getenv("GOOGLE_CLIENT_SECRET"),
+			GitHubClientID:     os.Getenv("GITHUB_CLIENT_ID"),
+			GitHubClientSecret: os.Getenv("GITHUB_CLIENT_SECRET"),
+			SAMLBaseURL:        strings.TrimSuffix(os.Getenv("SAML_BASE_URL"), "/"),
+		},
+		Risk: Risk{
+			NewDevice:        os.Getenv("RISK_NEW_DEVICE"),
+			ImpossibleTravel: os.Getenv("RISK_IMPOSSIBLE_TRAVEL"),
+		},
+		SMS: SMS{
+			Provider:           os.Getenv("SMS_PROVIDER"),
+			TwilioAccountSID:   os.Getenv("TWILIO_ACCOUNT_SID"),
+			TwilioAuthToken:    os.Getenv("TWILIO_AUTH_TOKEN"),
+			TwilioFrom:         os.Getenv("TWILIO_FROM"),
+			SNSRegion:          os.Getenv("SNS_REGION"),
+			AWSAccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+			AWSSecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		},
+		PASETO: PASETO{
+			LocalKey:       os.Getenv("PASETO_LOCAL_KEY"),
+			SigningKeyFile: os.Getenv("PASETO_SIGNING_KEY_FILE"),
+		},
+		ReferenceTokens: ReferenceTokens{
+			Clients:          getList("REFERENCE_TOKEN_CLIENTS"),
+			IntrospectionKey: os.Getenv("INTROSPECTION_API_KEY"),
+		},
+	}
+
+	var err error
+	if len(cfg.Redis.Addrs) == 0 {
+		cfg.Redis.Addrs = []string{"localhost:6379"}
+	}
+	if cfg.Redis.Cluster, err = getBool("REDIS_CLUSTER", false); err != nil {
+		return nil, err
+	}
+	if cfg.Redis.DB, err = getInt("REDIS_DB", 0); err != nil {
+		return nil, err
+	}
+	if cfg.Redis.TLS, err = getBool("REDIS_TLS", false); err != nil {
+		return nil, err
+	}
+	if cfg.Redis.DegradedMode, err = getBool("REDIS_DEGRADED_MODE", false); err != nil {
+		return nil, err
+	}
+	if cfg.Redis.DegradedRetryAfter, err = getDuration("REDIS_DEGRADED_RETRY_AFTER", 5*time.Second); err != nil {
+		return nil, err
+	}
+	if cfg.TLS.BindRefreshTokens, err = getBool("REFRESH_CERT_BINDING", false); err != nil {
+		return nil, err
+	}
+	if cfg.HTTP.CORS.AllowCredentials, err = getBool("CORS_ALLOW_CREDENTIALS", false); err != nil {
+		return nil, err
+	}
+	if cfg.HTTP.CORS.MaxAge, err = getDuration("CORS_MAX_AGE", 10*time.Minute); err != nil {
+		return nil, err
+	}
+	if cfg.Hashing.MaxParallel, err = getInt("HASH_MAX_PARALLEL", runtime.NumCPU()); err != nil {
+		return nil, err
+	}
+	if cfg.Hashing.QueueDepth, err = getInt("HASH_QUEUE_DEPTH", 64); err != nil {
+		return nil, err
+	}
+	if cfg.Hashing.QueueTimeout, err = getDuration("HASH_QUEUE_TIMEOUT", 2*time.Second); err != nil {
+		return nil, err
+	}
+	cfg.Passwords.BannedFile = os.Getenv("PASSWORD_BANNED_FILE")
+	cfg.Passwords.Algorithm = os.Getenv("PASSWORD_HASH_ALGORITHM")
+	cfg.Passwords.Pepper = os.Getenv("PASSWORD_PEPPER")
+	if cfg.Passwords.BcryptCost, err = getInt("BCRYPT_COST", 12); err != nil {
+		return nil, err
+	}
+	if cfg.Passwords.MinLength, err = getInt("PASSWORD_MIN_LENGTH", 8); err != nil {
+		return nil, err
+	}
+	if cfg.Passwords.MaxLength, err = getInt("PASSWORD_MAX_LENGTH", 72); err != nil {
+		return nil, err
+	}
+	if cfg.Passwords.MinClasses, err = getInt("PASSWORD_MIN_CLASSES", 0); err != nil {
+		return nil, err
+	}
+	if cfg.Passwords.MinScore, err = getInt("PASSWORD_MIN_SCORE", 0); err != nil {
+		return nil, err
+	}
+	if cfg.Passwords.History, err = getInt("PASSWORD_HISTORY", 5); err != nil {
+		return nil, err
+	}
+	if cfg.Users.PurgeAfter, err = getDuration("USER_PURGE_AFTER", 30*24*time.Hour); err != nil {
+		return nil, err
+	}
+	if cfg.Users.LoginAttemptRetention, err = getDuration("LOGIN_ATTEMPT_RETENTION", 90*24*time.Hour); err != nil {
+		return nil, err
+	}
+	if cfg.Users.RequireApproval, err = getBool("REGISTRATION_APPROVAL", false); err != nil {
+		return nil, err
+	}
+	if cfg.Users.RequireInvite, err = getBool("REGISTRATION_INVITE_REQUIRED", false); err != nil {
+		return nil, err
+	}
+	if cfg.Users.UsernameCooldown, err = getDuration("USERNAME_CHANGE_COOLDOWN", 30*24*time.Hour); err != nil {
+		return nil, err
+	}
+	if cfg.Users.UsernameGrace, err = getDuration("USERNAME_GRACE", 30*24*time.Hour); err != nil {
+		return nil, err
+	}
+	if cfg.ValidationCache.Size, err = getInt("VALIDATION_CACHE_SIZE", 10000); err != nil {
+		return nil, err
+	}
+	if cfg.ValidationCache.TTL, err = getDuration("VALIDATION_CACHE_TTL", 30*time.Second); err != nil {
+		return nil, err
+	}
+	if cfg.Tokens.AccessTTL, err = getDuration("ACCESS_TOKEN_TTL", 5*time.Minute); err != nil {
+		return nil, err
+	}
+	if cfg.Tokens.RefreshTTL, err = getDuration("REFRESH_TOKEN_TTL", 7*24*time.Hour); err != nil {
+		return nil, err
+	}
+	if cfg.Tokens.RememberMeTTL, err = getDuration("REMEMBER_ME_REFRESH_TTL", 30*24*time.Hour); err != nil {
+		return nil, err
+	}
+	if cfg.Tokens.MaxSessionLifetime, err = getDuration("SESSION_MAX_LIFETIME", 0); err != nil {
+		return nil, err
+	}
+	if cfg.Tokens.Leeway, err = getDuration("TOKEN_LEEWAY", 30*time.Second); err != nil {
+		return nil, err
+	}
+	if cfg.Tokens.RotationGrace, err = getDuration("REFRESH_ROTATION_GRACE", 0); err != nil {
+		return nil, err
+	}
+	if cfg.Tokens.VersionCheck, err = getBool("TOKEN_VERSION_CHECK", false); err != nil {
+		return nil, err
+	}
+	cfg.Tokens.MetadataClaims = getList("TOKEN_METADATA_CLAIMS")
+	if cfg.ScopedTokens.TTL, err = getDuration("SCOPED_TOKEN_TTL", time.Minute); err != nil {
+		return nil, err
+	}
+	if cfg.ReferenceTokens.CacheTTL, err = getDuration("INTROSPECTION_CACHE_TTL", 30*time.Second); err != nil {
+		return nil, err
+	}
+	if cfg.Signing.MigrationCutoff, err = getTime("SIGNING_MIGRATION_CUTOFF"); err != nil {
+		return nil, err
+	}
+	if cfg.RateLimit.Requests, err = getInt("RATE_LIMIT_REQUESTS", 0); err != nil {
+		return nil, err
+	}
+	if cfg.RateLimit.Window, err = getDuration("RATE_LIMIT_WINDOW", time.Minute); err != nil {
+		return nil, err
+	}
+	if cfg.RateLimit.MaxSessions, err = getInt("MAX_SESSIONS_PER_USER", 0); err != nil {
+		return nil, err
+	}
+	if cfg.LoginLinks.Enabled, err = getBool("LOGIN_LINK_ENABLED", false); err != nil {
+		return nil, err
+	}
+	if cfg.LoginLinks.TTL, err = getDuration("LOGIN_LINK_TTL", 15*time.Minute); err != nil {
+		return nil, err
+	}
+	if cfg.LoginLinks.Requests, err = getInt("LOGIN_LINK_RATE_LIMIT", 3); err != nil {
+		return nil, err
+	}
+	if cfg.LoginLinks.Window, err = getDuration("LOGIN_LINK_RATE_WINDOW", 15*time.Minute); err != nil {
+		return nil, err
+	}
+	if cfg.PasswordResets.TTL, err = getDuration("PASSWORD_RESET_TTL", 30*time.Minute); err != nil {
+		return nil, err
+	}
+	if cfg.PasswordResets.Requests, err = getInt("PASSWORD_RESET_RATE_LIMIT", 3); err != nil {
+		return nil, err
+	}
+	if cfg.PasswordResets.Window, err = getDuration("PASSWORD_RESET_RATE_WINDOW", time.Hour); err != nil {
+		return nil, err
+	}
+	if cfg.LoginBackoff.Threshold, err = getInt("LOGIN_BACKOFF_THRESHOLD", 3); err != nil {
+		return nil, err
+	}
+	if cfg.LoginBackoff.BaseDelay, err = getDuration("LOGIN_BACKOFF_BASE", 500*time.Millisecond); err != nil {
+		return nil, err
+	}
+	if cfg.LoginBackoff.MaxDelay, err = getDuration("LOGIN_BACKOFF_MAX", 10*time.Second); err != nil {
+		return nil, err
+	}
+	if cfg.LoginBackoff.Window, err = getDuration("LOGIN_BACKOFF_WINDOW", 15*time.Minute); err != nil {
+		return nil, err
+	}
+	if cfg.LoginBackoff.LockoutThreshold, err = getInt("LOGIN_LOCKOUT_THRESHOLD", 10); err != nil {
+		return nil, err
+	}
+	if cfg.LoginBackoff.LockoutDuration, err = getDuration("LOGIN_LOCKOUT_DURATION", 30*time.Minute); err != nil {
+		return nil, err
+	}
+	if cfg.Federation.LinkByEmail, err = getBool("FEDERATION_LINK_BY_EMAIL", true); err != nil {
+		return nil, err
+	}
+	if cfg.Federation.OIDC, err = getOIDCProviders(); err != nil {
+		return nil, err
+	}
+	if cfg.Federation.SAML, err = getSAMLProviders(cfg.Federation.SAMLBaseURL, cfg.Federation.OIDC); err != nil {
+		return nil, err
+	}
+	if cfg.LDAP, err = getLDAPDirectories(cfg.Federation); err != nil {
+		return nil, err
+	}
+	if cfg.Risk.Enabled, err = getBool("RISK_ENABLED", false); err != nil {
+		return nil, err
+	}
+	if cfg.Risk.MaxTravelSpeed, err = getInt("RISK_MAX_TRAVEL_SPEED", 900); err != nil {
+		return nil, err
+	}
+	if cfg.Risk.History, err = getDuration("RISK_HISTORY", 90*24*time.Hour); err != nil {
+		return nil, err
+	}
+
+	cfg.HTTP.Issuer = cfg.Tokens.Issuer
+
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func (c *Config) validate() error {
+	if c.DBURL == "" {
+		return fmt.Errorf("DB_URL must be set")
+	}
+	if c.GRPCAddr == "" {
+		return fmt.Errorf("GRPC_ADDR must be set")
+	}
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		return fmt.Errorf("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	if c.TLS.ClientCAFile != "" && !c.TLS.Enabled() {
+		return fmt.Errorf("TLS_CLIENT_CA_FILE requires TLS_CERT_FILE and TLS_KEY_FILE")
+	}
+	if c.TLS.BindRefreshTokens && !c.TLS.MutualTLS() {
+		return fmt.Errorf("REFRESH_CERT_BINDING requires mTLS (TLS_CLIENT_CA_FILE)")
+	}
+	if c.AdminAPIKey != "" && len(c.AdminAPIKey) < 32 {
+		return fmt.Errorf("ADMIN_API_KEY must be at least 32 bytes")
+	}
+	if c.Tokens.AccessTTL < time.Second || c.Tokens.AccessTTL > 24*time.Hour {
+		return fmt.Errorf("ACCESS_TOKEN_TTL must be between 1s and 24h")
+	}
+	if c.Tokens.RefreshTTL <= c.Tokens.AccessTTL {
+		return fmt.Errorf("REFRESH_TOKEN_TTL must be longer than ACCESS_TOKEN_TTL")
+	}
+	if c.Tokens.RememberMeTTL < 0 || (c.Tokens.RememberMeTTL > 0 && c.Tokens.RememberMeTTL < c.Tokens.RefreshTTL) {
+		return fmt.Errorf("REMEMBER_ME_REFRESH_TTL must not be shorter than REFRESH_TOKEN_TTL")
+	}
+	if c.Tokens.MaxSessionLifetime > 0 && c.Tokens.MaxSessionLifetime < c.Tokens.RefreshTTL {
+		return fmt.Errorf("SESSION_MAX_LIFETIME must not be shorter than REFRESH_TOKEN_TTL")
+	}
+	if c.Tokens.Leeway < 0 || c.Tokens.Leeway > 5*time.Minute {
+		return fmt.Errorf("TOKEN_LEEWAY must be between 0 and 5m")
+	}
+	if c.Tokens.Issuer != "" {
+		u, err := url.Parse(c.Tokens.Issuer)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" || u.RawQuery != "" || u.Fragment != "" {
+			return fmt.Errorf("TOKEN_ISSUER must be an http(s) URL without query or fragment")
+		}
+	}
+	if c.HTTP.MetricsAddr != "" && c.HTTP.MetricsAddr == c.HTTP.Addr {
+		return fmt.Errorf("METRICS_ADDR must differ from HTTP_ADDR")
+	}
+	if c.Tokens.RotationGrace < 0 || c.Tokens.RotationGrace > 5*time.Minute {
+		return fmt.Errorf("REFRESH_ROTATION_GRACE must be between 0 and 5m")
+	}
+	if c.Redis.MasterName != "" && c.Redis.Cluster {
+		return fmt.Errorf("REDIS_MASTER_NAME and REDIS_CLUSTER are mutually exclusive")
+	}
+	if c.Redis.DB < 0 {
+		return fmt.Errorf("REDIS_DB must not be negative")
+	}
+	if c.Redis.DB != 0 && (c.Redis.Cluster || (c.Redis.MasterName == "" && len(c.Redis.Addrs) > 1)) {
+		return fmt.Errorf("REDIS_DB is not supported by Redis Cluster")
+	}
+	if c.Redis.Username != "" && c.Redis.Password == "" {
+		return fmt.Errorf("REDIS_USERNAME requires REDIS_PASSWORD")
+	}
+	if (c.Redis.CertFile == "") != (c.Redis.KeyFile == "") {
+		return fmt.Errorf("REDIS_TLS_CERT_FILE and REDIS_TLS_KEY_FILE must be set together")
+	}
+	if !c.Redis.TLS && (c.Redis.CAFile != "" || c.Redis.CertFile != "" || c.Redis.ServerName != "") {
+		return fmt.Errorf("REDIS_TLS_* settings require REDIS_TLS=true")
+	}
+	if c.Redis.DegradedRetryAfter <= 0 {
+		return fmt.Errorf("REDIS_DEGRADED_RETRY_AFTER must be positive")
+	}
+	switch c.Tokens.Store {
+	case "", "redis", "postgres":
+	default:
+		return fmt.Errorf("REFRESH_TOKEN_STORE must be redis or postgres")
+	}
+	switch c.DeviceBinding {
+	case "", "off", "warn", "enforce":
+	default:
+		return fmt.Errorf("REFRESH_DEVICE_BINDING must be off, warn or enforce")
+	}
+	switch c.SMS.Provider {
+	case "":
+	case "twilio":
+		if c.SMS.TwilioAccountSID == "" || c.SMS.TwilioAuthToken == "" || c.SMS.TwilioFrom == "" {
+			return fmt.Errorf("SMS_PROVIDER=twilio requires TWILIO_ACCOUNT_SID, TWILIO_AUTH_TOKEN and TWILIO_FROM")
+		}
+	case "sns":
+		if c.SMS.SNSRegion == "" || c.SMS.AWSAccessKeyID == "" || c.SMS.AWSSecretAccessKey == "" {
+			return fmt.Errorf("SMS_PROVIDER=sns requires SNS_REGION, AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+		}
+	default:
+		return fmt.Errorf("SMS_PROVIDER must be twilio or sns")
+	}
+	switch c.TokenFormat {
+	case "", "jwt", "opaque":
+	case "paseto-v4-local":
+		if key, err := hex.DecodeString(c.PASETO.LocalKey); err != nil || len(key) != 32 {
+			return fmt.Errorf("PASETO_LOCAL_KEY must be 32 hex-encoded bytes")
+		}
+		if c.CryptoMode == "fips" {
+			return fmt.Errorf("TOKEN_FORMAT=paseto-v4-local is not FIPS approved")
+		}
+	case "paseto-v4-public":
+		if c.PASETO.SigningKeyFile == "" {
+			return fmt.Errorf("TOKEN_FORMAT=paseto-v4-public requires PASETO_SIGNING_KEY_FILE")
+		}
+	default:
+		return fmt.Errorf("TOKEN_FORMAT must be jwt, opaque, paseto-v4-local or paseto-v4-public")
+	}
+	if c.Signing.KeyFile != "" && c.Signing.NextSecret != "" {
+		return fmt.Errorf("SIGNING_KEY_FILE and NEXT_SECRET_KEY are mutually exclusive")
+	}
+	switch c.Signing.Algorithm {
+	case "":
+	case "RS256", "ES256", "ES384", "EdDSA":
+		if c.Signing.KeyFile == "" {
+			return fmt.Errorf("SIGNING_ALGORITHM requires SIGNING_KEY_FILE")
+		}
+	default:
+		return fmt.Errorf("SIGNING_ALGORITHM must be RS256, ES256, ES384 or EdDSA")
+	}
+	for _, secret := range c.Signing.PreviousSecrets {
+		if len(secret) < 32 {
+			return fmt.Errorf("PREVIOUS_SECRET_KEYS must be at least 32 bytes each")
+		}
+	}
+	if c.Signing.NextSecret != "" && len(c.Signing.NextSecret) < 32 {
+		return fmt.Errorf("NEXT_SECRET_KEY must be at least 32 bytes")
+	}
+	if !c.Signing.MigrationCutoff.IsZero() && c.Signing.KeyFile == "" && c.Signing.NextSecret == "" {
+		return fmt.Errorf("SIGNING_MIGRATION_CUTOFF requires SIGNING_KEY_FILE or NEXT_SECRET_KEY")
+	}
+	if c.Tokens.RefreshPepper != "" {
+		if len(c.Tokens.RefreshPepper) < 32 {
+			return fmt.Errorf("REFRESH_TOKEN_PEPPER must be at least 32 bytes")
+		}
+		if c.Tokens.RefreshPepper == c.SecretKey {
+			return fmt.Errorf("REFRESH_TOKEN_PEPPER must differ from SECRET_KEY")
+		}
+	}
+	if c.ReferenceTokens.IntrospectionKey != "" && len(c.ReferenceTokens.IntrospectionKey) < 32 {
+		return fmt.Errorf("INTROSPECTION_API_KEY must be at least 32 bytes")
+	}
+	if len(c.ReferenceTokens.Clients) > 0 && c.ReferenceTokens.IntrospectionKey == "" {
+		return fmt.Errorf("REFERENCE_TOKEN_CLIENTS requires INTROSPECTION_API_KEY")
+	}
+	if c.HTTP.CORS.AllowCredentials && slices.Contains(c.HTTP.CORS.AllowedOrigins, "*") {
+		return fmt.Errorf("CORS_ALLOW_CREDENTIALS cannot be combined with CORS_ALLOWED_ORIGINS=*")
+	}
+	if c.SecurityAlertWebhook != "" {
+		u, err := url.Parse(c.SecurityAlertWebhook)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("SECURITY_ALERT_WEBHOOK must be an http(s) URL")
+		}
+	}
+	if c.LoginLinks.URL != "" {
+		u, err := url.Parse(c.LoginLinks.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("LOGIN_LINK_URL must be an http(s) URL")
+		}
+	}
+	if c.LoginLinks.TTL < time.Minute || c.LoginLinks.TTL > time.Hour {
+		return fmt.Errorf("LOGIN_LINK_TTL must be between 1m and 1h")
+	}
+	if c.LoginLinks.Requests < 0 || (c.LoginLinks.Requests > 0 && c.LoginLinks.Window <= 0) {
+		return fmt.Errorf("LOGIN_LINK_RATE_LIMIT must not be negative and LOGIN_LINK_RATE_WINDOW must be positive")
+	}
+	if c.PasswordResets.URL != "" {
+		u, err := url.Parse(c.PasswordResets.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("PASSWORD_RESET_URL must be an http(s) URL")
+		}
+	}
+	if c.PasswordResets.TTL < time.Minute || c.PasswordResets.TTL > 24*time.Hour {
+		return fmt.Errorf("PASSWORD_RESET_TTL must be between 1m and 24h")
+	}
+	if c.PasswordResets.Requests < 0 || (c.PasswordResets.Requests > 0 && c.PasswordResets.Window <= 0) {
+		return fmt.Errorf("PASSWORD_RESET_RATE_LIMIT must not be negative and PASSWORD_RESET_RATE_WINDOW must be positive")
+	}
+	if c.Federation.GoogleClientSecret != "" && c.Federation.GoogleClientID == "" {
+		return fmt.Errorf("GOOGLE_CLIENT_SECRET requires GOOGLE_CLIENT_ID")
+	}
+	if (c.Federation.GitHubClientID == "") != (c.Federation.GitHubClientSecret == "") {
+		return fmt.Errorf("GITHUB_CLIENT_ID and GITHUB_CLIENT_SECRET must be set together")
+	}
+	if c.Mail.SMTPAddr != "" && c.Mail.From == "" {
+		return fmt.Errorf("SMTP_ADDR requires MAIL_FROM")
+	}
+	if c.LoginBackoff.Threshold < 0 {
+		return fmt.Errorf("LOGIN_BACKOFF_THRESHOLD must not be negative")
+	}
+	if (c.LoginBackoff.MaxDelay > 0 || c.LoginBackoff.LockoutThreshold > 0) && c.LoginBackoff.Window == 0 {
+		return fmt.Errorf("LOGIN_BACKOFF_WINDOW must be positive")
+	}
+	if c.LoginBackoff.LockoutThreshold < 0 {
+		return fmt.Errorf("LOGIN_LOCKOUT_THRESHOLD must not be negative")
+	}
+	if c.LoginBackoff.LockoutThreshold > 0 && c.LoginBackoff.LockoutThreshold <= c.LoginBackoff.Threshold {
+		return fmt.Errorf("LOGIN_LOCKOUT_THRESHOLD must exceed LOGIN_BACKOFF_THRESHOLD")
+	}
+	if c.LoginBackoff.LockoutDuration < time.Minute || c.LoginBackoff.LockoutDuration > 24*time.Hour {
+		return fmt.Errorf("LOGIN_LOCKOUT_DURATION must be between 1m and 24h")
+	}
+	if c.LoginBackoff.UnlockURL != "" {
+		u, err := url.Parse(c.LoginBackoff.UnlockURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("ACCOUNT_UNLOCK_URL must be an http(s) URL")
+		}
+	}
+	for key, v := range map[string]string{"RISK_NEW_DEVICE": c.Risk.NewDevice, "RISK_IMPOSSIBLE_TRAVEL": c.Risk.ImpossibleTravel} {
+		if v != "" && v != "allow" && v != "mfa" && v != "deny" {
+			return fmt.Errorf("%s must be allow, mfa or deny", key)
+		}
+	}
+	if c.Risk.Enabled && (c.Risk.MaxTravelSpeed <= 0 || c.Risk.History <= 0) {
+		return fmt.Errorf("RISK_MAX_TRAVEL_SPEED and RISK_HISTORY must be positive")
+	}
+	if c.Hashing.MaxParallel < 1 {
+		return fmt.Errorf("HASH_MAX_PARALLEL must be positive")
+	}
+	if c.Hashing.QueueDepth < 0 {
+		return fmt.Errorf("HASH_QUEUE_DEPTH must not be negative")
+	}
+	if c.Passwords.MinLength < 0 {
+		return fmt.Errorf("PASSWORD_MIN_LENGTH must not be negative")
+	}
+	if c.Passwords.MaxLength < 1 || c.Passwords.MaxLength > 72 {
+		return fmt.Errorf("PASSWORD_MAX_LENGTH must be between 1 and 72")
+	}
+	if c.Passwords.MinLength > c.Passwords.MaxLength {
+		return fmt.Errorf("PASSWORD_MIN_LENGTH must not exceed PASSWORD_MAX_LENGTH")
+	}
+	if c.Passwords.MinClasses < 0 || c.Passwords.MinClasses > 4 {
+		return fmt.Errorf("PASSWORD_MIN_CLASSES must be between 0 and 4")
+	}
+	if c.Passwords.MinScore < 0 || c.Passwords.MinScore > 4 {
+		return fmt.Errorf("PASSWORD_MIN_SCORE must be between 0 and 4")
+	}
+	switch c.Passwords.Algorithm {
+	case "", "bcrypt", "argon2id":
+		if c.Passwords.Algorithm != "" && c.CryptoMode == "fips" {
+			return fmt.Errorf("PASSWORD_HASH_ALGORITHM must not be set with CRYPTO_MODE=fips, which uses PBKDF2")
+		}
+	default:
+		return fmt.Errorf("PASSWORD_HASH_ALGORITHM must be bcrypt or argon2id")
+	}
+	if c.Passwords.BcryptCost < 4 || c.Passwords.BcryptCost > 31 {
+		return fmt.Errorf("BCRYPT_COST must be between 4 and 31")
+	}
+	if c.Passwords.Pepper != "" {
+		if len(c.Passwords.Pepper) < 32 {
+			return fmt.Errorf("PASSWORD_PEPPER must be at least 32 bytes")
+		}
+		if c.Passwords.Pepper == c.SecretKey || c.Passwords.Pepper == c.Tokens.RefreshPepper {
+			return fmt.Errorf("PASSWORD_PEPPER must differ from SECRET_KEY and REFRESH_TOKEN_PEPPER")
+		}
+	}
+	if c.Passwords.History < 0 {
+		return fmt.Errorf("PASSWORD_HISTORY must not be negative")
+	}
+	if c.Users.PurgeAfter < 0 {
+		return fmt.Errorf("USER_PURGE_AFTER must not be negative")
+	}
+	if c.Users.LoginAttemptRetention < 0 {
+		return fmt.Errorf("LOGIN_ATTEMPT_RETENTION must not be negative")
+	}
+	if c.Users.UsernameCooldown < 0 || c.Users.UsernameGrace < 0 {
+		return fmt.Errorf("USERNAME_CHANGE_COOLDOWN and USERNAME_GRACE must not be negative")
+	}
+	if c.ValidationCache.Size < 0 {
+		return fmt.Errorf("VALIDATION_CACHE_SIZE must not be negative")
+	}
+	if c.RateLimit.Requests < 0 || c.RateLimit.MaxSessions < 0 {
+		return fmt.Errorf("RATE_LIMIT_REQUESTS and MAX_SESSIONS_PER_USER must not be negative")
+	}
+	if c.RateLimit.Requests > 0 && c.RateLimit.Window <= 0 {
+		return fmt.Errorf("RATE_LIMIT_WINDOW must be positive")
+	}
+	if c.ScopedTokens.TTL == 0 {
+		return fmt.Errorf("SCOPED_TOKEN_TTL must be positive")
+	}
+	return nil
+}
+
+// getOIDCProviders reads the providers named in OIDC_PROVIDERS.
+func getOIDCProviders() ([]OIDCProvider, error) {
+	var providers []OIDCProvider
+	for _, name := range getList("OIDC_PROVIDERS") {
+		name = strings.ToLower(name)
+		if !oidcProviderName.MatchString(name) || slices.Contains([]string{"google", "github"}, name) {
+			return nil, fmt.Errorf("OIDC_PROVIDERS: invalid provider name %q", name)
+		}
+		prefix := "OIDC_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+		p := OIDCProvider{
+			Name:         name,
+			DiscoveryURL: os.Getenv(prefix + "DISCOVERY_URL"),
+			ClientID:     os.Getenv(prefix + "CLIENT_ID"),
+			ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+			Claims:       map[string]string{},
+		}
+		if u, err := url.Parse(p.DiscoveryURL); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return nil, fmt.Errorf("%sDISCOVERY_URL must be an http(s) URL", prefix)
+		}
+		if p.ClientID == "" {
+			return nil, fmt.Errorf("%sCLIENT_ID is required", prefix)
+		}
+		for _, pair := range getList(prefix + "CLAIMS") {
+			field, claim, ok := strings.Cut(pair, "=")
+			field, claim = strings.TrimSpace(field), strings.TrimSpace(claim)
+			if !ok || claim == "" || !slices.Contains(oidcClaimFields, field) {
+				return nil, fmt.Errorf("%sCLAIMS: invalid mapping %q; want field=claim with a field of %s", prefix, pair, strings.Join(oidcClaimFields, ", "))
+			}
+			p.Claims[field] = claim
+		}
+		var err error
+		if p.TrustEmail, err = getBool(prefix+"TRUST_EMAIL", false); err != nil {
+			return nil, err
+		}
+		if slices.ContainsFunc(providers, func(o OIDCProvider) bool { return o.Name == name }) {
+			return nil, fmt.Errorf("OIDC_PROVIDERS: duplicate provider %q", name)
+		}
+		providers = append(providers, p)
+	}
+	return providers, nil
+}
+
+// getSAMLProviders reads the providers named in SAML_PROVIDERS; their
+// names must differ from those of the OIDC providers.
+func getSAMLProviders(baseURL string, oidc []OIDCProvider) ([]SAMLProvider, error) {
+	var providers []SAMLProvider
+	for _, name := range getList("SAML_PROVIDERS") {
+		name = strings.ToLower(name)
+		if !oidcProviderName.MatchString(name) || slices.Contains([]string{"google", "github"}, name) {
+			return nil, fmt.Errorf("SAML_PROVIDERS: invalid provider name %q", name)
+		}
+		if slices.ContainsFunc(oidc, func(o OIDCProvider) bool { return o.Name == name }) ||
+			slices.ContainsFunc(providers, func(p SAMLProvider) bool { return p.Name == name }) {
+			return nil, fmt.Errorf("SAML_PROVIDERS: duplicate provider %q", name)
+		}
+		if u, err := url.Parse(baseURL); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return nil, fmt.Errorf("SAML_PROVIDERS requires SAML_BASE_URL, the public http(s) URL of the gateway")
+		}
+		prefix := "SAML_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+		p := SAMLProvider{
+			Name:            name,
+			IdPMetadataFile: os.Getenv(prefix + "IDP_METADATA_FILE"),
+			EntityID:        os.Getenv(prefix + "ENTITY_ID"),
+			ReturnURL:       os.Getenv(prefix + "RETURN_URL"),
+			Attributes:      map[string][]string{},
+		}
+		if p.IdPMetadataFile == "" {
+			return nil, fmt.Errorf("%sIDP_METADATA_FILE is required", prefix)
+		}
+		if p.EntityID == "" {
+			p.EntityID = baseURL + "/v1/saml/" + name + "/metadata"
+		}
+		if u, err := url.Parse(p.ReturnURL); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return nil, fmt.Errorf("%sRETURN_URL must be an http(s) URL", prefix)
+		}
+		for _, pair := range getList(prefix + "ATTRIBUTES") {
+			field, attr, ok := strings.Cut(pair, "=")
+			field, attr = strings.TrimSpace(field), strings.TrimSpace(attr)
+			if !ok || attr == "" || !slices.Contains(samlAttributeFields, field) {
+				return nil, fmt.Errorf("%sATTRIBUTES: invalid mapping %q; want field=attribute with a field of %s", prefix, pair, strings.Join(samlAttributeFields, ", "))
+			}
+			p.Attributes[field] = append(p.Attributes[field], attr)
+		}
+		var err error
+		if p.TrustEmail, err = getBool(prefix+"TRUST_EMAIL", false); err != nil {
+			return nil, err
+		}
+		if p.AllowIdPInitiated, err = getBool(prefix+"ALLOW_IDP_INITIATED", false); err != nil {
+			return nil, err
+		}
+		providers = append(providers, p)
+	}
+	return providers, nil
+}
+
+// getLDAPDirectories reads the directories named in LDAP_DIRECTORIES; their
+// names must differ from those of the federation providers, and each client
+// may use one directory.
+func getLDAPDirectories(fed Federation) ([]LDAPDirectory, error) {
+	var dirs []LDAPDirectory
+	clients := map[string]string{}
+	for _, name := range getList("LDAP_DIRECTORIES") {
+		name = strings.ToLower(name)
+		if !oidcProviderName.MatchString(name) || slices.Contains([]string{"google", "github"}, name) {
+			return nil, fmt.Errorf("LDAP_DIRECTORIES: invalid directory name %q", name)
+		}
+		if slices.ContainsFunc(fed.OIDC, func(o OIDCProvider) bool { return o.Name == name }) ||
+			slices.ContainsFunc(fed.SAML, func(p SAMLProvider) bool { return p.Name == name }) ||
+			slices.ContainsFunc(dirs, func(d LDAPDirectory) bool { return d.Name == name }) {
+			return nil, fmt.Errorf("LDAP_DIRECTORIES: duplicate name %q", name)
+		}
+		prefix := "LDAP_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+		d := LDAPDirectory{
+			Name:         name,
+			URL:          os.Getenv(prefix + "URL"),
+			CAFile:       os.Getenv(prefix + "CA_FILE"),
+			BindDN:       os.Getenv(prefix + "BIND_DN"),
+			BindPassword: os.Getenv(prefix + "BIND_PASSWORD"),
+			BaseDN:       os.Getenv(prefix + "BASE_DN"),
+			UserFilter:   os.Getenv(prefix + "USER_FILTER"),
+			Attributes:   map[string]string{},
+			Clients:      getList(prefix + "CLIENTS"),
+		}
+		if u, err := url.Parse(d.URL); err != nil || (u.Scheme != "ldap" && u.Scheme != "ldaps") || u.Host == "" {
+			return nil, fmt.Errorf("%sURL must be an ldap:// or ldaps:// URL", prefix)
+		}
+		if d.BaseDN == "" {
+			return nil, fmt.Errorf("%sBASE_DN is required", prefix)
+		}
+		if (d.BindDN == "") != (d.BindPassword == "") {
+			return nil, fmt.Errorf("%sBIND_DN and %sBIND_PASSWORD must be set together", prefix, prefix)
+		}
+		var err error
+		if d.StartTLS, err = getBool(prefix+"START_TLS", false); err != nil {
+			return nil, err
+		}
+		if d.ActiveDirectory, err = getBool(prefix+"ACTIVE_DIRECTORY", false); err != nil {
+			return nil, err
+		}
+		if d.UserFilter == "" {
+			d.UserFilter = "(uid={login})"
+			if d.ActiveDirectory {
+				d.UserFilter = "(sAMAccountName={login})"
+			}
+		}
+		for _, pair := range getList(prefix + "ATTRIBUTES") {
+			field, attr, ok := strings.Cut(pair, "=")
+			field, attr = strings.TrimSpace(field), strings.TrimSpace(attr)
+			if !ok || attr == "" || !slices.Contains(ldapAttributeFields, field) {
+				return nil, fmt.Errorf("%sATTRIBUTES: invalid mapping %q; want field=attribute with a field of %s", prefix, pair, strings.Join(ldapAttributeFields, ", "))
+			}
+			d.Attributes[field] = attr
+		}
+		if d.TrustEmail, err = getBool(prefix+"TRUST_EMAIL", false); err != nil {
+			return nil, err
+		}
+		if d.Timeout, err = getDuration(prefix+"TIMEOUT", 10*time.Second); err != nil {
+			return nil, err
+		}
+		if len(d.Clients) == 0 {
+			return nil, fmt.Errorf("%sCLIENTS is required: the client IDs whose logins use the directory, or *", prefix)
+		}
+		for _, c := range d.Clients {
+			if other, ok := clients[c]; ok {
+				return nil, fmt.Errorf("%sCLIENTS: client %q already uses directory %s", prefix, c, other)
+			}
+			clients[c] = name
+		}
+		dirs = append(dirs, d)
+	}
+	return dirs, nil
+}
+
+// oidcProviderName is the syntax of OIDC provider names, which appear in
+// login URLs and environment variable names.
+var oidcProviderName = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,31}$`)
+
+// getList splits a comma-separated variable, dropping empty items.
+func getList(key string) []string {
+	var out []string
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+func getBool(key string, def bool) (bool, error) {
+	v := strings.TrimSpace(os.Getenv(key))
+	if v == "" {
+		return def, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("%s: invalid boolean %q", key, v)
+	}
+	return b, nil
+}
+
+func getInt(key string, def int) (int, error) {
+	v := strings.TrimSpace(os.Getenv(key))
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("%s: invalid integer %q", key, v)
+	}
+	return n, nil
+}
+
+func getDuration(key string, def time.Duration) (time.Duration, error) {
+	v := strings.TrimSpace(os.Getenv(key))
+	if v == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("%s: invalid duration %q", key, v)
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("%s: duration must not be negative", key)
+	}
+	return d, nil
+}
+
+// getTime parses an RFC 3339 timestamp; unset yields the zero time.
+func getTime(key string) (time.Time, error) {
+	v := strings.TrimSpace(os.Getenv(key))
+	if v == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s: invalid RFC 3339 time %q", key, v)
+	}
+	return t, nil
+}
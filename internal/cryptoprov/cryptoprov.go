@@ -0,0 +1,196 @@
+// Package cryptoprov selects the cryptography used by the services: random
+// numbers, password hashing and access token signing. The standard provider
+// hashes with bcrypt or Argon2id; the FIPS provider restricts itself to FIPS 140-3 approved
+// algorithms and requires Go's FIPS module to be active.
+package cryptoprov
+
+import (
+	"crypto/fips140"
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Modes accepted by New.
+const (
+	ModeStandard = "standard"
+	ModeFIPS     = "fips"
+)
+
+var (
+	// ErrMismatch is returned when a password does not match its hash.
+	ErrMismatch = errors.New("cryptoprov: password does not match")
+	// ErrUnsupportedHash is returned for hashes the provider may not verify,
+	// e.g. bcrypt hashes in FIPS mode.
+	ErrUnsupportedHash = errors.New("cryptoprov: unsupported password hash")
+)
+
+// Provider is the source of all cryptography used by the services.
+type Provider interface {
+	Name() string
+	// FIPS reports whether only approved algorithms are used.
+	FIPS() bool
+	// Rand is the random source for identifiers, secrets and salts.
+	Rand() io.Reader
+	HashPassword(password string) (string, error)
+	// VerifyPassword returns ErrMismatch or ErrUnsupportedHash on failure.
+	VerifyPassword(hash, password string) error
+	// NeedsRehash reports whether a verified hash should be replaced with
+	// one from HashPassword, because its algorithm or cost is outdated.
+	NeedsRehash(hash string) bool
+	// SigningMethod signs access tokens.
+	SigningMethod() jwt.SigningMethod
+}
+
+// Passwords configures password hashing.
+type Passwords struct {
+	// Algorithm hashes new passwords in standard mode ("" means bcrypt);
+	// FIPS mode always uses PBKDF2.
+	Algorithm string
+	// BcryptCost is the bcrypt work factor; 0 means 12.
+	BcryptCost int
+	// Pepper, when set, is applied to passwords with HMAC-SHA256 before
+	// hashing.
+	Pepper []byte
+}
+
+// New returns the provider for mode ("" means standard).
+func New(mode string, pw Passwords) (Provider, error) {
+	switch mode {
+	case "", ModeStandard:
+		hasher, err := NewHasher(pw.Algorithm, pw.BcryptCost)
+		if err != nil {
+			return nil, err
+		}
+		return standardProvider{hasher: hasher, pepper: pw.Pepper}, nil
+	case ModeFIPS:
+		if !fips140.Enabled() {
+			return nil, fmt.Errorf("cryptoprov: FIPS mode requires the Go FIPS 140-3 module (GODEBUG=fips140=on or a GOFIPS140 build)")
+		}
+		if pw.Algorithm != "" {
+			return nil, fmt.Errorf("cryptoprov: FIPS mode hashes passwords with PBKDF2 only")
+		}
+		return fipsProvider{pepper: pw.Pepper}, nil
+	default:
+		return nil, fmt.Errorf("cryptoprov: unknown mode %q", mode)
+	}
+}
+
+// Standard returns the default provider: bcrypt passwords, HS256 tokens.
+func Standard() Provider {
+	return standardProvider{hasher: bcryptHasher{cost: defaultBcryptCost}}
+}
+
+type standardProvider struct {
+	hasher Hasher
+	pepper pepper
+}
+
+func (standardProvider) Name() string                     { return ModeStandard }
+func (standardProvider) FIPS() bool                       { return false }
+func (standardProvider) Rand() io.Reader                  { return rand.Reader }
+func (standardProvider) SigningMethod() jwt.SigningMethod { return jwt.SigningMethodHS256 }
+
+func (p standardProvider) HashPassword(password string) (string, error) {
+	return p.pepper.hash(p.hasher, password)
+}
+
+// VerifyPassword accepts hashes of every algorithm, including PBKDF2 so a
+// deployment can leave FIPS mode without resetting passwords.
+func (p standardProvider) VerifyPassword(hash, password string) error {
+	return p.pepper.verify(hash, password, verifyPassword)
+}
+
+func (p standardProvider) NeedsRehash(hash string) bool {
+	return !p.pepper.current(hash, p.hasher.Current)
+}
+
+type fipsProvider struct {
+	pepper pepper
+}
+
+func (fipsProvider) Name() string                     { return ModeFIPS }
+func (fipsProvider) FIPS() bool                       { return true }
+func (fipsProvider) Rand() io.Reader                  { return rand.Reader }
+func (fipsProvider) SigningMethod() jwt.SigningMethod { return jwt.SigningMethodHS256 }
+
+func (p fipsProvider) HashPassword(password string) (string, error) {
+	return p.pepper.hash(pbkdf2Hasher{}, password)
+}
+
+// NeedsRehash only upgrades PBKDF2 hashes; others cannot be verified in
+// FIPS mode in the first place.
+func (p fipsProvider) NeedsRehash(hash string) bool {
+	inner := strings.TrimPrefix(hash, pepperPrefix)
+	return strings.HasPrefix(inner, pbkdf2Prefix) && !p.pepper.current(hash, pbkdf2Hasher{}.Current)
+}
+
+// VerifyPassword only accepts PBKDF2 hashes: bcrypt is not an approved
+// algorithm, so accounts hashed before FIPS mode need a password reset.
+func (p fipsProvider) VerifyPassword(hash, password string) error {
+	return p.pepper.verify(hash, password, func(hash, password string) error {
+		if !strings.HasPrefix(hash, pbkdf2Prefix) {
+			return ErrUnsupportedHash
+		}
+		return verifyPBKDF2(hash, password)
+	})
+}
+
+// PBKDF2-HMAC-SHA256 parameters (NIST SP 800-132, OWASP 2023 iteration count).
+const (
+	pbkdf2Prefix     = "$pbkdf2-sha256$"
+	pbkdf2Iterations = 600_000
+	pbkdf2SaltLen    = 16
+	pbkdf2KeyLen     = 32
+)
+
+var b64 = base64.RawStdEncoding
+
+// hashPBKDF2 encodes as $pbkdf2-sha256$<iterations>$<salt>$<key>.
+func hashPBKDF2(password string) (string, error) {
+	salt := make([]byte, pbkdf2SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key, err := pbkdf2.Key(sha256.New, password, salt, pbkdf2Iterations, pbkdf2KeyLen)
+	if err != nil {
+		return "", err
+	}
+	return pbkdf2Prefix + strconv.Itoa(pbkdf2Iterations) + "$" + b64.EncodeToString(salt) + "$" + b64.EncodeToString(key), nil
+}
+
+func verifyPBKDF2(encoded, password string) error {
+	parts := strings.Split(strings.TrimPrefix(encoded, pbkdf2Prefix), "$")
+	if len(parts) != 3 {
+		return ErrUnsupportedHash
+	}
+	iter, err := strconv.Atoi(parts[0])
+	if err != nil || iter < 1 {
+		return ErrUnsupportedHash
+	}
+	salt, err := b64.DecodeString(parts[1])
+	if err != nil {
+		return ErrUnsupportedHash
+	}
+	want, err := b64.DecodeString(parts[2])
+	if err != nil || len(want) == 0 {
+		return ErrUnsupportedHash
+	}
+	got, err := pbkdf2.Key(sha256.New, password, salt, iter, len(want))
+	if err != nil {
+		return ErrUnsupportedHash
+	}
+	if !hmac.Equal(got, want) {
+		return ErrMismatch
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package cryptoprov
+
+import (
+	"crypto/fips140"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestStandardPasswords(t *testing.T) {
+	p := Standard()
+	hash, err := p.HashPassword("correct horse")
+	if err != nil {
+		t.Fatalf("HashPassword failed: %v", err)
+	}
+	if !strings.HasPrefix(hash, "$2") {
+		t.Fatalf("expected a bcrypt hash, got %q", hash)
+	}
+	if err := p.VerifyPassword(hash, "correct horse"); err != nil {
+		t.Fatalf("VerifyPassword failed: %v", err)
+	}
+	if err := p.VerifyPassword(hash, "wrong"); !errors.Is(err, ErrMismatch) {
+		t.Fatalf("expected ErrMismatch, got %v", err)
+	}
+}
+
+func TestFIPSPasswords(t *testing.T) {
+	p := fipsProvider{}
+	hash, err := p.HashPassword("correct horse battery")
+	if err != nil {
+		t.Fatalf("HashPassword failed: %v", err)
+	}
+	if !strings.HasPrefix(hash, pbkdf2Prefix) {
+		t.Fatalf("expected a PBKDF2 hash, got %q", hash)
+	}
+	if err := p.VerifyPassword(hash, "correct horse battery"); err != nil {
+		t.Fatalf("VerifyPassword failed: %v", err)
+	}
+	if err := p.VerifyPassword(hash, "wrong"); !errors.Is(err, ErrMismatch) {
+		t.Fatalf("expected ErrMismatch, got %v", err)
+	}
+
+	// leaving FIPS mode keeps PBKDF2 hashes usable, but not the other way round
+	if err := Standard().VerifyPassword(hash, "correct horse battery"); err != nil {
+		t.Fatalf("standard provider should verify PBKDF2 hashes: %v", err)
+	}
+	bcryptHash, _ := Standard().HashPassword("correct horse battery")
+	if err := p.VerifyPassword(bcryptHash, "correct horse battery"); !errors.Is(err, ErrUnsupportedHash) {
+		t.Fatalf("expected ErrUnsupportedHash for bcrypt in FIPS mode, got %v", err)
+	}
+}
+
+func TestNew(t *testing.T) {
+	if p, err := New("", Passwords{}); err != nil || p.Name() != ModeStandard {
+		t.Fatalf("expected standard provider by default, got %v, %v", p, err)
+	}
+	if _, err := New("rot13", Passwords{}); err == nil {
+		t.Fatal("expected an unknown mode to be rejected")
+	}
+	_, err := New(ModeFIPS, Passwords{})
+	if fips140.Enabled() != (err == nil) {
+		t.Fatalf("FIPS mode must be available exactly when the FIPS module is enabled, got %v", err)
+	}
+}
+
+func TestArgon2idPasswords(t *testing.T) {
+	p, err := New(ModeStandard, Passwords{Algorithm: AlgorithmArgon2id})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	hash, err := p.HashPassword("correct horse")
+	if err != nil {
+		t.Fatalf("HashPassword failed: %v", err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=19456,t=2,p=1$") {
+		t.Fatalf("expected an Argon2id hash, got %q", hash)
+	}
+	if err := p.VerifyPassword(hash, "correct horse"); err != nil {
+		t.Fatalf("VerifyPassword failed: %v", err)
+	}
+	if err := p.VerifyPassword(hash, "wrong"); !errors.Is(err, ErrMismatch) {
+		t.Fatalf("expected ErrMismatch, got %v", err)
+	}
+	if p.NeedsRehash(hash) {
+		t.Fatal("a hash of the configured algorithm must not need a rehash")
+	}
+
+	// switching algorithms keeps old hashes usable until they are replaced
+	bcryptHash, _ := Standard().HashPassword("correct horse")
+	if err := p.VerifyPassword(bcryptHash, "correct horse"); err != nil {
+		t.Fatalf("argon2id provider should verify bcrypt hashes: %v", err)
+	}
+	if !p.NeedsRehash(bcryptHash) {
+		t.Fatal("expected a bcrypt hash to need a rehash to Argon2id")
+	}
+	if err := Standard().VerifyPassword(hash, "correct horse"); err != nil {
+		t.Fatalf("bcrypt provider should verify Argon2id hashes: %v", err)
+	}
+	if !Standard().NeedsRehash(hash) || Standard().NeedsRehash(bcryptHash) {
+		t.Fatal("expected only the Argon2id hash to need a rehash to bcrypt")
+	}
+
+	if _, err := New(ModeStandard, Passwords{Algorithm: "md5"}); err == nil {
+		t.Fatal("expected an unknown algorithm to be rejected")
+	}
+}
+
+func TestPepperAndCost(t *testing.T) {
+	plain, err := New(ModeStandard, Passwords{BcryptCost: 4})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	peppered, err := New(ModeStandard, Passwords{BcryptCost: 4, Pepper: []byte("0123456789abcdef0123456789abcdef")})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	hash, err := peppered.HashPassword("correct horse")
+	if err != nil {
+		t.Fatalf("HashPassword failed: %v", err)
+	}
+	if !strings.HasPrefix(hash, pepperPrefix+"$2a$04$") {
+		t.Fatalf("expected a peppered bcrypt hash of cost 4, got %q", hash)
+	}
+	if err := peppered.VerifyPassword(hash, "correct horse"); err != nil {
+		t.Fatalf("VerifyPassword failed: %v", err)
+	}
+	if err := peppered.VerifyPassword(hash, "wrong"); !errors.Is(err, ErrMismatch) {
+		t.Fatalf("expected ErrMismatch, got %v", err)
+	}
+	if err := plain.VerifyPassword(hash, "correct horse"); !errors.Is(err, ErrUnsupportedHash) {
+		t.Fatalf("expected ErrUnsupportedHash without the pepper, got %v", err)
+	}
+	if peppered.NeedsRehash(hash) || !plain.NeedsRehash(hash) {
+		t.Fatal("expected a peppered hash to need a rehash only without the pepper")
+	}
+
+	// hashes from before the pepper keep working until the next login
+	old, _ := plain.HashPassword("correct horse")
+	if err := peppered.VerifyPassword(old, "correct horse"); err != nil {
+		t.Fatalf("VerifyPassword of an unpeppered hash failed: %v", err)
+	}
+	if !peppered.NeedsRehash(old) {
+		t.Fatal("expected an unpeppered hash to need a rehash")
+	}
+	if !Standard().NeedsRehash(old) {
+		t.Fatal("expected a hash of another bcrypt cost to need a rehash")
+	}
+
+	if _, err := New(ModeStandard, Passwords{BcryptCost: 40}); err == nil {
+		t.Fatal("expected an out of range bcrypt cost to be rejected")
+	}
+}
@@ -0,0 +1,178 @@
+package cryptoprov
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Password hashing algorithms accepted by NewHasher.
+const (
+	AlgorithmBcrypt   = "bcrypt"
+	AlgorithmArgon2id = "argon2id"
+)
+
+// Hasher hashes passwords with one algorithm and its parameters.
+type Hasher interface {
+	Algorithm() string
+	Hash(password string) (string, error)
+	// Current reports whether hash was made by this algorithm with the same
+	// parameters; other hashes should be replaced on the next login.
+	Current(hash string) bool
+}
+
+// NewHasher returns the hasher for algorithm ("" means bcrypt). bcryptCost
+// is the bcrypt work factor; 0 means 12.
+func NewHasher(algorithm string, bcryptCost int) (Hasher, error) {
+	switch algorithm {
+	case "", AlgorithmBcrypt:
+		if bcryptCost == 0 {
+			bcryptCost = defaultBcryptCost
+		}
+		if bcryptCost < bcrypt.MinCost || bcryptCost > bcrypt.MaxCost {
+			return nil, fmt.Errorf("cryptoprov: bcrypt cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+		}
+		return bcryptHasher{cost: bcryptCost}, nil
+	case AlgorithmArgon2id:
+		return argon2idHasher{defaultArgon2Params}, nil
+	default:
+		return nil, fmt.Errorf("cryptoprov: unknown password hashing algorithm %q", algorithm)
+	}
+}
+
+const defaultBcryptCost = 12
+
+type bcryptHasher struct {
+	cost int
+}
+
+func (bcryptHasher) Algorithm() string { return AlgorithmBcrypt }
+
+func (h bcryptHasher) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.cost)
+	return string(hash), err
+}
+
+func (h bcryptHasher) Current(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err == nil && cost == h.cost
+}
+
+func verifyBcrypt(hash, password string) error {
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
+		return ErrMismatch
+	default:
+		return ErrUnsupportedHash
+	}
+}
+
+// argon2Params are the Argon2id cost parameters.
+type argon2Params struct {
+	memory  uint32 // KiB
+	time    uint32
+	threads uint8
+}
+
+// defaultArgon2Params are the OWASP 2023 recommendation.
+var defaultArgon2Params = argon2Params{memory: 19 * 1024, time: 2, threads: 1}
+
+const (
+	argon2Prefix  = "$argon2id$"
+	argon2SaltLen = 16
+	argon2KeyLen  = 32
+)
+
+type argon2idHasher struct {
+	params argon2Params
+}
+
+func (argon2idHasher) Algorithm() string { return AlgorithmArgon2id }
+
+// Hash encodes in the PHC format of the reference implementation:
+// $argon2id$v=19$m=<memory>,t=<time>,p=<threads>$<salt>$<key>.
+func (h argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, argon2SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	p := h.params
+	key := argon2.IDKey([]byte(password), salt, p.time, p.memory, p.threads, argon2KeyLen)
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s", argon2Prefix, argon2.Version,
+		p.memory, p.time, p.threads, b64.EncodeToString(salt), b64.EncodeToString(key)), nil
+}
+
+func (h argon2idHasher) Current(hash string) bool {
+	p, _, _, err := decodeArgon2id(hash)
+	return err == nil && p == h.params
+}
+
+func verifyArgon2id(hash, password string) error {
+	p, salt, want, err := decodeArgon2id(hash)
+	if err != nil {
+		return err
+	}
+	got := argon2.IDKey([]byte(password), salt, p.time, p.memory, p.threads, uint32(len(want)))
+	if subtle.ConstantTimeCompare(got, want) != 1 {
+		return ErrMismatch
+	}
+	return nil
+}
+
+func decodeArgon2id(hash string) (argon2Params, []byte, []byte, error) {
+	var p argon2Params
+	parts := strings.Split(strings.TrimPrefix(hash, argon2Prefix), "$")
+	if !strings.HasPrefix(hash, argon2Prefix) || len(parts) != 4 {
+		return p, nil, nil, ErrUnsupportedHash
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[0], "v=%d", &version); err != nil || version != argon2.Version {
+		return p, nil, nil, ErrUnsupportedHash
+	}
+	if _, err := fmt.Sscanf(parts[1], "m=%d,t=%d,p=%d", &p.memory, &p.time, &p.threads); err != nil ||
+		p.time < 1 || p.threads < 1 {
+		return p, nil, nil, ErrUnsupportedHash
+	}
+	salt, err := b64.DecodeString(parts[2])
+	if err != nil {
+		return p, nil, nil, ErrUnsupportedHash
+	}
+	key, err := b64.DecodeString(parts[3])
+	if err != nil || len(key) == 0 {
+		return p, nil, nil, ErrUnsupportedHash
+	}
+	return p, salt, key, nil
+}
+
+// pbkdf2Hasher is the hasher of FIPS mode.
+type pbkdf2Hasher struct{}
+
+func (pbkdf2Hasher) Algorithm() string { return "pbkdf2-sha256" }
+
+func (pbkdf2Hasher) Hash(password string) (string, error) {
+	return hashPBKDF2(password)
+}
+
+func (pbkdf2Hasher) Current(hash string) bool {
+	return strings.HasPrefix(hash, fmt.Sprintf("%s%d$", pbkdf2Prefix, pbkdf2Iterations))
+}
+
+// verifyPassword checks password against a hash of any supported algorithm.
+func verifyPassword(hash, password string) error {
+	switch {
+	case strings.HasPrefix(hash, pbkdf2Prefix):
+		return verifyPBKDF2(hash, password)
+	case strings.HasPrefix(hash, argon2Prefix):
+		return verifyArgon2id(hash, password)
+	default:
+		return verifyBcrypt(hash, password)
+	}
+}
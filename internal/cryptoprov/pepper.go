@@ -0,0 +1,55 @@
+package cryptoprov
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"strings"
+)
+
+// pepperPrefix marks hashes of peppered passwords; the hash of the inner
+// algorithm follows it.
+const pepperPrefix = "$hmac-sha256$"
+
+// pepper is a secret key passwords are run through with HMAC-SHA256 before
+// hashing, so that a leaked users table alone does not allow offline
+// guessing. Hashes made before the pepper was set stay verifiable and are
+// replaced on the next login.
+type pepper []byte
+
+// apply returns the base64 HMAC of password, 44 bytes, within the 72 bcrypt
+// hashes.
+func (p pepper) apply(password string) string {
+	mac := hmac.New(sha256.New, p)
+	mac.Write([]byte(password))
+	return b64.EncodeToString(mac.Sum(nil))
+}
+
+func (p pepper) hash(h Hasher, password string) (string, error) {
+	if len(p) == 0 {
+		return h.Hash(password)
+	}
+	hash, err := h.Hash(p.apply(password))
+	if err != nil {
+		return "", err
+	}
+	return pepperPrefix + hash, nil
+}
+
+// verify checks password against a hash made with or without the pepper.
+func (p pepper) verify(hash, password string, verify func(hash, password string) error) error {
+	inner, peppered := strings.CutPrefix(hash, pepperPrefix)
+	if !peppered {
+		return verify(hash, password)
+	}
+	if len(p) == 0 {
+		return ErrUnsupportedHash
+	}
+	return verify(inner, p.apply(password))
+}
+
+// current reports whether hash is peppered exactly when the pepper is set
+// and its inner hash is current.
+func (p pepper) current(hash string, current func(hash string) bool) bool {
+	inner, peppered := strings.CutPrefix(hash, pepperPrefix)
+	return peppered == (len(p) > 0) && current(inner)
+}
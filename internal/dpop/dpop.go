@@ -0,0 +1,237 @@
+// Package dpop implements verification of DPoP proofs (RFC 9449).
+//
+// A proof is a JWT signed by the client with the private key whose public part
+// is carried in the "jwk" header. Tokens issued against a proof are bound to the
+// key by its RFC 7638 thumbprint, so presenting the token also requires a fresh
+// proof signed with the same key.
+//
+// gRPC has no request URI, so the "htu" claim is expected to carry the full
+// method name (e.g. "/auth.AuthService/Login") and "htm" is always "POST".
+package dpop
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// HeaderType is the mandatory "typ" header of a DPoP proof.
+const HeaderType = "dpop+jwt"
+
+// MethodPOST is the "htm" value expected for gRPC calls.
+const MethodPOST = "POST"
+
+var (
+	ErrInvalidProof = errors.New("dpop: invalid proof")
+	ErrStaleProof   = errors.New("dpop: proof issued outside the acceptance window")
+)
+
+// Options controls proof freshness checks.
+type Options struct {
+	// MaxAge is how old a proof may be. Default: 5 minutes.
+	MaxAge time.Duration
+	// Leeway tolerates proofs issued slightly in the future. Default: 30 seconds.
+	Leeway time.Duration
+}
+
+func (o Options) withDefaults() Options {
+	if o.MaxAge == 0 {
+		o.MaxAge = 5 * time.Minute
+	}
+	if o.Leeway == 0 {
+		o.Leeway = 30 * time.Second
+	}
+	return o
+}
+
+// Proof is a verified DPoP proof.
+type Proof struct {
+	// JKT is the base64url SHA-256 JWK thumbprint of the proof key.
+	JKT string
+	// ID is the unique proof identifier (jti) used for replay detection.
+	ID       string
+	IssuedAt time.Time
+}
+
+type proofClaims struct {
+	HTM string `json:"htm"`
+	HTU string `json:"htu"`
+	ATH string `json:"ath,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// Verify checks the proof signature, its header, and that it was created for
+// the given method and target. When accessToken is non-empty the proof must
+// also carry its hash in the "ath" claim.
+func Verify(proof, method, target, accessToken string, now time.Time, opts Options) (*Proof, error) {
+	opts = opts.withDefaults()
+
+	var jkt string
+	claims := &proofClaims{}
+	_, err := jwt.ParseWithClaims(proof, claims, func(t *jwt.Token) (interface{}, error) {
+		if typ, _ := t.Header["typ"].(string); typ != HeaderType {
+			return nil, fmt.Errorf("unexpected typ %q", typ)
+		}
+		raw, ok := t.Header["jwk"].(map[string]interface{})
+		if !ok {
+			return nil, errors.New("missing jwk header")
+		}
+		key, thumb, err := parseJWK(raw)
+		if err != nil {
+			return nil, err
+		}
+		jkt = thumb
+		return key, nil
+	},
+		jwt.WithValidMethods([]string{"ES256", "RS256", "EdDSA"}),
+		jwt.WithoutClaimsValidation(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidProof, err)
+	}
+
+	if claims.ID == "" || claims.IssuedAt == nil {
+		return nil, fmt.Errorf("%w: missing jti or iat", ErrInvalidProof)
+	}
+	if claims.HTM != method || claims.HTU != target {
+		return nil, fmt.Errorf("%w: proof was issued for another request", ErrInvalidProof)
+	}
+	if accessToken != "" && claims.ATH != AccessTokenHash(accessToken) {
+		return nil, fmt.Errorf("%w: access token hash mismatch", ErrInvalidProof)
+	}
+
+	iat := claims.IssuedAt.Time
+	if iat.After(now.Add(opts.Leeway)) || iat.Before(now.Add(-opts.MaxAge)) {
+		return nil, ErrStaleProof
+	}
+
+	return &Proof{JKT: jkt, ID: claims.ID, IssuedAt: iat}, nil
+}
+
+// AccessTokenHash returns the "ath" value for an access token.
+func AccessTokenHash(accessToken string) string {
+	sum := sha256.Sum256([]byte(accessToken))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// parseJWK converts a public JWK into a verification key and computes its
+// RFC 7638 thumbprint.
+func parseJWK(raw map[string]interface{}) (interface{}, string, error) {
+	str := func(name string) (string, error) {
+		v, ok := raw[name].(string)
+		if !ok || v == "" {
+			return "", fmt.Errorf("jwk: missing %q", name)
+		}
+		return v, nil
+	}
+
+	kty, err := str("kty")
+	if err != nil {
+		return nil, "", err
+	}
+	if _, ok := raw["d"]; ok {
+		return nil, "", errors.New("jwk: private key material is not allowed")
+	}
+
+	switch kty {
+	case "EC":
+		crv, err := str("crv")
+		if err != nil {
+			return nil, "", err
+		}
+		if crv != "P-256" {
+			return nil, "", fmt.Errorf("jwk: unsupported curve %q", crv)
+		}
+		x, err := str("x")
+		if err != nil {
+			return nil, "", err
+		}
+		y, err := str("y")
+		if err != nil {
+			return nil, "", err
+		}
+		xb, err := decodeCoord(x, 32)
+		if err != nil {
+			return nil, "", err
+		}
+		yb, err := decodeCoord(y, 32)
+		if err != nil {
+			return nil, "", err
+		}
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(xb), Y: new(big.Int).SetBytes(yb)}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			return nil, "", errors.New("jwk: point is not on curve")
+		}
+		return key, thumbprint(map[string]string{"crv": crv, "kty": kty, "x": x, "y": y}), nil
+
+	case "RSA":
+		n, err := str("n")
+		if err != nil {
+			return nil, "", err
+		}
+		e, err := str("e")
+		if err != nil {
+			return nil, "", err
+		}
+		nb, err := base64.RawURLEncoding.DecodeString(n)
+		if err != nil {
+			return nil, "", fmt.Errorf("jwk: bad modulus: %w", err)
+		}
+		eb, err := base64.RawURLEncoding.DecodeString(e)
+		if err != nil || len(eb) == 0 || len(eb) > 4 {
+			return nil, "", errors.New("jwk: bad exponent")
+		}
+		key := &rsa.PublicKey{N: new(big.Int).SetBytes(nb), E: int(new(big.Int).SetBytes(eb).Int64())}
+		if key.N.BitLen() < 2048 {
+			return nil, "", errors.New("jwk: RSA key is too small")
+		}
+		return key, thumbprint(map[string]string{"e": e, "kty": kty, "n": n}), nil
+
+	case "OKP":
+		crv, err := str("crv")
+		if err != nil {
+			return nil, "", err
+		}
+		if crv != "Ed25519" {
+			return nil, "", fmt.Errorf("jwk: unsupported curve %q", crv)
+		}
+		x, err := str("x")
+		if err != nil {
+			return nil, "", err
+		}
+		xb, err := base64.RawURLEncoding.DecodeString(x)
+		if err != nil || len(xb) != ed25519.PublicKeySize {
+			return nil, "", errors.New("jwk: bad Ed25519 key")
+		}
+		return ed25519.PublicKey(xb), thumbprint(map[string]string{"crv": crv, "kty": kty, "x": x}), nil
+
+	default:
+		return nil, "", fmt.Errorf("jwk: unsupported key type %q", kty)
+	}
+}
+
+func decodeCoord(s string, size int) ([]byte, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) != size {
+		return nil, errors.New("jwk: bad EC coordinate")
+	}
+	return b, nil
+}
+
+// thumbprint computes the RFC 7638 thumbprint of the required JWK members.
+// encoding/json sorts map keys, which yields the canonical member order.
+func thumbprint(members map[string]string) string {
+	b, _ := json.Marshal(members)
+	sum := sha256.Sum256(b)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
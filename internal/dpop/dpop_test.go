@@ -0,0 +1,78 @@
+package dpop
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func newProof(t *testing.T, key *ecdsa.PrivateKey, htu, ath string, iat time.Time) string {
+	t.Helper()
+	claims := jwt.MapClaims{
+		"jti": "proof-1",
+		"htm": MethodPOST,
+		"htu": htu,
+		"iat": iat.Unix(),
+	}
+	if ath != "" {
+		claims["ath"] = ath
+	}
+	tok := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+	tok.Header["typ"] = HeaderType
+	tok.Header["jwk"] = map[string]string{
+		"kty": "EC",
+		"crv": "P-256",
+		"x":   base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+		"y":   base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32))),
+	}
+	s, err := tok.SignedString(key)
+	if err != nil {
+		t.Fatalf("failed to sign proof: %v", err)
+	}
+	return s
+}
+
+func TestVerify(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	now := time.Now()
+	target := "/auth.AuthService/Login"
+
+	p, err := Verify(newProof(t, key, target, "", now), MethodPOST, target, "", now, Options{})
+	if err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	if p.JKT == "" || p.ID != "proof-1" {
+		t.Fatalf("unexpected proof: %+v", p)
+	}
+
+	// the thumbprint only depends on the key
+	again, err := Verify(newProof(t, key, target, "", now), MethodPOST, target, "", now, Options{})
+	if err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	if again.JKT != p.JKT {
+		t.Fatalf("expected stable thumbprint, got %q and %q", p.JKT, again.JKT)
+	}
+
+	if _, err := Verify(newProof(t, key, "/auth.AuthService/Refresh", "", now), MethodPOST, target, "", now, Options{}); !errors.Is(err, ErrInvalidProof) {
+		t.Fatalf("expected ErrInvalidProof for another target, got %v", err)
+	}
+	if _, err := Verify(newProof(t, key, target, "", now.Add(-time.Hour)), MethodPOST, target, "", now, Options{}); !errors.Is(err, ErrStaleProof) {
+		t.Fatalf("expected ErrStaleProof, got %v", err)
+	}
+	if _, err := Verify(newProof(t, key, target, "", now), MethodPOST, target, "access-token", now, Options{}); !errors.Is(err, ErrInvalidProof) {
+		t.Fatalf("expected ErrInvalidProof without ath, got %v", err)
+	}
+	if _, err := Verify(newProof(t, key, target, AccessTokenHash("access-token"), now), MethodPOST, target, "access-token", now, Options{}); err != nil {
+		t.Fatalf("Verify with ath failed: %v", err)
+	}
+}
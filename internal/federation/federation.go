@@ -0,0 +1,188 @@
+// Package federation logs users in with accounts at external identity
+// providers. A Provider turns what the client brought back from the
+// provider, an authorization code or an ID token, into the Identity of the
+// user there; linking that identity to a local user is up to the caller.
+package federation
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/andro-kes/auth_service/pkg/tokenverify"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	// ErrInvalidCredential is returned for codes and ID tokens the provider
+	// does not accept: expired, already used, forged or issued to another
+	// client.
+	ErrInvalidCredential = errors.New("federation: invalid credential")
+	// ErrUnsupported is returned for kinds of credentials a provider does
+	// not take, such as ID tokens of a plain OAuth2 provider.
+	ErrUnsupported = errors.New("federation: credential not supported by provider")
+)
+
+// Credential is what the client obtained from the provider. Exactly one of
+// Code and IDToken is set.
+type Credential struct {
+	// Code is an authorization code, obtained with RedirectURI.
+	Code        string
+	RedirectURI string
+	// IDToken is an OpenID Connect ID token issued to our client ID.
+	IDToken string
+}
+
+// Identity is a user as the provider reports it.
+type Identity struct {
+	// Subject is the provider's stable user ID.
+	Subject string
+	Email   string
+	// EmailVerified is set when the provider vouches that the user owns
+	// Email.
+	EmailVerified bool
+	// Username is the user's login name at the provider, if it has one.
+	Username    string
+	FirstName   string
+	LastName    string
+	DisplayName string
+}
+
+// Provider authenticates users at one identity provider.
+type Provider interface {
+	Authenticate(ctx context.Context, cred Credential) (*Identity, error)
+}
+
+// httpTimeout bounds each request to a provider.
+const httpTimeout = 10 * time.Second
+
+func newHTTPClient() *http.Client {
+	return &http.Client{Timeout: httpTimeout}
+}
+
+// tokenResponse is the response of an OAuth2 token endpoint.
+type tokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	IDToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// exchangeCode redeems an authorization code at tokenURL.
+func exchangeCode(ctx context.Context, client *http.Client, tokenURL, clientID, clientSecret string, cred Credential) (*tokenResponse, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {cred.Code},
+		"client_id":     {clientID},
+		"client_secret": {clientSecret},
+	}
+	if cred.RedirectURI != "" {
+		form.Set("redirect_uri", cred.RedirectURI)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var tr tokenResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&tr); err != nil {
+		return nil, fmt.Errorf("token endpoint: %s: %w", resp.Status, err)
+	}
+	switch {
+	case tr.Error == "invalid_grant" || tr.Error == "bad_verification_code":
+		return nil, fmt.Errorf("%w: %s", ErrInvalidCredential, tr.Error)
+	case tr.Error != "":
+		return nil, fmt.Errorf("token endpoint: %s: %s", tr.Error, tr.ErrorDescription)
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("token endpoint: %s", resp.Status)
+	}
+	return &tr, nil
+}
+
+// getJSON fetches endpoint with the bearer token into v.
+func getJSON(ctx context.Context, client *http.Client, endpoint, token string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized:
+		return fmt.Errorf("%w: %s rejected the token", ErrInvalidCredential, endpoint)
+	case resp.StatusCode != http.StatusOK:
+		return fmt.Errorf("%s: %s", endpoint, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+// verifyIDToken checks the signature of an ID token against keys, that one
+// of issuers issued it for clientID, and returns its claims.
+func verifyIDToken(ctx context.Context, keys *tokenverify.Verifier, token string, issuers []string, clientID string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	if err := keys.VerifyClaims(ctx, token, claims); err != nil {
+		if errors.Is(err, tokenverify.ErrKeysUnavailable) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCredential, err)
+	}
+	iss, _ := claims.GetIssuer()
+	if !slices.Contains(issuers, iss) {
+		return nil, fmt.Errorf("%w: unexpected issuer %q", ErrInvalidCredential, iss)
+	}
+	aud, _ := claims.GetAudience()
+	if !slices.Contains(aud, clientID) {
+		return nil, fmt.Errorf("%w: issued to another client", ErrInvalidCredential)
+	}
+	if sub, _ := claims.GetSubject(); sub == "" {
+		return nil, fmt.Errorf("%w: no subject", ErrInvalidCredential)
+	}
+	return claims, nil
+}
+
+// claimString returns the string claim name, or "".
+func claimString(claims jwt.MapClaims, name string) string {
+	s, _ := claims[name].(string)
+	return s
+}
+
+// claimBool returns the boolean claim name; some providers send "true".
+func claimBool(claims jwt.MapClaims, name string) bool {
+	switch v := claims[name].(type) {
+	case bool:
+		return v
+	case string:
+		return v == "true"
+	}
+	return false
+}
+
+// newKeySet returns a verifier of tokens signed with the keys at jwksURL.
+func newKeySet(jwksURL string, client *http.Client) (*tokenverify.Verifier, error) {
+	return tokenverify.New(tokenverify.Config{
+		JWKSURL:    jwksURL,
+		HTTPClient: client,
+		Leeway:     time.Minute,
+	})
+}
@@ -0,0 +1,247 @@
+package federation
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/andro-kes/auth_service/internal/signing"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// idp is a fake OAuth2/OpenID provider. Its token endpoint redeems the
+// code "good" for idToken.
+type idp struct {
+	*httptest.Server
+	key     *signing.Key
+	idToken string
+	form    map[string]string
+	// emails are served by the GitHub API
+	emails []map[string]any
+	// userinfo is served by the OpenID userinfo endpoint
+	userinfo map[string]any
+}
+
+func newIDP(t *testing.T) *idp {
+	t.Helper()
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := signing.ParsePrivateKeyPEM(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &idp{key: key}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, _ *http.Request) {
+		jwk, _ := p.key.JWK()
+		_ = json.NewEncoder(w).Encode(signing.JWKSet{Keys: []signing.JWK{jwk}})
+	})
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		p.form = map[string]string{}
+		for k := range r.PostForm {
+			p.form[k] = r.PostForm.Get(k)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if r.PostForm.Get("code") != "good" {
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{"access_token": "at", "id_token": p.idToken})
+	})
+	api := func(body func() any) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "Bearer at" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			_ = json.NewEncoder(w).Encode(body())
+		}
+	}
+	mux.HandleFunc("GET /user", api(func() any {
+		return map[string]any{"id": 583231, "login": "octocat", "name": "The Octocat", "email": "public@example.com"}
+	}))
+	mux.HandleFunc("GET /user/emails", api(func() any { return p.emails }))
+	mux.HandleFunc("GET /userinfo", api(func() any { return p.userinfo }))
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, _ *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"issuer":            p.URL,
+			"token_endpoint":    p.URL + "/token",
+			"userinfo_endpoint": p.URL + "/userinfo",
+			"jwks_uri":          p.URL + "/jwks",
+		})
+	})
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+	return p
+}
+
+func (p *idp) sign(t *testing.T, claims jwt.MapClaims) string {
+	t.Helper()
+	s, err := p.key.Sign(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestGoogle(t *testing.T) {
+	p := newIDP(t)
+	g, err := newGoogle("client-1", "secret-1", p.URL+"/token", p.URL+"/jwks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := t.Context()
+	claims := func() jwt.MapClaims {
+		return jwt.MapClaims{
+			"iss":            "https://accounts.google.com",
+			"aud":            "client-1",
+			"sub":            "1234567890",
+			"email":          "Alice@example.com",
+			"email_verified": true,
+			"given_name":     "Alice",
+			"name":           "Alice Liddell",
+			"exp":            time.Now().Add(time.Hour).Unix(),
+		}
+	}
+
+	p.idToken = p.sign(t, claims())
+	id, err := g.Authenticate(ctx, Credential{Code: "good", RedirectURI: "https://app.example/cb"})
+	if err != nil {
+		t.Fatalf("Authenticate with a code failed: %v", err)
+	}
+	if *id != (Identity{Subject: "1234567890", Email: "Alice@example.com", EmailVerified: true, FirstName: "Alice", DisplayName: "Alice Liddell"}) {
+		t.Fatalf("unexpected identity %+v", id)
+	}
+	if p.form["client_secret"] != "secret-1" || p.form["redirect_uri"] != "https://app.example/cb" {
+		t.Fatalf("unexpected token request %v", p.form)
+	}
+	if _, err := g.Authenticate(ctx, Credential{Code: "used"}); !errors.Is(err, ErrInvalidCredential) {
+		t.Fatalf("expected a rejected code to be an invalid credential, got %v", err)
+	}
+
+	if _, err := g.Authenticate(ctx, Credential{IDToken: p.idToken}); err != nil {
+		t.Fatalf("Authenticate with an ID token failed: %v", err)
+	}
+	for name, change := range map[string]func(jwt.MapClaims){
+		"another client": func(c jwt.MapClaims) { c["aud"] = "client-2" },
+		"another issuer": func(c jwt.MapClaims) { c["iss"] = "https://evil.example" },
+		"expired":        func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() },
+	} {
+		c := claims()
+		change(c)
+		if _, err := g.Authenticate(ctx, Credential{IDToken: p.sign(t, c)}); !errors.Is(err, ErrInvalidCredential) {
+			t.Fatalf("expected an ID token of %s to be rejected, got %v", name, err)
+		}
+	}
+}
+
+func TestGitHub(t *testing.T) {
+	p := newIDP(t)
+	g := NewGitHub("client-1", "secret-1")
+	g.tokenURL, g.apiURL = p.URL+"/token", p.URL
+	ctx := t.Context()
+
+	p.emails = []map[string]any{
+		{"email": "old@example.com", "primary": false, "verified": true},
+		{"email": "octocat@example.com", "primary": true, "verified": true},
+	}
+	id, err := g.Authenticate(ctx, Credential{Code: "good"})
+	if err != nil {
+		t.Fatalf("Authenticate failed: %v", err)
+	}
+	if *id != (Identity{Subject: "583231", Username: "octocat", DisplayName: "The Octocat", Email: "octocat@example.com", EmailVerified: true}) {
+		t.Fatalf("unexpected identity %+v", id)
+	}
+
+	// without a verified email the public one is reported as unverified
+	p.emails = []map[string]any{{"email": "octocat@example.com", "primary": true, "verified": false}}
+	id, err = g.Authenticate(ctx, Credential{Code: "good"})
+	if err != nil {
+		t.Fatalf("Authenticate failed: %v", err)
+	}
+	if id.Email != "public@example.com" || id.EmailVerified {
+		t.Fatalf("expected the unverified public email, got %+v", id)
+	}
+
+	if _, err := g.Authenticate(ctx, Credential{Code: "used"}); !errors.Is(err, ErrInvalidCredential) {
+		t.Fatalf("expected a rejected code to be an invalid credential, got %v", err)
+	}
+	if _, err := g.Authenticate(ctx, Credential{IDToken: "x"}); !errors.Is(err, ErrUnsupported) {
+		t.Fatalf("expected ID tokens to be unsupported, got %v", err)
+	}
+}
+
+func TestOIDC(t *testing.T) {
+	p := newIDP(t)
+	ctx := t.Context()
+	claims := func() jwt.MapClaims {
+		return jwt.MapClaims{
+			"iss":   p.URL,
+			"aud":   "client-1",
+			"sub":   "00u1",
+			"email": "alice@corp.example",
+			"upn":   "alice.l",
+			"exp":   time.Now().Add(time.Hour).Unix(),
+		}
+	}
+	o := NewOIDC(OIDCConfig{
+		DiscoveryURL: p.URL + "/.well-known/openid-configuration",
+		ClientID:     "client-1",
+		ClientSecret: "secret-1",
+		Claims:       ClaimMapping{Username: "upn"},
+	})
+
+	// claims the ID token lacks come from userinfo
+	p.idToken = p.sign(t, claims())
+	p.userinfo = map[string]any{"sub": "00u1", "email": "other@corp.example", "given_name": "Alice", "email_verified": true}
+	id, err := o.Authenticate(ctx, Credential{Code: "good"})
+	if err != nil {
+		t.Fatalf("Authenticate with a code failed: %v", err)
+	}
+	if *id != (Identity{Subject: "00u1", Username: "alice.l", Email: "alice@corp.example", EmailVerified: true, FirstName: "Alice"}) {
+		t.Fatalf("unexpected identity %+v", id)
+	}
+	p.userinfo = map[string]any{"sub": "00u2"}
+	if _, err := o.Authenticate(ctx, Credential{Code: "good"}); err == nil {
+		t.Fatal("expected userinfo of another subject to be rejected")
+	}
+
+	id, err = o.Authenticate(ctx, Credential{IDToken: p.idToken})
+	if err != nil {
+		t.Fatalf("Authenticate with an ID token failed: %v", err)
+	}
+	if id.EmailVerified || id.FirstName != "" {
+		t.Fatalf("unexpected identity %+v", id)
+	}
+	c := claims()
+	c["iss"] = "https://evil.example"
+	if _, err := o.Authenticate(ctx, Credential{IDToken: p.sign(t, c)}); !errors.Is(err, ErrInvalidCredential) {
+		t.Fatalf("expected an ID token of another issuer to be rejected, got %v", err)
+	}
+
+	trusting := NewOIDC(OIDCConfig{DiscoveryURL: p.URL, ClientID: "client-1", TrustEmail: true})
+	if id, err := trusting.Authenticate(ctx, Credential{IDToken: p.idToken}); err != nil || !id.EmailVerified {
+		t.Fatalf("expected a trusted email to be verified, got %+v, %v", id, err)
+	}
+
+	// a provider that cannot be discovered is unavailable, not a bad credential
+	if _, err := NewOIDC(OIDCConfig{DiscoveryURL: p.URL + "/other", ClientID: "client-1"}).Authenticate(ctx, Credential{IDToken: p.idToken}); err == nil || errors.Is(err, ErrInvalidCredential) {
+		t.Fatalf("expected discovery to fail, got %v", err)
+	}
+}
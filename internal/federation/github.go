@@ -0,0 +1,102 @@
+package federation
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+const (
+	githubTokenURL = "https://github.com/login/oauth/access_token"
+	githubAPIURL   = "https://api.github.com"
+)
+
+// GitHub signs users in with their GitHub account. GitHub is a plain OAuth2
+// provider: codes are redeemed for an access token, with which the user and
+// their emails are read from the API. It issues no ID tokens.
+type GitHub struct {
+	clientID     string
+	clientSecret string
+	tokenURL     string
+	apiURL       string
+	client       *http.Client
+}
+
+// NewGitHub returns the GitHub provider of the OAuth app clientID. The app
+// needs the user:email scope to see private emails.
+func NewGitHub(clientID, clientSecret string) *GitHub {
+	return &GitHub{
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		tokenURL:     githubTokenURL,
+		apiURL:       githubAPIURL,
+		client:       newHTTPClient(),
+	}
+}
+
+type githubUser struct {
+	ID    int64  `json:"id"`
+	Login string `json:"login"`
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+type githubEmail struct {
+	Email    string `json:"email"`
+	Primary  bool   `json:"primary"`
+	Verified bool   `json:"verified"`
+}
+
+func (g *GitHub) Authenticate(ctx context.Context, cred Credential) (*Identity, error) {
+	if cred.Code == "" {
+		return nil, ErrUnsupported
+	}
+	tr, err := exchangeCode(ctx, g.client, g.tokenURL, g.clientID, g.clientSecret, cred)
+	if err != nil {
+		return nil, err
+	}
+	if tr.AccessToken == "" {
+		return nil, fmt.Errorf("github: no access_token in the token response")
+	}
+
+	var user githubUser
+	if err := getJSON(ctx, g.client, g.apiURL+"/user", tr.AccessToken, &user); err != nil {
+		return nil, err
+	}
+	if user.ID == 0 {
+		return nil, fmt.Errorf("github: no user id")
+	}
+	identity := &Identity{
+		Subject:     strconv.FormatInt(user.ID, 10),
+		Username:    user.Login,
+		DisplayName: user.Name,
+		// the public profile email is not necessarily verified
+		Email: user.Email,
+	}
+
+	var emails []githubEmail
+	if err := getJSON(ctx, g.client, g.apiURL+"/user/emails", tr.AccessToken, &emails); err != nil {
+		return nil, err
+	}
+	if email, ok := githubVerifiedEmail(emails); ok {
+		identity.Email, identity.EmailVerified = email, true
+	}
+	return identity, nil
+}
+
+// githubVerifiedEmail picks the primary email if it is verified, or else
+// the first verified one.
+func githubVerifiedEmail(emails []githubEmail) (string, bool) {
+	for _, e := range emails {
+		if e.Primary && e.Verified {
+			return e.Email, true
+		}
+	}
+	for _, e := range emails {
+		if e.Verified {
+			return e.Email, true
+		}
+	}
+	return "", false
+}
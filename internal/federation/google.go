@@ -0,0 +1,77 @@
+package federation
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/andro-kes/auth_service/pkg/tokenverify"
+)
+
+const (
+	googleTokenURL = "https://oauth2.googleapis.com/token"
+	googleJWKSURL  = "https://www.googleapis.com/oauth2/v3/certs"
+)
+
+// googleIssuers are the "iss" values of Google ID tokens.
+var googleIssuers = []string{"https://accounts.google.com", "accounts.google.com"}
+
+// Google signs users in with their Google account. Authorization codes are
+// redeemed for an ID token; ID tokens obtained by the client directly, e.g.
+// with Sign in with Google, are accepted as well.
+type Google struct {
+	clientID     string
+	clientSecret string
+	tokenURL     string
+	client       *http.Client
+	keys         *tokenverify.Verifier
+}
+
+// NewGoogle returns the Google provider of the OAuth client clientID.
+func NewGoogle(clientID, clientSecret string) (*Google, error) {
+	return newGoogle(clientID, clientSecret, googleTokenURL, googleJWKSURL)
+}
+
+func newGoogle(clientID, clientSecret, tokenURL, jwksURL string) (*Google, error) {
+	client := newHTTPClient()
+	keys, err := newKeySet(jwksURL, client)
+	if err != nil {
+		return nil, err
+	}
+	return &Google{
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		tokenURL:     tokenURL,
+		client:       client,
+		keys:         keys,
+	}, nil
+}
+
+func (g *Google) Authenticate(ctx context.Context, cred Credential) (*Identity, error) {
+	idToken := cred.IDToken
+	if cred.Code != "" {
+		tr, err := exchangeCode(ctx, g.client, g.tokenURL, g.clientID, g.clientSecret, cred)
+		if err != nil {
+			return nil, err
+		}
+		if tr.IDToken == "" {
+			return nil, fmt.Errorf("google: no id_token in the token response; is the openid scope requested?")
+		}
+		idToken = tr.IDToken
+	}
+	if idToken == "" {
+		return nil, fmt.Errorf("%w: code or id_token is required", ErrInvalidCredential)
+	}
+	claims, err := verifyIDToken(ctx, g.keys, idToken, googleIssuers, g.clientID)
+	if err != nil {
+		return nil, err
+	}
+	return &Identity{
+		Subject:       claimString(claims, "sub"),
+		Email:         claimString(claims, "email"),
+		EmailVerified: claimBool(claims, "email_verified"),
+		FirstName:     claimString(claims, "given_name"),
+		LastName:      claimString(claims, "family_name"),
+		DisplayName:   claimString(claims, "name"),
+	}, nil
+}
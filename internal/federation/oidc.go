@@ -0,0 +1,182 @@
+package federation
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/andro-kes/auth_service/pkg/tokenverify"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const discoveryPath = "/.well-known/openid-configuration"
+
+// ClaimMapping names the claims local user fields are read from.
+type ClaimMapping struct {
+	Username      string
+	Email         string
+	EmailVerified string
+	FirstName     string
+	LastName      string
+	DisplayName   string
+}
+
+// DefaultClaims are the standard OpenID Connect claims.
+var DefaultClaims = ClaimMapping{
+	Username:      "preferred_username",
+	Email:         "email",
+	EmailVerified: "email_verified",
+	FirstName:     "given_name",
+	LastName:      "family_name",
+	DisplayName:   "name",
+}
+
+// OIDCConfig configures an OpenID Connect provider.
+type OIDCConfig struct {
+	// DiscoveryURL is the issuer URL or its
+	// /.well-known/openid-configuration document.
+	DiscoveryURL string
+	ClientID     string
+	ClientSecret string
+	// Claims overrides the claims of DefaultClaims that are set.
+	Claims ClaimMapping
+	// TrustEmail treats every email as verified, for providers that manage
+	// the emails of their users but send no email_verified claim.
+	TrustEmail bool
+}
+
+// OIDC signs users in with any OpenID Connect provider, such as the
+// identity provider of an enterprise. Its endpoints and keys are discovered
+// on first use, so that a provider that is down does not keep the service
+// from starting.
+type OIDC struct {
+	cfg    OIDCConfig
+	claims ClaimMapping
+	client *http.Client
+
+	mu   sync.Mutex
+	meta *oidcMetadata
+	keys *tokenverify.Verifier
+}
+
+// oidcMetadata is the part of the discovery document the provider uses.
+type oidcMetadata struct {
+	Issuer           string `json:"issuer"`
+	TokenEndpoint    string `json:"token_endpoint"`
+	UserinfoEndpoint string `json:"userinfo_endpoint"`
+	JWKSURI          string `json:"jwks_uri"`
+}
+
+// NewOIDC returns the provider of cfg.
+func NewOIDC(cfg OIDCConfig) *OIDC {
+	claims := DefaultClaims
+	for _, c := range []struct {
+		dst *string
+		src string
+	}{
+		{&claims.Username, cfg.Claims.Username},
+		{&claims.Email, cfg.Claims.Email},
+		{&claims.EmailVerified, cfg.Claims.EmailVerified},
+		{&claims.FirstName, cfg.Claims.FirstName},
+		{&claims.LastName, cfg.Claims.LastName},
+		{&claims.DisplayName, cfg.Claims.DisplayName},
+	} {
+		if c.src != "" {
+			*c.dst = c.src
+		}
+	}
+	return &OIDC{cfg: cfg, claims: claims, client: newHTTPClient()}
+}
+
+func (o *OIDC) Authenticate(ctx context.Context, cred Credential) (*Identity, error) {
+	meta, keys, err := o.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	idToken, accessToken := cred.IDToken, ""
+	if cred.Code != "" {
+		tr, err := exchangeCode(ctx, o.client, meta.TokenEndpoint, o.cfg.ClientID, o.cfg.ClientSecret, cred)
+		if err != nil {
+			return nil, err
+		}
+		if tr.IDToken == "" {
+			return nil, fmt.Errorf("oidc: no id_token in the token response; is the openid scope requested?")
+		}
+		idToken, accessToken = tr.IDToken, tr.AccessToken
+	}
+	if idToken == "" {
+		return nil, fmt.Errorf("%w: code or id_token is required", ErrInvalidCredential)
+	}
+	claims, err := verifyIDToken(ctx, keys, idToken, []string{meta.Issuer}, o.cfg.ClientID)
+	if err != nil {
+		return nil, err
+	}
+	if accessToken != "" && meta.UserinfoEndpoint != "" {
+		if err := o.addUserinfo(ctx, meta.UserinfoEndpoint, accessToken, claims); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Identity{
+		Subject:       claimString(claims, "sub"),
+		Username:      claimString(claims, o.claims.Username),
+		Email:         claimString(claims, o.claims.Email),
+		EmailVerified: o.cfg.TrustEmail || claimBool(claims, o.claims.EmailVerified),
+		FirstName:     claimString(claims, o.claims.FirstName),
+		LastName:      claimString(claims, o.claims.LastName),
+		DisplayName:   claimString(claims, o.claims.DisplayName),
+	}, nil
+}
+
+// addUserinfo adds the claims of the userinfo endpoint that the ID token
+// lacks; many providers keep ID tokens small.
+func (o *OIDC) addUserinfo(ctx context.Context, endpoint, accessToken string, claims jwt.MapClaims) error {
+	info := jwt.MapClaims{}
+	if err := getJSON(ctx, o.client, endpoint, accessToken, &info); err != nil {
+		return err
+	}
+	// userinfo of another subject must not be mixed in (OpenID Connect
+	// Core 5.3.2)
+	if claimString(info, "sub") != claimString(claims, "sub") {
+		return fmt.Errorf("oidc: userinfo is about another subject")
+	}
+	for k, v := range info {
+		if _, ok := claims[k]; !ok {
+			claims[k] = v
+		}
+	}
+	return nil
+}
+
+// discover fetches the discovery document once it is first needed; a
+// failed fetch is retried on the next login.
+func (o *OIDC) discover(ctx context.Context) (*oidcMetadata, *tokenverify.Verifier, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.meta != nil {
+		return o.meta, o.keys, nil
+	}
+
+	issuer := strings.TrimSuffix(strings.TrimSuffix(o.cfg.DiscoveryURL, discoveryPath), "/")
+	var meta oidcMetadata
+	if err := getJSON(ctx, o.client, issuer+discoveryPath, "", &meta); err != nil {
+		return nil, nil, fmt.Errorf("oidc discovery: %w", err)
+	}
+	// the issuer must be the one we asked, or a document served elsewhere
+	// could vouch for tokens of another issuer (OpenID Connect Discovery
+	// 4.3)
+	if strings.TrimSuffix(meta.Issuer, "/") != issuer {
+		return nil, nil, fmt.Errorf("oidc discovery: issuer %q does not match %q", meta.Issuer, issuer)
+	}
+	if meta.TokenEndpoint == "" || meta.JWKSURI == "" {
+		return nil, nil, fmt.Errorf("oidc discovery: token_endpoint and jwks_uri are required")
+	}
+	keys, err := newKeySet(meta.JWKSURI, o.client)
+	if err != nil {
+		return nil, nil, err
+	}
+	o.meta, o.keys = &meta, keys
+	return o.meta, o.keys, nil
+}
@@ -0,0 +1,76 @@
+package httpapi
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/andro-kes/auth_service/internal/config"
+)
+
+const (
+	corsAllowedMethods = "GET, POST, PUT, DELETE"
+	corsAllowedHeaders = "Authorization, Content-Type, DPoP, X-Client-Id, X-Device-Id, X-Refresh-Token, X-Request-Id, Traceparent"
+	corsExposedHeaders = "Retry-After, X-RateLimit-Limit, X-RateLimit-Policy, X-RateLimit-Remaining, X-RateLimit-Reset, X-Request-Id"
+)
+
+type cors struct {
+	anyOrigin   bool
+	origins     map[string]bool
+	credentials bool
+	maxAge      string
+}
+
+func newCORS(cfg config.CORS) *cors {
+	c := &cors{
+		origins:     make(map[string]bool, len(cfg.AllowedOrigins)),
+		credentials: cfg.AllowCredentials,
+		maxAge:      strconv.Itoa(int(cfg.MaxAge.Seconds())),
+	}
+	for _, o := range cfg.AllowedOrigins {
+		if o == "*" {
+			c.anyOrigin = true
+			continue
+		}
+		c.origins[strings.ToLower(o)] = true
+	}
+	return c
+}
+
+func (c *cors) allowed(origin string) bool {
+	return c.anyOrigin || c.origins[strings.ToLower(origin)]
+}
+
+// wrap answers preflight requests and adds CORS headers for allowed origins.
+// Requests from other origins are served without CORS headers, so browsers
+// refuse to expose the response.
+func (c *cors) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		h.Add("Vary", "Origin")
+		origin := r.Header.Get("Origin")
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+
+		if origin != "" && c.allowed(origin) {
+			if c.anyOrigin && !c.credentials {
+				h.Set("Access-Control-Allow-Origin", "*")
+			} else {
+				h.Set("Access-Control-Allow-Origin", origin)
+			}
+			if c.credentials {
+				h.Set("Access-Control-Allow-Credentials", "true")
+			}
+			h.Set("Access-Control-Expose-Headers", corsExposedHeaders)
+			if preflight {
+				h.Set("Access-Control-Allow-Methods", corsAllowedMethods)
+				h.Set("Access-Control-Allow-Headers", corsAllowedHeaders)
+				h.Set("Access-Control-Max-Age", c.maxAge)
+			}
+		}
+		if preflight {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,60 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+)
+
+// discoveryPath is where the OpenID Connect discovery document is served.
+const discoveryPath = "/.well-known/openid-configuration"
+
+// discovery is the subset of OpenID Connect Discovery 1.0 provider
+// metadata that applies to this service: it issues tokens but has no
+// authorization endpoint nor ID tokens of its own.
+type discovery struct {
+	Issuer                   string   `json:"issuer"`
+	JWKSURI                  string   `json:"jwks_uri"`
+	TokenEndpoint            string   `json:"token_endpoint"`
+	IntrospectionEndpoint    string   `json:"introspection_endpoint"`
+	RevocationEndpoint       string   `json:"revocation_endpoint"`
+	GrantTypes               []string `json:"grant_types_supported"`
+	TokenEndpointAuthMethods []string `json:"token_endpoint_auth_methods_supported"`
+	SubjectTypes             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgs       []string `json:"id_token_signing_alg_values_supported"`
+	AccessTokenSigningAlgs   []string `json:"access_token_signing_alg_values_supported"`
+}
+
+// discoveryHandler describes issuer and the algorithms of the asymmetric
+// keys of src. Only tokens verifiable with the JWKS can be consumed by
+// standard libraries, so the document is not found while the service signs
+// with a shared secret alone.
+func discoveryHandler(issuer string, src KeySource) func(http.ResponseWriter, *http.Request, map[string]string) {
+	return func(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+		var algs []string
+		for _, k := range src.VerificationKeys() {
+			if jwk, ok := k.JWK(); ok && !slices.Contains(algs, jwk.Alg) {
+				algs = append(algs, jwk.Alg)
+			}
+		}
+		if len(algs) == 0 {
+			http.NotFound(w, r)
+			return
+		}
+		doc := discovery{
+			Issuer:                   issuer,
+			JWKSURI:                  issuer + jwksPath,
+			TokenEndpoint:            issuer + "/v1/token/client-credentials",
+			IntrospectionEndpoint:    issuer + "/v1/introspect",
+			RevocationEndpoint:       issuer + "/v1/revoke",
+			GrantTypes:               []string{"client_credentials"},
+			TokenEndpointAuthMethods: []string{"client_secret_basic", "client_secret_post"},
+			SubjectTypes:             []string{"public"},
+			IDTokenSigningAlgs:       algs,
+			AccessTokenSigningAlgs:   algs,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		_ = json.NewEncoder(w).Encode(doc)
+	}
+}
@@ -0,0 +1,114 @@
+package httpapi
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/andro-kes/auth_service/internal/autherr"
+	pb "github.com/andro-kes/auth_service/proto"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc/status"
+)
+
+// extAuthzPrefix is the path_prefix of the Envoy ext_authz HTTP service.
+// Envoy appends the path of the request it authorizes and keeps its method.
+const extAuthzPrefix = "/ext_authz"
+
+// Headers with the claims of an authorized request, for Envoy to add to the
+// upstream request (allowed_upstream_headers).
+const (
+	authUserIDHeader      = "X-Auth-User-Id"
+	authSessionIDHeader   = "X-Auth-Session-Id"
+	authScopeHeader       = "X-Auth-Scope"
+	authSubjectTypeHeader = "X-Auth-Subject-Type"
+	authRolesHeader       = "X-Auth-Roles"
+	authAudienceHeader    = "X-Auth-Audience"
+)
+
+// withExtAuthz serves the ext_authz checks under extAuthzPrefix and hands
+// every other request to next.
+func withExtAuthz(auth pb.AuthServiceServer, next http.Handler) http.Handler {
+	check := extAuthz(auth)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == extAuthzPrefix || strings.HasPrefix(r.URL.Path, extAuthzPrefix+"/") {
+			check.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// extAuthz checks the access token in the Authorization header of a request
+// Envoy forwards, exactly as Validate does: 200 with the claims in the
+// X-Auth-* headers, otherwise the HTTP status of the error, which Envoy
+// returns to the client. DPoP-bound tokens need the "DPoP" scheme and a proof
+// created for the original request, whose URL is rebuilt from the Host
+// header, X-Forwarded-Proto and the path.
+func extAuthz(auth pb.AuthServiceServer) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scheme, token, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+		req := &pb.ValidateRequest{
+			AccessToken: strings.TrimSpace(token),
+			Htm:         r.Method,
+			Htu:         originalURL(r),
+		}
+		switch strings.ToLower(scheme) {
+		case "bearer":
+		case "dpop":
+			req.DpopProof = r.Header.Get("DPoP")
+		default:
+			req.AccessToken = ""
+		}
+		if req.AccessToken == "" {
+			denyExtAuthz(w, scheme, autherr.ErrNoToken)
+			return
+		}
+
+		claims, err := auth.Validate(r.Context(), req)
+		if err != nil {
+			denyExtAuthz(w, scheme, err)
+			return
+		}
+		h := w.Header()
+		setNonEmpty(h, authUserIDHeader, claims.UserId)
+		setNonEmpty(h, authSessionIDHeader, claims.SessionId)
+		setNonEmpty(h, authScopeHeader, claims.Scope)
+		setNonEmpty(h, authSubjectTypeHeader, claims.SubType)
+		setNonEmpty(h, authRolesHeader, strings.Join(claims.Roles, ","))
+		setNonEmpty(h, authAudienceHeader, strings.Join(claims.Audience, ","))
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+// originalURL is the "htu" of the request being authorized: without query
+// and fragment, over https unless the proxy says otherwise.
+func originalURL(r *http.Request) string {
+	proto := r.Header.Get("X-Forwarded-Proto")
+	if proto == "" {
+		proto = "https"
+	}
+	path := strings.TrimPrefix(r.URL.Path, extAuthzPrefix)
+	if path == "" {
+		path = "/"
+	}
+	return proto + "://" + r.Host + path
+}
+
+func denyExtAuthz(w http.ResponseWriter, scheme string, err error) {
+	st := status.Convert(err)
+	code := runtime.HTTPStatusFromCode(st.Code())
+	if code == http.StatusUnauthorized {
+		if strings.EqualFold(scheme, "dpop") {
+			w.Header().Set("WWW-Authenticate", `DPoP error="invalid_token"`)
+		} else {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		}
+	}
+	http.Error(w, st.Message(), code)
+}
+
+func setNonEmpty(h http.Header, key, value string) {
+	if value != "" {
+		h.Set(key, value)
+	}
+}
@@ -0,0 +1,102 @@
+// Package httpapi serves the REST gateway of AuthService and the ops
+// endpoints over HTTP.
+package httpapi
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/andro-kes/auth_service/internal/config"
+	"github.com/andro-kes/auth_service/internal/metrics"
+	"github.com/andro-kes/auth_service/internal/saml"
+	pb "github.com/andro-kes/auth_service/proto"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+)
+
+// forwardedHeaders are passed to the RPC handlers as metadata under their
+// lower-cased names, the same keys gRPC clients send.
+var forwardedHeaders = map[string]bool{
+	"dpop":                true,
+	"user-agent":          true,
+	"x-client-id":         true,
+	"x-client-location":   true,
+	"x-device-id":         true,
+	"x-introspection-key": true,
+	"x-refresh-token":     true,
+}
+
+// New returns the HTTP handler: the JSON gateway for auth (routes are defined
+// in proto/auth_gateway.yaml), /healthz, /metrics unless cfg.MetricsAddr
+// serves them elsewhere, the JWKS of keys (when
+// not nil) and, with an issuer, the OIDC discovery document, the endpoints
+// of the SAML connections, the Envoy ext_authz service under /ext_authz,
+// CORS, security headers, request logging and tracing.
+func New(ctx context.Context, auth pb.AuthServiceServer, keys KeySource, samlProviders map[string]*saml.ServiceProvider, cfg config.HTTP) (http.Handler, error) {
+	mux := runtime.NewServeMux(
+		runtime.WithIncomingHeaderMatcher(headerMatcher),
+		runtime.WithForwardResponseOption(quotaResponseOption),
+		runtime.WithErrorHandler(quotaErrorHandler),
+	)
+	if err := pb.RegisterAuthServiceHandlerServer(ctx, mux, auth); err != nil {
+		return nil, err
+	}
+	if err := mux.HandlePath(http.MethodGet, "/healthz", healthz); err != nil {
+		return nil, err
+	}
+	if keys != nil {
+		if err := mux.HandlePath(http.MethodGet, jwksPath, jwksHandler(keys)); err != nil {
+			return nil, err
+		}
+	}
+	if keys != nil && cfg.Issuer != "" {
+		if err := mux.HandlePath(http.MethodGet, discoveryPath, discoveryHandler(cfg.Issuer, keys)); err != nil {
+			return nil, err
+		}
+	}
+	if len(samlProviders) > 0 {
+		if err := handleSAML(mux, samlProviders); err != nil {
+			return nil, err
+		}
+	}
+	// with a metrics listener of their own, metrics stay off this address
+	if cfg.MetricsAddr == "" {
+		promHandler := metrics.Handler()
+		serveMetrics := func(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+			promHandler.ServeHTTP(w, r)
+		}
+		if err := mux.HandlePath(http.MethodGet, "/metrics", serveMetrics); err != nil {
+			return nil, err
+		}
+	}
+	return traceRequests(requestLogging(securityHeaders(withExtAuthz(auth, newCORS(cfg.CORS).wrap(mux))))), nil
+}
+
+func headerMatcher(key string) (string, bool) {
+	if k := strings.ToLower(key); forwardedHeaders[k] {
+		return k, true
+	}
+	return runtime.DefaultHeaderMatcher(key)
+}
+
+func healthz(w http.ResponseWriter, _ *http.Request, _ map[string]string) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = w.Write([]byte("ok\n"))
+}
+
+// securityHeaders sets headers appropriate for a JSON API that hands out
+// credentials: nothing is cached, framed or sniffed.
+func securityHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		h.Set("Cache-Control", "no-store")
+		h.Set("Content-Security-Policy", "default-src 'none'; frame-ancestors 'none'")
+		h.Set("Referrer-Policy", "no-referrer")
+		h.Set("X-Content-Type-Options", "nosniff")
+		h.Set("X-Frame-Options", "DENY")
+		if r.TLS != nil {
+			h.Set("Strict-Transport-Security", "max-age=63072000; includeSubDomains")
+		}
+		next.ServeHTTP(w, r)
+	})
+}
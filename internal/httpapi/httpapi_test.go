@@ -0,0 +1,383 @@
+package httpapi
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/andro-kes/auth_service/internal/autherr"
+	"github.com/andro-kes/auth_service/internal/config"
+	"github.com/andro-kes/auth_service/internal/logger"
+	"github.com/andro-kes/auth_service/internal/ratelimit"
+	"github.com/andro-kes/auth_service/internal/saml"
+	"github.com/andro-kes/auth_service/internal/signing"
+	pb "github.com/andro-kes/auth_service/proto"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/redis/go-redis/v9"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+type stubAuth struct {
+	pb.UnimplementedAuthServiceServer
+	md        metadata.MD
+	requestID string
+	validated *pb.ValidateRequest
+}
+
+func (s *stubAuth) Login(ctx context.Context, req *pb.LoginRequest) (*pb.TokenResponse, error) {
+	s.md, _ = metadata.FromIncomingContext(ctx)
+	s.requestID = logger.RequestID(ctx)
+	if req.Password != "secret" {
+		return nil, autherr.ErrLoginUser
+	}
+	return &pb.TokenResponse{AccessToken: "at", RefreshToken: "rt", UserId: req.Username}, nil
+}
+
+func (s *stubAuth) Register(ctx context.Context, req *pb.RegisterRequest) (*pb.RegisterResponse, error) {
+	_ = grpc.SetTrailer(ctx, metadata.Pairs(
+		ratelimit.PolicyKey, "requests",
+		ratelimit.LimitKey, "10",
+		ratelimit.RemainingKey, "0",
+		ratelimit.ResetKey, "42",
+	))
+	return nil, status.Error(codes.ResourceExhausted, "requests quota exceeded")
+}
+
+func (s *stubAuth) Validate(_ context.Context, req *pb.ValidateRequest) (*pb.ValidateTokenResponse, error) {
+	s.validated = req
+	if req.AccessToken != "good" {
+		return nil, autherr.ErrInvalidToken
+	}
+	return &pb.ValidateTokenResponse{UserId: "alice", SessionId: "s1", Roles: []string{"admin", "editor"}}, nil
+}
+
+func newTestHandler(t *testing.T, auth pb.AuthServiceServer, cors config.CORS) http.Handler {
+	t.Helper()
+	h, err := New(t.Context(), auth, nil, nil, config.HTTP{CORS: cors})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	return h
+}
+
+func TestGateway_Login(t *testing.T) {
+	auth := &stubAuth{}
+	h := newTestHandler(t, auth, config.CORS{})
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/login", strings.NewReader(`{"username":"alice","password":"secret"}`))
+	req.Header.Set("X-Device-Id", "phone")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+	if !strings.Contains(rec.Body.String(), `"accessToken":"at"`) {
+		t.Fatalf("unexpected body: %s", rec.Body)
+	}
+	if got := auth.md.Get("x-device-id"); len(got) != 1 || got[0] != "phone" {
+		t.Fatalf("expected x-device-id to be forwarded, got %v", got)
+	}
+	if rec.Header().Get("Cache-Control") != "no-store" || rec.Header().Get("X-Content-Type-Options") != "nosniff" {
+		t.Fatalf("missing security headers: %v", rec.Header())
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/v1/login", strings.NewReader(`{"username":"alice","password":"wrong"}`))
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d", rec.Code)
+	}
+}
+
+func TestGateway_RequestID(t *testing.T) {
+	auth := &stubAuth{}
+	h := newTestHandler(t, auth, config.CORS{})
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/login", strings.NewReader(`{"username":"alice","password":"secret"}`))
+	req.Header.Set("X-Request-Id", "trace-42")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if got := rec.Header().Get("X-Request-Id"); got != "trace-42" || auth.requestID != "trace-42" {
+		t.Fatalf("expected caller's request ID to be kept, got header %q and context %q", got, auth.requestID)
+	}
+
+	// unusable IDs are replaced
+	req = httptest.NewRequest(http.MethodPost, "/v1/login", strings.NewReader(`{"username":"alice","password":"secret"}`))
+	req.Header.Set("X-Request-Id", "bad id\n")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	got := rec.Header().Get("X-Request-Id")
+	if got == "" || got == "bad id\n" || auth.requestID != got {
+		t.Fatalf("expected a generated request ID, got header %q and context %q", got, auth.requestID)
+	}
+}
+
+func TestMetricsAddr(t *testing.T) {
+	h := newTestHandler(t, &stubAuth{}, config.CORS{})
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected metrics on the gateway, got %d", rec.Code)
+	}
+
+	h, err := New(t.Context(), &stubAuth{}, nil, nil, config.HTTP{MetricsAddr: ":9090"})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 with a separate metrics address, got %d", rec.Code)
+	}
+}
+
+func TestGateway_QuotaHeaders(t *testing.T) {
+	h := newTestHandler(t, &stubAuth{}, config.CORS{})
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/register", strings.NewReader(`{"username":"alice","password":"secret"}`))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429, got %d", rec.Code)
+	}
+	hdr := rec.Header()
+	if hdr.Get("X-RateLimit-Limit") != "10" || hdr.Get("X-RateLimit-Remaining") != "0" ||
+		hdr.Get("X-RateLimit-Reset") != "42" || hdr.Get("Retry-After") != "42" {
+		t.Fatalf("unexpected quota headers: %v", hdr)
+	}
+}
+
+type stubKeys []*signing.Key
+
+func (k stubKeys) VerificationKeys() []*signing.Key { return k }
+
+// newECKey returns an ES256 signing key.
+func newECKey(t *testing.T) *signing.Key {
+	t.Helper()
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := signing.ParsePrivateKeyPEM(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestJWKS(t *testing.T) {
+	key := newECKey(t)
+	secret := signing.HMAC(jwt.SigningMethodHS256, []byte("012345678901234567890123456789ab"))
+
+	h, err := New(t.Context(), &stubAuth{}, stubKeys{key, secret}, nil, config.HTTP{})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+
+	var set signing.JWKSet
+	if err := json.Unmarshal(rec.Body.Bytes(), &set); err != nil {
+		t.Fatalf("invalid JWKS: %v", err)
+	}
+	if len(set.Keys) != 1 {
+		t.Fatalf("expected only the asymmetric key to be published, got %+v", set.Keys)
+	}
+	jwk := set.Keys[0]
+	if jwk.Kty != "EC" || jwk.Crv != "P-256" || jwk.Alg != "ES256" || jwk.Kid != key.ID || len(jwk.X) != 43 || len(jwk.Y) != 43 {
+		t.Fatalf("unexpected JWK %+v", jwk)
+	}
+	if rec.Header().Get("Cache-Control") != "public, max-age=300" {
+		t.Fatalf("unexpected Cache-Control %q", rec.Header().Get("Cache-Control"))
+	}
+}
+
+func TestDiscovery(t *testing.T) {
+	secret := signing.HMAC(jwt.SigningMethodHS256, []byte("012345678901234567890123456789ab"))
+	get := func(keys stubKeys, issuer string) *httptest.ResponseRecorder {
+		h, err := New(t.Context(), &stubAuth{}, keys, nil, config.HTTP{Issuer: issuer})
+		if err != nil {
+			t.Fatalf("New failed: %v", err)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/.well-known/openid-configuration", nil))
+		return rec
+	}
+
+	rec := get(stubKeys{newECKey(t), secret}, "https://auth.example.com")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	var doc map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("invalid discovery document: %v", err)
+	}
+	if doc["issuer"] != "https://auth.example.com" || doc["jwks_uri"] != "https://auth.example.com/.well-known/jwks.json" ||
+		doc["token_endpoint"] != "https://auth.example.com/v1/token/client-credentials" {
+		t.Fatalf("unexpected discovery document %v", doc)
+	}
+	if algs, _ := doc["id_token_signing_alg_values_supported"].([]any); len(algs) != 1 || algs[0] != "ES256" {
+		t.Fatalf("unexpected algorithms %v", doc["id_token_signing_alg_values_supported"])
+	}
+
+	// a shared secret cannot be verified by others
+	if rec := get(stubKeys{secret}, "https://auth.example.com"); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 without asymmetric keys, got %d", rec.Code)
+	}
+	if rec := get(stubKeys{newECKey(t)}, ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 without an issuer, got %d", rec.Code)
+	}
+}
+
+func TestCORS(t *testing.T) {
+	h := newTestHandler(t, &stubAuth{}, config.CORS{
+		AllowedOrigins:   []string{"https://app.example.com"},
+		AllowCredentials: true,
+		MaxAge:           time.Hour,
+	})
+
+	preflight := httptest.NewRequest(http.MethodOptions, "/v1/login", nil)
+	preflight.Header.Set("Origin", "https://app.example.com")
+	preflight.Header.Set("Access-Control-Request-Method", http.MethodPost)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, preflight)
+
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", rec.Code)
+	}
+	hdr := rec.Header()
+	if hdr.Get("Access-Control-Allow-Origin") != "https://app.example.com" ||
+		hdr.Get("Access-Control-Allow-Credentials") != "true" ||
+		hdr.Get("Access-Control-Max-Age") != "3600" {
+		t.Fatalf("unexpected preflight headers: %v", hdr)
+	}
+
+	other := httptest.NewRequest(http.MethodGet, "/healthz", nil)
+	other.Header.Set("Origin", "https://evil.example.com")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, other)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	if rec.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Fatal("expected no CORS headers for a disallowed origin")
+	}
+}
+
+func TestSAMLRoutes(t *testing.T) {
+	srv, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("failed to start miniredis: %v", err)
+	}
+	defer srv.Close()
+	sp, err := saml.New(saml.Config{
+		Name:      "corp",
+		EntityID:  "https://auth.example.com/v1/saml/corp/metadata",
+		ACSURL:    "https://auth.example.com/v1/saml/corp/acs",
+		IdP:       &saml.IdP{EntityID: "https://idp.example.com", SSOURL: "https://idp.example.com/sso"},
+		ReturnURL: "https://app.example.com/callback",
+	}, redis.NewClient(&redis.Options{Addr: srv.Addr()}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := New(t.Context(), &stubAuth{}, nil, map[string]*saml.ServiceProvider{"corp": sp}, config.HTTP{})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/saml/corp/metadata", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/samlmetadata+xml" ||
+		!strings.Contains(rec.Body.String(), "https://auth.example.com/v1/saml/corp/acs") {
+		t.Fatalf("metadata: %d %s", rec.Code, rec.Body)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/saml/corp/login?relay_state=abc", nil))
+	if loc := rec.Header().Get("Location"); rec.Code != http.StatusFound ||
+		!strings.HasPrefix(loc, "https://idp.example.com/sso?") || !strings.Contains(loc, "RelayState=abc") {
+		t.Fatalf("login: %d %s", rec.Code, loc)
+	}
+
+	// a refused response still returns the browser to the application
+	req := httptest.NewRequest(http.MethodPost, "/v1/saml/corp/acs", strings.NewReader("SAMLResponse=bm9wZQ%3D%3D&RelayState=abc"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if loc := rec.Header().Get("Location"); rec.Code != http.StatusSeeOther ||
+		loc != "https://app.example.com/callback?error=access_denied&state=abc" {
+		t.Fatalf("acs: %d %s", rec.Code, loc)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/saml/other/metadata", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("unknown provider: %d", rec.Code)
+	}
+}
+
+func TestExtAuthz(t *testing.T) {
+	auth := &stubAuth{}
+	h := newTestHandler(t, auth, config.CORS{})
+
+	req := httptest.NewRequest(http.MethodGet, "/ext_authz/orders/7?expand=items", nil)
+	req.Host = "api.example.com"
+	req.Header.Set("Authorization", "DPoP good")
+	req.Header.Set("DPoP", "proof")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+	if got := auth.validated; got.AccessToken != "good" || got.DpopProof != "proof" || got.Htm != http.MethodGet || got.Htu != "https://api.example.com/orders/7" {
+		t.Fatalf("unexpected ValidateRequest %+v", got)
+	}
+	if rec.Header().Get("X-Auth-User-Id") != "alice" || rec.Header().Get("X-Auth-Roles") != "admin,editor" || rec.Header().Get("X-Auth-Scope") != "" {
+		t.Fatalf("unexpected claim headers %v", rec.Header())
+	}
+
+	// a bearer token is checked without the proof
+	req = httptest.NewRequest(http.MethodPost, "/ext_authz/orders", nil)
+	req.Host = "api.example.com"
+	req.Header.Set("Authorization", "Bearer bad")
+	req.Header.Set("DPoP", "proof")
+	req.Header.Set("X-Forwarded-Proto", "http")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized || !strings.HasPrefix(rec.Header().Get("WWW-Authenticate"), "Bearer") {
+		t.Fatalf("expected 401 with a Bearer challenge, got %d %v", rec.Code, rec.Header())
+	}
+	if got := auth.validated; got.DpopProof != "" || got.Htm != http.MethodPost || got.Htu != "http://api.example.com/orders" {
+		t.Fatalf("unexpected ValidateRequest %+v", got)
+	}
+
+	auth.validated = nil
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ext_authz/orders", nil))
+	if rec.Code != http.StatusUnauthorized || auth.validated != nil {
+		t.Fatalf("expected a request without token to be refused up front, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,33 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/andro-kes/auth_service/internal/signing"
+)
+
+// jwksPath is where the public signing keys are published.
+const jwksPath = "/.well-known/jwks.json"
+
+// KeySource provides the keys access tokens are currently verified with.
+type KeySource interface {
+	VerificationKeys() []*signing.Key
+}
+
+// jwksHandler publishes the asymmetric keys of src as a JWK set. HMAC keys
+// are never included, so with a shared secret the set is empty.
+func jwksHandler(src KeySource) func(http.ResponseWriter, *http.Request, map[string]string) {
+	return func(w http.ResponseWriter, _ *http.Request, _ map[string]string) {
+		set := signing.JWKSet{Keys: []signing.JWK{}}
+		for _, k := range src.VerificationKeys() {
+			if jwk, ok := k.JWK(); ok {
+				set.Keys = append(set.Keys, jwk)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		// verifiers refetch on an unknown kid, so a short cache is enough
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		_ = json.NewEncoder(w).Encode(set)
+	}
+}
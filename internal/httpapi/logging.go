@@ -0,0 +1,87 @@
+package httpapi
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/andro-kes/auth_service/internal/logger"
+	"github.com/andro-kes/auth_service/internal/tracing"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// requestIDHeader carries the request ID, as the "x-request-id" metadata
+// does for gRPC callers.
+const requestIDHeader = "X-Request-Id"
+
+// statusRecorder remembers the status code written through it.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	if r.status == 0 {
+		r.status = code
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// requestLogging does for the gateway what rpc.LoggingInterceptor does for
+// gRPC calls, which the gateway invokes in-process: the request ID goes
+// into the request context and the response headers, and every request is
+// logged with its path, peer, duration and status.
+func requestLogging(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := logger.EnsureRequestID(r.Header.Get(requestIDHeader))
+		w.Header().Set(requestIDHeader, id)
+		r = r.WithContext(logger.WithRequestID(r.Context(), id))
+
+		rec := &statusRecorder{ResponseWriter: w}
+		start := time.Now()
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		level := zapcore.InfoLevel
+		switch {
+		case r.URL.Path == "/healthz" || r.URL.Path == "/metrics":
+			level = zapcore.DebugLevel
+		case rec.status >= http.StatusInternalServerError:
+			level = zapcore.WarnLevel
+		}
+		logger.FromContext(r.Context()).Log(level, "HTTP request finished",
+			zap.String("method", r.Method),
+			zap.String("path", r.URL.Path),
+			zap.Int("status", rec.status),
+			zap.Duration("duration", time.Since(start)),
+			zap.String("peer", r.RemoteAddr))
+	})
+}
+
+// traceRequests runs every request in a server span, so that the queries
+// and commands of the RPCs the gateway invokes in-process become its
+// children.
+func traceRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r, span := tracing.StartHTTP(r)
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		tracing.EndHTTP(span, rec.status)
+	})
+}
@@ -0,0 +1,48 @@
+package httpapi
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/andro-kes/auth_service/internal/ratelimit"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/protobuf/proto"
+)
+
+// quotaHeaders maps the quota trailers set by the handlers to HTTP headers.
+var quotaHeaders = map[string]string{
+	ratelimit.PolicyKey:    "X-RateLimit-Policy",
+	ratelimit.LimitKey:     "X-RateLimit-Limit",
+	ratelimit.RemainingKey: "X-RateLimit-Remaining",
+	ratelimit.ResetKey:     "X-RateLimit-Reset",
+}
+
+// forwardQuota copies quota trailers to response headers; gRPC trailers only
+// reach HTTP clients that ask for them, which browsers never do.
+func forwardQuota(ctx context.Context, w http.ResponseWriter) {
+	md, ok := runtime.ServerMetadataFromContext(ctx)
+	if !ok {
+		return
+	}
+	for key, header := range quotaHeaders {
+		if v := md.TrailerMD.Get(key); len(v) > 0 {
+			// the last reported quota is the one that decided the call
+			w.Header().Set(header, v[len(v)-1])
+		}
+	}
+	if w.Header().Get("X-RateLimit-Remaining") == "0" {
+		if reset := w.Header().Get("X-RateLimit-Reset"); reset != "" {
+			w.Header().Set("Retry-After", reset)
+		}
+	}
+}
+
+func quotaResponseOption(ctx context.Context, w http.ResponseWriter, _ proto.Message) error {
+	forwardQuota(ctx, w)
+	return nil
+}
+
+func quotaErrorHandler(ctx context.Context, mux *runtime.ServeMux, m runtime.Marshaler, w http.ResponseWriter, r *http.Request, err error) {
+	forwardQuota(ctx, w)
+	runtime.DefaultHTTPErrorHandler(ctx, mux, m, w, r, err)
+}
@@ -0,0 +1,74 @@
+package httpapi
+
+import (
+	"net/http"
+
+	"github.com/andro-kes/auth_service/internal/logger"
+	"github.com/andro-kes/auth_service/internal/saml"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"go.uber.org/zap"
+)
+
+// maxACSBody bounds the form posted to the ACS.
+const maxACSBody = 512 << 10
+
+// handleSAML registers the endpoints of the SAML connections: the service
+// provider metadata, the start of a login and the assertion consumer
+// service, which hands the browser back to the application with a code.
+func handleSAML(mux *runtime.ServeMux, providers map[string]*saml.ServiceProvider) error {
+	lookup := func(w http.ResponseWriter, r *http.Request, params map[string]string) *saml.ServiceProvider {
+		sp, ok := providers[params["provider"]]
+		if !ok {
+			http.NotFound(w, r)
+		}
+		return sp
+	}
+	metadata := func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+		if sp := lookup(w, r, params); sp != nil {
+			w.Header().Set("Content-Type", "application/samlmetadata+xml")
+			_, _ = w.Write(sp.Metadata())
+		}
+	}
+	login := func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+		sp := lookup(w, r, params)
+		if sp == nil {
+			return
+		}
+		u, err := sp.LoginURL(r.Context(), r.URL.Query().Get("relay_state"))
+		if err != nil {
+			logger.Logger().Warn("SAML login not started", zap.String("provider", params["provider"]), zap.Error(err))
+			http.Error(w, "login could not be started", http.StatusBadRequest)
+			return
+		}
+		http.Redirect(w, r, u, http.StatusFound)
+	}
+	acs := func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+		sp := lookup(w, r, params)
+		if sp == nil {
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, maxACSBody)
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "invalid form", http.StatusBadRequest)
+			return
+		}
+		u, err := sp.HandleACS(r.Context(), r.PostForm.Get("SAMLResponse"), r.PostForm.Get("RelayState"))
+		if err != nil {
+			logger.Logger().Warn("SAML response refused", zap.String("provider", params["provider"]), zap.Error(err))
+		}
+		http.Redirect(w, r, u, http.StatusSeeOther)
+	}
+	for _, route := range []struct {
+		method, path string
+		handler      runtime.HandlerFunc
+	}{
+		{http.MethodGet, "/v1/saml/{provider}/metadata", metadata},
+		{http.MethodGet, "/v1/saml/{provider}/login", login},
+		{http.MethodPost, "/v1/saml/{provider}/acs", acs},
+	} {
+		if err := mux.HandlePath(route.method, route.path, route.handler); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,187 @@
+package ldap
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// BER classes and the constructed bit of identifier octets.
+const (
+	classUniversal   = 0x00
+	classApplication = 0x40
+	classContext     = 0x80
+	constructed      = 0x20
+)
+
+// Universal tags used by LDAP.
+const (
+	tagBoolean     = 0x01
+	tagInteger     = 0x02
+	tagOctetString = 0x04
+	tagEnumerated  = 0x0a
+	tagSequence    = 0x10
+	tagSet         = 0x11
+)
+
+// maxPacketSize bounds the messages read from a server.
+const maxPacketSize = 4 << 20
+
+// packet is a BER element. Constructed packets have children, primitive
+// ones a value.
+type packet struct {
+	// id is the identifier octet: class, constructed bit and tag.
+	id       byte
+	value    []byte
+	children []*packet
+}
+
+func (p *packet) tag() byte { return p.id & 0x1f }
+
+func (p *packet) class() byte { return p.id & 0xc0 }
+
+func primitive(id byte, value []byte) *packet {
+	return &packet{id: id, value: value}
+}
+
+func sequence(id byte, children ...*packet) *packet {
+	return &packet{id: id | constructed, children: children}
+}
+
+func octetString(s string) *packet {
+	return primitive(tagOctetString, []byte(s))
+}
+
+func integer(id byte, n int64) *packet {
+	// minimal two's complement
+	var b []byte
+	for {
+		b = append([]byte{byte(n)}, b...)
+		if (n >= -128 && n < 128) || len(b) == 8 {
+			break
+		}
+		n >>= 8
+	}
+	return primitive(id, b)
+}
+
+func boolean(v bool) *packet {
+	if v {
+		return primitive(tagBoolean, []byte{0xff})
+	}
+	return primitive(tagBoolean, []byte{0})
+}
+
+// bytes encodes p in definite-length form.
+func (p *packet) bytes() []byte {
+	content := p.value
+	if p.id&constructed != 0 {
+		content = nil
+		for _, c := range p.children {
+			content = append(content, c.bytes()...)
+		}
+	}
+	out := []byte{p.id}
+	n := len(content)
+	switch {
+	case n < 0x80:
+		out = append(out, byte(n))
+	case n <= 0xff:
+		out = append(out, 0x81, byte(n))
+	case n <= 0xffff:
+		out = append(out, 0x82, byte(n>>8), byte(n))
+	default:
+		out = append(out, 0x84, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	}
+	return append(out, content...)
+}
+
+// readPacket reads one element from r.
+func readPacket(r *bufio.Reader) (*packet, error) {
+	id, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	if id&0x1f == 0x1f {
+		return nil, errors.New("ldap: multi-byte tags are not supported")
+	}
+	first, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	n := int(first)
+	if first&0x80 != 0 {
+		size := int(first & 0x7f)
+		if size == 0 || size > 4 {
+			return nil, errors.New("ldap: unsupported length encoding")
+		}
+		n = 0
+		for range size {
+			b, err := r.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			n = n<<8 | int(b)
+		}
+	}
+	if n > maxPacketSize {
+		return nil, fmt.Errorf("ldap: message of %d bytes is too large", n)
+	}
+	content := make([]byte, n)
+	if _, err := io.ReadFull(r, content); err != nil {
+		return nil, err
+	}
+	return decode(id, content, 0)
+}
+
+// decode parses the content of an element with identifier id.
+func decode(id byte, content []byte, depth int) (*packet, error) {
+	p := &packet{id: id}
+	if id&constructed == 0 {
+		p.value = content
+		return p, nil
+	}
+	if depth > 16 {
+		return nil, errors.New("ldap: message nested too deeply")
+	}
+	for len(content) > 0 {
+		if len(content) < 2 || content[0]&0x1f == 0x1f {
+			return nil, errors.New("ldap: malformed message")
+		}
+		cid, n, rest := content[0], int(content[1]), content[2:]
+		if content[1]&0x80 != 0 {
+			size := int(content[1] & 0x7f)
+			if size == 0 || size > 4 || len(rest) < size {
+				return nil, errors.New("ldap: malformed message")
+			}
+			n = 0
+			for _, b := range rest[:size] {
+				n = n<<8 | int(b)
+			}
+			rest = rest[size:]
+		}
+		if n < 0 || n > len(rest) {
+			return nil, errors.New("ldap: malformed message")
+		}
+		child, err := decode(cid, rest[:n], depth+1)
+		if err != nil {
+			return nil, err
+		}
+		p.children = append(p.children, child)
+		content = rest[n:]
+	}
+	return p, nil
+}
+
+// int returns the value of an INTEGER or ENUMERATED.
+func (p *packet) int() int64 {
+	var n int64
+	for i, b := range p.value {
+		if i == 0 && b&0x80 != 0 {
+			n = -1
+		}
+		n = n<<8 | int64(b)
+	}
+	return n
+}
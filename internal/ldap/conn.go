@@ -0,0 +1,311 @@
+package ldap
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+)
+
+// Protocol operations of LDAPMessage.
+const (
+	opBindRequest      = 0
+	opBindResponse     = 1
+	opUnbindRequest    = 2
+	opSearchRequest    = 3
+	opSearchEntry      = 4
+	opSearchDone       = 5
+	opSearchReference  = 19
+	opExtendedRequest  = 23
+	opExtendedResponse = 24
+)
+
+// Result codes of LDAPResult.
+const (
+	resultSuccess      = 0
+	resultSizeLimit    = 4
+	resultNoSuchObject = 32
+	resultInvalidCreds = 49
+)
+
+const (
+	oidStartTLS       = "1.3.6.1.4.1.1466.20037"
+	scopeWholeSubtree = 2
+	derefNever        = 0
+	// unsolicitedMsgID is the message ID of notices of disconnection.
+	unsolicitedMsgID = 0
+	// searchSizeLimit is enough to tell one match from several.
+	searchSizeLimit     = 2
+	maxSearchReferences = 16
+)
+
+// ErrInvalidCredentials is returned for a wrong login or password.
+var ErrInvalidCredentials = errors.New("ldap: invalid credentials")
+
+// ResultError is an LDAP result other than success.
+type ResultError struct {
+	Code    int64
+	Message string
+}
+
+func (e *ResultError) Error() string {
+	return fmt.Sprintf("ldap: result code %d: %s", e.Code, e.Message)
+}
+
+// Entry is an object found by a search.
+type Entry struct {
+	DN         string
+	Attributes map[string][]string
+}
+
+// conn is a connection to a directory server. Requests are sent one at a
+// time, so every response belongs to the last request.
+type conn struct {
+	nc    net.Conn
+	r     *bufio.Reader
+	msgID int64
+	// stop releases the watch of the dial context.
+	stop func() bool
+}
+
+// dial connects to an ldap:// or ldaps:// URL; with startTLS a plain
+// connection is upgraded before it is used. I/O on the connection fails
+// once ctx is done.
+func dial(ctx context.Context, rawURL string, tlsConfig *tls.Config, startTLS bool) (*conn, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	host := u.Host
+	var d net.Dialer
+	var nc net.Conn
+	switch u.Scheme {
+	case "ldap":
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "389")
+		}
+		nc, err = d.DialContext(ctx, "tcp", host)
+	case "ldaps":
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "636")
+		}
+		td := tls.Dialer{NetDialer: &d, Config: tlsConfigFor(tlsConfig, u.Hostname())}
+		nc, err = td.DialContext(ctx, "tcp", host)
+	default:
+		return nil, fmt.Errorf("ldap: unsupported URL scheme %q", u.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = nc.SetDeadline(deadline)
+	}
+	c := &conn{nc: nc, r: bufio.NewReader(nc)}
+	// a deadline in the past interrupts pending reads and writes
+	c.stop = context.AfterFunc(ctx, func() { _ = nc.SetDeadline(time.Unix(1, 0)) })
+
+	if startTLS && u.Scheme == "ldap" {
+		if err := c.startTLS(tlsConfigFor(tlsConfig, u.Hostname())); err != nil {
+			c.close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func tlsConfigFor(cfg *tls.Config, host string) *tls.Config {
+	if cfg == nil {
+		cfg = &tls.Config{MinVersion: tls.VersionTLS12}
+	} else {
+		cfg = cfg.Clone()
+	}
+	if cfg.ServerName == "" {
+		cfg.ServerName = host
+	}
+	return cfg
+}
+
+func (c *conn) startTLS(cfg *tls.Config) error {
+	resp, err := c.roundTrip(sequence(classApplication|opExtendedRequest,
+		primitive(classContext|0, []byte(oidStartTLS))), opExtendedResponse)
+	if err != nil {
+		return err
+	}
+	if err := result(resp); err != nil {
+		return err
+	}
+	tc := tls.Client(c.nc, cfg)
+	if err := tc.Handshake(); err != nil {
+		return err
+	}
+	c.nc, c.r = tc, bufio.NewReader(tc)
+	return nil
+}
+
+// bind authenticates the connection with a simple bind. Empty passwords
+// are refused: servers treat them as an unauthenticated bind, which
+// succeeds for any DN.
+func (c *conn) bind(dn, password string) error {
+	if password == "" {
+		return ErrInvalidCredentials
+	}
+	resp, err := c.roundTrip(sequence(classApplication|opBindRequest,
+		integer(tagInteger, 3),
+		octetString(dn),
+		primitive(classContext|0, []byte(password)),
+	), opBindResponse)
+	if err != nil {
+		return err
+	}
+	err = result(resp)
+	var re *ResultError
+	if errors.As(err, &re) && re.Code == resultInvalidCreds {
+		return ErrInvalidCredentials
+	}
+	return err
+}
+
+// search returns the entries under base that match filter, with attrs. A
+// search matching more than searchSizeLimit entries fails.
+func (c *conn) search(base, filter string, attrs []string) ([]Entry, error) {
+	f, err := compileFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	list := sequence(tagSequence)
+	for _, a := range attrs {
+		list.children = append(list.children, octetString(a))
+	}
+	if err := c.send(sequence(classApplication|opSearchRequest,
+		octetString(base),
+		integer(tagEnumerated, scopeWholeSubtree),
+		integer(tagEnumerated, derefNever),
+		integer(tagInteger, searchSizeLimit),
+		integer(tagInteger, 0),
+		boolean(false),
+		f,
+		list,
+	)); err != nil {
+		return nil, err
+	}
+
+	var entries []Entry
+	for references := 0; ; {
+		op, err := c.receive()
+		if err != nil {
+			return nil, err
+		}
+		switch op.tag() {
+		case opSearchEntry:
+			e, err := parseEntry(op)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, e)
+		case opSearchReference:
+			// referrals to other servers are not followed
+			if references++; references > maxSearchReferences {
+				return nil, errors.New("ldap: too many search references")
+			}
+		case opSearchDone:
+			if err := result(op); err != nil {
+				var re *ResultError
+				if errors.As(err, &re) && re.Code == resultNoSuchObject {
+					return nil, nil
+				}
+				return nil, err
+			}
+			return entries, nil
+		default:
+			return nil, fmt.Errorf("ldap: unexpected operation %d", op.tag())
+		}
+	}
+}
+
+func parseEntry(op *packet) (Entry, error) {
+	if len(op.children) != 2 {
+		return Entry{}, errors.New("ldap: malformed search entry")
+	}
+	e := Entry{DN: string(op.children[0].value), Attributes: map[string][]string{}}
+	for _, a := range op.children[1].children {
+		if len(a.children) != 2 {
+			return Entry{}, errors.New("ldap: malformed search entry")
+		}
+		name := string(a.children[0].value)
+		for _, v := range a.children[1].children {
+			e.Attributes[name] = append(e.Attributes[name], string(v.value))
+		}
+	}
+	return e, nil
+}
+
+// close unbinds and closes the connection.
+func (c *conn) close() {
+	_ = c.send(primitive(classApplication|opUnbindRequest, nil))
+	_ = c.nc.Close()
+	c.stop()
+}
+
+// roundTrip sends a request and returns its response, which must be the
+// operation want.
+func (c *conn) roundTrip(op *packet, want byte) (*packet, error) {
+	if err := c.send(op); err != nil {
+		return nil, err
+	}
+	resp, err := c.receive()
+	if err != nil {
+		return nil, err
+	}
+	if resp.tag() != want {
+		return nil, fmt.Errorf("ldap: unexpected operation %d", resp.tag())
+	}
+	return resp, nil
+}
+
+func (c *conn) send(op *packet) error {
+	c.msgID++
+	_, err := c.nc.Write(sequence(tagSequence, integer(tagInteger, c.msgID), op).bytes())
+	return err
+}
+
+// receive reads the protocol operation of the next message for the last
+// request.
+func (c *conn) receive() (*packet, error) {
+	msg, err := readPacket(c.r)
+	if err != nil {
+		return nil, err
+	}
+	if msg.id != constructed|tagSequence || len(msg.children) < 2 {
+		return nil, errors.New("ldap: malformed message")
+	}
+	op := msg.children[1]
+	switch id := msg.children[0].int(); {
+	case id == unsolicitedMsgID:
+		// a notice of disconnection
+		if err := result(op); err != nil {
+			return nil, err
+		}
+		return nil, errors.New("ldap: server closed the connection")
+	case id != c.msgID:
+		return nil, fmt.Errorf("ldap: response to unknown message %d", id)
+	}
+	if op.class() != classApplication {
+		return nil, errors.New("ldap: malformed message")
+	}
+	return op, nil
+}
+
+// result returns the error of the LDAPResult of op.
+func result(op *packet) error {
+	if len(op.children) < 3 {
+		return errors.New("ldap: malformed result")
+	}
+	if code := op.children[0].int(); code != resultSuccess {
+		return &ResultError{Code: code, Message: string(op.children[2].value)}
+	}
+	return nil
+}
@@ -0,0 +1,189 @@
+// Package ldap authenticates users against an LDAP directory, such as
+// Active Directory, with a search and a simple bind: the user's entry is
+// found by login with the service account (or anonymously), and the login
+// succeeds when the entry's DN binds with the password. The entry's
+// attributes become a federation.Identity, so that directory users are
+// provisioned and linked like those of other identity providers.
+//
+// Only the parts of LDAPv3 this needs are implemented: simple bind,
+// search, StartTLS and unbind.
+package ldap
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/andro-kes/auth_service/internal/federation"
+)
+
+// DefaultTimeout bounds a login when Config.Timeout is zero.
+const DefaultTimeout = 10 * time.Second
+
+// AttributeMapping names the attributes of user entries that become the
+// fields of an identity.
+type AttributeMapping struct {
+	// Subject is the stable user ID, e.g. "entryUUID" or "objectGUID";
+	// objectGUID and other binary values are hex-encoded. Empty uses the DN, which changes when
+	// the entry is renamed or moved.
+	Subject     string
+	Username    string
+	Email       string
+	FirstName   string
+	LastName    string
+	DisplayName string
+}
+
+// DefaultAttributes suit OpenLDAP and other directories with the
+// inetOrgPerson schema.
+var DefaultAttributes = AttributeMapping{
+	Subject:     "entryUUID",
+	Username:    "uid",
+	Email:       "mail",
+	FirstName:   "givenName",
+	LastName:    "sn",
+	DisplayName: "displayName",
+}
+
+// ActiveDirectoryAttributes suit Active Directory.
+var ActiveDirectoryAttributes = AttributeMapping{
+	Subject:     "objectGUID",
+	Username:    "sAMAccountName",
+	Email:       "mail",
+	FirstName:   "givenName",
+	LastName:    "sn",
+	DisplayName: "displayName",
+}
+
+// Config configures a Directory.
+type Config struct {
+	// URL is the server, ldap://host[:port] or ldaps://host[:port].
+	URL string
+	// StartTLS upgrades ldap:// connections before credentials are sent.
+	StartTLS bool
+	// TLS configures ldaps:// and StartTLS; nil verifies the server with
+	// the system roots.
+	TLS *tls.Config
+	// BindDN and BindPassword are the service account users are searched
+	// with; without them the search is anonymous.
+	BindDN       string
+	BindPassword string
+	// BaseDN is where users are searched.
+	BaseDN string
+	// UserFilter finds the entry of a login, whose escaped value replaces
+	// each "{login}", e.g. "(&(objectClass=person)(uid={login}))".
+	UserFilter string
+	Attributes AttributeMapping
+	// TrustEmail treats the directory's emails as verified.
+	TrustEmail bool
+	Timeout    time.Duration
+}
+
+// Directory is an LDAP server users log in with.
+type Directory struct {
+	cfg Config
+}
+
+// New checks cfg and returns its directory. Connections are made per login.
+func New(cfg Config) (*Directory, error) {
+	if !strings.HasPrefix(cfg.URL, "ldap://") && !strings.HasPrefix(cfg.URL, "ldaps://") {
+		return nil, errors.New("ldap: URL must be ldap:// or ldaps://")
+	}
+	if !strings.Contains(cfg.UserFilter, "{login}") {
+		return nil, errors.New("ldap: the user filter must contain {login}")
+	}
+	if _, err := compileFilter(strings.ReplaceAll(cfg.UserFilter, "{login}", "x")); err != nil {
+		return nil, fmt.Errorf("ldap: user filter: %w", err)
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = DefaultTimeout
+	}
+	return &Directory{cfg: cfg}, nil
+}
+
+// Authenticate checks login and password at the directory and returns the
+// identity of the user's entry. It returns ErrInvalidCredentials for
+// unknown logins, ambiguous ones and wrong passwords alike.
+func (d *Directory) Authenticate(ctx context.Context, login, password string) (*federation.Identity, error) {
+	if login == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+	ctx, cancel := context.WithTimeout(ctx, d.cfg.Timeout)
+	defer cancel()
+	c, err := dial(ctx, d.cfg.URL, d.cfg.TLS, d.cfg.StartTLS)
+	if err != nil {
+		return nil, err
+	}
+	defer c.close()
+
+	if d.cfg.BindDN != "" {
+		if err := c.bind(d.cfg.BindDN, d.cfg.BindPassword); err != nil {
+			// the service account must work; this is not the user's fault
+			return nil, fmt.Errorf("ldap: service account bind: %v", err)
+		}
+	}
+	a := d.cfg.Attributes
+	var attrs []string
+	for _, name := range []string{a.Subject, a.Username, a.Email, a.FirstName, a.LastName, a.DisplayName} {
+		if name != "" {
+			attrs = append(attrs, name)
+		}
+	}
+	filter := strings.ReplaceAll(d.cfg.UserFilter, "{login}", EscapeFilter(login))
+	entries, err := c.search(d.cfg.BaseDN, filter, attrs)
+	var re *ResultError
+	if errors.As(err, &re) && re.Code == resultSizeLimit {
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) != 1 {
+		return nil, ErrInvalidCredentials
+	}
+	entry := entries[0]
+	if err := c.bind(entry.DN, password); err != nil {
+		return nil, err
+	}
+
+	identity := &federation.Identity{
+		Subject:     entry.DN,
+		Username:    entry.first(a.Username),
+		Email:       entry.first(a.Email),
+		FirstName:   entry.first(a.FirstName),
+		LastName:    entry.first(a.LastName),
+		DisplayName: entry.first(a.DisplayName),
+	}
+	if a.Subject != "" {
+		if identity.Subject = entry.first(a.Subject); identity.Subject == "" {
+			return nil, fmt.Errorf("ldap: entry %s has no %s", entry.DN, a.Subject)
+		}
+		if strings.EqualFold(a.Subject, "objectGUID") || !utf8.ValidString(identity.Subject) {
+			identity.Subject = hex.EncodeToString([]byte(identity.Subject))
+		}
+	}
+	if identity.Username == "" {
+		identity.Username = login
+	}
+	identity.EmailVerified = identity.Email != "" && d.cfg.TrustEmail
+	return identity, nil
+}
+
+// first returns the first value of the attribute name, which is matched
+// case-insensitively like LDAP attribute descriptions.
+func (e Entry) first(name string) string {
+	if name == "" {
+		return ""
+	}
+	for k, v := range e.Attributes {
+		if strings.EqualFold(k, name) && len(v) > 0 {
+			return v[0]
+		}
+	}
+	return ""
+}
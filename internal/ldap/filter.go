@@ -0,0 +1,173 @@
+package ldap
+
+import (
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+// Filter choices of a SearchRequest.
+const (
+	filterAnd            = 0
+	filterOr             = 1
+	filterNot            = 2
+	filterEquality       = 3
+	filterSubstrings     = 4
+	filterGreaterOrEqual = 5
+	filterLessOrEqual    = 6
+	filterPresent        = 7
+	filterApprox         = 8
+)
+
+var errFilter = errors.New("ldap: invalid filter")
+
+// EscapeFilter escapes s for use as a value in a filter (RFC 4515).
+func EscapeFilter(s string) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '*', '(', ')', '\\', 0:
+			sb.WriteString(`\` + hex.EncodeToString([]byte{c}))
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String()
+}
+
+// compileFilter encodes the string representation of a filter (RFC 4515):
+// and, or, not, equality, substring, ordering, approximate and presence
+// assertions. Extensible matching is not supported.
+func compileFilter(s string) (*packet, error) {
+	f, rest, err := parseFilter(s, 0)
+	if err != nil {
+		return nil, err
+	}
+	if rest != "" {
+		return nil, errFilter
+	}
+	return f, nil
+}
+
+func parseFilter(s string, depth int) (*packet, string, error) {
+	if depth > 10 || !strings.HasPrefix(s, "(") {
+		return nil, "", errFilter
+	}
+	s = s[1:]
+	if s == "" {
+		return nil, "", errFilter
+	}
+	switch s[0] {
+	case '&', '|':
+		tag := byte(filterAnd)
+		if s[0] == '|' {
+			tag = filterOr
+		}
+		set := sequence(classContext | tag)
+		s = s[1:]
+		for strings.HasPrefix(s, "(") {
+			f, rest, err := parseFilter(s, depth+1)
+			if err != nil {
+				return nil, "", err
+			}
+			set.children = append(set.children, f)
+			s = rest
+		}
+		if len(set.children) == 0 || !strings.HasPrefix(s, ")") {
+			return nil, "", errFilter
+		}
+		return set, s[1:], nil
+	case '!':
+		f, rest, err := parseFilter(s[1:], depth+1)
+		if err != nil || !strings.HasPrefix(rest, ")") {
+			return nil, "", errFilter
+		}
+		return sequence(classContext|filterNot, f), rest[1:], nil
+	}
+
+	end := strings.IndexByte(s, ')')
+	if end < 0 {
+		return nil, "", errFilter
+	}
+	item, rest := s[:end], s[end+1:]
+	eq := strings.IndexByte(item, '=')
+	if eq <= 0 {
+		return nil, "", errFilter
+	}
+	attr, value := item[:eq], item[eq+1:]
+	tag := byte(filterEquality)
+	switch attr[len(attr)-1] {
+	case '>':
+		tag, attr = filterGreaterOrEqual, attr[:len(attr)-1]
+	case '<':
+		tag, attr = filterLessOrEqual, attr[:len(attr)-1]
+	case '~':
+		tag, attr = filterApprox, attr[:len(attr)-1]
+	case ':':
+		return nil, "", errors.New("ldap: extensible match filters are not supported")
+	}
+	if attr == "" || strings.ContainsAny(attr, "()*\\") {
+		return nil, "", errFilter
+	}
+
+	if tag == filterEquality && value == "*" {
+		return primitive(classContext|filterPresent, []byte(attr)), rest, nil
+	}
+	if tag == filterEquality && strings.Contains(value, "*") {
+		parts := strings.Split(value, "*")
+		subs := sequence(tagSequence)
+		for i, part := range parts {
+			if part == "" {
+				continue
+			}
+			v, err := unescapeFilter(part)
+			if err != nil {
+				return nil, "", err
+			}
+			kind := byte(1) // any
+			switch i {
+			case 0:
+				kind = 0 // initial
+			case len(parts) - 1:
+				kind = 2 // final
+			}
+			subs.children = append(subs.children, primitive(classContext|kind, []byte(v)))
+		}
+		if len(subs.children) == 0 {
+			return nil, "", errFilter
+		}
+		return sequence(classContext|filterSubstrings, octetString(attr), subs), rest, nil
+	}
+	if strings.Contains(value, "*") {
+		return nil, "", errFilter
+	}
+	v, err := unescapeFilter(value)
+	if err != nil {
+		return nil, "", err
+	}
+	return sequence(classContext|tag, octetString(attr), octetString(v)), rest, nil
+}
+
+// unescapeFilter decodes the \XX escapes of a filter value.
+func unescapeFilter(s string) (string, error) {
+	if !strings.Contains(s, `\`) {
+		return s, nil
+	}
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			sb.WriteByte(s[i])
+			continue
+		}
+		if i+3 > len(s) {
+			return "", errFilter
+		}
+		b, err := hex.DecodeString(s[i+1 : i+3])
+		if err != nil {
+			return "", errFilter
+		}
+		sb.Write(b)
+		i += 2
+	}
+	return sb.String(), nil
+}
@@ -0,0 +1,204 @@
+package ldap
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/andro-kes/auth_service/internal/federation"
+)
+
+func TestCompileFilter(t *testing.T) {
+	cases := []struct {
+		filter string
+		want   string // hex of the BER encoding
+	}{
+		{"(uid=jane)", "a30b040375696404046a616e65"},
+		{"(objectClass=*)", "870b6f626a656374436c617373"},
+		{"(&(uid=a)(!(cn=b)))", "a015a3080403756964040161a209a3070402636e040162"},
+		{"(cn=a*b*c)", "a40f0402636e3009800161810162820163"},
+		{`(cn=\2a\29)`, "a3080402636e04022a29"},
+	}
+	for _, c := range cases {
+		f, err := compileFilter(c.filter)
+		if err != nil {
+			t.Errorf("%s: %v", c.filter, err)
+			continue
+		}
+		if got := hex.EncodeToString(f.bytes()); got != c.want {
+			t.Errorf("%s: got %s, want %s", c.filter, got, c.want)
+		}
+	}
+	for _, bad := range []string{"", "uid=a", "(uid=a", "(=a)", "(&)", "(uid=a)(cn=b)", `(cn=\2)`, "(cn:dn:=a)"} {
+		if _, err := compileFilter(bad); err == nil {
+			t.Errorf("%q compiled", bad)
+		}
+	}
+	if got := EscapeFilter(`a*(b)\`); got != `a\2a\28b\29\5c` {
+		t.Errorf("EscapeFilter: %s", got)
+	}
+}
+
+// fakeServer is a directory with a service account and the users of
+// entries, whose passwords are "secret".
+type fakeServer struct {
+	net.Listener
+	entries map[string]Entry // by uid
+	// filters are the equality values searched for.
+	filters []string
+}
+
+const (
+	serviceDN       = "cn=svc,dc=example,dc=com"
+	servicePassword = "svc-secret"
+)
+
+func newFakeServer(t *testing.T) *fakeServer {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeServer{Listener: l, entries: map[string]Entry{
+		"jane": {DN: "uid=jane,ou=people,dc=example,dc=com", Attributes: map[string][]string{
+			"entryUUID": {"8a1c0e3e-1111-2222-3333-444455556666"},
+			"uid":       {"jane"},
+			"mail":      {"jane@example.com"},
+			"givenName": {"Jane"},
+			"sn":        {"Doe"},
+		}},
+	}}
+	t.Cleanup(func() { _ = l.Close() })
+	go func() {
+		for {
+			nc, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(nc)
+		}
+	}()
+	return s
+}
+
+func (s *fakeServer) serve(nc net.Conn) {
+	defer nc.Close()
+	r := bufio.NewReader(nc)
+	reply := func(id int64, op *packet) {
+		_, _ = nc.Write(sequence(tagSequence, integer(tagInteger, id), op).bytes())
+	}
+	ldapResult := func(op byte, code int64) *packet {
+		return sequence(classApplication|op, integer(tagEnumerated, code), octetString(""), octetString(""))
+	}
+	for {
+		msg, err := readPacket(r)
+		if err != nil {
+			return
+		}
+		id, op := msg.children[0].int(), msg.children[1]
+		switch op.tag() {
+		case opBindRequest:
+			dn, password := string(op.children[1].value), string(op.children[2].value)
+			code := int64(resultInvalidCreds)
+			if dn == serviceDN && password == servicePassword {
+				code = resultSuccess
+			}
+			for _, e := range s.entries {
+				if dn == e.DN && password == "secret" {
+					code = resultSuccess
+				}
+			}
+			reply(id, ldapResult(opBindResponse, code))
+		case opSearchRequest:
+			// the filters of the tests are (&(objectClass=person)(uid=...))
+			uid := string(op.children[6].children[1].children[1].value)
+			s.filters = append(s.filters, uid)
+			if e, ok := s.entries[uid]; ok {
+				attrs := sequence(tagSequence)
+				for name, values := range e.Attributes {
+					set := sequence(tagSet)
+					for _, v := range values {
+						set.children = append(set.children, octetString(v))
+					}
+					attrs.children = append(attrs.children, sequence(tagSequence, octetString(name), set))
+				}
+				reply(id, sequence(classApplication|opSearchEntry, octetString(e.DN), attrs))
+			}
+			reply(id, ldapResult(opSearchDone, resultSuccess))
+		case opUnbindRequest:
+			return
+		}
+	}
+}
+
+func TestDirectory(t *testing.T) {
+	ctx := context.Background()
+	s := newFakeServer(t)
+	d, err := New(Config{
+		URL:          "ldap://" + s.Addr().String(),
+		BindDN:       serviceDN,
+		BindPassword: servicePassword,
+		BaseDN:       "dc=example,dc=com",
+		UserFilter:   "(&(objectClass=person)(uid={login}))",
+		Attributes:   DefaultAttributes,
+		TrustEmail:   true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	identity, err := d.Authenticate(ctx, "jane", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := federation.Identity{
+		Subject:       "8a1c0e3e-1111-2222-3333-444455556666",
+		Username:      "jane",
+		Email:         "jane@example.com",
+		EmailVerified: true,
+		FirstName:     "Jane",
+		LastName:      "Doe",
+	}
+	if *identity != want {
+		t.Errorf("identity %+v, want %+v", *identity, want)
+	}
+
+	for _, c := range []struct{ login, password string }{
+		{"jane", "wrong"},
+		{"jane", ""},
+		{"nobody", "secret"},
+		{"*", "secret"},
+	} {
+		if _, err := d.Authenticate(ctx, c.login, c.password); !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("%s/%s: %v", c.login, c.password, err)
+		}
+	}
+	// the login is matched literally, not as a pattern
+	if last := s.filters[len(s.filters)-1]; last != "*" {
+		t.Errorf("searched for %q", last)
+	}
+
+	d.cfg.BindPassword = "wrong"
+	if _, err := d.Authenticate(ctx, "jane", "secret"); err == nil || errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("broken service account: %v", err)
+	}
+}
+
+func TestPacket(t *testing.T) {
+	for _, n := range []int64{0, 1, 127, 128, 255, 256, -1, -129, 1 << 40} {
+		p := integer(tagInteger, n)
+		got, err := readPacket(bufio.NewReader(bytes.NewReader(p.bytes())))
+		if err != nil || got.int() != n {
+			t.Errorf("integer %d: got %d, %v", n, got.int(), err)
+		}
+	}
+	long := sequence(tagSequence, octetString(string(make([]byte, 300))), octetString("x"))
+	got, err := readPacket(bufio.NewReader(bytes.NewReader(long.bytes())))
+	if err != nil || len(got.children) != 2 || len(got.children[0].value) != 300 || string(got.children[1].value) != "x" {
+		t.Errorf("long form: %+v, %v", got, err)
+	}
+}
@@ -0,0 +1,46 @@
+package logger
+
+import (
+	"context"
+	"strings"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+type requestIDKey struct{}
+
+// WithRequestID returns a copy of ctx carrying the request ID id.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request ID carried by ctx, or "".
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// FromContext returns the package logger with the request ID of ctx, if
+// any, attached as the "request_id" field.
+func FromContext(ctx context.Context) *zap.Logger {
+	l := Logger()
+	if id := RequestID(ctx); id != "" {
+		l = l.With(zap.String("request_id", id))
+	}
+	return l
+}
+
+// maxRequestIDLen bounds request IDs accepted from callers.
+const maxRequestIDLen = 128
+
+// EnsureRequestID returns id when it is usable as a request ID: non-empty,
+// at most 128 characters and printable ASCII without spaces, so that a
+// caller cannot inject anything into the logs. Otherwise it returns a new
+// random ID.
+func EnsureRequestID(id string) string {
+	if id != "" && len(id) <= maxRequestIDLen && !strings.ContainsFunc(id, func(r rune) bool { return r <= ' ' || r > '~' }) {
+		return id
+	}
+	return uuid.NewString()
+}
@@ -0,0 +1,202 @@
+// Package loginguard slows down repeated failed logins. Consecutive failures
+// are counted per account and per client IP in Redis; once a counter passes
+// the threshold each further attempt is delayed, doubling up to a maximum.
+// Delayed attempts are not rejected, so legitimate users are not locked out
+// while credential-stuffing tools lose their throughput. Only an account
+// that keeps failing past a second, higher threshold is locked for a while.
+package loginguard
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Config controls the backoff curve and the lockout.
+type Config struct {
+	// Threshold is the number of consecutive failures tolerated without delay.
+	Threshold int
+	// BaseDelay is the delay after the first failure past the threshold.
+	BaseDelay time.Duration
+	// MaxDelay caps the delay; 0 disables the backoff.
+	MaxDelay time.Duration
+	// Window is how long failures are remembered after the last one.
+	Window time.Duration
+	// LockoutThreshold is the number of consecutive failures that lock an
+	// account for LockoutDuration; 0 disables the lockout. IP counters
+	// never lock anything.
+	LockoutThreshold int
+	LockoutDuration  time.Duration
+}
+
+// Guard tracks failed logins. A nil *Guard is valid and never delays or
+// locks.
+type Guard struct {
+	rdb redis.UniversalClient
+	cfg Config
+}
+
+// New returns a guard storing counters in rdb, or nil when cfg disables
+// both the backoff and the lockout.
+func New(rdb redis.UniversalClient, cfg Config) *Guard {
+	if (cfg.MaxDelay <= 0 || cfg.BaseDelay <= 0) && (cfg.LockoutThreshold <= 0 || cfg.LockoutDuration <= 0) {
+		return nil
+	}
+	if cfg.MaxDelay <= 0 || cfg.BaseDelay <= 0 {
+		cfg.BaseDelay, cfg.MaxDelay = 0, 0
+	}
+	if cfg.LockoutDuration <= 0 {
+		cfg.LockoutThreshold = 0
+	}
+	return &Guard{rdb: rdb, cfg: cfg}
+}
+
+// Delay returns how long an attempt for account from ip must wait.
+func (g *Guard) Delay(ctx context.Context, account, ip string) (time.Duration, error) {
+	if g == nil || g.cfg.MaxDelay == 0 {
+		return 0, nil
+	}
+	vals, err := g.rdb.MGet(ctx, g.keys(account, ip)...).Result()
+	if err != nil {
+		return 0, err
+	}
+	var worst int64
+	for _, v := range vals {
+		s, ok := v.(string)
+		if !ok {
+			continue
+		}
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err == nil && n > worst {
+			worst = n
+		}
+	}
+	return g.delayFor(worst), nil
+}
+
+// Wait blocks for the delay of the next attempt. It returns ctx.Err() when
+// the caller gives up first.
+func (g *Guard) Wait(ctx context.Context, account, ip string) error {
+	d, err := g.Delay(ctx, account, ip)
+	if err != nil || d == 0 {
+		return err
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Fail records a failed attempt and reports whether it locked account.
+func (g *Guard) Fail(ctx context.Context, account, ip string) (bool, error) {
+	if g == nil {
+		return false, nil
+	}
+	pipe := g.rdb.TxPipeline()
+	var failures *redis.IntCmd
+	for i, key := range g.keys(account, ip) {
+		incr := pipe.Incr(ctx, key)
+		if i == 0 {
+			failures = incr
+		}
+		pipe.Expire(ctx, key, g.cfg.Window)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return false, err
+	}
+	// only the failure that reaches the threshold locks, once
+	if g.cfg.LockoutThreshold == 0 || failures.Val() != int64(g.cfg.LockoutThreshold) {
+		return false, nil
+	}
+	// the count starts over, so that once the lock ends the account locks
+	// again only after another LockoutThreshold failures
+	pipe = g.rdb.TxPipeline()
+	pipe.Set(ctx, lockKey(account), 1, g.cfg.LockoutDuration)
+	pipe.Del(ctx, userKey(account))
+	if _, err := pipe.Exec(ctx); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Locked returns how much longer account stays locked, or 0 when it is not.
+func (g *Guard) Locked(ctx context.Context, account string) (time.Duration, error) {
+	if g == nil || g.cfg.LockoutThreshold == 0 {
+		return 0, nil
+	}
+	d, err := g.rdb.PTTL(ctx, lockKey(account)).Result()
+	if err != nil {
+		return 0, err
+	}
+	// a missing key has a negative TTL
+	return max(d, 0), nil
+}
+
+// LockoutDuration returns how long a lockout lasts, or 0 when it is disabled.
+func (g *Guard) LockoutDuration() time.Duration {
+	if g == nil || g.cfg.LockoutThreshold == 0 {
+		return 0
+	}
+	return g.cfg.LockoutDuration
+}
+
+// Succeed clears the account counter. The IP counter is left alone: a
+// successful login from an IP does not vouch for its other attempts.
+func (g *Guard) Succeed(ctx context.Context, account string) error {
+	if g == nil {
+		return nil
+	}
+	return g.rdb.Del(ctx, userKey(account)).Err()
+}
+
+// Unlock lifts the lock of account and clears its counter.
+func (g *Guard) Unlock(ctx context.Context, account string) error {
+	if g == nil {
+		return nil
+	}
+	return g.rdb.Del(ctx, lockKey(account), userKey(account)).Err()
+}
+
+func (g *Guard) delayFor(failures int64) time.Duration {
+	over := failures - int64(g.cfg.Threshold)
+	if over <= 0 {
+		return 0
+	}
+	d := g.cfg.BaseDelay
+	for i := int64(1); i < over && d < g.cfg.MaxDelay; i++ {
+		d *= 2
+	}
+	return min(d, g.cfg.MaxDelay)
+}
+
+func (g *Guard) keys(account, ip string) []string {
+	keys := []string{userKey(account)}
+	if ip != "" {
+		keys = append(keys, "login:fail:ip:"+ip)
+	}
+	return keys
+}
+
+// userKey hashes the normalized account so attacker-chosen input never
+// becomes a raw Redis key.
+func userKey(account string) string {
+	return "login:fail:user:" + accountHash(account)
+}
+
+func lockKey(account string) string {
+	return "login:lock:user:" + accountHash(account)
+}
+
+func accountHash(account string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(account))))
+	return hex.EncodeToString(sum[:])
+}
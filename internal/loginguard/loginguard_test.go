@@ -0,0 +1,137 @@
+package loginguard
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func newTestGuard(t *testing.T, cfg Config) (*Guard, *miniredis.Miniredis) {
+	t.Helper()
+	srv, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("failed to start miniredis: %v", err)
+	}
+	t.Cleanup(srv.Close)
+	rdb := redis.NewClient(&redis.Options{Addr: srv.Addr()})
+	t.Cleanup(func() { _ = rdb.Close() })
+	return New(rdb, cfg), srv
+}
+
+func TestDelayEscalates(t *testing.T) {
+	g, _ := newTestGuard(t, Config{Threshold: 2, BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second, Window: time.Minute})
+	ctx := context.Background()
+
+	want := []time.Duration{0, 0, 0, 100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond, time.Second, time.Second}
+	for i, w := range want {
+		d, err := g.Delay(ctx, "alice", "10.0.0.1")
+		if err != nil {
+			t.Fatalf("Delay failed: %v", err)
+		}
+		if d != w {
+			t.Fatalf("after %d failures: expected %v, got %v", i, w, d)
+		}
+		if _, err := g.Fail(ctx, "alice", "10.0.0.1"); err != nil {
+			t.Fatalf("Fail failed: %v", err)
+		}
+	}
+}
+
+func TestSucceedResetsAccountOnly(t *testing.T) {
+	g, _ := newTestGuard(t, Config{Threshold: 1, BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second, Window: time.Minute})
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		_, _ = g.Fail(ctx, "Alice", "10.0.0.1")
+	}
+	if err := g.Succeed(ctx, "alice"); err != nil {
+		t.Fatalf("Succeed failed: %v", err)
+	}
+	if d, _ := g.Delay(ctx, "alice", "10.0.0.2"); d != 0 {
+		t.Fatalf("expected account counter to be reset, got %v", d)
+	}
+	if d, _ := g.Delay(ctx, "bob", "10.0.0.1"); d == 0 {
+		t.Fatal("expected the IP counter to survive a successful login")
+	}
+}
+
+func TestWindowExpires(t *testing.T) {
+	g, srv := newTestGuard(t, Config{Threshold: 0, BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second, Window: time.Minute})
+	ctx := context.Background()
+
+	_, _ = g.Fail(ctx, "alice", "")
+	srv.FastForward(2 * time.Minute)
+	if d, _ := g.Delay(ctx, "alice", ""); d != 0 {
+		t.Fatalf("expected failures to be forgotten after the window, got %v", d)
+	}
+}
+
+func TestWaitHonoursContext(t *testing.T) {
+	g, _ := newTestGuard(t, Config{Threshold: 0, BaseDelay: time.Minute, MaxDelay: time.Minute, Window: time.Minute})
+	_, _ = g.Fail(context.Background(), "alice", "")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := g.Wait(ctx, "alice", ""); err != context.DeadlineExceeded {
+		t.Fatalf("expected DeadlineExceeded, got %v", err)
+	}
+}
+
+func TestLockout(t *testing.T) {
+	g, srv := newTestGuard(t, Config{Window: time.Hour, LockoutThreshold: 3, LockoutDuration: 10 * time.Minute})
+	ctx := context.Background()
+	for i := 1; i <= 3; i++ {
+		locked, err := g.Fail(ctx, "alice", "10.0.0.1")
+		if err != nil {
+			t.Fatalf("Fail failed: %v", err)
+		}
+		if locked != (i == 3) {
+			t.Fatalf("after %d failures: expected locked %v, got %v", i, i == 3, locked)
+		}
+	}
+	if d, err := g.Locked(ctx, "Alice"); err != nil || d != 10*time.Minute {
+		t.Fatalf("expected the account to be locked for 10m, got %v, %v", d, err)
+	}
+	if d, _ := g.Locked(ctx, "bob"); d != 0 {
+		t.Fatalf("expected other accounts to stay unlocked, got %v", d)
+	}
+	if d, _ := g.Delay(ctx, "alice", "10.0.0.1"); d != 0 {
+		t.Fatalf("expected no delay with the backoff disabled, got %v", d)
+	}
+
+	// the lock runs out, and the count has started over
+	srv.FastForward(11 * time.Minute)
+	if d, _ := g.Locked(ctx, "alice"); d != 0 {
+		t.Fatalf("expected the lock to run out, got %v", d)
+	}
+	if locked, _ := g.Fail(ctx, "alice", ""); locked {
+		t.Fatal("expected a single failure after the lock not to lock again")
+	}
+
+	_, _ = g.Fail(ctx, "alice", "")
+	if locked, _ := g.Fail(ctx, "alice", ""); !locked {
+		t.Fatal("expected the account to lock again")
+	}
+	if err := g.Unlock(ctx, "alice"); err != nil {
+		t.Fatalf("Unlock failed: %v", err)
+	}
+	if d, _ := g.Locked(ctx, "alice"); d != 0 {
+		t.Fatalf("expected Unlock to lift the lock, got %v", d)
+	}
+}
+
+func TestNilGuard(t *testing.T) {
+	var g *Guard = New(nil, Config{})
+	if g != nil {
+		t.Fatal("expected a disabled config to yield a nil guard")
+	}
+	if err := g.Wait(context.Background(), "alice", ""); err != nil {
+		t.Fatalf("nil guard Wait: %v", err)
+	}
+	if _, err := g.Fail(context.Background(), "alice", ""); err != nil {
+		t.Fatalf("nil guard Fail: %v", err)
+	}
+}
@@ -0,0 +1,87 @@
+// Package metrics defines the Prometheus metrics of the service. They are
+// registered with the default registry and served by Handler.
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "auth"
+
+var (
+	// AccessTokensVerified counts access tokens that passed signature
+	// verification, by signing key role ("current" or "previous").
+	AccessTokensVerified = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "access_tokens_verified_total",
+		Help:      "Access tokens verified, by signing key role.",
+	}, []string{"key"})
+
+	// SigningMigrationCutoff is the Unix time after which tokens signed with
+	// the previous key are rejected; 0 when no migration is in progress.
+	SigningMigrationCutoff = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "signing_migration_cutoff_timestamp_seconds",
+		Help:      "Time after which the previous signing key is no longer accepted.",
+	})
+)
+
+var (
+	// TokensIssued counts issued tokens by type ("access", "refresh",
+	// "scoped" or "service").
+	TokensIssued = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tokens_issued_total",
+		Help:      "Tokens issued, by type.",
+	}, []string{"type"})
+
+	// RefreshRotations counts refresh token rotations by result ("ok",
+	// "invalid", "in_progress" or "error").
+	RefreshRotations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "refresh_rotations_total",
+		Help:      "Refresh token rotations, by result.",
+	}, []string{"result"})
+
+	// Revocations counts revoked tokens and ended sessions by kind
+	// ("refresh", "access" or "session").
+	Revocations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "revocations_total",
+		Help:      "Revoked tokens and sessions, by kind.",
+	}, []string{"kind"})
+
+	// ValidationFailures counts rejected access tokens by reason
+	// ("expired", "invalid", "replayed", "dpop" or "error").
+	ValidationFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "access_token_validation_failures_total",
+		Help:      "Access tokens rejected, by reason.",
+	}, []string{"reason"})
+
+	// RedisCommandDuration observes the latency of Redis commands by
+	// command name; pipelines and transactions are observed as "pipeline".
+	RedisCommandDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "redis_command_duration_seconds",
+		Help:      "Latency of Redis commands, by command.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	}, []string{"command"})
+
+	// RedisDegraded is 1 while the service runs in degraded mode because
+	// Redis is unreachable.
+	RedisDegraded = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "redis_degraded",
+		Help:      "Whether the service runs degraded because Redis is unreachable.",
+	})
+)
+
+// Handler serves the metrics in the Prometheus exposition format.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
@@ -0,0 +1,60 @@
+package metrics
+
+import (
+	"github.com/andro-kes/auth_service/internal/workpool"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// workPoolCollector reports the state of a worker pool as read from its
+// Stats at scrape time.
+type workPoolCollector struct {
+	stats func() workpool.Stats
+
+	queued   *prometheus.Desc
+	inFlight *prometheus.Desc
+	rejected *prometheus.Desc
+	timedOut *prometheus.Desc
+}
+
+func newWorkPoolCollector(name string, stats func() workpool.Stats) *workPoolCollector {
+	labels := prometheus.Labels{"pool": name}
+	return &workPoolCollector{
+		stats: stats,
+		queued: prometheus.NewDesc(prometheus.BuildFQName(namespace, "workpool", "queued"),
+			"Tasks waiting for a worker.", nil, labels),
+		inFlight: prometheus.NewDesc(prometheus.BuildFQName(namespace, "workpool", "in_flight"),
+			"Tasks running.", nil, labels),
+		rejected: prometheus.NewDesc(prometheus.BuildFQName(namespace, "workpool", "rejected_total"),
+			"Tasks rejected because the queue was full.", nil, labels),
+		timedOut: prometheus.NewDesc(prometheus.BuildFQName(namespace, "workpool", "timed_out_total"),
+			"Tasks that timed out waiting for a worker.", nil, labels),
+	}
+}
+
+func (c *workPoolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.queued
+	ch <- c.inFlight
+	ch <- c.rejected
+	ch <- c.timedOut
+}
+
+func (c *workPoolCollector) Collect(ch chan<- prometheus.Metric) {
+	st := c.stats()
+	ch <- prometheus.MustNewConstMetric(c.queued, prometheus.GaugeValue, float64(st.Queued))
+	ch <- prometheus.MustNewConstMetric(c.inFlight, prometheus.GaugeValue, float64(st.InFlight))
+	ch <- prometheus.MustNewConstMetric(c.rejected, prometheus.CounterValue, float64(st.Rejected))
+	ch <- prometheus.MustNewConstMetric(c.timedOut, prometheus.CounterValue, float64(st.TimedOut))
+}
+
+// RegisterWorkPool exports the queue depth, in-flight tasks and rejections
+// of pool with the "pool" label name. A pool registered earlier under the
+// same name is replaced.
+func RegisterWorkPool(name string, pool *workpool.Pool) error {
+	return registerWorkPool(prometheus.DefaultRegisterer, name, pool)
+}
+
+func registerWorkPool(reg prometheus.Registerer, name string, pool *workpool.Pool) error {
+	c := newWorkPoolCollector(name, pool.Stats)
+	reg.Unregister(c)
+	return reg.Register(c)
+}
@@ -0,0 +1,68 @@
+package metrics
+
+import (
+	"context"
+	"testing"
+
+	"github.com/andro-kes/auth_service/internal/workpool"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestRegisterWorkPool(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	pool := workpool.New(workpool.Config{MaxParallel: 1, QueueDepth: 0})
+	if err := registerWorkPool(reg, "hashing", pool); err != nil {
+		t.Fatalf("registerWorkPool failed: %v", err)
+	}
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = pool.Do(context.Background(), func() {
+			close(started)
+			<-release
+		})
+	}()
+	<-started
+	if err := pool.Do(context.Background(), func() {}); err != workpool.ErrSaturated {
+		t.Fatalf("expected the pool to be saturated, got %v", err)
+	}
+
+	got := gatherValues(t, reg)
+	if got["auth_workpool_in_flight"] != 1 || got["auth_workpool_queued"] != 0 || got["auth_workpool_rejected_total"] != 1 {
+		t.Fatalf("unexpected values %v", got)
+	}
+	close(release)
+	<-done
+
+	// a new pool under the same name replaces the old one
+	if err := registerWorkPool(reg, "hashing", workpool.New(workpool.Config{})); err != nil {
+		t.Fatalf("registering the pool again failed: %v", err)
+	}
+	if got := gatherValues(t, reg); got["auth_workpool_rejected_total"] != 0 {
+		t.Fatalf("expected the new pool to be reported, got %v", got)
+	}
+}
+
+// gatherValues returns the value of every metric in reg by name.
+func gatherValues(t *testing.T, reg *prometheus.Registry) map[string]float64 {
+	t.Helper()
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Gather failed: %v", err)
+	}
+	values := make(map[string]float64)
+	for _, f := range families {
+		for _, m := range f.GetMetric() {
+			switch {
+			case m.GetGauge() != nil:
+				values[f.GetName()] = m.GetGauge().GetValue()
+			case m.GetCounter() != nil:
+				values[f.GetName()] = m.GetCounter().GetValue()
+			}
+		}
+	}
+	return values
+}
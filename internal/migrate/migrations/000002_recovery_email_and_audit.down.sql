@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS recovery_emails;
+DROP INDEX IF EXISTS idx_audit_events_user_id;
+DROP TABLE IF EXISTS audit_events;
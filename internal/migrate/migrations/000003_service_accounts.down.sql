@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_service_account_keys_account_id;
+DROP TABLE IF EXISTS service_account_keys;
+DROP TABLE IF EXISTS service_accounts;
@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS service_accounts (
+  id TEXT PRIMARY KEY,
+  name TEXT NOT NULL UNIQUE,
+  allowed_scopes TEXT[] NOT NULL DEFAULT '{}',
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS service_account_keys (
+  id TEXT PRIMARY KEY,
+  account_id TEXT NOT NULL REFERENCES service_accounts (id) ON DELETE CASCADE,
+  public_key TEXT NOT NULL,
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+  revoked_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS idx_service_account_keys_account_id ON service_account_keys (account_id);
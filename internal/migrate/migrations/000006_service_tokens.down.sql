@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_service_tokens_account_id;
+DROP TABLE IF EXISTS service_tokens;
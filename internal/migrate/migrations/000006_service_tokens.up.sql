@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS service_tokens (
+  id TEXT PRIMARY KEY,
+  account_id TEXT NOT NULL REFERENCES service_accounts (id) ON DELETE CASCADE,
+  scope TEXT NOT NULL DEFAULT '',
+  expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+  revoked_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS idx_service_tokens_account_id ON service_tokens (account_id);
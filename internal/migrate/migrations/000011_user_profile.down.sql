@@ -0,0 +1,4 @@
+ALTER TABLE users DROP COLUMN IF EXISTS metadata;
+ALTER TABLE users DROP COLUMN IF EXISTS display_name;
+ALTER TABLE users DROP COLUMN IF EXISTS last_name;
+ALTER TABLE users DROP COLUMN IF EXISTS first_name;
@@ -0,0 +1,2 @@
+-- keyset pagination of ListUsers; the username order uses the unique index
+CREATE INDEX IF NOT EXISTS idx_users_created_at_id ON users (created_at, id) WHERE deleted_at IS NULL;
@@ -0,0 +1,14 @@
+-- Usernames are unique regardless of case. Accounts whose usernames differ
+-- only in case must be renamed before this migration can run.
+DO $$
+DECLARE
+  dups TEXT;
+BEGIN
+  SELECT string_agg(name, ', ') INTO dups
+  FROM (SELECT lower(username) AS name FROM users GROUP BY lower(username) HAVING count(*) > 1) d;
+  IF dups IS NOT NULL THEN
+    RAISE EXCEPTION 'usernames differing only in case: %', dups;
+  END IF;
+END $$;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_username_lower ON users (lower(username));
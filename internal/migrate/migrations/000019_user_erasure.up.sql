@@ -0,0 +1,3 @@
+-- erased users are anonymized tombstones: deleted, but never purged so that
+-- references to them stay valid
+ALTER TABLE users ADD COLUMN IF NOT EXISTS erased_at TIMESTAMP WITH TIME ZONE;
@@ -0,0 +1,4 @@
+UPDATE users SET status = 'disabled' WHERE status = 'pending';
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_status_check;
+ALTER TABLE users ADD CONSTRAINT users_status_check
+  CHECK (status IN ('active', 'disabled', 'banned'));
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS username_changes (
+  id BIGSERIAL PRIMARY KEY,
+  user_id TEXT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+  old_username TEXT NOT NULL,
+  changed_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+  reserved_until TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_username_changes_user ON username_changes (user_id, changed_at);
+CREATE INDEX IF NOT EXISTS idx_username_changes_old ON username_changes (lower(old_username), reserved_until);
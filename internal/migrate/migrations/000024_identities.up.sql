@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS identities (
+  user_id TEXT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+  provider TEXT NOT NULL,
+  subject TEXT NOT NULL,
+  email TEXT,
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+  PRIMARY KEY (provider, subject),
+  CONSTRAINT identities_user_provider_key UNIQUE (user_id, provider)
+);
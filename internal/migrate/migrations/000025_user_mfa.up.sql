@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS user_mfa (
+  user_id TEXT PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+  totp_secret TEXT NOT NULL,
+  enabled_at TIMESTAMP WITH TIME ZONE,
+  last_step BIGINT NOT NULL DEFAULT 0,
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS user_phones (
+  user_id TEXT PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+  phone TEXT NOT NULL,
+  verified_at TIMESTAMP WITH TIME ZONE,
+  mfa_enabled BOOLEAN NOT NULL DEFAULT false,
+  code_purpose TEXT,
+  code_hash TEXT,
+  code_expires_at TIMESTAMP WITH TIME ZONE,
+  code_sent_at TIMESTAMP WITH TIME ZONE,
+  attempts INTEGER NOT NULL DEFAULT 0,
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+  updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);
@@ -0,0 +1 @@
+ALTER TABLE clients DROP COLUMN IF EXISTS scopes, DROP COLUMN IF EXISTS secret_hash;
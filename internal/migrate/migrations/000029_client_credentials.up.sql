@@ -0,0 +1,3 @@
+ALTER TABLE clients
+  ADD COLUMN IF NOT EXISTS secret_hash TEXT NOT NULL DEFAULT '',
+  ADD COLUMN IF NOT EXISTS scopes TEXT[] NOT NULL DEFAULT '{}';
@@ -0,0 +1,6 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS password_set BOOLEAN NOT NULL DEFAULT true;
+
+-- users provisioned by a federated login got a random password
+UPDATE users SET password_set = false
+WHERE password_changed_at IS NULL
+  AND id IN (SELECT user_id FROM audit_events WHERE type = 'user.provisioned');
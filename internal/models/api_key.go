@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// APIKey is a long-lived secret a user hands to an integration that cannot
+// run an OAuth flow. It acts for the user within Scopes. The key itself is
+// not stored, only the SHA-256 of its secret part.
+type APIKey struct {
+	ID         string     `json:"id" db:"id"`
+	UserID     string     `json:"user_id" db:"user_id"`
+	Name       string     `json:"name" db:"name"`
+	SecretHash string     `json:"-" db:"secret_hash"`
+	Scopes     []string   `json:"scopes" db:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty" db:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty" db:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at" db:"created_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty" db:"revoked_at"`
+}
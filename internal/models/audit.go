@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// AuditEvent is a security-relevant action recorded for later review.
+type AuditEvent struct {
+	Type      string            `json:"type" db:"type"`
+	UserID    string            `json:"user_id,omitempty" db:"user_id"`
+	IP        string            `json:"ip,omitempty" db:"ip"`
+	UserAgent string            `json:"user_agent,omitempty" db:"user_agent"`
+	Details   map[string]string `json:"details,omitempty" db:"details"`
+	CreatedAt time.Time         `json:"created_at" db:"created_at"`
+}
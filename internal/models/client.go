@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// Client is a registered application, such as a frontend, that users log in
+// through. Access tokens issued for it carry its Audience as "aud".
+// Confidential clients have a secret and obtain tokens for their Scopes on
+// their own behalf with the client credentials grant.
+type Client struct {
+	ID       string `json:"id" db:"id"`
+	Name     string `json:"name" db:"name"`
+	Audience string `json:"audience" db:"audience"`
+	// SecretHash is the SHA-256 of the secret; empty for public clients.
+	SecretHash string    `json:"-" db:"secret_hash"`
+	Scopes     []string  `json:"scopes" db:"scopes"`
+	CreatedAt  time.Time `json:"created_at" db:"created_at"`
+}
@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// Identity links a user to an account at an external identity provider,
+// such as a social login. Subject is the provider's stable user ID.
+type Identity struct {
+	UserID   string `json:"user_id" db:"user_id"`
+	Provider string `json:"provider" db:"provider"`
+	Subject  string `json:"subject" db:"subject"`
+	// Email is the email the provider reported, if any.
+	Email     string    `json:"email,omitempty" db:"email"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// Invite is a registration invite code. Only a hash of the code is stored.
+type Invite struct {
+	ID       string `json:"id" db:"id"`
+	CodeHash string `json:"-" db:"code_hash"`
+	// MaxUses is how many registrations the code admits; Uses counts them.
+	MaxUses   int       `json:"max_uses" db:"max_uses"`
+	Uses      int       `json:"uses" db:"uses"`
+	ExpiresAt time.Time `json:"expires_at" db:"expires_at"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// LoginAttempt is a failed login, kept for brute-force analysis.
+type LoginAttempt struct {
+	// Username is the login as entered, a username or an email.
+	Username  string    `json:"username" db:"username"`
+	IP        string    `json:"ip,omitempty" db:"ip"`
+	Reason    string    `json:"reason" db:"reason"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// MFA is the second factor of a user.
+type MFA struct {
+	UserID     string `json:"user_id" db:"user_id"`
+	TOTPSecret string `json:"-" db:"totp_secret"`
+	// EnabledAt is zero until the enrollment is confirmed with a code.
+	EnabledAt time.Time `json:"enabled_at,omitzero" db:"enabled_at"`
+	// LastStep is the TOTP time step of the last accepted code, which
+	// cannot be used again.
+	LastStep  int64     `json:"-" db:"last_step"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
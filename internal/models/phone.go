@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+// Phone is the phone number of a user, used to receive one-time codes by
+// SMS. It is pending until a code sent to it is confirmed.
+type Phone struct {
+	UserID     string     `json:"user_id" db:"user_id"`
+	Phone      string     `json:"phone" db:"phone"`
+	VerifiedAt *time.Time `json:"verified_at,omitempty" db:"verified_at"`
+	// MFAEnabled makes codes sent to the phone a second factor of logins.
+	MFAEnabled bool `json:"mfa_enabled" db:"mfa_enabled"`
+	// CodePurpose tells what the pending code confirms.
+	CodePurpose   string     `json:"-" db:"code_purpose"`
+	CodeHash      string     `json:"-" db:"code_hash"`
+	CodeExpiresAt *time.Time `json:"-" db:"code_expires_at"`
+	CodeSentAt    *time.Time `json:"-" db:"code_sent_at"`
+	Attempts      int        `json:"-" db:"attempts"`
+}
+
+// Verified reports whether the number has been confirmed.
+func (p *Phone) Verified() bool {
+	return p.VerifiedAt != nil
+}
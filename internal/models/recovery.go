@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// RecoveryEmail is a secondary address used only for account recovery. It
+// is pending until the code sent to it is confirmed.
+type RecoveryEmail struct {
+	UserID        string     `json:"user_id" db:"user_id"`
+	Email         string     `json:"email" db:"email"`
+	VerifiedAt    *time.Time `json:"verified_at,omitempty" db:"verified_at"`
+	CodeHash      string     `json:"-" db:"code_hash"`
+	CodeExpiresAt *time.Time `json:"-" db:"code_expires_at"`
+	Attempts      int        `json:"-" db:"attempts"`
+}
+
+// Verified reports whether the address has been confirmed.
+func (r *RecoveryEmail) Verified() bool {
+	return r.VerifiedAt != nil
+}
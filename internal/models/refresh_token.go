@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// RefreshToken is the durable copy of a refresh token kept in Redis. Hash is
+// the SHA-256 of the raw token; Fields mirrors the Redis hash.
+type RefreshToken struct {
+	Hash      string            `json:"token_hash" db:"token_hash"`
+	UserID    string            `json:"user_id" db:"user_id"`
+	SessionID string            `json:"session_id" db:"session_id"`
+	Fields    map[string]string `json:"fields" db:"fields"`
+	ExpiresAt time.Time         `json:"expires_at" db:"expires_at"`
+}
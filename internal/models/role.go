@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// Role is a named set of permissions assigned to users. The names of a
+// user's roles are carried in their access tokens as "roles".
+type Role struct {
+	Name        string    `json:"name" db:"name"`
+	Description string    `json:"description" db:"description"`
+	Permissions []string  `json:"permissions"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+}
@@ -0,0 +1,32 @@
+package models
+
+import "time"
+
+// ServiceAccount is a machine identity that authenticates with signed
+// assertions instead of a password.
+type ServiceAccount struct {
+	ID            string   `json:"id" db:"id"`
+	Name          string   `json:"name" db:"name"`
+	AllowedScopes []string `json:"allowed_scopes" db:"allowed_scopes"`
+}
+
+// ServiceAccountKey is a registered public key (PEM, PKIX) of a service
+// account. ID is the key's "kid".
+type ServiceAccountKey struct {
+	ID        string     `json:"id" db:"id"`
+	AccountID string     `json:"account_id" db:"account_id"`
+	PublicKey string     `json:"public_key" db:"public_key"`
+	CreatedAt time.Time  `json:"created_at" db:"created_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty" db:"revoked_at"`
+}
+
+// ServiceToken is a long-lived token minted for a service account. ID is the
+// token's "jti"; the token itself is not stored.
+type ServiceToken struct {
+	ID        string     `json:"id" db:"id"`
+	AccountID string     `json:"account_id" db:"account_id"`
+	Scope     string     `json:"scope" db:"scope"`
+	ExpiresAt time.Time  `json:"expires_at" db:"expires_at"`
+	CreatedAt time.Time  `json:"created_at" db:"created_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty" db:"revoked_at"`
+}
@@ -1,7 +1,46 @@
 package models
 
+import "time"
+
 type User struct {
 	ID       string `json:"id" db:"id"`
 	Username string `json:"username" db:"username"`
+	// Email is an optional, lower-cased login; empty is stored as NULL.
+	Email    string `json:"email,omitempty" db:"email"`
 	Password string `json:"password" db:"password"`
+	// TokenVersion is embedded in access tokens; bumping it invalidates them.
+	TokenVersion int64     `json:"token_version" db:"token_version"`
+	CreatedAt    time.Time `json:"created_at" db:"created_at"`
+	// Status is one of the UserStatus constants; only active users may log
+	// in and use their tokens.
+	Status string `json:"status" db:"status"`
+	// LastLoginAt is zero if the user never logged in.
+	LastLoginAt time.Time `json:"last_login_at,omitzero" db:"last_login_at"`
+	LastLoginIP string    `json:"last_login_ip,omitempty" db:"last_login_ip"`
+	// InviteID is the invite the user registered with. It is only written
+	// on creation.
+	InviteID string `json:"-" db:"invite_id"`
+	// NoPassword marks users created without a password they know, such as
+	// federated ones, until they set one. It is only written on creation.
+	NoPassword bool `json:"-" db:"-"`
+	Profile
+}
+
+// Account statuses.
+const (
+	UserStatusActive   = "active"
+	UserStatusDisabled = "disabled"
+	UserStatusBanned   = "banned"
+	// UserStatusPending is the status of registrations awaiting approval.
+	UserStatusPending = "pending"
+)
+
+// Profile is the basic profile data consuming apps may keep in the auth
+// service instead of a user store of their own.
+type Profile struct {
+	FirstName   string `json:"first_name" db:"first_name"`
+	LastName    string `json:"last_name" db:"last_name"`
+	DisplayName string `json:"display_name" db:"display_name"`
+	// Metadata is free-form JSON owned by the consuming apps.
+	Metadata map[string]any `json:"metadata" db:"metadata"`
 }
@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// UsernameChange records a user's previous username, which stays reserved
+// for them until ReservedUntil.
+type UsernameChange struct {
+	UserID        string    `json:"user_id" db:"user_id"`
+	OldUsername   string    `json:"old_username" db:"old_username"`
+	ChangedAt     time.Time `json:"changed_at" db:"changed_at"`
+	ReservedUntil time.Time `json:"reserved_until" db:"reserved_until"`
+}
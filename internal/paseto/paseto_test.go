@@ -0,0 +1,77 @@
+package paseto
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+// Test vector 4-S-1 of the PASETO specification.
+func TestSignVector(t *testing.T) {
+	seed, _ := hex.DecodeString("b4cbfb43df4ce210727d953e4a713307fa19bb7d9f85041438d9e11b942a3774")
+	key := ed25519.NewKeyFromSeed(seed)
+	payload := []byte(`{"data":"this is a signed message","exp":"2022-01-01T00:00:00+00:00"}`)
+	want := "v4.public.eyJkYXRhIjoidGhpcyBpcyBhIHNpZ25lZCBtZXNzYWdlIiwiZXhwIjoiMjAyMi0wMS0wMVQwMDowMDowMCswMDowMCJ9bg_XBBzds8lTZShVlwwKSgeKpLT3yukTw6JUz3W4h_ExsQV-P0V54zemZDcAxFaSeef1QlXEFtkqxT1ciiQEDA"
+
+	if got := Sign(key, payload, nil); got != want {
+		t.Fatalf("Sign = %s, want %s", got, want)
+	}
+	got, footer, err := Verify(key.Public().(ed25519.PublicKey), want)
+	if err != nil || !bytes.Equal(got, payload) || footer != nil {
+		t.Fatalf("Verify = %s, %q, %v", got, footer, err)
+	}
+}
+
+func TestLocalRoundTrip(t *testing.T) {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	payload := []byte(`{"uid":"alice"}`)
+	token, err := Encrypt(rand.Reader, key, payload, []byte("kid-1"))
+	if err != nil {
+		t.Fatalf("Encrypt failed: %v", err)
+	}
+	if !strings.HasPrefix(token, LocalHeader) || strings.Contains(token, "alice") {
+		t.Fatalf("unexpected token %q", token)
+	}
+
+	got, footer, err := Decrypt(key, token)
+	if err != nil || !bytes.Equal(got, payload) || string(footer) != "kid-1" {
+		t.Fatalf("Decrypt = %s, %q, %v", got, footer, err)
+	}
+
+	other := bytes.Clone(key)
+	other[0] ^= 1
+	if _, _, err := Decrypt(other, token); err != ErrInvalidToken {
+		t.Fatalf("expected wrong key to fail, got %v", err)
+	}
+	body, _, _ := strings.Cut(token, "."+b64.EncodeToString([]byte("kid-1")))
+	if _, _, err := Decrypt(key, body+"."+b64.EncodeToString([]byte("kid-2"))); err != ErrInvalidToken {
+		t.Fatalf("expected swapped footer to fail, got %v", err)
+	}
+	if _, _, err := Decrypt(key, strings.Replace(token, LocalHeader, PublicHeader, 1)); err != ErrInvalidToken {
+		t.Fatalf("expected wrong purpose to fail, got %v", err)
+	}
+}
+
+func TestVerifyRejectsTampering(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := Sign(priv, []byte(`{"uid":"alice"}`), nil)
+	forged := Sign(priv, []byte(`{"uid":"mallory"}`), nil)
+	// graft the signature of one token onto the payload of another
+	tampered := forged[:len(PublicHeader)+20] + token[len(PublicHeader)+20:]
+	if _, _, err := Verify(pub, tampered); err != ErrInvalidToken {
+		t.Fatalf("expected tampered token to fail, got %v", err)
+	}
+	otherPub, _, _ := ed25519.GenerateKey(rand.Reader)
+	if _, _, err := Verify(otherPub, token); err != ErrInvalidToken {
+		t.Fatalf("expected wrong key to fail, got %v", err)
+	}
+}
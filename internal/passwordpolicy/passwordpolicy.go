@@ -0,0 +1,210 @@
+// Package passwordpolicy checks new passwords against the rules of a
+// deployment: length bounds, required character classes, a list of banned
+// passwords and a minimum strength score. Check reports every rule a
+// password breaks, so that clients can show them all at once.
+package passwordpolicy
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Rules reported in violations.
+const (
+	RuleMinLength = "min_length"
+	RuleMaxLength = "max_length"
+	RuleCharClass = "character_classes"
+	RuleBanned    = "banned"
+	RuleUserInput = "user_input"
+	RuleMinScore  = "strength"
+)
+
+// bcryptMaxBytes is the longest password bcrypt hashes in full.
+const bcryptMaxBytes = 72
+
+// commonPasswords are always banned.
+var commonPasswords = []string{
+	"password", "password1", "passw0rd", "123456", "12345678", "123456789",
+	"1234567890", "qwerty", "qwerty123", "qwertyuiop", "111111", "000000",
+	"abc123", "iloveyou", "letmein", "welcome", "admin", "dragon",
+	"monkey", "football", "sunshine", "princess", "changeme",
+}
+
+// Config holds the rules. Zero values disable a rule.
+type Config struct {
+	// MinLength is the minimum number of characters.
+	MinLength int
+	// MaxLength is the maximum number of bytes; bcrypt ignores anything past
+	// 72 bytes, which is the cap when MaxLength is 0 or larger.
+	MaxLength int
+	// MinClasses is how many of lower case, upper case, digits and symbols
+	// a password must mix.
+	MinClasses int
+	// Banned are rejected regardless of case, on top of a built-in list of
+	// the most common passwords.
+	Banned []string
+	// MinScore is the minimum strength from 1 (weak) to 4 (very strong).
+	MinScore int
+}
+
+// Violation is a rule a password breaks.
+type Violation struct {
+	Rule        string
+	Description string
+}
+
+// StrengthFunc scores a password from 0 to 4, the scale of zxcvbn.
+// userInputs are words the password is weak against, e.g. the username.
+type StrengthFunc func(password string, userInputs []string) int
+
+// Policy checks passwords. A nil *Policy accepts every password.
+type Policy struct {
+	cfg    Config
+	banned map[string]struct{}
+	// Strength scores passwords for MinScore; it defaults to Estimate and
+	// may be replaced, e.g. with zxcvbn.
+	Strength StrengthFunc
+}
+
+// New returns a policy enforcing cfg.
+func New(cfg Config) *Policy {
+	if cfg.MaxLength <= 0 || cfg.MaxLength > bcryptMaxBytes {
+		cfg.MaxLength = bcryptMaxBytes
+	}
+	p := &Policy{
+		cfg:      cfg,
+		banned:   make(map[string]struct{}, len(commonPasswords)+len(cfg.Banned)),
+		Strength: Estimate,
+	}
+	for _, list := range [][]string{commonPasswords, cfg.Banned} {
+		for _, b := range list {
+			p.banned[strings.ToLower(b)] = struct{}{}
+		}
+	}
+	return p
+}
+
+// Check returns the rules password breaks; none means it is accepted.
+// userInputs, such as the username and email, must not be the password.
+func (p *Policy) Check(password string, userInputs ...string) []Violation {
+	if p == nil {
+		return nil
+	}
+	var vs []Violation
+	if n := utf8.RuneCountInString(password); n < p.cfg.MinLength {
+		vs = append(vs, Violation{RuleMinLength, fmt.Sprintf("must be at least %d characters long", p.cfg.MinLength)})
+	}
+	if len(password) > p.cfg.MaxLength {
+		vs = append(vs, Violation{RuleMaxLength, fmt.Sprintf("must be at most %d bytes long", p.cfg.MaxLength)})
+	}
+	if n := classes(password); n < p.cfg.MinClasses {
+		vs = append(vs, Violation{RuleCharClass, fmt.Sprintf("must mix at least %d of lower case, upper case, digits and symbols", p.cfg.MinClasses)})
+	}
+	lower := strings.ToLower(password)
+	if _, ok := p.banned[lower]; ok {
+		vs = append(vs, Violation{RuleBanned, "is too common"})
+	}
+	for _, in := range userInputs {
+		if in != "" && strings.EqualFold(in, password) {
+			vs = append(vs, Violation{RuleUserInput, "must differ from the username and email"})
+			break
+		}
+	}
+	if p.cfg.MinScore > 0 && p.Strength(password, userInputs) < p.cfg.MinScore {
+		vs = append(vs, Violation{RuleMinScore, "is too easy to guess"})
+	}
+	return vs
+}
+
+// ReadList reads banned passwords, one per line; blank lines and lines
+// starting with # are skipped.
+func ReadList(r io.Reader) ([]string, error) {
+	var list []string
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		list = append(list, line)
+	}
+	return list, sc.Err()
+}
+
+// Estimate is a coarse strength score from the size of the character pool a
+// password draws from and its length, discounting repeated characters and
+// user inputs it contains. It maps about 28, 36, 60 and 80 bits of entropy to
+// scores 1 to 4.
+func Estimate(password string, userInputs []string) int {
+	lower := strings.ToLower(password)
+	for _, in := range userInputs {
+		if len(in) >= 3 {
+			lower = strings.ReplaceAll(lower, strings.ToLower(in), "")
+		}
+	}
+	pool := 0
+	for class, present := range charClasses(password) {
+		if present {
+			pool += classSizes[class]
+		}
+	}
+	if pool == 0 {
+		return 0
+	}
+
+	// each run of a repeated character counts once
+	length := 0
+	var prev rune = -1
+	for _, r := range lower {
+		if r != prev {
+			length++
+		}
+		prev = r
+	}
+	bits := float64(length) * math.Log2(float64(pool))
+	score := 0
+	for _, threshold := range []float64{28, 36, 60, 80} {
+		if bits >= threshold {
+			score++
+		}
+	}
+	return score
+}
+
+// classSizes are the sizes of the character classes of charClasses, with
+// symbols counted as printable ASCII punctuation.
+var classSizes = [4]int{26, 26, 10, 33}
+
+// charClasses reports which of lower case, upper case, digits and symbols
+// password contains.
+func charClasses(password string) [4]bool {
+	var present [4]bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			present[0] = true
+		case unicode.IsUpper(r):
+			present[1] = true
+		case unicode.IsDigit(r):
+			present[2] = true
+		default:
+			present[3] = true
+		}
+	}
+	return present
+}
+
+func classes(password string) int {
+	n := 0
+	for _, present := range charClasses(password) {
+		if present {
+			n++
+		}
+	}
+	return n
+}
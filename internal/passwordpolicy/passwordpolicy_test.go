@@ -0,0 +1,76 @@
+package passwordpolicy
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func rules(vs []Violation) []string {
+	var out []string
+	for _, v := range vs {
+		out = append(out, v.Rule)
+	}
+	return out
+}
+
+func TestCheck(t *testing.T) {
+	p := New(Config{MinLength: 10, MinClasses: 3, Banned: []string{"CorrectHorse1"}, MinScore: 3})
+
+	tests := []struct {
+		password string
+		inputs   []string
+		want     []string
+	}{
+		{"Tr0ub4dor&3xyz", nil, nil},
+		{"short", nil, []string{RuleMinLength, RuleCharClass, RuleMinScore}},
+		{"correcthorse1", nil, []string{RuleCharClass, RuleBanned}},
+		{"Password1", nil, []string{RuleMinLength, RuleBanned, RuleMinScore}},
+		{"Alice.Example1", []string{"alice.example1"}, []string{RuleUserInput, RuleMinScore}},
+		{strings.Repeat("Ab1!", 19), nil, []string{RuleMaxLength}},
+	}
+	for _, tt := range tests {
+		if got := rules(p.Check(tt.password, tt.inputs...)); !slices.Equal(got, tt.want) {
+			t.Errorf("Check(%q) = %v, want %v", tt.password, got, tt.want)
+		}
+	}
+}
+
+func TestCheckNilPolicy(t *testing.T) {
+	var p *Policy
+	if vs := p.Check(""); vs != nil {
+		t.Fatalf("expected nil policy to accept everything, got %v", vs)
+	}
+}
+
+func TestEstimate(t *testing.T) {
+	tests := []struct {
+		password string
+		want     int
+	}{
+		{"", 0},
+		{"aaaaaaaaaaaa", 0},
+		{"abcdefg", 1},
+		{"abcdefgh", 2},
+		{"Tr0ub4dor&3", 3},
+		{"correct horse battery staple", 4},
+	}
+	for _, tt := range tests {
+		if got := Estimate(tt.password, nil); got != tt.want {
+			t.Errorf("Estimate(%q) = %d, want %d", tt.password, got, tt.want)
+		}
+	}
+	if got := Estimate("aliceAlice2024", []string{"alice"}); got >= Estimate("xqzvwAlice2024", nil) {
+		t.Errorf("expected user inputs to lower the score, got %d", got)
+	}
+}
+
+func TestReadList(t *testing.T) {
+	list, err := ReadList(strings.NewReader("# common\nhunter2\n\n  trustno1  \n"))
+	if err != nil {
+		t.Fatalf("ReadList failed: %v", err)
+	}
+	if !slices.Equal(list, []string{"hunter2", "trustno1"}) {
+		t.Fatalf("unexpected list %v", list)
+	}
+}
@@ -0,0 +1,119 @@
+// Package ratelimit counts requests per key in fixed windows stored in Redis
+// and describes the outcome as a Quota that can be reported back to clients,
+// so they can back off before and after being rejected.
+package ratelimit
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"google.golang.org/grpc/metadata"
+)
+
+// Metadata keys under which a quota is reported in gRPC trailers. The REST
+// gateway sends them as HTTP headers of the same name.
+const (
+	PolicyKey    = "x-ratelimit-policy"
+	LimitKey     = "x-ratelimit-limit"
+	RemainingKey = "x-ratelimit-remaining"
+	// ResetKey holds the seconds until the window restarts.
+	ResetKey = "x-ratelimit-reset"
+)
+
+// Quota is the state of one limit after a request was counted.
+type Quota struct {
+	// Policy names the limit, e.g. "login" or "sessions".
+	Policy string
+	Limit  int
+	// Remaining is how many more requests fit before Reset; 0 once exhausted.
+	Remaining int
+	// Reset is when the window restarts. Zero for limits without a window.
+	Reset time.Time
+	// Exceeded is set when the counted request is over the limit.
+	Exceeded bool
+}
+
+// nearFraction is the share of the limit below which a quota counts as
+// nearly exhausted.
+const nearFraction = 0.1
+
+// Near reports whether the quota is exceeded or close to it, i.e. worth
+// telling the client about.
+func (q Quota) Near() bool {
+	return q.Exceeded || float64(q.Remaining) <= float64(q.Limit)*nearFraction
+}
+
+// RetryAfter is how long a rejected client should wait.
+func (q Quota) RetryAfter() time.Duration {
+	if q.Reset.IsZero() {
+		return 0
+	}
+	return max(time.Until(q.Reset), 0)
+}
+
+// Metadata renders q for gRPC trailers.
+func (q Quota) Metadata() metadata.MD {
+	md := metadata.Pairs(
+		PolicyKey, q.Policy,
+		LimitKey, strconv.Itoa(q.Limit),
+		RemainingKey, strconv.Itoa(q.Remaining),
+	)
+	if !q.Reset.IsZero() {
+		// round up so that clients never retry early
+		secs := (q.RetryAfter() + time.Second - 1) / time.Second
+		md.Set(ResetKey, strconv.FormatInt(int64(secs), 10))
+	}
+	return md
+}
+
+// Limiter allows Limit requests per key and Window. A nil *Limiter is valid
+// and allows everything.
+type Limiter struct {
+	rdb    redis.UniversalClient
+	policy string
+	limit  int
+	window time.Duration
+}
+
+// New returns a limiter named policy, or nil when limit or window is not
+// positive.
+func New(rdb redis.UniversalClient, policy string, limit int, window time.Duration) *Limiter {
+	if limit <= 0 || window <= 0 {
+		return nil
+	}
+	return &Limiter{rdb: rdb, policy: policy, limit: limit, window: window}
+}
+
+// takeScript increments the window counter, starting the window on the first
+// request, and returns the count and the window's remaining milliseconds.
+var takeScript = redis.NewScript(`
+local n = redis.call("INCR", KEYS[1])
+if n == 1 then
+  redis.call("PEXPIRE", KEYS[1], ARGV[1])
+end
+return {n, redis.call("PTTL", KEYS[1])}
+`)
+
+// Take counts a request for key. The zero Quota is returned by a nil limiter.
+func (l *Limiter) Take(ctx context.Context, key string) (Quota, error) {
+	if l == nil {
+		return Quota{}, nil
+	}
+	res, err := takeScript.Run(ctx, l.rdb, []string{"ratelimit:" + l.policy + ":" + key}, l.window.Milliseconds()).Int64Slice()
+	if err != nil {
+		return Quota{}, err
+	}
+	count, ttl := int(res[0]), time.Duration(res[1])*time.Millisecond
+	if ttl < 0 {
+		ttl = l.window
+	}
+	return Quota{
+		Policy:    l.policy,
+		Limit:     l.limit,
+		Remaining: max(l.limit-count, 0),
+		Reset:     time.Now().Add(ttl),
+		Exceeded:  count > l.limit,
+	}, nil
+}
@@ -0,0 +1,59 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestTake(t *testing.T) {
+	srv, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("failed to start miniredis: %v", err)
+	}
+	defer srv.Close()
+	rdb := redis.NewClient(&redis.Options{Addr: srv.Addr()})
+	defer rdb.Close()
+
+	l := New(rdb, "login", 10, time.Minute)
+	ctx := context.Background()
+
+	for i := 1; i <= 11; i++ {
+		q, err := l.Take(ctx, "10.0.0.1")
+		if err != nil {
+			t.Fatalf("Take failed: %v", err)
+		}
+		if q.Remaining != max(10-i, 0) || q.Exceeded != (i > 10) {
+			t.Fatalf("request %d: unexpected quota %+v", i, q)
+		}
+		if q.Near() != (i >= 9) {
+			t.Fatalf("request %d: expected Near()=%v", i, i >= 9)
+		}
+		if d := q.RetryAfter(); d <= 0 || d > time.Minute {
+			t.Fatalf("request %d: unexpected retry after %v", i, d)
+		}
+	}
+
+	if q, _ := l.Take(ctx, "10.0.0.2"); q.Remaining != 9 {
+		t.Fatalf("expected keys to be counted separately, got %+v", q)
+	}
+
+	srv.FastForward(time.Minute)
+	if q, _ := l.Take(ctx, "10.0.0.1"); q.Exceeded || q.Remaining != 9 {
+		t.Fatalf("expected a fresh window, got %+v", q)
+	}
+}
+
+func TestNilLimiter(t *testing.T) {
+	var l *Limiter
+	q, err := l.Take(context.Background(), "any")
+	if err != nil || q.Exceeded {
+		t.Fatalf("expected nil limiter to allow, got %+v, %v", q, err)
+	}
+	if New(nil, "login", 0, time.Minute) != nil {
+		t.Fatal("expected a zero limit to disable the limiter")
+	}
+}
@@ -0,0 +1,115 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/andro-kes/auth_service/internal/autherr"
+	"github.com/andro-kes/auth_service/internal/models"
+	"github.com/andro-kes/auth_service/internal/repo/db"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// APIKeyRepo stores the API keys of users.
+type APIKeyRepo interface {
+	Create(ctx context.Context, q db.Querier, key *models.APIKey) error
+	FindByID(ctx context.Context, id string) (*models.APIKey, error)
+	// ListByUser returns the keys of userID that are not revoked, newest
+	// first.
+	ListByUser(ctx context.Context, userID string) ([]models.APIKey, error)
+	// Revoke revokes the key id of userID and reports whether it was
+	// active.
+	Revoke(ctx context.Context, q db.Querier, userID, id string) (bool, error)
+	// Touch sets the key's last use to at unless it was used after since,
+	// so that busy keys are not written on every request.
+	Touch(ctx context.Context, id string, at, since time.Time) error
+}
+
+type apiKeyRepo struct {
+	pool *pgxpool.Pool
+}
+
+func NewAPIKeyRepo(ctx context.Context, pool *pgxpool.Pool) APIKeyRepo {
+	return &apiKeyRepo{
+		pool: pool,
+	}
+}
+
+var apiKeyColumns = []string{"id", "user_id", "name", "secret_hash", "scopes", "expires_at", "last_used_at", "created_at", "revoked_at"}
+
+func (ar *apiKeyRepo) Create(ctx context.Context, q db.Querier, key *models.APIKey) error {
+	sql, args, err := db.NewInsertBuilder(ctx, ar.pool).
+		Into("api_keys").
+		Columns("id", "user_id", "name", "secret_hash", "scopes", "expires_at").
+		Values(key.ID, key.UserID, key.Name, key.SecretHash, key.Scopes, key.ExpiresAt).
+		Build()
+	if err != nil {
+		return err
+	}
+	_, err = q.Exec(ctx, sql, args...)
+	return err
+}
+
+func (ar *apiKeyRepo) FindByID(ctx context.Context, id string) (*models.APIKey, error) {
+	rows, err := db.NewSelectBuilder(ctx, ar.pool).
+		Select(apiKeyColumns...).
+		From("api_keys").
+		Where("id = ?", id).
+		Limit(1).
+		Query()
+	if err != nil {
+		return nil, err
+	}
+	key, err := pgx.CollectExactlyOneRow(rows, pgx.RowToStructByPos[models.APIKey])
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, autherr.ErrNotFound
+		}
+		return nil, err
+	}
+	return &key, nil
+}
+
+func (ar *apiKeyRepo) ListByUser(ctx context.Context, userID string) ([]models.APIKey, error) {
+	rows, err := db.NewSelectBuilder(ctx, ar.pool).
+		Select(apiKeyColumns...).
+		From("api_keys").
+		Where("user_id = ?", userID).
+		Where("revoked_at IS NULL").
+		OrderBy("created_at DESC", "id").
+		Query()
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowToStructByPos[models.APIKey])
+}
+
+func (ar *apiKeyRepo) Revoke(ctx context.Context, q db.Querier, userID, id string) (bool, error) {
+	sql, args, err := db.NewUpdateBuilder(ctx, ar.pool).
+		Table("api_keys").
+		Set("revoked_at", time.Now()).
+		Where("id = ?", id).
+		Where("user_id = ?", userID).
+		Where("revoked_at IS NULL").
+		Build()
+	if err != nil {
+		return false, err
+	}
+	tag, err := q.Exec(ctx, sql, args...)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+func (ar *apiKeyRepo) Touch(ctx context.Context, id string, at, since time.Time) error {
+	_, err := db.NewUpdateBuilder(ctx, ar.pool).
+		Table("api_keys").
+		Set("last_used_at", at).
+		Where("id = ?", id).
+		Where("(last_used_at IS NULL OR last_used_at < ?)", since).
+		Exec()
+	return err
+}
@@ -0,0 +1,86 @@
+package repo
+
+import (
+	"context"
+
+	"github.com/andro-kes/auth_service/internal/models"
+	"github.com/andro-kes/auth_service/internal/repo/db"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type AuditRepo interface {
+	Insert(ctx context.Context, q db.Querier, event *models.AuditEvent) error
+	// ListByUser returns the events of userID, oldest first.
+	ListByUser(ctx context.Context, userID string) ([]models.AuditEvent, error)
+	// Anonymize clears the client data and details of the events of userID,
+	// keeping their types and times.
+	Anonymize(ctx context.Context, q db.Querier, userID string) error
+}
+
+type auditRepo struct {
+	pool *pgxpool.Pool
+}
+
+func NewAuditRepo(ctx context.Context, pool *pgxpool.Pool) AuditRepo {
+	return &auditRepo{
+		pool: pool,
+	}
+}
+
+func (ar *auditRepo) Insert(ctx context.Context, q db.Querier, event *models.AuditEvent) error {
+	details := event.Details
+	if details == nil {
+		details = map[string]string{}
+	}
+	ib := db.NewInsertBuilder(ctx, ar.pool).
+		Into("audit_events").
+		Columns("type", "user_id", "ip", "user_agent", "details").
+		Values(event.Type, nullIfEmpty(event.UserID), nullIfEmpty(event.IP), nullIfEmpty(event.UserAgent), details)
+
+	sql, args, err := ib.Build()
+	if err != nil {
+		return err
+	}
+	_, err = q.Exec(ctx, sql, args...)
+	return err
+}
+
+func (ar *auditRepo) ListByUser(ctx context.Context, userID string) ([]models.AuditEvent, error) {
+	rows, err := db.NewSelectBuilder(ctx, ar.pool).
+		Select("type", "COALESCE(user_id, '')", "COALESCE(ip, '')", "COALESCE(user_agent, '')", "details", "created_at").
+		From("audit_events").
+		Where("user_id = ?", userID).
+		OrderBy("created_at", "id").
+		Query()
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (models.AuditEvent, error) {
+		var e models.AuditEvent
+		err := row.Scan(&e.Type, &e.UserID, &e.IP, &e.UserAgent, &e.Details, &e.CreatedAt)
+		return e, err
+	})
+}
+
+func (ar *auditRepo) Anonymize(ctx context.Context, q db.Querier, userID string) error {
+	sql, args, err := db.NewUpdateBuilder(ctx, ar.pool).
+		Table("audit_events").
+		SetExpr("ip", "NULL").
+		SetExpr("user_agent", "NULL").
+		SetExpr("details", "'{}'").
+		Where("user_id = ?", userID).
+		Build()
+	if err != nil {
+		return err
+	}
+	_, err = q.Exec(ctx, sql, args...)
+	return err
+}
+
+func nullIfEmpty(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
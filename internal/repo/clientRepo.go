@@ -0,0 +1,57 @@
+package repo
+
+import (
+	"context"
+	"errors"
+
+	"github.com/andro-kes/auth_service/internal/autherr"
+	"github.com/andro-kes/auth_service/internal/models"
+	"github.com/andro-kes/auth_service/internal/repo/db"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type ClientRepo interface {
+	Create(ctx context.Context, q db.Querier, client *models.Client) error
+	FindByID(ctx context.Context, id string) (*models.Client, error)
+}
+
+type clientRepo struct {
+	pool *pgxpool.Pool
+}
+
+func NewClientRepo(ctx context.Context, pool *pgxpool.Pool) ClientRepo {
+	return &clientRepo{
+		pool: pool,
+	}
+}
+
+func (cr *clientRepo) Create(ctx context.Context, q db.Querier, client *models.Client) error {
+	sql, args, err := db.NewInsertBuilder(ctx, cr.pool).
+		Into("clients").
+		Columns("id", "name", "audience", "secret_hash", "scopes").
+		Values(client.ID, client.Name, client.Audience, client.SecretHash, client.Scopes).
+		Build()
+	if err != nil {
+		return err
+	}
+	_, err = q.Exec(ctx, sql, args...)
+	return err
+}
+
+func (cr *clientRepo) FindByID(ctx context.Context, id string) (*models.Client, error) {
+	sb := db.NewSelectBuilder(ctx, cr.pool).
+		Select("id", "name", "audience", "secret_hash", "scopes", "created_at").
+		From("clients").
+		Where("id = ?", id).
+		Limit(1)
+
+	var c models.Client
+	if err := sb.QueryRow().Scan(&c.ID, &c.Name, &c.Audience, &c.SecretHash, &c.Scopes, &c.CreatedAt); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, autherr.ErrNotFound
+		}
+		return nil, err
+	}
+	return &c, nil
+}
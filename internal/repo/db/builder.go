@@ -225,10 +225,11 @@ func (s *SelectBuilder) Exec() (pgconn.CommandTag, error) {
 type InsertBuilder struct {
 	baseBuilder
 
-	table     string
-	columns   []string
-	values    [][]interface{} // multiple rows support
-	returning []string
+	table      string
+	columns    []string
+	values     [][]interface{} // multiple rows support
+	onConflict string
+	returning  []string
 }
 
 func NewInsertBuilder(ctx context.Context, pool *pgxpool.Pool) *InsertBuilder {
@@ -256,6 +257,14 @@ func (i *InsertBuilder) Values(vals ...interface{}) *InsertBuilder {
 	return i
 }
 
+// OnConflict appends an ON CONFLICT clause, e.g.
+// OnConflict("(id) DO UPDATE SET name = EXCLUDED.name"). The clause is used
+// verbatim and must not contain placeholders.
+func (i *InsertBuilder) OnConflict(clause string) *InsertBuilder {
+	i.onConflict = clause
+	return i
+}
+
 func (i *InsertBuilder) Returning(cols ...string) *InsertBuilder {
 	i.returning = append(i.returning, cols...)
 	return i
@@ -299,6 +308,10 @@ func (i *InsertBuilder) Build() (string, []any, error) {
 		i.addArgs(row...)
 	}
 	b.WriteString(strings.Join(rowsFragments, ", "))
+	if i.onConflict != "" {
+		b.WriteString(" ON CONFLICT ")
+		b.WriteString(i.onConflict)
+	}
 	if len(i.returning) > 0 {
 		b.WriteString(" RETURNING ")
 		b.WriteString(strings.Join(i.returning, ", "))
@@ -354,6 +367,13 @@ func (u *UpdateBuilder) Set(col string, value interface{}) *UpdateBuilder {
 	return u
 }
 
+// SetExpr adds a "col = expr" pair with expr written verbatim, e.g. to
+// increment a counter. expr must not contain user input.
+func (u *UpdateBuilder) SetExpr(col, expr string) *UpdateBuilder {
+	u.sets = append(u.sets, fmt.Sprintf("%s = %s", col, expr))
+	return u
+}
+
 func (u *UpdateBuilder) Where(cond string, args ...interface{}) *UpdateBuilder {
 	fragment, err := u.replaceQuestionPlaceholders(cond, args...)
 	if err != nil {
@@ -0,0 +1,79 @@
+package db
+
+import (
+	"context"
+	"testing"
+)
+
+func BenchmarkSelectBuilderBuild(b *testing.B) {
+	ctx := context.Background()
+
+	b.ReportAllocs()
+	for b.Loop() {
+		sb := NewSelectBuilder(ctx, nil).
+			Select("id", "username", "password").
+			From("users").
+			Where("username = ?", "alice").
+			Where("created_at > ?", "2024-01-01").
+			OrderBy("created_at DESC").
+			Limit(10)
+		if sql, _ := sb.Build(); sql == "" {
+			b.Fatal("empty SQL")
+		}
+	}
+}
+
+func BenchmarkInsertBuilderBuild(b *testing.B) {
+	ctx := context.Background()
+
+	b.ReportAllocs()
+	for b.Loop() {
+		ib := NewInsertBuilder(ctx, nil).
+			Into("users").
+			Columns("id", "username", "password").
+			Values("id-1", "alice", "hash").
+			Returning("id")
+		if _, _, err := ib.Build(); err != nil {
+			b.Fatalf("Build failed: %v", err)
+		}
+	}
+}
+
+func TestInsertBuilderOnConflict(t *testing.T) {
+	sql, args, err := NewInsertBuilder(context.Background(), nil).
+		Into("recovery_emails").
+		Columns("user_id", "email").
+		Values("u1", "a@example.com").
+		OnConflict("(user_id) DO UPDATE SET email = EXCLUDED.email").
+		Returning("user_id").
+		Build()
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	want := "INSERT INTO recovery_emails (user_id, email) VALUES ($1, $2) ON CONFLICT (user_id) DO UPDATE SET email = EXCLUDED.email RETURNING user_id"
+	if sql != want {
+		t.Fatalf("unexpected SQL:\n got %s\nwant %s", sql, want)
+	}
+	if len(args) != 2 {
+		t.Fatalf("expected 2 args, got %d", len(args))
+	}
+}
+
+func TestUpdateBuilderSetExpr(t *testing.T) {
+	sql, args, err := NewUpdateBuilder(context.Background(), nil).
+		Table("users").
+		SetExpr("token_version", "token_version + 1").
+		Where("id = ?", "u1").
+		Returning("token_version").
+		Build()
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	want := "UPDATE users SET token_version = token_version + 1 WHERE id = $1 RETURNING token_version"
+	if sql != want {
+		t.Fatalf("unexpected SQL:\n got %s\nwant %s", sql, want)
+	}
+	if len(args) != 1 {
+		t.Fatalf("expected 1 arg, got %d", len(args))
+	}
+}
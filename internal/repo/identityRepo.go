@@ -0,0 +1,145 @@
+package repo
+
+import (
+	"context"
+	"errors"
+
+	"github.com/andro-kes/auth_service/internal/autherr"
+	"github.com/andro-kes/auth_service/internal/models"
+	"github.com/andro-kes/auth_service/internal/repo/db"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// IdentityUserProviderKey is the constraint allowing one identity per
+// provider and user.
+const IdentityUserProviderKey = "identities_user_provider_key"
+
+// IdentityRepo stores the links between users and external identities.
+type IdentityRepo interface {
+	Create(ctx context.Context, q db.Querier, identity *models.Identity) error
+	// FindBySubject returns the identity of subject at provider.
+	FindBySubject(ctx context.Context, provider, subject string) (*models.Identity, error)
+	// ListByUser returns the identities of userID, oldest first.
+	ListByUser(ctx context.Context, userID string) ([]models.Identity, error)
+	// Delete removes the identity of userID at provider, or returns
+	// ErrNotFound.
+	Delete(ctx context.Context, q db.Querier, userID, provider string) error
+	DeleteByUser(ctx context.Context, q db.Querier, userID string) error
+	// LoginMethods locks the user for the rest of the transaction and
+	// returns whether they set a password and how many identities they
+	// have, or ErrNotFound.
+	LoginMethods(ctx context.Context, q db.Querier, userID string) (passwordSet bool, identities int, err error)
+}
+
+type identityRepo struct {
+	pool *pgxpool.Pool
+}
+
+func NewIdentityRepo(ctx context.Context, pool *pgxpool.Pool) IdentityRepo {
+	return &identityRepo{
+		pool: pool,
+	}
+}
+
+var identityColumns = []string{"user_id", "provider", "subject", "COALESCE(email, '')", "created_at"}
+
+func (ir *identityRepo) Create(ctx context.Context, q db.Querier, identity *models.Identity) error {
+	sql, args, err := db.NewInsertBuilder(ctx, ir.pool).
+		Into("identities").
+		Columns("user_id", "provider", "subject", "email").
+		Values(identity.UserID, identity.Provider, identity.Subject, nullIfEmpty(identity.Email)).
+		Build()
+	if err != nil {
+		return err
+	}
+	_, err = q.Exec(ctx, sql, args...)
+	return err
+}
+
+func (ir *identityRepo) FindBySubject(ctx context.Context, provider, subject string) (*models.Identity, error) {
+	rows, err := db.NewSelectBuilder(ctx, ir.pool).
+		Select(identityColumns...).
+		From("identities").
+		Where("provider = ?", provider).
+		Where("subject = ?", subject).
+		Limit(1).
+		Query()
+	if err != nil {
+		return nil, err
+	}
+	identity, err := pgx.CollectExactlyOneRow(rows, pgx.RowToStructByPos[models.Identity])
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, autherr.ErrNotFound
+		}
+		return nil, err
+	}
+	return &identity, nil
+}
+
+func (ir *identityRepo) ListByUser(ctx context.Context, userID string) ([]models.Identity, error) {
+	rows, err := db.NewSelectBuilder(ctx, ir.pool).
+		Select(identityColumns...).
+		From("identities").
+		Where("user_id = ?", userID).
+		OrderBy("created_at", "provider").
+		Query()
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowToStructByPos[models.Identity])
+}
+
+func (ir *identityRepo) Delete(ctx context.Context, q db.Querier, userID, provider string) error {
+	sql, args, err := db.NewDeleteBuilder(ctx, ir.pool).
+		From("identities").
+		Where("user_id = ?", userID).
+		Where("provider = ?", provider).
+		Build()
+	if err != nil {
+		return err
+	}
+	tag, err := q.Exec(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return autherr.ErrNotFound
+	}
+	return nil
+}
+
+func (ir *identityRepo) DeleteByUser(ctx context.Context, q db.Querier, userID string) error {
+	sql, args, err := db.NewDeleteBuilder(ctx, ir.pool).
+		From("identities").
+		Where("user_id = ?", userID).
+		Build()
+	if err != nil {
+		return err
+	}
+	_, err = q.Exec(ctx, sql, args...)
+	return err
+}
+
+// loginMethodsSQL locks the user so that concurrent unlinks see each
+// other's result.
+const loginMethodsSQL = `SELECT u.password_set,
+	(SELECT count(*) FROM identities i WHERE i.user_id = u.id)
+FROM users u
+WHERE u.id = $1 AND u.deleted_at IS NULL
+FOR UPDATE OF u`
+
+func (ir *identityRepo) LoginMethods(ctx context.Context, q db.Querier, userID string) (bool, int, error) {
+	var (
+		passwordSet bool
+		identities  int
+	)
+	if err := q.QueryRow(ctx, loginMethodsSQL, userID).Scan(&passwordSet, &identities); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return false, 0, autherr.ErrNotFound
+		}
+		return false, 0, err
+	}
+	return passwordSet, identities, nil
+}
@@ -0,0 +1,65 @@
+package repo
+
+import (
+	"context"
+	"errors"
+
+	"github.com/andro-kes/auth_service/internal/autherr"
+	"github.com/andro-kes/auth_service/internal/models"
+	"github.com/andro-kes/auth_service/internal/repo/db"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// InviteRepo stores registration invite codes.
+type InviteRepo interface {
+	Create(ctx context.Context, q db.Querier, invite *models.Invite) error
+	// Use counts a registration against the unexpired invite with codeHash
+	// that has uses left and returns its ID, or ErrNotFound.
+	Use(ctx context.Context, q db.Querier, codeHash string) (string, error)
+}
+
+type inviteRepo struct {
+	pool *pgxpool.Pool
+}
+
+func NewInviteRepo(ctx context.Context, pool *pgxpool.Pool) InviteRepo {
+	return &inviteRepo{
+		pool: pool,
+	}
+}
+
+func (ir *inviteRepo) Create(ctx context.Context, q db.Querier, invite *models.Invite) error {
+	sql, args, err := db.NewInsertBuilder(ctx, ir.pool).
+		Into("invites").
+		Columns("id", "code_hash", "max_uses", "expires_at").
+		Values(invite.ID, invite.CodeHash, invite.MaxUses, invite.ExpiresAt).
+		Build()
+	if err != nil {
+		return err
+	}
+	_, err = q.Exec(ctx, sql, args...)
+	return err
+}
+
+func (ir *inviteRepo) Use(ctx context.Context, q db.Querier, codeHash string) (string, error) {
+	sql, args, err := db.NewUpdateBuilder(ctx, ir.pool).
+		Table("invites").
+		SetExpr("uses", "uses + 1").
+		Where("code_hash = ?", codeHash).
+		Where("uses < max_uses").
+		Where("expires_at > now()").
+		Returning("id").
+		Build()
+	if err != nil {
+		return "", err
+	}
+	var id string
+	if err := q.QueryRow(ctx, sql, args...).Scan(&id); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", autherr.ErrNotFound
+		}
+		return "", err
+	}
+	return id, nil
+}
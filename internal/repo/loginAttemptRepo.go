@@ -0,0 +1,88 @@
+package repo
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/andro-kes/auth_service/internal/models"
+	"github.com/andro-kes/auth_service/internal/repo/db"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// LoginAttemptRepo stores failed logins.
+type LoginAttemptRepo interface {
+	Insert(ctx context.Context, attempt *models.LoginAttempt) error
+	// ListByLogin returns the attempts made with any of logins, ignoring
+	// case, oldest first.
+	ListByLogin(ctx context.Context, logins []string) ([]models.LoginAttempt, error)
+	// DeleteByLogin removes the attempts made with any of logins, ignoring
+	// case.
+	DeleteByLogin(ctx context.Context, q db.Querier, logins []string) error
+	// Purge removes attempts made before cutoff.
+	Purge(ctx context.Context, cutoff time.Time) (int64, error)
+}
+
+type loginAttemptRepo struct {
+	pool *pgxpool.Pool
+}
+
+func NewLoginAttemptRepo(ctx context.Context, pool *pgxpool.Pool) LoginAttemptRepo {
+	return &loginAttemptRepo{
+		pool: pool,
+	}
+}
+
+func (lr *loginAttemptRepo) Insert(ctx context.Context, attempt *models.LoginAttempt) error {
+	_, err := db.NewInsertBuilder(ctx, lr.pool).
+		Into("login_attempts").
+		Columns("username", "ip", "reason", "created_at").
+		Values(attempt.Username, nullIfEmpty(attempt.IP), attempt.Reason, attempt.CreatedAt).
+		Exec()
+	return err
+}
+
+func (lr *loginAttemptRepo) ListByLogin(ctx context.Context, logins []string) ([]models.LoginAttempt, error) {
+	rows, err := db.NewSelectBuilder(ctx, lr.pool).
+		Select("username", "COALESCE(ip, '')", "reason", "created_at").
+		From("login_attempts").
+		Where("lower(username) = ANY(?)", lowerAll(logins)).
+		OrderBy("created_at", "id").
+		Query()
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowToStructByPos[models.LoginAttempt])
+}
+
+func (lr *loginAttemptRepo) DeleteByLogin(ctx context.Context, q db.Querier, logins []string) error {
+	sql, args, err := db.NewDeleteBuilder(ctx, lr.pool).
+		From("login_attempts").
+		Where("lower(username) = ANY(?)", lowerAll(logins)).
+		Build()
+	if err != nil {
+		return err
+	}
+	_, err = q.Exec(ctx, sql, args...)
+	return err
+}
+
+func lowerAll(ss []string) []string {
+	lower := make([]string, len(ss))
+	for i, s := range ss {
+		lower[i] = strings.ToLower(s)
+	}
+	return lower
+}
+
+func (lr *loginAttemptRepo) Purge(ctx context.Context, cutoff time.Time) (int64, error) {
+	tag, err := db.NewDeleteBuilder(ctx, lr.pool).
+		From("login_attempts").
+		Where("created_at < ?", cutoff).
+		Exec()
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
@@ -0,0 +1,183 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/andro-kes/auth_service/internal/autherr"
+	"github.com/andro-kes/auth_service/internal/models"
+	"github.com/andro-kes/auth_service/internal/repo/db"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// MFARepo stores the second factors of users.
+type MFARepo interface {
+	// SetTOTP starts an enrollment with secret, replacing an unconfirmed
+	// one. It returns ErrConflict if MFA is already enabled.
+	SetTOTP(ctx context.Context, q db.Querier, userID, secret string) error
+	Find(ctx context.Context, userID string) (*models.MFA, error)
+	// Enable confirms the enrollment.
+	Enable(ctx context.Context, q db.Querier, userID string) error
+	// UseStep records step as the last accepted TOTP step. It reports false
+	// if that or a later step was accepted before.
+	UseStep(ctx context.Context, userID string, step int64) (bool, error)
+	// SetRecoveryCodes replaces the recovery codes of userID with hashes.
+	SetRecoveryCodes(ctx context.Context, q db.Querier, userID string, hashes []string) error
+	// UseRecoveryCode marks the unused code with hash as used. It reports
+	// false if there is no such code.
+	UseRecoveryCode(ctx context.Context, q db.Querier, userID, hash string) (bool, error)
+	// Delete removes the second factors of userID with their recovery codes.
+	Delete(ctx context.Context, q db.Querier, userID string) error
+}
+
+type mfaRepo struct {
+	pool *pgxpool.Pool
+}
+
+func NewMFARepo(ctx context.Context, pool *pgxpool.Pool) MFARepo {
+	return &mfaRepo{
+		pool: pool,
+	}
+}
+
+func (mr *mfaRepo) SetTOTP(ctx context.Context, q db.Querier, userID, secret string) error {
+	sql, args, err := db.NewInsertBuilder(ctx, mr.pool).
+		Into("user_mfa").
+		Columns("user_id", "totp_secret").
+		Values(userID, secret).
+		OnConflict("(user_id) DO UPDATE SET totp_secret = EXCLUDED.totp_secret, last_step = 0, created_at = now() " +
+			"WHERE user_mfa.enabled_at IS NULL").
+		Build()
+	if err != nil {
+		return err
+	}
+	tag, err := q.Exec(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return autherr.ErrConflict
+	}
+	return nil
+}
+
+func (mr *mfaRepo) Find(ctx context.Context, userID string) (*models.MFA, error) {
+	sb := db.NewSelectBuilder(ctx, mr.pool).
+		Select("user_id", "totp_secret", "enabled_at", "last_step", "created_at").
+		From("user_mfa").
+		Where("user_id = ?", userID).
+		Limit(1)
+
+	var (
+		m       models.MFA
+		enabled *time.Time
+	)
+	if err := sb.QueryRow().Scan(&m.UserID, &m.TOTPSecret, &enabled, &m.LastStep, &m.CreatedAt); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, autherr.ErrNotFound
+		}
+		return nil, err
+	}
+	if enabled != nil {
+		m.EnabledAt = *enabled
+	}
+	return &m, nil
+}
+
+func (mr *mfaRepo) Enable(ctx context.Context, q db.Querier, userID string) error {
+	sql, args, err := db.NewUpdateBuilder(ctx, mr.pool).
+		Table("user_mfa").
+		SetExpr("enabled_at", "now()").
+		Where("user_id = ?", userID).
+		Where("enabled_at IS NULL").
+		Build()
+	if err != nil {
+		return err
+	}
+	tag, err := q.Exec(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return autherr.ErrNotFound
+	}
+	return nil
+}
+
+func (mr *mfaRepo) UseStep(ctx context.Context, userID string, step int64) (bool, error) {
+	tag, err := db.NewUpdateBuilder(ctx, mr.pool).
+		Table("user_mfa").
+		Set("last_step", step).
+		Where("user_id = ?", userID).
+		Where("last_step < ?", step).
+		Exec()
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+func (mr *mfaRepo) SetRecoveryCodes(ctx context.Context, q db.Querier, userID string, hashes []string) error {
+	sql, args, err := db.NewDeleteBuilder(ctx, mr.pool).
+		From("mfa_recovery_codes").
+		Where("user_id = ?", userID).
+		Build()
+	if err != nil {
+		return err
+	}
+	if _, err := q.Exec(ctx, sql, args...); err != nil {
+		return err
+	}
+	if len(hashes) == 0 {
+		return nil
+	}
+
+	ib := db.NewInsertBuilder(ctx, mr.pool).
+		Into("mfa_recovery_codes").
+		Columns("user_id", "code_hash")
+	for _, h := range hashes {
+		ib.Values(userID, h)
+	}
+	sql, args, err = ib.Build()
+	if err != nil {
+		return err
+	}
+	_, err = q.Exec(ctx, sql, args...)
+	return err
+}
+
+func (mr *mfaRepo) UseRecoveryCode(ctx context.Context, q db.Querier, userID, hash string) (bool, error) {
+	sql, args, err := db.NewUpdateBuilder(ctx, mr.pool).
+		Table("mfa_recovery_codes").
+		SetExpr("used_at", "now()").
+		Where("user_id = ?", userID).
+		Where("code_hash = ?", hash).
+		Where("used_at IS NULL").
+		Build()
+	if err != nil {
+		return false, err
+	}
+	tag, err := q.Exec(ctx, sql, args...)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+func (mr *mfaRepo) Delete(ctx context.Context, q db.Querier, userID string) error {
+	for _, table := range []string{"mfa_recovery_codes", "user_mfa"} {
+		sql, args, err := db.NewDeleteBuilder(ctx, mr.pool).
+			From(table).
+			Where("user_id = ?", userID).
+			Build()
+		if err != nil {
+			return err
+		}
+		if _, err := q.Exec(ctx, sql, args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
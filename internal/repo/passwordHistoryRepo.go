@@ -0,0 +1,68 @@
+package repo
+
+import (
+	"context"
+
+	"github.com/andro-kes/auth_service/internal/repo/db"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// PasswordHistoryRepo keeps the hashes of passwords users had before.
+type PasswordHistoryRepo interface {
+	Add(ctx context.Context, q db.Querier, userID, hash string) error
+	// Recent returns the n newest hashes of userID, newest first.
+	Recent(ctx context.Context, userID string, n int) ([]string, error)
+	// Trim drops all but the keep newest hashes of userID.
+	Trim(ctx context.Context, q db.Querier, userID string, keep int) error
+}
+
+type passwordHistoryRepo struct {
+	pool *pgxpool.Pool
+}
+
+func NewPasswordHistoryRepo(ctx context.Context, pool *pgxpool.Pool) PasswordHistoryRepo {
+	return &passwordHistoryRepo{
+		pool: pool,
+	}
+}
+
+func (pr *passwordHistoryRepo) Add(ctx context.Context, q db.Querier, userID, hash string) error {
+	sql, args, err := db.NewInsertBuilder(ctx, pr.pool).
+		Into("password_history").
+		Columns("user_id", "password").
+		Values(userID, hash).
+		Build()
+	if err != nil {
+		return err
+	}
+	_, err = q.Exec(ctx, sql, args...)
+	return err
+}
+
+func (pr *passwordHistoryRepo) Recent(ctx context.Context, userID string, n int) ([]string, error) {
+	rows, err := db.NewSelectBuilder(ctx, pr.pool).
+		Select("password").
+		From("password_history").
+		Where("user_id = ?", userID).
+		OrderBy("id DESC").
+		Limit(n).
+		Query()
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowTo[string])
+}
+
+func (pr *passwordHistoryRepo) Trim(ctx context.Context, q db.Querier, userID string, keep int) error {
+	sql, args, err := db.NewDeleteBuilder(ctx, pr.pool).
+		From("password_history").
+		Where("user_id = ?", userID).
+		Where("id NOT IN (SELECT id FROM password_history WHERE user_id = ? ORDER BY id DESC LIMIT ?)", userID, keep).
+		Build()
+	if err != nil {
+		return err
+	}
+	_, err = q.Exec(ctx, sql, args...)
+	return err
+}
@@ -0,0 +1,154 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/andro-kes/auth_service/internal/autherr"
+	"github.com/andro-kes/auth_service/internal/models"
+	"github.com/andro-kes/auth_service/internal/repo/db"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type PhoneRepo interface {
+	Get(ctx context.Context, userID string) (*models.Phone, error)
+	// SetPending stores phone as the user's unverified number, replacing any
+	// previous one. Logins no longer ask for codes sent to it.
+	SetPending(ctx context.Context, q db.Querier, userID, phone string) error
+	// SetCode stores the hash of a code sent for purpose, replacing a
+	// pending one. It returns ErrNotFound if the user has no phone.
+	SetCode(ctx context.Context, q db.Querier, userID, purpose, codeHash string, codeExpiresAt time.Time) error
+	IncrementAttempts(ctx context.Context, q db.Querier, userID string) error
+	// ClearCode removes the pending code once it was accepted.
+	ClearCode(ctx context.Context, q db.Querier, userID string) error
+	MarkVerified(ctx context.Context, q db.Querier, userID string, mfa bool) error
+	Delete(ctx context.Context, q db.Querier, userID string) (bool, error)
+}
+
+type phoneRepo struct {
+	pool *pgxpool.Pool
+}
+
+func NewPhoneRepo(ctx context.Context, pool *pgxpool.Pool) PhoneRepo {
+	return &phoneRepo{
+		pool: pool,
+	}
+}
+
+func (pr *phoneRepo) Get(ctx context.Context, userID string) (*models.Phone, error) {
+	sb := db.NewSelectBuilder(ctx, pr.pool).
+		Select("user_id", "phone", "verified_at", "mfa_enabled", "COALESCE(code_purpose, '')", "COALESCE(code_hash, '')",
+			"code_expires_at", "code_sent_at", "attempts").
+		From("user_phones").
+		Where("user_id = ?", userID).
+		Limit(1)
+
+	var p models.Phone
+	err := sb.QueryRow().Scan(&p.UserID, &p.Phone, &p.VerifiedAt, &p.MFAEnabled, &p.CodePurpose, &p.CodeHash,
+		&p.CodeExpiresAt, &p.CodeSentAt, &p.Attempts)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, autherr.ErrNotFound
+		}
+		return nil, err
+	}
+	return &p, nil
+}
+
+func (pr *phoneRepo) SetPending(ctx context.Context, q db.Querier, userID, phone string) error {
+	ib := db.NewInsertBuilder(ctx, pr.pool).
+		Into("user_phones").
+		Columns("user_id", "phone").
+		Values(userID, phone).
+		OnConflict("(user_id) DO UPDATE SET phone = EXCLUDED.phone, verified_at = NULL, mfa_enabled = false, " +
+			"code_purpose = NULL, code_hash = NULL, code_expires_at = NULL, attempts = 0, updated_at = now()")
+
+	sql, args, err := ib.Build()
+	if err != nil {
+		return err
+	}
+	_, err = q.Exec(ctx, sql, args...)
+	return err
+}
+
+func (pr *phoneRepo) SetCode(ctx context.Context, q db.Querier, userID, purpose, codeHash string, codeExpiresAt time.Time) error {
+	sql, args, err := db.NewUpdateBuilder(ctx, pr.pool).
+		Table("user_phones").
+		Set("code_purpose", purpose).
+		Set("code_hash", codeHash).
+		Set("code_expires_at", codeExpiresAt).
+		Set("attempts", 0).
+		SetExpr("code_sent_at", "now()").
+		SetExpr("updated_at", "now()").
+		Where("user_id = ?", userID).
+		Build()
+	if err != nil {
+		return err
+	}
+	tag, err := q.Exec(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return autherr.ErrNotFound
+	}
+	return nil
+}
+
+func (pr *phoneRepo) IncrementAttempts(ctx context.Context, q db.Querier, userID string) error {
+	_, err := q.Exec(ctx, "UPDATE user_phones SET attempts = attempts + 1, updated_at = now() WHERE user_id = $1", userID)
+	return err
+}
+
+func (pr *phoneRepo) ClearCode(ctx context.Context, q db.Querier, userID string) error {
+	sql, args, err := db.NewUpdateBuilder(ctx, pr.pool).
+		Table("user_phones").
+		Set("code_purpose", nil).
+		Set("code_hash", nil).
+		Set("code_expires_at", nil).
+		Set("attempts", 0).
+		SetExpr("updated_at", "now()").
+		Where("user_id = ?", userID).
+		Build()
+	if err != nil {
+		return err
+	}
+	_, err = q.Exec(ctx, sql, args...)
+	return err
+}
+
+func (pr *phoneRepo) MarkVerified(ctx context.Context, q db.Querier, userID string, mfa bool) error {
+	sql, args, err := db.NewUpdateBuilder(ctx, pr.pool).
+		Table("user_phones").
+		SetExpr("verified_at", "now()").
+		Set("mfa_enabled", mfa).
+		Set("code_purpose", nil).
+		Set("code_hash", nil).
+		Set("code_expires_at", nil).
+		Set("attempts", 0).
+		SetExpr("updated_at", "now()").
+		Where("user_id = ?", userID).
+		Build()
+	if err != nil {
+		return err
+	}
+	_, err = q.Exec(ctx, sql, args...)
+	return err
+}
+
+func (pr *phoneRepo) Delete(ctx context.Context, q db.Querier, userID string) (bool, error) {
+	sql, args, err := db.NewDeleteBuilder(ctx, pr.pool).
+		From("user_phones").
+		Where("user_id = ?", userID).
+		Build()
+	if err != nil {
+		return false, err
+	}
+	tag, err := q.Exec(ctx, sql, args...)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
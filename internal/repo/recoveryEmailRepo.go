@@ -0,0 +1,104 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/andro-kes/auth_service/internal/autherr"
+	"github.com/andro-kes/auth_service/internal/models"
+	"github.com/andro-kes/auth_service/internal/repo/db"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type RecoveryEmailRepo interface {
+	Get(ctx context.Context, userID string) (*models.RecoveryEmail, error)
+	// SetPending stores email as the user's unverified recovery address,
+	// replacing any previous one.
+	SetPending(ctx context.Context, q db.Querier, userID, email, codeHash string, codeExpiresAt time.Time) error
+	MarkVerified(ctx context.Context, q db.Querier, userID string) error
+	IncrementAttempts(ctx context.Context, q db.Querier, userID string) error
+	Delete(ctx context.Context, q db.Querier, userID string) (bool, error)
+}
+
+type recoveryEmailRepo struct {
+	pool *pgxpool.Pool
+}
+
+func NewRecoveryEmailRepo(ctx context.Context, pool *pgxpool.Pool) RecoveryEmailRepo {
+	return &recoveryEmailRepo{
+		pool: pool,
+	}
+}
+
+func (rr *recoveryEmailRepo) Get(ctx context.Context, userID string) (*models.RecoveryEmail, error) {
+	sb := db.NewSelectBuilder(ctx, rr.pool).
+		Select("user_id", "email", "verified_at", "COALESCE(code_hash, '')", "code_expires_at", "attempts").
+		From("recovery_emails").
+		Where("user_id = ?", userID).
+		Limit(1)
+
+	var r models.RecoveryEmail
+	err := sb.QueryRow().Scan(&r.UserID, &r.Email, &r.VerifiedAt, &r.CodeHash, &r.CodeExpiresAt, &r.Attempts)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, autherr.ErrNotFound
+		}
+		return nil, err
+	}
+	return &r, nil
+}
+
+func (rr *recoveryEmailRepo) SetPending(ctx context.Context, q db.Querier, userID, email, codeHash string, codeExpiresAt time.Time) error {
+	ib := db.NewInsertBuilder(ctx, rr.pool).
+		Into("recovery_emails").
+		Columns("user_id", "email", "code_hash", "code_expires_at").
+		Values(userID, email, codeHash, codeExpiresAt).
+		OnConflict("(user_id) DO UPDATE SET email = EXCLUDED.email, verified_at = NULL, " +
+			"code_hash = EXCLUDED.code_hash, code_expires_at = EXCLUDED.code_expires_at, attempts = 0, updated_at = now()")
+
+	sql, args, err := ib.Build()
+	if err != nil {
+		return err
+	}
+	_, err = q.Exec(ctx, sql, args...)
+	return err
+}
+
+func (rr *recoveryEmailRepo) MarkVerified(ctx context.Context, q db.Querier, userID string) error {
+	sql, args, err := db.NewUpdateBuilder(ctx, rr.pool).
+		Table("recovery_emails").
+		Set("verified_at", time.Now()).
+		Set("code_hash", nil).
+		Set("code_expires_at", nil).
+		Set("attempts", 0).
+		Set("updated_at", time.Now()).
+		Where("user_id = ?", userID).
+		Build()
+	if err != nil {
+		return err
+	}
+	_, err = q.Exec(ctx, sql, args...)
+	return err
+}
+
+func (rr *recoveryEmailRepo) IncrementAttempts(ctx context.Context, q db.Querier, userID string) error {
+	_, err := q.Exec(ctx, "UPDATE recovery_emails SET attempts = attempts + 1, updated_at = now() WHERE user_id = $1", userID)
+	return err
+}
+
+func (rr *recoveryEmailRepo) Delete(ctx context.Context, q db.Querier, userID string) (bool, error) {
+	sql, args, err := db.NewDeleteBuilder(ctx, rr.pool).
+		From("recovery_emails").
+		Where("user_id = ?", userID).
+		Build()
+	if err != nil {
+		return false, err
+	}
+	tag, err := q.Exec(ctx, sql, args...)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
@@ -0,0 +1,98 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/andro-kes/auth_service/internal/autherr"
+	"github.com/andro-kes/auth_service/internal/models"
+	"github.com/andro-kes/auth_service/internal/repo/db"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type RefreshTokenRepo interface {
+	// Save inserts the token or replaces the stored copy.
+	Save(ctx context.Context, token *models.RefreshToken) error
+	// Find returns an unexpired token by hash.
+	Find(ctx context.Context, hash string) (*models.RefreshToken, error)
+	Delete(ctx context.Context, hashes ...string) error
+	// DeleteByUser removes every token of userID except those of keepSession.
+	DeleteByUser(ctx context.Context, userID, keepSession string) (int64, error)
+	DeleteExpired(ctx context.Context) (int64, error)
+}
+
+type refreshTokenRepo struct {
+	pool *pgxpool.Pool
+}
+
+func NewRefreshTokenRepo(ctx context.Context, pool *pgxpool.Pool) RefreshTokenRepo {
+	return &refreshTokenRepo{
+		pool: pool,
+	}
+}
+
+func (rr *refreshTokenRepo) Save(ctx context.Context, token *models.RefreshToken) error {
+	_, err := db.NewInsertBuilder(ctx, rr.pool).
+		Into("refresh_tokens").
+		Columns("token_hash", "user_id", "session_id", "fields", "expires_at").
+		Values(token.Hash, token.UserID, token.SessionID, token.Fields, token.ExpiresAt).
+		OnConflict("(token_hash) DO UPDATE SET fields = EXCLUDED.fields, expires_at = EXCLUDED.expires_at").
+		Exec()
+	return err
+}
+
+func (rr *refreshTokenRepo) Find(ctx context.Context, hash string) (*models.RefreshToken, error) {
+	sb := db.NewSelectBuilder(ctx, rr.pool).
+		Select("token_hash", "user_id", "session_id", "fields", "expires_at").
+		From("refresh_tokens").
+		Where("token_hash = ?", hash).
+		Where("expires_at > ?", time.Now()).
+		Limit(1)
+
+	var t models.RefreshToken
+	if err := sb.QueryRow().Scan(&t.Hash, &t.UserID, &t.SessionID, &t.Fields, &t.ExpiresAt); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, autherr.ErrNotFound
+		}
+		return nil, err
+	}
+	return &t, nil
+}
+
+func (rr *refreshTokenRepo) Delete(ctx context.Context, hashes ...string) error {
+	if len(hashes) == 0 {
+		return nil
+	}
+	_, err := db.NewDeleteBuilder(ctx, rr.pool).
+		From("refresh_tokens").
+		Where("token_hash = ANY(?)", hashes).
+		Exec()
+	return err
+}
+
+func (rr *refreshTokenRepo) DeleteByUser(ctx context.Context, userID, keepSession string) (int64, error) {
+	b := db.NewDeleteBuilder(ctx, rr.pool).
+		From("refresh_tokens").
+		Where("user_id = ?", userID)
+	if keepSession != "" {
+		b = b.Where("session_id <> ?", keepSession)
+	}
+	tag, err := b.Exec()
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+func (rr *refreshTokenRepo) DeleteExpired(ctx context.Context) (int64, error) {
+	tag, err := db.NewDeleteBuilder(ctx, rr.pool).
+		From("refresh_tokens").
+		Where("expires_at <= ?", time.Now()).
+		Exec()
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
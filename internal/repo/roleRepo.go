@@ -0,0 +1,170 @@
+package repo
+
+import (
+	"context"
+	"errors"
+
+	"github.com/andro-kes/auth_service/internal/models"
+	"github.com/andro-kes/auth_service/internal/repo/db"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type RoleRepo interface {
+	// Create inserts the role together with its permissions, creating
+	// permissions that do not exist yet.
+	Create(ctx context.Context, q db.Querier, role *models.Role) error
+	Assign(ctx context.Context, q db.Querier, userID, role string) error
+	// Revoke reports whether the user had the role.
+	Revoke(ctx context.Context, q db.Querier, userID, role string) (bool, error)
+	// UserRoles returns the names of the user's roles, sorted.
+	UserRoles(ctx context.Context, userID string) ([]string, error)
+	// HasPermission reports whether any role of the user grants permission.
+	HasPermission(ctx context.Context, userID, permission string) (bool, error)
+	// SetRequireMFA reports whether the role exists.
+	SetRequireMFA(ctx context.Context, q db.Querier, role string, require bool) (bool, error)
+	// RequiresMFA reports whether any role of the user requires MFA.
+	RequiresMFA(ctx context.Context, userID string) (bool, error)
+}
+
+type roleRepo struct {
+	pool *pgxpool.Pool
+}
+
+func NewRoleRepo(ctx context.Context, pool *pgxpool.Pool) RoleRepo {
+	return &roleRepo{
+		pool: pool,
+	}
+}
+
+func (rr *roleRepo) Create(ctx context.Context, q db.Querier, role *models.Role) error {
+	sql, args, err := db.NewInsertBuilder(ctx, rr.pool).
+		Into("roles").
+		Columns("name", "description").
+		Values(role.Name, role.Description).
+		Build()
+	if err != nil {
+		return err
+	}
+	if _, err := q.Exec(ctx, sql, args...); err != nil {
+		return err
+	}
+	if len(role.Permissions) == 0 {
+		return nil
+	}
+
+	perms := db.NewInsertBuilder(ctx, rr.pool).
+		Into("permissions").
+		Columns("name").
+		OnConflict("DO NOTHING")
+	grants := db.NewInsertBuilder(ctx, rr.pool).
+		Into("role_permissions").
+		Columns("role", "permission")
+	for _, p := range role.Permissions {
+		perms.Values(p)
+		grants.Values(role.Name, p)
+	}
+	for _, ib := range []*db.InsertBuilder{perms, grants} {
+		sql, args, err := ib.Build()
+		if err != nil {
+			return err
+		}
+		if _, err := q.Exec(ctx, sql, args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (rr *roleRepo) Assign(ctx context.Context, q db.Querier, userID, role string) error {
+	sql, args, err := db.NewInsertBuilder(ctx, rr.pool).
+		Into("user_roles").
+		Columns("user_id", "role").
+		Values(userID, role).
+		OnConflict("DO NOTHING").
+		Build()
+	if err != nil {
+		return err
+	}
+	_, err = q.Exec(ctx, sql, args...)
+	return err
+}
+
+func (rr *roleRepo) Revoke(ctx context.Context, q db.Querier, userID, role string) (bool, error) {
+	sql, args, err := db.NewDeleteBuilder(ctx, rr.pool).
+		From("user_roles").
+		Where("user_id = ?", userID).
+		Where("role = ?", role).
+		Build()
+	if err != nil {
+		return false, err
+	}
+	tag, err := q.Exec(ctx, sql, args...)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+func (rr *roleRepo) UserRoles(ctx context.Context, userID string) ([]string, error) {
+	rows, err := db.NewSelectBuilder(ctx, rr.pool).
+		Select("role").
+		From("user_roles").
+		Where("user_id = ?", userID).
+		OrderBy("role").
+		Query()
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowTo[string])
+}
+
+func (rr *roleRepo) HasPermission(ctx context.Context, userID, permission string) (bool, error) {
+	var one int
+	err := db.NewSelectBuilder(ctx, rr.pool).
+		Select("1").
+		From("user_roles ur").
+		Join("JOIN role_permissions rp ON rp.role = ur.role").
+		Where("ur.user_id = ?", userID).
+		Where("rp.permission = ?", permission).
+		Limit(1).
+		QueryRow().
+		Scan(&one)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (rr *roleRepo) SetRequireMFA(ctx context.Context, q db.Querier, role string, require bool) (bool, error) {
+	sql, args, err := db.NewUpdateBuilder(ctx, rr.pool).
+		Table("roles").
+		Set("require_mfa", require).
+		Where("name = ?", role).
+		Build()
+	if err != nil {
+		return false, err
+	}
+	tag, err := q.Exec(ctx, sql, args...)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+func (rr *roleRepo) RequiresMFA(ctx context.Context, userID string) (bool, error) {
+	var one int
+	err := db.NewSelectBuilder(ctx, rr.pool).
+		Select("1").
+		From("user_roles ur").
+		Join("JOIN roles r ON r.name = ur.role").
+		Where("ur.user_id = ?", userID).
+		Where("r.require_mfa").
+		Limit(1).
+		QueryRow().
+		Scan(&one)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	return err == nil, err
+}
@@ -0,0 +1,162 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/andro-kes/auth_service/internal/autherr"
+	"github.com/andro-kes/auth_service/internal/models"
+	"github.com/andro-kes/auth_service/internal/repo/db"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type ServiceAccountRepo interface {
+	Create(ctx context.Context, q db.Querier, account *models.ServiceAccount) error
+	FindByID(ctx context.Context, id string) (*models.ServiceAccount, error)
+	AddKey(ctx context.Context, q db.Querier, key *models.ServiceAccountKey) error
+	// ActiveKeys returns the account's keys that are not revoked.
+	ActiveKeys(ctx context.Context, accountID string) ([]models.ServiceAccountKey, error)
+	RevokeKey(ctx context.Context, q db.Querier, accountID, keyID string) (bool, error)
+	AddToken(ctx context.Context, q db.Querier, token *models.ServiceToken) error
+	FindToken(ctx context.Context, id string) (*models.ServiceToken, error)
+	RevokeToken(ctx context.Context, q db.Querier, accountID, tokenID string) (bool, error)
+}
+
+type serviceAccountRepo struct {
+	pool *pgxpool.Pool
+}
+
+func NewServiceAccountRepo(ctx context.Context, pool *pgxpool.Pool) ServiceAccountRepo {
+	return &serviceAccountRepo{
+		pool: pool,
+	}
+}
+
+func (sr *serviceAccountRepo) Create(ctx context.Context, q db.Querier, account *models.ServiceAccount) error {
+	sql, args, err := db.NewInsertBuilder(ctx, sr.pool).
+		Into("service_accounts").
+		Columns("id", "name", "allowed_scopes").
+		Values(account.ID, account.Name, account.AllowedScopes).
+		Build()
+	if err != nil {
+		return err
+	}
+	_, err = q.Exec(ctx, sql, args...)
+	return err
+}
+
+func (sr *serviceAccountRepo) FindByID(ctx context.Context, id string) (*models.ServiceAccount, error) {
+	sb := db.NewSelectBuilder(ctx, sr.pool).
+		Select("id", "name", "allowed_scopes").
+		From("service_accounts").
+		Where("id = ?", id).
+		Limit(1)
+
+	var a models.ServiceAccount
+	if err := sb.QueryRow().Scan(&a.ID, &a.Name, &a.AllowedScopes); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, autherr.ErrNotFound
+		}
+		return nil, err
+	}
+	return &a, nil
+}
+
+func (sr *serviceAccountRepo) AddKey(ctx context.Context, q db.Querier, key *models.ServiceAccountKey) error {
+	sql, args, err := db.NewInsertBuilder(ctx, sr.pool).
+		Into("service_account_keys").
+		Columns("id", "account_id", "public_key").
+		Values(key.ID, key.AccountID, key.PublicKey).
+		Build()
+	if err != nil {
+		return err
+	}
+	_, err = q.Exec(ctx, sql, args...)
+	return err
+}
+
+func (sr *serviceAccountRepo) ActiveKeys(ctx context.Context, accountID string) ([]models.ServiceAccountKey, error) {
+	rows, err := db.NewSelectBuilder(ctx, sr.pool).
+		Select("id", "account_id", "public_key", "created_at").
+		From("service_account_keys").
+		Where("account_id = ?", accountID).
+		Where("revoked_at IS NULL").
+		OrderBy("created_at").
+		Query()
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (models.ServiceAccountKey, error) {
+		var k models.ServiceAccountKey
+		err := row.Scan(&k.ID, &k.AccountID, &k.PublicKey, &k.CreatedAt)
+		return k, err
+	})
+}
+
+func (sr *serviceAccountRepo) RevokeKey(ctx context.Context, q db.Querier, accountID, keyID string) (bool, error) {
+	sql, args, err := db.NewUpdateBuilder(ctx, sr.pool).
+		Table("service_account_keys").
+		Set("revoked_at", time.Now()).
+		Where("id = ?", keyID).
+		Where("account_id = ?", accountID).
+		Where("revoked_at IS NULL").
+		Build()
+	if err != nil {
+		return false, err
+	}
+	tag, err := q.Exec(ctx, sql, args...)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+func (sr *serviceAccountRepo) AddToken(ctx context.Context, q db.Querier, token *models.ServiceToken) error {
+	sql, args, err := db.NewInsertBuilder(ctx, sr.pool).
+		Into("service_tokens").
+		Columns("id", "account_id", "scope", "expires_at").
+		Values(token.ID, token.AccountID, token.Scope, token.ExpiresAt).
+		Build()
+	if err != nil {
+		return err
+	}
+	_, err = q.Exec(ctx, sql, args...)
+	return err
+}
+
+func (sr *serviceAccountRepo) FindToken(ctx context.Context, id string) (*models.ServiceToken, error) {
+	sb := db.NewSelectBuilder(ctx, sr.pool).
+		Select("id", "account_id", "scope", "expires_at", "created_at", "revoked_at").
+		From("service_tokens").
+		Where("id = ?", id).
+		Limit(1)
+
+	var t models.ServiceToken
+	if err := sb.QueryRow().Scan(&t.ID, &t.AccountID, &t.Scope, &t.ExpiresAt, &t.CreatedAt, &t.RevokedAt); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, autherr.ErrNotFound
+		}
+		return nil, err
+	}
+	return &t, nil
+}
+
+func (sr *serviceAccountRepo) RevokeToken(ctx context.Context, q db.Querier, accountID, tokenID string) (bool, error) {
+	sql, args, err := db.NewUpdateBuilder(ctx, sr.pool).
+		Table("service_tokens").
+		Set("revoked_at", time.Now()).
+		Where("id = ?", tokenID).
+		Where("account_id = ?", accountID).
+		Where("revoked_at IS NULL").
+		Build()
+	if err != nil {
+		return false, err
+	}
+	tag, err := q.Exec(ctx, sql, args...)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
@@ -3,17 +3,58 @@ package repo
 import (
 	"context"
 	"errors"
+	"fmt"
+	"strings"
+	"time"
 
 	"github.com/andro-kes/auth_service/internal/autherr"
 	"github.com/andro-kes/auth_service/internal/models"
 	"github.com/andro-kes/auth_service/internal/repo/db"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 )
 
 type UserRepo interface {
 	Create(ctx context.Context, q db.Querier, user *models.User) (string, error)
+	// FindByUsername ignores the case of username.
 	FindByUsername(ctx context.Context, username string) (*models.User, error)
+	FindByEmail(ctx context.Context, email string) (*models.User, error)
+	FindByID(ctx context.Context, id string) (*models.User, error)
+	// UpdatePassword replaces the hash of an unchanged password, e.g. with
+	// a stronger one; ChangePassword sets a new password.
+	UpdatePassword(ctx context.Context, q db.Querier, id, hash string) error
+	// ChangePassword sets a new password hash and password_changed_at, and
+	// marks the password as set by the user.
+	ChangePassword(ctx context.Context, q db.Querier, id, hash string) error
+	// PasswordChangedAt returns when the password was last changed, or the
+	// zero time.
+	PasswordChangedAt(ctx context.Context, id string) (time.Time, error)
+	// UpdateProfile sets the profile columns listed in columns, e.g.
+	// "first_name" or "metadata", and returns the updated user.
+	UpdateProfile(ctx context.Context, q db.Querier, id string, profile *models.Profile, columns []string) (*models.User, error)
+	SetStatus(ctx context.Context, q db.Querier, id, status string) error
+	// ChangeUsername returns ErrUserExists if username is taken.
+	ChangeUsername(ctx context.Context, q db.Querier, id, username string) error
+	// RecordLogin stores the time and client IP of a successful login.
+	RecordLogin(ctx context.Context, id string, at time.Time, ip string) error
+	// List returns up to query.Limit users matching query, in its order.
+	List(ctx context.Context, query UserQuery) ([]*models.User, error)
+	// Search returns up to limit users whose username or email starts with
+	// text or, if fuzzy, resembles it, best matches first.
+	Search(ctx context.Context, text string, fuzzy bool, limit int) ([]*models.User, error)
+	// SoftDelete marks the user deleted; deleted users are not found anymore.
+	SoftDelete(ctx context.Context, q db.Querier, id string) error
+	// PurgeDeleted removes users deleted before cutoff, except erased ones.
+	PurgeDeleted(ctx context.Context, cutoff time.Time) (int64, error)
+	// Erase anonymizes the user, deleted or not, and marks them deleted and
+	// erased. It returns the username and email the user had.
+	Erase(ctx context.Context, q db.Querier, id string) (username, email string, err error)
+	// TokenVersion returns the user's current token version.
+	TokenVersion(ctx context.Context, id string) (int64, error)
+	// BumpTokenVersion increments the token version and returns the new one.
+	BumpTokenVersion(ctx context.Context, id string) (int64, error)
 }
 
 type userRepo struct {
@@ -29,8 +70,8 @@ func NewUserRepo(ctx context.Context, pool *pgxpool.Pool) UserRepo {
 func (ur *userRepo) Create(ctx context.Context, q db.Querier, user *models.User) (string, error) {
 	ib := db.NewInsertBuilder(ctx, ur.pool).
 		Into("users").
-		Columns("id", "username", "password").
-		Values(user.ID, user.Username, user.Password).
+		Columns("id", "username", "email", "password", "password_set", "status", "invite_id").
+		Values(user.ID, user.Username, nullable(user.Email), user.Password, !user.NoPassword, userStatus(user.Status), nullable(user.InviteID)).
 		Returning("id")
 
 	sql, args, err := ib.Build()
@@ -40,29 +81,432 @@ func (ur *userRepo) Create(ctx context.Context, q db.Querier, user *models.User)
 
 	var userId string
 	if err := q.QueryRow(ctx, sql, args...).Scan(&userId); err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+			return "", userExists(pgErr.ConstraintName)
+		}
 		return "", err
 	}
 
 	return userId, nil
 }
 
+// userStatus defaults an empty status to active.
+func userStatus(status string) string {
+	if status == "" {
+		return models.UserStatusActive
+	}
+	return status
+}
+
+// uniqueViolation is the Postgres error code of unique constraint violations.
+const uniqueViolation = "23505"
+
+// userExists returns ErrUserExists naming the field whose unique constraint
+// was violated, so that clients can tell "username taken" from "email taken".
+func userExists(constraint string) error {
+	field := "username"
+	if constraint == "idx_users_email" {
+		field = "email"
+	}
+	return autherr.ErrUserExists.WithMessage(field + " already taken").WithDetails(&errdetails.BadRequest{
+		FieldViolations: []*errdetails.BadRequest_FieldViolation{{
+			Field:       field,
+			Reason:      "taken",
+			Description: field + " is already taken",
+		}},
+	})
+}
+
 func (ur *userRepo) FindByUsername(ctx context.Context, username string) (*models.User, error) {
+	// usernames are case-insensitive; idx_users_username_lower serves this
+	return ur.findBy(ctx, "lower(username) = lower(?)", username)
+}
+
+func (ur *userRepo) FindByEmail(ctx context.Context, email string) (*models.User, error) {
+	return ur.findBy(ctx, "email = ?", email)
+}
+
+func (ur *userRepo) FindByID(ctx context.Context, id string) (*models.User, error) {
+	return ur.findBy(ctx, "id = ?", id)
+}
+
+func (ur *userRepo) findBy(ctx context.Context, where string, arg any) (*models.User, error) {
 	sb := db.NewSelectBuilder(ctx, ur.pool).
-		Select("id", "username", "password").
+		Select(userColumns...).
 		From("users").
-		Where("username = ?", username).
+		Where(where, arg).
+		Where("deleted_at IS NULL").
 		Limit(1)
 
-	row := sb.QueryRow()
+	return scanUser(sb.QueryRow())
+}
+
+var userColumns = []string{"id", "username", "email", "password", "token_version", "created_at", "status",
+	"last_login_at", "last_login_ip", "first_name", "last_name", "display_name", "metadata"}
 
-	var user models.User
-	err := row.Scan(&user.ID, &user.Username, &user.Password)
+func scanUser(row pgx.Row) (*models.User, error) {
+	var (
+		user      models.User
+		email     *string
+		lastLogin *time.Time
+	)
+	err := row.Scan(&user.ID, &user.Username, &email, &user.Password, &user.TokenVersion, &user.CreatedAt, &user.Status,
+		&lastLogin, &user.LastLoginIP, &user.FirstName, &user.LastName, &user.DisplayName, &user.Metadata)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return nil, autherr.ErrNotFound
 		}
 		return nil, err
 	}
+	if email != nil {
+		user.Email = *email
+	}
+	if lastLogin != nil {
+		user.LastLoginAt = *lastLogin
+	}
 
 	return &user, nil
 }
+
+// User orders of UserQuery. Ties are broken by id.
+const (
+	UserOrderCreatedAt = "created_at"
+	UserOrderUsername  = "username"
+)
+
+// UserQuery selects a page of users.
+type UserQuery struct {
+	// UsernamePrefix, Status and CreatedAfter filter when not zero.
+	UsernamePrefix string
+	Status         string
+	CreatedAfter   time.Time
+
+	OrderBy    string // UserOrderCreatedAt if empty
+	Descending bool
+	// After is the last user of the previous page in the same order; the
+	// page starts right after it.
+	After *models.User
+	Limit int
+}
+
+func (ur *userRepo) List(ctx context.Context, query UserQuery) ([]*models.User, error) {
+	sb := db.NewSelectBuilder(ctx, ur.pool).
+		Select(userColumns...).
+		From("users").
+		Where("deleted_at IS NULL")
+	if query.UsernamePrefix != "" {
+		sb.Where(`lower(username) LIKE lower(?) ESCAPE '\'`, escapeLike(query.UsernamePrefix)+"%")
+	}
+	if query.Status != "" {
+		sb.Where("status = ?", query.Status)
+	}
+	if !query.CreatedAfter.IsZero() {
+		sb.Where("created_at > ?", query.CreatedAfter)
+	}
+
+	order := query.OrderBy
+	if order == "" {
+		order = UserOrderCreatedAt
+	}
+	dir, cmp := "ASC", ">"
+	if query.Descending {
+		dir, cmp = "DESC", "<"
+	}
+	if query.After != nil {
+		var key any
+		switch order {
+		case UserOrderCreatedAt:
+			key = query.After.CreatedAt
+		case UserOrderUsername:
+			key = query.After.Username
+		default:
+			return nil, fmt.Errorf("repo: unknown user order %q", order)
+		}
+		// keyset pagination: a row comparison uses the (order, id) index
+		sb.Where(fmt.Sprintf("(%s, id) %s (?, ?)", order, cmp), key, query.After.ID)
+	}
+
+	rows, err := sb.OrderBy(order+" "+dir, "id "+dir).Limit(query.Limit).Query()
+	if err != nil {
+		return nil, err
+	}
+	return collectUsers(rows)
+}
+
+func (ur *userRepo) Search(ctx context.Context, text string, fuzzy bool, limit int) ([]*models.User, error) {
+	sb := db.NewSelectBuilder(ctx, ur.pool).
+		Select(userColumns...).
+		From("users")
+	if fuzzy {
+		// text is the first argument, $1, which the order reuses; % is the
+		// pg_trgm similarity operator
+		sb.Where("(lower(username) % ? OR email % $1)", strings.ToLower(text)).
+			OrderBy("greatest(similarity(lower(username), $1), similarity(coalesce(email, ''), $1)) DESC", "username")
+	} else {
+		pattern := escapeLike(strings.ToLower(text)) + "%"
+		sb.Where(`(lower(username) LIKE ? ESCAPE '\' OR email LIKE ? ESCAPE '\')`, pattern, pattern).
+			OrderBy("lower(username)")
+	}
+
+	rows, err := sb.Where("deleted_at IS NULL").Limit(limit).Query()
+	if err != nil {
+		return nil, err
+	}
+	return collectUsers(rows)
+}
+
+func collectUsers(rows pgx.Rows) ([]*models.User, error) {
+	defer rows.Close()
+	var users []*models.User
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+// escapeLike escapes the LIKE wildcards in s.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+func (ur *userRepo) UpdatePassword(ctx context.Context, q db.Querier, id, hash string) error {
+	return ur.setPassword(ctx, q, db.NewUpdateBuilder(ctx, ur.pool).
+		Table("users").
+		Set("password", hash).
+		Where("id = ?", id).
+		Where("deleted_at IS NULL"))
+}
+
+func (ur *userRepo) ChangePassword(ctx context.Context, q db.Querier, id, hash string) error {
+	return ur.setPassword(ctx, q, db.NewUpdateBuilder(ctx, ur.pool).
+		Table("users").
+		Set("password", hash).
+		Set("password_set", true).
+		SetExpr("password_changed_at", "now()").
+		Where("id = ?", id).
+		Where("deleted_at IS NULL"))
+}
+
+func (ur *userRepo) setPassword(ctx context.Context, q db.Querier, ub *db.UpdateBuilder) error {
+	sql, args, err := ub.Build()
+	if err != nil {
+		return err
+	}
+	tag, err := q.Exec(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return autherr.ErrNotFound
+	}
+	return nil
+}
+
+func (ur *userRepo) PasswordChangedAt(ctx context.Context, id string) (time.Time, error) {
+	sb := db.NewSelectBuilder(ctx, ur.pool).
+		Select("password_changed_at").
+		From("users").
+		Where("id = ?", id).
+		Limit(1)
+
+	var at *time.Time
+	if err := sb.QueryRow().Scan(&at); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return time.Time{}, autherr.ErrNotFound
+		}
+		return time.Time{}, err
+	}
+	if at == nil {
+		return time.Time{}, nil
+	}
+	return *at, nil
+}
+
+func (ur *userRepo) UpdateProfile(ctx context.Context, q db.Querier, id string, profile *models.Profile, columns []string) (*models.User, error) {
+	values := map[string]any{
+		"first_name":   profile.FirstName,
+		"last_name":    profile.LastName,
+		"display_name": profile.DisplayName,
+		"metadata":     profile.Metadata,
+	}
+	ub := db.NewUpdateBuilder(ctx, ur.pool).Table("users")
+	for _, col := range columns {
+		v, ok := values[col]
+		if !ok {
+			return nil, fmt.Errorf("unknown profile column %q", col)
+		}
+		if col == "metadata" && v.(map[string]any) == nil {
+			v = map[string]any{}
+		}
+		ub = ub.Set(col, v)
+	}
+	sql, args, err := ub.
+		Where("id = ?", id).
+		Where("deleted_at IS NULL").
+		Returning(userColumns...).
+		Build()
+	if err != nil {
+		return nil, err
+	}
+	return scanUser(q.QueryRow(ctx, sql, args...))
+}
+
+func (ur *userRepo) SetStatus(ctx context.Context, q db.Querier, id, status string) error {
+	sql, args, err := db.NewUpdateBuilder(ctx, ur.pool).
+		Table("users").
+		Set("status", status).
+		Where("id = ?", id).
+		Where("deleted_at IS NULL").
+		Build()
+	if err != nil {
+		return err
+	}
+	tag, err := q.Exec(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return autherr.ErrNotFound
+	}
+	return nil
+}
+
+func (ur *userRepo) ChangeUsername(ctx context.Context, q db.Querier, id, username string) error {
+	sql, args, err := db.NewUpdateBuilder(ctx, ur.pool).
+		Table("users").
+		Set("username", username).
+		Where("id = ?", id).
+		Where("deleted_at IS NULL").
+		Build()
+	if err != nil {
+		return err
+	}
+	tag, err := q.Exec(ctx, sql, args...)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+			return userExists(pgErr.ConstraintName)
+		}
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return autherr.ErrNotFound
+	}
+	return nil
+}
+
+func (ur *userRepo) SoftDelete(ctx context.Context, q db.Querier, id string) error {
+	sql, args, err := db.NewUpdateBuilder(ctx, ur.pool).
+		Table("users").
+		SetExpr("deleted_at", "now()").
+		Where("id = ?", id).
+		Where("deleted_at IS NULL").
+		Build()
+	if err != nil {
+		return err
+	}
+	tag, err := q.Exec(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return autherr.ErrNotFound
+	}
+	return nil
+}
+
+func (ur *userRepo) PurgeDeleted(ctx context.Context, cutoff time.Time) (int64, error) {
+	tag, err := db.NewDeleteBuilder(ctx, ur.pool).
+		From("users").
+		Where("deleted_at < ?", cutoff).
+		Where("erased_at IS NULL").
+		Exec()
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// eraseSQL anonymizes a user in place; the subquery returns the values
+// from before the update.
+const eraseSQL = `UPDATE users u SET
+	username = 'erased-' || u.id, email = NULL, password = '',
+	first_name = '', last_name = '', display_name = '', metadata = '{}',
+	last_login_ip = '', deleted_at = COALESCE(u.deleted_at, now()), erased_at = now()
+FROM (SELECT id, username, email FROM users WHERE id = $1 FOR UPDATE) old
+WHERE u.id = old.id AND u.erased_at IS NULL
+RETURNING old.username, old.email`
+
+func (ur *userRepo) Erase(ctx context.Context, q db.Querier, id string) (string, string, error) {
+	var (
+		username string
+		email    *string
+	)
+	if err := q.QueryRow(ctx, eraseSQL, id).Scan(&username, &email); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", "", autherr.ErrNotFound
+		}
+		return "", "", err
+	}
+	if email == nil {
+		return username, "", nil
+	}
+	return username, *email, nil
+}
+
+func (ur *userRepo) RecordLogin(ctx context.Context, id string, at time.Time, ip string) error {
+	_, err := db.NewUpdateBuilder(ctx, ur.pool).
+		Table("users").
+		Set("last_login_at", at).
+		Set("last_login_ip", ip).
+		Where("id = ?", id).
+		Exec()
+	return err
+}
+
+func (ur *userRepo) TokenVersion(ctx context.Context, id string) (int64, error) {
+	sb := db.NewSelectBuilder(ctx, ur.pool).
+		Select("token_version").
+		From("users").
+		Where("id = ?", id).
+		Limit(1)
+
+	var version int64
+	if err := sb.QueryRow().Scan(&version); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, autherr.ErrNotFound
+		}
+		return 0, err
+	}
+	return version, nil
+}
+
+func (ur *userRepo) BumpTokenVersion(ctx context.Context, id string) (int64, error) {
+	ub := db.NewUpdateBuilder(ctx, ur.pool).
+		Table("users").
+		SetExpr("token_version", "token_version + 1").
+		Where("id = ?", id).
+		Returning("token_version")
+
+	var version int64
+	if err := ub.QueryRow().Scan(&version); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, autherr.ErrNotFound
+		}
+		return 0, err
+	}
+	return version, nil
+}
+
+// nullable stores empty strings as NULL, which unique indexes do not compare.
+func nullable(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
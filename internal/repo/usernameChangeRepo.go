@@ -0,0 +1,95 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/andro-kes/auth_service/internal/models"
+	"github.com/andro-kes/auth_service/internal/repo/db"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// UsernameChangeRepo stores the previous usernames of users.
+type UsernameChangeRepo interface {
+	Insert(ctx context.Context, q db.Querier, change *models.UsernameChange) error
+	// LastChange returns when userID last changed their username, or the
+	// zero time.
+	LastChange(ctx context.Context, userID string) (time.Time, error)
+	// ReservedBy returns the ID of the user whose previous username, ignoring
+	// case, is reserved at now, or "".
+	ReservedBy(ctx context.Context, q db.Querier, username string, now time.Time) (string, error)
+	DeleteByUser(ctx context.Context, q db.Querier, userID string) error
+}
+
+type usernameChangeRepo struct {
+	pool *pgxpool.Pool
+}
+
+func NewUsernameChangeRepo(ctx context.Context, pool *pgxpool.Pool) UsernameChangeRepo {
+	return &usernameChangeRepo{
+		pool: pool,
+	}
+}
+
+func (ur *usernameChangeRepo) Insert(ctx context.Context, q db.Querier, change *models.UsernameChange) error {
+	sql, args, err := db.NewInsertBuilder(ctx, ur.pool).
+		Into("username_changes").
+		Columns("user_id", "old_username", "changed_at", "reserved_until").
+		Values(change.UserID, change.OldUsername, change.ChangedAt, change.ReservedUntil).
+		Build()
+	if err != nil {
+		return err
+	}
+	_, err = q.Exec(ctx, sql, args...)
+	return err
+}
+
+func (ur *usernameChangeRepo) LastChange(ctx context.Context, userID string) (time.Time, error) {
+	sb := db.NewSelectBuilder(ctx, ur.pool).
+		Select("changed_at").
+		From("username_changes").
+		Where("user_id = ?", userID).
+		OrderBy("changed_at DESC").
+		Limit(1)
+
+	var at time.Time
+	if err := sb.QueryRow().Scan(&at); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return time.Time{}, nil
+		}
+		return time.Time{}, err
+	}
+	return at, nil
+}
+
+func (ur *usernameChangeRepo) ReservedBy(ctx context.Context, q db.Querier, username string, now time.Time) (string, error) {
+	sql, args := db.NewSelectBuilder(ctx, ur.pool).
+		Select("user_id").
+		From("username_changes").
+		Where("lower(old_username) = lower(?)", username).
+		Where("reserved_until > ?", now).
+		Limit(1).
+		Build()
+	var userID string
+	if err := q.QueryRow(ctx, sql, args...).Scan(&userID); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", nil
+		}
+		return "", err
+	}
+	return userID, nil
+}
+
+func (ur *usernameChangeRepo) DeleteByUser(ctx context.Context, q db.Querier, userID string) error {
+	sql, args, err := db.NewDeleteBuilder(ctx, ur.pool).
+		From("username_changes").
+		Where("user_id = ?", userID).
+		Build()
+	if err != nil {
+		return err
+	}
+	_, err = q.Exec(ctx, sql, args...)
+	return err
+}
@@ -0,0 +1,244 @@
+// Package risk evaluates logins whose password was accepted. An Evaluator
+// sees where and from what device a user logs in and decides whether to
+// let the login through, ask for the second factor or refuse it. Heuristic
+// is the default Evaluator: it remembers the devices and the last location
+// of each user in Redis and flags new devices and impossible travel.
+package risk
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Decision is the outcome of an evaluation.
+type Decision int
+
+const (
+	// Allow lets the login through.
+	Allow Decision = iota
+	// RequireMFA asks for the second factor before issuing tokens.
+	RequireMFA
+	// Deny refuses the login.
+	Deny
+)
+
+func (d Decision) String() string {
+	switch d {
+	case Allow:
+		return "allow"
+	case RequireMFA:
+		return "mfa"
+	case Deny:
+		return "deny"
+	}
+	return "Decision(" + strconv.Itoa(int(d)) + ")"
+}
+
+// ParseDecision parses "allow", "mfa" or "deny".
+func ParseDecision(s string) (Decision, error) {
+	switch s {
+	case "allow":
+		return Allow, nil
+	case "mfa":
+		return RequireMFA, nil
+	case "deny":
+		return Deny, nil
+	}
+	return Allow, fmt.Errorf("unknown risk decision %q", s)
+}
+
+// Coordinates is a position in degrees.
+type Coordinates struct {
+	Lat, Lon float64
+}
+
+// ParseCoordinates parses "lat,lon" in degrees, as sent by edges that
+// geolocate the client IP.
+func ParseCoordinates(s string) (Coordinates, bool) {
+	lat, lon, ok := strings.Cut(s, ",")
+	if !ok {
+		return Coordinates{}, false
+	}
+	c := Coordinates{}
+	var err error
+	if c.Lat, err = strconv.ParseFloat(strings.TrimSpace(lat), 64); err != nil || math.Abs(c.Lat) > 90 {
+		return Coordinates{}, false
+	}
+	if c.Lon, err = strconv.ParseFloat(strings.TrimSpace(lon), 64); err != nil || math.Abs(c.Lon) > 180 {
+		return Coordinates{}, false
+	}
+	return c, true
+}
+
+// Login is what an Evaluator knows about a login.
+type Login struct {
+	UserID    string
+	IP        string
+	UserAgent string
+	DeviceID  string
+	// Geo is the client position, nil when the edge sent none.
+	Geo  *Coordinates
+	Time time.Time
+}
+
+// device identifies the client by its device ID or, for clients that send
+// none, its user agent. It returns "" when there is neither.
+func (l Login) device() string {
+	v := "id:" + l.DeviceID
+	if l.DeviceID == "" {
+		if l.UserAgent == "" {
+			return ""
+		}
+		v = "ua:" + l.UserAgent
+	}
+	sum := sha256.Sum256([]byte(v))
+	return hex.EncodeToString(sum[:16])
+}
+
+// Assessment is the result of evaluating a login.
+type Assessment struct {
+	Decision Decision
+	// Reasons names the heuristics that fired, for logs.
+	Reasons []string
+}
+
+// Evaluator decides on logins. Evaluate runs once the password was
+// accepted, Record once the login completed with all of its factors, so
+// that history only learns from logins the user proved.
+type Evaluator interface {
+	Evaluate(ctx context.Context, login Login) (Assessment, error)
+	Record(ctx context.Context, login Login) error
+}
+
+// Config tunes Heuristic.
+type Config struct {
+	// NewDevice is the decision for a device the user has not logged in
+	// from before. Users without history are allowed.
+	NewDevice Decision
+	// ImpossibleTravel is the decision when the user would have moved from
+	// the last login faster than MaxSpeed.
+	ImpossibleTravel Decision
+	// MaxSpeed in km/h.
+	MaxSpeed float64
+	// History is how long devices and locations are remembered after the
+	// last login.
+	History time.Duration
+}
+
+const (
+	// maxDevices remembered per user; the least recently used go first.
+	maxDevices = 50
+	// minTravelKm ignores jumps within the precision of IP geolocation.
+	minTravelKm   = 100
+	earthRadiusKm = 6371
+)
+
+// Heuristic is the default Evaluator.
+type Heuristic struct {
+	rdb redis.UniversalClient
+	cfg Config
+}
+
+// NewHeuristic returns an Evaluator keeping history in rdb.
+func NewHeuristic(rdb redis.UniversalClient, cfg Config) *Heuristic {
+	return &Heuristic{rdb: rdb, cfg: cfg}
+}
+
+// Evaluate applies the new-device and impossible-travel heuristics and
+// returns the strictest decision of those that fired.
+func (h *Heuristic) Evaluate(ctx context.Context, login Login) (Assessment, error) {
+	device := login.device()
+	var seen *redis.FloatCmd
+	pipe := h.rdb.Pipeline()
+	known := pipe.ZCard(ctx, devicesKey(login.UserID))
+	if device != "" {
+		seen = pipe.ZScore(ctx, devicesKey(login.UserID), device)
+	}
+	last := pipe.HGetAll(ctx, lastKey(login.UserID))
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return Assessment{}, err
+	}
+
+	var a Assessment
+	if seen != nil && known.Val() > 0 && seen.Err() == redis.Nil {
+		a.add(h.cfg.NewDevice, "new_device")
+	}
+	if login.Geo != nil {
+		if prev, at, ok := parseLast(last.Val()); ok {
+			km := distanceKm(prev, *login.Geo)
+			hours := login.Time.Sub(at).Hours()
+			if km > minTravelKm && (hours <= 0 || km/hours > h.cfg.MaxSpeed) {
+				a.add(h.cfg.ImpossibleTravel, "impossible_travel")
+			}
+		}
+	}
+	return a, nil
+}
+
+// Record remembers the device and location of a completed login.
+func (h *Heuristic) Record(ctx context.Context, login Login) error {
+	_, err := h.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		if device := login.device(); device != "" {
+			key := devicesKey(login.UserID)
+			pipe.ZAdd(ctx, key, redis.Z{Score: float64(login.Time.Unix()), Member: device})
+			pipe.ZRemRangeByRank(ctx, key, 0, -maxDevices-1)
+			pipe.Expire(ctx, key, h.cfg.History)
+		}
+		if login.Geo != nil {
+			key := lastKey(login.UserID)
+			pipe.HSet(ctx, key,
+				"lat", strconv.FormatFloat(login.Geo.Lat, 'f', -1, 64),
+				"lon", strconv.FormatFloat(login.Geo.Lon, 'f', -1, 64),
+				"at", login.Time.Unix())
+			pipe.Expire(ctx, key, h.cfg.History)
+		}
+		return nil
+	})
+	return err
+}
+
+func (a *Assessment) add(d Decision, reason string) {
+	if d == Allow {
+		return
+	}
+	a.Reasons = append(a.Reasons, reason)
+	a.Decision = max(a.Decision, d)
+}
+
+func parseLast(f map[string]string) (Coordinates, time.Time, bool) {
+	c, ok := ParseCoordinates(f["lat"] + "," + f["lon"])
+	if !ok {
+		return Coordinates{}, time.Time{}, false
+	}
+	at, err := strconv.ParseInt(f["at"], 10, 64)
+	if err != nil {
+		return Coordinates{}, time.Time{}, false
+	}
+	return c, time.Unix(at, 0), true
+}
+
+// distanceKm is the great-circle distance by the haversine formula.
+func distanceKm(a, b Coordinates) float64 {
+	rad := func(deg float64) float64 { return deg * math.Pi / 180 }
+	dLat := rad(b.Lat - a.Lat)
+	dLon := rad(b.Lon - a.Lon)
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(rad(a.Lat))*math.Cos(rad(b.Lat))*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(h))
+}
+
+func devicesKey(userID string) string {
+	return "risk:devices:" + userID
+}
+
+func lastKey(userID string) string {
+	return "risk:last:" + userID
+}
@@ -0,0 +1,96 @@
+package risk
+
+import (
+	"math"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	berlin = Coordinates{Lat: 52.52, Lon: 13.405}
+	paris  = Coordinates{Lat: 48.8566, Lon: 2.3522}
+	tokyo  = Coordinates{Lat: 35.6762, Lon: 139.6503}
+)
+
+func TestParseCoordinates(t *testing.T) {
+	c, ok := ParseCoordinates(" 52.52, 13.405 ")
+	if !ok || c != berlin {
+		t.Fatalf("expected Berlin, got %+v %v", c, ok)
+	}
+	for _, s := range []string{"", "52.52", "91,0", "0,181", "a,b"} {
+		if _, ok := ParseCoordinates(s); ok {
+			t.Fatalf("expected %q to be rejected", s)
+		}
+	}
+	if km := distanceKm(berlin, paris); math.Abs(km-878) > 5 {
+		t.Fatalf("expected Berlin-Paris to be about 878 km, got %.0f", km)
+	}
+}
+
+func TestHeuristic(t *testing.T) {
+	srv, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("failed to start miniredis: %v", err)
+	}
+	defer srv.Close()
+
+	rdb := redis.NewClient(&redis.Options{Addr: srv.Addr()})
+	defer rdb.Close()
+
+	h := NewHeuristic(rdb, Config{
+		NewDevice:        RequireMFA,
+		ImpossibleTravel: Deny,
+		MaxSpeed:         900,
+		History:          24 * time.Hour,
+	})
+	ctx := t.Context()
+	now := time.Unix(1_700_000_000, 0)
+	login := Login{UserID: "u1", DeviceID: "laptop", Geo: &berlin, Time: now}
+
+	check := func(l Login, want Decision, reasons ...string) {
+		t.Helper()
+		a, err := h.Evaluate(ctx, l)
+		if err != nil {
+			t.Fatalf("Evaluate failed: %v", err)
+		}
+		if a.Decision != want || !slices.Equal(a.Reasons, reasons) {
+			t.Fatalf("expected %v %v, got %v %v", want, reasons, a.Decision, a.Reasons)
+		}
+	}
+
+	// without history there is nothing to compare with
+	check(login, Allow)
+	if err := h.Record(ctx, login); err != nil {
+		t.Fatalf("Record failed: %v", err)
+	}
+	check(login, Allow)
+
+	phone := login
+	phone.DeviceID = "phone"
+	check(phone, RequireMFA, "new_device")
+
+	// Berlin to Paris in two hours is plausible, in ten minutes it is not
+	trip := login
+	trip.Geo, trip.Time = &paris, now.Add(2*time.Hour)
+	check(trip, Allow)
+	trip.Time = now.Add(10 * time.Minute)
+	check(trip, Deny, "impossible_travel")
+
+	phone.Geo, phone.Time = &tokyo, now.Add(time.Hour)
+	check(phone, Deny, "new_device", "impossible_travel")
+
+	// a login without device or position is not judged
+	check(Login{UserID: "u1", Time: now}, Allow)
+
+	if err := h.Record(ctx, phone); err != nil {
+		t.Fatalf("Record failed: %v", err)
+	}
+	check(phone, Allow)
+	if ttl := srv.TTL(devicesKey("u1")); ttl != 24*time.Hour {
+		t.Fatalf("expected history to expire after a day, got %v", ttl)
+	}
+}
@@ -0,0 +1,110 @@
+package rpc
+
+import (
+	"context"
+	"crypto/subtle"
+	"time"
+
+	"github.com/andro-kes/auth_service/internal/autherr"
+	pb "github.com/andro-kes/auth_service/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// adminKeyMetadataKey carries the shared admin key for admin RPCs.
+const adminKeyMetadataKey = "x-admin-key"
+
+// requireAdmin authorizes admin RPCs. Admin RPCs are disabled when no admin
+// key is configured.
+func (as *AuthServer) requireAdmin(ctx context.Context) error {
+	if as.adminKey == "" {
+		return autherr.ErrForbidden.WithMessage("admin API is disabled")
+	}
+	key := firstMetadata(ctx, adminKeyMetadataKey)
+	if key == "" || subtle.ConstantTimeCompare([]byte(key), []byte(as.adminKey)) != 1 {
+		return autherr.ErrForbidden
+	}
+	return nil
+}
+
+func (as *AuthServer) ForceExpireTokens(ctx context.Context, req *pb.ForceExpireTokensRequest) (*pb.ForceExpireTokensResponse, error) {
+	if err := as.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	notBefore := time.Now()
+	if req.NotBefore != nil {
+		if err := req.NotBefore.CheckValid(); err != nil {
+			return nil, autherr.ErrBadRequest.WithMessage("invalid not_before")
+		}
+		notBefore = req.NotBefore.AsTime()
+	}
+
+	nbf, err := as.TokenService.ForceExpire(ctx, notBefore, req.UserId)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.ForceExpireTokensResponse{NotBefore: timestamppb.New(nbf)}, nil
+}
+
+func (as *AuthServer) BumpTokenVersion(ctx context.Context, req *pb.BumpTokenVersionRequest) (*pb.BumpTokenVersionResponse, error) {
+	if err := as.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	if req.UserId == "" {
+		return nil, autherr.ErrBadRequest.WithMessage("user_id is required")
+	}
+
+	v, err := as.TokenService.BumpTokenVersion(ctx, req.UserId)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.BumpTokenVersionResponse{TokenVersion: v}, nil
+}
+
+func (as *AuthServer) ListUserSessions(ctx context.Context, req *pb.ListUserSessionsRequest) (*pb.ListSessionsResponse, error) {
+	if err := as.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	if req.UserId == "" {
+		return nil, autherr.ErrBadRequest.WithMessage("user_id is required")
+	}
+
+	sessions, err := as.TokenService.ListSessions(ctx, req.UserId)
+	if err != nil {
+		return nil, err
+	}
+	resp := sessionsToPB(sessions, "")
+	if err := as.addLastLogin(ctx, req.UserId, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (as *AuthServer) MintHoneytoken(ctx context.Context, req *pb.MintHoneytokenRequest) (*pb.MintHoneytokenResponse, error) {
+	if err := as.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	if req.Label == "" {
+		return nil, autherr.ErrBadRequest.WithMessage("label is required")
+	}
+
+	switch req.Kind {
+	case pb.HoneytokenKind_HONEYTOKEN_KIND_REFRESH_TOKEN:
+		token, err := as.CanaryService.MintRefreshToken(ctx, req.Label)
+		if err != nil {
+			return nil, err
+		}
+		return &pb.MintHoneytokenResponse{RefreshToken: token}, nil
+	case pb.HoneytokenKind_HONEYTOKEN_KIND_CREDENTIALS:
+		if req.Username == "" {
+			return nil, autherr.ErrBadRequest.WithMessage("username is required")
+		}
+		password, err := as.CanaryService.MintCredentials(ctx, req.Username, req.Label)
+		if err != nil {
+			return nil, err
+		}
+		return &pb.MintHoneytokenResponse{Username: req.Username, Password: password}, nil
+	default:
+		return nil, autherr.ErrBadRequest.WithMessage("unknown honeytoken kind")
+	}
+}
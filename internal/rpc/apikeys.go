@@ -0,0 +1,113 @@
+package rpc
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/andro-kes/auth_service/internal/autherr"
+	"github.com/andro-kes/auth_service/internal/models"
+	pb "github.com/andro-kes/auth_service/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func (as *AuthServer) CreateAPIKey(ctx context.Context, req *pb.CreateAPIKeyRequest) (*pb.CreateAPIKeyResponse, error) {
+	claims, err := as.callerClaims(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// a scoped token, or one of a service account or client, must not mint
+	// itself a broader, longer-lived credential
+	if claims.Scope != "" || claims.SubjectType != "" {
+		return nil, autherr.ErrForbidden.WithMessage("API keys are created with a user's access token")
+	}
+	var ttl time.Duration
+	if req.Ttl != nil {
+		if err := req.Ttl.CheckValid(); err != nil || req.Ttl.AsDuration() <= 0 {
+			return nil, autherr.ErrBadRequest.WithMessage("invalid ttl")
+		}
+		ttl = req.Ttl.AsDuration()
+	}
+	key, secret, err := as.APIKeys.CreateKey(ctx, claims.UserID, req.Name, req.Scopes, ttl, clientInfo(ctx))
+	if err != nil {
+		return nil, err
+	}
+	return &pb.CreateAPIKeyResponse{ApiKey: secret, Key: apiKeyToPB(key)}, nil
+}
+
+func (as *AuthServer) ListAPIKeys(ctx context.Context, _ *pb.ListAPIKeysRequest) (*pb.ListAPIKeysResponse, error) {
+	userID, err := as.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := as.APIKeys.ListKeys(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	resp := &pb.ListAPIKeysResponse{Keys: make([]*pb.APIKey, 0, len(keys))}
+	for i := range keys {
+		resp.Keys = append(resp.Keys, apiKeyToPB(&keys[i]))
+	}
+	return resp, nil
+}
+
+func (as *AuthServer) RevokeAPIKey(ctx context.Context, req *pb.RevokeAPIKeyRequest) (*pb.RevokeAPIKeyResponse, error) {
+	userID, err := as.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := as.APIKeys.RevokeKey(ctx, userID, req.KeyId, clientInfo(ctx)); err != nil {
+		return nil, err
+	}
+	return &pb.RevokeAPIKeyResponse{}, nil
+}
+
+// ValidateAPIKey reports keys that are not valid in the response, like
+// ValidateBatch, and fails only when the key could not be checked.
+func (as *AuthServer) ValidateAPIKey(ctx context.Context, req *pb.ValidateAPIKeyRequest) (*pb.ValidateAPIKeyResponse, error) {
+	if err := as.authorizeIntrospection(ctx); err != nil {
+		return nil, err
+	}
+	key, err := as.APIKeys.ValidateKey(ctx, req.ApiKey)
+	switch {
+	case err == autherr.ErrInvalidToken:
+		return &pb.ValidateAPIKeyResponse{Error: "invalid_key"}, nil
+	case err == autherr.ErrTokenExpired:
+		return &pb.ValidateAPIKeyResponse{Error: "key_expired"}, nil
+	case status.Code(err) == codes.PermissionDenied:
+		return &pb.ValidateAPIKeyResponse{Error: "account_disabled"}, nil
+	case err != nil:
+		return nil, err
+	}
+	if req.Scope != "" && !slices.Contains(key.Scopes, req.Scope) {
+		return &pb.ValidateAPIKeyResponse{Error: "insufficient_scope"}, nil
+	}
+	resp := &pb.ValidateAPIKeyResponse{
+		Valid:  true,
+		UserId: key.UserID,
+		KeyId:  key.ID,
+		Scopes: key.Scopes,
+	}
+	if key.ExpiresAt != nil {
+		resp.ExpiresAt = timestamppb.New(*key.ExpiresAt)
+	}
+	return resp, nil
+}
+
+func apiKeyToPB(key *models.APIKey) *pb.APIKey {
+	k := &pb.APIKey{
+		KeyId:     key.ID,
+		Name:      key.Name,
+		Scopes:    key.Scopes,
+		CreatedAt: timestampOrNil(key.CreatedAt),
+	}
+	if key.ExpiresAt != nil {
+		k.ExpiresAt = timestamppb.New(*key.ExpiresAt)
+	}
+	if key.LastUsedAt != nil {
+		k.LastUsedAt = timestamppb.New(*key.LastUsedAt)
+	}
+	return k
+}
@@ -0,0 +1,152 @@
+package rpc
+
+import (
+	"context"
+	"net"
+	"strings"
+
+	"github.com/andro-kes/auth_service/internal/autherr"
+	"github.com/andro-kes/auth_service/internal/services"
+	pb "github.com/andro-kes/auth_service/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	authorizationMetadataKey = "authorization"
+	deviceIDMetadataKey      = "x-device-id"
+	forwardedForMetadataKey  = "x-forwarded-for"
+	geoMetadataKey           = "x-client-geo"
+	locationMetadataKey      = "x-client-location"
+	userAgentMetadataKey     = "user-agent"
+)
+
+// authenticate validates the access token sent in the "authorization"
+// metadata and returns the caller's user ID. See callerClaims. It guards
+// RPCs acting on the caller's own account, so the tokens of service
+// accounts and clients, whose subject is no user, are refused.
+func (as *AuthServer) authenticate(ctx context.Context) (string, error) {
+	claims, err := as.callerClaims(ctx)
+	if err != nil {
+		return "", err
+	}
+	if claims.SubjectType != "" {
+		return "", autherr.ErrForbidden.WithMessage("a user's access token is required")
+	}
+	return claims.UserID, nil
+}
+
+func (as *AuthServer) ValidateToken(ctx context.Context, _ *pb.ValidateTokenRequest) (*pb.ValidateTokenResponse, error) {
+	claims, err := as.callerClaims(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return claimsToPB(claims)
+}
+
+func (as *AuthServer) Validate(ctx context.Context, req *pb.ValidateRequest) (*pb.ValidateTokenResponse, error) {
+	if req.AccessToken == "" {
+		return nil, autherr.ErrNoToken
+	}
+	proof := req.DpopProof
+	if proof == "" {
+		proof = dpopProof(ctx)
+	}
+	var claims *services.Claims
+	var err error
+	switch {
+	case req.Htm == "" && req.Htu == "":
+		claims, err = as.validateAccess(ctx, req.AccessToken, proof)
+	case req.Htm == "" || req.Htu == "":
+		return nil, autherr.ErrBadRequest.WithMessage("htm and htu must be set together")
+	default:
+		// a resource server passes on the proof it received for its own request
+		claims, err = as.TokenService.ValidateAccessForRequest(ctx, req.AccessToken, proof, req.Htm, req.Htu)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return claimsToPB(claims)
+}
+
+func claimsToPB(claims *services.Claims) (*pb.ValidateTokenResponse, error) {
+	metadata, err := claimsMetadataToPB(claims.Metadata)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.ValidateTokenResponse{
+		UserId:    claims.UserID,
+		Jti:       claims.JTI,
+		SessionId: claims.SessionID,
+		Scope:     claims.Scope,
+		SubType:   claims.SubjectType,
+		DpopJkt:   claims.DPoPJKT,
+		OneTime:   claims.OneTime,
+		Audience:  claims.Audience,
+		Roles:     claims.Roles,
+		Metadata:  metadata,
+		Actors:    claims.Actors,
+		IssuedAt:  timestampOrNil(claims.IssuedAt),
+		ExpiresAt: timestamppb.New(claims.ExpiresAt),
+	}, nil
+}
+
+// callerClaims validates the access token sent in the "authorization"
+// metadata and returns its claims. DPoP-bound tokens must use the "DPoP"
+// scheme together with a proof in the "dpop" metadata. One-time tokens are
+// consumed by the call.
+func (as *AuthServer) callerClaims(ctx context.Context) (*services.Claims, error) {
+	scheme, token, ok := accessToken(ctx)
+	if !ok {
+		return nil, autherr.ErrNoToken
+	}
+
+	switch scheme {
+	case "bearer":
+		return as.validateAccess(ctx, token, "")
+	case "dpop":
+		return as.validateAccess(ctx, token, dpopProof(ctx))
+	default:
+		return nil, autherr.ErrNoToken
+	}
+}
+
+// validateAccess validates token, with the DPoP proof if any, for the
+// current call. ValidateToken and Validate differ only in where the token
+// comes from.
+func (as *AuthServer) validateAccess(ctx context.Context, token, proof string) (*services.Claims, error) {
+	method, _ := grpc.Method(ctx)
+	return as.TokenService.ValidateAccessForCall(ctx, token, proof, method)
+}
+
+// accessToken splits the "authorization" metadata into a lower-cased scheme
+// and the token.
+func accessToken(ctx context.Context) (scheme, token string, ok bool) {
+	scheme, token, ok = strings.Cut(firstMetadata(ctx, authorizationMetadataKey), " ")
+	token = strings.TrimSpace(token)
+	if !ok || token == "" {
+		return "", "", false
+	}
+	return strings.ToLower(scheme), token, true
+}
+
+// clientInfo describes the calling client for session bookkeeping.
+func clientInfo(ctx context.Context) services.ClientInfo {
+	ci := services.ClientInfo{
+		UserAgent: firstMetadata(ctx, userAgentMetadataKey),
+		DeviceID:  firstMetadata(ctx, deviceIDMetadataKey),
+		Location:  firstMetadata(ctx, locationMetadataKey),
+	}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		ci.IP = p.Addr.String()
+		if host, _, err := net.SplitHostPort(ci.IP); err == nil {
+			ci.IP = host
+		}
+	} else if xff := firstMetadata(ctx, forwardedForMetadataKey); xff != "" {
+		// in-process REST gateway: it appends the remote address last
+		hops := strings.Split(xff, ",")
+		ci.IP = strings.TrimSpace(hops[len(hops)-1])
+	}
+	return ci
+}
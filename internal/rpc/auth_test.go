@@ -0,0 +1,290 @@
+package rpc
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/andro-kes/auth_service/internal/dpop"
+	"github.com/andro-kes/auth_service/internal/services"
+	pb "github.com/andro-kes/auth_service/proto"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const validateMethod = "/auth.AuthService/Validate"
+
+// methodStream tells handlers called directly which method they serve.
+type methodStream struct {
+	grpc.ServerTransportStream
+	method string
+}
+
+func (s methodStream) Method() string { return s.method }
+
+type testClock struct{ t time.Time }
+
+func (c *testClock) Now() time.Time { return c.t }
+
+func newTestAuthServer(t *testing.T, opts ...services.Option) *AuthServer {
+	t.Helper()
+	srv, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("failed to start miniredis: %v", err)
+	}
+	t.Cleanup(srv.Close)
+	rdb := redis.NewClient(&redis.Options{Addr: srv.Addr()})
+	t.Cleanup(func() { _ = rdb.Close() })
+
+	tokens, err := services.NewTokenService(rdb, "012345678901234567890123456789ab", time.Minute, time.Hour, opts...)
+	if err != nil {
+		t.Fatalf("failed to create TokenService: %v", err)
+	}
+	return &AuthServer{TokenService: tokens}
+}
+
+func newProof(t *testing.T, key *ecdsa.PrivateKey, htu, accessToken string) string {
+	t.Helper()
+	return newRequestProof(t, key, dpop.MethodPOST, htu, accessToken)
+}
+
+func newRequestProof(t *testing.T, key *ecdsa.PrivateKey, htm, htu, accessToken string) string {
+	t.Helper()
+	claims := jwt.MapClaims{
+		"jti": uuid.NewString(),
+		"htm": htm,
+		"htu": htu,
+		"iat": time.Now().Unix(),
+	}
+	if accessToken != "" {
+		claims["ath"] = dpop.AccessTokenHash(accessToken)
+	}
+	tok := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+	tok.Header["typ"] = dpop.HeaderType
+	tok.Header["jwk"] = map[string]string{
+		"kty": "EC",
+		"crv": "P-256",
+		"x":   base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+		"y":   base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32))),
+	}
+	s, err := tok.SignedString(key)
+	if err != nil {
+		t.Fatalf("failed to sign proof: %v", err)
+	}
+	return s
+}
+
+func TestValidate(t *testing.T) {
+	clock := &testClock{t: time.Now()}
+	as := newTestAuthServer(t, services.WithClock(clock))
+	ctx := grpc.NewContextWithServerTransportStream(t.Context(), methodStream{method: validateMethod})
+
+	access, _, _, _, err := as.TokenService.GenerateTokens(ctx, "alice")
+	if err != nil {
+		t.Fatalf("GenerateTokens failed: %v", err)
+	}
+	resp, err := as.Validate(ctx, &pb.ValidateRequest{AccessToken: access})
+	if err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+	if resp.UserId != "alice" || resp.Jti == "" || resp.ExpiresAt == nil {
+		t.Fatalf("unexpected claims %+v", resp)
+	}
+
+	if _, err := as.Validate(ctx, &pb.ValidateRequest{}); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected Unauthenticated without a token, got %v", err)
+	}
+	if _, err := as.Validate(ctx, &pb.ValidateRequest{AccessToken: "not-a-token"}); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected Unauthenticated for garbage, got %v", err)
+	}
+
+	if err := as.TokenService.RevokeAccess(ctx, access); err != nil {
+		t.Fatalf("RevokeAccess failed: %v", err)
+	}
+	if _, err := as.Validate(ctx, &pb.ValidateRequest{AccessToken: access}); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected Unauthenticated for a revoked token, got %v", err)
+	}
+
+	old, _, _, _, err := as.TokenService.GenerateTokens(ctx, "alice")
+	if err != nil {
+		t.Fatalf("GenerateTokens failed: %v", err)
+	}
+	clock.t = clock.t.Add(2 * time.Minute)
+	if _, err := as.Validate(ctx, &pb.ValidateRequest{AccessToken: old}); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected Unauthenticated for an expired token, got %v", err)
+	}
+}
+
+func TestValidateDPoP(t *testing.T) {
+	as := newTestAuthServer(t)
+	ctx := grpc.NewContextWithServerTransportStream(t.Context(), methodStream{method: validateMethod})
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	p, err := dpop.Verify(newProof(t, key, validateMethod, ""), dpop.MethodPOST, validateMethod, "", time.Now(), dpop.Options{})
+	if err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	access, _, _, _, err := as.TokenService.GenerateTokens(ctx, "alice", services.WithDPoPKey(p.JKT))
+	if err != nil {
+		t.Fatalf("GenerateTokens failed: %v", err)
+	}
+
+	if _, err := as.Validate(ctx, &pb.ValidateRequest{AccessToken: access}); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected a DPoP-bound token without proof to be rejected, got %v", err)
+	}
+	resp, err := as.Validate(ctx, &pb.ValidateRequest{AccessToken: access, DpopProof: newProof(t, key, validateMethod, access)})
+	if err != nil {
+		t.Fatalf("Validate with dpop_proof failed: %v", err)
+	}
+	if resp.DpopJkt != p.JKT {
+		t.Fatalf("expected dpop_jkt %q, got %q", p.JKT, resp.DpopJkt)
+	}
+
+	// the proof may come in the "dpop" metadata as well
+	mdCtx := metadata.NewIncomingContext(ctx, metadata.Pairs(dpopMetadataKey, newProof(t, key, validateMethod, access)))
+	if _, err := as.Validate(mdCtx, &pb.ValidateRequest{AccessToken: access}); err != nil {
+		t.Fatalf("Validate with dpop metadata failed: %v", err)
+	}
+
+	other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	if _, err := as.Validate(ctx, &pb.ValidateRequest{AccessToken: access, DpopProof: newProof(t, other, validateMethod, access)}); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected a proof by another key to be rejected, got %v", err)
+	}
+}
+
+func TestValidateDPoPForResourceServer(t *testing.T) {
+	as := newTestAuthServer(t)
+	ctx := grpc.NewContextWithServerTransportStream(t.Context(), methodStream{method: validateMethod})
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	p, err := dpop.Verify(newProof(t, key, validateMethod, ""), dpop.MethodPOST, validateMethod, "", time.Now(), dpop.Options{})
+	if err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	access, _, _, _, err := as.TokenService.GenerateTokens(ctx, "alice", services.WithDPoPKey(p.JKT))
+	if err != nil {
+		t.Fatalf("GenerateTokens failed: %v", err)
+	}
+
+	// the client sent the proof to the resource server, not to us
+	const htu = "https://api.example.com/orders"
+	proof := newRequestProof(t, key, "GET", htu, access)
+	if _, err := as.Validate(ctx, &pb.ValidateRequest{AccessToken: access, DpopProof: proof}); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected a proof for another URL to be rejected without htu, got %v", err)
+	}
+	resp, err := as.Validate(ctx, &pb.ValidateRequest{AccessToken: access, DpopProof: newRequestProof(t, key, "GET", htu, access), Htm: "GET", Htu: htu})
+	if err != nil {
+		t.Fatalf("Validate with htm and htu failed: %v", err)
+	}
+	if resp.UserId != "alice" || resp.DpopJkt != p.JKT {
+		t.Fatalf("unexpected claims %+v", resp)
+	}
+
+	if _, err := as.Validate(ctx, &pb.ValidateRequest{AccessToken: access, DpopProof: newRequestProof(t, key, "GET", htu, access), Htm: "POST", Htu: htu}); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected a proof for another method to be rejected, got %v", err)
+	}
+	if _, err := as.Validate(ctx, &pb.ValidateRequest{AccessToken: access, DpopProof: newRequestProof(t, key, "GET", htu, access), Htm: "GET", Htu: "https://api.example.com/admin"}); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected a proof for another URL to be rejected, got %v", err)
+	}
+	if _, err := as.Validate(ctx, &pb.ValidateRequest{AccessToken: access, Htu: htu}); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected htu without htm to be refused, got %v", err)
+	}
+}
+
+func TestValidateBatchDPoP(t *testing.T) {
+	as := newTestAuthServer(t)
+	as.references = referencePolicy{key: "introspection-key"}
+	ctx := metadata.NewIncomingContext(t.Context(), metadata.Pairs(introspectionKeyMetadataKey, "introspection-key"))
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	p, err := dpop.Verify(newProof(t, key, validateMethod, ""), dpop.MethodPOST, validateMethod, "", time.Now(), dpop.Options{})
+	if err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	access, _, _, _, err := as.TokenService.GenerateTokens(ctx, "alice", services.WithDPoPKey(p.JKT))
+	if err != nil {
+		t.Fatalf("GenerateTokens failed: %v", err)
+	}
+
+	const htu = "https://api.example.com/orders"
+	resp, err := as.ValidateBatch(ctx, &pb.ValidateBatchRequest{
+		Tokens: []string{access, access, access},
+		DpopProofs: []*pb.DPoPProof{
+			{Proof: newRequestProof(t, key, "GET", htu, access), Htm: "GET", Htu: htu},
+			{Proof: newRequestProof(t, key, "GET", "https://evil.example.com/orders", access), Htm: "GET", Htu: htu},
+		},
+	})
+	if err != nil {
+		t.Fatalf("ValidateBatch failed: %v", err)
+	}
+	if r := resp.Results[0]; !r.Valid || r.DpopJkt != p.JKT {
+		t.Fatalf("token with proof: %+v", r)
+	}
+	if r := resp.Results[1]; r.Valid || r.Error != "invalid_token" {
+		t.Fatalf("token with a proof for a third-party URL: %+v", r)
+	}
+	if r := resp.Results[2]; r.Valid || r.Error != "invalid_token" {
+		t.Fatalf("token without proof: %+v", r)
+	}
+
+	_, err = as.ValidateBatch(ctx, &pb.ValidateBatchRequest{
+		Tokens:     []string{access},
+		DpopProofs: []*pb.DPoPProof{{Proof: newRequestProof(t, key, "GET", htu, access)}},
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected a proof without htm and htu to be refused, got %v", err)
+	}
+}
+
+func TestAuthenticateUsersOnly(t *testing.T) {
+	as := newTestAuthServer(t)
+	ctx := t.Context()
+
+	user, _, _, _, err := as.TokenService.GenerateTokens(ctx, "alice")
+	if err != nil {
+		t.Fatalf("GenerateTokens failed: %v", err)
+	}
+	userCtx := metadata.NewIncomingContext(ctx, metadata.Pairs(authorizationMetadataKey, "Bearer "+user))
+	if userID, err := as.authenticate(userCtx); err != nil || userID != "alice" {
+		t.Fatalf("authenticate = %q, %v", userID, err)
+	}
+
+	for name, opt := range map[string]services.IssueOption{
+		"service account": services.AsServiceAccount(),
+		"client":          services.AsClient(),
+	} {
+		token, _, err := as.TokenService.IssueScopedAccess(ctx, "svc-1", "orders:read", 0, false, opt)
+		if err != nil {
+			t.Fatalf("IssueScopedAccess failed: %v", err)
+		}
+		svcCtx := metadata.NewIncomingContext(ctx, metadata.Pairs(authorizationMetadataKey, "Bearer "+token))
+		if _, err := as.authenticate(svcCtx); status.Code(err) != codes.PermissionDenied {
+			t.Fatalf("expected PermissionDenied for a %s token, got %v", name, err)
+		}
+		// the token itself is valid
+		if _, err := as.callerClaims(svcCtx); err != nil {
+			t.Fatalf("callerClaims failed for a %s token: %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package rpc
+
+import (
+	"context"
+	"encoding/base64"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/andro-kes/auth_service/internal/autherr"
+	pb "github.com/andro-kes/auth_service/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+func (as *AuthServer) CreateClient(ctx context.Context, req *pb.CreateClientRequest) (*pb.CreateClientResponse, error) {
+	if err := as.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	if req.Confidential {
+		id, secret, err := as.Clients.CreateConfidentialClient(ctx, req.Name, req.Audience, req.Scopes)
+		if err != nil {
+			return nil, err
+		}
+		return &pb.CreateClientResponse{ClientId: id, ClientSecret: secret}, nil
+	}
+	if len(req.Scopes) > 0 {
+		return nil, autherr.ErrBadRequest.WithMessage("scopes require a confidential client")
+	}
+	id, err := as.Clients.CreateClient(ctx, req.Name, req.Audience)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.CreateClientResponse{ClientId: id}, nil
+}
+
+func (as *AuthServer) ClientCredentials(ctx context.Context, req *pb.ClientCredentialsRequest) (*pb.ClientCredentialsResponse, error) {
+	if err := as.limitRate(ctx); err != nil {
+		return nil, err
+	}
+	clientID, secret := req.ClientId, req.ClientSecret
+	if clientID == "" {
+		var ok bool
+		if clientID, secret, ok = basicCredentials(ctx); !ok {
+			return nil, autherr.ErrBadRequest.WithMessage("client_id and client_secret are required")
+		}
+	}
+	token, scope, exp, err := as.Clients.ClientCredentials(ctx, clientID, secret, req.Scope)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.ClientCredentialsResponse{
+		AccessToken: token,
+		ExpiresIn:   durationpb.New(time.Until(exp)),
+		Scope:       scope,
+	}, nil
+}
+
+// basicCredentials reads HTTP Basic authorization, whose user and password
+// are form-encoded client ID and secret (RFC 6749 2.3.1).
+func basicCredentials(ctx context.Context) (clientID, secret string, ok bool) {
+	scheme, token, ok := accessToken(ctx)
+	if !ok || scheme != "basic" {
+		return "", "", false
+	}
+	raw, err := base64.StdEncoding.DecodeString(token)
+	if err != nil {
+		return "", "", false
+	}
+	user, pass, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return "", "", false
+	}
+	if clientID, err = url.QueryUnescape(user); err != nil {
+		return "", "", false
+	}
+	if secret, err = url.QueryUnescape(pass); err != nil {
+		return "", "", false
+	}
+	return clientID, secret, clientID != ""
+}
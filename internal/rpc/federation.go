@@ -0,0 +1,100 @@
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/andro-kes/auth_service/internal/config"
+	"github.com/andro-kes/auth_service/internal/federation"
+	"github.com/andro-kes/auth_service/internal/logger"
+	"github.com/andro-kes/auth_service/internal/saml"
+	pb "github.com/andro-kes/auth_service/proto"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// newFederationProviders returns the identity providers configured in cfg
+// by name.
+func newFederationProviders(cfg config.Federation) (map[string]federation.Provider, error) {
+	providers := map[string]federation.Provider{}
+	if cfg.GoogleClientID != "" {
+		google, err := federation.NewGoogle(cfg.GoogleClientID, cfg.GoogleClientSecret)
+		if err != nil {
+			return nil, err
+		}
+		providers["google"] = google
+	}
+	if cfg.GitHubClientID != "" {
+		providers["github"] = federation.NewGitHub(cfg.GitHubClientID, cfg.GitHubClientSecret)
+	}
+	for _, p := range cfg.OIDC {
+		providers[p.Name] = federation.NewOIDC(federation.OIDCConfig{
+			DiscoveryURL: p.DiscoveryURL,
+			ClientID:     p.ClientID,
+			ClientSecret: p.ClientSecret,
+			Claims: federation.ClaimMapping{
+				Username:      p.Claims["username"],
+				Email:         p.Claims["email"],
+				EmailVerified: p.Claims["email_verified"],
+				FirstName:     p.Claims["first_name"],
+				LastName:      p.Claims["last_name"],
+				DisplayName:   p.Claims["display_name"],
+			},
+			TrustEmail: p.TrustEmail,
+		})
+	}
+	return providers, nil
+}
+
+// newSAMLProviders returns the SAML connections configured in cfg by name.
+func newSAMLProviders(cfg config.Federation, rdb redis.UniversalClient) (map[string]*saml.ServiceProvider, error) {
+	providers := map[string]*saml.ServiceProvider{}
+	for _, p := range cfg.SAML {
+		data, err := os.ReadFile(p.IdPMetadataFile)
+		if err != nil {
+			return nil, fmt.Errorf("SAML provider %s: %w", p.Name, err)
+		}
+		idp, err := saml.ParseIdPMetadata(data)
+		if err != nil {
+			return nil, fmt.Errorf("SAML provider %s: %w", p.Name, err)
+		}
+		sp, err := saml.New(saml.Config{
+			Name:      p.Name,
+			EntityID:  p.EntityID,
+			ACSURL:    cfg.SAMLBaseURL + "/v1/saml/" + p.Name + "/acs",
+			IdP:       idp,
+			ReturnURL: p.ReturnURL,
+			Attributes: saml.AttributeMapping{
+				Username:    p.Attributes["username"],
+				Email:       p.Attributes["email"],
+				FirstName:   p.Attributes["first_name"],
+				LastName:    p.Attributes["last_name"],
+				DisplayName: p.Attributes["display_name"],
+			},
+			TrustEmail:        p.TrustEmail,
+			AllowIdPInitiated: p.AllowIdPInitiated,
+		}, rdb)
+		if err != nil {
+			return nil, fmt.Errorf("SAML provider %s: %w", p.Name, err)
+		}
+		providers[p.Name] = sp
+	}
+	return providers, nil
+}
+
+func (as *AuthServer) FederatedLogin(ctx context.Context, req *pb.FederatedLoginRequest) (*pb.TokenResponse, error) {
+	if err := as.limitRate(ctx); err != nil {
+		return nil, err
+	}
+	user, err := as.Federation.Login(ctx, req.Provider, federation.Credential{
+		Code:        req.Code,
+		RedirectURI: req.RedirectUri,
+		IDToken:     req.IdToken,
+	}, clientInfo(ctx))
+	if err != nil {
+		return nil, err
+	}
+	logger.FromContext(ctx).Info("Federated login accepted", zap.String("username", user.Username), zap.String("provider", req.Provider))
+	return as.completeLogin(ctx, user, req.RememberMe, req.ClientId)
+}
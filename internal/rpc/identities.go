@@ -0,0 +1,101 @@
+package rpc
+
+import (
+	"context"
+
+	"github.com/andro-kes/auth_service/internal/autherr"
+	"github.com/andro-kes/auth_service/internal/federation"
+	"github.com/andro-kes/auth_service/internal/models"
+	pb "github.com/andro-kes/auth_service/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// LinkIdentity links any subject for admins; users link the account they
+// prove with a login at the provider.
+func (as *AuthServer) LinkIdentity(ctx context.Context, req *pb.LinkIdentityRequest) (*pb.Identity, error) {
+	userID, admin, err := as.accountSubject(ctx, req.UserId)
+	if err != nil {
+		return nil, err
+	}
+	var identity *models.Identity
+	if admin {
+		identity, err = as.Identities.LinkIdentity(ctx, userID, models.Identity{
+			Provider: req.Provider,
+			Subject:  req.Subject,
+			Email:    req.Email,
+		}, clientInfo(ctx))
+	} else {
+		identity, err = as.Federation.Link(ctx, userID, req.Provider, federation.Credential{
+			Code:        req.Code,
+			RedirectURI: req.RedirectUri,
+			IDToken:     req.IdToken,
+		}, clientInfo(ctx))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return identityToPB(identity), nil
+}
+
+// UnlinkIdentity keeps users from unlinking their last way to log in.
+func (as *AuthServer) UnlinkIdentity(ctx context.Context, req *pb.UnlinkIdentityRequest) (*pb.UnlinkIdentityResponse, error) {
+	userID, admin, err := as.accountSubject(ctx, req.UserId)
+	if err != nil {
+		return nil, err
+	}
+	if admin {
+		err = as.Identities.UnlinkIdentity(ctx, userID, req.Provider, clientInfo(ctx))
+	} else {
+		err = as.Identities.UnlinkOwnIdentity(ctx, userID, req.Provider, clientInfo(ctx))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &pb.UnlinkIdentityResponse{}, nil
+}
+
+func (as *AuthServer) ListIdentities(ctx context.Context, req *pb.ListIdentitiesRequest) (*pb.ListIdentitiesResponse, error) {
+	if err := as.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	if req.UserId == "" {
+		return nil, autherr.ErrBadRequest.WithMessage("user_id is required")
+	}
+	return as.listIdentities(ctx, req.UserId)
+}
+
+func (as *AuthServer) ListLinkedIdentities(ctx context.Context, req *pb.ListLinkedIdentitiesRequest) (*pb.ListIdentitiesResponse, error) {
+	if err := as.limitRate(ctx); err != nil {
+		return nil, err
+	}
+	userID, err := as.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return as.listIdentities(ctx, userID)
+}
+
+func (as *AuthServer) listIdentities(ctx context.Context, userID string) (*pb.ListIdentitiesResponse, error) {
+	identities, err := as.Identities.Identities(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	resp := &pb.ListIdentitiesResponse{}
+	for i := range identities {
+		resp.Identities = append(resp.Identities, identityToPB(&identities[i]))
+	}
+	return resp, nil
+}
+
+func identityToPB(identity *models.Identity) *pb.Identity {
+	out := &pb.Identity{
+		UserId:   identity.UserID,
+		Provider: identity.Provider,
+		Subject:  identity.Subject,
+		Email:    identity.Email,
+	}
+	if !identity.CreatedAt.IsZero() {
+		out.CreatedAt = timestamppb.New(identity.CreatedAt)
+	}
+	return out
+}
@@ -0,0 +1,159 @@
+package rpc
+
+import (
+	"context"
+	"crypto/subtle"
+	"time"
+
+	"github.com/andro-kes/auth_service/internal/autherr"
+	"github.com/andro-kes/auth_service/internal/config"
+	"github.com/andro-kes/auth_service/internal/services"
+	pb "github.com/andro-kes/auth_service/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// introspectionKeyMetadataKey carries the key authorizing Introspect calls.
+const introspectionKeyMetadataKey = "x-introspection-key"
+
+// referencePolicy decides which clients get reference access tokens and
+// guards the introspection endpoint that resolves them.
+type referencePolicy struct {
+	clients  map[string]bool
+	key      string
+	cacheTTL time.Duration
+}
+
+func newReferencePolicy(cfg config.ReferenceTokens) referencePolicy {
+	p := referencePolicy{
+		clients:  make(map[string]bool, len(cfg.Clients)),
+		key:      cfg.IntrospectionKey,
+		cacheTTL: cfg.CacheTTL,
+	}
+	for _, c := range cfg.Clients {
+		p.clients[c] = true
+	}
+	return p
+}
+
+// reference reports whether clientID receives reference tokens.
+func (p referencePolicy) reference(clientID string) bool {
+	return clientID != "" && p.clients[clientID]
+}
+
+func (as *AuthServer) Introspect(ctx context.Context, req *pb.IntrospectRequest) (*pb.IntrospectResponse, error) {
+	if err := as.authorizeIntrospection(ctx); err != nil {
+		return nil, err
+	}
+
+	proof, err := dpopRequest(req.DpopProof, req.Htm, req.Htu)
+	if err != nil {
+		return nil, err
+	}
+	in, err := as.TokenService.IntrospectForRequest(ctx, req.Token, proof)
+	if err == nil && !in.Active {
+		in, err = as.ServiceAccounts.Introspect(ctx, req.Token)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !in.Active {
+		return &pb.IntrospectResponse{}, nil
+	}
+	metadata, err := claimsMetadataToPB(in.Metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	// never let a cached result outlive the token
+	cacheTTL := min(as.references.cacheTTL, time.Until(in.ExpiresAt))
+	resp := &pb.IntrospectResponse{
+		Active:    true,
+		TokenType: in.TokenType,
+		UserId:    in.UserID,
+		Scope:     in.Scope,
+		SessionId: in.SessionID,
+		SubType:   in.SubjectType,
+		Jti:       in.JTI,
+		DpopJkt:   in.DPoPJKT,
+		OneTime:   in.OneTime,
+		Roles:     in.Roles,
+		Metadata:  metadata,
+		Actors:    in.Actors,
+		ExpiresAt: timestamppb.New(in.ExpiresAt),
+		CacheTtl:  durationpb.New(cacheTTL),
+	}
+	if !in.IssuedAt.IsZero() {
+		resp.IssuedAt = timestamppb.New(in.IssuedAt)
+	}
+	return resp, nil
+}
+
+// ValidateBatch validates many access tokens at once. Tokens that are not
+// valid, or could not be checked, get a per-token error instead of failing
+// the whole call.
+func (as *AuthServer) ValidateBatch(ctx context.Context, req *pb.ValidateBatchRequest) (*pb.ValidateBatchResponse, error) {
+	if err := as.authorizeIntrospection(ctx); err != nil {
+		return nil, err
+	}
+
+	proofs := make([]services.DPoPRequest, len(req.DpopProofs))
+	for i, p := range req.DpopProofs {
+		proof, err := dpopRequest(p.GetProof(), p.GetHtm(), p.GetHtu())
+		if err != nil {
+			return nil, err
+		}
+		proofs[i] = proof
+	}
+	results, err := as.TokenService.ValidateAccessBatch(ctx, req.Tokens, proofs)
+	if err != nil {
+		return nil, err
+	}
+	resp := &pb.ValidateBatchResponse{Results: make([]*pb.TokenValidation, len(results))}
+	for i, r := range results {
+		switch {
+		case r.Err == autherr.ErrTokenExpired:
+			resp.Results[i] = &pb.TokenValidation{Error: "token_expired"}
+		case status.Code(r.Err) == codes.Unauthenticated:
+			resp.Results[i] = &pb.TokenValidation{Error: "invalid_token"}
+		case r.Err != nil:
+			resp.Results[i] = &pb.TokenValidation{Error: "unavailable"}
+		default:
+			resp.Results[i] = &pb.TokenValidation{
+				Valid:     true,
+				UserId:    r.UserID,
+				Scope:     r.Scope,
+				SessionId: r.SessionID,
+				SubType:   r.SubjectType,
+				Jti:       r.JTI,
+				DpopJkt:   r.DPoPJKT,
+				OneTime:   r.OneTime,
+				ExpiresAt: timestamppb.New(r.ExpiresAt),
+			}
+		}
+	}
+	return resp, nil
+}
+
+// dpopRequest is the DPoP proof a resource server received with a token it
+// introspects; the proof is only meaningful with the request's method and URL.
+func dpopRequest(proof, htm, htu string) (services.DPoPRequest, error) {
+	if proof != "" && (htm == "" || htu == "") {
+		return services.DPoPRequest{}, autherr.ErrBadRequest.WithMessage("htm and htu must be set with a DPoP proof")
+	}
+	return services.DPoPRequest{Proof: proof, Method: htm, Target: htu}, nil
+}
+
+// authorizeIntrospection checks the x-introspection-key of the call.
+func (as *AuthServer) authorizeIntrospection(ctx context.Context) error {
+	if as.references.key == "" {
+		return autherr.ErrForbidden.WithMessage("introspection is disabled")
+	}
+	key := firstMetadata(ctx, introspectionKeyMetadataKey)
+	if key == "" || subtle.ConstantTimeCompare([]byte(key), []byte(as.references.key)) != 1 {
+		return autherr.ErrForbidden
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package rpc
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/andro-kes/auth_service/internal/autherr"
+	"github.com/andro-kes/auth_service/internal/config"
+	"github.com/andro-kes/auth_service/internal/ldap"
+	"github.com/andro-kes/auth_service/internal/logger"
+	"github.com/andro-kes/auth_service/internal/models"
+	"go.uber.org/zap"
+)
+
+// ldapDirectory is a directory password logins are checked against; name
+// is the provider of its users' identities.
+type ldapDirectory struct {
+	name string
+	dir  *ldap.Directory
+}
+
+// anyClient selects a directory for the clients not assigned another one.
+const anyClient = "*"
+
+// newLDAPDirectories returns the directories of cfg by the client IDs that
+// use them.
+func newLDAPDirectories(cfg []config.LDAPDirectory) (map[string]ldapDirectory, error) {
+	byClient := map[string]ldapDirectory{}
+	for _, d := range cfg {
+		var tlsConfig *tls.Config
+		if d.CAFile != "" {
+			pem, err := os.ReadFile(d.CAFile)
+			if err != nil {
+				return nil, fmt.Errorf("LDAP directory %s: failed to read CA file: %w", d.Name, err)
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("LDAP directory %s: no certificates found in %s", d.Name, d.CAFile)
+			}
+			tlsConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+		}
+		attrs := ldap.DefaultAttributes
+		if d.ActiveDirectory {
+			attrs = ldap.ActiveDirectoryAttributes
+		}
+		for field, dst := range map[string]*string{
+			"subject":      &attrs.Subject,
+			"username":     &attrs.Username,
+			"email":        &attrs.Email,
+			"first_name":   &attrs.FirstName,
+			"last_name":    &attrs.LastName,
+			"display_name": &attrs.DisplayName,
+		} {
+			if attr, ok := d.Attributes[field]; ok {
+				*dst = attr
+			}
+		}
+		dir, err := ldap.New(ldap.Config{
+			URL:          d.URL,
+			StartTLS:     d.StartTLS,
+			TLS:          tlsConfig,
+			BindDN:       d.BindDN,
+			BindPassword: d.BindPassword,
+			BaseDN:       d.BaseDN,
+			UserFilter:   d.UserFilter,
+			Attributes:   attrs,
+			TrustEmail:   d.TrustEmail,
+			Timeout:      d.Timeout,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("LDAP directory %s: %w", d.Name, err)
+		}
+		for _, client := range d.Clients {
+			byClient[client] = ldapDirectory{name: d.Name, dir: dir}
+		}
+	}
+	return byClient, nil
+}
+
+// passwordLogin checks a login's password against the directory of its
+// client, provisioning the user on the first login, or locally.
+func (as *AuthServer) passwordLogin(ctx context.Context, login, password, clientID string) (*models.User, error) {
+	d, ok := as.directories[clientID]
+	if !ok {
+		d, ok = as.directories[anyClient]
+	}
+	if !ok {
+		return as.UserService.Login(ctx, login, password)
+	}
+	identity, err := d.dir.Authenticate(ctx, login, password)
+	switch {
+	case errors.Is(err, ldap.ErrInvalidCredentials):
+		return nil, autherr.ErrLoginUser
+	case err != nil:
+		logger.FromContext(ctx).Error("LDAP directory unavailable", zap.String("directory", d.name), zap.Error(err))
+		return nil, autherr.ErrUnavailable
+	}
+	return as.Federation.LoginIdentity(ctx, d.name, identity, clientInfo(ctx))
+}
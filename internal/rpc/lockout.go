@@ -0,0 +1,86 @@
+package rpc
+
+import (
+	"context"
+
+	"github.com/andro-kes/auth_service/internal/autherr"
+	"github.com/andro-kes/auth_service/internal/logger"
+	pb "github.com/andro-kes/auth_service/proto"
+	"go.uber.org/zap"
+)
+
+// loginAccount returns the account failed logins with login are counted
+// under: the user it names, so that the username and the email of a user
+// share one count, or else the login itself. userID is "" when no user
+// matched.
+func (as *AuthServer) loginAccount(ctx context.Context, login string) (account, userID string) {
+	if as.loginGuard == nil {
+		return "", ""
+	}
+	if userID = as.UserService.LoginUserID(ctx, login); userID != "" {
+		return userAccount(userID), userID
+	}
+	return "login:" + login, ""
+}
+
+// userAccount returns the login guard account of the user userID.
+func userAccount(userID string) string {
+	return "user:" + userID
+}
+
+// checkLockout refuses logins to a locked account. Like the backoff it is
+// best effort: a Redis outage must not block logins.
+func (as *AuthServer) checkLockout(ctx context.Context, account string) error {
+	locked, err := as.loginGuard.Locked(ctx, account)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Login lockout unavailable", zap.Error(err))
+		return nil
+	}
+	if locked > 0 {
+		return autherr.ErrAccountLocked
+	}
+	return nil
+}
+
+// failLogin counts a failed login and, when it locks the account of the user
+// userID, mails them an unlock link.
+func (as *AuthServer) failLogin(ctx context.Context, account, userID, ip string) {
+	locked, err := as.loginGuard.Fail(ctx, account, ip)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Failed to record login failure", zap.Error(err))
+		return
+	}
+	if !locked {
+		return
+	}
+	logger.FromContext(ctx).Warn("Account locked after failed logins", zap.String("user_id", userID))
+	if userID == "" {
+		return
+	}
+	if err := as.AccountUnlocks.SendUnlock(ctx, userID, as.loginGuard.LockoutDuration()); err != nil {
+		logger.FromContext(ctx).Error("Failed to send unlock link", zap.String("user_id", userID), zap.Error(err))
+	}
+}
+
+// unlockUser lifts the lockout of the user userID; failing to do so is only
+// logged, the lock runs out anyway.
+func (as *AuthServer) unlockUser(ctx context.Context, userID string) {
+	if err := as.loginGuard.Unlock(ctx, userAccount(userID)); err != nil {
+		logger.FromContext(ctx).Warn("Failed to unlock account", zap.String("user_id", userID), zap.Error(err))
+	}
+}
+
+func (as *AuthServer) UnlockAccount(ctx context.Context, req *pb.UnlockAccountRequest) (*pb.UnlockAccountResponse, error) {
+	if err := as.limitRate(ctx); err != nil {
+		return nil, err
+	}
+	userID, err := as.AccountUnlocks.Unlock(ctx, req.UnlockToken)
+	if err != nil {
+		return nil, err
+	}
+	if err := as.loginGuard.Unlock(ctx, userAccount(userID)); err != nil {
+		return nil, autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	logger.FromContext(ctx).Info("Account unlocked", zap.String("user_id", userID))
+	return &pb.UnlockAccountResponse{}, nil
+}
@@ -0,0 +1,69 @@
+package rpc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/andro-kes/auth_service/internal/autherr"
+	"github.com/andro-kes/auth_service/internal/loginguard"
+	"github.com/andro-kes/auth_service/internal/services"
+	pb "github.com/andro-kes/auth_service/proto"
+	"github.com/redis/go-redis/v9"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestLockout(t *testing.T) {
+	srv, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("failed to start miniredis: %v", err)
+	}
+	defer srv.Close()
+	rdb := redis.NewClient(&redis.Options{Addr: srv.Addr()})
+	defer rdb.Close()
+
+	tokens, err := services.NewTokenService(rdb, "012345678901234567890123456789ab", time.Minute, time.Hour)
+	if err != nil {
+		t.Fatalf("failed to create TokenService: %v", err)
+	}
+	as := &AuthServer{
+		TokenService:   tokens,
+		AccountUnlocks: &services.AccountUnlockService{Tokens: tokens},
+		loginGuard: loginguard.New(rdb, loginguard.Config{
+			Window:           time.Hour,
+			LockoutThreshold: 2,
+			LockoutDuration:  time.Hour,
+		}),
+	}
+	ctx := t.Context()
+
+	account := userAccount("alice")
+	for i := 0; i < 2; i++ {
+		if err := as.checkLockout(ctx, account); err != nil {
+			t.Fatalf("expected the account to be open after %d failures, got %v", i, err)
+		}
+		// no user ID: nothing is mailed
+		as.failLogin(ctx, account, "", "10.0.0.1")
+	}
+	if err := as.checkLockout(ctx, account); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("expected the account to be locked, got %v", err)
+	}
+	if services.LoginFailureReason(autherr.ErrAccountLocked) != services.LoginFailureAccountLocked {
+		t.Fatal("expected a lockout to be recorded as such")
+	}
+
+	token, _, err := tokens.IssuePurposeToken(ctx, services.PurposeAccountUnlock, "alice", time.Hour)
+	if err != nil {
+		t.Fatalf("IssuePurposeToken failed: %v", err)
+	}
+	if _, err := as.UnlockAccount(ctx, &pb.UnlockAccountRequest{UnlockToken: token}); err != nil {
+		t.Fatalf("UnlockAccount failed: %v", err)
+	}
+	if err := as.checkLockout(ctx, account); err != nil {
+		t.Fatalf("expected the account to be unlocked, got %v", err)
+	}
+	if _, err := as.UnlockAccount(ctx, &pb.UnlockAccountRequest{UnlockToken: token}); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected a used token to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,69 @@
+package rpc
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/andro-kes/auth_service/internal/logger"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// requestIDMetadataKey carries the request ID in both directions: a caller
+// may set it to correlate its own logs, and the one used is always
+// returned as a response header.
+const requestIDMetadataKey = "x-request-id"
+
+// healthMethodPrefix marks the health checks probed every few seconds,
+// logged at debug level only.
+const healthMethodPrefix = "/grpc.health.v1.Health/"
+
+// LoggingInterceptor attaches a request ID to the context of every unary
+// call, so that logger.FromContext tags the log lines of services with it,
+// and logs the method, peer, duration and status code of the call.
+func LoggingInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	id := logger.EnsureRequestID(firstMetadata(ctx, requestIDMetadataKey))
+	ctx = logger.WithRequestID(ctx, id)
+	_ = grpc.SetHeader(ctx, metadata.Pairs(requestIDMetadataKey, id))
+
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	code := status.Code(err)
+
+	level := zapcore.InfoLevel
+	switch {
+	case strings.HasPrefix(info.FullMethod, healthMethodPrefix):
+		level = zapcore.DebugLevel
+	case serverFault(code):
+		level = zapcore.WarnLevel
+	}
+	fields := []zap.Field{
+		zap.String("method", info.FullMethod),
+		zap.String("code", code.String()),
+		zap.Duration("duration", time.Since(start)),
+	}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		fields = append(fields, zap.String("peer", p.Addr.String()))
+	}
+	if err != nil && level != zapcore.InfoLevel {
+		fields = append(fields, zap.Error(err))
+	}
+	logger.FromContext(ctx).Log(level, "RPC finished", fields...)
+	return resp, err
+}
+
+// serverFault reports whether code means the call failed on the server's
+// side rather than because of its input or credentials.
+func serverFault(code codes.Code) bool {
+	switch code {
+	case codes.Unknown, codes.Internal, codes.DataLoss, codes.Unavailable, codes.DeadlineExceeded:
+		return true
+	}
+	return false
+}
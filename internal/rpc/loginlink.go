@@ -0,0 +1,42 @@
+package rpc
+
+import (
+	"context"
+
+	"github.com/andro-kes/auth_service/internal/autherr"
+	"github.com/andro-kes/auth_service/internal/logger"
+	pb "github.com/andro-kes/auth_service/proto"
+	"go.uber.org/zap"
+)
+
+func (as *AuthServer) RequestLoginLink(ctx context.Context, req *pb.RequestLoginLinkRequest) (*pb.RequestLoginLinkResponse, error) {
+	if as.LoginLinks == nil {
+		return nil, autherr.ErrForbidden.WithMessage("passwordless login is disabled")
+	}
+	if err := as.limitRate(ctx); err != nil {
+		return nil, err
+	}
+	if err := as.limitLoginMail(ctx, as.loginLinkLimiter, req.Login); err != nil {
+		return nil, err
+	}
+	if err := as.LoginLinks.RequestLoginLink(ctx, req.Login); err != nil {
+		return nil, err
+	}
+	return &pb.RequestLoginLinkResponse{}, nil
+}
+
+func (as *AuthServer) CompleteLoginLink(ctx context.Context, req *pb.CompleteLoginLinkRequest) (*pb.TokenResponse, error) {
+	if as.LoginLinks == nil {
+		return nil, autherr.ErrForbidden.WithMessage("passwordless login is disabled")
+	}
+	if err := as.limitRate(ctx); err != nil {
+		return nil, err
+	}
+	user, err := as.LoginLinks.CompleteLoginLink(ctx, req.Token)
+	if err != nil {
+		return nil, err
+	}
+	// the link replaces the password, not the second factor
+	logger.FromContext(ctx).Info("Login link accepted", zap.String("username", user.Username))
+	return as.completeLogin(ctx, user, req.RememberMe, req.ClientId)
+}
@@ -0,0 +1,32 @@
+package rpc
+
+import (
+	"context"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// dpopMetadataKey carries the DPoP proof, mirroring the HTTP "DPoP" header.
+const dpopMetadataKey = "dpop"
+
+// clientIDMetadataKey identifies the calling client application.
+const clientIDMetadataKey = "x-client-id"
+
+// refreshTokenMetadataKey carries the refresh token of Logout.
+const refreshTokenMetadataKey = "x-refresh-token"
+
+// dpopProof returns the DPoP proof sent with the request, if any.
+func dpopProof(ctx context.Context) string {
+	return firstMetadata(ctx, dpopMetadataKey)
+}
+
+func firstMetadata(ctx context.Context, key string) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	if v := md.Get(key); len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
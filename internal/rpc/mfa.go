@@ -0,0 +1,173 @@
+package rpc
+
+import (
+	"context"
+	"time"
+
+	"github.com/andro-kes/auth_service/internal/autherr"
+	"github.com/andro-kes/auth_service/internal/logger"
+	"github.com/andro-kes/auth_service/internal/models"
+	"github.com/andro-kes/auth_service/internal/services"
+	pb "github.com/andro-kes/auth_service/proto"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+func (as *AuthServer) EnrollTOTP(ctx context.Context, req *pb.EnrollTOTPRequest) (*pb.EnrollTOTPResponse, error) {
+	if err := as.limitRate(ctx); err != nil {
+		return nil, err
+	}
+	var userID string
+	if req.MfaToken != "" {
+		challenge, err := as.TokenService.MFAChallenge(ctx, req.MfaToken)
+		if err != nil {
+			return nil, err
+		}
+		if !challenge.Enroll {
+			return nil, autherr.ErrForbidden.WithMessage("mfa token does not allow enrollment")
+		}
+		userID = challenge.UserID
+	} else {
+		var err error
+		if userID, err = as.authenticate(ctx); err != nil {
+			return nil, err
+		}
+	}
+	enrollment, err := as.MFA.EnrollTOTP(ctx, userID, clientInfo(ctx))
+	if err != nil {
+		return nil, err
+	}
+	return &pb.EnrollTOTPResponse{
+		Secret:        enrollment.Secret,
+		Uri:           enrollment.URI,
+		RecoveryCodes: enrollment.RecoveryCodes,
+	}, nil
+}
+
+func (as *AuthServer) VerifyTOTP(ctx context.Context, req *pb.VerifyTOTPRequest) (*pb.VerifyTOTPResponse, error) {
+	if err := as.limitRate(ctx); err != nil {
+		return nil, err
+	}
+	userID, err := as.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := as.MFA.VerifyTOTP(ctx, userID, req.Code, clientInfo(ctx)); err != nil {
+		return nil, err
+	}
+	return &pb.VerifyTOTPResponse{Enabled: true}, nil
+}
+
+func (as *AuthServer) CompleteMFALogin(ctx context.Context, req *pb.CompleteMFALoginRequest) (*pb.TokenResponse, error) {
+	if err := as.limitRate(ctx); err != nil {
+		return nil, err
+	}
+	challenge, err := as.TokenService.MFAChallenge(ctx, req.MfaToken)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case req.RecoveryCode != "":
+		err = as.MFA.UseRecoveryCode(ctx, challenge.UserID, req.RecoveryCode, clientInfo(ctx))
+	case req.SmsCode != "":
+		err = as.MFA.VerifySMS(ctx, challenge.UserID, req.SmsCode, clientInfo(ctx))
+	default:
+		err = as.MFA.VerifyTOTP(ctx, challenge.UserID, req.Code, clientInfo(ctx))
+	}
+	if err != nil {
+		if err == autherr.ErrInvalidMFA {
+			as.UserService.RecordFailedLogin(ctx, challenge.Username, clientInfo(ctx).IP, services.LoginFailureInvalidMFA)
+			if ferr := as.TokenService.FailMFAChallenge(ctx, req.MfaToken); ferr != nil {
+				logger.FromContext(ctx).Warn("Failed to record mfa failure", zap.Error(ferr))
+			}
+		}
+		return nil, err
+	}
+	if err := as.TokenService.RedeemMFAChallenge(ctx, req.MfaToken); err != nil {
+		return nil, err
+	}
+	logger.FromContext(ctx).Info("User logged in", zap.String("username", challenge.Username))
+	return as.loginTokens(ctx, challenge.UserID, challenge.RememberMe, challenge.ClientID)
+}
+
+func (as *AuthServer) RegenerateRecoveryCodes(ctx context.Context, _ *pb.RegenerateRecoveryCodesRequest) (*pb.RegenerateRecoveryCodesResponse, error) {
+	if err := as.limitRate(ctx); err != nil {
+		return nil, err
+	}
+	userID, err := as.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	codes, err := as.MFA.RegenerateRecoveryCodes(ctx, userID, clientInfo(ctx))
+	if err != nil {
+		return nil, err
+	}
+	return &pb.RegenerateRecoveryCodesResponse{RecoveryCodes: codes}, nil
+}
+
+func (as *AuthServer) SetPhone(ctx context.Context, req *pb.SetPhoneRequest) (*pb.SetPhoneResponse, error) {
+	if err := as.limitRate(ctx); err != nil {
+		return nil, err
+	}
+	userID, err := as.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	expires, err := as.MFA.SetPhone(ctx, userID, req.Phone, clientInfo(ctx))
+	if err != nil {
+		return nil, err
+	}
+	return &pb.SetPhoneResponse{CodeExpiresIn: durationpb.New(time.Until(expires))}, nil
+}
+
+func (as *AuthServer) VerifyPhone(ctx context.Context, req *pb.VerifyPhoneRequest) (*pb.VerifyPhoneResponse, error) {
+	if err := as.limitRate(ctx); err != nil {
+		return nil, err
+	}
+	userID, err := as.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := as.MFA.VerifyPhone(ctx, userID, req.Code, req.UseForMfa, clientInfo(ctx)); err != nil {
+		return nil, err
+	}
+	return &pb.VerifyPhoneResponse{}, nil
+}
+
+func (as *AuthServer) SendMFASMS(ctx context.Context, req *pb.SendMFASMSRequest) (*pb.SendMFASMSResponse, error) {
+	if err := as.limitRate(ctx); err != nil {
+		return nil, err
+	}
+	challenge, err := as.TokenService.MFAChallenge(ctx, req.MfaToken)
+	if err != nil {
+		return nil, err
+	}
+	expires, err := as.MFA.SendLoginSMS(ctx, challenge.UserID)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.SendMFASMSResponse{CodeExpiresIn: durationpb.New(time.Until(expires))}, nil
+}
+
+// mfaChallenge answers a login whose password was accepted with the token
+// that completes it with the second factor. With enroll the user has none
+// yet and sets up TOTP with the token first.
+func (as *AuthServer) mfaChallenge(ctx context.Context, user *models.User, rememberMe bool, clientID string, enroll bool) (*pb.TokenResponse, error) {
+	token, exp, err := as.TokenService.IssueMFAChallenge(ctx, services.MFAChallenge{
+		UserID:     user.ID,
+		Username:   user.Username,
+		RememberMe: rememberMe,
+		ClientID:   clientID,
+		Enroll:     enroll,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &pb.TokenResponse{
+		UserId:                user.ID,
+		MfaRequired:           true,
+		MfaToken:              token,
+		MfaExpiresIn:          durationpb.New(time.Until(exp)),
+		MfaEnrollmentRequired: enroll,
+	}, nil
+}
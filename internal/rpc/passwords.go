@@ -0,0 +1,113 @@
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/andro-kes/auth_service/internal/config"
+	"github.com/andro-kes/auth_service/internal/logger"
+	"github.com/andro-kes/auth_service/internal/passwordpolicy"
+	pb "github.com/andro-kes/auth_service/proto"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+func (as *AuthServer) ChangePassword(ctx context.Context, req *pb.ChangePasswordRequest) (*pb.ChangePasswordResponse, error) {
+	if err := as.limitRate(ctx); err != nil {
+		return nil, err
+	}
+	userID, err := as.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := as.UserService.ChangePassword(ctx, userID, req.CurrentPassword, req.NewPassword); err != nil {
+		return nil, err
+	}
+	if err := as.TokenService.PasswordChanged(ctx, userID); err != nil {
+		return nil, err
+	}
+	return &pb.ChangePasswordResponse{}, nil
+}
+
+func (as *AuthServer) RequestPasswordReset(ctx context.Context, req *pb.RequestPasswordResetRequest) (*pb.RequestPasswordResetResponse, error) {
+	if err := as.limitRate(ctx); err != nil {
+		return nil, err
+	}
+	if err := as.limitLoginMail(ctx, as.passwordResetLimiter, req.Login); err != nil {
+		return nil, err
+	}
+	if err := as.PasswordResets.RequestPasswordReset(ctx, req.Login); err != nil {
+		return nil, err
+	}
+	return &pb.RequestPasswordResetResponse{}, nil
+}
+
+func (as *AuthServer) ResetPassword(ctx context.Context, req *pb.ResetPasswordRequest) (*pb.ResetPasswordResponse, error) {
+	if err := as.limitRate(ctx); err != nil {
+		return nil, err
+	}
+	userID, err := as.PasswordResets.ResetPassword(ctx, req.ResetToken, req.NewPassword)
+	if err != nil {
+		return nil, err
+	}
+	// whoever reset the password owns the account
+	as.unlockUser(ctx, userID)
+	return &pb.ResetPasswordResponse{}, nil
+}
+
+func (as *AuthServer) ChangeUsername(ctx context.Context, req *pb.ChangeUsernameRequest) (*pb.TokenResponse, error) {
+	if err := as.limitRate(ctx); err != nil {
+		return nil, err
+	}
+	userID, err := as.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := as.UserService.ChangeUsername(ctx, userID, req.NewUsername); err != nil {
+		return nil, err
+	}
+	if err := as.TokenService.RotateUserTokens(ctx, userID); err != nil {
+		return nil, err
+	}
+	opts, err := as.issueOptions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	accessToken, refreshToken, accessExp, refreshExp, err := as.TokenService.GenerateTokens(ctx, userID, opts...)
+	if err != nil {
+		logger.FromContext(ctx).Error("Failed to generate tokens", zap.Error(err))
+		return nil, err
+	}
+	return &pb.TokenResponse{
+		AccessToken:      accessToken,
+		RefreshToken:     refreshToken,
+		AccessExpiresIn:  durationpb.New(time.Until(accessExp)),
+		RefreshExpiresIn: durationpb.New(time.Until(refreshExp)),
+		UserId:           userID,
+	}, nil
+}
+
+func newPasswordPolicy(cfg config.Passwords) (*passwordpolicy.Policy, error) {
+	var banned []string
+	if cfg.BannedFile != "" {
+		f, err := os.Open(cfg.BannedFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open banned passwords: %w", err)
+		}
+		defer f.Close()
+		if banned, err = passwordpolicy.ReadList(f); err != nil {
+			return nil, fmt.Errorf("failed to read banned passwords: %w", err)
+		}
+	}
+	return passwordpolicy.New(passwordpolicy.Config{
+		MinLength:  cfg.MinLength,
+		MaxLength:  cfg.MaxLength,
+		MinClasses: cfg.MinClasses,
+		Banned:     banned,
+		MinScore:   cfg.MinScore,
+	}), nil
+}
@@ -0,0 +1,130 @@
+package rpc
+
+import (
+	"context"
+	"slices"
+	"strings"
+
+	"github.com/andro-kes/auth_service/internal/autherr"
+	"github.com/andro-kes/auth_service/internal/models"
+	pb "github.com/andro-kes/auth_service/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func (as *AuthServer) GetProfile(ctx context.Context, _ *pb.GetProfileRequest) (*pb.GetProfileResponse, error) {
+	userID, err := as.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := as.UserService.GetProfile(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	profile, err := profileToPB(&user.Profile)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.GetProfileResponse{
+		UserId:   user.ID,
+		Username: user.Username,
+		Email:    user.Email,
+		Profile:  profile,
+	}, nil
+}
+
+func (as *AuthServer) UpdateProfile(ctx context.Context, req *pb.UpdateProfileRequest) (*pb.UpdateProfileResponse, error) {
+	userID, err := as.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	in := req.GetProfile()
+	profile := &models.Profile{
+		FirstName:   in.GetFirstName(),
+		LastName:    in.GetLastName(),
+		DisplayName: in.GetDisplayName(),
+	}
+	if in.GetMetadata() != nil {
+		profile.Metadata = in.GetMetadata().AsMap()
+	}
+	// metadata is replaced as a whole, so nested paths such as the ones the
+	// HTTP gateway derives from a JSON body name their top-level field
+	var fields []string
+	for _, path := range req.GetUpdateMask().GetPaths() {
+		field, _, _ := strings.Cut(path, ".")
+		if !slices.Contains(fields, field) {
+			fields = append(fields, field)
+		}
+	}
+
+	user, err := as.UserService.UpdateProfile(ctx, userID, profile, fields)
+	if err != nil {
+		return nil, err
+	}
+	out, err := profileToPB(&user.Profile)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.UpdateProfileResponse{Profile: out}, nil
+}
+
+// GetUser serves resource servers, which hold the introspection key, and
+// admins.
+func (as *AuthServer) GetUser(ctx context.Context, req *pb.GetUserRequest) (*pb.GetUserResponse, error) {
+	if err := as.requireAdmin(ctx); err != nil {
+		if err := as.authorizeIntrospection(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	user, err := as.UserService.GetUser(ctx, req.UserId)
+	if err != nil {
+		return nil, err
+	}
+	return userToPB(user)
+}
+
+// userToPB returns the public fields of user.
+func userToPB(user *models.User) (*pb.GetUserResponse, error) {
+	profile, err := profileToPB(&user.Profile)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.GetUserResponse{
+		UserId:      user.ID,
+		Username:    user.Username,
+		Email:       user.Email,
+		Profile:     profile,
+		CreatedAt:   timestamppb.New(user.CreatedAt),
+		Status:      statusToPB(user.Status),
+		LastLoginAt: timestampOrNil(user.LastLoginAt),
+		LastLoginIp: user.LastLoginIP,
+	}, nil
+}
+
+// claimsMetadataToPB converts the "meta" claim; tokens without one get nil.
+func claimsMetadataToPB(m map[string]any) (*structpb.Struct, error) {
+	if len(m) == 0 {
+		return nil, nil
+	}
+	metadata, err := structpb.NewStruct(m)
+	if err != nil {
+		return nil, autherr.ErrInvalidToken.WithMessage("invalid metadata claim")
+	}
+	return metadata, nil
+}
+
+func profileToPB(p *models.Profile) (*pb.Profile, error) {
+	metadata, err := structpb.NewStruct(p.Metadata)
+	if err != nil {
+		return nil, autherr.ErrStorageError.WithMessage("invalid profile metadata")
+	}
+	return &pb.Profile{
+		FirstName:   p.FirstName,
+		LastName:    p.LastName,
+		DisplayName: p.DisplayName,
+		Metadata:    metadata,
+	}, nil
+}
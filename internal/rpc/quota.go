@@ -0,0 +1,114 @@
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/andro-kes/auth_service/internal/logger"
+	"github.com/andro-kes/auth_service/internal/ratelimit"
+	"github.com/andro-kes/auth_service/internal/services"
+	"go.uber.org/zap"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/protoadapt"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// unknownPeer is the rate limit key of calls whose client IP is unknown.
+const unknownPeer = "unknown"
+
+// limitRate counts the call against the per-IP rate limit. Calls without a
+// client IP share one bucket rather than going unlimited. Like login
+// backoff it is best effort: a Redis outage lets the call through.
+func (as *AuthServer) limitRate(ctx context.Context) error {
+	ip := clientInfo(ctx).IP
+	if ip == "" {
+		ip = unknownPeer
+	}
+	q, err := as.rateLimiter.Take(ctx, ip)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Rate limiter unavailable", zap.Error(err))
+		return nil
+	}
+	return checkQuota(ctx, q)
+}
+
+// limitLoginMail caps with l the mails, such as login links, requested for
+// login, whichever client asks for them, so that a mailbox cannot be
+// flooded.
+func (as *AuthServer) limitLoginMail(ctx context.Context, l *ratelimit.Limiter, login string) error {
+	q, err := l.Take(ctx, strings.ToLower(login))
+	if err != nil {
+		logger.FromContext(ctx).Warn("Rate limiter unavailable", zap.Error(err))
+		return nil
+	}
+	return checkQuota(ctx, q)
+}
+
+// limitSessions checks the cap on concurrent sessions of userID before a
+// new one is started, reporting the quota once it is nearly exhausted. The
+// cap itself is enforced atomically when the session is issued, see
+// sessionLimit.
+func (as *AuthServer) limitSessions(ctx context.Context, userID string) error {
+	if as.maxSessions <= 0 {
+		return nil
+	}
+	sessions, err := as.TokenService.ListSessions(ctx, userID)
+	if err != nil {
+		return err
+	}
+	return checkQuota(ctx, ratelimit.Quota{
+		Policy:    "sessions",
+		Limit:     as.maxSessions,
+		Remaining: max(as.maxSessions-len(sessions)-1, 0),
+		Exceeded:  len(sessions) >= as.maxSessions,
+	})
+}
+
+// sessionLimit returns the issue options enforcing the cap on concurrent
+// sessions.
+func (as *AuthServer) sessionLimit() []services.IssueOption {
+	if as.maxSessions <= 0 {
+		return nil
+	}
+	return []services.IssueOption{services.WithSessionLimit(as.maxSessions)}
+}
+
+// sessionsExceeded is the error of a login refused by the session cap.
+func (as *AuthServer) sessionsExceeded(ctx context.Context) error {
+	return checkQuota(ctx, ratelimit.Quota{Policy: "sessions", Limit: as.maxSessions, Exceeded: true})
+}
+
+// checkQuota reports q in the trailers once it is nearly exhausted and turns
+// an exceeded quota into ResourceExhausted carrying QuotaFailure and, for
+// windowed limits, RetryInfo details.
+func checkQuota(ctx context.Context, q ratelimit.Quota) error {
+	if q.Limit == 0 || !q.Near() {
+		return nil
+	}
+	// fails only outside a gRPC call, e.g. in tests
+	_ = grpc.SetTrailer(ctx, q.Metadata())
+	if !q.Exceeded {
+		return nil
+	}
+
+	msg := fmt.Sprintf("%s quota exceeded", q.Policy)
+	st := status.New(codes.ResourceExhausted, msg)
+	details := []protoadapt.MessageV1{&errdetails.QuotaFailure{
+		Violations: []*errdetails.QuotaFailure_Violation{{
+			Subject:     q.Policy,
+			Description: fmt.Sprintf("limit of %d reached", q.Limit),
+		}},
+	}}
+	if d := q.RetryAfter(); d > 0 {
+		details = append(details, &errdetails.RetryInfo{RetryDelay: durationpb.New(d)})
+	}
+	withDetails, err := st.WithDetails(details...)
+	if err != nil {
+		return st.Err()
+	}
+	return withDetails.Err()
+}
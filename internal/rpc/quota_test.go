@@ -0,0 +1,34 @@
+package rpc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/andro-kes/auth_service/internal/ratelimit"
+	"github.com/redis/go-redis/v9"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestLimitRateWithoutIP(t *testing.T) {
+	srv, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("failed to start miniredis: %v", err)
+	}
+	defer srv.Close()
+	rdb := redis.NewClient(&redis.Options{Addr: srv.Addr()})
+	defer rdb.Close()
+
+	as := &AuthServer{rateLimiter: ratelimit.New(rdb, "requests", 2, time.Minute)}
+	// no peer and no forwarded address: the calls share one bucket
+	ctx := t.Context()
+	for i := 0; i < 2; i++ {
+		if err := as.limitRate(ctx); err != nil {
+			t.Fatalf("call %d: expected to pass, got %v", i, err)
+		}
+	}
+	if err := as.limitRate(ctx); status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("expected calls without an IP to be limited, got %v", err)
+	}
+}
@@ -0,0 +1,64 @@
+package rpc
+
+import (
+	"context"
+	"time"
+
+	pb "github.com/andro-kes/auth_service/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func (as *AuthServer) SetRecoveryEmail(ctx context.Context, req *pb.SetRecoveryEmailRequest) (*pb.SetRecoveryEmailResponse, error) {
+	userID, err := as.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	expires, err := as.RecoveryService.SetRecoveryEmail(ctx, userID, req.Email, clientInfo(ctx))
+	if err != nil {
+		return nil, err
+	}
+	return &pb.SetRecoveryEmailResponse{CodeExpiresIn: durationpb.New(time.Until(expires))}, nil
+}
+
+func (as *AuthServer) VerifyRecoveryEmail(ctx context.Context, req *pb.VerifyRecoveryEmailRequest) (*pb.VerifyRecoveryEmailResponse, error) {
+	userID, err := as.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := as.RecoveryService.VerifyRecoveryEmail(ctx, userID, req.Code, clientInfo(ctx)); err != nil {
+		return nil, err
+	}
+	return &pb.VerifyRecoveryEmailResponse{}, nil
+}
+
+func (as *AuthServer) GetRecoveryEmail(ctx context.Context, _ *pb.GetRecoveryEmailRequest) (*pb.GetRecoveryEmailResponse, error) {
+	userID, err := as.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rec, err := as.RecoveryService.GetRecoveryEmail(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	resp := &pb.GetRecoveryEmailResponse{Email: rec.Email, Verified: rec.Verified()}
+	if rec.VerifiedAt != nil {
+		resp.VerifiedAt = timestamppb.New(*rec.VerifiedAt)
+	}
+	return resp, nil
+}
+
+func (as *AuthServer) RemoveRecoveryEmail(ctx context.Context, _ *pb.RemoveRecoveryEmailRequest) (*pb.RemoveRecoveryEmailResponse, error) {
+	userID, err := as.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := as.RecoveryService.RemoveRecoveryEmail(ctx, userID, clientInfo(ctx)); err != nil {
+		return nil, err
+	}
+	return &pb.RemoveRecoveryEmailResponse{}, nil
+}
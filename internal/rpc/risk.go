@@ -0,0 +1,80 @@
+package rpc
+
+import (
+	"context"
+	"time"
+
+	"github.com/andro-kes/auth_service/internal/config"
+	"github.com/andro-kes/auth_service/internal/logger"
+	"github.com/andro-kes/auth_service/internal/risk"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// newRiskEvaluator returns the heuristic evaluator configured by cfg, or nil
+// when risk evaluation is disabled.
+func newRiskEvaluator(rdb redis.UniversalClient, cfg config.Risk) (risk.Evaluator, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	newDevice, err := riskDecision(cfg.NewDevice)
+	if err != nil {
+		return nil, err
+	}
+	travel, err := riskDecision(cfg.ImpossibleTravel)
+	if err != nil {
+		return nil, err
+	}
+	return risk.NewHeuristic(rdb, risk.Config{
+		NewDevice:        newDevice,
+		ImpossibleTravel: travel,
+		MaxSpeed:         float64(cfg.MaxTravelSpeed),
+		History:          cfg.History,
+	}), nil
+}
+
+func riskDecision(s string) (risk.Decision, error) {
+	if s == "" {
+		return risk.RequireMFA, nil
+	}
+	return risk.ParseDecision(s)
+}
+
+// assessLogin evaluates a login of userID whose first factor was accepted.
+// Evaluation is best effort: when it fails the login is allowed.
+func (as *AuthServer) assessLogin(ctx context.Context, userID string) risk.Assessment {
+	if as.Risk == nil {
+		return risk.Assessment{}
+	}
+	a, err := as.Risk.Evaluate(ctx, riskLogin(ctx, userID))
+	if err != nil {
+		logger.FromContext(ctx).Warn("Risk evaluation unavailable", zap.Error(err))
+		return risk.Assessment{}
+	}
+	return a
+}
+
+// recordLogin feeds a completed login of userID to the risk history.
+func (as *AuthServer) recordLogin(ctx context.Context, userID string) {
+	if as.Risk == nil {
+		return
+	}
+	if err := as.Risk.Record(ctx, riskLogin(ctx, userID)); err != nil {
+		logger.FromContext(ctx).Warn("Failed to record login for risk evaluation", zap.Error(err))
+	}
+}
+
+func riskLogin(ctx context.Context, userID string) risk.Login {
+	ci := clientInfo(ctx)
+	login := risk.Login{
+		UserID:    userID,
+		IP:        ci.IP,
+		UserAgent: ci.UserAgent,
+		DeviceID:  ci.DeviceID,
+		Time:      time.Now(),
+	}
+	if geo, ok := risk.ParseCoordinates(firstMetadata(ctx, geoMetadataKey)); ok {
+		login.Geo = &geo
+	}
+	return login
+}
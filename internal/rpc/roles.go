@@ -0,0 +1,74 @@
+package rpc
+
+import (
+	"context"
+
+	"github.com/andro-kes/auth_service/internal/autherr"
+	pb "github.com/andro-kes/auth_service/proto"
+)
+
+func (as *AuthServer) CreateRole(ctx context.Context, req *pb.CreateRoleRequest) (*pb.CreateRoleResponse, error) {
+	if err := as.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	if err := as.Roles.CreateRole(ctx, req.Name, req.Description, req.Permissions); err != nil {
+		return nil, err
+	}
+	return &pb.CreateRoleResponse{}, nil
+}
+
+func (as *AuthServer) AssignRole(ctx context.Context, req *pb.AssignRoleRequest) (*pb.AssignRoleResponse, error) {
+	if err := as.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	if err := as.Roles.AssignRole(ctx, req.UserId, req.Role); err != nil {
+		return nil, err
+	}
+	return &pb.AssignRoleResponse{}, nil
+}
+
+func (as *AuthServer) RevokeRole(ctx context.Context, req *pb.RevokeRoleRequest) (*pb.RevokeRoleResponse, error) {
+	if err := as.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	if err := as.Roles.RevokeRole(ctx, req.UserId, req.Role); err != nil {
+		return nil, err
+	}
+	return &pb.RevokeRoleResponse{}, nil
+}
+
+func (as *AuthServer) ListUserRoles(ctx context.Context, req *pb.ListUserRolesRequest) (*pb.ListUserRolesResponse, error) {
+	if err := as.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	if req.UserId == "" {
+		return nil, autherr.ErrBadRequest.WithMessage("user_id is required")
+	}
+	roles, err := as.Roles.UserRoles(ctx, req.UserId)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.ListUserRolesResponse{Roles: roles}, nil
+}
+
+func (as *AuthServer) SetRoleMFARequired(ctx context.Context, req *pb.SetRoleMFARequiredRequest) (*pb.SetRoleMFARequiredResponse, error) {
+	if err := as.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	if err := as.Roles.SetRoleMFARequired(ctx, req.Role, req.RequireMfa); err != nil {
+		return nil, err
+	}
+	return &pb.SetRoleMFARequiredResponse{}, nil
+}
+
+func (as *AuthServer) CheckPermission(ctx context.Context, req *pb.CheckPermissionRequest) (*pb.CheckPermissionResponse, error) {
+	userID, err := as.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	allowed, err := as.Roles.HasPermission(ctx, userID, req.Permission)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.CheckPermissionResponse{Allowed: allowed}, nil
+}
@@ -0,0 +1,70 @@
+package rpc
+
+import (
+	"context"
+	"time"
+
+	"github.com/andro-kes/auth_service/internal/config"
+	"github.com/andro-kes/auth_service/internal/services"
+	pb "github.com/andro-kes/auth_service/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// scopedPolicy decides the lifetime of scoped tokens and whether they are
+// one-time.
+type scopedPolicy struct {
+	ttl            time.Duration
+	oneTimeScopes  map[string]bool
+	oneTimeClients map[string]bool
+}
+
+func newScopedPolicy(cfg config.ScopedTokens) scopedPolicy {
+	p := scopedPolicy{
+		ttl:            cfg.TTL,
+		oneTimeScopes:  make(map[string]bool, len(cfg.OneTimeScopes)),
+		oneTimeClients: make(map[string]bool, len(cfg.OneTimeClients)),
+	}
+	for _, s := range cfg.OneTimeScopes {
+		p.oneTimeScopes[s] = true
+	}
+	for _, c := range cfg.OneTimeClients {
+		p.oneTimeClients[c] = true
+	}
+	return p
+}
+
+// oneTime reports whether tokens for scope issued to clientID are single use.
+// clientID must be authenticated, as by peerClientID: a self-asserted one
+// would let a client opt out of one-time tokens.
+func (p scopedPolicy) oneTime(scope, clientID string) bool {
+	return p.oneTimeScopes[scope] || (clientID != "" && p.oneTimeClients[clientID])
+}
+
+func (as *AuthServer) IssueScopedToken(ctx context.Context, req *pb.IssueScopedTokenRequest) (*pb.IssueScopedTokenResponse, error) {
+	userID, err := as.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// a scoped token must not be weaker than the token it was obtained with
+	var opts []services.IssueOption
+	if _, token, ok := accessToken(ctx); ok {
+		if jkt := as.TokenService.DPoPBinding(ctx, token); jkt != "" {
+			opts = append(opts, services.WithDPoPKey(jkt))
+		}
+	}
+
+	if as.references.reference(firstMetadata(ctx, clientIDMetadataKey)) {
+		opts = append(opts, services.AsReferenceToken())
+	}
+	oneTime := as.scoped.oneTime(req.Scope, peerClientID(ctx))
+	token, exp, err := as.TokenService.IssueScopedAccess(ctx, userID, req.Scope, as.scoped.ttl, oneTime, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.IssueScopedTokenResponse{
+		AccessToken: token,
+		ExpiresIn:   durationpb.New(time.Until(exp)),
+		OneTime:     oneTime,
+	}, nil
+}
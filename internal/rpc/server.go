@@ -2,54 +2,435 @@ package rpc
 
 import (
 	"context"
-	"os"
 	"time"
 
+	"github.com/andro-kes/auth_service/internal/alert"
 	"github.com/andro-kes/auth_service/internal/autherr"
+	"github.com/andro-kes/auth_service/internal/config"
+	"github.com/andro-kes/auth_service/internal/cryptoprov"
 	"github.com/andro-kes/auth_service/internal/logger"
+	"github.com/andro-kes/auth_service/internal/loginguard"
+	"github.com/andro-kes/auth_service/internal/mail"
+	"github.com/andro-kes/auth_service/internal/metrics"
+	"github.com/andro-kes/auth_service/internal/models"
+	"github.com/andro-kes/auth_service/internal/ratelimit"
+	"github.com/andro-kes/auth_service/internal/repo"
+	"github.com/andro-kes/auth_service/internal/risk"
+	"github.com/andro-kes/auth_service/internal/saml"
 	"github.com/andro-kes/auth_service/internal/services"
+	"github.com/andro-kes/auth_service/internal/sms"
+	"github.com/andro-kes/auth_service/internal/tokencache"
+	"github.com/andro-kes/auth_service/internal/workpool"
 	pb "github.com/andro-kes/auth_service/proto"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/redis/go-redis/v9"
 	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/durationpb"
 )
 
 type AuthServer struct {
 	pb.UnimplementedAuthServiceServer
-	UserService  *services.UserService
-	TokenService *services.TokenService
+	UserService     *services.UserService
+	TokenService    *services.TokenService
+	RecoveryService *services.RecoveryService
+	CanaryService   *services.CanaryService
+	ServiceAccounts *services.ServiceAccountService
+	Clients         *services.ClientService
+	APIKeys         *services.APIKeyService
+	Roles           *services.RoleService
+	Export          *services.ExportService
+	Erasure         *services.ErasureService
+	Identities      *services.IdentityService
+	Logouts         *services.LogoutService
+	MFA             *services.MFAService
+	// LoginLinks is nil unless passwordless login is enabled.
+	LoginLinks     *services.LoginLinkService
+	PasswordResets *services.PasswordResetService
+	AccountUnlocks *services.AccountUnlockService
+	Federation     *services.FederationService
+	// SAML are the SAML connections by name, served by the HTTP gateway;
+	// they are federation providers as well.
+	SAML map[string]*saml.ServiceProvider
+	// Risk evaluates logins once the password was accepted; nil disables
+	// the evaluation. It may be replaced by a custom risk.Evaluator.
+	Risk risk.Evaluator
+
+	// directories check the passwords of logins through the clients they
+	// are keyed by; other logins are checked locally.
+	directories map[string]ldapDirectory
+
+	bindCerts  bool
+	adminKey   string
+	scoped     scopedPolicy
+	references referencePolicy
+	loginGuard *loginguard.Guard
+
+	rateLimiter          *ratelimit.Limiter
+	loginLinkLimiter     *ratelimit.Limiter
+	passwordResetLimiter *ratelimit.Limiter
+	maxSessions          int
 }
 
-func NewAuthServer(ctx context.Context, pool *pgxpool.Pool) (*AuthServer, error) {
+// NewAuthServer wires the services from cfg on top of pool and rdb.
+// extraTokenOpts are appended to the token service options derived from cfg.
+func NewAuthServer(ctx context.Context, pool *pgxpool.Pool, rdb redis.UniversalClient, cfg *config.Config, extraTokenOpts ...services.Option) (*AuthServer, error) {
+	crypto, err := cryptoprov.New(cfg.CryptoMode, cryptoprov.Passwords{
+		Algorithm:  cfg.Passwords.Algorithm,
+		BcryptCost: cfg.Passwords.BcryptCost,
+		Pepper:     []byte(cfg.Passwords.Pepper),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	security := &services.SecurityEvents{Audit: repo.NewAuditRepo(ctx, pool), DB: pool}
+	if cfg.SecurityAlertWebhook != "" {
+		security.Alerts = alert.NewWebhook(cfg.SecurityAlertWebhook)
+	}
+	onCanary := func(ctx context.Context, ev services.CanaryEvent) {
+		security.CanaryTriggered(ctx, ev, clientInfo(ctx))
+	}
+
+	tokenOpts := []services.Option{
+		services.WithCryptoProvider(crypto),
+		services.WithCanaryHandler(onCanary),
+		services.WithLeeway(cfg.Tokens.Leeway),
+	}
+	if cfg.ValidationCache.Size > 0 {
+		cache := tokencache.New(cfg.ValidationCache.Size, cfg.ValidationCache.TTL)
+		tokenOpts = append(tokenOpts, services.WithValidationCache(cache))
+	}
+	next, err := nextSigningKey(cfg.Signing, crypto)
+	if err != nil {
+		return nil, err
+	}
+	if next != nil {
+		tokenOpts = append(tokenOpts, services.WithSigningMigration(next, cfg.Signing.MigrationCutoff))
+	}
+	previous, err := previousSigningKeys(cfg.Signing)
+	if err != nil {
+		return nil, err
+	}
+	tokenOpts = append(tokenOpts, services.WithPreviousKeys(previous...))
+	tokenOpts = append(tokenOpts, services.WithPreviousSecrets(cfg.Signing.PreviousSecrets...))
+	if cfg.Tokens.Store == "postgres" {
+		tokenOpts = append(tokenOpts, services.WithRefreshStore(repo.NewRefreshTokenRepo(ctx, pool)))
+	}
+	if cfg.Tokens.Issuer != "" {
+		tokenOpts = append(tokenOpts, services.WithIssuer(cfg.Tokens.Issuer))
+	}
+	if cfg.Tokens.RefreshPepper != "" {
+		tokenOpts = append(tokenOpts, services.WithRefreshPepper([]byte(cfg.Tokens.RefreshPepper)))
+	}
+	if cfg.Tokens.VersionCheck {
+		tokenOpts = append(tokenOpts, services.WithTokenVersions(repo.NewUserRepo(ctx, pool)))
+	}
+	tokenOpts = append(tokenOpts, services.WithPasswordChanges(repo.NewUserRepo(ctx, pool)))
+	tokenOpts = append(tokenOpts, services.WithUserStatuses(repo.NewUserRepo(ctx, pool)))
+	tokenOpts = append(tokenOpts, services.WithRoles(repo.NewRoleRepo(ctx, pool)))
+	tokenOpts = append(tokenOpts, services.WithMetadataClaims(repo.NewUserRepo(ctx, pool), cfg.Tokens.MetadataClaims))
+	if cfg.Tokens.RememberMeTTL > 0 {
+		tokenOpts = append(tokenOpts, services.WithRememberMeTTL(cfg.Tokens.RememberMeTTL))
+	}
+	if cfg.Tokens.RotationGrace > 0 {
+		tokenOpts = append(tokenOpts, services.WithRotationGrace(cfg.Tokens.RotationGrace))
+	}
+	if cfg.Redis.DegradedMode {
+		tokenOpts = append(tokenOpts, services.WithDegradedMode(cfg.Redis.DegradedRetryAfter))
+	}
+	if cfg.Tokens.MaxSessionLifetime > 0 {
+		tokenOpts = append(tokenOpts, services.WithMaxSessionLifetime(cfg.Tokens.MaxSessionLifetime))
+	}
+	if cfg.DeviceBinding != "" {
+		tokenOpts = append(tokenOpts, services.WithDeviceBinding(services.DeviceBinding(cfg.DeviceBinding)))
+	}
+	format, err := tokenFormatOption(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if format != nil {
+		tokenOpts = append(tokenOpts, format)
+	}
+	tokenOpts = append(tokenOpts, extraTokenOpts...)
+
 	tsvc, err := services.NewTokenService(
-		os.Getenv("SECRET_KEY"),
-		time.Minute*5,
-		time.Hour*24*7,
+		rdb,
+		cfg.SecretKey,
+		cfg.Tokens.AccessTTL,
+		cfg.Tokens.RefreshTTL,
+		tokenOpts...,
 	)
 	if err != nil {
 		// return the actual error so callers see the real cause
 		return nil, err
 	}
 
+	hashing := workpool.New(workpool.Config{
+		MaxParallel:  cfg.Hashing.MaxParallel,
+		QueueDepth:   cfg.Hashing.QueueDepth,
+		QueueTimeout: cfg.Hashing.QueueTimeout,
+	})
+	if err := metrics.RegisterWorkPool("hashing", hashing); err != nil {
+		return nil, err
+	}
+
+	var sender mail.Sender = mail.LogSender{Logger: logger.FromContext(ctx)}
+	if cfg.Mail.SMTPAddr != "" {
+		sender = mail.NewSMTPSender(cfg.Mail.SMTPAddr, cfg.Mail.From, cfg.Mail.SMTPUsername, cfg.Mail.SMTPPassword)
+	}
+
+	var smsSender sms.Sender = sms.LogSender{Logger: logger.FromContext(ctx)}
+	switch cfg.SMS.Provider {
+	case "twilio":
+		smsSender = sms.NewTwilio(cfg.SMS.TwilioAccountSID, cfg.SMS.TwilioAuthToken, cfg.SMS.TwilioFrom)
+	case "sns":
+		smsSender = sms.NewSNS(cfg.SMS.SNSRegion, cfg.SMS.AWSAccessKeyID, cfg.SMS.AWSSecretAccessKey)
+	}
+
+	users := services.NewUserService(ctx, pool, hashing, crypto)
+	if users.Policy, err = newPasswordPolicy(cfg.Passwords); err != nil {
+		return nil, err
+	}
+	users.HistorySize = cfg.Passwords.History
+	users.RequireApproval = cfg.Users.RequireApproval
+	users.RequireInvite = cfg.Users.RequireInvite
+	users.UsernameCooldown = cfg.Users.UsernameCooldown
+	users.UsernameGrace = cfg.Users.UsernameGrace
+
+	recovery := services.NewRecoveryService(ctx, pool, sender)
+	roles := services.NewRoleService(ctx, pool)
+	identities := services.NewIdentityService(ctx, pool)
+	mfa := services.NewMFAService(ctx, pool, cfg.MFA.Issuer, smsSender)
+
+	var loginLinks *services.LoginLinkService
+	if cfg.LoginLinks.Enabled {
+		loginLinks = &services.LoginLinkService{
+			Users:  users,
+			Tokens: tsvc,
+			Mail:   sender,
+			URL:    cfg.LoginLinks.URL,
+			TTL:    cfg.LoginLinks.TTL,
+		}
+	}
+
+	providers, err := newFederationProviders(cfg.Federation)
+	if err != nil {
+		return nil, err
+	}
+	samlProviders, err := newSAMLProviders(cfg.Federation, rdb)
+	if err != nil {
+		return nil, err
+	}
+	for name, sp := range samlProviders {
+		providers[name] = sp
+	}
+	directories, err := newLDAPDirectories(cfg.LDAP)
+	if err != nil {
+		return nil, err
+	}
+	federated := services.NewFederationService(ctx, pool, users, providers)
+	federated.NoLinkByEmail = !cfg.Federation.LinkByEmail
+	evaluator, err := newRiskEvaluator(rdb, cfg.Risk)
+	if err != nil {
+		return nil, err
+	}
+
 	return &AuthServer{
-		UserService:  services.NewUserService(ctx, pool),
-		TokenService: tsvc,
+		UserService:     users,
+		TokenService:    tsvc,
+		RecoveryService: recovery,
+		CanaryService:   services.NewCanaryService(tsvc, users, onCanary),
+		ServiceAccounts: services.NewServiceAccountService(ctx, pool, tsvc, cfg.JWTBearerAudience),
+		Clients:         services.NewClientService(ctx, pool, tsvc),
+		APIKeys:         services.NewAPIKeyService(ctx, pool),
+		Roles:           roles,
+		Export: &services.ExportService{
+			Users:      users,
+			Tokens:     tsvc,
+			Roles:      roles,
+			Recovery:   recovery,
+			Identities: identities,
+			MFA:        mfa,
+			Audit:      security.Audit,
+		},
+		Erasure:    services.NewErasureService(ctx, pool),
+		Identities: identities,
+		Logouts:    services.NewLogoutService(ctx, pool, tsvc),
+		MFA:        mfa,
+		LoginLinks: loginLinks,
+		PasswordResets: &services.PasswordResetService{
+			Users:    users,
+			Tokens:   tsvc,
+			Recovery: recovery,
+			Mail:     sender,
+			URL:      cfg.PasswordResets.URL,
+			TTL:      cfg.PasswordResets.TTL,
+		},
+		AccountUnlocks: &services.AccountUnlockService{
+			Users:    users,
+			Tokens:   tsvc,
+			Recovery: recovery,
+			Mail:     sender,
+			URL:      cfg.LoginBackoff.UnlockURL,
+		},
+		Federation: federated,
+		SAML:       samlProviders,
+		Risk:       evaluator,
+		bindCerts:  cfg.TLS.BindRefreshTokens,
+		adminKey:   cfg.AdminAPIKey,
+		scoped:     newScopedPolicy(cfg.ScopedTokens),
+		references: newReferencePolicy(cfg.ReferenceTokens),
+		loginGuard: loginguard.New(rdb, loginguard.Config{
+			Threshold: cfg.LoginBackoff.Threshold,
+			BaseDelay: cfg.LoginBackoff.BaseDelay,
+			MaxDelay:  cfg.LoginBackoff.MaxDelay,
+			Window:    cfg.LoginBackoff.Window,
+
+			LockoutThreshold: cfg.LoginBackoff.LockoutThreshold,
+			LockoutDuration:  cfg.LoginBackoff.LockoutDuration,
+		}),
+		rateLimiter:          ratelimit.New(rdb, "requests", cfg.RateLimit.Requests, cfg.RateLimit.Window),
+		loginLinkLimiter:     ratelimit.New(rdb, "login_link", cfg.LoginLinks.Requests, cfg.LoginLinks.Window),
+		passwordResetLimiter: ratelimit.New(rdb, "password_reset", cfg.PasswordResets.Requests, cfg.PasswordResets.Window),
+		maxSessions:          cfg.RateLimit.MaxSessions,
+		directories:          directories,
 	}, nil
 }
 
+// issueOptions collects per-request token options derived from the connection
+// and request metadata.
+func (as *AuthServer) issueOptions(ctx context.Context) ([]services.IssueOption, error) {
+	opts := []services.IssueOption{services.WithClientInfo(clientInfo(ctx))}
+	if as.references.reference(firstMetadata(ctx, clientIDMetadataKey)) {
+		opts = append(opts, services.AsReferenceToken())
+	}
+	if as.bindCerts {
+		if x5t := peerCertThumbprint(ctx); x5t != "" {
+			opts = append(opts, services.WithCertThumbprint(x5t))
+		}
+	}
+	if proof := dpopProof(ctx); proof != "" {
+		method, _ := grpc.Method(ctx)
+		jkt, err := as.TokenService.VerifyDPoPProof(ctx, proof, method)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, services.WithDPoPKey(jkt))
+	}
+	return opts, nil
+}
+
 func (as *AuthServer) Login(ctx context.Context, req *pb.LoginRequest) (*pb.TokenResponse, error) {
-	user, err := as.UserService.Login(ctx, req.Username, req.Password)
+	if err := as.limitRate(ctx); err != nil {
+		return nil, err
+	}
+	ip := clientInfo(ctx).IP
+	account, userID := as.loginAccount(ctx, req.Username)
+	// backoff is best effort: a Redis outage must not block logins
+	if err := as.loginGuard.Wait(ctx, account, ip); err != nil {
+		if ctx.Err() != nil {
+			return nil, status.FromContextError(ctx.Err()).Err()
+		}
+		logger.FromContext(ctx).Warn("Login backoff unavailable", zap.Error(err))
+	}
+	if err := as.checkLockout(ctx, account); err != nil {
+		as.UserService.RecordFailedLogin(ctx, req.Username, ip, services.LoginFailureReason(err))
+		return nil, err
+	}
+
+	// honeypot accounts go through the usual password check so that timing
+	// does not give them away, but never log in
+	honeypot := as.CanaryService.CheckLogin(ctx, req.Username)
+	user, err := as.passwordLogin(ctx, req.Username, req.Password, req.ClientId)
+	if honeypot {
+		as.UserService.RecordFailedLogin(ctx, req.Username, ip, services.LoginFailureHoneypot)
+		return nil, autherr.ErrLoginUser
+	}
 	if err != nil {
-		logger.Logger().Error("Failed to login", zap.Error(err))
+		logger.FromContext(ctx).Error("Failed to login", zap.Error(err))
+		as.UserService.RecordFailedLogin(ctx, req.Username, ip, services.LoginFailureReason(err))
+		if err == autherr.ErrLoginUser || err == autherr.ErrNotFound {
+			as.failLogin(ctx, account, userID, ip)
+		}
+		return nil, err
+	}
+	if err := as.loginGuard.Succeed(ctx, account); err != nil {
+		logger.FromContext(ctx).Warn("Failed to reset login backoff", zap.Error(err))
+	}
+	return as.completeLogin(ctx, user, req.RememberMe, req.ClientId)
+}
+
+// completeLogin continues a login whose first factor was accepted: it
+// refuses logins the risk evaluation denies, asks for the second factor
+// when the user has one or a role of the user requires one, and issues
+// tokens otherwise.
+func (as *AuthServer) completeLogin(ctx context.Context, user *models.User, rememberMe bool, clientID string) (*pb.TokenResponse, error) {
+	assessment := as.assessLogin(ctx, user.ID)
+	if assessment.Decision == risk.Deny {
+		logger.FromContext(ctx).Warn("Login denied by risk evaluation",
+			zap.String("username", user.Username), zap.Strings("reasons", assessment.Reasons))
+		as.UserService.RecordFailedLogin(ctx, user.Username, clientInfo(ctx).IP, services.LoginFailureRiskDenied)
+		return nil, autherr.ErrForbidden.WithMessage("login denied as too risky")
+	}
+	if as.MFA != nil {
+		enabled, err := as.MFA.Enabled(ctx, user.ID)
+		if err != nil {
+			return nil, err
+		}
+		if enabled {
+			return as.mfaChallenge(ctx, user, rememberMe, clientID, false)
+		}
+		required, err := as.Roles.RequiresMFA(ctx, user.ID)
+		if err != nil {
+			return nil, err
+		}
+		if required {
+			return as.mfaChallenge(ctx, user, rememberMe, clientID, true)
+		}
+	}
+	if assessment.Decision == risk.RequireMFA {
+		// nothing to challenge: the login proceeds, flagged
+		logger.FromContext(ctx).Warn("Risky login without a second factor",
+			zap.String("username", user.Username), zap.Strings("reasons", assessment.Reasons))
+	}
+	logger.FromContext(ctx).Info("User logged in", zap.String("username", user.Username))
+	return as.loginTokens(ctx, user.ID, rememberMe, clientID)
+}
+
+// loginTokens completes a login of userID, with all of its factors accepted,
+// by issuing a token pair.
+func (as *AuthServer) loginTokens(ctx context.Context, userID string, rememberMe bool, clientID string) (*pb.TokenResponse, error) {
+	if err := as.limitSessions(ctx, userID); err != nil {
 		return nil, err
 	}
-	logger.Logger().Info("User logged in", zap.String("username", user.Username))
+	as.UserService.RecordLogin(ctx, userID, clientInfo(ctx).IP)
 
-	accessToken, refreshToken, accessExp, refreshExp, err := as.TokenService.GenerateTokens(ctx, user.ID)
+	opts, err := as.issueOptions(ctx)
 	if err != nil {
-		logger.Logger().Error("Failed to generate tokens", zap.Error(err))
+		return nil, err
+	}
+	opts = append(opts, as.sessionLimit()...)
+	if rememberMe {
+		opts = append(opts, services.WithRememberMe())
+	}
+	if clientID != "" {
+		opt, err := as.Clients.IssueOption(ctx, clientID)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, opt)
+	}
+	accessToken, refreshToken, accessExp, refreshExp, err := as.TokenService.GenerateTokens(ctx, userID, opts...)
+	if err == autherr.ErrTooManySessions {
+		return nil, as.sessionsExceeded(ctx)
+	}
+	if err != nil {
+		logger.FromContext(ctx).Error("Failed to generate tokens", zap.Error(err))
 		return nil, autherr.ErrBadRequest
 	}
+	as.recordLogin(ctx, userID)
 
 	accessTTL := time.Until(accessExp)
 	refreshTTL := time.Until(refreshExp)
@@ -59,21 +440,38 @@ func (as *AuthServer) Login(ctx context.Context, req *pb.LoginRequest) (*pb.Toke
 		RefreshToken:     refreshToken,
 		AccessExpiresIn:  durationpb.New(accessTTL),
 		RefreshExpiresIn: durationpb.New(refreshTTL),
-		UserId:           user.ID,
+		UserId:           userID,
 	}, nil
 }
 
 func (as *AuthServer) Register(ctx context.Context, req *pb.RegisterRequest) (*pb.RegisterResponse, error) {
-	userId, err := as.UserService.Register(ctx, req.Username, req.Password)
+	if err := as.limitRate(ctx); err != nil {
+		return nil, err
+	}
+	userId, err := as.UserService.RegisterWithInvite(ctx, req.Username, req.Email, req.Password, req.InviteCode)
 	if err != nil {
 		return &pb.RegisterResponse{UserId: ""}, err
 	}
 
-	return &pb.RegisterResponse{UserId: userId}, nil
+	return &pb.RegisterResponse{UserId: userId, PendingApproval: as.UserService.RequireApproval}, nil
 }
 
 func (as *AuthServer) Refresh(ctx context.Context, req *pb.RefreshRequest) (resp *pb.TokenResponse, err error) {
-	newAccess, newRefresh, accessExp, refreshExp, err := as.TokenService.RotateRefresh(ctx, req.RefreshToken, req.ExpectedUserId)
+	if err := as.limitRate(ctx); err != nil {
+		return nil, err
+	}
+	opts, err := as.issueOptions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if req.ClientId != "" {
+		opt, err := as.Clients.IssueOption(ctx, req.ClientId)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, opt)
+	}
+	newAccess, newRefresh, accessExp, refreshExp, err := as.TokenService.RotateRefresh(ctx, req.RefreshToken, req.ExpectedUserId, opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -93,5 +491,23 @@ func (as *AuthServer) Revoke(ctx context.Context, req *pb.RevokeRequest) (*pb.Re
 	if err := as.TokenService.RevokeRefreshByRaw(ctx, req.RefreshToken); err != nil {
 		return &pb.RevokeResponse{Error: "failed to revoke token"}, err
 	}
+	if req.AccessToken != "" {
+		if err := as.TokenService.RevokeAccess(ctx, req.AccessToken); err != nil {
+			return &pb.RevokeResponse{Error: "failed to revoke token"}, err
+		}
+	}
 	return &pb.RevokeResponse{Error: "Token revoked"}, nil
 }
+
+func (as *AuthServer) Logout(ctx context.Context, _ *pb.LogoutRequest) (*pb.LogoutResponse, error) {
+	claims, err := as.callerClaims(ctx)
+	if err != nil {
+		return nil, err
+	}
+	_, token, _ := accessToken(ctx)
+	refresh := firstMetadata(ctx, refreshTokenMetadataKey)
+	if err := as.Logouts.Logout(ctx, claims, token, refresh, clientInfo(ctx)); err != nil {
+		return nil, err
+	}
+	return &pb.LogoutResponse{}, nil
+}
@@ -0,0 +1,105 @@
+package rpc
+
+import (
+	"context"
+	"time"
+
+	"github.com/andro-kes/auth_service/internal/autherr"
+	pb "github.com/andro-kes/auth_service/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func (as *AuthServer) ExchangeAssertion(ctx context.Context, req *pb.ExchangeAssertionRequest) (*pb.ExchangeAssertionResponse, error) {
+	if err := as.limitRate(ctx); err != nil {
+		return nil, err
+	}
+	token, exp, err := as.ServiceAccounts.ExchangeAssertion(ctx, req.Assertion, req.Scope)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.ExchangeAssertionResponse{
+		AccessToken: token,
+		ExpiresIn:   durationpb.New(time.Until(exp)),
+	}, nil
+}
+
+// ExchangeOnBehalfOf takes the service token of the calling service as
+// "authorization", with the bearer scheme.
+func (as *AuthServer) ExchangeOnBehalfOf(ctx context.Context, req *pb.ExchangeOnBehalfOfRequest) (*pb.ExchangeOnBehalfOfResponse, error) {
+	if err := as.limitRate(ctx); err != nil {
+		return nil, err
+	}
+	scheme, serviceToken, ok := accessToken(ctx)
+	if !ok || scheme != "bearer" {
+		return nil, autherr.ErrNoToken
+	}
+	token, exp, err := as.ServiceAccounts.OnBehalfOf(ctx, serviceToken, req.SubjectToken, req.Audience, req.Scope)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.ExchangeOnBehalfOfResponse{
+		AccessToken: token,
+		ExpiresIn:   durationpb.New(time.Until(exp)),
+	}, nil
+}
+
+func (as *AuthServer) CreateServiceAccount(ctx context.Context, req *pb.CreateServiceAccountRequest) (*pb.CreateServiceAccountResponse, error) {
+	if err := as.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	id, err := as.ServiceAccounts.CreateAccount(ctx, req.Name, req.AllowedScopes)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.CreateServiceAccountResponse{AccountId: id}, nil
+}
+
+func (as *AuthServer) AddServiceAccountKey(ctx context.Context, req *pb.AddServiceAccountKeyRequest) (*pb.AddServiceAccountKeyResponse, error) {
+	if err := as.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	kid, err := as.ServiceAccounts.AddKey(ctx, req.AccountId, req.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.AddServiceAccountKeyResponse{KeyId: kid}, nil
+}
+
+func (as *AuthServer) RevokeServiceAccountKey(ctx context.Context, req *pb.RevokeServiceAccountKeyRequest) (*pb.RevokeServiceAccountKeyResponse, error) {
+	if err := as.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	if err := as.ServiceAccounts.RevokeKey(ctx, req.AccountId, req.KeyId); err != nil {
+		return nil, err
+	}
+	return &pb.RevokeServiceAccountKeyResponse{}, nil
+}
+
+func (as *AuthServer) MintServiceToken(ctx context.Context, req *pb.MintServiceTokenRequest) (*pb.MintServiceTokenResponse, error) {
+	if err := as.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	var ttl time.Duration
+	if req.Ttl != nil {
+		if err := req.Ttl.CheckValid(); err != nil {
+			return nil, autherr.ErrBadRequest.WithMessage("invalid ttl")
+		}
+		ttl = req.Ttl.AsDuration()
+	}
+	token, id, exp, err := as.ServiceAccounts.MintToken(ctx, req.AccountId, req.Scope, ttl)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.MintServiceTokenResponse{Token: token, TokenId: id, ExpiresAt: timestamppb.New(exp)}, nil
+}
+
+func (as *AuthServer) RevokeServiceToken(ctx context.Context, req *pb.RevokeServiceTokenRequest) (*pb.RevokeServiceTokenResponse, error) {
+	if err := as.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	if err := as.ServiceAccounts.RevokeToken(ctx, req.AccountId, req.TokenId); err != nil {
+		return nil, err
+	}
+	return &pb.RevokeServiceTokenResponse{}, nil
+}
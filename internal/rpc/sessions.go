@@ -0,0 +1,113 @@
+package rpc
+
+import (
+	"context"
+	"time"
+
+	"github.com/andro-kes/auth_service/internal/autherr"
+	"github.com/andro-kes/auth_service/internal/services"
+	pb "github.com/andro-kes/auth_service/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func (as *AuthServer) ListSessions(ctx context.Context, _ *pb.ListSessionsRequest) (*pb.ListSessionsResponse, error) {
+	userID, err := as.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions, err := as.TokenService.ListSessions(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	_, token, _ := accessToken(ctx)
+	current := as.TokenService.SessionOf(ctx, token)
+
+	resp := sessionsToPB(sessions, current)
+	if err := as.addLastLogin(ctx, userID, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// addLastLogin sets the last login of userID in resp. Subjects without a
+// user record, such as service accounts, have none.
+func (as *AuthServer) addLastLogin(ctx context.Context, userID string, resp *pb.ListSessionsResponse) error {
+	user, err := as.UserService.GetUser(ctx, userID)
+	if err == autherr.ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	resp.LastLoginAt = timestampOrNil(user.LastLoginAt)
+	resp.LastLoginIp = user.LastLoginIP
+	return nil
+}
+
+// sessionsToPB converts sessions, marking the one with ID current.
+func sessionsToPB(sessions []services.Session, current string) *pb.ListSessionsResponse {
+	resp := &pb.ListSessionsResponse{Sessions: make([]*pb.Session, 0, len(sessions))}
+	for _, s := range sessions {
+		resp.Sessions = append(resp.Sessions, &pb.Session{
+			Id:         s.ID,
+			DeviceId:   s.Client.DeviceID,
+			UserAgent:  s.Client.UserAgent,
+			Ip:         s.Client.IP,
+			Location:   s.Client.Location,
+			CreatedAt:  timestampOrNil(s.CreatedAt),
+			LastUsedAt: timestampOrNil(s.LastUsedAt),
+			ExpiresAt:  timestampOrNil(s.ExpiresAt),
+			IssuedAt:   timestampOrNil(s.IssuedAt),
+			Current:    current != "" && s.ID == current,
+		})
+	}
+	return resp
+}
+
+func (as *AuthServer) RevokeSession(ctx context.Context, req *pb.RevokeSessionRequest) (*pb.RevokeSessionResponse, error) {
+	userID, err := as.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if req.SessionId == "" {
+		return nil, autherr.ErrBadRequest.WithMessage("session_id is required")
+	}
+
+	if err := as.TokenService.RevokeSession(ctx, userID, req.SessionId); err != nil {
+		return nil, err
+	}
+	return &pb.RevokeSessionResponse{}, nil
+}
+
+func timestampOrNil(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(t)
+}
+
+func (as *AuthServer) RevokeAllSessions(ctx context.Context, req *pb.RevokeAllSessionsRequest) (*pb.RevokeAllSessionsResponse, error) {
+	userID, err := as.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	_, token, _ := accessToken(ctx)
+	var keep string
+	if req.KeepCurrent {
+		keep = as.TokenService.SessionOf(ctx, token)
+	}
+	n, err := as.TokenService.RevokeAllSessions(ctx, userID, keep)
+	if err != nil {
+		return nil, err
+	}
+	// the caller's own access token goes too unless its session is kept
+	if !req.KeepCurrent {
+		if err := as.TokenService.RevokeAccess(ctx, token); err != nil {
+			return nil, err
+		}
+	}
+	return &pb.RevokeAllSessionsResponse{Revoked: int32(n)}, nil
+}
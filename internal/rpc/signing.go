@@ -0,0 +1,103 @@
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/andro-kes/auth_service/internal/config"
+	"github.com/andro-kes/auth_service/internal/cryptoprov"
+	"github.com/andro-kes/auth_service/internal/signing"
+	pb "github.com/andro-kes/auth_service/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// nextSigningKey loads the key configured to replace the service secret, or
+// returns nil when there is none.
+func nextSigningKey(cfg config.Signing, crypto cryptoprov.Provider) (*signing.Key, error) {
+	switch {
+	case cfg.KeyFile != "":
+		k, err := loadSigningKey(cfg.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		if cfg.Algorithm != "" && k.Method.Alg() != cfg.Algorithm {
+			return nil, fmt.Errorf("%s: key signs with %s, SIGNING_ALGORITHM is %s", cfg.KeyFile, k.Method.Alg(), cfg.Algorithm)
+		}
+		return k, nil
+	case cfg.NextSecret != "":
+		return signing.HMAC(crypto.SigningMethod(), []byte(cfg.NextSecret)), nil
+	default:
+		return nil, nil
+	}
+}
+
+// previousSigningKeys loads the keys rotated out earlier.
+func previousSigningKeys(cfg config.Signing) ([]*signing.Key, error) {
+	keys := make([]*signing.Key, 0, len(cfg.PreviousKeyFiles))
+	for _, file := range cfg.PreviousKeyFiles {
+		k, err := loadSigningKey(file)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+
+func loadSigningKey(file string) (*signing.Key, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("read signing key: %w", err)
+	}
+	k, err := signing.ParsePrivateKeyPEM(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	return k, nil
+}
+
+func (as *AuthServer) GetSigningStatus(ctx context.Context, req *pb.GetSigningStatusRequest) (*pb.GetSigningStatusResponse, error) {
+	if err := as.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	st := as.TokenService.SigningStatus()
+	resp := &pb.GetSigningStatusResponse{
+		KeyId:     st.KeyID,
+		Algorithm: st.Algorithm,
+		Migrating: st.Migrating,
+	}
+	for _, k := range st.Keys {
+		ks := &pb.SigningKeyStatus{
+			KeyId:     k.ID,
+			Algorithm: k.Algorithm,
+			Signing:   k.Signing,
+			Verified:  k.Verified,
+		}
+		if !k.RetireAt.IsZero() {
+			ks.RetireAt = timestamppb.New(k.RetireAt)
+		}
+		if !k.LastSeen.IsZero() {
+			ks.LastSeen = timestamppb.New(k.LastSeen)
+		}
+		resp.Keys = append(resp.Keys, ks)
+	}
+	if !st.Migrating {
+		return resp, nil
+	}
+	resp.PreviousAlgorithm = st.PreviousAlgorithm
+	if !st.Cutoff.IsZero() {
+		resp.Cutoff = timestamppb.New(st.Cutoff)
+	}
+	resp.VerifiedCurrent = st.VerifiedCurrent
+	resp.VerifiedPrevious = st.VerifiedPrevious
+	if total := st.VerifiedCurrent + st.VerifiedPrevious; total > 0 {
+		resp.PreviousRatio = float64(st.VerifiedPrevious) / float64(total)
+	}
+	if !st.LastPrevious.IsZero() {
+		resp.LastPreviousSeen = timestamppb.New(st.LastPrevious)
+	}
+	resp.SafeToRetire = st.SafeToRetire
+	return resp, nil
+}
@@ -0,0 +1,56 @@
+package rpc
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"os"
+
+	"github.com/andro-kes/auth_service/internal/config"
+	"github.com/andro-kes/auth_service/internal/services"
+)
+
+// tokenFormatOption selects the configured access token format, or returns
+// nil for JWTs.
+func tokenFormatOption(cfg *config.Config) (services.Option, error) {
+	switch cfg.TokenFormat {
+	case "opaque":
+		return services.WithOpaqueAccessTokens(), nil
+	case "paseto-v4-local":
+		key, err := hex.DecodeString(cfg.PASETO.LocalKey)
+		if err != nil {
+			return nil, fmt.Errorf("PASETO_LOCAL_KEY: %w", err)
+		}
+		return services.WithPASETOLocal(key), nil
+	case "paseto-v4-public":
+		key, err := loadEd25519Key(cfg.PASETO.SigningKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		return services.WithPASETOPublic(key), nil
+	default:
+		return nil, nil
+	}
+}
+
+func loadEd25519Key(file string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("read PASETO signing key: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM block found", file)
+	}
+	priv, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	key, ok := priv.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: PASETO v4.public requires an Ed25519 key", file)
+	}
+	return key, nil
+}
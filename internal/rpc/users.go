@@ -0,0 +1,272 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/andro-kes/auth_service/internal/autherr"
+	"github.com/andro-kes/auth_service/internal/models"
+	"github.com/andro-kes/auth_service/internal/repo"
+	"github.com/andro-kes/auth_service/internal/services"
+	pb "github.com/andro-kes/auth_service/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func (as *AuthServer) DeleteUser(ctx context.Context, req *pb.DeleteUserRequest) (*pb.DeleteUserResponse, error) {
+	userID, err := as.deletionSubject(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	// tokens go first: a failure then leaves a logged out user rather than
+	// a deleted one with live tokens
+	if _, err := as.TokenService.RevokeAllSessions(ctx, userID, ""); err != nil {
+		return nil, err
+	}
+	if _, err := as.TokenService.ForceExpire(ctx, time.Now(), userID); err != nil {
+		return nil, err
+	}
+	if err := as.UserService.DeleteUser(ctx, userID); err != nil {
+		return nil, err
+	}
+	return &pb.DeleteUserResponse{}, nil
+}
+
+// EraseUser revokes the tokens first, like DeleteUser.
+func (as *AuthServer) EraseUser(ctx context.Context, req *pb.EraseUserRequest) (*pb.EraseUserResponse, error) {
+	if err := as.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	if req.UserId == "" {
+		return nil, autherr.ErrBadRequest.WithMessage("user_id is required")
+	}
+
+	if _, err := as.TokenService.RevokeAllSessions(ctx, req.UserId, ""); err != nil {
+		return nil, err
+	}
+	if _, err := as.TokenService.ForceExpire(ctx, time.Now(), req.UserId); err != nil {
+		return nil, err
+	}
+	if err := as.Erasure.EraseUser(ctx, req.UserId, clientInfo(ctx)); err != nil {
+		return nil, err
+	}
+	return &pb.EraseUserResponse{}, nil
+}
+
+// deletionSubject returns the user to delete: user_id for admins, the caller
+// after checking their password otherwise.
+func (as *AuthServer) deletionSubject(ctx context.Context, req *pb.DeleteUserRequest) (string, error) {
+	userID, admin, err := as.accountSubject(ctx, req.UserId)
+	if err != nil || admin {
+		return userID, err
+	}
+	if err := as.UserService.VerifyPassword(ctx, userID, req.Password); err != nil {
+		return "", err
+	}
+	return userID, nil
+}
+
+// accountSubject returns the user an account operation applies to: userID
+// for admins, which it reports, and the authenticated caller otherwise.
+func (as *AuthServer) accountSubject(ctx context.Context, userID string) (string, bool, error) {
+	if firstMetadata(ctx, adminKeyMetadataKey) != "" {
+		if err := as.requireAdmin(ctx); err != nil {
+			return "", false, err
+		}
+		if userID == "" {
+			return "", false, autherr.ErrBadRequest.WithMessage("user_id is required")
+		}
+		return userID, true, nil
+	}
+
+	if err := as.limitRate(ctx); err != nil {
+		return "", false, err
+	}
+	caller, err := as.authenticate(ctx)
+	if err != nil {
+		return "", false, err
+	}
+	return caller, false, nil
+}
+
+func (as *AuthServer) ExportUserData(ctx context.Context, req *pb.ExportUserDataRequest) (*pb.ExportUserDataResponse, error) {
+	userID, _, err := as.accountSubject(ctx, req.UserId)
+	if err != nil {
+		return nil, err
+	}
+	export, err := as.Export.ExportUserData(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	b, err := json.Marshal(export)
+	if err != nil {
+		return nil, autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	data := &structpb.Struct{}
+	if err := data.UnmarshalJSON(b); err != nil {
+		return nil, autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	return &pb.ExportUserDataResponse{Data: data}, nil
+}
+
+// SetUserStatus stores the status first so that a failure afterwards leaves
+// an account that cannot log in while its tokens last, rather than one that
+// can.
+func (as *AuthServer) SetUserStatus(ctx context.Context, req *pb.SetUserStatusRequest) (*pb.SetUserStatusResponse, error) {
+	if err := as.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	status, ok := statusFromPB[req.Status]
+	if !ok {
+		return nil, autherr.ErrBadRequest.WithMessage("unknown account status")
+	}
+	if err := as.UserService.SetStatus(ctx, req.UserId, status); err != nil {
+		return nil, err
+	}
+	if err := as.TokenService.SetUserStatus(ctx, req.UserId, status); err != nil {
+		return nil, err
+	}
+	return &pb.SetUserStatusResponse{}, nil
+}
+
+func (as *AuthServer) ListUsers(ctx context.Context, req *pb.ListUsersRequest) (*pb.ListUsersResponse, error) {
+	if err := as.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	params := services.ListUsersParams{
+		PageSize:       int(req.PageSize),
+		PageToken:      req.PageToken,
+		UsernamePrefix: req.UsernamePrefix,
+		Descending:     req.Descending,
+	}
+	if req.Status != pb.UserStatus_USER_STATUS_UNSPECIFIED {
+		status, ok := statusFromPB[req.Status]
+		if !ok {
+			return nil, autherr.ErrBadRequest.WithMessage("unknown account status")
+		}
+		params.Status = status
+	}
+	if req.CreatedAfter != nil {
+		params.CreatedAfter = req.CreatedAfter.AsTime()
+	}
+	switch req.OrderBy {
+	case pb.UserOrder_USER_ORDER_UNSPECIFIED, pb.UserOrder_USER_ORDER_CREATED_AT:
+		params.OrderBy = repo.UserOrderCreatedAt
+	case pb.UserOrder_USER_ORDER_USERNAME:
+		params.OrderBy = repo.UserOrderUsername
+	default:
+		return nil, autherr.ErrBadRequest.WithMessage("unknown order")
+	}
+
+	return as.listUsers(ctx, params)
+}
+
+func (as *AuthServer) ListPendingUsers(ctx context.Context, req *pb.ListPendingUsersRequest) (*pb.ListUsersResponse, error) {
+	if err := as.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	return as.listUsers(ctx, services.ListUsersParams{
+		PageSize:  int(req.PageSize),
+		PageToken: req.PageToken,
+		Status:    models.UserStatusPending,
+		OrderBy:   repo.UserOrderCreatedAt,
+	})
+}
+
+func (as *AuthServer) listUsers(ctx context.Context, params services.ListUsersParams) (*pb.ListUsersResponse, error) {
+	users, next, err := as.UserService.ListUsers(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	resp := &pb.ListUsersResponse{NextPageToken: next}
+	for _, user := range users {
+		u, err := userToPB(user)
+		if err != nil {
+			return nil, err
+		}
+		resp.Users = append(resp.Users, u)
+	}
+	return resp, nil
+}
+
+func (as *AuthServer) CreateInvite(ctx context.Context, req *pb.CreateInviteRequest) (*pb.CreateInviteResponse, error) {
+	if err := as.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	var ttl time.Duration
+	if req.Ttl != nil {
+		if err := req.Ttl.CheckValid(); err != nil {
+			return nil, autherr.ErrBadRequest.WithMessage("invalid ttl")
+		}
+		ttl = req.Ttl.AsDuration()
+	}
+	code, invite, err := as.UserService.CreateInvite(ctx, int(req.MaxUses), ttl)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.CreateInviteResponse{Code: code, InviteId: invite.ID, ExpiresAt: timestamppb.New(invite.ExpiresAt)}, nil
+}
+
+// ApproveUser also clears the pending status from token validation; pending
+// users cannot have tokens unless an admin set the status by hand.
+func (as *AuthServer) ApproveUser(ctx context.Context, req *pb.ApproveUserRequest) (*pb.ApproveUserResponse, error) {
+	if err := as.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	if err := as.UserService.ApproveUser(ctx, req.UserId); err != nil {
+		return nil, err
+	}
+	if err := as.TokenService.SetUserStatus(ctx, req.UserId, models.UserStatusActive); err != nil {
+		return nil, err
+	}
+	return &pb.ApproveUserResponse{}, nil
+}
+
+// SearchUsers is authorized like GetUser.
+func (as *AuthServer) SearchUsers(ctx context.Context, req *pb.SearchUsersRequest) (*pb.SearchUsersResponse, error) {
+	if err := as.requireAdmin(ctx); err != nil {
+		if err := as.authorizeIntrospection(ctx); err != nil {
+			return nil, err
+		}
+	}
+	var fuzzy bool
+	switch req.Mode {
+	case pb.UserSearchMode_USER_SEARCH_MODE_UNSPECIFIED, pb.UserSearchMode_USER_SEARCH_MODE_PREFIX:
+	case pb.UserSearchMode_USER_SEARCH_MODE_FUZZY:
+		fuzzy = true
+	default:
+		return nil, autherr.ErrBadRequest.WithMessage("unknown search mode")
+	}
+
+	users, err := as.UserService.SearchUsers(ctx, req.Query, fuzzy, int(req.Limit))
+	if err != nil {
+		return nil, err
+	}
+	resp := &pb.SearchUsersResponse{}
+	for _, user := range users {
+		u, err := userToPB(user)
+		if err != nil {
+			return nil, err
+		}
+		resp.Users = append(resp.Users, u)
+	}
+	return resp, nil
+}
+
+var statusFromPB = map[pb.UserStatus]string{
+	pb.UserStatus_USER_STATUS_ACTIVE:   models.UserStatusActive,
+	pb.UserStatus_USER_STATUS_DISABLED: models.UserStatusDisabled,
+	pb.UserStatus_USER_STATUS_BANNED:   models.UserStatusBanned,
+	pb.UserStatus_USER_STATUS_PENDING:  models.UserStatusPending,
+}
+
+func statusToPB(status string) pb.UserStatus {
+	for s, name := range statusFromPB {
+		if name == status {
+			return s
+		}
+	}
+	return pb.UserStatus_USER_STATUS_UNSPECIFIED
+}
@@ -0,0 +1,180 @@
+package saml
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+const (
+	nsDSig   = "http://www.w3.org/2000/09/xmldsig#"
+	nsExcC14 = "http://www.w3.org/2001/10/xml-exc-c14n#"
+
+	algEnveloped = "http://www.w3.org/2000/09/xmldsig#enveloped-signature"
+)
+
+// signatureAlgs are the accepted SignatureMethods; SHA-1 is not.
+var signatureAlgs = map[string]struct {
+	hash  crypto.Hash
+	ecdsa bool
+}{
+	"http://www.w3.org/2001/04/xmldsig-more#rsa-sha256":   {crypto.SHA256, false},
+	"http://www.w3.org/2001/04/xmldsig-more#rsa-sha512":   {crypto.SHA512, false},
+	"http://www.w3.org/2001/04/xmldsig-more#ecdsa-sha256": {crypto.SHA256, true},
+	"http://www.w3.org/2001/04/xmldsig-more#ecdsa-sha512": {crypto.SHA512, true},
+}
+
+// digestAlgs are the accepted DigestMethods.
+var digestAlgs = map[string]crypto.Hash{
+	"http://www.w3.org/2001/04/xmlenc#sha256": crypto.SHA256,
+	"http://www.w3.org/2001/04/xmlenc#sha512": crypto.SHA512,
+}
+
+// errUnsigned is returned by verifySignature for elements without a
+// signature.
+var errUnsigned = errors.New("saml: element is not signed")
+
+// verifySignature checks the enveloped signature of e, which must sign e as
+// a whole by its ID with one of certs. Only Exclusive Canonicalization is
+// supported, which SAML requires of signers.
+func verifySignature(e *element, certs []*x509.Certificate) error {
+	sigs := e.childrenNamed(nsDSig, "Signature")
+	switch len(sigs) {
+	case 0:
+		return errUnsigned
+	case 1:
+	default:
+		return errors.New("saml: more than one signature")
+	}
+	sig := sigs[0]
+	signedInfo := sig.child(nsDSig, "SignedInfo")
+	sigValue := sig.child(nsDSig, "SignatureValue")
+	if signedInfo == nil || sigValue == nil {
+		return errors.New("saml: malformed signature")
+	}
+
+	c14n := signedInfo.child(nsDSig, "CanonicalizationMethod")
+	if c14n == nil || c14n.attr("Algorithm") != nsExcC14 {
+		return errors.New("saml: unsupported canonicalization; exclusive c14n is required")
+	}
+	method := signedInfo.child(nsDSig, "SignatureMethod")
+	if method == nil {
+		return errors.New("saml: malformed signature")
+	}
+	alg, ok := signatureAlgs[method.attr("Algorithm")]
+	if !ok {
+		return fmt.Errorf("saml: unsupported signature method %q", method.attr("Algorithm"))
+	}
+
+	refs := signedInfo.childrenNamed(nsDSig, "Reference")
+	if len(refs) != 1 {
+		return errors.New("saml: exactly one signature reference is required")
+	}
+	ref := refs[0]
+	id := e.attr("ID")
+	if id == "" || ref.attr("URI") != "#"+id {
+		return errors.New("saml: signature does not reference the signed element")
+	}
+	inclusive, err := referenceTransforms(ref)
+	if err != nil {
+		return err
+	}
+	digestMethod := ref.child(nsDSig, "DigestMethod")
+	digestValue := ref.child(nsDSig, "DigestValue")
+	if digestMethod == nil || digestValue == nil {
+		return errors.New("saml: malformed signature reference")
+	}
+	digestAlg, ok := digestAlgs[digestMethod.attr("Algorithm")]
+	if !ok {
+		return fmt.Errorf("saml: unsupported digest method %q", digestMethod.attr("Algorithm"))
+	}
+	want, err := decodeBase64(digestValue.text())
+	if err != nil {
+		return errors.New("saml: malformed digest value")
+	}
+	h := digestAlg.New()
+	h.Write(canonicalize(e, inclusive, sig))
+	if subtle.ConstantTimeCompare(h.Sum(nil), want) != 1 {
+		return errors.New("saml: digest mismatch")
+	}
+
+	signature, err := decodeBase64(sigValue.text())
+	if err != nil {
+		return errors.New("saml: malformed signature value")
+	}
+	h = alg.hash.New()
+	h.Write(canonicalize(signedInfo, inclusivePrefixes(c14n), nil))
+	sum := h.Sum(nil)
+	for _, cert := range certs {
+		if verifyWith(cert.PublicKey, alg.hash, alg.ecdsa, sum, signature) {
+			return nil
+		}
+	}
+	return errors.New("saml: signature verification failed")
+}
+
+// referenceTransforms checks the transforms of ref, which must be the
+// enveloped signature and Exclusive Canonicalization, and returns the
+// latter's inclusive prefixes.
+func referenceTransforms(ref *element) ([]string, error) {
+	transforms := ref.child(nsDSig, "Transforms")
+	if transforms == nil {
+		return nil, errors.New("saml: signature reference has no transforms")
+	}
+	var (
+		inclusive []string
+		c14n      bool
+	)
+	for _, t := range transforms.childrenNamed(nsDSig, "Transform") {
+		switch t.attr("Algorithm") {
+		case algEnveloped:
+		case nsExcC14:
+			c14n = true
+			inclusive = inclusivePrefixes(t)
+		default:
+			return nil, fmt.Errorf("saml: unsupported transform %q", t.attr("Algorithm"))
+		}
+	}
+	if !c14n {
+		return nil, errors.New("saml: signature reference is not canonicalized")
+	}
+	return inclusive, nil
+}
+
+// inclusivePrefixes returns the PrefixList of the InclusiveNamespaces of a
+// canonicalization method or transform.
+func inclusivePrefixes(method *element) []string {
+	if in := method.child(nsExcC14, "InclusiveNamespaces"); in != nil {
+		return strings.Fields(in.attr("PrefixList"))
+	}
+	return nil
+}
+
+func verifyWith(pub any, hash crypto.Hash, isECDSA bool, sum, signature []byte) bool {
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		return !isECDSA && rsa.VerifyPKCS1v15(k, hash, sum, signature) == nil
+	case *ecdsa.PublicKey:
+		// XML signatures carry r and s concatenated
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if !isECDSA || len(signature) != 2*size {
+			return false
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		return ecdsa.Verify(k, sum, r, s)
+	}
+	return false
+}
+
+// decodeBase64 decodes base64 that may be wrapped over several lines.
+func decodeBase64(s string) ([]byte, error) {
+	return base64.StdEncoding.DecodeString(strings.Join(strings.Fields(s), ""))
+}
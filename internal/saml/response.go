@@ -0,0 +1,266 @@
+package saml
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/andro-kes/auth_service/internal/federation"
+)
+
+const (
+	statusSuccess      = "urn:oasis:names:tc:SAML:2.0:status:Success"
+	confirmationBearer = "urn:oasis:names:tc:SAML:2.0:cm:bearer"
+)
+
+// maxResponseSize bounds accepted SAML responses, before base64 decoding.
+const maxResponseSize = 256 << 10
+
+// invalid wraps the reason a response is refused.
+func invalid(format string, args ...any) error {
+	return fmt.Errorf("%w: %s", federation.ErrInvalidCredential, fmt.Sprintf(format, args...))
+}
+
+// consume checks a base64 SAMLResponse and returns the identity it asserts.
+// The assertion is read from the very element whose signature was checked,
+// never looked up again, so that unsigned content wrapped around it is
+// ignored.
+func (sp *ServiceProvider) consume(ctx context.Context, samlResponse string) (*federation.Identity, error) {
+	if samlResponse == "" || len(samlResponse) > maxResponseSize {
+		return nil, invalid("missing or oversized SAMLResponse")
+	}
+	data, err := decodeBase64(samlResponse)
+	if err != nil {
+		return nil, invalid("SAMLResponse is not base64")
+	}
+	resp, err := parseXML(data)
+	if err != nil {
+		return nil, invalid("%v", err)
+	}
+	if !resp.is(nsProtocol, "Response") {
+		return nil, invalid("not a SAML response")
+	}
+	if err := uniqueIDs(resp); err != nil {
+		return nil, err
+	}
+	if dest := resp.attr("Destination"); dest != "" && dest != sp.cfg.ACSURL {
+		return nil, invalid("response is for %q", dest)
+	}
+	if issuer := resp.child(nsAssertion, "Issuer"); issuer != nil && issuer.text() != sp.cfg.IdP.EntityID {
+		return nil, invalid("response issued by %q", issuer.text())
+	}
+	status := resp.child(nsProtocol, "Status")
+	if status == nil {
+		return nil, invalid("response has no status")
+	}
+	if code := status.child(nsProtocol, "StatusCode"); code == nil || code.attr("Value") != statusSuccess {
+		return nil, invalid("login failed at the IdP")
+	}
+	if len(resp.childrenNamed(nsAssertion, "EncryptedAssertion")) > 0 {
+		return nil, invalid("encrypted assertions are not supported")
+	}
+	// a second assertion, signed or not, could be the one a consumer
+	// further down trusts
+	assertions := resp.childrenNamed(nsAssertion, "Assertion")
+	if len(assertions) != 1 {
+		return nil, invalid("exactly one assertion is required, got %d", len(assertions))
+	}
+	assertion := assertions[0]
+
+	signed := false
+	for _, e := range []*element{resp, assertion} {
+		switch err := verifySignature(e, sp.cfg.IdP.Certificates); {
+		case err == nil:
+			signed = true
+		case !errors.Is(err, errUnsigned):
+			return nil, invalid("%v", err)
+		}
+	}
+	if !signed {
+		return nil, invalid("assertion is not signed")
+	}
+
+	now := sp.now()
+	identity, notOnOrAfter, inResponseTo, err := sp.checkAssertion(assertion, now)
+	if err != nil {
+		return nil, err
+	}
+	if r := resp.attr("InResponseTo"); r != "" && inResponseTo != "" && r != inResponseTo {
+		return nil, invalid("response and assertion answer different requests")
+	} else if inResponseTo == "" {
+		inResponseTo = r
+	}
+	if err := sp.checkRequest(ctx, inResponseTo); err != nil {
+		return nil, err
+	}
+	// an assertion is accepted once; it cannot be replayed past its expiry
+	ttl := notOnOrAfter.Sub(now) + clockSkew
+	ok, err := sp.rdb.SetNX(ctx, sp.assertionKey(assertion.attr("ID")), 1, ttl).Result()
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, invalid("assertion already used")
+	}
+	return identity, nil
+}
+
+// checkAssertion checks the issuer, subject confirmation and conditions of
+// assertion and returns the identity, when it expires and the request it
+// answers.
+func (sp *ServiceProvider) checkAssertion(assertion *element, now time.Time) (*federation.Identity, time.Time, string, error) {
+	var zero time.Time
+	if assertion.attr("ID") == "" {
+		return nil, zero, "", invalid("assertion has no ID")
+	}
+	if issuer := assertion.child(nsAssertion, "Issuer"); issuer == nil || issuer.text() != sp.cfg.IdP.EntityID {
+		return nil, zero, "", invalid("assertion is not issued by the IdP")
+	}
+	subject := assertion.child(nsAssertion, "Subject")
+	if subject == nil {
+		return nil, zero, "", invalid("assertion has no subject")
+	}
+	nameID := subject.child(nsAssertion, "NameID")
+	if nameID == nil || nameID.text() == "" {
+		return nil, zero, "", invalid("assertion has no NameID")
+	}
+
+	// a bearer confirmation must be addressed to us and still be valid
+	var (
+		confirmed    bool
+		notOnOrAfter time.Time
+		inResponseTo string
+	)
+	for _, sc := range subject.childrenNamed(nsAssertion, "SubjectConfirmation") {
+		data := sc.child(nsAssertion, "SubjectConfirmationData")
+		if sc.attr("Method") != confirmationBearer || data == nil || data.attr("Recipient") != sp.cfg.ACSURL {
+			continue
+		}
+		until, err := parseTime(data.attr("NotOnOrAfter"))
+		if err != nil || !now.Before(until.Add(clockSkew)) {
+			continue
+		}
+		if nb := data.attr("NotBefore"); nb != "" {
+			if from, err := parseTime(nb); err != nil || now.Add(clockSkew).Before(from) {
+				continue
+			}
+		}
+		confirmed, notOnOrAfter, inResponseTo = true, until, data.attr("InResponseTo")
+		break
+	}
+	if !confirmed {
+		return nil, zero, "", invalid("assertion has no valid bearer confirmation for %s", sp.cfg.ACSURL)
+	}
+
+	conditions := assertion.child(nsAssertion, "Conditions")
+	if conditions == nil {
+		return nil, zero, "", invalid("assertion has no conditions")
+	}
+	if nb := conditions.attr("NotBefore"); nb != "" {
+		if from, err := parseTime(nb); err != nil || now.Add(clockSkew).Before(from) {
+			return nil, zero, "", invalid("assertion is not yet valid")
+		}
+	}
+	if na := conditions.attr("NotOnOrAfter"); na != "" {
+		until, err := parseTime(na)
+		if err != nil || !now.Before(until.Add(clockSkew)) {
+			return nil, zero, "", invalid("assertion expired")
+		}
+		if until.Before(notOnOrAfter) {
+			notOnOrAfter = until
+		}
+	}
+	restrictions := conditions.childrenNamed(nsAssertion, "AudienceRestriction")
+	if len(restrictions) == 0 {
+		return nil, zero, "", invalid("assertion has no audience restriction")
+	}
+	for _, r := range restrictions {
+		var audiences []string
+		for _, a := range r.childrenNamed(nsAssertion, "Audience") {
+			audiences = append(audiences, a.text())
+		}
+		if !slices.Contains(audiences, sp.cfg.EntityID) {
+			return nil, zero, "", invalid("assertion is for another audience")
+		}
+	}
+
+	attrs := map[string]string{}
+	for _, st := range assertion.childrenNamed(nsAssertion, "AttributeStatement") {
+		for _, a := range st.childrenNamed(nsAssertion, "Attribute") {
+			values := a.childrenNamed(nsAssertion, "AttributeValue")
+			if len(values) == 0 {
+				continue
+			}
+			if _, ok := attrs[a.attr("Name")]; !ok {
+				attrs[a.attr("Name")] = values[0].text()
+			}
+		}
+	}
+	first := func(names []string) string {
+		for _, n := range names {
+			if v := attrs[n]; v != "" {
+				return v
+			}
+		}
+		return ""
+	}
+	identity := &federation.Identity{
+		Subject:     nameID.text(),
+		Username:    first(sp.attrs.Username),
+		Email:       first(sp.attrs.Email),
+		FirstName:   first(sp.attrs.FirstName),
+		LastName:    first(sp.attrs.LastName),
+		DisplayName: first(sp.attrs.DisplayName),
+	}
+	identity.EmailVerified = identity.Email != "" && sp.cfg.TrustEmail
+	return identity, notOnOrAfter, inResponseTo, nil
+}
+
+// checkRequest accepts a response to an AuthnRequest of ours, once, or an
+// IdP-initiated one when those are allowed.
+func (sp *ServiceProvider) checkRequest(ctx context.Context, inResponseTo string) error {
+	if inResponseTo == "" {
+		if !sp.cfg.AllowIdPInitiated {
+			return invalid("IdP-initiated logins are not allowed")
+		}
+		return nil
+	}
+	n, err := sp.rdb.Del(ctx, sp.requestKey(inResponseTo)).Result()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return invalid("response to an unknown or answered request")
+	}
+	return nil
+}
+
+// uniqueIDs refuses documents with an ID twice, which a signature could
+// be made to reference ambiguously.
+func uniqueIDs(root *element) error {
+	seen := map[string]bool{}
+	var walk func(e *element) error
+	walk = func(e *element) error {
+		if id := e.attr("ID"); id != "" {
+			if seen[id] {
+				return invalid("duplicate ID %q", id)
+			}
+			seen[id] = true
+		}
+		for _, c := range e.children {
+			if c, ok := c.(*element); ok {
+				if err := walk(c); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	return walk(root)
+}
+
+func parseTime(s string) (time.Time, error) {
+	return time.Parse(time.RFC3339Nano, s)
+}
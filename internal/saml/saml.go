@@ -0,0 +1,377 @@
+// Package saml is a SAML 2.0 service provider for enterprise identity
+// providers that support nothing else. Logins are SP-initiated with the
+// HTTP-Redirect binding (or, if allowed, started at the IdP) and answered to
+// the assertion consumer service with the HTTP-POST binding. The ACS checks
+// the response and hands the application a one-time code, which it
+// redeems like an OAuth code with a federated login; a ServiceProvider is
+// the federation.Provider for those codes.
+//
+// Assertions must be signed, by themselves or by the response, with
+// Exclusive Canonicalization and SHA-256 or SHA-512. Encrypted assertions
+// and signed AuthnRequests are not supported.
+package saml
+
+import (
+	"bytes"
+	"compress/flate"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/andro-kes/auth_service/internal/federation"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	nsProtocol  = "urn:oasis:names:tc:SAML:2.0:protocol"
+	nsAssertion = "urn:oasis:names:tc:SAML:2.0:assertion"
+
+	bindingRedirect = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-Redirect"
+	bindingPOST     = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST"
+
+	nameIDPersistent = "urn:oasis:names:tc:SAML:2.0:nameid-format:persistent"
+)
+
+const (
+	// requestTTL bounds how long the IdP may take to answer an
+	// AuthnRequest.
+	requestTTL = 10 * time.Minute
+	// codeTTL bounds how long the application may take to redeem the code
+	// of a login.
+	codeTTL = 2 * time.Minute
+	// clockSkew is tolerated in the validity periods of assertions.
+	clockSkew = 2 * time.Minute
+)
+
+// IdP is the identity provider side of a SAML connection.
+type IdP struct {
+	EntityID string
+	// SSOURL is the HTTP-Redirect single sign-on endpoint.
+	SSOURL string
+	// Certificates verify the signatures of the IdP.
+	Certificates []*x509.Certificate
+}
+
+// ParseIdPMetadata reads the entity ID, HTTP-Redirect SSO endpoint and
+// signing certificates from the metadata of an identity provider, an
+// EntityDescriptor or an EntitiesDescriptor holding one IdP.
+func ParseIdPMetadata(data []byte) (*IdP, error) {
+	type keyDescriptor struct {
+		Use          string   `xml:"use,attr"`
+		Certificates []string `xml:"KeyInfo>X509Data>X509Certificate"`
+	}
+	type endpoint struct {
+		Binding  string `xml:"Binding,attr"`
+		Location string `xml:"Location,attr"`
+	}
+	type entity struct {
+		EntityID string `xml:"entityID,attr"`
+		IDP      *struct {
+			Keys []keyDescriptor `xml:"KeyDescriptor"`
+			SSO  []endpoint      `xml:"SingleSignOnService"`
+		} `xml:"IDPSSODescriptor"`
+	}
+	var doc struct {
+		XMLName xml.Name
+		entity
+		Entities []entity `xml:"EntityDescriptor"`
+	}
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("saml: IdP metadata: %w", err)
+	}
+	var found *entity
+	for _, e := range append([]entity{doc.entity}, doc.Entities...) {
+		if e.IDP == nil {
+			continue
+		}
+		if found != nil {
+			return nil, errors.New("saml: IdP metadata describes more than one IdP")
+		}
+		found = &e
+	}
+	if found == nil || found.EntityID == "" {
+		return nil, errors.New("saml: IdP metadata has no IDPSSODescriptor")
+	}
+
+	idp := &IdP{EntityID: found.EntityID}
+	for _, sso := range found.IDP.SSO {
+		if sso.Binding == bindingRedirect {
+			idp.SSOURL = sso.Location
+			break
+		}
+	}
+	for _, k := range found.IDP.Keys {
+		if k.Use != "" && k.Use != "signing" {
+			continue
+		}
+		for _, c := range k.Certificates {
+			der, err := decodeBase64(c)
+			if err != nil {
+				return nil, fmt.Errorf("saml: IdP metadata: certificate: %w", err)
+			}
+			cert, err := x509.ParseCertificate(der)
+			if err != nil {
+				return nil, fmt.Errorf("saml: IdP metadata: certificate: %w", err)
+			}
+			idp.Certificates = append(idp.Certificates, cert)
+		}
+	}
+	if len(idp.Certificates) == 0 {
+		return nil, errors.New("saml: IdP metadata has no signing certificate")
+	}
+	return idp, nil
+}
+
+// AttributeMapping names the attributes local user fields are read from.
+// Each field takes the first of its attributes the assertion has.
+type AttributeMapping struct {
+	Username    []string
+	Email       []string
+	FirstName   []string
+	LastName    []string
+	DisplayName []string
+}
+
+// DefaultAttributes are the attribute names common IdPs send, in their
+// friendly, OID and WS-Federation claim forms.
+var DefaultAttributes = AttributeMapping{
+	Username: []string{"uid", "username", "urn:oid:0.9.2342.19200300.100.1.1"},
+	Email: []string{"email", "mail", "urn:oid:0.9.2342.19200300.100.1.3",
+		"http://schemas.xmlsoap.org/ws/2005/05/identity/claims/emailaddress"},
+	FirstName: []string{"firstName", "givenName", "urn:oid:2.5.4.42",
+		"http://schemas.xmlsoap.org/ws/2005/05/identity/claims/givenname"},
+	LastName: []string{"lastName", "sn", "urn:oid:2.5.4.4",
+		"http://schemas.xmlsoap.org/ws/2005/05/identity/claims/surname"},
+	DisplayName: []string{"displayName", "urn:oid:2.16.840.1.113730.3.1.241",
+		"http://schemas.xmlsoap.org/ws/2005/05/identity/claims/name"},
+}
+
+// Config configures a ServiceProvider.
+type Config struct {
+	// Name identifies the connection in Redis keys and logs.
+	Name string
+	// EntityID and ACSURL are the service provider's.
+	EntityID string
+	ACSURL   string
+	IdP      *IdP
+	// ReturnURL is where the ACS sends the browser with the one-time
+	// "code", or an "error", and the RelayState as "state".
+	ReturnURL string
+	// Attributes override the attributes of DefaultAttributes that are set.
+	Attributes AttributeMapping
+	// TrustEmail treats the IdP's emails as verified; SAML has no way to
+	// say so.
+	TrustEmail bool
+	// AllowIdPInitiated accepts responses to no AuthnRequest, as sent by
+	// IdP dashboards. They are easier to inject into a victim's browser.
+	AllowIdPInitiated bool
+}
+
+// ServiceProvider is one SAML connection.
+type ServiceProvider struct {
+	cfg   Config
+	attrs AttributeMapping
+	rdb   redis.UniversalClient
+	now   func() time.Time
+}
+
+// New returns the service provider of cfg, keeping its state in rdb.
+func New(cfg Config, rdb redis.UniversalClient) (*ServiceProvider, error) {
+	if cfg.IdP == nil || cfg.EntityID == "" || cfg.ACSURL == "" || cfg.ReturnURL == "" {
+		return nil, errors.New("saml: IdP, entity ID, ACS URL and return URL are required")
+	}
+	attrs := DefaultAttributes
+	for _, a := range []struct{ dst, src *[]string }{
+		{&attrs.Username, &cfg.Attributes.Username},
+		{&attrs.Email, &cfg.Attributes.Email},
+		{&attrs.FirstName, &cfg.Attributes.FirstName},
+		{&attrs.LastName, &cfg.Attributes.LastName},
+		{&attrs.DisplayName, &cfg.Attributes.DisplayName},
+	} {
+		if len(*a.src) > 0 {
+			*a.dst = *a.src
+		}
+	}
+	return &ServiceProvider{cfg: cfg, attrs: attrs, rdb: rdb, now: time.Now}, nil
+}
+
+// Metadata returns the service provider's metadata for the IdP.
+func (sp *ServiceProvider) Metadata() []byte {
+	type endpoint struct {
+		Binding   string `xml:"Binding,attr"`
+		Location  string `xml:"Location,attr"`
+		Index     int    `xml:"index,attr"`
+		IsDefault bool   `xml:"isDefault,attr"`
+	}
+	doc := struct {
+		XMLName  xml.Name `xml:"urn:oasis:names:tc:SAML:2.0:metadata EntityDescriptor"`
+		EntityID string   `xml:"entityID,attr"`
+		SP       struct {
+			AuthnRequestsSigned  bool     `xml:"AuthnRequestsSigned,attr"`
+			WantAssertionsSigned bool     `xml:"WantAssertionsSigned,attr"`
+			Protocols            string   `xml:"protocolSupportEnumeration,attr"`
+			NameIDFormat         string   `xml:"NameIDFormat"`
+			ACS                  endpoint `xml:"AssertionConsumerService"`
+		} `xml:"SPSSODescriptor"`
+	}{EntityID: sp.cfg.EntityID}
+	doc.SP.WantAssertionsSigned = true
+	doc.SP.Protocols = nsProtocol
+	doc.SP.NameIDFormat = nameIDPersistent
+	doc.SP.ACS = endpoint{Binding: bindingPOST, Location: sp.cfg.ACSURL, IsDefault: true}
+	out, _ := xml.MarshalIndent(doc, "", "  ")
+	return append([]byte(xml.Header), out...)
+}
+
+// LoginURL starts an SP-initiated login: it returns the IdP URL to send
+// the browser to with an AuthnRequest. relayState comes back with the code,
+// for the application to tie the answer to its own request.
+func (sp *ServiceProvider) LoginURL(ctx context.Context, relayState string) (string, error) {
+	if sp.cfg.IdP.SSOURL == "" {
+		return "", errors.New("saml: the IdP has no HTTP-Redirect SSO endpoint")
+	}
+	if len(relayState) > 80 {
+		// the binding limits RelayState to 80 bytes
+		return "", fmt.Errorf("%w: relay state too long", federation.ErrInvalidCredential)
+	}
+	id, err := randomID()
+	if err != nil {
+		return "", err
+	}
+	var req bytes.Buffer
+	fmt.Fprintf(&req, `<samlp:AuthnRequest xmlns:samlp="%s" xmlns:saml="%s" ID="%s" Version="2.0" IssueInstant="%s"`,
+		nsProtocol, nsAssertion, id, sp.now().UTC().Format(time.RFC3339))
+	req.WriteString(` Destination="`)
+	_ = xml.EscapeText(&req, []byte(sp.cfg.IdP.SSOURL))
+	req.WriteString(`" AssertionConsumerServiceURL="`)
+	_ = xml.EscapeText(&req, []byte(sp.cfg.ACSURL))
+	fmt.Fprintf(&req, `" ProtocolBinding="%s"><saml:Issuer>`, bindingPOST)
+	_ = xml.EscapeText(&req, []byte(sp.cfg.EntityID))
+	fmt.Fprintf(&req, `</saml:Issuer><samlp:NameIDPolicy Format="%s" AllowCreate="true"/></samlp:AuthnRequest>`, nameIDPersistent)
+
+	var deflated bytes.Buffer
+	w, _ := flate.NewWriter(&deflated, flate.BestCompression)
+	_, _ = w.Write(req.Bytes())
+	_ = w.Close()
+
+	if err := sp.rdb.Set(ctx, sp.requestKey(id), 1, requestTTL).Err(); err != nil {
+		return "", err
+	}
+	u, err := url.Parse(sp.cfg.IdP.SSOURL)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	q.Set("SAMLRequest", base64.StdEncoding.EncodeToString(deflated.Bytes()))
+	if relayState != "" {
+		q.Set("RelayState", relayState)
+	}
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// HandleACS consumes the base64 SAMLResponse posted to the ACS and returns
+// where to send the browser: the return URL with a one-time code or, if the
+// response was not accepted, an error. The reason a response was refused
+// is returned as well, for logging.
+func (sp *ServiceProvider) HandleACS(ctx context.Context, samlResponse, relayState string) (string, error) {
+	identity, err := sp.consume(ctx, samlResponse)
+	q := url.Values{}
+	if relayState != "" {
+		q.Set("state", relayState)
+	}
+	if err == nil {
+		var code string
+		if code, err = sp.issueCode(ctx, identity); err == nil {
+			q.Set("code", code)
+		} else {
+			q.Set("error", "server_error")
+		}
+	} else if errors.Is(err, federation.ErrInvalidCredential) {
+		q.Set("error", "access_denied")
+	} else {
+		q.Set("error", "server_error")
+	}
+	return appendQuery(sp.cfg.ReturnURL, q), err
+}
+
+// Authenticate redeems a code of HandleACS for the identity it was issued
+// for.
+func (sp *ServiceProvider) Authenticate(ctx context.Context, cred federation.Credential) (*federation.Identity, error) {
+	if cred.Code == "" {
+		return nil, fmt.Errorf("%w: SAML logins are completed with the code of the ACS", federation.ErrUnsupported)
+	}
+	data, err := sp.rdb.GetDel(ctx, sp.codeKey(cred.Code)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, fmt.Errorf("%w: unknown or used code", federation.ErrInvalidCredential)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var identity federation.Identity
+	if err := json.Unmarshal(data, &identity); err != nil {
+		return nil, err
+	}
+	return &identity, nil
+}
+
+func (sp *ServiceProvider) issueCode(ctx context.Context, identity *federation.Identity) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	code := base64.RawURLEncoding.EncodeToString(b)
+	data, err := json.Marshal(identity)
+	if err != nil {
+		return "", err
+	}
+	if err := sp.rdb.Set(ctx, sp.codeKey(code), data, codeTTL).Err(); err != nil {
+		return "", err
+	}
+	return code, nil
+}
+
+func (sp *ServiceProvider) requestKey(id string) string {
+	return "saml:request:" + sp.cfg.Name + ":" + id
+}
+
+func (sp *ServiceProvider) assertionKey(id string) string {
+	return "saml:assertion:" + sp.cfg.Name + ":" + hashHex(id)
+}
+
+func (sp *ServiceProvider) codeKey(code string) string {
+	return "saml:code:" + sp.cfg.Name + ":" + hashHex(code)
+}
+
+func hashHex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// randomID returns an ID for an AuthnRequest; XML IDs must not start with
+// a digit.
+func randomID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "_" + hex.EncodeToString(b), nil
+}
+
+// appendQuery adds q to the query of rawURL.
+func appendQuery(rawURL string, q url.Values) string {
+	sep := "?"
+	if strings.Contains(rawURL, "?") {
+		sep = "&"
+	}
+	return rawURL + sep + q.Encode()
+}
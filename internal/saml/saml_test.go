@@ -0,0 +1,416 @@
+package saml
+
+import (
+	"bytes"
+	"compress/flate"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/andro-kes/auth_service/internal/federation"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestCanonicalize(t *testing.T) {
+	cases := []struct {
+		name, in, want string
+		inclusive      []string
+	}{
+		{
+			name: "attributes and namespaces",
+			in:   `<a:root xmlns:a="urn:a" xmlns:b="urn:b" xmlns:unused="urn:u" z="1" a:y="2"><b:c/><d xmlns="urn:d">t &amp; &lt; &gt; "q"</d></a:root>`,
+			want: `<a:root xmlns:a="urn:a" z="1" a:y="2"><b:c xmlns:b="urn:b"></b:c><d xmlns="urn:d">t &amp; &lt; &gt; "q"</d></a:root>`,
+		},
+		{
+			name: "comments and redundant declarations",
+			in:   "<r xmlns=\"urn:r\" v=\"a&#9;&quot;b\"><!-- c --><s xmlns=\"urn:r\">x</s></r>",
+			want: "<r xmlns=\"urn:r\" v=\"a&#x9;&quot;b\"><s>x</s></r>",
+		},
+		{
+			name:      "inclusive prefixes",
+			in:        `<p:r xmlns:p="urn:p" xmlns:xs="urn:xs"><p:s/></p:r>`,
+			want:      `<p:r xmlns:p="urn:p" xmlns:xs="urn:xs"><p:s></p:s></p:r>`,
+			inclusive: []string{"xs"},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			e, err := parseXML([]byte(c.in))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := string(canonicalize(e, c.inclusive, nil)); got != c.want {
+				t.Errorf("got  %s\nwant %s", got, c.want)
+			}
+		})
+	}
+
+	if _, err := parseXML([]byte(`<!DOCTYPE r [<!ENTITY x "y">]><r>&x;</r>`)); err == nil {
+		t.Error("parsed a document with a DTD")
+	}
+}
+
+const (
+	idpEntityID = "https://idp.example.com/metadata"
+	spEntityID  = "https://auth.example.com/v1/saml/corp/metadata"
+	acsURL      = "https://auth.example.com/v1/saml/corp/acs"
+)
+
+type signer struct {
+	key  *rsa.PrivateKey
+	cert *x509.Certificate
+}
+
+func newSigner(t *testing.T) *signer {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "idp"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &signer{key: key, cert: cert}
+}
+
+// sign replaces the comment <!--sig:id--> of doc with an enveloped
+// signature of the element id.
+func (s *signer) sign(t *testing.T, doc, id string) string {
+	t.Helper()
+	root, err := parseXML([]byte(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var find func(e *element) *element
+	find = func(e *element) *element {
+		if e.attr("ID") == id {
+			return e
+		}
+		for _, c := range e.children {
+			if c, ok := c.(*element); ok {
+				if f := find(c); f != nil {
+					return f
+				}
+			}
+		}
+		return nil
+	}
+	e := find(root)
+	if e == nil {
+		t.Fatalf("no element %s", id)
+	}
+	digest := sha256.Sum256(canonicalize(e, nil, nil))
+	signedInfo := fmt.Sprintf(`<ds:SignedInfo xmlns:ds="%s">`+
+		`<ds:CanonicalizationMethod Algorithm="%s"/>`+
+		`<ds:SignatureMethod Algorithm="http://www.w3.org/2001/04/xmldsig-more#rsa-sha256"/>`+
+		`<ds:Reference URI="#%s"><ds:Transforms>`+
+		`<ds:Transform Algorithm="%s"/><ds:Transform Algorithm="%s"/>`+
+		`</ds:Transforms><ds:DigestMethod Algorithm="http://www.w3.org/2001/04/xmlenc#sha256"/>`+
+		`<ds:DigestValue>%s</ds:DigestValue></ds:Reference></ds:SignedInfo>`,
+		nsDSig, nsExcC14, id, algEnveloped, nsExcC14, base64.StdEncoding.EncodeToString(digest[:]))
+	si, err := parseXML([]byte(signedInfo))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(canonicalize(si, nil, nil))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature := fmt.Sprintf(`<ds:Signature xmlns:ds="%s">%s<ds:SignatureValue>%s</ds:SignatureValue></ds:Signature>`,
+		nsDSig, strings.Replace(signedInfo, ` xmlns:ds="`+nsDSig+`"`, "", 1), base64.StdEncoding.EncodeToString(sig))
+	placeholder := "<!--sig:" + id + "-->"
+	if !strings.Contains(doc, placeholder) {
+		t.Fatalf("no placeholder for %s", id)
+	}
+	return strings.Replace(doc, placeholder, signature, 1)
+}
+
+func (s *signer) metadata() string {
+	return fmt.Sprintf(`<?xml version="1.0"?>
+<md:EntityDescriptor xmlns:md="urn:oasis:names:tc:SAML:2.0:metadata" entityID="%s">
+  <md:IDPSSODescriptor protocolSupportEnumeration="%s">
+    <md:KeyDescriptor use="signing">
+      <ds:KeyInfo xmlns:ds="%s"><ds:X509Data><ds:X509Certificate>%s</ds:X509Certificate></ds:X509Data></ds:KeyInfo>
+    </md:KeyDescriptor>
+    <md:SingleSignOnService Binding="%s" Location="https://idp.example.com/sso"/>
+    <md:SingleSignOnService Binding="%s" Location="https://idp.example.com/sso-post"/>
+  </md:IDPSSODescriptor>
+</md:EntityDescriptor>`, idpEntityID, nsProtocol, nsDSig, base64.StdEncoding.EncodeToString(s.cert.Raw), bindingRedirect, bindingPOST)
+}
+
+// response describes a SAML response; its zero fields take valid values.
+type response struct {
+	assertionID  string
+	inResponseTo string
+	recipient    string
+	audience     string
+	issuer       string
+	notOnOrAfter time.Time
+}
+
+func (r response) xml() string {
+	if r.assertionID == "" {
+		r.assertionID = "_assertion"
+	}
+	if r.recipient == "" {
+		r.recipient = acsURL
+	}
+	if r.audience == "" {
+		r.audience = spEntityID
+	}
+	if r.issuer == "" {
+		r.issuer = idpEntityID
+	}
+	if r.notOnOrAfter.IsZero() {
+		r.notOnOrAfter = time.Now().Add(5 * time.Minute)
+	}
+	inResponseTo := ""
+	if r.inResponseTo != "" {
+		inResponseTo = ` InResponseTo="` + r.inResponseTo + `"`
+	}
+	until := r.notOnOrAfter.UTC().Format(time.RFC3339)
+	return fmt.Sprintf(`<samlp:Response xmlns:samlp="%[1]s" xmlns:saml="%[2]s" ID="_resp" Version="2.0" Destination="%[3]s"%[4]s>`+
+		`<saml:Issuer>%[5]s</saml:Issuer><!--sig:_resp-->`+
+		`<samlp:Status><samlp:StatusCode Value="urn:oasis:names:tc:SAML:2.0:status:Success"/></samlp:Status>`+
+		`<saml:Assertion ID="%[9]s" Version="2.0"><saml:Issuer>%[5]s</saml:Issuer><!--sig:%[9]s-->`+
+		`<saml:Subject><saml:NameID>u-123</saml:NameID>`+
+		`<saml:SubjectConfirmation Method="urn:oasis:names:tc:SAML:2.0:cm:bearer">`+
+		`<saml:SubjectConfirmationData Recipient="%[6]s" NotOnOrAfter="%[7]s"%[4]s/></saml:SubjectConfirmation></saml:Subject>`+
+		`<saml:Conditions NotOnOrAfter="%[7]s"><saml:AudienceRestriction><saml:Audience>%[8]s</saml:Audience></saml:AudienceRestriction></saml:Conditions>`+
+		`<saml:AttributeStatement>`+
+		`<saml:Attribute Name="mail"><saml:AttributeValue>jane@example.com</saml:AttributeValue></saml:Attribute>`+
+		`<saml:Attribute Name="uid"><saml:AttributeValue>jane</saml:AttributeValue></saml:Attribute>`+
+		`<saml:Attribute Name="givenName"><saml:AttributeValue>Jane</saml:AttributeValue></saml:Attribute>`+
+		`</saml:AttributeStatement></saml:Assertion></samlp:Response>`,
+		nsProtocol, nsAssertion, acsURL, inResponseTo, r.issuer, r.recipient, until, r.audience, r.assertionID)
+}
+
+func newTestSP(t *testing.T, s *signer, allowIdPInitiated bool) *ServiceProvider {
+	t.Helper()
+	srv, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("failed to start miniredis: %v", err)
+	}
+	t.Cleanup(srv.Close)
+	idp, err := ParseIdPMetadata([]byte(s.metadata()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sp, err := New(Config{
+		Name:              "corp",
+		EntityID:          spEntityID,
+		ACSURL:            acsURL,
+		IdP:               idp,
+		ReturnURL:         "https://app.example.com/saml/callback",
+		TrustEmail:        true,
+		AllowIdPInitiated: allowIdPInitiated,
+	}, redis.NewClient(&redis.Options{Addr: srv.Addr()}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sp
+}
+
+// login starts a login and returns the ID of its AuthnRequest.
+func login(t *testing.T, sp *ServiceProvider) string {
+	t.Helper()
+	loginURL, err := sp.LoginURL(context.Background(), "xyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(loginURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Host != "idp.example.com" || u.Path != "/sso" || u.Query().Get("RelayState") != "xyz" {
+		t.Fatalf("login URL %s", loginURL)
+	}
+	deflated, err := base64.StdEncoding.DecodeString(u.Query().Get("SAMLRequest"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(flate.NewReader(bytes.NewReader(deflated)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := parseXML(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !req.is(nsProtocol, "AuthnRequest") || req.attr("AssertionConsumerServiceURL") != acsURL {
+		t.Fatalf("AuthnRequest %s", data)
+	}
+	if issuer := req.child(nsAssertion, "Issuer"); issuer == nil || issuer.text() != spEntityID {
+		t.Fatalf("AuthnRequest issuer %s", data)
+	}
+	return req.attr("ID")
+}
+
+func encode(doc string) string {
+	return base64.StdEncoding.EncodeToString([]byte(doc))
+}
+
+func TestServiceProvider(t *testing.T) {
+	ctx := context.Background()
+	s := newSigner(t)
+	sp := newTestSP(t, s, false)
+
+	if md := string(sp.Metadata()); !strings.Contains(md, `entityID="`+spEntityID+`"`) || !strings.Contains(md, `Location="`+acsURL+`"`) {
+		t.Fatalf("metadata %s", md)
+	}
+
+	id := login(t, sp)
+	doc := s.sign(t, response{inResponseTo: id}.xml(), "_assertion")
+	redirect, err := sp.HandleACS(ctx, encode(doc), "xyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(redirect)
+	if u.Host != "app.example.com" || u.Query().Get("state") != "xyz" || u.Query().Get("code") == "" {
+		t.Fatalf("redirect %s", redirect)
+	}
+	identity, err := sp.Authenticate(ctx, federation.Credential{Code: u.Query().Get("code")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := federation.Identity{Subject: "u-123", Username: "jane", Email: "jane@example.com", EmailVerified: true, FirstName: "Jane"}
+	if *identity != want {
+		t.Errorf("identity %+v, want %+v", *identity, want)
+	}
+	if _, err := sp.Authenticate(ctx, federation.Credential{Code: u.Query().Get("code")}); !errors.Is(err, federation.ErrInvalidCredential) {
+		t.Errorf("code redeemed twice: %v", err)
+	}
+	// the request is answered
+	redirect, err = sp.HandleACS(ctx, encode(doc), "")
+	if !errors.Is(err, federation.ErrInvalidCredential) || !strings.Contains(redirect, "error=access_denied") {
+		t.Errorf("replayed response: %s, %v", redirect, err)
+	}
+
+	// a signed response vouches for its assertion
+	id = login(t, sp)
+	if _, err := sp.consume(ctx, encode(s.sign(t, response{assertionID: "_a2", inResponseTo: id}.xml(), "_resp"))); err != nil {
+		t.Errorf("signed response: %v", err)
+	}
+	// and both may be signed
+	id = login(t, sp)
+	signedAssertion := s.sign(t, response{assertionID: "_a3", inResponseTo: id}.xml(), "_a3")
+	if _, err := sp.consume(ctx, encode(s.sign(t, signedAssertion, "_resp"))); err != nil {
+		t.Errorf("signed response and assertion: %v", err)
+	}
+}
+
+func TestServiceProviderRejects(t *testing.T) {
+	ctx := context.Background()
+	s := newSigner(t)
+	other := newSigner(t)
+	sp := newTestSP(t, s, false)
+
+	cases := []struct {
+		name string
+		doc  func(id string) string
+	}{
+		{"unsigned", func(id string) string {
+			return response{inResponseTo: id}.xml()
+		}},
+		{"other key", func(id string) string {
+			return other.sign(t, response{inResponseTo: id}.xml(), "_assertion")
+		}},
+		{"tampered", func(id string) string {
+			return strings.Replace(s.sign(t, response{inResponseTo: id}.xml(), "_assertion"), "u-123", "admin", 1)
+		}},
+		{"wrapped", func(id string) string {
+			// the signed assertion is hidden in an extension and a forged
+			// one takes its place
+			signed := s.sign(t, response{inResponseTo: id}.xml(), "_assertion")
+			start := strings.Index(signed, "<saml:Assertion")
+			end := strings.Index(signed, "</saml:Assertion>") + len("</saml:Assertion>")
+			original := signed[start:end]
+			forged := strings.Replace(strings.Replace(original, "u-123", "admin", 1), `ID="_assertion"`, `ID="_forged"`, 1)
+			return signed[:start] + `<samlp:Extensions>` + original + `</samlp:Extensions>` + forged + signed[end:]
+		}},
+		{"two assertions", func(id string) string {
+			// both validly signed, under different IDs
+			first := s.sign(t, response{inResponseTo: id}.xml(), "_assertion")
+			second := s.sign(t, response{inResponseTo: id, assertionID: "_second"}.xml(), "_second")
+			start := strings.Index(second, "<saml:Assertion")
+			end := strings.Index(second, "</saml:Assertion>") + len("</saml:Assertion>")
+			return strings.Replace(first, "</samlp:Response>", second[start:end]+"</samlp:Response>", 1)
+		}},
+		{"no assertion", func(id string) string {
+			doc := response{inResponseTo: id}.xml()
+			start := strings.Index(doc, "<saml:Assertion")
+			end := strings.Index(doc, "</saml:Assertion>") + len("</saml:Assertion>")
+			return s.sign(t, doc[:start]+doc[end:], "_resp")
+		}},
+		{"duplicate ID", func(id string) string {
+			doc := s.sign(t, response{inResponseTo: id}.xml(), "_assertion")
+			return strings.Replace(doc, "<samlp:Status>", `<samlp:Extensions><x ID="_assertion"/></samlp:Extensions><samlp:Status>`, 1)
+		}},
+		{"wrong audience", func(id string) string {
+			return s.sign(t, response{inResponseTo: id, audience: "https://other.example.com"}.xml(), "_assertion")
+		}},
+		{"wrong recipient", func(id string) string {
+			return s.sign(t, response{inResponseTo: id, recipient: "https://other.example.com/acs"}.xml(), "_assertion")
+		}},
+		{"wrong issuer", func(id string) string {
+			return s.sign(t, response{inResponseTo: id, issuer: "https://other.example.com"}.xml(), "_assertion")
+		}},
+		{"expired", func(id string) string {
+			return s.sign(t, response{inResponseTo: id, notOnOrAfter: time.Now().Add(-time.Hour)}.xml(), "_assertion")
+		}},
+		{"unknown request", func(string) string {
+			return s.sign(t, response{inResponseTo: "_unknown"}.xml(), "_assertion")
+		}},
+		{"IdP-initiated", func(string) string {
+			return s.sign(t, response{}.xml(), "_assertion")
+		}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			id := login(t, sp)
+			if _, err := sp.consume(ctx, encode(c.doc(id))); !errors.Is(err, federation.ErrInvalidCredential) {
+				t.Errorf("accepted: %v", err)
+			}
+		})
+	}
+}
+
+func TestServiceProviderIdPInitiated(t *testing.T) {
+	ctx := context.Background()
+	s := newSigner(t)
+	sp := newTestSP(t, s, true)
+
+	doc := encode(s.sign(t, response{}.xml(), "_assertion"))
+	if _, err := sp.consume(ctx, doc); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sp.consume(ctx, doc); !errors.Is(err, federation.ErrInvalidCredential) {
+		t.Errorf("replayed assertion: %v", err)
+	}
+}
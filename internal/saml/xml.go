@@ -0,0 +1,336 @@
+package saml
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// element is a node of a parsed document. Prefixes are kept as written so
+// that the document can be canonicalized; encoding/xml would replace them
+// with namespace URIs.
+type element struct {
+	prefix, local string
+	// attrs include namespace declarations, in document order.
+	attrs    []attr
+	children []any // *element, text or procInst
+	parent   *element
+}
+
+type attr struct {
+	prefix, local, value string
+}
+
+type text string
+
+type procInst struct {
+	target string
+	inst   string
+}
+
+// xmlns reports whether a is a namespace declaration and the prefix it
+// declares, "" for the default namespace.
+func (a attr) xmlns() (string, bool) {
+	switch {
+	case a.prefix == "xmlns":
+		return a.local, true
+	case a.prefix == "" && a.local == "xmlns":
+		return "", true
+	}
+	return "", false
+}
+
+// maxDepth bounds the nesting of parsed documents.
+const maxDepth = 64
+
+// parseXML parses a document into a tree. Comments are dropped, as by
+// canonicalization without comments; DTDs are refused.
+func parseXML(data []byte) (*element, error) {
+	d := xml.NewDecoder(bytes.NewReader(data))
+	var root, cur *element
+	depth := 0
+	for {
+		tok, err := d.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if root != nil && cur == nil {
+				return nil, errors.New("xml: more than one root element")
+			}
+			if depth++; depth > maxDepth {
+				return nil, errors.New("xml: document too deep")
+			}
+			e := &element{prefix: t.Name.Space, local: t.Name.Local, parent: cur}
+			for _, a := range t.Attr {
+				e.attrs = append(e.attrs, attr{prefix: a.Name.Space, local: a.Name.Local, value: a.Value})
+			}
+			if cur == nil {
+				root = e
+			} else {
+				cur.children = append(cur.children, e)
+			}
+			cur = e
+		case xml.EndElement:
+			// RawToken does not match end elements to start elements
+			if cur == nil || t.Name.Space != cur.prefix || t.Name.Local != cur.local {
+				return nil, fmt.Errorf("xml: unexpected end element %s", t.Name.Local)
+			}
+			depth--
+			cur = cur.parent
+		case xml.CharData:
+			if cur == nil {
+				if len(bytes.TrimSpace(t)) > 0 {
+					return nil, errors.New("xml: text outside the root element")
+				}
+				continue
+			}
+			cur.children = append(cur.children, text(t))
+		case xml.ProcInst:
+			if cur != nil {
+				cur.children = append(cur.children, procInst{target: t.Target, inst: string(t.Inst)})
+			}
+		case xml.Directive:
+			return nil, errors.New("xml: DTDs are not allowed")
+		}
+	}
+	if root == nil || cur != nil {
+		return nil, errors.New("xml: incomplete document")
+	}
+	return root, nil
+}
+
+// lookupNS returns the namespace URI prefix is bound to at e.
+func (e *element) lookupNS(prefix string) (string, bool) {
+	if prefix == "xml" {
+		return "http://www.w3.org/XML/1998/namespace", true
+	}
+	for n := e; n != nil; n = n.parent {
+		for _, a := range n.attrs {
+			if p, ok := a.xmlns(); ok && p == prefix {
+				return a.value, true
+			}
+		}
+	}
+	return "", prefix == ""
+}
+
+// is reports whether e is the element local of namespace ns.
+func (e *element) is(ns, local string) bool {
+	if e.local != local {
+		return false
+	}
+	uri, _ := e.lookupNS(e.prefix)
+	return uri == ns
+}
+
+// childrenNamed returns the child elements local of namespace ns.
+func (e *element) childrenNamed(ns, local string) []*element {
+	var out []*element
+	for _, c := range e.children {
+		if c, ok := c.(*element); ok && c.is(ns, local) {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// child returns the only child element local of namespace ns, or nil.
+func (e *element) child(ns, local string) *element {
+	if cs := e.childrenNamed(ns, local); len(cs) == 1 {
+		return cs[0]
+	}
+	return nil
+}
+
+// attr returns the unprefixed attribute name.
+func (e *element) attr(name string) string {
+	for _, a := range e.attrs {
+		if a.prefix == "" && a.local == name {
+			return a.value
+		}
+	}
+	return ""
+}
+
+// text returns all the character data directly in e. Character data split
+// by a comment is joined, so that a comment cannot truncate a signed value.
+func (e *element) text() string {
+	var sb strings.Builder
+	for _, c := range e.children {
+		if t, ok := c.(text); ok {
+			sb.WriteString(string(t))
+		}
+	}
+	return strings.TrimSpace(sb.String())
+}
+
+// canonicalize writes e in Exclusive XML Canonicalization without comments
+// (http://www.w3.org/2001/10/xml-exc-c14n#). inclusive are the prefixes of
+// the InclusiveNamespaces PrefixList, "#default" for the default namespace,
+// and exclude is left out, as by the enveloped signature transform.
+func canonicalize(e *element, inclusive []string, exclude *element) []byte {
+	var buf bytes.Buffer
+	c := canonicalizer{buf: &buf, inclusive: inclusive, exclude: exclude}
+	c.element(e, map[string]string{"": ""})
+	return buf.Bytes()
+}
+
+type canonicalizer struct {
+	buf       *bytes.Buffer
+	inclusive []string
+	exclude   *element
+}
+
+// element writes e; rendered are the namespace declarations in effect in
+// the output so far.
+func (c *canonicalizer) element(e *element, rendered map[string]string) {
+	// the namespaces e visibly uses, plus those of the inclusive list that
+	// are in scope
+	used := []string{e.prefix}
+	for _, a := range e.attrs {
+		if _, ok := a.xmlns(); !ok && a.prefix != "" {
+			used = append(used, a.prefix)
+		}
+	}
+	for _, p := range c.inclusive {
+		if p == "#default" {
+			p = ""
+		}
+		if _, ok := e.lookupNS(p); ok {
+			used = append(used, p)
+		}
+	}
+	slices.Sort(used)
+	used = slices.Compact(used)
+
+	var decls []attr
+	scope, copied := rendered, false
+	for _, p := range used {
+		if p == "xml" {
+			continue
+		}
+		uri, _ := e.lookupNS(p)
+		if have, ok := rendered[p]; ok && have == uri {
+			continue
+		}
+		if _, ok := rendered[p]; !ok && p != "" && uri == "" {
+			continue
+		}
+		if !copied {
+			scope, copied = maps.Clone(rendered), true
+		}
+		scope[p] = uri
+		decls = append(decls, attr{prefix: "xmlns", local: p, value: uri})
+	}
+
+	var attrs []attr
+	for _, a := range e.attrs {
+		if _, ok := a.xmlns(); !ok {
+			attrs = append(attrs, a)
+		}
+	}
+	attrNS := func(a attr) string {
+		if a.prefix == "" {
+			return ""
+		}
+		uri, _ := e.lookupNS(a.prefix)
+		return uri
+	}
+	slices.SortStableFunc(attrs, func(a, b attr) int {
+		if n := strings.Compare(attrNS(a), attrNS(b)); n != 0 {
+			return n
+		}
+		return strings.Compare(a.local, b.local)
+	})
+
+	name := qname(e.prefix, e.local)
+	c.buf.WriteByte('<')
+	c.buf.WriteString(name)
+	for _, d := range decls {
+		if d.local == "" {
+			c.buf.WriteString(` xmlns="`)
+		} else {
+			c.buf.WriteString(" xmlns:" + d.local + `="`)
+		}
+		escapeAttr(c.buf, d.value)
+		c.buf.WriteByte('"')
+	}
+	for _, a := range attrs {
+		c.buf.WriteString(" " + qname(a.prefix, a.local) + `="`)
+		escapeAttr(c.buf, a.value)
+		c.buf.WriteByte('"')
+	}
+	c.buf.WriteByte('>')
+	for _, child := range e.children {
+		switch child := child.(type) {
+		case *element:
+			if child != c.exclude {
+				c.element(child, scope)
+			}
+		case text:
+			escapeText(c.buf, string(child))
+		case procInst:
+			c.buf.WriteString("<?" + child.target)
+			if child.inst != "" {
+				c.buf.WriteString(" " + child.inst)
+			}
+			c.buf.WriteString("?>")
+		}
+	}
+	c.buf.WriteString("</" + name + ">")
+}
+
+func qname(prefix, local string) string {
+	if prefix == "" {
+		return local
+	}
+	return prefix + ":" + local
+}
+
+func escapeText(buf *bytes.Buffer, s string) {
+	for _, r := range s {
+		switch r {
+		case '&':
+			buf.WriteString("&amp;")
+		case '<':
+			buf.WriteString("&lt;")
+		case '>':
+			buf.WriteString("&gt;")
+		case '\r':
+			buf.WriteString("&#xD;")
+		default:
+			buf.WriteRune(r)
+		}
+	}
+}
+
+func escapeAttr(buf *bytes.Buffer, s string) {
+	for _, r := range s {
+		switch r {
+		case '&':
+			buf.WriteString("&amp;")
+		case '<':
+			buf.WriteString("&lt;")
+		case '"':
+			buf.WriteString("&quot;")
+		case '\t':
+			buf.WriteString("&#x9;")
+		case '\n':
+			buf.WriteString("&#xA;")
+		case '\r':
+			buf.WriteString("&#xD;")
+		default:
+			buf.WriteRune(r)
+		}
+	}
+}
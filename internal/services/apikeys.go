@@ -0,0 +1,191 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/andro-kes/auth_service/internal/autherr"
+	"github.com/andro-kes/auth_service/internal/logger"
+	"github.com/andro-kes/auth_service/internal/models"
+	"github.com/andro-kes/auth_service/internal/repo"
+	"github.com/andro-kes/auth_service/internal/repo/db"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+)
+
+const (
+	AuditAPIKeyCreated = "api_key.created"
+	AuditAPIKeyRevoked = "api_key.revoked"
+)
+
+// APIKeyPrefix starts every API key, so that leaked keys are easy to spot.
+const APIKeyPrefix = "ak_"
+
+const (
+	// maxAPIKeys bounds the active keys of a user.
+	maxAPIKeys = 50
+	// apiKeyTouchInterval is how stale the last use of a key may get before
+	// a validation records it again.
+	apiKeyTouchInterval = time.Minute
+)
+
+// APIKeyService issues API keys: long-lived secrets users hand to
+// integrations that cannot run OAuth flows. A key is "ak_<id>_<secret>";
+// the ID finds the key and only the SHA-256 of the secret is stored.
+type APIKeyService struct {
+	Repo  repo.APIKeyRepo
+	Users repo.UserRepo
+	Audit repo.AuditRepo
+	Tx    db.Tx
+}
+
+func NewAPIKeyService(ctx context.Context, pool *pgxpool.Pool) *APIKeyService {
+	return &APIKeyService{
+		Repo:  repo.NewAPIKeyRepo(ctx, pool),
+		Users: repo.NewUserRepo(ctx, pool),
+		Audit: repo.NewAuditRepo(ctx, pool),
+		Tx:    db.NewTx(pool),
+	}
+}
+
+// CreateKey issues a key of userID for scopes that expires after ttl, or
+// never for a zero ttl. The key is returned only here.
+func (ks *APIKeyService) CreateKey(ctx context.Context, userID, name string, scopes []string, ttl time.Duration, client ClientInfo) (*models.APIKey, string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, "", autherr.ErrBadRequest.WithMessage("name is required")
+	}
+	if len(scopes) == 0 {
+		return nil, "", autherr.ErrBadRequest.WithMessage("scopes are required")
+	}
+	for _, sc := range scopes {
+		if sc == "" || strings.ContainsAny(sc, " \t\n") {
+			return nil, "", autherr.ErrBadRequest.WithMessage("scopes must be non-empty and contain no spaces")
+		}
+	}
+	if ttl < 0 {
+		return nil, "", autherr.ErrBadRequest.WithMessage("ttl must not be negative")
+	}
+	active, err := ks.Repo.ListByUser(ctx, userID)
+	if err != nil {
+		logger.FromContext(ctx).Error("Failed to list API keys", zap.Error(err))
+		return nil, "", autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	if len(active) >= maxAPIKeys {
+		return nil, "", autherr.ErrForbidden.WithMessage("too many API keys; revoke unused ones")
+	}
+
+	id, err := randomHex(rand.Reader, 8)
+	if err != nil {
+		return nil, "", autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	secret, err := randomBase64(rand.Reader, 32)
+	if err != nil {
+		return nil, "", autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	key := &models.APIKey{
+		ID:         id,
+		UserID:     userID,
+		Name:       name,
+		SecretHash: sha256Hex(secret),
+		Scopes:     slices.Compact(slices.Sorted(slices.Values(scopes))),
+		CreatedAt:  time.Now().UTC(),
+	}
+	if ttl > 0 {
+		expires := key.CreatedAt.Add(ttl)
+		key.ExpiresAt = &expires
+	}
+	err = ks.Tx.RunInTx(ctx, func(ctx context.Context, q db.Querier) error {
+		if err := ks.Repo.Create(ctx, q, key); err != nil {
+			return err
+		}
+		return ks.Audit.Insert(ctx, q, auditEvent(AuditAPIKeyCreated, userID, client, map[string]string{
+			"key_id": key.ID,
+			"scopes": strings.Join(key.Scopes, " "),
+		}))
+	})
+	if err != nil {
+		logger.FromContext(ctx).Error("Failed to create API key", zap.Error(err))
+		return nil, "", autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	return key, APIKeyPrefix + key.ID + "_" + secret, nil
+}
+
+// ListKeys returns the keys of userID that are not revoked, newest first.
+func (ks *APIKeyService) ListKeys(ctx context.Context, userID string) ([]models.APIKey, error) {
+	keys, err := ks.Repo.ListByUser(ctx, userID)
+	if err != nil {
+		logger.FromContext(ctx).Error("Failed to list API keys", zap.Error(err))
+		return nil, autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	return keys, nil
+}
+
+// RevokeKey revokes the key keyID of userID.
+func (ks *APIKeyService) RevokeKey(ctx context.Context, userID, keyID string, client ClientInfo) error {
+	var found bool
+	err := ks.Tx.RunInTx(ctx, func(ctx context.Context, q db.Querier) error {
+		var err error
+		if found, err = ks.Repo.Revoke(ctx, q, userID, keyID); err != nil || !found {
+			return err
+		}
+		return ks.Audit.Insert(ctx, q, auditEvent(AuditAPIKeyRevoked, userID, client, map[string]string{
+			"key_id": keyID,
+		}))
+	})
+	if err != nil {
+		logger.FromContext(ctx).Error("Failed to revoke API key", zap.Error(err))
+		return autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	if !found {
+		return autherr.ErrNotFound
+	}
+	return nil
+}
+
+// ValidateKey returns the key raw if it is active, not expired and its user
+// may log in, and records its use. Unknown, forged and revoked keys get
+// ErrInvalidToken, expired ones ErrTokenExpired.
+func (ks *APIKeyService) ValidateKey(ctx context.Context, raw string) (*models.APIKey, error) {
+	id, secret, ok := strings.Cut(strings.TrimPrefix(raw, APIKeyPrefix), "_")
+	if !ok || !strings.HasPrefix(raw, APIKeyPrefix) || id == "" || secret == "" {
+		return nil, autherr.ErrInvalidToken
+	}
+	key, err := ks.Repo.FindByID(ctx, id)
+	if err == autherr.ErrNotFound {
+		return nil, autherr.ErrInvalidToken
+	}
+	if err != nil {
+		logger.FromContext(ctx).Error("Failed to get API key", zap.Error(err))
+		return nil, autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	if subtle.ConstantTimeCompare([]byte(sha256Hex(secret)), []byte(key.SecretHash)) != 1 || key.RevokedAt != nil {
+		return nil, autherr.ErrInvalidToken
+	}
+	now := time.Now()
+	if key.ExpiresAt != nil && !now.Before(*key.ExpiresAt) {
+		return nil, autherr.ErrTokenExpired
+	}
+
+	user, err := ks.Users.FindByID(ctx, key.UserID)
+	if err == autherr.ErrNotFound {
+		return nil, autherr.ErrInvalidToken
+	}
+	if err != nil {
+		logger.FromContext(ctx).Error("Failed to get API key owner", zap.Error(err))
+		return nil, autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	if err := statusError(user.Status); err != nil {
+		return nil, err
+	}
+
+	// a failure to record the use must not fail the request
+	if err := ks.Repo.Touch(ctx, key.ID, now, now.Add(-apiKeyTouchInterval)); err != nil {
+		logger.FromContext(ctx).Warn("Failed to record API key use", zap.String("key_id", key.ID), zap.Error(err))
+	}
+	return key, nil
+}
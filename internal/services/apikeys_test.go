@@ -0,0 +1,134 @@
+package services
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andro-kes/auth_service/internal/autherr"
+	"github.com/andro-kes/auth_service/internal/models"
+	"github.com/andro-kes/auth_service/internal/repo/db"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type testAPIKeyRepo struct {
+	keys    map[string]*models.APIKey
+	touches int
+}
+
+func (r *testAPIKeyRepo) Create(ctx context.Context, q db.Querier, key *models.APIKey) error {
+	if r.keys == nil {
+		r.keys = map[string]*models.APIKey{}
+	}
+	r.keys[key.ID] = key
+	return nil
+}
+
+func (r *testAPIKeyRepo) FindByID(ctx context.Context, id string) (*models.APIKey, error) {
+	if k, ok := r.keys[id]; ok {
+		return k, nil
+	}
+	return nil, autherr.ErrNotFound
+}
+
+func (r *testAPIKeyRepo) ListByUser(ctx context.Context, userID string) ([]models.APIKey, error) {
+	var out []models.APIKey
+	for _, k := range r.keys {
+		if k.UserID == userID && k.RevokedAt == nil {
+			out = append(out, *k)
+		}
+	}
+	return out, nil
+}
+
+func (r *testAPIKeyRepo) Revoke(ctx context.Context, q db.Querier, userID, id string) (bool, error) {
+	k, ok := r.keys[id]
+	if !ok || k.UserID != userID || k.RevokedAt != nil {
+		return false, nil
+	}
+	now := time.Now()
+	k.RevokedAt = &now
+	return true, nil
+}
+
+func (r *testAPIKeyRepo) Touch(ctx context.Context, id string, at, since time.Time) error {
+	k := r.keys[id]
+	if k.LastUsedAt == nil || k.LastUsedAt.Before(since) {
+		k.LastUsedAt = &at
+		r.touches++
+	}
+	return nil
+}
+
+func TestAPIKeys(t *testing.T) {
+	keys := &testAPIKeyRepo{}
+	users := &testUserRepo{}
+	audit := &testAuditRepo{}
+	ks := &APIKeyService{Repo: keys, Users: users, Audit: audit, Tx: &fakeTx{}}
+	ctx := t.Context()
+
+	if _, _, err := ks.CreateKey(ctx, "1", "ci", nil, 0, ClientInfo{}); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected a key without scopes to be rejected, got %v", err)
+	}
+	key, raw, err := ks.CreateKey(ctx, "1", "ci", []string{"repo:write", "repo:read"}, 0, ClientInfo{})
+	if err != nil {
+		t.Fatalf("CreateKey failed: %v", err)
+	}
+	if !strings.HasPrefix(raw, APIKeyPrefix+key.ID+"_") || strings.Contains(key.SecretHash, raw) {
+		t.Fatalf("unexpected key %q", raw)
+	}
+
+	got, err := ks.ValidateKey(ctx, raw)
+	if err != nil {
+		t.Fatalf("ValidateKey failed: %v", err)
+	}
+	if got.UserID != "1" || !slices.Equal(got.Scopes, []string{"repo:read", "repo:write"}) || got.LastUsedAt == nil {
+		t.Fatalf("unexpected key %+v", got)
+	}
+	// uses within a minute are recorded once
+	if _, err := ks.ValidateKey(ctx, raw); err != nil || keys.touches != 1 {
+		t.Fatalf("expected one recorded use, got %d, %v", keys.touches, err)
+	}
+
+	for _, bad := range []string{"", raw + "x", strings.Replace(raw, key.ID, "0000000000000000", 1), strings.TrimPrefix(raw, APIKeyPrefix)} {
+		if _, err := ks.ValidateKey(ctx, bad); err != autherr.ErrInvalidToken {
+			t.Fatalf("expected %q to be rejected, got %v", bad, err)
+		}
+	}
+
+	users.status = models.UserStatusDisabled
+	if _, err := ks.ValidateKey(ctx, raw); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("expected the key of a disabled user to be rejected, got %v", err)
+	}
+	users.status = ""
+
+	expiring, rawExpiring, err := ks.CreateKey(ctx, "1", "deploy", []string{"deploy"}, time.Hour, ClientInfo{})
+	if err != nil {
+		t.Fatalf("CreateKey failed: %v", err)
+	}
+	past := time.Now().Add(-time.Second)
+	expiring.ExpiresAt = &past
+	if _, err := ks.ValidateKey(ctx, rawExpiring); err != autherr.ErrTokenExpired {
+		t.Fatalf("expected an expired key to be rejected, got %v", err)
+	}
+
+	if err := ks.RevokeKey(ctx, "2", key.ID, ClientInfo{}); err != autherr.ErrNotFound {
+		t.Fatalf("expected another user's key not to be revoked, got %v", err)
+	}
+	if err := ks.RevokeKey(ctx, "1", key.ID, ClientInfo{}); err != nil {
+		t.Fatalf("RevokeKey failed: %v", err)
+	}
+	if _, err := ks.ValidateKey(ctx, raw); err != autherr.ErrInvalidToken {
+		t.Fatalf("expected a revoked key to be rejected, got %v", err)
+	}
+	listed, err := ks.ListKeys(ctx, "1")
+	if err != nil || len(listed) != 1 || listed[0].ID != expiring.ID {
+		t.Fatalf("expected only the unrevoked key to be listed, got %+v, %v", listed, err)
+	}
+	if !slices.Equal(audit.events, []string{AuditAPIKeyCreated, AuditAPIKeyCreated, AuditAPIKeyRevoked}) {
+		t.Fatalf("unexpected audit events %v", audit.events)
+	}
+}
@@ -0,0 +1,73 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/andro-kes/auth_service/internal/autherr"
+)
+
+// MaxValidateBatch is the most tokens ValidateAccessBatch accepts at once.
+const MaxValidateBatch = 100
+
+// batchParallelism bounds the tokens of one batch validated concurrently, so
+// that a single batch cannot monopolize the Redis connection pool.
+const batchParallelism = 8
+
+// BatchResult is the outcome for one token of a batch: its introspection,
+// or ErrInvalidToken, ErrTokenExpired, ErrInvalidDPoPProof or a storage
+// error.
+type BatchResult struct {
+	Introspection
+	Err error
+}
+
+// ValidateAccessBatch validates access tokens like IntrospectForRequest and
+// returns a result per token, in order. proofs[i], if present, is the DPoP
+// request tokens[i] was presented with; DPoP-bound tokens without a valid
+// proof get ErrInvalidDPoPProof. Refresh tokens are rejected.
+func (s *TokenService) ValidateAccessBatch(ctx context.Context, tokens []string, proofs []DPoPRequest) ([]BatchResult, error) {
+	if len(tokens) > MaxValidateBatch {
+		return nil, autherr.ErrBadRequest.WithMessage(fmt.Sprintf("at most %d tokens per batch", MaxValidateBatch))
+	}
+	if len(proofs) > len(tokens) {
+		return nil, autherr.ErrBadRequest.WithMessage("more DPoP proofs than tokens")
+	}
+	results := make([]BatchResult, len(tokens))
+	sem := make(chan struct{}, batchParallelism)
+	var wg sync.WaitGroup
+	for i, token := range tokens {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			var proof DPoPRequest
+			if i < len(proofs) {
+				proof = proofs[i]
+			}
+			results[i] = s.validateBatchToken(ctx, token, proof)
+		}()
+	}
+	wg.Wait()
+	return results, nil
+}
+
+func (s *TokenService) validateBatchToken(ctx context.Context, token string, proof DPoPRequest) BatchResult {
+	if ctx.Err() != nil {
+		return BatchResult{Err: autherr.ErrStorageError.WithMessage(ctx.Err().Error())}
+	}
+	claims, err := s.accessClaims(ctx, token)
+	if err != nil {
+		return BatchResult{Err: err}
+	}
+	in, err := s.activeAccess(ctx, claims)
+	if err == nil {
+		err = s.checkBinding(ctx, claims, token, proof)
+	}
+	if err != nil {
+		return BatchResult{Err: err}
+	}
+	return BatchResult{Introspection: in}
+}
@@ -0,0 +1,87 @@
+package services
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/andro-kes/auth_service/internal/autherr"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestValidateAccessBatch(t *testing.T) {
+	clock := &fixedClock{t: time.Now()}
+	svc, _ := newTestTokenService(t, WithClock(clock))
+	ctx := t.Context()
+
+	expired, _, _, _, err := svc.GenerateTokens(ctx, "old")
+	if err != nil {
+		t.Fatalf("GenerateTokens failed: %v", err)
+	}
+	clock.t = clock.t.Add(time.Hour)
+
+	tokens := []string{expired, "garbage"}
+	for i := range 20 {
+		access, refresh, _, _, err := svc.GenerateTokens(ctx, "user-"+strconv.Itoa(i))
+		if err != nil {
+			t.Fatalf("GenerateTokens failed: %v", err)
+		}
+		tokens = append(tokens, access, refresh)
+	}
+
+	results, err := svc.ValidateAccessBatch(ctx, tokens, nil)
+	if err != nil {
+		t.Fatalf("ValidateAccessBatch failed: %v", err)
+	}
+	if len(results) != len(tokens) {
+		t.Fatalf("got %d results for %d tokens", len(results), len(tokens))
+	}
+	if results[0].Err != autherr.ErrTokenExpired {
+		t.Fatalf("expired token: %v, want ErrTokenExpired", results[0].Err)
+	}
+	if results[1].Err != autherr.ErrInvalidToken {
+		t.Fatalf("garbage token: %v, want ErrInvalidToken", results[1].Err)
+	}
+	for i := range 20 {
+		access, refresh := results[2+2*i], results[3+2*i]
+		if access.Err != nil || !access.Active || access.UserID != "user-"+strconv.Itoa(i) {
+			t.Fatalf("access token %d: %+v", i, access)
+		}
+		if refresh.Err != autherr.ErrInvalidToken {
+			t.Fatalf("refresh token %d: %v, want ErrInvalidToken", i, refresh.Err)
+		}
+	}
+
+	if _, err := svc.ValidateAccessBatch(ctx, make([]string, MaxValidateBatch+1), nil); err == nil {
+		t.Fatal("expected an oversized batch to be rejected")
+	}
+}
+
+func TestValidateAccessBatch_DPoP(t *testing.T) {
+	svc, _ := newTestTokenService(t)
+	ctx := t.Context()
+
+	key, jkt := newTestKey(t)
+	access, _, _, _, err := svc.GenerateTokens(ctx, "alice", WithDPoPKey(jkt))
+	if err != nil {
+		t.Fatalf("GenerateTokens failed: %v", err)
+	}
+	const htu = "https://api.example.com/orders"
+	proofs := []DPoPRequest{{Proof: newTestProof(t, key, "GET", htu, access), Method: "GET", Target: htu}}
+
+	results, err := svc.ValidateAccessBatch(ctx, []string{access, access}, proofs)
+	if err != nil {
+		t.Fatalf("ValidateAccessBatch failed: %v", err)
+	}
+	if results[0].Err != nil || !results[0].Active || results[0].DPoPJKT != jkt {
+		t.Fatalf("token with proof: %+v", results[0])
+	}
+	if status.Code(results[1].Err) != codes.Unauthenticated || results[1].Active {
+		t.Fatalf("token without proof: %+v, want it rejected", results[1])
+	}
+
+	if _, err := svc.ValidateAccessBatch(ctx, []string{access}, append(proofs, DPoPRequest{})); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected more proofs than tokens to be refused, got %v", err)
+	}
+}
@@ -0,0 +1,157 @@
+package services
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/andro-kes/auth_service/internal/autherr"
+	"github.com/andro-kes/auth_service/internal/logger"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// Honeytoken kinds.
+const (
+	CanaryRefreshToken = "refresh_token"
+	CanaryCredentials  = "credentials"
+)
+
+const (
+	// canaryTokensKey maps refresh token hashes of honeytokens to labels.
+	canaryTokensKey = "canary:tokens"
+	// canaryUsersKey maps lower-cased honeypot usernames to labels.
+	canaryUsersKey = "canary:users"
+	// canaryLifetime is the expiry written to the durable store for
+	// honeytokens, which are meant never to expire.
+	canaryLifetime = 100 * 365 * 24 * time.Hour
+	// canaryAlertTimeout bounds the background run of the canary handler.
+	canaryAlertTimeout = 30 * time.Second
+)
+
+// CanaryEvent describes a honeytoken being used.
+type CanaryEvent struct {
+	Kind  string
+	Label string
+	// Subject identifies the honeytoken: the username or a token hash prefix.
+	Subject string
+}
+
+// CanaryHandler is called in the background when a honeytoken is used, so
+// that alerting does not delay the failed request or give the honeytoken
+// away by its latency.
+type CanaryHandler func(ctx context.Context, ev CanaryEvent)
+
+// alertCanary runs h, if any, with ev in the background.
+func alertCanary(ctx context.Context, h CanaryHandler, ev CanaryEvent) {
+	if h == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), canaryAlertTimeout)
+	go func() {
+		defer cancel()
+		h(ctx, ev)
+	}()
+}
+
+// WithCanaryHandler sets the handler invoked when a honeytoken refresh token
+// is presented.
+func WithCanaryHandler(h CanaryHandler) Option {
+	return func(s *TokenService) {
+		s.onCanary = h
+	}
+}
+
+// MintCanaryRefresh stores a refresh token that looks like any other but
+// belongs to no real user and never expires. Presenting it fails like an
+// invalid token and triggers the canary handler. Like real refresh tokens it
+// is written to the durable store, so it survives a Redis flush.
+func (s *TokenService) MintCanaryRefresh(ctx context.Context, label string) (string, error) {
+	raw, err := randomBase64(s.crypto.Rand(), 64)
+	if err != nil {
+		return "", autherr.ErrTokenGeneration.WithMessage(err.Error())
+	}
+	sid, err := randomHex(s.crypto.Rand(), 16)
+	if err != nil {
+		return "", autherr.ErrTokenGeneration.WithMessage(err.Error())
+	}
+	h := s.refreshHash(raw)
+	userID := uuid.New().String()
+	now := s.now()
+	fields := map[string]any{
+		"user_id":    userID,
+		"issued_at":  now.Unix(),
+		"sid":        sid,
+		"created_at": now.Unix(),
+		"last_used":  now.Unix(),
+		"canary":     label,
+	}
+
+	pipe := s.rdb.TxPipeline()
+	pipe.HSet(ctx, redisKey(h), fields)
+	pipe.HSet(ctx, canaryTokensKey, h, label)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return "", autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	// no session is indexed for a honeytoken, so none is stored either
+	if err := s.persistRefresh(ctx, h, userID, "", fields, now.Add(canaryLifetime)); err != nil {
+		_ = s.rdb.Del(ctx, redisKey(h)).Err()
+		_ = s.rdb.HDel(ctx, canaryTokensKey, h).Err()
+		return "", err
+	}
+	return raw, nil
+}
+
+func (s *TokenService) tripCanary(ctx context.Context, label, hash string) {
+	alertCanary(ctx, s.onCanary, CanaryEvent{Kind: CanaryRefreshToken, Label: label, Subject: hash[:12]})
+}
+
+// CanaryService mints honeytokens and checks logins against honeypot
+// credentials.
+type CanaryService struct {
+	Tokens *TokenService
+	Users  *UserService
+	OnTrip CanaryHandler
+}
+
+func NewCanaryService(tokens *TokenService, users *UserService, onTrip CanaryHandler) *CanaryService {
+	return &CanaryService{Tokens: tokens, Users: users, OnTrip: onTrip}
+}
+
+// MintRefreshToken returns a honeytoken refresh token.
+func (cs *CanaryService) MintRefreshToken(ctx context.Context, label string) (string, error) {
+	return cs.Tokens.MintCanaryRefresh(ctx, label)
+}
+
+// MintCredentials creates a honeypot account with a random password meant to
+// be planted where leaked credentials would be found. Any login attempt for
+// the username triggers the handler and fails.
+func (cs *CanaryService) MintCredentials(ctx context.Context, username, label string) (string, error) {
+	password, err := randomBase64(cs.Tokens.crypto.Rand(), 18)
+	if err != nil {
+		return "", autherr.ErrTokenGeneration.WithMessage(err.Error())
+	}
+	// the random password need not satisfy the password policy
+	if _, err := cs.Users.register(ctx, username, "", password, ""); err != nil {
+		return "", err
+	}
+	if err := cs.Tokens.rdb.HSet(ctx, canaryUsersKey, strings.ToLower(username), label).Err(); err != nil {
+		return "", autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	return password, nil
+}
+
+// CheckLogin reports whether username is a honeypot account and triggers the
+// handler if so. Lookup failures are logged and treated as no match.
+func (cs *CanaryService) CheckLogin(ctx context.Context, username string) bool {
+	label, err := cs.Tokens.rdb.HGet(ctx, canaryUsersKey, strings.ToLower(username)).Result()
+	if err != nil {
+		if err != redis.Nil {
+			logger.FromContext(ctx).Warn("Honeypot lookup failed", zap.Error(err))
+		}
+		return false
+	}
+	alertCanary(ctx, cs.OnTrip, CanaryEvent{Kind: CanaryCredentials, Label: label, Subject: username})
+	return true
+}
@@ -0,0 +1,97 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/andro-kes/auth_service/internal/autherr"
+)
+
+func TestCanaryRefreshToken(t *testing.T) {
+	events := make(chan CanaryEvent, 10)
+	store := newTestRefreshTokenRepo()
+	svc, srv := newTestTokenService(t, WithCanaryHandler(func(ctx context.Context, ev CanaryEvent) { events <- ev }), WithRefreshStore(store))
+
+	ctx := t.Context()
+	raw, err := svc.MintCanaryRefresh(ctx, "backup-bucket")
+	if err != nil {
+		t.Fatalf("MintCanaryRefresh failed: %v", err)
+	}
+
+	if _, _, _, _, err := svc.RotateRefresh(ctx, raw, ""); err != autherr.ErrInvalidToken {
+		t.Fatalf("expected honeytoken rotation to fail as invalid, got %v", err)
+	}
+	if err := svc.RevokeRefreshByRaw(ctx, raw); err != nil {
+		t.Fatalf("expected honeytoken revoke to look successful, got %v", err)
+	}
+	if _, err := svc.ValidateRefresh(ctx, raw); err != autherr.ErrInvalidToken {
+		t.Fatalf("expected honeytoken to stay in place and fail again, got %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		ev := waitCanary(t, events)
+		if ev.Kind != CanaryRefreshToken || ev.Label != "backup-bucket" {
+			t.Fatalf("unexpected event %+v", ev)
+		}
+	}
+	if ttl := srv.TTL(redisKey(sha256Hex(raw))); ttl != 0 {
+		t.Fatalf("expected honeytoken not to expire, got TTL %v", ttl)
+	}
+
+	// the honeytoken survives a Redis flush like a real refresh token
+	srv.FlushAll()
+	if _, err := svc.ValidateRefresh(ctx, raw); err != autherr.ErrInvalidToken {
+		t.Fatalf("expected the restored honeytoken to fail as invalid, got %v", err)
+	}
+	if ev := waitCanary(t, events); ev.Label != "backup-bucket" {
+		t.Fatalf("expected the restored honeytoken to trip, got %+v", ev)
+	}
+	if label := srv.HGet(canaryTokensKey, sha256Hex(raw)); label != "backup-bucket" {
+		t.Fatalf("expected the restored honeytoken to be listed, got %q", label)
+	}
+}
+
+// waitCanary returns the next event sent to the canary handler, which runs
+// in the background.
+func waitCanary(t *testing.T, events <-chan CanaryEvent) CanaryEvent {
+	t.Helper()
+	select {
+	case ev := <-events:
+		return ev
+	case <-time.After(time.Second):
+		t.Fatal("expected a canary event")
+		return CanaryEvent{}
+	}
+}
+
+func TestCanaryCredentials(t *testing.T) {
+	tokens, _ := newTestTokenService(t)
+	users := &UserService{Repo: &testUserRepo{}, Tx: &fakeTx{}}
+	events := make(chan CanaryEvent, 10)
+	cs := NewCanaryService(tokens, users, func(ctx context.Context, ev CanaryEvent) { events <- ev })
+
+	ctx := t.Context()
+	password, err := cs.MintCredentials(ctx, "svc-backup", "leaked-db")
+	if err != nil {
+		t.Fatalf("MintCredentials failed: %v", err)
+	}
+	if password == "" {
+		t.Fatal("expected a generated password")
+	}
+
+	if cs.CheckLogin(ctx, "alice") {
+		t.Fatal("expected a regular user not to be a honeypot")
+	}
+	if !cs.CheckLogin(ctx, "SVC-Backup") {
+		t.Fatal("expected the honeypot username to match case-insensitively")
+	}
+	if ev := waitCanary(t, events); ev.Kind != CanaryCredentials || ev.Label != "leaked-db" {
+		t.Fatalf("unexpected event %+v", ev)
+	}
+	select {
+	case ev := <-events:
+		t.Fatalf("expected a single event, got %+v", ev)
+	default:
+	}
+}
@@ -0,0 +1,90 @@
+package services
+
+import (
+	"time"
+
+	"github.com/andro-kes/auth_service/internal/tokencache"
+)
+
+// Claims are the claims of a validated access token.
+type Claims struct {
+	UserID      string
+	JTI         string
+	SessionID   string
+	Scope       string
+	SubjectType string
+	// Audience is the "aud" of tokens issued for a registered client.
+	Audience []string
+	// Roles are the names of the user's roles when the token was issued.
+	Roles []string
+	// Metadata are the configured keys of the user's profile metadata when
+	// the token was issued.
+	Metadata map[string]any
+	// Actors are the services a token issued on behalf of the user was
+	// delegated to, the one it was issued to first.
+	Actors []string
+	// DPoPJKT is the key thumbprint of DPoP-bound tokens.
+	DPoPJKT   string
+	OneTime   bool
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+}
+
+func newClaims(tc *tokenClaims) *Claims {
+	c := &Claims{
+		UserID:      tc.UserID,
+		JTI:         tc.ID,
+		SessionID:   tc.SessionID,
+		Scope:       tc.Scope,
+		SubjectType: tc.SubType,
+		Audience:    tc.Audience,
+		Roles:       tc.Roles,
+		Metadata:    tc.Metadata,
+		Actors:      tc.Act.chain(),
+		OneTime:     tc.OneTime,
+		ExpiresAt:   tc.ExpiresAt.Time,
+	}
+	if tc.IssuedAt != nil {
+		c.IssuedAt = tc.IssuedAt.Time
+	}
+	if tc.Cnf != nil {
+		c.DPoPJKT = tc.Cnf.JKT
+	}
+	return c
+}
+
+// cacheEntry and claimsFromCache carry claims through the validation cache,
+// which only ever holds plain bearer tokens.
+func (c *Claims) cacheEntry() tokencache.Entry {
+	return tokencache.Entry{
+		UserID:      c.UserID,
+		JTI:         c.JTI,
+		SessionID:   c.SessionID,
+		Scope:       c.Scope,
+		SubjectType: c.SubjectType,
+		DPoPJKT:     c.DPoPJKT,
+		Audience:    c.Audience,
+		Roles:       c.Roles,
+		Metadata:    c.Metadata,
+		Actors:      c.Actors,
+		IssuedAt:    c.IssuedAt,
+		ExpiresAt:   c.ExpiresAt,
+	}
+}
+
+func claimsFromCache(e tokencache.Entry) *Claims {
+	return &Claims{
+		UserID:      e.UserID,
+		JTI:         e.JTI,
+		SessionID:   e.SessionID,
+		Scope:       e.Scope,
+		SubjectType: e.SubjectType,
+		DPoPJKT:     e.DPoPJKT,
+		Audience:    e.Audience,
+		Roles:       e.Roles,
+		Metadata:    e.Metadata,
+		Actors:      e.Actors,
+		IssuedAt:    e.IssuedAt,
+		ExpiresAt:   e.ExpiresAt,
+	}
+}
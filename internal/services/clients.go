@@ -0,0 +1,165 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/andro-kes/auth_service/internal/autherr"
+	"github.com/andro-kes/auth_service/internal/logger"
+	"github.com/andro-kes/auth_service/internal/models"
+	"github.com/andro-kes/auth_service/internal/repo"
+	"github.com/andro-kes/auth_service/internal/repo/db"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+)
+
+// ForClient issues tokens for a registered client: the access token carries
+// audience as "aud" and the session remembers the client, so that its
+// refresh token cannot be rotated on behalf of another client.
+func ForClient(clientID, audience string) IssueOption {
+	return func(p *issueParams) {
+		p.clientID = clientID
+		p.audience = audience
+	}
+}
+
+// SubjectClient is the sub_type claim of tokens issued to clients on their
+// own behalf.
+const SubjectClient = "client"
+
+// AsClient marks the issued token's subject as a client.
+func AsClient() IssueOption {
+	return func(p *issueParams) {
+		p.subjectType = SubjectClient
+	}
+}
+
+// ClientService manages the registry of client applications and implements
+// the client credentials grant (RFC 6749 4.4) for confidential clients.
+type ClientService struct {
+	Repo   repo.ClientRepo
+	Tx     db.Tx
+	Tokens *TokenService
+}
+
+func NewClientService(ctx context.Context, pool *pgxpool.Pool, tokens *TokenService) *ClientService {
+	return &ClientService{
+		Repo:   repo.NewClientRepo(ctx, pool),
+		Tx:     db.NewTx(pool),
+		Tokens: tokens,
+	}
+}
+
+// CreateClient registers a client whose access tokens are issued for
+// audience.
+func (cs *ClientService) CreateClient(ctx context.Context, name, audience string) (string, error) {
+	if name == "" {
+		return "", autherr.ErrBadRequest.WithMessage("name is required")
+	}
+	if audience == "" {
+		return "", autherr.ErrBadRequest.WithMessage("audience is required")
+	}
+	client := &models.Client{ID: uuid.New().String(), Name: name, Audience: audience, Scopes: []string{}}
+	if err := cs.create(ctx, client); err != nil {
+		return "", err
+	}
+	return client.ID, nil
+}
+
+// CreateConfidentialClient registers a client that authenticates with a
+// secret and may obtain tokens for scopes. The secret is returned only
+// here; just its hash is stored.
+func (cs *ClientService) CreateConfidentialClient(ctx context.Context, name, audience string, scopes []string) (id, secret string, err error) {
+	if name == "" {
+		return "", "", autherr.ErrBadRequest.WithMessage("name is required")
+	}
+	if audience == "" {
+		return "", "", autherr.ErrBadRequest.WithMessage("audience is required")
+	}
+	if len(scopes) == 0 {
+		return "", "", autherr.ErrBadRequest.WithMessage("scopes are required")
+	}
+	for _, sc := range scopes {
+		if sc == "" || strings.ContainsAny(sc, " \t\n") {
+			return "", "", autherr.ErrBadRequest.WithMessage("scopes must be non-empty and contain no spaces")
+		}
+	}
+	if secret, err = randomBase64(rand.Reader, 32); err != nil {
+		return "", "", autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	client := &models.Client{
+		ID:         uuid.New().String(),
+		Name:       name,
+		Audience:   audience,
+		SecretHash: sha256Hex(secret),
+		Scopes:     slices.Compact(slices.Sorted(slices.Values(scopes))),
+	}
+	if err := cs.create(ctx, client); err != nil {
+		return "", "", err
+	}
+	return client.ID, secret, nil
+}
+
+func (cs *ClientService) create(ctx context.Context, client *models.Client) error {
+	err := cs.Tx.RunInTx(ctx, func(ctx context.Context, q db.Querier) error {
+		return cs.Repo.Create(ctx, q, client)
+	})
+	if err != nil {
+		logger.FromContext(ctx).Error("Failed to create client", zap.Error(err))
+		return autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	return nil
+}
+
+// ClientCredentials authenticates a confidential client by its secret and
+// issues an access token for scope, a space-separated subset of the
+// client's scopes; empty grants all of them. The token's subject is the
+// client and its audience the client's. The granted scope is returned with
+// the token.
+func (cs *ClientService) ClientCredentials(ctx context.Context, clientID, secret, scope string) (token, granted string, expiresAt time.Time, err error) {
+	client, err := cs.Repo.FindByID(ctx, clientID)
+	if err != nil && err != autherr.ErrNotFound {
+		logger.FromContext(ctx).Error("Failed to get client", zap.Error(err))
+		return "", "", time.Time{}, autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	// public clients have no secret and unknown ones fail the same way
+	if client == nil || client.SecretHash == "" ||
+		subtle.ConstantTimeCompare([]byte(sha256Hex(secret)), []byte(client.SecretHash)) != 1 {
+		return "", "", time.Time{}, autherr.ErrLoginUser.WithMessage("invalid client credentials")
+	}
+	scopes := strings.Fields(scope)
+	if len(scopes) == 0 {
+		scopes = client.Scopes
+	}
+	for _, sc := range scopes {
+		if !slices.Contains(client.Scopes, sc) {
+			return "", "", time.Time{}, autherr.ErrForbidden.WithMessage("scope not allowed for client")
+		}
+	}
+	granted = strings.Join(scopes, " ")
+	token, expiresAt, err = cs.Tokens.IssueScopedAccess(ctx, client.ID, granted, 0, false,
+		ForClient(client.ID, client.Audience), AsClient())
+	if err != nil {
+		return "", "", time.Time{}, err
+	}
+	return token, granted, expiresAt, nil
+}
+
+// IssueOption resolves a client ID sent with a login or refresh to the
+// option that issues tokens for it. Unknown clients are rejected.
+func (cs *ClientService) IssueOption(ctx context.Context, clientID string) (IssueOption, error) {
+	client, err := cs.Repo.FindByID(ctx, clientID)
+	if err != nil {
+		if err == autherr.ErrNotFound {
+			return nil, autherr.ErrBadRequest.WithMessage("unknown client")
+		}
+		logger.FromContext(ctx).Error("Failed to get client", zap.Error(err))
+		return nil, autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	return ForClient(client.ID, client.Audience), nil
+}
@@ -0,0 +1,145 @@
+package services
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/andro-kes/auth_service/internal/autherr"
+	"github.com/andro-kes/auth_service/internal/models"
+	"github.com/andro-kes/auth_service/internal/repo/db"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type testClientRepo struct {
+	clients map[string]*models.Client
+}
+
+func (r *testClientRepo) Create(ctx context.Context, q db.Querier, client *models.Client) error {
+	if r.clients == nil {
+		r.clients = map[string]*models.Client{}
+	}
+	r.clients[client.ID] = client
+	return nil
+}
+
+func (r *testClientRepo) FindByID(ctx context.Context, id string) (*models.Client, error) {
+	if c, ok := r.clients[id]; ok {
+		return c, nil
+	}
+	return nil, autherr.ErrNotFound
+}
+
+func TestClientAudience(t *testing.T) {
+	tokens, _ := newTestTokenService(t)
+	cs := &ClientService{Repo: &testClientRepo{}, Tx: &fakeTx{}}
+
+	ctx := t.Context()
+	if _, err := cs.CreateClient(ctx, "web", ""); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected missing audience to be rejected, got %v", err)
+	}
+	web, err := cs.CreateClient(ctx, "web", "https://app.example")
+	if err != nil {
+		t.Fatalf("CreateClient failed: %v", err)
+	}
+	admin, err := cs.CreateClient(ctx, "admin", "https://admin.example")
+	if err != nil {
+		t.Fatalf("CreateClient failed: %v", err)
+	}
+	if _, err := cs.IssueOption(ctx, "unknown"); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected unknown client to be rejected, got %v", err)
+	}
+
+	opt, err := cs.IssueOption(ctx, web)
+	if err != nil {
+		t.Fatalf("IssueOption failed: %v", err)
+	}
+	access, refresh, _, _, err := tokens.GenerateTokens(ctx, "user-1", opt)
+	if err != nil {
+		t.Fatalf("GenerateTokens failed: %v", err)
+	}
+	claims, err := tokens.ValidateAccess(access)
+	if err != nil || !slices.Equal(claims.Audience, []string{"https://app.example"}) {
+		t.Fatalf("expected web audience, got claims=%+v err=%v", claims, err)
+	}
+
+	// a refresh naming no client keeps the session's client
+	access, refresh, _, _, err = tokens.RotateRefresh(ctx, refresh, "user-1")
+	if err != nil {
+		t.Fatalf("RotateRefresh failed: %v", err)
+	}
+	claims, err = tokens.ValidateAccess(access)
+	if err != nil || !slices.Equal(claims.Audience, []string{"https://app.example"}) {
+		t.Fatalf("expected audience to survive rotation, got claims=%+v err=%v", claims, err)
+	}
+
+	other, err := cs.IssueOption(ctx, admin)
+	if err != nil {
+		t.Fatalf("IssueOption failed: %v", err)
+	}
+	if _, _, _, _, err := tokens.RotateRefresh(ctx, refresh, "user-1", other); err != autherr.ErrInvalidToken {
+		t.Fatalf("expected refresh for another client to fail, got %v", err)
+	}
+	if _, _, _, _, err := tokens.RotateRefresh(ctx, refresh, "user-1", opt); err != nil {
+		t.Fatalf("RotateRefresh for the session's client failed: %v", err)
+	}
+
+	access, _, _, _, err = tokens.GenerateTokens(ctx, "user-1")
+	if err != nil {
+		t.Fatalf("GenerateTokens failed: %v", err)
+	}
+	if claims, err := tokens.ValidateAccess(access); err != nil || claims.Audience != nil {
+		t.Fatalf("expected no audience without a client, got claims=%+v err=%v", claims, err)
+	}
+}
+
+func TestClientCredentials(t *testing.T) {
+	tokens, _ := newTestTokenService(t)
+	cs := &ClientService{Repo: &testClientRepo{}, Tx: &fakeTx{}, Tokens: tokens}
+
+	ctx := t.Context()
+	if _, _, err := cs.CreateConfidentialClient(ctx, "billing", "https://api.example", nil); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected a client without scopes to be rejected, got %v", err)
+	}
+	id, secret, err := cs.CreateConfidentialClient(ctx, "billing", "https://api.example", []string{"invoices:write", "invoices:read"})
+	if err != nil {
+		t.Fatalf("CreateConfidentialClient failed: %v", err)
+	}
+	public, err := cs.CreateClient(ctx, "web", "https://app.example")
+	if err != nil {
+		t.Fatalf("CreateClient failed: %v", err)
+	}
+
+	token, scope, _, err := cs.ClientCredentials(ctx, id, secret, "")
+	if err != nil {
+		t.Fatalf("ClientCredentials failed: %v", err)
+	}
+	if scope != "invoices:read invoices:write" {
+		t.Fatalf("expected all scopes to be granted, got %q", scope)
+	}
+	claims, err := tokens.ValidateAccess(token)
+	if err != nil {
+		t.Fatalf("ValidateAccess failed: %v", err)
+	}
+	if claims.UserID != id || claims.SubjectType != SubjectClient || claims.Scope != scope ||
+		!slices.Equal(claims.Audience, []string{"https://api.example"}) {
+		t.Fatalf("unexpected claims %+v", claims)
+	}
+	if _, scope, _, err := cs.ClientCredentials(ctx, id, secret, "invoices:read"); err != nil || scope != "invoices:read" {
+		t.Fatalf("expected a subset of scopes to be granted, got %q, %v", scope, err)
+	}
+	if _, _, _, err := cs.ClientCredentials(ctx, id, secret, "invoices:read users:delete"); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("expected a scope of another client to be refused, got %v", err)
+	}
+
+	for name, c := range map[string]struct{ id, secret string }{
+		"wrong secret":  {id, secret + "x"},
+		"unknown":       {"unknown", secret},
+		"public client": {public, ""},
+	} {
+		if _, _, _, err := cs.ClientCredentials(ctx, c.id, c.secret, ""); status.Code(err) != codes.Unauthenticated {
+			t.Fatalf("expected %s to be rejected, got %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package services
+
+import "time"
+
+// Clock tells the time tokens are issued and validated at. TokenService
+// reads the time only through its Clock, so that expiry edge cases can be
+// tested without sleeping; Redis still expires keys on its own clock.
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+// WithClock replaces the system clock, e.g. with a fixed one in tests.
+func WithClock(c Clock) Option {
+	return func(s *TokenService) {
+		s.clock = c
+	}
+}
+
+// WithLeeway accepts access tokens up to d past their expiry or before their
+// "nbf", so that clients with slightly skewed clocks are not rejected.
+func WithLeeway(d time.Duration) Option {
+	return func(s *TokenService) {
+		s.leeway = d
+	}
+}
+
+func (s *TokenService) now() time.Time {
+	return s.clock.Now()
+}
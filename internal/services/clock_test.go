@@ -0,0 +1,85 @@
+package services
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andro-kes/auth_service/internal/autherr"
+)
+
+type fixedClock struct{ t time.Time }
+
+func (c *fixedClock) Now() time.Time { return c.t }
+
+func TestValidateAccess_Leeway(t *testing.T) {
+	secret := "012345678901234567890123456789ab"
+	clock := &fixedClock{t: time.Now()}
+	strict, _ := newTestTokenService(t, WithClock(clock))
+	rdb := strict.rdb
+	lenient, err := NewTokenService(rdb, secret, time.Minute, time.Minute*5, WithClock(clock), WithLeeway(30*time.Second))
+	if err != nil {
+		t.Fatalf("failed to create TokenService: %v", err)
+	}
+
+	access, _, _, _, err := strict.GenerateTokens(t.Context(), "user-123")
+	if err != nil {
+		t.Fatalf("GenerateTokens failed: %v", err)
+	}
+
+	clock.t = clock.t.Add(time.Minute + 10*time.Second)
+	if _, err := strict.ValidateAccess(access); err != autherr.ErrTokenExpired {
+		t.Fatalf("expected ErrTokenExpired without leeway, got %v", err)
+	}
+	if claims, err := lenient.ValidateAccess(access); err != nil || claims.UserID != "user-123" {
+		t.Fatalf("ValidateAccess within leeway = %+v, %v", claims, err)
+	}
+
+	clock.t = clock.t.Add(time.Minute)
+	if _, err := lenient.ValidateAccess(access); err != autherr.ErrTokenExpired {
+		t.Fatalf("expected ErrTokenExpired past leeway, got %v", err)
+	}
+}
+
+func TestClock_ExpiryBoundary(t *testing.T) {
+	clock := &fixedClock{t: time.Now().Truncate(time.Second)}
+	svc, srv := newTestTokenService(t, WithClock(clock))
+
+	ctx := t.Context()
+	start := clock.t
+	access, _, accessExp, _, err := svc.GenerateTokens(ctx, "user-123")
+	if err != nil {
+		t.Fatalf("GenerateTokens failed: %v", err)
+	}
+	reference, _, _, _, err := svc.GenerateTokens(ctx, "user-123", AsReferenceToken())
+	if err != nil {
+		t.Fatalf("GenerateTokens failed: %v", err)
+	}
+	if !accessExp.Equal(start.Add(time.Minute)) {
+		t.Fatalf("expected expiry at %v, got %v", start.Add(time.Minute), accessExp)
+	}
+
+	clock.t = accessExp.Add(-time.Second)
+	if _, err := svc.ValidateAccess(access); err != nil {
+		t.Fatalf("expected token valid a second before expiry, got %v", err)
+	}
+	if in, err := svc.Introspect(ctx, reference); err != nil || !in.Active {
+		t.Fatalf("expected reference token active a second before expiry, got %+v, %v", in, err)
+	}
+
+	clock.t = accessExp
+	if _, err := svc.ValidateAccess(access); err != autherr.ErrTokenExpired {
+		t.Fatalf("expected ErrTokenExpired at expiry, got %v", err)
+	}
+	if in, err := svc.Introspect(ctx, reference); err != nil || in.Active {
+		t.Fatalf("expected reference token inactive at expiry, got %+v, %v", in, err)
+	}
+	// nothing is left to deny once the token expired
+	if err := svc.RevokeAccess(ctx, access); err != nil {
+		t.Fatalf("RevokeAccess failed: %v", err)
+	}
+	if keys := srv.Keys(); slices.ContainsFunc(keys, func(k string) bool { return strings.HasPrefix(k, "access:revoked:") }) {
+		t.Fatalf("expected no denylist entry for an expired token, got keys %v", keys)
+	}
+}
@@ -0,0 +1,111 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/andro-kes/auth_service/internal/autherr"
+	"github.com/andro-kes/auth_service/internal/logger"
+	"github.com/andro-kes/auth_service/internal/metrics"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// WithDegradedMode keeps the service partly up while Redis is unreachable.
+// Access tokens keep being validated from their signature and claims alone:
+// the denylist is skipped and token versions are read from the database.
+// Operations that cannot work without Redis, such as issuing and rotating
+// refresh tokens, fail with ErrUnavailable asking clients to retry after
+// retryAfter instead of with a storage error.
+func WithDegradedMode(retryAfter time.Duration) Option {
+	return func(s *TokenService) {
+		s.outage = &outage{retryAfter: retryAfter}
+	}
+}
+
+// outage tracks whether Redis is reachable, as seen by the commands sent to
+// it: a connection error marks it down, any reply marks it up again.
+type outage struct {
+	down       atomic.Bool
+	retryAfter time.Duration
+}
+
+func (o *outage) observe(err error) {
+	down := isConnError(err)
+	if o.down.Swap(down) == down {
+		return
+	}
+	if down {
+		metrics.RedisDegraded.Set(1)
+		logger.Logger().Error("Redis unreachable, entering degraded mode", zap.Error(err))
+	} else {
+		metrics.RedisDegraded.Set(0)
+		logger.Logger().Info("Redis reachable again, leaving degraded mode")
+	}
+}
+
+// isConnError tells connection failures apart from replies, including
+// error replies and redis.Nil, and from canceled calls.
+func isConnError(err error) bool {
+	if err == nil || errors.Is(err, redis.Nil) || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var reply redis.Error
+	if errors.As(err, &reply) {
+		return false
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.EOF) ||
+		errors.Is(err, redis.ErrClosed) || errors.Is(err, redis.ErrPoolTimeout)
+}
+
+// redisDown reports whether degraded mode is on and Redis is unreachable.
+func (s *TokenService) redisDown() bool {
+	return s.outage != nil && s.outage.down.Load()
+}
+
+// degrade turns the internal errors of operations that failed because Redis
+// is unreachable into ErrUnavailable.
+func (s *TokenService) degrade(err error) error {
+	if err == nil || !s.redisDown() || status.Code(err) != codes.Internal {
+		return err
+	}
+	return autherr.ErrUnavailable.WithRetryDelay(s.outage.retryAfter)
+}
+
+// outageHook feeds the outage tracker from every command and pipeline.
+type outageHook struct {
+	o *outage
+}
+
+func (h outageHook) DialHook(next redis.DialHook) redis.DialHook {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := next(ctx, network, addr)
+		if err != nil {
+			h.o.observe(err)
+		}
+		return conn, err
+	}
+}
+
+func (h outageHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		err := next(ctx, cmd)
+		h.o.observe(err)
+		return err
+	}
+}
+
+func (h outageHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		err := next(ctx, cmds)
+		h.o.observe(err)
+		return err
+	}
+}
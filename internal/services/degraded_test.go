@@ -0,0 +1,91 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/andro-kes/auth_service/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/redis/go-redis/v9"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestDegradedMode(t *testing.T) {
+	srv, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("failed to start miniredis: %v", err)
+	}
+	defer srv.Close()
+
+	rdb := redis.NewClient(&redis.Options{Addr: srv.Addr(), MaxRetries: -1})
+	defer rdb.Close()
+	strictRDB := redis.NewClient(&redis.Options{Addr: srv.Addr(), MaxRetries: -1})
+	defer strictRDB.Close()
+
+	secret := "012345678901234567890123456789ab"
+	svc, err := NewTokenService(rdb, secret, time.Minute, time.Minute*5, WithDegradedMode(3*time.Second))
+	if err != nil {
+		t.Fatalf("failed to create TokenService: %v", err)
+	}
+	strict, err := NewTokenService(strictRDB, secret, time.Minute, time.Minute*5)
+	if err != nil {
+		t.Fatalf("failed to create TokenService: %v", err)
+	}
+
+	ctx := t.Context()
+	access, refresh, _, _, err := svc.GenerateTokens(ctx, "user-123")
+	if err != nil {
+		t.Fatalf("GenerateTokens failed: %v", err)
+	}
+
+	srv.Close()
+	if claims, err := svc.ValidateAccess(access); err != nil || claims.UserID != "user-123" {
+		t.Fatalf("expected access token valid while degraded, got %+v, %v", claims, err)
+	}
+	if got := testutil.ToFloat64(metrics.RedisDegraded); got != 1 {
+		t.Fatalf("expected degraded gauge 1, got %v", got)
+	}
+	if _, err := strict.ValidateAccess(access); status.Code(err) != codes.Internal {
+		t.Fatalf("expected storage error without degraded mode, got %v", err)
+	}
+
+	_, _, _, _, err = svc.RotateRefresh(ctx, refresh, "user-123")
+	st := status.Convert(err)
+	if st.Code() != codes.Unavailable {
+		t.Fatalf("expected Unavailable while degraded, got %v", err)
+	}
+	var retry *errdetails.RetryInfo
+	for _, d := range st.Details() {
+		if ri, ok := d.(*errdetails.RetryInfo); ok {
+			retry = ri
+		}
+	}
+	if retry == nil || retry.RetryDelay.AsDuration() != 3*time.Second {
+		t.Fatalf("expected RetryInfo of 3s, got details %v", st.Details())
+	}
+	if _, _, _, _, err := svc.GenerateTokens(ctx, "user-123"); status.Code(err) != codes.Unavailable {
+		t.Fatalf("expected GenerateTokens to be Unavailable while degraded, got %v", err)
+	}
+
+	if err := srv.Restart(); err != nil {
+		t.Fatalf("failed to restart miniredis: %v", err)
+	}
+	// the connection pool redials in the background after failed dials
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		_, _, _, _, err = svc.RotateRefresh(ctx, refresh, "user-123")
+		if status.Code(err) != codes.Unavailable || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("RotateRefresh after recovery failed: %v", err)
+	}
+	if got := testutil.ToFloat64(metrics.RedisDegraded); got != 0 {
+		t.Fatalf("expected degraded gauge 0 after recovery, got %v", got)
+	}
+}
@@ -0,0 +1,63 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"github.com/andro-kes/auth_service/internal/autherr"
+	"github.com/andro-kes/auth_service/internal/logger"
+	"github.com/andro-kes/auth_service/internal/repo/db"
+	"go.uber.org/zap"
+)
+
+// VerifyPassword checks the password of a user who is already
+// authenticated, before an action as sensitive as deleting the account.
+func (us *UserService) VerifyPassword(ctx context.Context, userID, password string) error {
+	user, err := us.findByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	return us.comparePassword(ctx, user.Password, password)
+}
+
+// DeleteUser soft-deletes a user: they can no longer log in nor be found,
+// while the row is kept until PurgeDeletedUsers removes it. Revoking the
+// user's tokens is up to the caller.
+func (us *UserService) DeleteUser(ctx context.Context, userID string) error {
+	if userID == "" {
+		return autherr.ErrBadRequest.WithMessage("user_id is required")
+	}
+	err := us.Tx.RunInTx(ctx, func(ctx context.Context, q db.Querier) error {
+		return us.Repo.SoftDelete(ctx, q, userID)
+	})
+	if err != nil {
+		if err == autherr.ErrNotFound {
+			return autherr.ErrNotFound
+		}
+		logger.FromContext(ctx).Error("Failed to delete user", zap.Error(err))
+		return autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	logger.FromContext(ctx).Info("User deleted", zap.String("user_id", userID))
+	return nil
+}
+
+// PurgeDeletedUsers removes users deleted more than retention ago every
+// interval until ctx is done.
+func (us *UserService) PurgeDeletedUsers(ctx context.Context, every, retention time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := us.Repo.PurgeDeleted(ctx, time.Now().Add(-retention))
+			if err != nil && ctx.Err() == nil {
+				logger.FromContext(ctx).Warn("Failed to purge deleted users", zap.Error(err))
+			}
+			if n > 0 {
+				logger.FromContext(ctx).Info("Deleted users purged", zap.Int64("count", n))
+			}
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/andro-kes/auth_service/internal/autherr"
+)
+
+func TestRevokeAccess(t *testing.T) {
+	svc, srv := newTestTokenService(t)
+	rdb := svc.rdb
+	// a second instance without the revocation broadcast must still see it
+	other, err := NewTokenService(rdb, "012345678901234567890123456789ab", time.Minute, time.Minute*5)
+	if err != nil {
+		t.Fatalf("failed to create TokenService: %v", err)
+	}
+
+	ctx := t.Context()
+	access, _, _, _, err := svc.GenerateTokens(ctx, "alice")
+	if err != nil {
+		t.Fatalf("GenerateTokens failed: %v", err)
+	}
+	if _, err := other.ValidateAccess(access); err != nil {
+		t.Fatalf("expected token to validate before revocation, got %v", err)
+	}
+
+	if err := svc.RevokeAccess(ctx, access); err != nil {
+		t.Fatalf("RevokeAccess failed: %v", err)
+	}
+	if _, err := other.ValidateAccess(access); err != autherr.ErrInvalidToken {
+		t.Fatalf("expected revoked token to be rejected, got %v", err)
+	}
+	if _, err := other.ValidateAccessForCall(ctx, access, "", "/auth.AuthService/ListSessions"); err != autherr.ErrInvalidToken {
+		t.Fatalf("expected revoked token to be rejected per call, got %v", err)
+	}
+	if ttl := srv.TTL("access:revoked:" + mustJTI(t, svc, access)); ttl <= 0 || ttl > time.Minute {
+		t.Fatalf("expected denylist entry to live until token expiry, got %v", ttl)
+	}
+
+	if err := svc.RevokeAccess(ctx, "garbage"); err != nil {
+		t.Fatalf("expected invalid token revocation to be a no-op, got %v", err)
+	}
+}
+
+func mustJTI(t *testing.T, svc *TokenService, token string) string {
+	t.Helper()
+	claims, err := svc.parseAndMapErr(token)
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	return claims.ID
+}
@@ -0,0 +1,102 @@
+package services
+
+import (
+	"context"
+
+	"github.com/andro-kes/auth_service/internal/autherr"
+	"github.com/andro-kes/auth_service/internal/logger"
+	"github.com/andro-kes/auth_service/internal/repo"
+	"github.com/andro-kes/auth_service/internal/repo/db"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+)
+
+// AuditUserErased is recorded when a user's personal data is erased.
+const AuditUserErased = "user.erased"
+
+// ErasureService irreversibly removes the personal data of users, e.g. for
+// right-to-erasure requests. The user row stays, anonymized, so that
+// references to the user ID remain valid.
+type ErasureService struct {
+	Users      repo.UserRepo
+	History    repo.PasswordHistoryRepo
+	Recovery   repo.RecoveryEmailRepo
+	Attempts   repo.LoginAttemptRepo
+	Names      repo.UsernameChangeRepo
+	Identities repo.IdentityRepo
+	MFA        repo.MFARepo
+	Phones     repo.PhoneRepo
+	Audit      repo.AuditRepo
+	Tx         db.Tx
+}
+
+func NewErasureService(ctx context.Context, pool *pgxpool.Pool) *ErasureService {
+	return &ErasureService{
+		Users:      repo.NewUserRepo(ctx, pool),
+		History:    repo.NewPasswordHistoryRepo(ctx, pool),
+		Recovery:   repo.NewRecoveryEmailRepo(ctx, pool),
+		Attempts:   repo.NewLoginAttemptRepo(ctx, pool),
+		Names:      repo.NewUsernameChangeRepo(ctx, pool),
+		Identities: repo.NewIdentityRepo(ctx, pool),
+		MFA:        repo.NewMFARepo(ctx, pool),
+		Phones:     repo.NewPhoneRepo(ctx, pool),
+		Audit:      repo.NewAuditRepo(ctx, pool),
+		Tx:         db.NewTx(pool),
+	}
+}
+
+// EraseUser anonymizes userID, deleted or not: the username becomes
+// "erased-<id>", the email, password, profile and last login IP are
+// cleared, password history, previous usernames, linked identities, second
+// factors, phone, recovery email and failed logins are removed and the client
+// data of their audit events is dropped.
+// An audit event records the erasure. Revoking the user's tokens is up to the caller.
+func (es *ErasureService) EraseUser(ctx context.Context, userID string, client ClientInfo) error {
+	if userID == "" {
+		return autherr.ErrBadRequest.WithMessage("user_id is required")
+	}
+	err := es.Tx.RunInTx(ctx, func(ctx context.Context, q db.Querier) error {
+		username, email, err := es.Users.Erase(ctx, q, userID)
+		if err != nil {
+			return err
+		}
+		if err := es.History.Trim(ctx, q, userID, 0); err != nil {
+			return err
+		}
+		if err := es.Names.DeleteByUser(ctx, q, userID); err != nil {
+			return err
+		}
+		if err := es.Identities.DeleteByUser(ctx, q, userID); err != nil {
+			return err
+		}
+		if err := es.MFA.Delete(ctx, q, userID); err != nil {
+			return err
+		}
+		if _, err := es.Phones.Delete(ctx, q, userID); err != nil {
+			return err
+		}
+		if _, err := es.Recovery.Delete(ctx, q, userID); err != nil {
+			return err
+		}
+		logins := []string{username}
+		if email != "" {
+			logins = append(logins, email)
+		}
+		if err := es.Attempts.DeleteByLogin(ctx, q, logins); err != nil {
+			return err
+		}
+		if err := es.Audit.Anonymize(ctx, q, userID); err != nil {
+			return err
+		}
+		return es.Audit.Insert(ctx, q, auditEvent(AuditUserErased, userID, client, nil))
+	})
+	if err == autherr.ErrNotFound {
+		return autherr.ErrNotFound
+	}
+	if err != nil {
+		logger.FromContext(ctx).Error("Failed to erase user", zap.Error(err))
+		return autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	logger.FromContext(ctx).Warn("User erased", zap.String("user_id", userID))
+	return nil
+}
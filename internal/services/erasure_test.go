@@ -0,0 +1,53 @@
+package services
+
+import (
+	"context"
+	"testing"
+
+	"github.com/andro-kes/auth_service/internal/autherr"
+	"github.com/andro-kes/auth_service/internal/models"
+)
+
+func TestEraseUser(t *testing.T) {
+	ctx := context.Background()
+	users := &testUserRepo{
+		passwords: map[string]string{"u1": "hash"},
+		profiles:  map[string]models.Profile{"u1": {FirstName: "Alice"}},
+	}
+	history := &testHistoryRepo{hashes: map[string][]string{"u1": {"old-hash"}}}
+	recovery := &testRecoveryRepo{rows: map[string]*models.RecoveryEmail{"u1": {UserID: "u1", Email: "backup@example.com"}}}
+	attempts := &testAttemptRepo{stored: []models.LoginAttempt{
+		{Username: "User-u1", IP: "203.0.113.7"},
+		{Username: "someone-else"},
+	}}
+	audit := &testAuditRepo{stored: []models.AuditEvent{
+		{Type: AuditRecoveryEmailSet, UserID: "u1", IP: "203.0.113.7", Details: map[string]string{"email": "backup@example.com"}},
+	}}
+	es := &ErasureService{Users: users, History: history, Recovery: recovery, Attempts: attempts, Names: &testUsernameRepo{}, Identities: &testIdentityRepo{}, MFA: &testMFARepo{}, Phones: &testPhoneRepo{}, Audit: audit, Tx: &fakeTx{}}
+
+	if err := es.EraseUser(ctx, "u1", ClientInfo{IP: "10.0.0.1"}); err != nil {
+		t.Fatalf("EraseUser failed: %v", err)
+	}
+	if _, err := users.FindByID(ctx, "u1"); err != autherr.ErrNotFound {
+		t.Fatalf("expected the erased user to be gone, got %v", err)
+	}
+	if len(history.hashes["u1"]) != 0 || recovery.rows["u1"] != nil {
+		t.Fatal("expected password history and recovery email to be removed")
+	}
+	if len(attempts.stored) != 1 || attempts.stored[0].Username != "someone-else" {
+		t.Fatalf("expected only the user's failed logins removed, got %+v", attempts.stored)
+	}
+	if len(audit.stored) != 2 {
+		t.Fatalf("expected the erasure to be audited, got %+v", audit.stored)
+	}
+	if e := audit.stored[0]; e.IP != "" || e.Details != nil || e.Type != AuditRecoveryEmailSet {
+		t.Fatalf("expected the old event anonymized, got %+v", e)
+	}
+	if e := audit.stored[1]; e.Type != AuditUserErased || e.UserID != "u1" || e.IP != "10.0.0.1" {
+		t.Fatalf("unexpected erasure event: %+v", e)
+	}
+
+	if err := es.EraseUser(ctx, "", ClientInfo{}); err == nil {
+		t.Fatal("expected an error for an empty user_id")
+	}
+}
@@ -0,0 +1,143 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"github.com/andro-kes/auth_service/internal/autherr"
+	"github.com/andro-kes/auth_service/internal/logger"
+	"github.com/andro-kes/auth_service/internal/models"
+	"github.com/andro-kes/auth_service/internal/repo"
+	"go.uber.org/zap"
+)
+
+// ExportService collects everything stored about a user for data
+// portability requests.
+type ExportService struct {
+	Users    *UserService
+	Tokens   *TokenService
+	Roles    *RoleService
+	Recovery *RecoveryService
+	// Identities lists linked external identities. When nil, they are left
+	// out.
+	Identities *IdentityService
+	// MFA provides the user's phone. When nil, it is left out.
+	MFA   *MFAService
+	Audit repo.AuditRepo
+}
+
+// UserDataExport is the data of one user. Password hashes, token hashes and
+// verification codes are left out.
+type UserDataExport struct {
+	ExportedAt    time.Time             `json:"exported_at"`
+	User          ExportedUser          `json:"user"`
+	Roles         []string              `json:"roles"`
+	RecoveryEmail *models.RecoveryEmail `json:"recovery_email,omitempty"`
+	Identities    []models.Identity     `json:"identities,omitempty"`
+	Phone         *models.Phone         `json:"phone,omitempty"`
+	Sessions      []ExportedSession     `json:"sessions"`
+	AuditEvents   []models.AuditEvent   `json:"audit_events"`
+	// FailedLogins are the failed logins with the user's username or email.
+	FailedLogins []models.LoginAttempt `json:"failed_logins"`
+}
+
+// ExportedUser is the account record of a user.
+type ExportedUser struct {
+	ID          string         `json:"id"`
+	Username    string         `json:"username"`
+	Email       string         `json:"email,omitempty"`
+	Status      string         `json:"status"`
+	CreatedAt   time.Time      `json:"created_at"`
+	LastLoginAt time.Time      `json:"last_login_at,omitzero"`
+	LastLoginIP string         `json:"last_login_ip,omitempty"`
+	Profile     models.Profile `json:"profile"`
+}
+
+// ExportedSession is an active session of a user.
+type ExportedSession struct {
+	ID         string    `json:"id"`
+	DeviceID   string    `json:"device_id,omitempty"`
+	UserAgent  string    `json:"user_agent,omitempty"`
+	IP         string    `json:"ip,omitempty"`
+	Location   string    `json:"location,omitempty"`
+	CreatedAt  time.Time `json:"created_at,omitzero"`
+	LastUsedAt time.Time `json:"last_used_at,omitzero"`
+	ExpiresAt  time.Time `json:"expires_at,omitzero"`
+}
+
+// ExportUserData returns the data stored about userID.
+func (es *ExportService) ExportUserData(ctx context.Context, userID string) (*UserDataExport, error) {
+	user, err := es.Users.GetUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	out := &UserDataExport{
+		ExportedAt: time.Now().UTC(),
+		User: ExportedUser{
+			ID:          user.ID,
+			Username:    user.Username,
+			Email:       user.Email,
+			Status:      user.Status,
+			CreatedAt:   user.CreatedAt,
+			LastLoginAt: user.LastLoginAt,
+			LastLoginIP: user.LastLoginIP,
+			Profile:     user.Profile,
+		},
+	}
+
+	if out.Roles, err = es.Roles.UserRoles(ctx, userID); err != nil {
+		return nil, err
+	}
+	out.RecoveryEmail, err = es.Recovery.GetRecoveryEmail(ctx, userID)
+	if err != nil && err != autherr.ErrNotFound {
+		return nil, err
+	}
+
+	if es.Identities != nil {
+		if out.Identities, err = es.Identities.Identities(ctx, userID); err != nil {
+			return nil, err
+		}
+	}
+
+	if es.MFA != nil {
+		out.Phone, err = es.MFA.Phone(ctx, userID)
+		if err != nil && err != autherr.ErrNotFound {
+			return nil, err
+		}
+	}
+
+	sessions, err := es.Tokens.ListSessions(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range sessions {
+		out.Sessions = append(out.Sessions, ExportedSession{
+			ID:         s.ID,
+			DeviceID:   s.Client.DeviceID,
+			UserAgent:  s.Client.UserAgent,
+			IP:         s.Client.IP,
+			Location:   s.Client.Location,
+			CreatedAt:  s.CreatedAt,
+			LastUsedAt: s.LastUsedAt,
+			ExpiresAt:  s.ExpiresAt,
+		})
+	}
+
+	if out.AuditEvents, err = es.Audit.ListByUser(ctx, userID); err != nil {
+		logger.FromContext(ctx).Error("Failed to list audit events", zap.Error(err))
+		return nil, autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	if es.Users.Attempts != nil {
+		logins := []string{user.Username}
+		if user.Email != "" {
+			logins = append(logins, user.Email)
+		}
+		if out.FailedLogins, err = es.Users.Attempts.ListByLogin(ctx, logins); err != nil {
+			logger.FromContext(ctx).Error("Failed to list login attempts", zap.Error(err))
+			return nil, autherr.ErrStorageError.WithMessage(err.Error())
+		}
+	}
+
+	logger.FromContext(ctx).Info("User data exported", zap.String("user_id", userID))
+	return out, nil
+}
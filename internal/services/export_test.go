@@ -0,0 +1,67 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/andro-kes/auth_service/internal/models"
+)
+
+func TestExportUserData(t *testing.T) {
+	tokens, _ := newTestTokenService(t)
+	ctx := context.Background()
+	if _, _, _, _, err := tokens.GenerateTokens(ctx, "u1", WithClientInfo(ClientInfo{IP: "203.0.113.7"})); err != nil {
+		t.Fatalf("GenerateTokens failed: %v", err)
+	}
+
+	users := &testUserRepo{passwords: map[string]string{"u1": "$2a$12$secret-hash"}}
+	audit := &testAuditRepo{stored: []models.AuditEvent{
+		{Type: AuditRecoveryEmailSet, UserID: "u1"},
+		{Type: AuditRecoveryEmailSet, UserID: "u2"},
+	}}
+	attempts := &testAttemptRepo{stored: []models.LoginAttempt{
+		{Username: "USER-u1", Reason: LoginFailureInvalidPassword},
+		{Username: "someone-else", Reason: LoginFailureUnknownUser},
+	}}
+	roles := &testRoleRepo{roles: map[string]*models.Role{}, users: map[string][]string{"u1": {"editor"}}}
+	es := &ExportService{
+		Users:  &UserService{Repo: users, Tx: &fakeTx{}, Attempts: attempts},
+		Tokens: tokens,
+		Roles:  &RoleService{Repo: roles, Tx: &fakeTx{}},
+		Recovery: &RecoveryService{
+			Users:  users,
+			Emails: &testRecoveryRepo{rows: map[string]*models.RecoveryEmail{}},
+			Audit:  audit,
+			Tx:     &fakeTx{},
+		},
+		Audit: audit,
+	}
+
+	export, err := es.ExportUserData(ctx, "u1")
+	if err != nil {
+		t.Fatalf("ExportUserData failed: %v", err)
+	}
+	if export.User.ID != "u1" || len(export.Roles) != 1 || export.RecoveryEmail != nil {
+		t.Fatalf("unexpected export: %+v", export)
+	}
+	if len(export.Sessions) != 1 || export.Sessions[0].IP != "203.0.113.7" {
+		t.Fatalf("expected the session, got %+v", export.Sessions)
+	}
+	if len(export.AuditEvents) != 1 || len(export.FailedLogins) != 1 {
+		t.Fatalf("expected the user's events and failed logins only, got %+v and %+v", export.AuditEvents, export.FailedLogins)
+	}
+
+	b, err := json.Marshal(export)
+	if err != nil {
+		t.Fatalf("failed to marshal export: %v", err)
+	}
+	if strings.Contains(string(b), "secret-hash") {
+		t.Fatalf("export contains the password hash: %s", b)
+	}
+
+	if _, err := es.ExportUserData(ctx, ""); err == nil {
+		t.Fatal("expected an error for an empty user_id")
+	}
+}
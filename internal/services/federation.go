@@ -0,0 +1,341 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/andro-kes/auth_service/internal/autherr"
+	"github.com/andro-kes/auth_service/internal/federation"
+	"github.com/andro-kes/auth_service/internal/logger"
+	"github.com/andro-kes/auth_service/internal/models"
+	"github.com/andro-kes/auth_service/internal/repo"
+	"github.com/andro-kes/auth_service/internal/repo/db"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// AuditUserProvisioned is the audit event type of users created by a
+// federated login.
+const AuditUserProvisioned = "user.provisioned"
+
+// provisionAttempts bounds the usernames tried for a new federated user.
+const provisionAttempts = 5
+
+// FederationService logs users in with their accounts at external identity
+// providers. The identity a provider reports is looked up among the linked
+// identities; an unknown one is linked to the user with the same email if
+// the provider verified it, or else gets a new user.
+type FederationService struct {
+	// Providers by name, e.g. "google".
+	Providers map[string]federation.Provider
+	// NoLinkByEmail refuses first logins whose email belongs to an account
+	// even when the provider verified it: the user has to log in to the
+	// account and link the provider there.
+	NoLinkByEmail bool
+	Users         *UserService
+	Identities    repo.IdentityRepo
+	Audit         repo.AuditRepo
+	Tx            db.Tx
+}
+
+func NewFederationService(ctx context.Context, pool *pgxpool.Pool, users *UserService, providers map[string]federation.Provider) *FederationService {
+	return &FederationService{
+		Providers:  providers,
+		Users:      users,
+		Identities: repo.NewIdentityRepo(ctx, pool),
+		Audit:      repo.NewAuditRepo(ctx, pool),
+		Tx:         db.NewTx(pool),
+	}
+}
+
+// Login authenticates cred at provider and returns the local user of the
+// identity, linking or creating it on the first login. New users are not
+// created when registration requires an invite.
+func (fs *FederationService) Login(ctx context.Context, provider string, cred federation.Credential, client ClientInfo) (*models.User, error) {
+	identity, err := fs.authenticate(ctx, provider, cred)
+	if err != nil {
+		return nil, err
+	}
+	return fs.LoginIdentity(ctx, provider, identity, client)
+}
+
+// Link authenticates cred at provider and links the identity to userID,
+// who proves this way that the account at provider is theirs.
+func (fs *FederationService) Link(ctx context.Context, userID, provider string, cred federation.Credential, client ClientInfo) (*models.Identity, error) {
+	identity, err := fs.authenticate(ctx, provider, cred)
+	if err != nil {
+		return nil, err
+	}
+	email := ""
+	if identity.Email != "" {
+		email, _ = normalizeEmail(identity.Email)
+	}
+	err = fs.Tx.RunInTx(ctx, func(ctx context.Context, q db.Querier) error {
+		return fs.link(ctx, q, provider, identity, email, userID, client)
+	})
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+			if pgErr.ConstraintName == repo.IdentityUserProviderKey {
+				return nil, autherr.ErrConflict.WithMessage("an account at " + provider + " is already linked; unlink it first")
+			}
+			return nil, autherr.ErrConflict.WithMessage("this account at " + provider + " is linked to another user")
+		}
+		logger.FromContext(ctx).Error("Failed to link identity", zap.Error(err))
+		return nil, autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	logger.FromContext(ctx).Info("Identity linked", zap.String("user_id", userID), zap.String("provider", provider))
+	return &models.Identity{UserID: userID, Provider: provider, Subject: identity.Subject, Email: email}, nil
+}
+
+// authenticate returns the identity cred proves at provider.
+func (fs *FederationService) authenticate(ctx context.Context, provider string, cred federation.Credential) (*federation.Identity, error) {
+	p, ok := fs.Providers[provider]
+	if !ok {
+		return nil, autherr.ErrNotFound.WithMessage("unknown identity provider")
+	}
+	if (cred.Code == "") == (cred.IDToken == "") {
+		return nil, autherr.ErrBadRequest.WithMessage("exactly one of code and id_token is required")
+	}
+	identity, err := p.Authenticate(ctx, cred)
+	if err != nil {
+		switch {
+		case errors.Is(err, federation.ErrInvalidCredential):
+			return nil, autherr.ErrInvalidToken.WithMessage(err.Error())
+		case errors.Is(err, federation.ErrUnsupported):
+			return nil, autherr.ErrBadRequest.WithMessage(provider + " does not support this kind of credential")
+		}
+		logger.FromContext(ctx).Error("Identity provider unavailable", zap.String("provider", provider), zap.Error(err))
+		return nil, autherr.ErrUnavailable
+	}
+	return identity, nil
+}
+
+// LoginIdentity returns the local user of an identity that provider
+// authenticated, linking or creating it on the first login, as Login does.
+// It serves backends that check credentials themselves, such as LDAP
+// directories.
+func (fs *FederationService) LoginIdentity(ctx context.Context, provider string, identity *federation.Identity, client ClientInfo) (*models.User, error) {
+	user, err := fs.linkedUser(ctx, provider, identity)
+	if err == autherr.ErrNotFound {
+		user, err = fs.firstLogin(ctx, provider, identity, client)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := statusError(user.Status); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// linkedUser returns the user identity is linked to, or ErrNotFound.
+func (fs *FederationService) linkedUser(ctx context.Context, provider string, identity *federation.Identity) (*models.User, error) {
+	linked, err := fs.Identities.FindBySubject(ctx, provider, identity.Subject)
+	if err == nil {
+		var user *models.User
+		if user, err = fs.Users.Repo.FindByID(ctx, linked.UserID); err == nil {
+			return user, nil
+		}
+	}
+	if err == autherr.ErrNotFound {
+		return nil, autherr.ErrNotFound
+	}
+	logger.FromContext(ctx).Error("Failed to find user by identity", zap.Error(err))
+	return nil, autherr.ErrStorageError.WithMessage(err.Error())
+}
+
+// firstLogin links identity to the user with its email or creates a user.
+func (fs *FederationService) firstLogin(ctx context.Context, provider string, identity *federation.Identity, client ClientInfo) (*models.User, error) {
+	email := ""
+	if identity.Email != "" {
+		// an address we cannot store is not worth failing the login for
+		email, _ = normalizeEmail(identity.Email)
+	}
+	if email != "" {
+		user, err := fs.Users.Repo.FindByEmail(ctx, email)
+		switch {
+		case err == nil:
+			return fs.linkByEmail(ctx, provider, identity, email, user, client)
+		case err != autherr.ErrNotFound:
+			logger.FromContext(ctx).Error("Failed to get user by email", zap.Error(err))
+			return nil, autherr.ErrStorageError.WithMessage(err.Error())
+		}
+	}
+	if fs.Users.RequireInvite {
+		return nil, autherr.ErrForbidden.WithMessage("registration requires an invite")
+	}
+	if !identity.EmailVerified {
+		// do not let an unverified address block its owner from registering
+		email = ""
+	}
+	return fs.provision(ctx, provider, identity, email, client)
+}
+
+// linkByEmail links identity to user, whose email the provider reported.
+// Only verified emails are trusted: anyone can claim an address at a
+// provider that does not check it, e.g. as the public email of a GitHub
+// profile.
+func (fs *FederationService) linkByEmail(ctx context.Context, provider string, identity *federation.Identity, email string, user *models.User, client ClientInfo) (*models.User, error) {
+	if !identity.EmailVerified || fs.NoLinkByEmail {
+		return nil, autherr.ErrConflict.WithMessage("an account with this email exists; log in to it to link " + provider)
+	}
+	err := fs.Tx.RunInTx(ctx, func(ctx context.Context, q db.Querier) error {
+		return fs.link(ctx, q, provider, identity, email, user.ID, client)
+	})
+	if err != nil {
+		return nil, fs.linkError(ctx, err)
+	}
+	logger.FromContext(ctx).Info("Identity linked by email", zap.String("user_id", user.ID), zap.String("provider", provider))
+	return user, nil
+}
+
+// provision creates a user for identity, trying a few usernames derived
+// from it.
+func (fs *FederationService) provision(ctx context.Context, provider string, identity *federation.Identity, email string, client ClientInfo) (*models.User, error) {
+	// the user has no password until they set one through recovery
+	password, err := randomBase64(rand.Reader, 32)
+	if err != nil {
+		return nil, autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	hash, err := fs.Users.hashPassword(ctx, password)
+	if err != nil {
+		return nil, err
+	}
+	user := &models.User{
+		ID:         uuid.New().String(),
+		Email:      email,
+		Password:   hash,
+		NoPassword: true,
+		Status:     models.UserStatusActive,
+		Profile: models.Profile{
+			FirstName:   identity.FirstName,
+			LastName:    identity.LastName,
+			DisplayName: identity.DisplayName,
+		},
+	}
+	if fs.Users.RequireApproval {
+		user.Status = models.UserStatusPending
+	}
+
+	base := federatedUsername(identity, email)
+	for attempt := range provisionAttempts {
+		user.Username = base
+		if attempt > 0 {
+			suffix, err := verificationCode()
+			if err != nil {
+				return nil, autherr.ErrStorageError.WithMessage(err.Error())
+			}
+			user.Username = base + "-" + suffix[2:]
+		}
+		err = fs.Tx.RunInTx(ctx, func(ctx context.Context, q db.Querier) error {
+			if err := fs.Users.checkReserved(ctx, q, user.Username, ""); err != nil {
+				return err
+			}
+			if _, err := fs.Users.Repo.Create(ctx, q, user); err != nil {
+				return err
+			}
+			if columns := profileColumns(&user.Profile); len(columns) > 0 {
+				if _, err := fs.Users.Repo.UpdateProfile(ctx, q, user.ID, &user.Profile, columns); err != nil {
+					return err
+				}
+			}
+			if err := fs.Audit.Insert(ctx, q, auditEvent(AuditUserProvisioned, user.ID, client, map[string]string{
+				"provider": provider,
+			})); err != nil {
+				return err
+			}
+			return fs.link(ctx, q, provider, identity, email, user.ID, client)
+		})
+		if !usernameTaken(err) {
+			break
+		}
+	}
+	if err != nil {
+		var authErr *autherr.AuthError
+		if errors.As(err, &authErr) {
+			return nil, err
+		}
+		return nil, fs.linkError(ctx, err)
+	}
+	logger.FromContext(ctx).Info("User provisioned", zap.String("user_id", user.ID), zap.String("provider", provider))
+	return user, nil
+}
+
+func (fs *FederationService) link(ctx context.Context, q db.Querier, provider string, identity *federation.Identity, email, userID string, client ClientInfo) error {
+	if err := fs.Identities.Create(ctx, q, &models.Identity{
+		UserID:   userID,
+		Provider: provider,
+		Subject:  identity.Subject,
+		Email:    email,
+	}); err != nil {
+		return err
+	}
+	return fs.Audit.Insert(ctx, q, auditEvent(AuditIdentityLinked, userID, client, map[string]string{
+		"provider": provider,
+	}))
+}
+
+func (fs *FederationService) linkError(ctx context.Context, err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+		// a concurrent first login of the same identity won
+		return autherr.ErrConflict.WithMessage("identity was linked concurrently; retry the login")
+	}
+	logger.FromContext(ctx).Error("Failed to link identity", zap.Error(err))
+	return autherr.ErrStorageError.WithMessage(err.Error())
+}
+
+// usernameTaken reports whether err is ErrUserExists for the username.
+func usernameTaken(err error) bool {
+	var authErr *autherr.AuthError
+	return errors.As(err, &authErr) && status.Code(err) == codes.AlreadyExists &&
+		strings.HasPrefix(authErr.Message, "username")
+}
+
+// profileColumns lists the non-empty columns of profile.
+func profileColumns(profile *models.Profile) []string {
+	var columns []string
+	for _, c := range []struct{ col, v string }{
+		{"first_name", profile.FirstName},
+		{"last_name", profile.LastName},
+		{"display_name", profile.DisplayName},
+	} {
+		if c.v != "" {
+			columns = append(columns, c.col)
+		}
+	}
+	return columns
+}
+
+// federatedUsername derives a valid username from the provider's username
+// or the email's local part.
+func federatedUsername(identity *federation.Identity, email string) string {
+	name := identity.Username
+	if name == "" {
+		name, _, _ = strings.Cut(email, "@")
+	}
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("._-", r) {
+			return r
+		}
+		return -1
+	}, normalizeUsername(name))
+	// leave room for a suffix
+	for utf8.RuneCountInString(name) > maxUsernameLen-5 {
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-size]
+	}
+	if utf8.RuneCountInString(name) < minUsernameLen {
+		name = "user" + name
+	}
+	return name
+}
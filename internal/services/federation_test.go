@@ -0,0 +1,182 @@
+package services
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/andro-kes/auth_service/internal/federation"
+	"github.com/andro-kes/auth_service/internal/models"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// testProvider returns the identity registered for a code. It takes no ID
+// tokens.
+type testProvider map[string]*federation.Identity
+
+func (p testProvider) Authenticate(ctx context.Context, cred federation.Credential) (*federation.Identity, error) {
+	if cred.Code == "" {
+		return nil, federation.ErrUnsupported
+	}
+	id, ok := p[cred.Code]
+	if !ok {
+		return nil, federation.ErrInvalidCredential
+	}
+	return id, nil
+}
+
+func TestFederatedLogin(t *testing.T) {
+	users := &testUserRepo{emails: []string{"bob@example.com"}}
+	identities := &testIdentityRepo{}
+	audit := &testAuditRepo{}
+	fs := &FederationService{
+		Providers: map[string]federation.Provider{
+			"google": testProvider{
+				"alice": {Subject: "g-1", Email: "Alice.Liddell@Example.com", EmailVerified: true, DisplayName: "Alice"},
+				"bob":   {Subject: "g-2", Email: "bob@example.com", EmailVerified: true},
+				"eve":   {Subject: "g-3", Email: "bob@example.com"},
+			},
+			"github": testProvider{
+				"carol": {Subject: "42", Username: "carol", Email: "carol@example.com"},
+				"bob":   {Subject: "43", Username: "bobby", Email: "bob@example.com", EmailVerified: true},
+			},
+		},
+		Users:      &UserService{Repo: users, Tx: &fakeTx{}},
+		Identities: identities,
+		Audit:      audit,
+		Tx:         &fakeTx{},
+	}
+	ctx := t.Context()
+	login := func(provider, code string) (*models.User, error) {
+		return fs.Login(ctx, provider, federation.Credential{Code: code}, ClientInfo{})
+	}
+
+	if _, err := login("gitlab", "alice"); status.Code(err) != codes.NotFound {
+		t.Fatalf("expected an unknown provider to be NotFound, got %v", err)
+	}
+	if _, err := login("google", "forged"); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected a rejected code to be Unauthenticated, got %v", err)
+	}
+
+	// the first login creates the user
+	user, err := login("google", "alice")
+	if err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+	if user.Username != "alice.liddell" || user.Email != "alice.liddell@example.com" || users.profiles[user.ID].DisplayName != "Alice" {
+		t.Fatalf("unexpected provisioned user %+v, profile %+v", user, users.profiles[user.ID])
+	}
+	if !users.newUser.NoPassword {
+		t.Fatal("expected the provisioned user to have no password")
+	}
+	if len(identities.identities) != 1 || identities.identities[0].UserID != user.ID {
+		t.Fatalf("expected the identity to be linked, got %+v", identities.identities)
+	}
+	if !slices.Equal(audit.events, []string{AuditUserProvisioned, AuditIdentityLinked}) {
+		t.Fatalf("unexpected audit events %v", audit.events)
+	}
+	// later logins find it through the identity
+	again, err := login("google", "alice")
+	if err != nil || again.ID != user.ID {
+		t.Fatalf("expected the linked user, got %+v, %v", again, err)
+	}
+
+	// a verified email links the existing account, an unverified one does not
+	if _, err := login("google", "eve"); status.Code(err) != codes.AlreadyExists {
+		t.Fatalf("expected an unverified email of an account to conflict, got %v", err)
+	}
+	bob, err := login("google", "bob")
+	if err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+	if bob.Email != "bob@example.com" || len(identities.identities) != 2 || identities.identities[1].UserID != bob.ID {
+		t.Fatalf("expected the identity to be linked to the account, got %+v", identities.identities)
+	}
+
+	// an unverified email is not given to a new user
+	carol, err := login("github", "carol")
+	if err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+	if carol.Username != "carol" || carol.Email != "" {
+		t.Fatalf("expected a user without email, got %+v", carol)
+	}
+	if _, err := fs.Login(ctx, "github", federation.Credential{IDToken: "x"}, ClientInfo{}); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected an unsupported credential to be InvalidArgument, got %v", err)
+	}
+	fs.NoLinkByEmail = true
+	if _, err := login("github", "bob"); status.Code(err) != codes.AlreadyExists {
+		t.Fatalf("expected linking by email to be refused, got %v", err)
+	}
+
+	users.status = models.UserStatusBanned
+	if _, err := login("google", "bob"); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("expected a banned user to be refused, got %v", err)
+	}
+
+	fs.Users.RequireInvite = true
+	identities.identities = nil
+	users.emails = nil
+	if _, err := login("google", "alice"); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("expected no users to be created without invites, got %v", err)
+	}
+}
+
+func TestFederatedLink(t *testing.T) {
+	identities := &testIdentityRepo{}
+	audit := &testAuditRepo{}
+	fs := &FederationService{
+		Providers: map[string]federation.Provider{
+			"google": testProvider{
+				"alice": {Subject: "g-1", Email: "Alice@Example.com"},
+				"other": {Subject: "g-2"},
+			},
+		},
+		Users:      &UserService{Repo: &testUserRepo{}, Tx: &fakeTx{}},
+		Identities: identities,
+		Audit:      audit,
+		Tx:         &fakeTx{},
+	}
+	ctx := t.Context()
+	link := func(userID, code string) (*models.Identity, error) {
+		return fs.Link(ctx, userID, "google", federation.Credential{Code: code}, ClientInfo{})
+	}
+
+	if _, err := link("u1", "forged"); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected a rejected code to be Unauthenticated, got %v", err)
+	}
+	identity, err := link("u1", "alice")
+	if err != nil {
+		t.Fatalf("Link failed: %v", err)
+	}
+	if identity.UserID != "u1" || identity.Subject != "g-1" || identity.Email != "alice@example.com" {
+		t.Fatalf("unexpected identity %+v", identity)
+	}
+	if _, err := link("u2", "alice"); status.Code(err) != codes.AlreadyExists {
+		t.Fatalf("expected an identity of another user to be AlreadyExists, got %v", err)
+	}
+	if _, err := link("u1", "other"); status.Code(err) != codes.AlreadyExists {
+		t.Fatalf("expected a second identity at the provider to be AlreadyExists, got %v", err)
+	}
+	if len(identities.identities) != 1 || !slices.Equal(audit.events, []string{AuditIdentityLinked}) {
+		t.Fatalf("unexpected identities %+v, audit events %v", identities.identities, audit.events)
+	}
+}
+
+func TestFederatedUsername(t *testing.T) {
+	for _, tc := range []struct {
+		identity federation.Identity
+		email    string
+		want     string
+	}{
+		{federation.Identity{Username: "octo cat!"}, "", "octocat"},
+		{federation.Identity{}, "jo@example.com", "userjo"},
+		{federation.Identity{}, "", "user"},
+		{federation.Identity{Username: "a-very-long-login-name-from-github"}, "", "a-very-long-login-name-from"},
+	} {
+		if got := federatedUsername(&tc.identity, tc.email); got != tc.want {
+			t.Fatalf("federatedUsername(%+v, %q) = %q, want %q", tc.identity, tc.email, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"github.com/andro-kes/auth_service/internal/autherr"
+	"github.com/andro-kes/auth_service/internal/logger"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// WithRotationGrace keeps a rotated refresh token usable for d: presenting
+// it again rotates the current token of its session instead of failing, so
+// a client that lost the response to a refresh can retry. Only rotation
+// honours the grace period. Zero disables it.
+func WithRotationGrace(d time.Duration) Option {
+	return func(s *TokenService) {
+		s.rotationGrace = d
+	}
+}
+
+// rememberRotated records the session a rotated token belonged to for the
+// grace period.
+func (s *TokenService) rememberRotated(ctx context.Context, oldRaw, userID, sessionID string) {
+	if s.rotationGrace <= 0 || sessionID == "" {
+		return
+	}
+	key := graceKey(s.refreshHash(oldRaw))
+	pipe := s.rdb.TxPipeline()
+	pipe.HSet(ctx, key, "user_id", userID, "sid", sessionID)
+	pipe.Expire(ctx, key, s.rotationGrace)
+	if _, err := pipe.Exec(ctx); err != nil {
+		// retries of this rotation fail as if there were no grace period
+		logger.FromContext(ctx).Warn("Failed to record rotated refresh token", zap.Error(err))
+	}
+}
+
+// validateGraceRetry resolves a token rotated within the grace period to the
+// current token of its session and validates that one instead.
+func (s *TokenService) validateGraceRetry(ctx context.Context, oldRaw string) (string, string, error) {
+	vals, err := s.rdb.HMGet(ctx, graceKey(s.refreshHash(oldRaw)), "user_id", "sid").Result()
+	if err != nil {
+		return "", "", autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	userID, _ := vals[0].(string)
+	sessionID, _ := vals[1].(string)
+	if userID == "" || sessionID == "" {
+		return "", "", autherr.ErrInvalidToken
+	}
+	// the session is gone once it was revoked or expired
+	h, err := s.rdb.HGet(ctx, userSessionsKey(userID), sessionID).Result()
+	if err == redis.Nil {
+		return "", "", autherr.ErrInvalidToken
+	}
+	if err != nil {
+		return "", "", autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	exists, err := s.ensureRefresh(ctx, h)
+	if err != nil {
+		return "", "", err
+	}
+	if !exists {
+		return "", "", autherr.ErrInvalidToken
+	}
+	current, err := s.checkRefresh(ctx, h)
+	if err != nil {
+		return "", "", err
+	}
+	if current != userID {
+		return "", "", autherr.ErrInvalidToken
+	}
+	logger.FromContext(ctx).Info("Refresh token retried within rotation grace period",
+		zap.String("user_id", userID),
+		zap.String("session_id", sessionID))
+	return userID, h, nil
+}
+
+func graceKey(hash string) string {
+	return "refresh:grace:" + hash
+}
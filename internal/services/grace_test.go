@@ -0,0 +1,64 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/andro-kes/auth_service/internal/autherr"
+)
+
+func TestRotateRefresh_Grace(t *testing.T) {
+	svc, srv := newTestTokenService(t, WithRotationGrace(30*time.Second))
+	ctx := t.Context()
+
+	_, old, _, _, err := svc.GenerateTokens(ctx, "alice")
+	if err != nil {
+		t.Fatalf("GenerateTokens failed: %v", err)
+	}
+	_, lost, _, _, err := svc.RotateRefresh(ctx, old, "alice")
+	if err != nil {
+		t.Fatalf("RotateRefresh failed: %v", err)
+	}
+
+	// the client never saw lost and retries with the old token
+	_, retried, _, _, err := svc.RotateRefresh(ctx, old, "alice")
+	if err != nil {
+		t.Fatalf("retry within grace period failed: %v", err)
+	}
+	if _, err := svc.ValidateRefresh(ctx, lost); err != autherr.ErrInvalidToken {
+		t.Fatalf("token replaced by the retry: %v, want ErrInvalidToken", err)
+	}
+	sessions, err := svc.ListSessions(ctx, "alice")
+	if err != nil {
+		t.Fatalf("ListSessions failed: %v", err)
+	}
+	if len(sessions) != 1 {
+		t.Fatalf("retry started a new session: %d sessions", len(sessions))
+	}
+	if _, err := svc.ValidateRefresh(ctx, old); err != autherr.ErrInvalidToken {
+		t.Fatalf("ValidateRefresh of rotated token: %v, want ErrInvalidToken", err)
+	}
+
+	// revoking the session ends the grace period
+	if err := svc.RevokeSession(ctx, "alice", sessions[0].ID); err != nil {
+		t.Fatalf("RevokeSession failed: %v", err)
+	}
+	if _, _, _, _, err := svc.RotateRefresh(ctx, old, "alice"); err != autherr.ErrInvalidToken {
+		t.Fatalf("retry after revocation: %v, want ErrInvalidToken", err)
+	}
+	if _, _, _, _, err := svc.RotateRefresh(ctx, retried, "alice"); err != autherr.ErrInvalidToken {
+		t.Fatalf("rotation after revocation: %v, want ErrInvalidToken", err)
+	}
+
+	_, old, _, _, err = svc.GenerateTokens(ctx, "alice")
+	if err != nil {
+		t.Fatalf("GenerateTokens failed: %v", err)
+	}
+	if _, _, _, _, err := svc.RotateRefresh(ctx, old, "alice"); err != nil {
+		t.Fatalf("RotateRefresh failed: %v", err)
+	}
+	srv.FastForward(time.Minute)
+	if _, _, _, _, err := svc.RotateRefresh(ctx, old, "alice"); err != autherr.ErrInvalidToken {
+		t.Fatalf("retry after grace period: %v, want ErrInvalidToken", err)
+	}
+}
@@ -0,0 +1,175 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"unicode/utf8"
+
+	"github.com/andro-kes/auth_service/internal/autherr"
+	"github.com/andro-kes/auth_service/internal/logger"
+	"github.com/andro-kes/auth_service/internal/models"
+	"github.com/andro-kes/auth_service/internal/repo"
+	"github.com/andro-kes/auth_service/internal/repo/db"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+)
+
+// Audit event types of identity links.
+const (
+	AuditIdentityLinked   = "identity.linked"
+	AuditIdentityUnlinked = "identity.unlinked"
+)
+
+const maxSubjectLen = 255
+
+// providerName matches identity provider names such as "google".
+var providerName = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]{0,31}$`)
+
+// IdentityService links users to accounts at external identity providers,
+// so that one account can be reached with a password and with federated
+// logins. A user has at most one identity per provider.
+type IdentityService struct {
+	Repo  repo.IdentityRepo
+	Users repo.UserRepo
+	Audit repo.AuditRepo
+	Tx    db.Tx
+}
+
+func NewIdentityService(ctx context.Context, pool *pgxpool.Pool) *IdentityService {
+	return &IdentityService{
+		Repo:  repo.NewIdentityRepo(ctx, pool),
+		Users: repo.NewUserRepo(ctx, pool),
+		Audit: repo.NewAuditRepo(ctx, pool),
+		Tx:    db.NewTx(pool),
+	}
+}
+
+// LinkIdentity links userID to subject at provider. email is the optional
+// email the provider reported. An identity can only be linked to one user.
+func (is *IdentityService) LinkIdentity(ctx context.Context, userID string, identity models.Identity, client ClientInfo) (*models.Identity, error) {
+	if userID == "" {
+		return nil, autherr.ErrBadRequest.WithMessage("user_id is required")
+	}
+	if err := checkIdentity(identity.Provider, identity.Subject); err != nil {
+		return nil, err
+	}
+	if identity.Email != "" {
+		var err error
+		if identity.Email, err = normalizeEmail(identity.Email); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := is.Users.FindByID(ctx, userID); err != nil {
+		if err == autherr.ErrNotFound {
+			return nil, autherr.ErrNotFound
+		}
+		logger.FromContext(ctx).Error("Failed to get user by id", zap.Error(err))
+		return nil, autherr.ErrStorageError.WithMessage(err.Error())
+	}
+
+	identity.UserID = userID
+	err := is.Tx.RunInTx(ctx, func(ctx context.Context, q db.Querier) error {
+		if err := is.Repo.Create(ctx, q, &identity); err != nil {
+			return err
+		}
+		return is.Audit.Insert(ctx, q, auditEvent(AuditIdentityLinked, userID, client, map[string]string{
+			"provider": identity.Provider,
+		}))
+	})
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+			if pgErr.ConstraintName == repo.IdentityUserProviderKey {
+				return nil, autherr.ErrConflict.WithMessage("user already has an identity at " + identity.Provider)
+			}
+			return nil, autherr.ErrConflict.WithMessage("identity is linked to another user")
+		}
+		logger.FromContext(ctx).Error("Failed to link identity", zap.Error(err))
+		return nil, autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	logger.FromContext(ctx).Info("Identity linked", zap.String("user_id", userID), zap.String("provider", identity.Provider))
+	return &identity, nil
+}
+
+// UnlinkIdentity removes the identity of userID at provider.
+func (is *IdentityService) UnlinkIdentity(ctx context.Context, userID, provider string, client ClientInfo) error {
+	return is.unlink(ctx, userID, provider, false, client)
+}
+
+// UnlinkOwnIdentity removes the identity of userID at provider like
+// UnlinkIdentity, unless it is the last way the user can log in: they
+// must have set a password or keep another identity.
+func (is *IdentityService) UnlinkOwnIdentity(ctx context.Context, userID, provider string, client ClientInfo) error {
+	return is.unlink(ctx, userID, provider, true, client)
+}
+
+func (is *IdentityService) unlink(ctx context.Context, userID, provider string, keepLogin bool, client ClientInfo) error {
+	if userID == "" || provider == "" {
+		return autherr.ErrBadRequest.WithMessage("user_id and provider are required")
+	}
+	err := is.Tx.RunInTx(ctx, func(ctx context.Context, q db.Querier) error {
+		if keepLogin {
+			passwordSet, identities, err := is.Repo.LoginMethods(ctx, q, userID)
+			if err != nil {
+				return err
+			}
+			if !passwordSet && identities <= 1 {
+				return autherr.ErrLastLoginMethod
+			}
+		}
+		if err := is.Repo.Delete(ctx, q, userID, provider); err != nil {
+			return err
+		}
+		return is.Audit.Insert(ctx, q, auditEvent(AuditIdentityUnlinked, userID, client, map[string]string{
+			"provider": provider,
+		}))
+	})
+	if err == autherr.ErrNotFound || err == autherr.ErrLastLoginMethod {
+		return err
+	}
+	if err != nil {
+		logger.FromContext(ctx).Error("Failed to unlink identity", zap.Error(err))
+		return autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	logger.FromContext(ctx).Info("Identity unlinked", zap.String("user_id", userID), zap.String("provider", provider))
+	return nil
+}
+
+// Identities returns the identities linked to userID.
+func (is *IdentityService) Identities(ctx context.Context, userID string) ([]models.Identity, error) {
+	identities, err := is.Repo.ListByUser(ctx, userID)
+	if err != nil {
+		logger.FromContext(ctx).Error("Failed to list identities", zap.Error(err))
+		return nil, autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	return identities, nil
+}
+
+// FindUser returns the user subject at provider is linked to, or
+// ErrNotFound.
+func (is *IdentityService) FindUser(ctx context.Context, provider, subject string) (*models.User, error) {
+	identity, err := is.Repo.FindBySubject(ctx, provider, subject)
+	if err == nil {
+		var user *models.User
+		if user, err = is.Users.FindByID(ctx, identity.UserID); err == nil {
+			return user, nil
+		}
+	}
+	if err == autherr.ErrNotFound {
+		return nil, autherr.ErrNotFound
+	}
+	logger.FromContext(ctx).Error("Failed to find user by identity", zap.Error(err))
+	return nil, autherr.ErrStorageError.WithMessage(err.Error())
+}
+
+func checkIdentity(provider, subject string) error {
+	if !providerName.MatchString(provider) {
+		return autherr.ErrBadRequest.WithMessage("provider must be 1-32 lower-case letters, digits, '_', '.' or '-'")
+	}
+	if subject == "" || utf8.RuneCountInString(subject) > maxSubjectLen {
+		return autherr.ErrBadRequest.WithMessage("subject must be 1-255 characters")
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package services
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/andro-kes/auth_service/internal/autherr"
+	"github.com/andro-kes/auth_service/internal/models"
+	"github.com/andro-kes/auth_service/internal/repo"
+	"github.com/andro-kes/auth_service/internal/repo/db"
+	"github.com/jackc/pgx/v5/pgconn"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type testIdentityRepo struct {
+	identities []models.Identity
+	// noPassword are the users who never set a password
+	noPassword map[string]bool
+}
+
+func (ti *testIdentityRepo) Create(ctx context.Context, q db.Querier, identity *models.Identity) error {
+	for _, i := range ti.identities {
+		if i.Provider == identity.Provider && i.Subject == identity.Subject {
+			return &pgconn.PgError{Code: uniqueViolation, ConstraintName: "identities_pkey"}
+		}
+		if i.Provider == identity.Provider && i.UserID == identity.UserID {
+			return &pgconn.PgError{Code: uniqueViolation, ConstraintName: repo.IdentityUserProviderKey}
+		}
+	}
+	ti.identities = append(ti.identities, *identity)
+	return nil
+}
+
+func (ti *testIdentityRepo) FindBySubject(ctx context.Context, provider, subject string) (*models.Identity, error) {
+	for _, i := range ti.identities {
+		if i.Provider == provider && i.Subject == subject {
+			return &i, nil
+		}
+	}
+	return nil, autherr.ErrNotFound
+}
+
+func (ti *testIdentityRepo) ListByUser(ctx context.Context, userID string) ([]models.Identity, error) {
+	var out []models.Identity
+	for _, i := range ti.identities {
+		if i.UserID == userID {
+			out = append(out, i)
+		}
+	}
+	return out, nil
+}
+
+func (ti *testIdentityRepo) Delete(ctx context.Context, q db.Querier, userID, provider string) error {
+	n := len(ti.identities)
+	ti.identities = slices.DeleteFunc(ti.identities, func(i models.Identity) bool {
+		return i.UserID == userID && i.Provider == provider
+	})
+	if len(ti.identities) == n {
+		return autherr.ErrNotFound
+	}
+	return nil
+}
+
+func (ti *testIdentityRepo) DeleteByUser(ctx context.Context, q db.Querier, userID string) error {
+	ti.identities = slices.DeleteFunc(ti.identities, func(i models.Identity) bool { return i.UserID == userID })
+	return nil
+}
+
+func (ti *testIdentityRepo) LoginMethods(ctx context.Context, q db.Querier, userID string) (bool, int, error) {
+	identities, _ := ti.ListByUser(ctx, userID)
+	return !ti.noPassword[userID], len(identities), nil
+}
+
+func TestIdentityLinking(t *testing.T) {
+	ctx := context.Background()
+	audit := &testAuditRepo{}
+	is := &IdentityService{Repo: &testIdentityRepo{}, Users: &testUserRepo{}, Audit: audit, Tx: &fakeTx{}}
+
+	if _, err := is.LinkIdentity(ctx, "u1", models.Identity{Provider: "Google!", Subject: "123"}, ClientInfo{}); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected InvalidArgument for a bad provider, got %v", err)
+	}
+	identity, err := is.LinkIdentity(ctx, "u1", models.Identity{Provider: "google", Subject: "123", Email: "Alice@Example.com"}, ClientInfo{})
+	if err != nil {
+		t.Fatalf("LinkIdentity failed: %v", err)
+	}
+	if identity.UserID != "u1" || identity.Email != "alice@example.com" {
+		t.Fatalf("unexpected identity: %+v", identity)
+	}
+	if _, err := is.LinkIdentity(ctx, "u2", models.Identity{Provider: "google", Subject: "123"}, ClientInfo{}); status.Code(err) != codes.AlreadyExists {
+		t.Fatalf("expected AlreadyExists for an identity of another user, got %v", err)
+	}
+	if _, err := is.LinkIdentity(ctx, "u1", models.Identity{Provider: "google", Subject: "456"}, ClientInfo{}); status.Code(err) != codes.AlreadyExists {
+		t.Fatalf("expected AlreadyExists for a second identity at the provider, got %v", err)
+	}
+
+	user, err := is.FindUser(ctx, "google", "123")
+	if err != nil || user.ID != "u1" {
+		t.Fatalf("FindUser returned %v, %v", user, err)
+	}
+
+	if err := is.UnlinkIdentity(ctx, "u1", "google", ClientInfo{}); err != nil {
+		t.Fatalf("UnlinkIdentity failed: %v", err)
+	}
+	if err := is.UnlinkIdentity(ctx, "u1", "google", ClientInfo{}); err != autherr.ErrNotFound {
+		t.Fatalf("expected ErrNotFound for a missing identity, got %v", err)
+	}
+	if _, err := is.FindUser(ctx, "google", "123"); err != autherr.ErrNotFound {
+		t.Fatalf("expected ErrNotFound after unlinking, got %v", err)
+	}
+	if !slices.Equal(audit.events, []string{AuditIdentityLinked, AuditIdentityUnlinked}) {
+		t.Fatalf("unexpected audit events: %v", audit.events)
+	}
+}
+
+func TestUnlinkOwnIdentity(t *testing.T) {
+	ctx := context.Background()
+	identities := &testIdentityRepo{
+		identities: []models.Identity{
+			{UserID: "u1", Provider: "google", Subject: "1"},
+			{UserID: "u1", Provider: "github", Subject: "2"},
+			{UserID: "u2", Provider: "google", Subject: "3"},
+		},
+		noPassword: map[string]bool{"u1": true},
+	}
+	is := &IdentityService{Repo: identities, Users: &testUserRepo{}, Audit: &testAuditRepo{}, Tx: &fakeTx{}}
+
+	// another identity is left
+	if err := is.UnlinkOwnIdentity(ctx, "u1", "google", ClientInfo{}); err != nil {
+		t.Fatalf("UnlinkOwnIdentity failed: %v", err)
+	}
+	if err := is.UnlinkOwnIdentity(ctx, "u1", "github", ClientInfo{}); err != autherr.ErrLastLoginMethod {
+		t.Fatalf("expected ErrLastLoginMethod for the last identity, got %v", err)
+	}
+	if status.Code(autherr.ErrLastLoginMethod) != codes.FailedPrecondition {
+		t.Fatalf("unexpected code %v", status.Code(autherr.ErrLastLoginMethod))
+	}
+	// the password is left
+	if err := is.UnlinkOwnIdentity(ctx, "u2", "google", ClientInfo{}); err != nil {
+		t.Fatalf("UnlinkOwnIdentity with a password failed: %v", err)
+	}
+	// admins may unlink anything
+	if err := is.UnlinkIdentity(ctx, "u1", "github", ClientInfo{}); err != nil {
+		t.Fatalf("UnlinkIdentity failed: %v", err)
+	}
+	if len(identities.identities) != 0 {
+		t.Fatalf("unexpected identities left: %+v", identities.identities)
+	}
+}
@@ -0,0 +1,85 @@
+package services
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/andro-kes/auth_service/internal/autherr"
+	"github.com/andro-kes/auth_service/internal/logger"
+	"github.com/andro-kes/auth_service/internal/models"
+	"github.com/andro-kes/auth_service/internal/repo/db"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultInviteTTL = 7 * 24 * time.Hour
+	maxInviteTTL     = 90 * 24 * time.Hour
+	maxInviteUses    = 10000
+)
+
+// CreateInvite creates an invite code admitting maxUses registrations
+// (1 when zero) until it expires after ttl (7 days when zero). The code is
+// returned once; only its hash is stored.
+func (us *UserService) CreateInvite(ctx context.Context, maxUses int, ttl time.Duration) (string, *models.Invite, error) {
+	if maxUses == 0 {
+		maxUses = 1
+	}
+	if maxUses < 0 || maxUses > maxInviteUses {
+		return "", nil, autherr.ErrBadRequest.WithMessage("max_uses must be between 1 and 10000")
+	}
+	if ttl == 0 {
+		ttl = defaultInviteTTL
+	}
+	if ttl < 0 || ttl > maxInviteTTL {
+		return "", nil, autherr.ErrBadRequest.WithMessage("ttl must be at most 90 days")
+	}
+
+	code, err := randomBase64(us.crypto().Rand(), 16)
+	if err != nil {
+		return "", nil, autherr.ErrTokenGeneration.WithMessage(err.Error())
+	}
+	invite := &models.Invite{
+		ID:        uuid.New().String(),
+		CodeHash:  sha256Hex(code),
+		MaxUses:   maxUses,
+		ExpiresAt: time.Now().UTC().Add(ttl),
+	}
+	err = us.Tx.RunInTx(ctx, func(ctx context.Context, q db.Querier) error {
+		return us.Invites.Create(ctx, q, invite)
+	})
+	if err != nil {
+		logger.FromContext(ctx).Error("Failed to create invite", zap.Error(err))
+		return "", nil, autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	return code, invite, nil
+}
+
+// checkInvite rejects registrations without an invite code when one is
+// required, before the password is hashed.
+func (us *UserService) checkInvite(code string) error {
+	if us.RequireInvite && strings.TrimSpace(code) == "" {
+		return inputError("invite_code", "required", "invite code is required")
+	}
+	return nil
+}
+
+// useInvite counts the registration against the invite with code inside the
+// registration's transaction, so that a failed registration does not use it
+// up. It returns the invite's ID, or "" when no code was given.
+func (us *UserService) useInvite(ctx context.Context, q db.Querier, code string) (string, error) {
+	code = strings.TrimSpace(code)
+	if code == "" {
+		return "", nil
+	}
+	id, err := us.Invites.Use(ctx, q, sha256Hex(code))
+	if err != nil {
+		if err == autherr.ErrNotFound {
+			return "", inputError("invite_code", "invalid", "invite code is invalid, expired or used up")
+		}
+		logger.FromContext(ctx).Error("Failed to use invite", zap.Error(err))
+		return "", autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	return id, nil
+}
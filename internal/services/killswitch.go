@@ -0,0 +1,138 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/andro-kes/auth_service/internal/autherr"
+	"github.com/andro-kes/auth_service/internal/logger"
+	"go.uber.org/zap"
+)
+
+// watermarkKey is a Redis hash of not-before watermarks: the globalWatermark
+// field applies to everyone, other fields are user IDs.
+const (
+	watermarkKey    = "auth:nbf"
+	globalWatermark = "*"
+)
+
+// watermarks is the in-memory copy of the not-before watermarks, so access
+// token validation can enforce them without a Redis round trip.
+type watermarks struct {
+	mu     sync.RWMutex
+	global time.Time
+	users  map[string]time.Time
+}
+
+func (w *watermarks) set(userID string, nbf time.Time) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if userID == "" {
+		if nbf.After(w.global) {
+			w.global = nbf
+		}
+		return
+	}
+	if w.users == nil {
+		w.users = make(map[string]time.Time)
+	}
+	if nbf.After(w.users[userID]) {
+		w.users[userID] = nbf
+	}
+}
+
+// revoked reports whether a token of userID issued at iat predates a watermark.
+func (w *watermarks) revoked(userID string, iat time.Time) bool {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if iat.Before(w.global) {
+		return true
+	}
+	return iat.Before(w.users[userID])
+}
+
+// ForceExpire invalidates every access and refresh token issued before
+// notBefore, for userID or for all users when userID is empty. It returns the
+// effective watermark, rounded up to whole seconds like the iat claim.
+// notBefore may lie in the future by no more than the leeway: a later
+// watermark would also reject the tokens issued until then, locking users
+// out.
+func (s *TokenService) ForceExpire(ctx context.Context, notBefore time.Time, userID string) (time.Time, error) {
+	if notBefore.After(s.now().Add(s.leeway)) {
+		return time.Time{}, autherr.ErrBadRequest.WithMessage("not_before must not be in the future")
+	}
+	nbf := time.Unix(int64(math.Ceil(float64(notBefore.UnixNano())/float64(time.Second))), 0).UTC()
+
+	field := userID
+	if field == "" {
+		field = globalWatermark
+	}
+	if err := s.rdb.HSet(ctx, watermarkKey, field, nbf.Unix()).Err(); err != nil {
+		return time.Time{}, autherr.ErrStorageError.WithMessage(err.Error())
+	}
+
+	s.applyWatermark(userID, nbf)
+
+	payload, err := json.Marshal(revocationMessage{UserID: userID, NotBefore: nbf.Unix()})
+	if err != nil {
+		return time.Time{}, autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	if err := s.rdb.Publish(ctx, revocationChannel, payload).Err(); err != nil {
+		// the watermark is persisted; other instances pick it up on resubscribe
+		logger.FromContext(ctx).Warn("Failed to publish watermark", zap.Error(err))
+	}
+
+	logger.FromContext(ctx).Warn("Tokens force-expired",
+		zap.String("user_id", userID),
+		zap.Time("not_before", nbf))
+	return nbf, nil
+}
+
+func (s *TokenService) applyWatermark(userID string, nbf time.Time) {
+	s.watermarks.set(userID, nbf)
+	if s.cache != nil {
+		s.cache.Purge()
+	}
+}
+
+// longestTokenLifetime is how long any token a watermark applies to can stay
+// valid. Service tokens outlive every user token.
+func (s *TokenService) longestTokenLifetime() time.Duration {
+	return max(s.accessTTL, s.longestRefreshTTL(), maxServiceTokenTTL)
+}
+
+// loadWatermarks replaces missed pub/sub updates with the persisted state.
+// Watermarks older than the longest token lifetime no longer matter and are dropped.
+func (s *TokenService) loadWatermarks(ctx context.Context) error {
+	all, err := s.rdb.HGetAll(ctx, watermarkKey).Result()
+	if err != nil {
+		return autherr.ErrStorageError.WithMessage(err.Error())
+	}
+
+	horizon := s.now().Add(-s.longestTokenLifetime())
+	var stale []string
+	for field, v := range all {
+		sec, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			continue
+		}
+		nbf := time.Unix(sec, 0).UTC()
+		if nbf.Before(horizon) {
+			stale = append(stale, field)
+			continue
+		}
+		if field == globalWatermark {
+			s.applyWatermark("", nbf)
+		} else {
+			s.applyWatermark(field, nbf)
+		}
+	}
+	if len(stale) > 0 {
+		_ = s.rdb.HDel(ctx, watermarkKey, stale...).Err()
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/andro-kes/auth_service/internal/autherr"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestForceExpire(t *testing.T) {
+	secret := "012345678901234567890123456789ab"
+	svc, _ := newTestTokenService(t)
+	rdb := svc.rdb
+
+	ctx := t.Context()
+
+	aliceAccess, aliceRefresh, _, _, err := svc.GenerateTokens(ctx, "alice")
+	if err != nil {
+		t.Fatalf("GenerateTokens failed: %v", err)
+	}
+	bobAccess, _, _, _, err := svc.GenerateTokens(ctx, "bob")
+	if err != nil {
+		t.Fatalf("GenerateTokens failed: %v", err)
+	}
+
+	if _, err := svc.ForceExpire(ctx, time.Now().Add(time.Hour), "alice"); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected a future watermark to be refused, got %v", err)
+	}
+	if _, err := svc.ValidateAccess(aliceAccess); err != nil {
+		t.Fatalf("expected a refused watermark not to apply, got %v", err)
+	}
+	if _, err := svc.ForceExpire(ctx, time.Now(), "alice"); err != nil {
+		t.Fatalf("ForceExpire failed: %v", err)
+	}
+
+	if _, err := svc.ValidateAccess(aliceAccess); err != autherr.ErrInvalidToken {
+		t.Fatalf("expected alice's access token to be rejected, got %v", err)
+	}
+	if _, err := svc.ValidateRefresh(ctx, aliceRefresh); err != autherr.ErrInvalidToken {
+		t.Fatalf("expected alice's refresh token to be rejected, got %v", err)
+	}
+	if _, err := svc.ValidateAccess(bobAccess); err != nil {
+		t.Fatalf("expected bob's access token to stay valid, got %v", err)
+	}
+
+	// a fresh instance picks the persisted watermark up from Redis
+	other, err := NewTokenService(rdb, secret, time.Minute, time.Minute*5)
+	if err != nil {
+		t.Fatalf("failed to create TokenService: %v", err)
+	}
+	if _, err := other.ForceExpire(ctx, time.Now(), ""); err != nil {
+		t.Fatalf("global ForceExpire failed: %v", err)
+	}
+	restarted, err := NewTokenService(rdb, secret, time.Minute, time.Minute*5)
+	if err != nil {
+		t.Fatalf("failed to create TokenService: %v", err)
+	}
+	if _, err := restarted.ValidateAccess(bobAccess); err != autherr.ErrInvalidToken {
+		t.Fatalf("expected global watermark to reject bob's token, got %v", err)
+	}
+}
+
+func TestForceExpire_ServiceTokens(t *testing.T) {
+	now := time.Now()
+	clock := &fixedClock{t: now.Add(-32 * 24 * time.Hour)}
+	tokens, srv := newTestTokenService(t, WithClock(clock))
+	repo := &testServiceAccountRepo{}
+	ss := &ServiceAccountService{Repo: repo, Tx: &fakeTx{}, Tokens: tokens}
+
+	ctx := t.Context()
+	accountID, err := ss.CreateAccount(ctx, "billing", []string{"invoices:read"})
+	if err != nil {
+		t.Fatalf("CreateAccount failed: %v", err)
+	}
+	token, _, _, err := ss.MintToken(ctx, accountID, "", 0)
+	if err != nil {
+		t.Fatalf("MintToken failed: %v", err)
+	}
+	clock.t = now.Add(-31 * 24 * time.Hour)
+	if _, err := tokens.ForceExpire(ctx, clock.t, accountID); err != nil {
+		t.Fatalf("ForceExpire failed: %v", err)
+	}
+
+	// a restart a month later reloads the watermark instead of dropping it
+	clock.t = now
+	restarted, err := NewTokenService(tokens.rdb, "012345678901234567890123456789ab", time.Minute, time.Minute*5, WithClock(clock))
+	if err != nil {
+		t.Fatalf("failed to create TokenService: %v", err)
+	}
+	if srv.HGet(watermarkKey, accountID) == "" {
+		t.Fatal("expected the watermark to be kept")
+	}
+	ss = &ServiceAccountService{Repo: repo, Tx: &fakeTx{}, Tokens: restarted}
+	if in, err := ss.Introspect(ctx, token); err != nil || in.Active {
+		t.Fatalf("expected the force-expired service token to stay inactive, got %+v, %v", in, err)
+	}
+}
@@ -0,0 +1,164 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/andro-kes/auth_service/internal/autherr"
+	"github.com/andro-kes/auth_service/internal/logger"
+	"github.com/andro-kes/auth_service/internal/models"
+	"github.com/andro-kes/auth_service/internal/repo"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultUserPageSize = 50
+	maxUserPageSize     = 500
+
+	defaultSearchLimit = 10
+	maxSearchLimit     = 50
+	// minFuzzyQueryLen is the shortest text with a trigram to compare.
+	minFuzzyQueryLen = 3
+)
+
+// ListUsersParams selects a page of ListUsers.
+type ListUsersParams struct {
+	PageSize  int
+	PageToken string
+
+	UsernamePrefix string
+	Status         string
+	CreatedAfter   time.Time
+
+	OrderBy    string // repo.UserOrderCreatedAt or repo.UserOrderUsername
+	Descending bool
+}
+
+// pageToken is the decoded page token of ListUsers: the order of the listing
+// and the position of its last user in it.
+type pageToken struct {
+	OrderBy    string    `json:"o"`
+	Descending bool      `json:"d,omitempty"`
+	ID         string    `json:"id"`
+	Username   string    `json:"u,omitempty"`
+	CreatedAt  time.Time `json:"c,omitzero"`
+}
+
+// ListUsers returns a page of users and the token of the next page, empty
+// on the last one. A page token only continues a listing in the same order.
+func (us *UserService) ListUsers(ctx context.Context, params ListUsersParams) ([]*models.User, string, error) {
+	query := repo.UserQuery{
+		UsernamePrefix: params.UsernamePrefix,
+		Status:         params.Status,
+		CreatedAfter:   params.CreatedAfter,
+		OrderBy:        params.OrderBy,
+		Descending:     params.Descending,
+		Limit:          params.PageSize,
+	}
+	switch query.OrderBy {
+	case "":
+		query.OrderBy = repo.UserOrderCreatedAt
+	case repo.UserOrderCreatedAt, repo.UserOrderUsername:
+	default:
+		return nil, "", autherr.ErrBadRequest.WithMessage("unknown order " + query.OrderBy)
+	}
+	if query.Status != "" && !validStatus(query.Status) {
+		return nil, "", autherr.ErrBadRequest.WithMessage("unknown account status")
+	}
+	switch {
+	case query.Limit < 0:
+		return nil, "", autherr.ErrBadRequest.WithMessage("page_size must not be negative")
+	case query.Limit == 0:
+		query.Limit = defaultUserPageSize
+	case query.Limit > maxUserPageSize:
+		query.Limit = maxUserPageSize
+	}
+
+	if params.PageToken != "" {
+		tok, err := decodePageToken(params.PageToken)
+		if err != nil || tok.OrderBy != query.OrderBy || tok.Descending != query.Descending {
+			return nil, "", autherr.ErrBadRequest.WithMessage("invalid page_token")
+		}
+		query.After = &models.User{ID: tok.ID, Username: tok.Username, CreatedAt: tok.CreatedAt}
+	}
+
+	// one more user tells whether there is a next page
+	query.Limit++
+	users, err := us.Repo.List(ctx, query)
+	if err != nil {
+		logger.FromContext(ctx).Error("Failed to list users", zap.Error(err))
+		return nil, "", autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	if len(users) < query.Limit {
+		return users, "", nil
+	}
+	users = users[:query.Limit-1]
+	last := users[len(users)-1]
+	next, err := encodePageToken(pageToken{
+		OrderBy:    query.OrderBy,
+		Descending: query.Descending,
+		ID:         last.ID,
+		Username:   last.Username,
+		CreatedAt:  last.CreatedAt,
+	})
+	if err != nil {
+		return nil, "", autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	return users, next, nil
+}
+
+// SearchUsers returns up to limit users whose username or email starts with
+// text or, if fuzzy, resembles it, best matches first.
+func (us *UserService) SearchUsers(ctx context.Context, text string, fuzzy bool, limit int) ([]*models.User, error) {
+	text = strings.TrimSpace(text)
+	switch {
+	case text == "":
+		return nil, autherr.ErrBadRequest.WithMessage("query is required")
+	case fuzzy && utf8.RuneCountInString(text) < minFuzzyQueryLen:
+		return nil, autherr.ErrBadRequest.WithMessage("fuzzy query must be at least 3 characters long")
+	case len(text) > maxLoginBytes || hasControl(text):
+		return nil, autherr.ErrBadRequest.WithMessage("invalid query")
+	}
+	switch {
+	case limit < 0:
+		return nil, autherr.ErrBadRequest.WithMessage("limit must not be negative")
+	case limit == 0:
+		limit = defaultSearchLimit
+	case limit > maxSearchLimit:
+		limit = maxSearchLimit
+	}
+
+	users, err := us.Repo.Search(ctx, text, fuzzy, limit)
+	if err != nil {
+		logger.FromContext(ctx).Error("Failed to search users", zap.Error(err))
+		return nil, autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	return users, nil
+}
+
+func encodePageToken(tok pageToken) (string, error) {
+	b, err := json.Marshal(tok)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func decodePageToken(s string) (pageToken, error) {
+	var tok pageToken
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return tok, err
+	}
+	if err := json.Unmarshal(b, &tok); err != nil {
+		return tok, err
+	}
+	if tok.ID == "" {
+		return tok, autherr.ErrBadRequest
+	}
+	return tok, nil
+}
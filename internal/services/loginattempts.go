@@ -0,0 +1,111 @@
+package services
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/andro-kes/auth_service/internal/autherr"
+	"github.com/andro-kes/auth_service/internal/logger"
+	"github.com/andro-kes/auth_service/internal/models"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Reasons of failed login attempts.
+const (
+	LoginFailureUnknownUser     = "unknown_user"
+	LoginFailureInvalidPassword = "invalid_password"
+	LoginFailureAccountDisabled = "account_disabled"
+	LoginFailureAccountLocked   = "account_locked"
+	LoginFailureInvalidInput    = "invalid_input"
+	LoginFailureHoneypot        = "honeypot"
+	LoginFailureInvalidMFA      = "invalid_mfa_code"
+	LoginFailureRiskDenied      = "risk_denied"
+)
+
+// LoginFailureReason classifies an error of Login for RecordFailedLogin.
+// It returns "" for errors that say nothing about the attempt, such as
+// storage failures.
+func LoginFailureReason(err error) string {
+	switch {
+	case err == autherr.ErrNotFound:
+		return LoginFailureUnknownUser
+	case err == autherr.ErrLoginUser:
+		return LoginFailureInvalidPassword
+	case err == autherr.ErrInvalidMFA:
+		return LoginFailureInvalidMFA
+	case err == autherr.ErrAccountLocked:
+		return LoginFailureAccountLocked
+	}
+	switch status.Code(err) {
+	case codes.PermissionDenied:
+		return LoginFailureAccountDisabled
+	case codes.InvalidArgument:
+		return LoginFailureInvalidInput
+	}
+	return ""
+}
+
+// LoginUserID returns the ID of the user login (a username or email) names,
+// so that failed logins can be counted per account whichever of the two is
+// used. It returns "" when no user matches; lookup failures are logged and
+// treated alike.
+func (us *UserService) LoginUserID(ctx context.Context, login string) string {
+	if checkLoginInput(login, "") != nil {
+		return ""
+	}
+	if !strings.Contains(login, "@") {
+		login = normalizeUsername(login)
+	}
+	user, err := us.findByLogin(ctx, login)
+	if err != nil {
+		if err != autherr.ErrNotFound {
+			logger.FromContext(ctx).Warn("Failed to get user by login", zap.Error(err))
+		}
+		return ""
+	}
+	return user.ID
+}
+
+// RecordFailedLogin stores a failed login in the background, like
+// RecordLogin; it does nothing without Attempts.
+func (us *UserService) RecordFailedLogin(ctx context.Context, login, ip, reason string) {
+	if us.Attempts == nil || reason == "" {
+		return
+	}
+	if len(login) > maxLoginBytes {
+		// invalid input; keep what identifies it
+		login = login[:maxLoginBytes]
+	}
+	attempt := &models.LoginAttempt{Username: login, IP: ip, Reason: reason, CreatedAt: time.Now().UTC()}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), recordLoginTimeout)
+	go func() {
+		defer cancel()
+		if err := us.Attempts.Insert(ctx, attempt); err != nil {
+			logger.FromContext(ctx).Warn("Failed to record login attempt", zap.Error(err))
+		}
+	}()
+}
+
+// PurgeLoginAttempts removes failed logins older than retention every
+// interval until ctx is done.
+func (us *UserService) PurgeLoginAttempts(ctx context.Context, every, retention time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := us.Attempts.Purge(ctx, time.Now().Add(-retention))
+			if err != nil && ctx.Err() == nil {
+				logger.FromContext(ctx).Warn("Failed to purge login attempts", zap.Error(err))
+			}
+			if n > 0 {
+				logger.FromContext(ctx).Info("Login attempts purged", zap.Int64("count", n))
+			}
+		}
+	}
+}
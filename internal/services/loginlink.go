@@ -0,0 +1,124 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/andro-kes/auth_service/internal/autherr"
+	"github.com/andro-kes/auth_service/internal/logger"
+	"github.com/andro-kes/auth_service/internal/mail"
+	"github.com/andro-kes/auth_service/internal/models"
+	"go.uber.org/zap"
+)
+
+// PurposeLoginLink is the purpose of login link tokens.
+const PurposeLoginLink = "login_link"
+
+// sendLinkTimeout bounds the background delivery of a login link.
+const sendLinkTimeout = 30 * time.Second
+
+// LoginLinkService implements passwordless login: a one-time token mailed to
+// the user's email address stands in for the password.
+type LoginLinkService struct {
+	Users  *UserService
+	Tokens *TokenService
+	Mail   mail.Sender
+	// URL is the page the mailed link opens, with the token in the "token"
+	// query parameter. When empty the bare token is mailed.
+	URL string
+	// TTL is how long a link is valid; zero means 15 minutes.
+	TTL time.Duration
+}
+
+// RequestLoginLink mails a login link to the user with login (username or
+// email). Unknown logins, users without an email and inactive accounts are
+// skipped silently, and the mail is sent in the background, so that neither
+// the result nor the timing tells whether the account exists.
+func (ls *LoginLinkService) RequestLoginLink(ctx context.Context, login string) error {
+	if login == "" {
+		return autherr.ErrBadRequest.WithMessage("login is required")
+	}
+	if err := checkLoginInput(login, ""); err != nil {
+		return err
+	}
+	if !strings.Contains(login, "@") {
+		login = normalizeUsername(login)
+	}
+	user, err := ls.Users.findByLogin(ctx, login)
+	if err != nil {
+		if err == autherr.ErrNotFound {
+			return nil
+		}
+		logger.FromContext(ctx).Error("Failed to get user by login", zap.Error(err))
+		return autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	if user.Email == "" || statusError(user.Status) != nil {
+		return nil
+	}
+
+	ttl := ls.TTL
+	if ttl == 0 {
+		ttl = defaultPurposeTokenTTL
+	}
+	token, _, err := ls.Tokens.IssuePurposeToken(ctx, PurposeLoginLink, user.ID, ttl)
+	if err != nil {
+		return err
+	}
+	msg := mail.Message{
+		To:      user.Email,
+		Subject: "Your login link",
+		Body: fmt.Sprintf("Use this link to log in:\n\n%s\n\nIt expires in %d minutes and works once. "+
+			"If you did not request it, ignore this message.\n", ls.link(token), int(ttl.Minutes())),
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), sendLinkTimeout)
+	go func() {
+		defer cancel()
+		if err := ls.Mail.Send(ctx, msg); err != nil {
+			logger.FromContext(ctx).Error("Failed to send login link", zap.String("user_id", user.ID), zap.Error(err))
+		}
+	}()
+	return nil
+}
+
+// CompleteLoginLink redeems a login link token and returns its user. A token
+// works once; the account must still be active.
+func (ls *LoginLinkService) CompleteLoginLink(ctx context.Context, token string) (*models.User, error) {
+	userID, err := ls.Tokens.ConsumePurposeToken(ctx, PurposeLoginLink, token)
+	if err != nil {
+		return nil, err
+	}
+	user, err := ls.Users.GetUser(ctx, userID)
+	if err != nil {
+		if err == autherr.ErrNotFound {
+			return nil, autherr.ErrInvalidToken
+		}
+		return nil, err
+	}
+	if err := statusError(user.Status); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (ls *LoginLinkService) link(token string) string {
+	return mailedLink(ls.URL, token)
+}
+
+// mailedLink returns the link to base with token in its "token" query
+// parameter, or token itself when there is no usable base.
+func mailedLink(base, token string) string {
+	if base == "" {
+		return token
+	}
+	u, err := url.Parse(base)
+	if err != nil {
+		return token
+	}
+	q := u.Query()
+	q.Set("token", token)
+	u.RawQuery = q.Encode()
+	return u.String()
+}
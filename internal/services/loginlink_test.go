@@ -0,0 +1,83 @@
+package services
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andro-kes/auth_service/internal/autherr"
+	"github.com/andro-kes/auth_service/internal/mail"
+	"github.com/andro-kes/auth_service/internal/models"
+)
+
+// chanMailer hands messages sent in the background to the test.
+type chanMailer chan mail.Message
+
+func (m chanMailer) Send(ctx context.Context, msg mail.Message) error {
+	m <- msg
+	return nil
+}
+
+func TestLoginLink(t *testing.T) {
+	tokens, _ := newTestTokenService(t)
+	users := &testUserRepo{emails: []string{"alice@example.com"}}
+	mailer := make(chanMailer, 1)
+	ls := &LoginLinkService{
+		Users:  &UserService{Repo: users},
+		Tokens: tokens,
+		Mail:   mailer,
+		URL:    "https://app.example.com/login?lang=en",
+	}
+	ctx := t.Context()
+
+	if err := ls.RequestLoginLink(ctx, "Alice@Example.com"); err != nil {
+		t.Fatalf("RequestLoginLink failed: %v", err)
+	}
+	var msg mail.Message
+	select {
+	case msg = <-mailer:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected a login link to be mailed")
+	}
+	if msg.To != "alice@example.com" {
+		t.Fatalf("expected the link sent to the user's email, got %q", msg.To)
+	}
+	var link string
+	for _, line := range strings.Split(msg.Body, "\n") {
+		if strings.HasPrefix(line, "https://") {
+			link = line
+		}
+	}
+	u, err := url.Parse(link)
+	if err != nil || u.Query().Get("lang") != "en" || u.Query().Get("token") == "" {
+		t.Fatalf("unexpected link %q in %q", link, msg.Body)
+	}
+	token := u.Query().Get("token")
+
+	user, err := ls.CompleteLoginLink(ctx, token)
+	if err != nil {
+		t.Fatalf("CompleteLoginLink failed: %v", err)
+	}
+	if user.ID == "" {
+		t.Fatalf("unexpected user %+v", user)
+	}
+	if _, err := ls.CompleteLoginLink(ctx, token); err != autherr.ErrInvalidToken {
+		t.Fatalf("expected a used link to be rejected, got %v", err)
+	}
+
+	// unknown logins look the same to the caller but nothing is mailed
+	if err := ls.RequestLoginLink(ctx, "bob@example.com"); err != nil {
+		t.Fatalf("expected no error for an unknown login, got %v", err)
+	}
+	users.status = models.UserStatusDisabled
+	if err := ls.RequestLoginLink(ctx, "alice@example.com"); err != nil {
+		t.Fatalf("expected no error for a disabled user, got %v", err)
+	}
+	select {
+	case msg := <-mailer:
+		t.Fatalf("expected nothing mailed, got %+v", msg)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,82 @@
+package services
+
+import (
+	"context"
+
+	"github.com/andro-kes/auth_service/internal/autherr"
+	"github.com/andro-kes/auth_service/internal/logger"
+	"github.com/andro-kes/auth_service/internal/repo"
+	"github.com/andro-kes/auth_service/internal/repo/db"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+)
+
+// AuditLogout is recorded when a user logs out.
+const AuditLogout = "user.logout"
+
+// LogoutService ends the session a user is logged in with.
+type LogoutService struct {
+	Tokens *TokenService
+	Audit  repo.AuditRepo
+	DB     db.Querier
+}
+
+func NewLogoutService(ctx context.Context, pool *pgxpool.Pool, tokens *TokenService) *LogoutService {
+	return &LogoutService{
+		Tokens: tokens,
+		Audit:  repo.NewAuditRepo(ctx, pool),
+		DB:     pool,
+	}
+}
+
+// Logout revokes refreshToken and puts accessToken, whose validated claims
+// are caller, on the denylist. A refresh token of another user is refused;
+// unknown and expired ones are ignored like in Revoke. The tokens are gone
+// once Logout returns, so failing to audit it is only logged.
+func (ls *LogoutService) Logout(ctx context.Context, caller *Claims, accessToken, refreshToken string, client ClientInfo) error {
+	if refreshToken == "" {
+		return autherr.ErrBadRequest.WithMessage("refresh token is required")
+	}
+	owner, err := ls.Tokens.refreshOwner(ctx, refreshToken)
+	if err != nil {
+		return err
+	}
+	if owner != "" && owner != caller.UserID {
+		return autherr.ErrForbidden.WithMessage("refresh token belongs to another user")
+	}
+	if err := ls.Tokens.RevokeRefreshByRaw(ctx, refreshToken); err != nil {
+		return err
+	}
+	if err := ls.Tokens.RevokeAccess(ctx, accessToken); err != nil {
+		return err
+	}
+
+	var details map[string]string
+	if caller.SessionID != "" {
+		details = map[string]string{"session_id": caller.SessionID}
+	}
+	if err := ls.Audit.Insert(ctx, ls.DB, auditEvent(AuditLogout, caller.UserID, client, details)); err != nil {
+		logger.FromContext(ctx).Error("Failed to audit logout", zap.String("user_id", caller.UserID), zap.Error(err))
+	}
+	return nil
+}
+
+// refreshOwner returns the user of the refresh token raw, or "" when the
+// token is unknown or a honeytoken, which revoking it then reports.
+func (s *TokenService) refreshOwner(ctx context.Context, raw string) (userID string, err error) {
+	defer func() { err = s.degrade(err) }()
+
+	h, exists, err := s.resolveRefresh(ctx, raw)
+	if err != nil || !exists {
+		return "", err
+	}
+	vals, err := s.rdb.HMGet(ctx, redisKey(h), "user_id", "canary").Result()
+	if err != nil {
+		return "", autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	if label, _ := vals[1].(string); label != "" {
+		return "", nil
+	}
+	userID, _ = vals[0].(string)
+	return userID, nil
+}
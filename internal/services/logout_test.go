@@ -0,0 +1,59 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/andro-kes/auth_service/internal/autherr"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestLogout(t *testing.T) {
+	svc, _ := newTestTokenService(t)
+	audit := &testAuditRepo{}
+	ls := &LogoutService{Tokens: svc, Audit: audit}
+
+	ctx := t.Context()
+	access, refresh, _, _, err := svc.GenerateTokens(ctx, "alice")
+	if err != nil {
+		t.Fatalf("GenerateTokens failed: %v", err)
+	}
+	_, otherRefresh, _, _, err := svc.GenerateTokens(ctx, "bob")
+	if err != nil {
+		t.Fatalf("GenerateTokens failed: %v", err)
+	}
+	caller, err := svc.ValidateAccess(access)
+	if err != nil {
+		t.Fatalf("ValidateAccess failed: %v", err)
+	}
+
+	if err := ls.Logout(ctx, caller, access, otherRefresh, ClientInfo{}); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("expected another user's refresh token to be refused, got %v", err)
+	}
+	if _, err := svc.ValidateAccess(access); err != nil {
+		t.Fatalf("expected refused logout to keep the access token, got %v", err)
+	}
+	if err := ls.Logout(ctx, caller, access, "", ClientInfo{}); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected missing refresh token to be rejected, got %v", err)
+	}
+
+	if err := ls.Logout(ctx, caller, access, refresh, ClientInfo{IP: "10.0.0.1"}); err != nil {
+		t.Fatalf("Logout failed: %v", err)
+	}
+	if _, err := svc.ValidateAccess(access); err != autherr.ErrInvalidToken {
+		t.Fatalf("expected access token to be denylisted, got %v", err)
+	}
+	if _, _, _, _, err := svc.RotateRefresh(ctx, refresh, "alice"); err == nil {
+		t.Fatalf("expected refresh token to be revoked")
+	}
+	if _, _, _, _, err := svc.RotateRefresh(ctx, otherRefresh, "bob"); err != nil {
+		t.Fatalf("expected other user's session to survive, got %v", err)
+	}
+	if len(audit.stored) != 1 {
+		t.Fatalf("expected one audit event, got %v", audit.events)
+	}
+	ev := audit.stored[0]
+	if ev.Type != AuditLogout || ev.UserID != "alice" || ev.IP != "10.0.0.1" || ev.Details["session_id"] != caller.SessionID {
+		t.Fatalf("unexpected audit event %+v", ev)
+	}
+}
@@ -0,0 +1,47 @@
+package services
+
+import (
+	"context"
+
+	"github.com/andro-kes/auth_service/internal/autherr"
+	"github.com/andro-kes/auth_service/internal/repo"
+)
+
+// WithMetadataClaims copies the listed keys of the user's profile metadata
+// into every access token as the "meta" claim, so that downstream services
+// can read e.g. a plan or tier without asking for the profile. Like roles,
+// the values are read when tokens are issued or rotated.
+func WithMetadataClaims(users repo.UserRepo, keys []string) Option {
+	return func(s *TokenService) {
+		if len(keys) > 0 {
+			s.metaUsers = users
+			s.metaKeys = keys
+		}
+	}
+}
+
+// stampMetadata sets the "meta" claim when metadata claims are configured.
+// Subjects without a user record get none.
+func (s *TokenService) stampMetadata(ctx context.Context, claims *tokenClaims) error {
+	if s.metaUsers == nil {
+		return nil
+	}
+	user, err := s.metaUsers.FindByID(ctx, claims.UserID)
+	if err == autherr.ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	for _, key := range s.metaKeys {
+		v, ok := user.Metadata[key]
+		if !ok {
+			continue
+		}
+		if claims.Metadata == nil {
+			claims.Metadata = make(map[string]any, len(s.metaKeys))
+		}
+		claims.Metadata[key] = v
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package services
+
+import (
+	"maps"
+	"testing"
+
+	"github.com/andro-kes/auth_service/internal/autherr"
+	"github.com/andro-kes/auth_service/internal/models"
+)
+
+func TestMetadataClaims(t *testing.T) {
+	users := &testUserRepo{profiles: map[string]models.Profile{
+		"u1": {Metadata: map[string]any{"plan": "pro", "seats": float64(5), "secret": "x"}},
+	}}
+	svc, _ := newTestTokenService(t, WithMetadataClaims(users, []string{"plan", "seats", "tier"}))
+
+	ctx := t.Context()
+	access, _, _, _, err := svc.GenerateTokens(ctx, "u1")
+	if err != nil {
+		t.Fatalf("GenerateTokens failed: %v", err)
+	}
+	claims, err := svc.ValidateAccess(access)
+	if err != nil {
+		t.Fatalf("ValidateAccess failed: %v", err)
+	}
+	want := map[string]any{"plan": "pro", "seats": float64(5)}
+	if !maps.Equal(claims.Metadata, want) {
+		t.Fatalf("expected metadata %v, got %v", want, claims.Metadata)
+	}
+	in, err := svc.Introspect(ctx, access)
+	if err != nil || !maps.Equal(in.Metadata, want) {
+		t.Fatalf("expected introspected metadata %v, got %v, %v", want, in.Metadata, err)
+	}
+
+	// subjects without a user record get no claim
+	users.notFoundError = autherr.ErrNotFound
+	access, _, _, _, err = svc.GenerateTokens(ctx, "svc-1")
+	if err != nil {
+		t.Fatalf("GenerateTokens failed: %v", err)
+	}
+	if claims, err := svc.ValidateAccess(access); err != nil || claims.Metadata != nil {
+		t.Fatalf("expected no metadata claim, got %v, %v", claims, err)
+	}
+}
@@ -0,0 +1,43 @@
+package services
+
+import (
+	"errors"
+
+	"github.com/andro-kes/auth_service/internal/autherr"
+	"github.com/andro-kes/auth_service/internal/metrics"
+)
+
+// observeValidation counts a rejected access token by reason.
+func observeValidation(err error) {
+	if err != nil {
+		metrics.ValidationFailures.WithLabelValues(failureReason(err)).Inc()
+	}
+}
+
+func failureReason(err error) string {
+	switch {
+	case errors.Is(err, autherr.ErrTokenExpired):
+		return "expired"
+	case errors.Is(err, autherr.ErrInvalidToken):
+		return "invalid"
+	case errors.Is(err, autherr.ErrTokenReplayed):
+		return "replayed"
+	case errors.Is(err, autherr.ErrInvalidDPoPProof):
+		return "dpop"
+	}
+	return "error"
+}
+
+// observeRotation counts a refresh token rotation by result.
+func observeRotation(err error) {
+	result := "ok"
+	switch {
+	case errors.Is(err, autherr.ErrInvalidToken) || errors.Is(err, autherr.ErrTokenExpired):
+		result = "invalid"
+	case errors.Is(err, autherr.ErrRotationInProgress):
+		result = "in_progress"
+	case err != nil:
+		result = "error"
+	}
+	metrics.RefreshRotations.WithLabelValues(result).Inc()
+}
@@ -0,0 +1,114 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/andro-kes/auth_service/internal/autherr"
+	"github.com/andro-kes/auth_service/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestTokenMetrics(t *testing.T) {
+	svc, _ := newTestTokenService(t)
+	ctx := t.Context()
+
+	counters := map[string]prometheus.Counter{
+		"issued":  metrics.TokensIssued.WithLabelValues("refresh"),
+		"rotated": metrics.RefreshRotations.WithLabelValues("ok"),
+		"reused":  metrics.RefreshRotations.WithLabelValues("invalid"),
+		"revoked": metrics.Revocations.WithLabelValues("refresh"),
+		"garbage": metrics.ValidationFailures.WithLabelValues("invalid"),
+		"expired": metrics.ValidationFailures.WithLabelValues("expired"),
+	}
+	before := make(map[string]float64, len(counters))
+	for name, c := range counters {
+		before[name] = testutil.ToFloat64(c)
+	}
+
+	access, refresh, _, _, err := svc.GenerateTokens(ctx, "alice")
+	if err != nil {
+		t.Fatalf("GenerateTokens failed: %v", err)
+	}
+	_, rotated, _, _, err := svc.RotateRefresh(ctx, refresh, "alice")
+	if err != nil {
+		t.Fatalf("RotateRefresh failed: %v", err)
+	}
+	if _, _, _, _, err := svc.RotateRefresh(ctx, refresh, "alice"); err == nil {
+		t.Fatal("expected reuse of a rotated token to fail")
+	}
+	if err := svc.RevokeRefreshByRaw(ctx, rotated); err != nil {
+		t.Fatalf("RevokeRefreshByRaw failed: %v", err)
+	}
+	// revoking again finds nothing to revoke
+	if err := svc.RevokeRefreshByRaw(ctx, rotated); err != nil {
+		t.Fatalf("RevokeRefreshByRaw failed: %v", err)
+	}
+	if _, err := svc.ValidateAccess(access); err != nil {
+		t.Fatalf("ValidateAccess failed: %v", err)
+	}
+	if _, err := svc.ValidateAccess("garbage"); err != autherr.ErrInvalidToken {
+		t.Fatalf("ValidateAccess of garbage: %v", err)
+	}
+
+	for name, want := range map[string]float64{
+		"issued":  2,
+		"rotated": 1,
+		"reused":  1,
+		"revoked": 1,
+		"garbage": 1,
+		"expired": 0,
+	} {
+		if got := testutil.ToFloat64(counters[name]) - before[name]; got != want {
+			t.Errorf("%s: counter moved by %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestValidationFailureReasons(t *testing.T) {
+	clock := &fixedClock{t: time.Now().Add(-10 * time.Second)}
+	users := &testUserRepo{}
+	svc, _ := newTestTokenService(t, WithClock(clock), WithPasswordChanges(users))
+	ctx := t.Context()
+
+	counters := map[string]prometheus.Counter{
+		"invalid": metrics.ValidationFailures.WithLabelValues("invalid"),
+		"dpop":    metrics.ValidationFailures.WithLabelValues("dpop"),
+	}
+	before := make(map[string]float64, len(counters))
+	for name, c := range counters {
+		before[name] = testutil.ToFloat64(c)
+	}
+
+	access, _, _, _, err := svc.GenerateTokens(ctx, "alice")
+	if err != nil {
+		t.Fatalf("GenerateTokens failed: %v", err)
+	}
+	if err := users.ChangePassword(ctx, nil, "alice", "new-hash"); err != nil {
+		t.Fatalf("ChangePassword failed: %v", err)
+	}
+	if err := svc.PasswordChanged(ctx, "alice"); err != nil {
+		t.Fatalf("PasswordChanged failed: %v", err)
+	}
+	// rejected with a copy of ErrInvalidToken carrying its own message
+	if _, err := svc.ValidateAccess(access); !errors.Is(err, autherr.ErrInvalidToken) {
+		t.Fatalf("expected ErrInvalidToken for a token issued before the change, got %v", err)
+	}
+
+	clock.t = time.Now().Add(time.Second)
+	bound, _, _, _, err := svc.GenerateTokens(ctx, "alice", WithDPoPKey("key-a"))
+	if err != nil {
+		t.Fatalf("GenerateTokens failed: %v", err)
+	}
+	if _, err := svc.ValidateAccessForCall(ctx, bound, "", "/auth.AuthService/Validate"); !errors.Is(err, autherr.ErrInvalidDPoPProof) {
+		t.Fatalf("expected ErrInvalidDPoPProof without a proof, got %v", err)
+	}
+
+	for name, want := range map[string]float64{"invalid": 1, "dpop": 1} {
+		if got := testutil.ToFloat64(counters[name]) - before[name]; got != want {
+			t.Errorf("%s: counter moved by %v, want %v", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"time"
+
+	"github.com/andro-kes/auth_service/internal/autherr"
+	"github.com/andro-kes/auth_service/internal/logger"
+	"github.com/andro-kes/auth_service/internal/models"
+	"github.com/andro-kes/auth_service/internal/repo"
+	"github.com/andro-kes/auth_service/internal/repo/db"
+	"github.com/andro-kes/auth_service/internal/sms"
+	"github.com/andro-kes/auth_service/internal/totp"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+)
+
+// Audit event types of two-factor authentication.
+const (
+	AuditMFAEnrolled     = "mfa.enrolled"
+	AuditMFAEnabled      = "mfa.enabled"
+	AuditMFAVerifyFailed = "mfa.verify_failed"
+)
+
+const (
+	defaultMFAIssuer = "auth_service"
+	// totpSkew accepts codes one step before and after the current one.
+	totpSkew = 1
+)
+
+// MFAService manages second factors. A user enrolls with EnrollTOTP and
+// confirms the authenticator app with a first VerifyTOTP, or verifies a
+// phone for codes by SMS; from then on Login asks for a code before issuing
+// tokens.
+type MFAService struct {
+	Repo  repo.MFARepo
+	Users repo.UserRepo
+	Audit repo.AuditRepo
+	Tx    db.Tx
+	// Phones and SMS enable codes by SMS; Phones may be nil.
+	Phones repo.PhoneRepo
+	SMS    sms.Sender
+	// Issuer names the service in authenticator apps.
+	Issuer string
+	Clock  Clock
+}
+
+func NewMFAService(ctx context.Context, pool *pgxpool.Pool, issuer string, sender sms.Sender) *MFAService {
+	return &MFAService{
+		Repo:   repo.NewMFARepo(ctx, pool),
+		Users:  repo.NewUserRepo(ctx, pool),
+		Audit:  repo.NewAuditRepo(ctx, pool),
+		Tx:     db.NewTx(pool),
+		Phones: repo.NewPhoneRepo(ctx, pool),
+		SMS:    sender,
+		Issuer: issuer,
+	}
+}
+
+// TOTPEnrollment is what a user needs to set up an authenticator app.
+type TOTPEnrollment struct {
+	Secret string
+	// URI is the otpauth:// URI to show as a QR code.
+	URI string
+	// RecoveryCodes are single-use codes that replace a TOTP code when the
+	// authenticator app is lost.
+	RecoveryCodes []string
+}
+
+// EnrollTOTP starts a TOTP enrollment for userID with a new secret and new
+// recovery codes. Enrolling again before the first code is verified replaces
+// both; once MFA is enabled it fails with ErrConflict.
+func (ms *MFAService) EnrollTOTP(ctx context.Context, userID string, client ClientInfo) (*TOTPEnrollment, error) {
+	user, err := ms.Users.FindByID(ctx, userID)
+	if err != nil {
+		if err == autherr.ErrNotFound {
+			return nil, autherr.ErrNotFound
+		}
+		logger.FromContext(ctx).Error("Failed to get user by id", zap.Error(err))
+		return nil, autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	secret, err := totp.NewSecret(rand.Reader)
+	if err != nil {
+		return nil, autherr.ErrTokenGeneration.WithMessage(err.Error())
+	}
+	codes, hashes, err := newRecoveryCodes(rand.Reader)
+	if err != nil {
+		return nil, autherr.ErrTokenGeneration.WithMessage(err.Error())
+	}
+
+	err = ms.Tx.RunInTx(ctx, func(ctx context.Context, q db.Querier) error {
+		if err := ms.Repo.SetTOTP(ctx, q, userID, secret); err != nil {
+			return err
+		}
+		if err := ms.Repo.SetRecoveryCodes(ctx, q, userID, hashes); err != nil {
+			return err
+		}
+		return ms.Audit.Insert(ctx, q, auditEvent(AuditMFAEnrolled, userID, client, nil))
+	})
+	if err != nil {
+		if err == autherr.ErrConflict {
+			return nil, autherr.ErrConflict.WithMessage("mfa is already enabled")
+		}
+		logger.FromContext(ctx).Error("Failed to enroll totp", zap.Error(err))
+		return nil, autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	return &TOTPEnrollment{
+		Secret:        secret,
+		URI:           totp.URI(ms.issuer(), user.Username, secret),
+		RecoveryCodes: codes,
+	}, nil
+}
+
+// VerifyTOTP checks a code from the user's authenticator app. The first valid
+// code confirms the enrollment and enables MFA. Every code is accepted once:
+// a code of the same or an earlier step than the last accepted one fails
+// with ErrInvalidMFA.
+func (ms *MFAService) VerifyTOTP(ctx context.Context, userID, code string, client ClientInfo) error {
+	mfa, err := ms.find(ctx, userID)
+	if err != nil {
+		return err
+	}
+	step, ok := totp.Validate(mfa.TOTPSecret, code, ms.now(), totpSkew)
+	if ok {
+		if ok, err = ms.Repo.UseStep(ctx, userID, step); err != nil {
+			logger.FromContext(ctx).Error("Failed to record totp step", zap.Error(err))
+			return autherr.ErrStorageError.WithMessage(err.Error())
+		}
+	}
+	if !ok {
+		err := ms.Tx.RunInTx(ctx, func(ctx context.Context, q db.Querier) error {
+			return ms.Audit.Insert(ctx, q, auditEvent(AuditMFAVerifyFailed, userID, client, nil))
+		})
+		if err != nil {
+			logger.FromContext(ctx).Warn("Failed to audit mfa verification", zap.Error(err))
+		}
+		return autherr.ErrInvalidMFA
+	}
+	if !mfa.EnabledAt.IsZero() {
+		return nil
+	}
+
+	err = ms.Tx.RunInTx(ctx, func(ctx context.Context, q db.Querier) error {
+		if err := ms.Repo.Enable(ctx, q, userID); err != nil {
+			return err
+		}
+		return ms.Audit.Insert(ctx, q, auditEvent(AuditMFAEnabled, userID, client, nil))
+	})
+	if err != nil && err != autherr.ErrNotFound {
+		// ErrNotFound: a concurrent verification enabled it first
+		logger.FromContext(ctx).Error("Failed to enable mfa", zap.Error(err))
+		return autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	return nil
+}
+
+// Enabled reports whether userID has confirmed a second factor: TOTP or a
+// phone receiving codes by SMS.
+func (ms *MFAService) Enabled(ctx context.Context, userID string) (bool, error) {
+	mfa, err := ms.Repo.Find(ctx, userID)
+	if err != nil && err != autherr.ErrNotFound {
+		logger.FromContext(ctx).Error("Failed to get mfa", zap.Error(err))
+		return false, autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	if err == nil && !mfa.EnabledAt.IsZero() {
+		return true, nil
+	}
+	phone, err := ms.mfaPhone(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	return phone != nil, nil
+}
+
+func (ms *MFAService) find(ctx context.Context, userID string) (*models.MFA, error) {
+	mfa, err := ms.Repo.Find(ctx, userID)
+	if err != nil {
+		if err == autherr.ErrNotFound {
+			return nil, autherr.ErrNotFound.WithMessage("totp is not enrolled")
+		}
+		logger.FromContext(ctx).Error("Failed to get mfa", zap.Error(err))
+		return nil, autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	return mfa, nil
+}
+
+func (ms *MFAService) issuer() string {
+	if ms.Issuer == "" {
+		return defaultMFAIssuer
+	}
+	return ms.Issuer
+}
+
+func (ms *MFAService) now() time.Time {
+	if ms.Clock == nil {
+		return time.Now()
+	}
+	return ms.Clock.Now()
+}
@@ -0,0 +1,232 @@
+package services
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andro-kes/auth_service/internal/autherr"
+	"github.com/andro-kes/auth_service/internal/models"
+	"github.com/andro-kes/auth_service/internal/repo/db"
+	"github.com/andro-kes/auth_service/internal/totp"
+)
+
+type testMFARepo struct {
+	rows map[string]*models.MFA
+	// codes maps user IDs to recovery code hashes and whether they were used.
+	codes map[string]map[string]bool
+}
+
+func (tm *testMFARepo) SetTOTP(ctx context.Context, q db.Querier, userID, secret string) error {
+	if tm.rows == nil {
+		tm.rows = map[string]*models.MFA{}
+	}
+	if m := tm.rows[userID]; m != nil && !m.EnabledAt.IsZero() {
+		return autherr.ErrConflict
+	}
+	tm.rows[userID] = &models.MFA{UserID: userID, TOTPSecret: secret, CreatedAt: time.Now()}
+	return nil
+}
+
+func (tm *testMFARepo) Find(ctx context.Context, userID string) (*models.MFA, error) {
+	m := tm.rows[userID]
+	if m == nil {
+		return nil, autherr.ErrNotFound
+	}
+	cp := *m
+	return &cp, nil
+}
+
+func (tm *testMFARepo) Enable(ctx context.Context, q db.Querier, userID string) error {
+	m := tm.rows[userID]
+	if m == nil || !m.EnabledAt.IsZero() {
+		return autherr.ErrNotFound
+	}
+	m.EnabledAt = time.Now()
+	return nil
+}
+
+func (tm *testMFARepo) UseStep(ctx context.Context, userID string, step int64) (bool, error) {
+	m := tm.rows[userID]
+	if m == nil || m.LastStep >= step {
+		return false, nil
+	}
+	m.LastStep = step
+	return true, nil
+}
+
+func (tm *testMFARepo) SetRecoveryCodes(ctx context.Context, q db.Querier, userID string, hashes []string) error {
+	if tm.codes == nil {
+		tm.codes = map[string]map[string]bool{}
+	}
+	tm.codes[userID] = map[string]bool{}
+	for _, h := range hashes {
+		tm.codes[userID][h] = false
+	}
+	return nil
+}
+
+func (tm *testMFARepo) UseRecoveryCode(ctx context.Context, q db.Querier, userID, hash string) (bool, error) {
+	used, ok := tm.codes[userID][hash]
+	if !ok || used {
+		return false, nil
+	}
+	tm.codes[userID][hash] = true
+	return true, nil
+}
+
+func (tm *testMFARepo) Delete(ctx context.Context, q db.Querier, userID string) error {
+	delete(tm.rows, userID)
+	delete(tm.codes, userID)
+	return nil
+}
+
+func TestTOTPEnrollment(t *testing.T) {
+	ctx := context.Background()
+	clock := &fixedClock{t: time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)}
+	audit := &testAuditRepo{}
+	ms := &MFAService{Repo: &testMFARepo{}, Users: &testUserRepo{}, Audit: audit, Tx: &fakeTx{}, Clock: clock}
+
+	if _, err := ms.EnrollTOTP(ctx, "u1", ClientInfo{}); err != nil {
+		t.Fatalf("EnrollTOTP failed: %v", err)
+	}
+	// enrolling again before verification replaces the secret
+	enrollment, err := ms.EnrollTOTP(ctx, "u1", ClientInfo{})
+	if err != nil {
+		t.Fatalf("EnrollTOTP failed: %v", err)
+	}
+	secret := enrollment.Secret
+	if !strings.HasPrefix(enrollment.URI, "otpauth://totp/auth_service:") || !strings.Contains(enrollment.URI, "secret="+secret) {
+		t.Fatalf("unexpected uri %q", enrollment.URI)
+	}
+	if enabled, _ := ms.Enabled(ctx, "u1"); enabled {
+		t.Fatal("expected mfa to stay disabled until a code is verified")
+	}
+
+	if err := ms.VerifyTOTP(ctx, "u1", "000000", ClientInfo{}); err != autherr.ErrInvalidMFA {
+		t.Fatalf("expected ErrInvalidMFA for a wrong code, got %v", err)
+	}
+	code, _ := totp.Code(secret, totp.Counter(clock.t))
+	if err := ms.VerifyTOTP(ctx, "u1", code, ClientInfo{}); err != nil {
+		t.Fatalf("VerifyTOTP failed: %v", err)
+	}
+	if enabled, _ := ms.Enabled(ctx, "u1"); !enabled {
+		t.Fatal("expected mfa to be enabled")
+	}
+	if err := ms.VerifyTOTP(ctx, "u1", code, ClientInfo{}); err != autherr.ErrInvalidMFA {
+		t.Fatalf("expected a replayed code to be rejected, got %v", err)
+	}
+	clock.t = clock.t.Add(totp.Step)
+	code, _ = totp.Code(secret, totp.Counter(clock.t))
+	if err := ms.VerifyTOTP(ctx, "u1", code, ClientInfo{}); err != nil {
+		t.Fatalf("expected the next code to be accepted, got %v", err)
+	}
+	if _, err := ms.EnrollTOTP(ctx, "u1", ClientInfo{}); err == nil {
+		t.Fatal("expected enrolling again to fail once mfa is enabled")
+	}
+
+	want := []string{AuditMFAEnrolled, AuditMFAEnrolled, AuditMFAVerifyFailed, AuditMFAEnabled, AuditMFAVerifyFailed}
+	if !slices.Equal(audit.events, want) {
+		t.Fatalf("unexpected audit events: %v", audit.events)
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	ctx := context.Background()
+	clock := &fixedClock{t: time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)}
+	audit := &testAuditRepo{}
+	ms := &MFAService{Repo: &testMFARepo{}, Users: &testUserRepo{}, Audit: audit, Tx: &fakeTx{}, Clock: clock}
+
+	enrollment, err := ms.EnrollTOTP(ctx, "u1", ClientInfo{})
+	if err != nil {
+		t.Fatalf("EnrollTOTP failed: %v", err)
+	}
+	if len(enrollment.RecoveryCodes) != recoveryCodeCount {
+		t.Fatalf("expected %d recovery codes, got %v", recoveryCodeCount, enrollment.RecoveryCodes)
+	}
+	code := enrollment.RecoveryCodes[0]
+	if err := ms.UseRecoveryCode(ctx, "u1", code, ClientInfo{}); err == nil {
+		t.Fatal("expected recovery codes to be rejected before mfa is enabled")
+	}
+	totpCode, _ := totp.Code(enrollment.Secret, totp.Counter(clock.t))
+	if err := ms.VerifyTOTP(ctx, "u1", totpCode, ClientInfo{}); err != nil {
+		t.Fatalf("VerifyTOTP failed: %v", err)
+	}
+
+	if err := ms.UseRecoveryCode(ctx, "u1", " "+strings.ToUpper(code)+" ", ClientInfo{}); err != nil {
+		t.Fatalf("UseRecoveryCode failed: %v", err)
+	}
+	if err := ms.UseRecoveryCode(ctx, "u1", code, ClientInfo{}); err != autherr.ErrInvalidMFA {
+		t.Fatalf("expected a used recovery code to be rejected, got %v", err)
+	}
+
+	codes, err := ms.RegenerateRecoveryCodes(ctx, "u1", ClientInfo{})
+	if err != nil {
+		t.Fatalf("RegenerateRecoveryCodes failed: %v", err)
+	}
+	if err := ms.UseRecoveryCode(ctx, "u1", enrollment.RecoveryCodes[1], ClientInfo{}); err != autherr.ErrInvalidMFA {
+		t.Fatalf("expected old recovery codes to be replaced, got %v", err)
+	}
+	if err := ms.UseRecoveryCode(ctx, "u1", codes[1], ClientInfo{}); err != nil {
+		t.Fatalf("UseRecoveryCode failed for a new code: %v", err)
+	}
+	if _, err := ms.RegenerateRecoveryCodes(ctx, "u2", ClientInfo{}); err == nil {
+		t.Fatal("expected RegenerateRecoveryCodes to fail without mfa")
+	}
+
+	want := []string{AuditMFAEnrolled, AuditMFAEnabled, AuditMFARecoveryCodeUsed, AuditMFAVerifyFailed,
+		AuditMFARecoveryCodesRegenerated, AuditMFAVerifyFailed, AuditMFARecoveryCodeUsed}
+	if !slices.Equal(audit.events, want) {
+		t.Fatalf("unexpected audit events: %v", audit.events)
+	}
+}
+
+func TestMFAChallenge(t *testing.T) {
+	svc, srv := newTestTokenService(t)
+	ctx := t.Context()
+
+	token, _, err := svc.IssueMFAChallenge(ctx, MFAChallenge{UserID: "u1", Username: "alice", RememberMe: true, ClientID: "web", Enroll: true})
+	if err != nil {
+		t.Fatalf("IssueMFAChallenge failed: %v", err)
+	}
+	challenge, err := svc.MFAChallenge(ctx, token)
+	if err != nil {
+		t.Fatalf("MFAChallenge failed: %v", err)
+	}
+	if *challenge != (MFAChallenge{UserID: "u1", Username: "alice", RememberMe: true, ClientID: "web", Enroll: true}) {
+		t.Fatalf("unexpected challenge: %+v", challenge)
+	}
+	if err := svc.RedeemMFAChallenge(ctx, token); err != nil {
+		t.Fatalf("RedeemMFAChallenge failed: %v", err)
+	}
+	if err := svc.RedeemMFAChallenge(ctx, token); err != autherr.ErrTokenReplayed {
+		t.Fatalf("expected ErrTokenReplayed, got %v", err)
+	}
+	if _, err := svc.MFAChallenge(ctx, token); err == nil {
+		t.Fatal("expected a redeemed challenge to be gone")
+	}
+
+	token, _, err = svc.IssueMFAChallenge(ctx, MFAChallenge{UserID: "u1"})
+	if err != nil {
+		t.Fatalf("IssueMFAChallenge failed: %v", err)
+	}
+	for range mfaChallengeMaxAttempts {
+		if err := svc.FailMFAChallenge(ctx, token); err != nil {
+			t.Fatalf("FailMFAChallenge failed: %v", err)
+		}
+	}
+	if _, err := svc.MFAChallenge(ctx, token); err == nil {
+		t.Fatal("expected the challenge to be dropped after too many wrong codes")
+	}
+
+	token, _, err = svc.IssueMFAChallenge(ctx, MFAChallenge{UserID: "u1"})
+	if err != nil {
+		t.Fatalf("IssueMFAChallenge failed: %v", err)
+	}
+	srv.FastForward(mfaChallengeTTL)
+	if _, err := svc.MFAChallenge(ctx, token); err == nil {
+		t.Fatal("expected the challenge to expire")
+	}
+}
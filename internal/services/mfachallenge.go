@@ -0,0 +1,119 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/andro-kes/auth_service/internal/autherr"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// mfaChallengeTTL is how long a user has to enter the second factor
+	// after the password was accepted.
+	mfaChallengeTTL = 5 * time.Minute
+	// mfaChallengeMaxAttempts wrong codes drop the challenge, so that the
+	// password has to be entered again.
+	mfaChallengeMaxAttempts = 5
+)
+
+// MFAChallenge is a login waiting for its second factor.
+type MFAChallenge struct {
+	UserID     string
+	Username   string
+	RememberMe bool
+	ClientID   string
+	// Enroll is set when a role requires MFA the user has not set up yet:
+	// the challenge then also authenticates EnrollTOTP.
+	Enroll bool
+}
+
+// failChallengeScript counts a wrong code and drops the challenge after
+// ARGV[1] of them. It returns 0 when the challenge no longer exists.
+var failChallengeScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 then
+  return 0
+end
+local n = redis.call("HINCRBY", KEYS[1], "attempts", 1)
+if n >= tonumber(ARGV[1]) then
+  redis.call("DEL", KEYS[1])
+end
+return 1
+`)
+
+// IssueMFAChallenge stores a login whose password was accepted and returns
+// the opaque token that completes it together with a second factor. Only
+// the token hash is stored.
+func (s *TokenService) IssueMFAChallenge(ctx context.Context, challenge MFAChallenge) (string, time.Time, error) {
+	token, err := randomBase64(s.crypto.Rand(), 32)
+	if err != nil {
+		return "", time.Time{}, autherr.ErrTokenGeneration.WithMessage(err.Error())
+	}
+	key := mfaChallengeKey(token)
+	_, err = s.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, key, "uid", challenge.UserID, "un", challenge.Username,
+			"rm", flag(challenge.RememberMe), "cid", challenge.ClientID, "en", flag(challenge.Enroll))
+		pipe.Expire(ctx, key, mfaChallengeTTL)
+		return nil
+	})
+	if err != nil {
+		return "", time.Time{}, autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	return token, s.now().Add(mfaChallengeTTL), nil
+}
+
+// MFAChallenge returns the pending login of token.
+func (s *TokenService) MFAChallenge(ctx context.Context, token string) (*MFAChallenge, error) {
+	if token == "" {
+		return nil, autherr.ErrNoToken
+	}
+	fields, err := s.rdb.HGetAll(ctx, mfaChallengeKey(token)).Result()
+	if err != nil {
+		return nil, autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	if fields["uid"] == "" {
+		return nil, autherr.ErrInvalidToken.WithMessage("mfa challenge expired or unknown")
+	}
+	return &MFAChallenge{
+		UserID:     fields["uid"],
+		Username:   fields["un"],
+		RememberMe: fields["rm"] == "1",
+		ClientID:   fields["cid"],
+		Enroll:     fields["en"] == "1",
+	}, nil
+}
+
+// FailMFAChallenge counts a wrong second factor for token.
+func (s *TokenService) FailMFAChallenge(ctx context.Context, token string) error {
+	err := failChallengeScript.Run(ctx, s.rdb, []string{mfaChallengeKey(token)}, mfaChallengeMaxAttempts).Err()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	return nil
+}
+
+// RedeemMFAChallenge consumes token after its second factor was accepted.
+// Of concurrent redemptions only one succeeds, the others get
+// ErrTokenReplayed.
+func (s *TokenService) RedeemMFAChallenge(ctx context.Context, token string) error {
+	n, err := s.rdb.Del(ctx, mfaChallengeKey(token)).Result()
+	if err != nil {
+		return autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	if n == 0 {
+		return autherr.ErrTokenReplayed
+	}
+	return nil
+}
+
+func flag(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
+
+func mfaChallengeKey(token string) string {
+	return "mfa:challenge:" + sha256Hex(token)
+}
@@ -0,0 +1,114 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"io"
+	"strings"
+
+	"github.com/andro-kes/auth_service/internal/autherr"
+	"github.com/andro-kes/auth_service/internal/logger"
+	"github.com/andro-kes/auth_service/internal/repo/db"
+	"go.uber.org/zap"
+)
+
+// Audit event types of MFA recovery codes.
+const (
+	AuditMFARecoveryCodeUsed         = "mfa.recovery_code_used"
+	AuditMFARecoveryCodesRegenerated = "mfa.recovery_codes_regenerated"
+)
+
+// recoveryCodeCount is how many recovery codes a user gets at a time.
+const recoveryCodeCount = 10
+
+var recoveryCodeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// RegenerateRecoveryCodes replaces the recovery codes of userID, e.g. after
+// most of them were used or when they may have leaked. The codes are
+// returned once; only their hashes are stored.
+func (ms *MFAService) RegenerateRecoveryCodes(ctx context.Context, userID string, client ClientInfo) ([]string, error) {
+	if err := ms.requireEnabled(ctx, userID); err != nil {
+		return nil, err
+	}
+	codes, hashes, err := newRecoveryCodes(rand.Reader)
+	if err != nil {
+		return nil, autherr.ErrTokenGeneration.WithMessage(err.Error())
+	}
+	err = ms.Tx.RunInTx(ctx, func(ctx context.Context, q db.Querier) error {
+		if err := ms.Repo.SetRecoveryCodes(ctx, q, userID, hashes); err != nil {
+			return err
+		}
+		return ms.Audit.Insert(ctx, q, auditEvent(AuditMFARecoveryCodesRegenerated, userID, client, nil))
+	})
+	if err != nil {
+		logger.FromContext(ctx).Error("Failed to regenerate recovery codes", zap.Error(err))
+		return nil, autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	return codes, nil
+}
+
+// UseRecoveryCode accepts one of the user's recovery codes instead of a TOTP
+// code, e.g. when the authenticator app was lost. Each code works once;
+// unknown and used codes fail with ErrInvalidMFA.
+func (ms *MFAService) UseRecoveryCode(ctx context.Context, userID, code string, client ClientInfo) error {
+	if err := ms.requireEnabled(ctx, userID); err != nil {
+		return err
+	}
+	hash := sha256Hex(normalizeRecoveryCode(code))
+	var used bool
+	err := ms.Tx.RunInTx(ctx, func(ctx context.Context, q db.Querier) error {
+		var err error
+		if used, err = ms.Repo.UseRecoveryCode(ctx, q, userID, hash); err != nil {
+			return err
+		}
+		event := AuditMFARecoveryCodeUsed
+		if !used {
+			event = AuditMFAVerifyFailed
+		}
+		return ms.Audit.Insert(ctx, q, auditEvent(event, userID, client, nil))
+	})
+	if err != nil {
+		logger.FromContext(ctx).Error("Failed to use recovery code", zap.Error(err))
+		return autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	if !used {
+		return autherr.ErrInvalidMFA
+	}
+	return nil
+}
+
+// requireEnabled fails unless userID has confirmed a second factor.
+func (ms *MFAService) requireEnabled(ctx context.Context, userID string) error {
+	enabled, err := ms.Enabled(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if !enabled {
+		return autherr.ErrNotFound.WithMessage("mfa is not enabled")
+	}
+	return nil
+}
+
+// newRecoveryCodes returns recoveryCodeCount codes formatted as
+// "xxxx-xxxx" and the hashes to store.
+func newRecoveryCodes(r io.Reader) ([]string, []string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	b := make([]byte, 5)
+	for i := range codes {
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(recoveryCodeEncoding.EncodeToString(b))
+		codes[i] = code[:4] + "-" + code[4:]
+		hashes[i] = sha256Hex(code)
+	}
+	return codes, hashes, nil
+}
+
+// normalizeRecoveryCode accepts codes typed in any case, with or without
+// the dash.
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
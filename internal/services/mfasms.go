@@ -0,0 +1,259 @@
+package services
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/andro-kes/auth_service/internal/autherr"
+	"github.com/andro-kes/auth_service/internal/logger"
+	"github.com/andro-kes/auth_service/internal/models"
+	"github.com/andro-kes/auth_service/internal/repo/db"
+	"github.com/andro-kes/auth_service/internal/sms"
+	"go.uber.org/zap"
+)
+
+// Audit event types of phone numbers.
+const (
+	AuditPhoneSet      = "phone.set"
+	AuditPhoneVerified = "phone.verified"
+)
+
+// Purposes of codes sent by SMS.
+const (
+	smsPurposeVerify = "verify"
+	smsPurposeLogin  = "login"
+)
+
+const (
+	smsCodeTTL         = 5 * time.Minute
+	smsCodeMaxAttempts = 5
+	// smsResendInterval is the minimum time between codes sent to a phone.
+	smsResendInterval = time.Minute
+)
+
+// phoneNumber matches E.164 numbers such as "+15551234567".
+var phoneNumber = regexp.MustCompile(`^\+[1-9][0-9]{6,14}$`)
+
+// SetPhone replaces the user's phone with an unverified number and sends it
+// a verification code. A new number is no longer a second factor until it is
+// verified again. It returns the code expiry.
+func (ms *MFAService) SetPhone(ctx context.Context, userID, phone string, client ClientInfo) (time.Time, error) {
+	phone = strings.NewReplacer(" ", "", "-", "", "(", "", ")", "").Replace(phone)
+	if !phoneNumber.MatchString(phone) {
+		return time.Time{}, autherr.ErrBadRequest.WithMessage("phone must be in E.164 format, e.g. +15551234567")
+	}
+	if prev, err := ms.Phones.Get(ctx, userID); err == nil {
+		if err := ms.checkResend(prev); err != nil {
+			return time.Time{}, err
+		}
+	} else if err != autherr.ErrNotFound {
+		logger.FromContext(ctx).Error("Failed to get phone", zap.Error(err))
+		return time.Time{}, autherr.ErrStorageError.WithMessage(err.Error())
+	}
+
+	code, err := verificationCode()
+	if err != nil {
+		return time.Time{}, autherr.ErrTokenGeneration.WithMessage(err.Error())
+	}
+	expires := ms.now().Add(smsCodeTTL)
+	err = ms.Tx.RunInTx(ctx, func(ctx context.Context, q db.Querier) error {
+		if err := ms.Phones.SetPending(ctx, q, userID, phone); err != nil {
+			return err
+		}
+		if err := ms.Phones.SetCode(ctx, q, userID, smsPurposeVerify, sha256Hex(code), expires); err != nil {
+			return err
+		}
+		return ms.Audit.Insert(ctx, q, auditEvent(AuditPhoneSet, userID, client, map[string]string{"phone": phone}))
+	})
+	if err != nil {
+		logger.FromContext(ctx).Error("Failed to store phone", zap.Error(err))
+		return time.Time{}, autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	if err := ms.sendCode(ctx, phone, code); err != nil {
+		return time.Time{}, err
+	}
+	return expires, nil
+}
+
+// Phone returns the user's phone, verified or not.
+func (ms *MFAService) Phone(ctx context.Context, userID string) (*models.Phone, error) {
+	phone, err := ms.Phones.Get(ctx, userID)
+	if err != nil {
+		if err == autherr.ErrNotFound {
+			return nil, autherr.ErrNotFound
+		}
+		logger.FromContext(ctx).Error("Failed to get phone", zap.Error(err))
+		return nil, autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	return phone, nil
+}
+
+// VerifyPhone confirms the user's phone with the code sent by SetPhone. With
+// mfa set, logins ask for a code sent to the phone from then on.
+func (ms *MFAService) VerifyPhone(ctx context.Context, userID, code string, mfa bool, client ClientInfo) error {
+	phone, ok, err := ms.checkSMSCode(ctx, userID, smsPurposeVerify, code, client)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return autherr.ErrBadRequest.WithMessage("invalid verification code")
+	}
+	err = ms.Tx.RunInTx(ctx, func(ctx context.Context, q db.Querier) error {
+		if err := ms.Phones.MarkVerified(ctx, q, userID, mfa); err != nil {
+			return err
+		}
+		return ms.Audit.Insert(ctx, q, auditEvent(AuditPhoneVerified, userID, client, map[string]string{
+			"phone": phone.Phone,
+			"mfa":   fmt.Sprint(mfa),
+		}))
+	})
+	if err != nil {
+		logger.FromContext(ctx).Error("Failed to verify phone", zap.Error(err))
+		return autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	return nil
+}
+
+// SendLoginSMS sends a login code to the user's phone, if it is a second
+// factor. It returns the code expiry.
+func (ms *MFAService) SendLoginSMS(ctx context.Context, userID string) (time.Time, error) {
+	phone, err := ms.mfaPhone(ctx, userID)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if phone == nil {
+		return time.Time{}, autherr.ErrNotFound.WithMessage("sms is not a second factor")
+	}
+	if err := ms.checkResend(phone); err != nil {
+		return time.Time{}, err
+	}
+
+	code, err := verificationCode()
+	if err != nil {
+		return time.Time{}, autherr.ErrTokenGeneration.WithMessage(err.Error())
+	}
+	expires := ms.now().Add(smsCodeTTL)
+	err = ms.Tx.RunInTx(ctx, func(ctx context.Context, q db.Querier) error {
+		return ms.Phones.SetCode(ctx, q, userID, smsPurposeLogin, sha256Hex(code), expires)
+	})
+	if err != nil {
+		logger.FromContext(ctx).Error("Failed to store sms code", zap.Error(err))
+		return time.Time{}, autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	if err := ms.sendCode(ctx, phone.Phone, code); err != nil {
+		return time.Time{}, err
+	}
+	return expires, nil
+}
+
+// VerifySMS checks a login code sent by SendLoginSMS. Wrong codes fail with
+// ErrInvalidMFA; after smsCodeMaxAttempts of them a new code is required.
+func (ms *MFAService) VerifySMS(ctx context.Context, userID, code string, client ClientInfo) error {
+	phone, err := ms.mfaPhone(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if phone == nil {
+		return autherr.ErrInvalidMFA
+	}
+	_, ok, err := ms.checkSMSCode(ctx, userID, smsPurposeLogin, code, client)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return autherr.ErrInvalidMFA
+	}
+	err = ms.Tx.RunInTx(ctx, func(ctx context.Context, q db.Querier) error {
+		return ms.Phones.ClearCode(ctx, q, userID)
+	})
+	if err != nil {
+		logger.FromContext(ctx).Error("Failed to clear sms code", zap.Error(err))
+		return autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	return nil
+}
+
+// checkSMSCode compares code with the pending code for purpose and counts
+// wrong ones.
+func (ms *MFAService) checkSMSCode(ctx context.Context, userID, purpose, code string, client ClientInfo) (*models.Phone, bool, error) {
+	phone, err := ms.Phones.Get(ctx, userID)
+	if err != nil {
+		if err == autherr.ErrNotFound {
+			return nil, false, autherr.ErrNotFound
+		}
+		logger.FromContext(ctx).Error("Failed to get phone", zap.Error(err))
+		return nil, false, autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	switch {
+	case phone.CodeHash == "" || phone.CodeExpiresAt == nil || phone.CodePurpose != purpose:
+		return nil, false, autherr.ErrBadRequest.WithMessage("no code pending")
+	case ms.now().After(*phone.CodeExpiresAt):
+		return nil, false, autherr.ErrBadRequest.WithMessage("code expired")
+	case phone.Attempts >= smsCodeMaxAttempts:
+		return nil, false, autherr.ErrForbidden.WithMessage("too many attempts, request a new code")
+	}
+
+	ok := subtle.ConstantTimeCompare([]byte(sha256Hex(strings.TrimSpace(code))), []byte(phone.CodeHash)) == 1
+	if ok {
+		return phone, true, nil
+	}
+	err = ms.Tx.RunInTx(ctx, func(ctx context.Context, q db.Querier) error {
+		if err := ms.Phones.IncrementAttempts(ctx, q, userID); err != nil {
+			return err
+		}
+		return ms.Audit.Insert(ctx, q, auditEvent(AuditMFAVerifyFailed, userID, client, map[string]string{"method": "sms"}))
+	})
+	if err != nil {
+		logger.FromContext(ctx).Error("Failed to count sms code attempt", zap.Error(err))
+		return nil, false, autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	return phone, false, nil
+}
+
+// mfaPhone returns the user's phone if it is a verified second factor, or
+// nil.
+func (ms *MFAService) mfaPhone(ctx context.Context, userID string) (*models.Phone, error) {
+	if ms.Phones == nil {
+		return nil, nil
+	}
+	phone, err := ms.Phones.Get(ctx, userID)
+	if err != nil {
+		if err == autherr.ErrNotFound {
+			return nil, nil
+		}
+		logger.FromContext(ctx).Error("Failed to get phone", zap.Error(err))
+		return nil, autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	if !phone.Verified() || !phone.MFAEnabled {
+		return nil, nil
+	}
+	return phone, nil
+}
+
+// checkResend rejects a new code within smsResendInterval of the last one,
+// so that the SMS provider cannot be abused to flood a number.
+func (ms *MFAService) checkResend(phone *models.Phone) error {
+	if phone.CodeSentAt == nil {
+		return nil
+	}
+	if wait := phone.CodeSentAt.Add(smsResendInterval).Sub(ms.now()); wait > 0 {
+		return autherr.ErrTooSoon.WithMessage("a code was sent recently").WithRetryDelay(wait)
+	}
+	return nil
+}
+
+func (ms *MFAService) sendCode(ctx context.Context, phone, code string) error {
+	msg := sms.Message{
+		To:   phone,
+		Body: fmt.Sprintf("Your %s code is %s. It expires in %d minutes.", ms.issuer(), code, int(smsCodeTTL.Minutes())),
+	}
+	if err := ms.SMS.Send(ctx, msg); err != nil {
+		logger.FromContext(ctx).Error("Failed to send sms", zap.Error(err))
+		return autherr.ErrDelivery
+	}
+	return nil
+}
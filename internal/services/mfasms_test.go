@@ -0,0 +1,178 @@
+package services
+
+import (
+	"context"
+	"regexp"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/andro-kes/auth_service/internal/autherr"
+	"github.com/andro-kes/auth_service/internal/models"
+	"github.com/andro-kes/auth_service/internal/repo/db"
+	"github.com/andro-kes/auth_service/internal/sms"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type testPhoneRepo struct {
+	rows map[string]*models.Phone
+	now  func() time.Time
+}
+
+func (r *testPhoneRepo) Get(ctx context.Context, userID string) (*models.Phone, error) {
+	p, ok := r.rows[userID]
+	if !ok {
+		return nil, autherr.ErrNotFound
+	}
+	cp := *p
+	return &cp, nil
+}
+
+func (r *testPhoneRepo) SetPending(ctx context.Context, q db.Querier, userID, phone string) error {
+	if r.rows == nil {
+		r.rows = map[string]*models.Phone{}
+	}
+	prev := r.rows[userID]
+	r.rows[userID] = &models.Phone{UserID: userID, Phone: phone}
+	if prev != nil {
+		r.rows[userID].CodeSentAt = prev.CodeSentAt
+	}
+	return nil
+}
+
+func (r *testPhoneRepo) SetCode(ctx context.Context, q db.Querier, userID, purpose, codeHash string, codeExpiresAt time.Time) error {
+	p, ok := r.rows[userID]
+	if !ok {
+		return autherr.ErrNotFound
+	}
+	now := r.now()
+	p.CodePurpose, p.CodeHash, p.CodeExpiresAt, p.CodeSentAt, p.Attempts = purpose, codeHash, &codeExpiresAt, &now, 0
+	return nil
+}
+
+func (r *testPhoneRepo) IncrementAttempts(ctx context.Context, q db.Querier, userID string) error {
+	r.rows[userID].Attempts++
+	return nil
+}
+
+func (r *testPhoneRepo) ClearCode(ctx context.Context, q db.Querier, userID string) error {
+	p := r.rows[userID]
+	p.CodePurpose, p.CodeHash, p.CodeExpiresAt, p.Attempts = "", "", nil, 0
+	return nil
+}
+
+func (r *testPhoneRepo) MarkVerified(ctx context.Context, q db.Querier, userID string, mfa bool) error {
+	now := r.now()
+	p := r.rows[userID]
+	p.VerifiedAt, p.MFAEnabled = &now, mfa
+	p.CodePurpose, p.CodeHash, p.CodeExpiresAt, p.Attempts = "", "", nil, 0
+	return nil
+}
+
+func (r *testPhoneRepo) Delete(ctx context.Context, q db.Querier, userID string) (bool, error) {
+	_, ok := r.rows[userID]
+	delete(r.rows, userID)
+	return ok, nil
+}
+
+type testSMSSender struct {
+	sent []sms.Message
+}
+
+func (s *testSMSSender) Send(ctx context.Context, msg sms.Message) error {
+	s.sent = append(s.sent, msg)
+	return nil
+}
+
+var smsCode = regexp.MustCompile(`\b[0-9]{6}\b`)
+
+func (s *testSMSSender) lastCode(t *testing.T) string {
+	t.Helper()
+	if len(s.sent) == 0 {
+		t.Fatal("expected an sms to be sent")
+	}
+	code := smsCode.FindString(s.sent[len(s.sent)-1].Body)
+	if code == "" {
+		t.Fatalf("no code in sms %q", s.sent[len(s.sent)-1].Body)
+	}
+	return code
+}
+
+func TestSMSSecondFactor(t *testing.T) {
+	ctx := context.Background()
+	clock := &fixedClock{t: time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)}
+	audit := &testAuditRepo{}
+	sender := &testSMSSender{}
+	ms := &MFAService{
+		Repo:   &testMFARepo{},
+		Users:  &testUserRepo{},
+		Audit:  audit,
+		Tx:     &fakeTx{},
+		Phones: &testPhoneRepo{now: clock.Now},
+		SMS:    sender,
+		Clock:  clock,
+	}
+
+	if _, err := ms.SetPhone(ctx, "u1", "555-1234", ClientInfo{}); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected InvalidArgument for a number without country code, got %v", err)
+	}
+	if _, err := ms.SetPhone(ctx, "u1", "+1 (555) 123-4567", ClientInfo{}); err != nil {
+		t.Fatalf("SetPhone failed: %v", err)
+	}
+	if to := sender.sent[0].To; to != "+15551234567" {
+		t.Fatalf("expected the code sent to the normalized number, got %q", to)
+	}
+	if _, err := ms.SetPhone(ctx, "u1", "+15551234567", ClientInfo{}); status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("expected FailedPrecondition for a resend within a minute, got %v", err)
+	}
+	if err := ms.VerifyPhone(ctx, "u1", "000000", true, ClientInfo{}); err == nil {
+		t.Fatal("expected a wrong code to be rejected")
+	}
+	if err := ms.VerifyPhone(ctx, "u1", sender.lastCode(t), true, ClientInfo{}); err != nil {
+		t.Fatalf("VerifyPhone failed: %v", err)
+	}
+	if enabled, err := ms.Enabled(ctx, "u1"); err != nil || !enabled {
+		t.Fatalf("expected sms to enable mfa, got %v, %v", enabled, err)
+	}
+
+	if _, err := ms.SendLoginSMS(ctx, "u1"); status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("expected FailedPrecondition for a resend within a minute, got %v", err)
+	}
+	clock.t = clock.t.Add(smsResendInterval)
+	if _, err := ms.SendLoginSMS(ctx, "u1"); err != nil {
+		t.Fatalf("SendLoginSMS failed: %v", err)
+	}
+	code := sender.lastCode(t)
+	if err := ms.VerifySMS(ctx, "u1", "000000", ClientInfo{}); err != autherr.ErrInvalidMFA {
+		t.Fatalf("expected ErrInvalidMFA for a wrong code, got %v", err)
+	}
+	if err := ms.VerifySMS(ctx, "u1", code, ClientInfo{}); err != nil {
+		t.Fatalf("VerifySMS failed: %v", err)
+	}
+	if err := ms.VerifySMS(ctx, "u1", code, ClientInfo{}); err == nil {
+		t.Fatal("expected a used code to be rejected")
+	}
+
+	clock.t = clock.t.Add(smsResendInterval)
+	if _, err := ms.SendLoginSMS(ctx, "u1"); err != nil {
+		t.Fatalf("SendLoginSMS failed: %v", err)
+	}
+	code = sender.lastCode(t)
+	for range smsCodeMaxAttempts {
+		ms.VerifySMS(ctx, "u1", "000000", ClientInfo{})
+	}
+	if err := ms.VerifySMS(ctx, "u1", code, ClientInfo{}); err == nil {
+		t.Fatal("expected the code to be locked after too many attempts")
+	}
+
+	if _, err := ms.SendLoginSMS(ctx, "u2"); err == nil {
+		t.Fatal("expected SendLoginSMS to fail without a phone")
+	}
+	if err := ms.VerifySMS(ctx, "u2", code, ClientInfo{}); err != autherr.ErrInvalidMFA {
+		t.Fatalf("expected ErrInvalidMFA without a phone, got %v", err)
+	}
+	if !slices.Contains(audit.events, AuditPhoneVerified) {
+		t.Fatalf("expected the verification to be audited, got %v", audit.events)
+	}
+}
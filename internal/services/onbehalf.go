@@ -0,0 +1,134 @@
+package services
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/andro-kes/auth_service/internal/autherr"
+	"github.com/andro-kes/auth_service/internal/logger"
+	"github.com/andro-kes/auth_service/internal/metrics"
+	"github.com/golang-jwt/jwt/v5"
+	"go.uber.org/zap"
+)
+
+// DelegateScopePrefix prefixes the service token scopes that let a service
+// account obtain tokens on behalf of users: "delegate:<audience>" allows
+// tokens for that audience.
+const DelegateScopePrefix = "delegate:"
+
+// maxDelegationDepth bounds the chain of services a token is delegated
+// through.
+const maxDelegationDepth = 5
+
+// actor is the RFC 8693 "act" claim: the party a token was issued to on
+// behalf of its subject, which may itself have acted for another one.
+type actor struct {
+	Subject string `json:"sub"`
+	SubType string `json:"sub_type,omitempty"`
+	Act     *actor `json:"act,omitempty"`
+}
+
+// chain lists the subjects of a and the actors before it, a's first.
+func (a *actor) chain() []string {
+	var subjects []string
+	for ; a != nil; a = a.Act {
+		subjects = append(subjects, a.Subject)
+	}
+	return subjects
+}
+
+// OnBehalfOf lets the service account of serviceToken act for the user of
+// subjectToken at a downstream service (RFC 8693 token exchange with an
+// actor). The service token needs the scope "delegate:<audience>". The
+// issued access token is narrowed to audience and scope, a space-separated
+// subset of the subject token's scope if it has one, expires no later than
+// the subject token, and records the service in its "act" claim, ahead of
+// the actors of the subject token.
+func (ss *ServiceAccountService) OnBehalfOf(ctx context.Context, serviceToken, subjectToken, audience, scope string) (string, time.Time, error) {
+	if audience == "" || scope == "" {
+		return "", time.Time{}, autherr.ErrBadRequest.WithMessage("audience and scope are required")
+	}
+	service, err := ss.Introspect(ctx, serviceToken)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	if !service.Active {
+		return "", time.Time{}, autherr.ErrInvalidToken
+	}
+	if !slices.Contains(strings.Fields(service.Scope), DelegateScopePrefix+audience) {
+		return "", time.Time{}, autherr.ErrForbidden.WithMessage("service account may not delegate to " + audience)
+	}
+	token, exp, err := ss.Tokens.issueOnBehalfOf(ctx, subjectToken, service.UserID, audience, scope)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	logger.FromContext(ctx).Info("Token issued on behalf of user",
+		zap.String("account_id", service.UserID),
+		zap.String("audience", audience))
+	return token, exp, nil
+}
+
+func (s *TokenService) issueOnBehalfOf(ctx context.Context, subjectToken, actorID, audience, scope string) (string, time.Time, error) {
+	subject, err := s.accessClaims(ctx, subjectToken)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	if _, err := s.activeAccess(ctx, subject); err != nil {
+		return "", time.Time{}, err
+	}
+	switch {
+	case subject.SubType == SubjectServiceAccount || subject.SubType == SubjectClient:
+		return "", time.Time{}, autherr.ErrBadRequest.WithMessage("subject token must be a user's")
+	case subject.OneTime:
+		// exchanging it would let it be used more than once
+		return "", time.Time{}, autherr.ErrBadRequest.WithMessage("one-time tokens cannot be exchanged")
+	case subject.Cnf != nil && subject.Cnf.JKT != "":
+		// only the holder of the key may use it
+		return "", time.Time{}, autherr.ErrBadRequest.WithMessage("DPoP-bound tokens cannot be exchanged")
+	case len(subject.Act.chain()) >= maxDelegationDepth:
+		return "", time.Time{}, autherr.ErrForbidden.WithMessage("delegation chain is too long")
+	}
+	scopes := strings.Fields(scope)
+	if subject.Scope != "" {
+		granted := strings.Fields(subject.Scope)
+		for _, sc := range scopes {
+			if !slices.Contains(granted, sc) {
+				return "", time.Time{}, autherr.ErrForbidden.WithMessage("scope exceeds the subject token's")
+			}
+		}
+	}
+
+	now := s.now().UTC()
+	exp := now.Add(s.accessTTL)
+	if subject.ExpiresAt.Time.Before(exp) {
+		exp = subject.ExpiresAt.Time
+	}
+	jti, err := randomHex(s.crypto.Rand(), 16)
+	if err != nil {
+		return "", time.Time{}, autherr.ErrTokenGeneration.WithMessage(err.Error())
+	}
+	claims := tokenClaims{
+		UserID:    subject.UserID,
+		Typ:       "access",
+		SessionID: subject.SessionID,
+		Scope:     strings.Join(scopes, " "),
+		SubType:   subject.SubType,
+		Version:   subject.Version,
+		Act:       &actor{Subject: actorID, SubType: SubjectServiceAccount, Act: subject.Act},
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        jti,
+			Audience:  jwt.ClaimStrings{audience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(exp),
+			NotBefore: jwt.NewNumericDate(now),
+		},
+	}
+	signed, err := s.encodeAccess(ctx, claims, false)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	metrics.TokensIssued.WithLabelValues("on_behalf_of").Inc()
+	return signed, exp, nil
+}
@@ -0,0 +1,92 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"github.com/andro-kes/auth_service/internal/autherr"
+	"github.com/andro-kes/auth_service/internal/metrics"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// IssueScopedAccess issues a short-lived access token restricted to scope.
+// When oneTime is set the token is accepted by exactly one call: its jti is
+// consumed on first use. Scoped tokens are not backed by a refresh token.
+func (s *TokenService) IssueScopedAccess(ctx context.Context, userID, scope string, ttl time.Duration, oneTime bool, opts ...IssueOption) (string, time.Time, error) {
+	if scope == "" {
+		return "", time.Time{}, autherr.ErrBadRequest.WithMessage("scope is required")
+	}
+	if ttl <= 0 || ttl > s.accessTTL {
+		ttl = s.accessTTL
+	}
+	params := newIssueParams(opts)
+
+	now := s.now().UTC()
+	exp := now.Add(ttl)
+	jti, err := randomHex(s.crypto.Rand(), 16)
+	if err != nil {
+		return "", time.Time{}, autherr.ErrTokenGeneration.WithMessage(err.Error())
+	}
+	claims := tokenClaims{
+		UserID:  userID,
+		Typ:     "access",
+		Scope:   scope,
+		SubType: params.subjectType,
+		OneTime: oneTime,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        jti,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(exp),
+			NotBefore: jwt.NewNumericDate(now),
+		},
+	}
+	if params.audience != "" {
+		claims.Audience = jwt.ClaimStrings{params.audience}
+	}
+	if params.dpopJKT != "" {
+		claims.Cnf = &confirmation{JKT: params.dpopJKT}
+	}
+	if err := s.stampVersion(ctx, &claims); err != nil {
+		return "", time.Time{}, err
+	}
+	signed, err := s.encodeAccess(ctx, claims, params.reference)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	metrics.TokensIssued.WithLabelValues("scoped").Inc()
+	return signed, exp, nil
+}
+
+// DPoPBinding returns the DPoP key thumbprint an access token is bound to, or
+// "" for bearer tokens. The token must already have been validated.
+func (s *TokenService) DPoPBinding(ctx context.Context, tokenStr string) string {
+	claims, err := s.accessClaims(ctx, tokenStr)
+	if err != nil || claims.Cnf == nil {
+		return ""
+	}
+	return claims.Cnf.JKT
+}
+
+// consumeOnce marks the jti of a one-time token as used. The marker lives
+// until the token expires, after which the token is rejected anyway.
+func (s *TokenService) consumeOnce(ctx context.Context, claims *tokenClaims) error {
+	if claims.ID == "" || claims.ExpiresAt == nil {
+		return autherr.ErrInvalidToken
+	}
+	ttl := claims.ExpiresAt.Time.Sub(s.now())
+	if ttl <= 0 {
+		return autherr.ErrTokenExpired
+	}
+	ok, err := s.rdb.SetNX(ctx, usedJTIKey(claims.ID), 1, ttl+time.Second).Result()
+	if err != nil {
+		return autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	if !ok {
+		return autherr.ErrTokenReplayed
+	}
+	return nil
+}
+
+func usedJTIKey(jti string) string {
+	return "access:used:" + jti
+}
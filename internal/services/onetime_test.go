@@ -0,0 +1,44 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/andro-kes/auth_service/internal/autherr"
+)
+
+func TestIssueScopedAccess_OneTime(t *testing.T) {
+	svc, _ := newTestTokenService(t)
+
+	ctx := t.Context()
+	const method = "/auth.AuthService/ListSessions"
+
+	once, _, err := svc.IssueScopedAccess(ctx, "alice", "payments", 30*time.Second, true)
+	if err != nil {
+		t.Fatalf("IssueScopedAccess failed: %v", err)
+	}
+	if _, err := svc.ValidateAccess(once); err != autherr.ErrInvalidToken {
+		t.Fatalf("expected one-time token to be rejected without a call context, got %v", err)
+	}
+	claims, err := svc.ValidateAccessForCall(ctx, once, "", method)
+	if err != nil || claims.UserID != "alice" || !claims.OneTime || claims.Scope != "payments" {
+		t.Fatalf("expected first use to succeed, got %+v, %v", claims, err)
+	}
+	if _, err := svc.ValidateAccessForCall(ctx, once, "", method); err != autherr.ErrTokenReplayed {
+		t.Fatalf("expected replay to be rejected, got %v", err)
+	}
+
+	reusable, _, err := svc.IssueScopedAccess(ctx, "alice", "profile", 30*time.Second, false)
+	if err != nil {
+		t.Fatalf("IssueScopedAccess failed: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := svc.ValidateAccessForCall(ctx, reusable, "", method); err != nil {
+			t.Fatalf("expected reusable scoped token to validate, got %v", err)
+		}
+	}
+
+	if _, _, err := svc.IssueScopedAccess(ctx, "alice", "", time.Minute, true); err == nil {
+		t.Fatal("expected empty scope to be rejected")
+	}
+}
@@ -0,0 +1,119 @@
+package services
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/andro-kes/auth_service/internal/autherr"
+	"github.com/andro-kes/auth_service/internal/paseto"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// pasetoCodec encodes access tokens as PASETO v4 instead of JWTs. Exactly
+// one of local and public is set.
+type pasetoCodec struct {
+	local  []byte
+	public ed25519.PrivateKey
+}
+
+// WithPASETOLocal issues access tokens as PASETO v4.local, encrypted and
+// authenticated with the 32-byte key. Their claims are unreadable to clients.
+func WithPASETOLocal(key []byte) Option {
+	return func(s *TokenService) {
+		s.paseto = &pasetoCodec{local: key}
+	}
+}
+
+// WithPASETOPublic issues access tokens as PASETO v4.public, signed with the
+// Ed25519 key.
+func WithPASETOPublic(key ed25519.PrivateKey) Option {
+	return func(s *TokenService) {
+		s.paseto = &pasetoCodec{public: key}
+	}
+}
+
+// pasetoClaims carries tokenClaims with the registered time claims as
+// RFC 3339 strings, as PASETO requires. The outer fields shadow the numeric
+// ones of jwt.RegisteredClaims.
+type pasetoClaims struct {
+	*tokenClaims
+	ExpiresAt string `json:"exp"`
+	IssuedAt  string `json:"iat,omitempty"`
+	NotBefore string `json:"nbf,omitempty"`
+}
+
+func (c *pasetoCodec) encode(rand io.Reader, claims tokenClaims) (string, error) {
+	pc := pasetoClaims{tokenClaims: &claims, ExpiresAt: formatClaimTime(claims.ExpiresAt)}
+	pc.IssuedAt = formatClaimTime(claims.IssuedAt)
+	pc.NotBefore = formatClaimTime(claims.NotBefore)
+	payload, err := json.Marshal(pc)
+	if err != nil {
+		return "", autherr.ErrTokenGeneration.WithMessage(err.Error())
+	}
+	if c.local == nil {
+		return paseto.Sign(c.public, payload, nil), nil
+	}
+	token, err := paseto.Encrypt(rand, c.local, payload, nil)
+	if err != nil {
+		return "", autherr.ErrTokenGeneration.WithMessage(err.Error())
+	}
+	return token, nil
+}
+
+// decode opens tokenStr and validates its time claims at now, tolerating
+// clock skew of up to leeway.
+func (c *pasetoCodec) decode(tokenStr string, now time.Time, leeway time.Duration) (*tokenClaims, error) {
+	var payload []byte
+	var err error
+	if c.local == nil {
+		payload, _, err = paseto.Verify(c.public.Public().(ed25519.PublicKey), tokenStr)
+	} else {
+		payload, _, err = paseto.Decrypt(c.local, tokenStr)
+	}
+	if err != nil {
+		return nil, autherr.ErrInvalidToken
+	}
+
+	claims := &tokenClaims{}
+	pc := pasetoClaims{tokenClaims: claims}
+	if err := json.Unmarshal(payload, &pc); err != nil {
+		return nil, autherr.ErrInvalidToken
+	}
+	if claims.ExpiresAt, err = parseClaimTime(pc.ExpiresAt); err != nil || claims.ExpiresAt == nil {
+		return nil, autherr.ErrInvalidToken
+	}
+	if claims.IssuedAt, err = parseClaimTime(pc.IssuedAt); err != nil {
+		return nil, autherr.ErrInvalidToken
+	}
+	if claims.NotBefore, err = parseClaimTime(pc.NotBefore); err != nil {
+		return nil, autherr.ErrInvalidToken
+	}
+
+	if !now.Add(-leeway).Before(claims.ExpiresAt.Time) {
+		return nil, autherr.ErrTokenExpired
+	}
+	if claims.NotBefore != nil && now.Add(leeway).Before(claims.NotBefore.Time) {
+		return nil, autherr.ErrInvalidToken
+	}
+	return claims, nil
+}
+
+func formatClaimTime(t *jwt.NumericDate) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+func parseClaimTime(s string) (*jwt.NumericDate, error) {
+	if s == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return nil, err
+	}
+	return jwt.NewNumericDate(t), nil
+}
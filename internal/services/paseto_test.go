@@ -0,0 +1,93 @@
+package services
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/andro-kes/auth_service/internal/autherr"
+	"github.com/andro-kes/auth_service/internal/paseto"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestPASETOAccessTokens(t *testing.T) {
+	srv, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("failed to start miniredis: %v", err)
+	}
+	defer srv.Close()
+
+	rdb := redis.NewClient(&redis.Options{Addr: srv.Addr()})
+	defer rdb.Close()
+
+	localKey := make([]byte, paseto.KeySize)
+	if _, err := rand.Read(localKey); err != nil {
+		t.Fatal(err)
+	}
+	_, signingKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		opt    Option
+		header string
+	}{
+		{"local", WithPASETOLocal(localKey), paseto.LocalHeader},
+		{"public", WithPASETOPublic(signingKey), paseto.PublicHeader},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			svc, err := NewTokenService(rdb, "012345678901234567890123456789ab", time.Minute, time.Minute*5, tc.opt)
+			if err != nil {
+				t.Fatalf("failed to create TokenService: %v", err)
+			}
+
+			ctx := t.Context()
+			access, _, exp, _, err := svc.GenerateTokens(ctx, "alice")
+			if err != nil {
+				t.Fatalf("GenerateTokens failed: %v", err)
+			}
+			if !strings.HasPrefix(access, tc.header) {
+				t.Fatalf("expected %s token, got %q", tc.header, access)
+			}
+			if claims, err := svc.ValidateAccess(access); err != nil || claims.UserID != "alice" {
+				t.Fatalf("ValidateAccess = %+v, %v", claims, err)
+			}
+			in, err := svc.Introspect(ctx, access)
+			if err != nil || !in.Active || in.UserID != "alice" || !in.ExpiresAt.Equal(exp.Truncate(time.Second)) {
+				t.Fatalf("unexpected introspection %+v, %v", in, err)
+			}
+
+			// tokens of one format are never accepted by a service using another
+			jwtSvc, err := NewTokenService(rdb, "012345678901234567890123456789ab", time.Minute, time.Minute*5)
+			if err != nil {
+				t.Fatalf("failed to create TokenService: %v", err)
+			}
+			if _, err := jwtSvc.ValidateAccess(access); err != autherr.ErrInvalidToken {
+				t.Fatalf("expected PASETO token to be rejected in JWT mode, got %v", err)
+			}
+
+			past := time.Now().Add(-time.Hour)
+			expired, err := svc.paseto.encode(rand.Reader, tokenClaims{
+				UserID: "alice",
+				Typ:    "access",
+				RegisteredClaims: jwt.RegisteredClaims{
+					ID:        "expired",
+					IssuedAt:  jwt.NewNumericDate(past),
+					ExpiresAt: jwt.NewNumericDate(past.Add(time.Minute)),
+				},
+			})
+			if err != nil {
+				t.Fatalf("encode failed: %v", err)
+			}
+			if _, err := svc.ValidateAccess(expired); err != autherr.ErrTokenExpired {
+				t.Fatalf("expected expired token to be rejected, got %v", err)
+			}
+		})
+	}
+}
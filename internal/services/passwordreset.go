@@ -0,0 +1,109 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/andro-kes/auth_service/internal/autherr"
+	"github.com/andro-kes/auth_service/internal/logger"
+	"github.com/andro-kes/auth_service/internal/mail"
+	"go.uber.org/zap"
+)
+
+// PasswordResetService lets users who forgot their password set a new one
+// with a one-time token mailed to them.
+type PasswordResetService struct {
+	Users  *UserService
+	Tokens *TokenService
+	// Recovery, when set, sends the token to the user's verified recovery
+	// email instead of the primary one.
+	Recovery *RecoveryService
+	Mail     mail.Sender
+	// URL is the page the mailed link opens, with the token in the "token"
+	// query parameter. When empty the bare token is mailed.
+	URL string
+	// TTL is how long a token is valid; zero means 15 minutes.
+	TTL time.Duration
+}
+
+// RequestPasswordReset mails a password reset token to the user with login
+// (username or email), at their recovery address if they verified one. Like
+// RequestLoginLink, unknown logins, users without an email and inactive
+// accounts are skipped silently and the mail is sent in the background.
+func (ps *PasswordResetService) RequestPasswordReset(ctx context.Context, login string) error {
+	if login == "" {
+		return autherr.ErrBadRequest.WithMessage("login is required")
+	}
+	if err := checkLoginInput(login, ""); err != nil {
+		return err
+	}
+	if !strings.Contains(login, "@") {
+		login = normalizeUsername(login)
+	}
+	user, err := ps.Users.findByLogin(ctx, login)
+	if err != nil {
+		if err == autherr.ErrNotFound {
+			return nil
+		}
+		logger.FromContext(ctx).Error("Failed to get user by login", zap.Error(err))
+		return autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	if statusError(user.Status) != nil {
+		return nil
+	}
+	to := user.Email
+	if ps.Recovery != nil {
+		if to, err = ps.Recovery.ContactAddress(ctx, user); err != nil {
+			return err
+		}
+	}
+	if to == "" {
+		return nil
+	}
+
+	ttl := ps.TTL
+	if ttl == 0 {
+		ttl = defaultPurposeTokenTTL
+	}
+	token, _, err := ps.Tokens.IssuePurposeToken(ctx, PurposePasswordReset, user.ID, ttl)
+	if err != nil {
+		return err
+	}
+	msg := mail.Message{
+		To:      to,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Use this link to choose a new password:\n\n%s\n\nIt expires in %d minutes and works once. "+
+			"All your sessions will be signed out. If you did not request it, ignore this message.\n",
+			mailedLink(ps.URL, token), int(ttl.Minutes())),
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), sendLinkTimeout)
+	go func() {
+		defer cancel()
+		if err := ps.Mail.Send(ctx, msg); err != nil {
+			logger.FromContext(ctx).Error("Failed to send password reset", zap.String("user_id", user.ID), zap.Error(err))
+		}
+	}()
+	return nil
+}
+
+// ResetPassword redeems a password reset token, sets password as the new
+// password of its user and signs the user out everywhere. It returns the
+// user's ID.
+func (ps *PasswordResetService) ResetPassword(ctx context.Context, token, password string) (string, error) {
+	userID, err := ps.Tokens.ConsumePurposeToken(ctx, PurposePasswordReset, token)
+	if err != nil {
+		return "", err
+	}
+	if err := ps.Users.ResetPassword(ctx, userID, password); err != nil {
+		if err == autherr.ErrNotFound {
+			return "", autherr.ErrInvalidToken
+		}
+		return "", err
+	}
+	if err := ps.Tokens.PasswordChanged(ctx, userID); err != nil {
+		return "", err
+	}
+	return userID, nil
+}
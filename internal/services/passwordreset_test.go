@@ -0,0 +1,129 @@
+package services
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andro-kes/auth_service/internal/autherr"
+	"github.com/andro-kes/auth_service/internal/mail"
+	"github.com/andro-kes/auth_service/internal/models"
+)
+
+func TestPasswordReset(t *testing.T) {
+	users := &testUserRepo{emails: []string{"alice@example.com"}, userID: "alice"}
+	clock := &fixedClock{t: time.Now().Add(-10 * time.Second)}
+	tokens, _ := newTestTokenService(t, WithClock(clock), WithPasswordChanges(users))
+	mailer := make(chanMailer, 1)
+	ps := &PasswordResetService{
+		Users:  &UserService{Repo: users, Tx: &fakeTx{}},
+		Tokens: tokens,
+		Mail:   mailer,
+		URL:    "https://app.example.com/reset",
+	}
+	ctx := t.Context()
+
+	access, refresh, _, _, err := tokens.GenerateTokens(ctx, "alice")
+	if err != nil {
+		t.Fatalf("GenerateTokens failed: %v", err)
+	}
+
+	if err := ps.RequestPasswordReset(ctx, "alice@example.com"); err != nil {
+		t.Fatalf("RequestPasswordReset failed: %v", err)
+	}
+	var msg mail.Message
+	select {
+	case msg = <-mailer:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected a reset link to be mailed")
+	}
+	if msg.To != "alice@example.com" {
+		t.Fatalf("expected the link sent to the user's email, got %q", msg.To)
+	}
+	var link string
+	for _, line := range strings.Split(msg.Body, "\n") {
+		if strings.HasPrefix(line, "https://") {
+			link = line
+		}
+	}
+	u, err := url.Parse(link)
+	if err != nil || u.Query().Get("token") == "" {
+		t.Fatalf("unexpected link %q in %q", link, msg.Body)
+	}
+	token := u.Query().Get("token")
+
+	clock.t = time.Now()
+	userID, err := ps.ResetPassword(ctx, token, "brand-new-secret")
+	if err != nil {
+		t.Fatalf("ResetPassword failed: %v", err)
+	}
+	if userID != "alice" || users.passwords["alice"] == "" {
+		t.Fatalf("expected alice's password replaced, got user %q", userID)
+	}
+	if _, err := ps.ResetPassword(ctx, token, "another-secret"); err != autherr.ErrInvalidToken {
+		t.Fatalf("expected a used token to be rejected, got %v", err)
+	}
+
+	// the sessions started before the reset are over
+	if _, err := tokens.ValidateAccess(access); err == nil {
+		t.Fatal("expected the old access token to be rejected")
+	}
+	if _, _, _, _, err := tokens.RotateRefresh(ctx, refresh, ""); err == nil {
+		t.Fatal("expected the old session to be revoked")
+	}
+
+	// unknown logins look the same to the caller but nothing is mailed
+	if err := ps.RequestPasswordReset(ctx, "bob@example.com"); err != nil {
+		t.Fatalf("expected no error for an unknown login, got %v", err)
+	}
+	select {
+	case msg := <-mailer:
+		t.Fatalf("expected nothing mailed, got %+v", msg)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestPasswordResetRecoveryAddress(t *testing.T) {
+	tokens, _ := newTestTokenService(t)
+	users := &testUserRepo{emails: []string{"alice@example.com"}, userID: "alice"}
+	emails := &testRecoveryRepo{rows: map[string]*models.RecoveryEmail{
+		"alice": {UserID: "alice", Email: "backup@example.com"},
+	}}
+	mailer := make(chanMailer, 1)
+	ps := &PasswordResetService{
+		Users:    &UserService{Repo: users},
+		Tokens:   tokens,
+		Recovery: &RecoveryService{Users: users, Emails: emails},
+		Mail:     mailer,
+	}
+	ctx := t.Context()
+
+	receive := func() string {
+		t.Helper()
+		select {
+		case msg := <-mailer:
+			return msg.To
+		case <-time.After(5 * time.Second):
+			t.Fatal("expected a reset token to be mailed")
+			return ""
+		}
+	}
+
+	// an unverified recovery email is not used
+	if err := ps.RequestPasswordReset(ctx, "alice@example.com"); err != nil {
+		t.Fatalf("RequestPasswordReset failed: %v", err)
+	}
+	if to := receive(); to != "alice@example.com" {
+		t.Fatalf("expected the token sent to the primary email, got %q", to)
+	}
+
+	verified := time.Now()
+	emails.rows["alice"].VerifiedAt = &verified
+	if err := ps.RequestPasswordReset(ctx, "alice@example.com"); err != nil {
+		t.Fatalf("RequestPasswordReset failed: %v", err)
+	}
+	if to := receive(); to != "backup@example.com" {
+		t.Fatalf("expected the token sent to the recovery email, got %q", to)
+	}
+}
@@ -0,0 +1,122 @@
+package services
+
+import (
+	"context"
+
+	"github.com/andro-kes/auth_service/internal/autherr"
+	"github.com/andro-kes/auth_service/internal/logger"
+	"github.com/andro-kes/auth_service/internal/models"
+	"github.com/andro-kes/auth_service/internal/repo/db"
+	"go.uber.org/zap"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+)
+
+// reasonReused is the field violation reason of a password found in the
+// user's history.
+const reasonReused = "reused"
+
+// ChangePassword sets a new password for a user who knows the current one.
+// The caller forces the user to log in again with
+// TokenService.PasswordChanged.
+func (us *UserService) ChangePassword(ctx context.Context, userID, current, password string) error {
+	user, err := us.findByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if err := us.comparePassword(ctx, user.Password, current); err != nil {
+		return err
+	}
+	return us.setPassword(ctx, user, password)
+}
+
+// ResetPassword sets a new password for a user who proved their identity some
+// other way, e.g. with a password reset token.
+func (us *UserService) ResetPassword(ctx context.Context, userID, password string) error {
+	user, err := us.findByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	return us.setPassword(ctx, user, password)
+}
+
+func (us *UserService) findByID(ctx context.Context, userID string) (*models.User, error) {
+	user, err := us.Repo.FindByID(ctx, userID)
+	if err != nil {
+		if err == autherr.ErrNotFound {
+			return nil, autherr.ErrNotFound
+		}
+		logger.FromContext(ctx).Error("Failed to get user by id", zap.Error(err))
+		return nil, autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	return user, nil
+}
+
+// setPassword checks password against the policy and the user's history,
+// then replaces the stored hash, moving the old one into the history.
+func (us *UserService) setPassword(ctx context.Context, user *models.User, password string) error {
+	if err := us.checkPassword(password, user.Username, user.Email); err != nil {
+		return err
+	}
+	if err := us.checkHistory(ctx, user, password); err != nil {
+		return err
+	}
+	hash, err := us.hashPassword(ctx, password)
+	if err != nil {
+		return err
+	}
+
+	err = us.Tx.RunInTx(ctx, func(ctx context.Context, q db.Querier) error {
+		if err := us.Repo.ChangePassword(ctx, q, user.ID, hash); err != nil {
+			return err
+		}
+		if us.HistorySize <= 0 || us.History == nil {
+			return nil
+		}
+		if err := us.History.Add(ctx, q, user.ID, user.Password); err != nil {
+			return err
+		}
+		return us.History.Trim(ctx, q, user.ID, us.HistorySize)
+	})
+	if err != nil {
+		if err == autherr.ErrNotFound {
+			return autherr.ErrNotFound
+		}
+		logger.FromContext(ctx).Error("Failed to update password", zap.Error(err))
+		return autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	logger.FromContext(ctx).Info("Password changed", zap.String("user_id", user.ID))
+	return nil
+}
+
+// checkHistory rejects the current password and the HistorySize previous
+// ones.
+func (us *UserService) checkHistory(ctx context.Context, user *models.User, password string) error {
+	if us.HistorySize <= 0 {
+		return nil
+	}
+	hashes := []string{user.Password}
+	if us.History != nil {
+		recent, err := us.History.Recent(ctx, user.ID, us.HistorySize)
+		if err != nil {
+			logger.FromContext(ctx).Error("Failed to get password history", zap.Error(err))
+			return autherr.ErrStorageError.WithMessage(err.Error())
+		}
+		hashes = append(hashes, recent...)
+	}
+	for _, hash := range hashes {
+		err := us.comparePassword(ctx, hash, password)
+		if err == nil {
+			return autherr.ErrBadRequest.WithMessage("password was used recently").WithDetails(&errdetails.BadRequest{
+				FieldViolations: []*errdetails.BadRequest_FieldViolation{{
+					Field:       "password",
+					Reason:      reasonReused,
+					Description: "password must differ from the recently used ones",
+				}},
+			})
+		}
+		if err != autherr.ErrLoginUser {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// WithRefreshPepper stores refresh tokens under HMAC-SHA256 keyed by pepper
+// instead of plain SHA-256, so that a dump of Redis or the durable store
+// cannot be matched against tokens without the key. Tokens stored under the
+// plain hash keep working until they are rotated or expire.
+func WithRefreshPepper(pepper []byte) Option {
+	return func(s *TokenService) {
+		s.pepper = pepper
+	}
+}
+
+// refreshHash is the storage key hash of a raw refresh token.
+func (s *TokenService) refreshHash(raw string) string {
+	if len(s.pepper) == 0 {
+		return sha256Hex(raw)
+	}
+	mac := hmac.New(sha256.New, s.pepper)
+	mac.Write([]byte(raw))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// resolveRefresh finds the hash a raw refresh token is stored under and
+// reports whether it exists. With a pepper set it falls back to the plain
+// hash used before the pepper was introduced.
+func (s *TokenService) resolveRefresh(ctx context.Context, raw string) (string, bool, error) {
+	h := s.refreshHash(raw)
+	exists, err := s.ensureRefresh(ctx, h)
+	if err != nil || exists || len(s.pepper) == 0 {
+		return h, exists, err
+	}
+	legacy := sha256Hex(raw)
+	exists, err = s.ensureRefresh(ctx, legacy)
+	if err != nil || !exists {
+		return h, false, err
+	}
+	return legacy, true, nil
+}
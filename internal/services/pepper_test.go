@@ -0,0 +1,54 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/andro-kes/auth_service/internal/autherr"
+)
+
+func TestRefreshPepper(t *testing.T) {
+	plain, srv := newTestTokenService(t)
+	rdb := plain.rdb
+	secret := "012345678901234567890123456789ab"
+	peppered, err := NewTokenService(rdb, secret, time.Minute, time.Hour,
+		WithRefreshPepper([]byte("pepper-pepper-pepper-pepper-0123")))
+	if err != nil {
+		t.Fatalf("NewTokenService: %v", err)
+	}
+	ctx := t.Context()
+
+	_, legacy, _, _, err := plain.GenerateTokens(ctx, "user-1")
+	if err != nil {
+		t.Fatalf("GenerateTokens: %v", err)
+	}
+
+	// tokens stored before the pepper was set still rotate
+	_, refresh, _, _, err := peppered.RotateRefresh(ctx, legacy, "user-1")
+	if err != nil {
+		t.Fatalf("RotateRefresh of legacy token: %v", err)
+	}
+	if srv.Exists(redisKey(sha256Hex(legacy))) {
+		t.Fatal("legacy token survived rotation")
+	}
+	if srv.Exists(redisKey(sha256Hex(refresh))) {
+		t.Fatal("new token stored under the plain hash")
+	}
+	if !srv.Exists(redisKey(peppered.refreshHash(refresh))) {
+		t.Fatal("new token not stored under the HMAC")
+	}
+
+	if uid, err := peppered.ValidateRefresh(ctx, refresh); err != nil || uid != "user-1" {
+		t.Fatalf("ValidateRefresh = %q, %v", uid, err)
+	}
+	if _, err := plain.ValidateRefresh(ctx, refresh); err != autherr.ErrInvalidToken {
+		t.Fatalf("ValidateRefresh without pepper: %v, want ErrInvalidToken", err)
+	}
+
+	if err := peppered.RevokeRefreshByRaw(ctx, refresh); err != nil {
+		t.Fatalf("RevokeRefreshByRaw: %v", err)
+	}
+	if _, err := peppered.ValidateRefresh(ctx, refresh); err != autherr.ErrInvalidToken {
+		t.Fatalf("ValidateRefresh after revoke: %v, want ErrInvalidToken", err)
+	}
+}
@@ -0,0 +1,90 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/andro-kes/auth_service/internal/autherr"
+	"github.com/andro-kes/auth_service/internal/logger"
+	"github.com/andro-kes/auth_service/internal/models"
+	"github.com/andro-kes/auth_service/internal/repo/db"
+	"go.uber.org/zap"
+)
+
+const (
+	maxProfileNameLen = 100
+	maxMetadataBytes  = 16 << 10
+)
+
+// profileFields are the fields UpdateProfile sets, named like their columns.
+var profileFields = []string{"first_name", "last_name", "display_name", "metadata"}
+
+// GetUser returns a user by ID.
+func (us *UserService) GetUser(ctx context.Context, userID string) (*models.User, error) {
+	if userID == "" {
+		return nil, autherr.ErrBadRequest.WithMessage("user_id is required")
+	}
+	return us.findByID(ctx, userID)
+}
+
+// GetProfile returns the user with their profile.
+func (us *UserService) GetProfile(ctx context.Context, userID string) (*models.User, error) {
+	return us.findByID(ctx, userID)
+}
+
+// UpdateProfile sets the listed fields of the user's profile to those of
+// profile; no fields means all of them. It returns the updated user.
+func (us *UserService) UpdateProfile(ctx context.Context, userID string, profile *models.Profile, fields []string) (*models.User, error) {
+	if len(fields) == 0 {
+		fields = profileFields
+	}
+	for _, f := range fields {
+		if !slices.Contains(profileFields, f) {
+			return nil, autherr.ErrBadRequest.WithMessage("unknown profile field " + f)
+		}
+	}
+	if err := checkProfile(profile); err != nil {
+		return nil, err
+	}
+
+	var user *models.User
+	err := us.Tx.RunInTx(ctx, func(ctx context.Context, q db.Querier) error {
+		var err error
+		user, err = us.Repo.UpdateProfile(ctx, q, userID, profile, fields)
+		return err
+	})
+	if err != nil {
+		if err == autherr.ErrNotFound {
+			return nil, autherr.ErrNotFound
+		}
+		logger.FromContext(ctx).Error("Failed to update profile", zap.Error(err))
+		return nil, autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	return user, nil
+}
+
+func checkProfile(p *models.Profile) error {
+	for _, f := range []struct{ name, value string }{
+		{"first_name", p.FirstName},
+		{"last_name", p.LastName},
+		{"display_name", p.DisplayName},
+	} {
+		if utf8.RuneCountInString(f.value) > maxProfileNameLen || strings.ContainsFunc(f.value, unicode.IsControl) {
+			return autherr.ErrBadRequest.WithMessage(f.name + " must be at most 100 characters without control characters")
+		}
+	}
+	if p.Metadata != nil {
+		raw, err := json.Marshal(p.Metadata)
+		if err != nil {
+			return autherr.ErrBadRequest.WithMessage("metadata must be a JSON object")
+		}
+		if len(raw) > maxMetadataBytes {
+			return autherr.ErrBadRequest.WithMessage("metadata must be at most 16 KiB")
+		}
+	}
+	return nil
+}
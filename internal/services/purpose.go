@@ -0,0 +1,67 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/andro-kes/auth_service/internal/autherr"
+	"github.com/redis/go-redis/v9"
+)
+
+// Purposes of one-time tokens issued by IssuePurposeToken. Flows may define
+// their own; a token only redeems for the purpose it was issued for.
+const (
+	PurposeEmailVerification = "email_verification"
+	PurposePasswordReset     = "password_reset"
+)
+
+const (
+	defaultPurposeTokenTTL = 15 * time.Minute
+	maxPurposeTokenTTL     = 24 * time.Hour
+)
+
+// IssuePurposeToken returns an opaque token that redeems once, within ttl,
+// for subject (e.g. a user ID) in the flow named by purpose. A zero ttl
+// means 15 minutes. Only the token's hash is stored.
+func (s *TokenService) IssuePurposeToken(ctx context.Context, purpose, subject string, ttl time.Duration) (string, time.Time, error) {
+	if purpose == "" || subject == "" {
+		return "", time.Time{}, autherr.ErrBadRequest.WithMessage("purpose and subject are required")
+	}
+	if ttl == 0 {
+		ttl = defaultPurposeTokenTTL
+	}
+	if ttl < 0 || ttl > maxPurposeTokenTTL {
+		return "", time.Time{}, autherr.ErrBadRequest.WithMessage("ttl must be at most 24h")
+	}
+
+	token, err := randomBase64(s.crypto.Rand(), 32)
+	if err != nil {
+		return "", time.Time{}, autherr.ErrTokenGeneration.WithMessage(err.Error())
+	}
+	if err := s.rdb.Set(ctx, purposeTokenKey(purpose, token), subject, ttl).Err(); err != nil {
+		return "", time.Time{}, autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	return token, s.now().Add(ttl), nil
+}
+
+// ConsumePurposeToken redeems a token issued for purpose and returns its
+// subject. The token is deleted in the same step, so a second attempt, an
+// expired token or one issued for another purpose fail with ErrInvalidToken.
+func (s *TokenService) ConsumePurposeToken(ctx context.Context, purpose, token string) (string, error) {
+	if purpose == "" || token == "" {
+		return "", autherr.ErrInvalidToken
+	}
+	subject, err := s.rdb.GetDel(ctx, purposeTokenKey(purpose, token)).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", autherr.ErrInvalidToken
+	}
+	if err != nil {
+		return "", autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	return subject, nil
+}
+
+func purposeTokenKey(purpose, token string) string {
+	return "purpose:" + purpose + ":" + sha256Hex(token)
+}
@@ -0,0 +1,44 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/andro-kes/auth_service/internal/autherr"
+)
+
+func TestPurposeTokens(t *testing.T) {
+	svc, srv := newTestTokenService(t)
+
+	ctx := t.Context()
+	if _, _, err := svc.IssuePurposeToken(ctx, PurposePasswordReset, "alice", 48*time.Hour); err == nil {
+		t.Fatal("expected an overlong ttl to be rejected")
+	}
+
+	token, exp, err := svc.IssuePurposeToken(ctx, PurposePasswordReset, "alice", 0)
+	if err != nil {
+		t.Fatalf("IssuePurposeToken failed: %v", err)
+	}
+	if d := time.Until(exp); d <= 14*time.Minute || d > 15*time.Minute {
+		t.Fatalf("expected the default ttl, got %v", d)
+	}
+
+	if _, err := svc.ConsumePurposeToken(ctx, PurposeEmailVerification, token); err != autherr.ErrInvalidToken {
+		t.Fatalf("expected a token of another purpose to be rejected, got %v", err)
+	}
+	if subject, err := svc.ConsumePurposeToken(ctx, PurposePasswordReset, token); err != nil || subject != "alice" {
+		t.Fatalf("ConsumePurposeToken = %q, %v", subject, err)
+	}
+	if _, err := svc.ConsumePurposeToken(ctx, PurposePasswordReset, token); err != autherr.ErrInvalidToken {
+		t.Fatalf("expected a consumed token to be rejected, got %v", err)
+	}
+
+	expiring, _, err := svc.IssuePurposeToken(ctx, PurposeEmailVerification, "bob", time.Minute)
+	if err != nil {
+		t.Fatalf("IssuePurposeToken failed: %v", err)
+	}
+	srv.FastForward(2 * time.Minute)
+	if _, err := svc.ConsumePurposeToken(ctx, PurposeEmailVerification, expiring); err != autherr.ErrInvalidToken {
+		t.Fatalf("expected an expired token to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,120 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/andro-kes/auth_service/internal/autherr"
+	"github.com/andro-kes/auth_service/internal/logger"
+	"github.com/andro-kes/auth_service/internal/repo"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// passwordChangeTTL bounds how long a password change time read from
+// Postgres is cached in Redis. PasswordChanged overwrites the cached value.
+const passwordChangeTTL = 10 * time.Minute
+
+// WithPasswordChanges rejects access tokens issued before the user's last
+// password change, read from users and cached in Redis, so that a new
+// password also locks out whoever holds the old tokens.
+func WithPasswordChanges(users repo.UserRepo) Option {
+	return func(s *TokenService) {
+		s.passwordChanges = users
+	}
+}
+
+// PasswordChanged forces userID to authenticate again after a password
+// change: access tokens issued before the change are rejected from now on and
+// all of their sessions are revoked.
+func (s *TokenService) PasswordChanged(ctx context.Context, userID string) error {
+	if s.passwordChanges != nil {
+		at, err := s.passwordChanges.PasswordChangedAt(ctx, userID)
+		if err != nil {
+			if err == autherr.ErrNotFound {
+				return autherr.ErrNotFound
+			}
+			return autherr.ErrStorageError.WithMessage(err.Error())
+		}
+		if err := s.rdb.Set(ctx, passwordChangeKey(userID), changeUnix(at), passwordChangeTTL).Err(); err != nil {
+			// validation would keep trusting the stale cached time
+			return autherr.ErrStorageError.WithMessage(err.Error())
+		}
+		if s.cache != nil {
+			s.cache.Purge()
+		}
+		payload, err := json.Marshal(revocationMessage{UserID: userID, PasswordChanged: changeUnix(at)})
+		if err != nil {
+			return autherr.ErrStorageError.WithMessage(err.Error())
+		}
+		if err := s.rdb.Publish(ctx, revocationChannel, payload).Err(); err != nil {
+			logger.FromContext(ctx).Warn("Failed to publish password change", zap.Error(err))
+		}
+	}
+	if _, err := s.RevokeAllSessions(ctx, userID, ""); err != nil {
+		return err
+	}
+	return nil
+}
+
+// passwordChangedAt returns when userID last changed their password, or the
+// zero time.
+func (s *TokenService) passwordChangedAt(ctx context.Context, userID string) (time.Time, error) {
+	key := passwordChangeKey(userID)
+	cached, err := s.rdb.Get(ctx, key).Result()
+	if err == nil {
+		if sec, err := strconv.ParseInt(cached, 10, 64); err == nil {
+			if sec == 0 {
+				return time.Time{}, nil
+			}
+			return time.Unix(sec, 0).UTC(), nil
+		}
+	} else if !errors.Is(err, redis.Nil) && !s.redisDown() {
+		return time.Time{}, autherr.ErrStorageError.WithMessage(err.Error())
+	}
+
+	at, err := s.passwordChanges.PasswordChangedAt(ctx, userID)
+	if err != nil && err != autherr.ErrNotFound {
+		return time.Time{}, autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	if s.redisDown() {
+		return at, nil
+	}
+	if err := s.rdb.Set(ctx, key, changeUnix(at), passwordChangeTTL).Err(); err != nil {
+		logger.FromContext(ctx).Warn("Failed to cache password change", zap.Error(err))
+	}
+	return at, nil
+}
+
+// checkPasswordChange rejects tokens issued before the user's last password
+// change. Like "iat" the change time is compared in whole seconds, so tokens
+// issued in the second of the change are still accepted.
+func (s *TokenService) checkPasswordChange(ctx context.Context, claims *tokenClaims) error {
+	if s.passwordChanges == nil || claims.IssuedAt == nil ||
+		claims.SubType == SubjectServiceAccount || claims.SubType == SubjectClient {
+		return nil
+	}
+	at, err := s.passwordChangedAt(ctx, claims.UserID)
+	if err != nil {
+		return err
+	}
+	if !at.IsZero() && claims.IssuedAt.Unix() < at.Unix() {
+		return autherr.ErrInvalidToken.WithMessage("token issued before the password was changed")
+	}
+	return nil
+}
+
+// changeUnix is the Unix time of a password change, 0 for never.
+func changeUnix(at time.Time) int64 {
+	if at.IsZero() {
+		return 0
+	}
+	return at.Unix()
+}
+
+func passwordChangeKey(userID string) string {
+	return "user:pwd:" + userID
+}
@@ -0,0 +1,50 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/andro-kes/auth_service/internal/tokencache"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestPasswordChanged(t *testing.T) {
+	clock := &fixedClock{t: time.Now().Add(-10 * time.Second)}
+	users := &testUserRepo{}
+	svc, _ := newTestTokenService(t, WithClock(clock), WithPasswordChanges(users), WithValidationCache(tokencache.New(16, time.Minute)))
+
+	ctx := t.Context()
+	access, refresh, _, _, err := svc.GenerateTokens(ctx, "alice")
+	if err != nil {
+		t.Fatalf("GenerateTokens failed: %v", err)
+	}
+	if _, err := svc.ValidateAccess(access); err != nil {
+		t.Fatalf("ValidateAccess failed: %v", err)
+	}
+
+	if err := users.ChangePassword(ctx, nil, "alice", "new-hash"); err != nil {
+		t.Fatalf("ChangePassword failed: %v", err)
+	}
+	if err := svc.PasswordChanged(ctx, "alice"); err != nil {
+		t.Fatalf("PasswordChanged failed: %v", err)
+	}
+	if _, err := svc.ValidateAccess(access); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected Unauthenticated for a token issued before the change, got %v", err)
+	}
+	if in, err := svc.Introspect(ctx, access); err != nil || in.Active {
+		t.Fatalf("expected a token issued before the change to be inactive, got %+v, %v", in, err)
+	}
+	if _, _, _, _, err := svc.RotateRefresh(ctx, refresh, ""); err == nil {
+		t.Fatal("expected the session to be revoked")
+	}
+
+	clock.t = time.Now().Add(time.Second)
+	fresh, _, _, _, err := svc.GenerateTokens(ctx, "alice")
+	if err != nil {
+		t.Fatalf("GenerateTokens failed: %v", err)
+	}
+	if _, err := svc.ValidateAccess(fresh); err != nil {
+		t.Fatalf("ValidateAccess failed for a token issued after the change: %v", err)
+	}
+}
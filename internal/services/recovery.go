@@ -0,0 +1,229 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/andro-kes/auth_service/internal/autherr"
+	"github.com/andro-kes/auth_service/internal/logger"
+	"github.com/andro-kes/auth_service/internal/mail"
+	"github.com/andro-kes/auth_service/internal/models"
+	"github.com/andro-kes/auth_service/internal/repo"
+	"github.com/andro-kes/auth_service/internal/repo/db"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+)
+
+// Audit event types of the recovery email lifecycle.
+const (
+	AuditRecoveryEmailSet          = "recovery_email.set"
+	AuditRecoveryEmailVerified     = "recovery_email.verified"
+	AuditRecoveryEmailVerifyFailed = "recovery_email.verify_failed"
+	AuditRecoveryEmailRemoved      = "recovery_email.removed"
+)
+
+const (
+	recoveryCodeTTL         = 30 * time.Minute
+	recoveryCodeMaxAttempts = 5
+)
+
+// RecoveryService manages the secondary email used only by the password
+// reset and account unlock flows.
+type RecoveryService struct {
+	Users  repo.UserRepo
+	Emails repo.RecoveryEmailRepo
+	Audit  repo.AuditRepo
+	Tx     db.Tx
+	Mail   mail.Sender
+}
+
+func NewRecoveryService(ctx context.Context, pool *pgxpool.Pool, sender mail.Sender) *RecoveryService {
+	return &RecoveryService{
+		Users:  repo.NewUserRepo(ctx, pool),
+		Emails: repo.NewRecoveryEmailRepo(ctx, pool),
+		Audit:  repo.NewAuditRepo(ctx, pool),
+		Tx:     db.NewTx(pool),
+		Mail:   sender,
+	}
+}
+
+// SetRecoveryEmail replaces the user's recovery email with an unverified
+// address and mails it a verification code. It returns the code expiry.
+func (rs *RecoveryService) SetRecoveryEmail(ctx context.Context, userID, email string, client ClientInfo) (time.Time, error) {
+	email, err := normalizeEmail(email)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	user, err := rs.Users.FindByID(ctx, userID)
+	if err != nil {
+		if err == autherr.ErrNotFound {
+			return time.Time{}, autherr.ErrNotFound
+		}
+		return time.Time{}, autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	if strings.EqualFold(user.Username, email) || user.Email == email {
+		return time.Time{}, autherr.ErrBadRequest.WithMessage("recovery email must differ from the login email")
+	}
+
+	code, err := verificationCode()
+	if err != nil {
+		return time.Time{}, autherr.ErrTokenGeneration.WithMessage(err.Error())
+	}
+	expires := time.Now().Add(recoveryCodeTTL)
+
+	err = rs.Tx.RunInTx(ctx, func(ctx context.Context, q db.Querier) error {
+		if err := rs.Emails.SetPending(ctx, q, userID, email, sha256Hex(code), expires); err != nil {
+			return err
+		}
+		return rs.Audit.Insert(ctx, q, auditEvent(AuditRecoveryEmailSet, userID, client, map[string]string{"email": email}))
+	})
+	if err != nil {
+		logger.FromContext(ctx).Error("Failed to store recovery email", zap.Error(err))
+		return time.Time{}, autherr.ErrStorageError.WithMessage(err.Error())
+	}
+
+	msg := mail.Message{
+		To:      email,
+		Subject: "Confirm your recovery email",
+		Body: fmt.Sprintf("Your verification code is %s.\n\nIt expires in %d minutes. "+
+			"If you did not request this, ignore this message.\n", code, int(recoveryCodeTTL.Minutes())),
+	}
+	if err := rs.Mail.Send(ctx, msg); err != nil {
+		logger.FromContext(ctx).Error("Failed to send recovery email verification", zap.Error(err))
+		return time.Time{}, autherr.ErrDelivery
+	}
+	return expires, nil
+}
+
+// VerifyRecoveryEmail confirms the pending recovery email with the code sent
+// to it. After recoveryCodeMaxAttempts wrong codes a new code is required.
+func (rs *RecoveryService) VerifyRecoveryEmail(ctx context.Context, userID, code string, client ClientInfo) error {
+	rec, err := rs.get(ctx, userID)
+	if err != nil {
+		return err
+	}
+	switch {
+	case rec.CodeHash == "" || rec.CodeExpiresAt == nil:
+		return autherr.ErrBadRequest.WithMessage("no verification pending")
+	case time.Now().After(*rec.CodeExpiresAt):
+		return autherr.ErrBadRequest.WithMessage("verification code expired")
+	case rec.Attempts >= recoveryCodeMaxAttempts:
+		return autherr.ErrForbidden.WithMessage("too many attempts, request a new code")
+	}
+
+	ok := subtle.ConstantTimeCompare([]byte(sha256Hex(strings.TrimSpace(code))), []byte(rec.CodeHash)) == 1
+	err = rs.Tx.RunInTx(ctx, func(ctx context.Context, q db.Querier) error {
+		if !ok {
+			if err := rs.Emails.IncrementAttempts(ctx, q, userID); err != nil {
+				return err
+			}
+			return rs.Audit.Insert(ctx, q, auditEvent(AuditRecoveryEmailVerifyFailed, userID, client, nil))
+		}
+		if err := rs.Emails.MarkVerified(ctx, q, userID); err != nil {
+			return err
+		}
+		return rs.Audit.Insert(ctx, q, auditEvent(AuditRecoveryEmailVerified, userID, client, map[string]string{"email": rec.Email}))
+	})
+	if err != nil {
+		logger.FromContext(ctx).Error("Failed to update recovery email", zap.Error(err))
+		return autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	if !ok {
+		return autherr.ErrBadRequest.WithMessage("invalid verification code")
+	}
+	return nil
+}
+
+// GetRecoveryEmail returns the user's recovery email, verified or not.
+func (rs *RecoveryService) GetRecoveryEmail(ctx context.Context, userID string) (*models.RecoveryEmail, error) {
+	return rs.get(ctx, userID)
+}
+
+// RemoveRecoveryEmail deletes the user's recovery email.
+func (rs *RecoveryService) RemoveRecoveryEmail(ctx context.Context, userID string, client ClientInfo) error {
+	var found bool
+	err := rs.Tx.RunInTx(ctx, func(ctx context.Context, q db.Querier) error {
+		var err error
+		if found, err = rs.Emails.Delete(ctx, q, userID); err != nil || !found {
+			return err
+		}
+		return rs.Audit.Insert(ctx, q, auditEvent(AuditRecoveryEmailRemoved, userID, client, nil))
+	})
+	if err != nil {
+		logger.FromContext(ctx).Error("Failed to remove recovery email", zap.Error(err))
+		return autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	if !found {
+		return autherr.ErrNotFound
+	}
+	return nil
+}
+
+// RecoveryAddress returns the verified recovery email that password reset
+// and account unlock messages must be sent to. Unverified addresses are never
+// returned.
+func (rs *RecoveryService) RecoveryAddress(ctx context.Context, userID string) (string, error) {
+	rec, err := rs.get(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+	if !rec.Verified() {
+		return "", autherr.ErrNotFound.WithMessage("no verified recovery email")
+	}
+	return rec.Email, nil
+}
+
+// ContactAddress returns the address password reset and account unlock
+// messages for user go to: the verified recovery email, or else the primary
+// email, which may be empty.
+func (rs *RecoveryService) ContactAddress(ctx context.Context, user *models.User) (string, error) {
+	rec, err := rs.get(ctx, user.ID)
+	if err != nil {
+		if err == autherr.ErrNotFound {
+			return user.Email, nil
+		}
+		return "", err
+	}
+	if !rec.Verified() {
+		return user.Email, nil
+	}
+	return rec.Email, nil
+}
+
+func (rs *RecoveryService) get(ctx context.Context, userID string) (*models.RecoveryEmail, error) {
+	rec, err := rs.Emails.Get(ctx, userID)
+	if err != nil {
+		if err == autherr.ErrNotFound {
+			return nil, autherr.ErrNotFound
+		}
+		logger.FromContext(ctx).Error("Failed to get recovery email", zap.Error(err))
+		return nil, autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	return rec, nil
+}
+
+func auditEvent(typ, userID string, client ClientInfo, details map[string]string) *models.AuditEvent {
+	return &models.AuditEvent{
+		Type:      typ,
+		UserID:    userID,
+		IP:        client.IP,
+		UserAgent: client.UserAgent,
+		Details:   details,
+		CreatedAt: time.Now(),
+	}
+}
+
+// verificationCode returns a random 6-digit code.
+func verificationCode() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1_000_000))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%06d", n.Int64()), nil
+}
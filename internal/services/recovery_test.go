@@ -0,0 +1,171 @@
+package services
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/andro-kes/auth_service/internal/autherr"
+	"github.com/andro-kes/auth_service/internal/mail"
+	"github.com/andro-kes/auth_service/internal/models"
+	"github.com/andro-kes/auth_service/internal/repo/db"
+)
+
+type testRecoveryRepo struct {
+	rows map[string]*models.RecoveryEmail
+}
+
+func (r *testRecoveryRepo) Get(ctx context.Context, userID string) (*models.RecoveryEmail, error) {
+	rec, ok := r.rows[userID]
+	if !ok {
+		return nil, autherr.ErrNotFound
+	}
+	cp := *rec
+	return &cp, nil
+}
+
+func (r *testRecoveryRepo) SetPending(ctx context.Context, q db.Querier, userID, email, codeHash string, codeExpiresAt time.Time) error {
+	r.rows[userID] = &models.RecoveryEmail{UserID: userID, Email: email, CodeHash: codeHash, CodeExpiresAt: &codeExpiresAt}
+	return nil
+}
+
+func (r *testRecoveryRepo) MarkVerified(ctx context.Context, q db.Querier, userID string) error {
+	now := time.Now()
+	rec := r.rows[userID]
+	rec.VerifiedAt, rec.CodeHash, rec.CodeExpiresAt, rec.Attempts = &now, "", nil, 0
+	return nil
+}
+
+func (r *testRecoveryRepo) IncrementAttempts(ctx context.Context, q db.Querier, userID string) error {
+	r.rows[userID].Attempts++
+	return nil
+}
+
+func (r *testRecoveryRepo) Delete(ctx context.Context, q db.Querier, userID string) (bool, error) {
+	_, ok := r.rows[userID]
+	delete(r.rows, userID)
+	return ok, nil
+}
+
+type testAuditRepo struct {
+	events []string
+	stored []models.AuditEvent
+}
+
+func (a *testAuditRepo) Insert(ctx context.Context, q db.Querier, event *models.AuditEvent) error {
+	a.events = append(a.events, event.Type)
+	a.stored = append(a.stored, *event)
+	return nil
+}
+
+func (a *testAuditRepo) ListByUser(ctx context.Context, userID string) ([]models.AuditEvent, error) {
+	var events []models.AuditEvent
+	for _, e := range a.stored {
+		if e.UserID == userID {
+			events = append(events, e)
+		}
+	}
+	return events, nil
+}
+
+func (a *testAuditRepo) Anonymize(ctx context.Context, q db.Querier, userID string) error {
+	for i, e := range a.stored {
+		if e.UserID == userID {
+			a.stored[i] = models.AuditEvent{Type: e.Type, UserID: e.UserID, CreatedAt: e.CreatedAt}
+		}
+	}
+	return nil
+}
+
+type testMailer struct {
+	sent []mail.Message
+}
+
+func (m *testMailer) Send(ctx context.Context, msg mail.Message) error {
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+var codePattern = regexp.MustCompile(`\b\d{6}\b`)
+
+func TestRecoveryEmailLifecycle(t *testing.T) {
+	ctx := context.Background()
+	audit := &testAuditRepo{}
+	mailer := &testMailer{}
+	rs := &RecoveryService{
+		Users:  &testUserRepo{},
+		Emails: &testRecoveryRepo{rows: map[string]*models.RecoveryEmail{}},
+		Audit:  audit,
+		Tx:     &fakeTx{},
+		Mail:   mailer,
+	}
+
+	if _, err := rs.SetRecoveryEmail(ctx, "u1", "user-u1", ClientInfo{}); err == nil {
+		t.Fatal("expected an invalid address to be rejected")
+	}
+	if _, err := rs.SetRecoveryEmail(ctx, "u1", "Backup@Example.com", ClientInfo{IP: "10.0.0.1"}); err != nil {
+		t.Fatalf("SetRecoveryEmail failed: %v", err)
+	}
+	if len(mailer.sent) != 1 || mailer.sent[0].To != "backup@example.com" {
+		t.Fatalf("expected a verification mail to backup@example.com, got %+v", mailer.sent)
+	}
+	code := codePattern.FindString(mailer.sent[0].Body)
+	if code == "" {
+		t.Fatalf("no code in mail body: %q", mailer.sent[0].Body)
+	}
+
+	if _, err := rs.RecoveryAddress(ctx, "u1"); err == nil {
+		t.Fatal("expected an unverified address not to be used for recovery")
+	}
+	if err := rs.VerifyRecoveryEmail(ctx, "u1", "not-the-code", ClientInfo{}); err == nil {
+		t.Fatal("expected a wrong code to be rejected")
+	}
+	if err := rs.VerifyRecoveryEmail(ctx, "u1", code, ClientInfo{}); err != nil {
+		t.Fatalf("VerifyRecoveryEmail failed: %v", err)
+	}
+	addr, err := rs.RecoveryAddress(ctx, "u1")
+	if err != nil || addr != "backup@example.com" {
+		t.Fatalf("expected verified recovery address, got %q, %v", addr, err)
+	}
+
+	if err := rs.RemoveRecoveryEmail(ctx, "u1", ClientInfo{}); err != nil {
+		t.Fatalf("RemoveRecoveryEmail failed: %v", err)
+	}
+	if err := rs.RemoveRecoveryEmail(ctx, "u1", ClientInfo{}); err != autherr.ErrNotFound {
+		t.Fatalf("expected ErrNotFound on second removal, got %v", err)
+	}
+
+	want := []string{AuditRecoveryEmailSet, AuditRecoveryEmailVerifyFailed, AuditRecoveryEmailVerified, AuditRecoveryEmailRemoved}
+	if len(audit.events) != len(want) {
+		t.Fatalf("expected audit events %v, got %v", want, audit.events)
+	}
+	for i := range want {
+		if audit.events[i] != want[i] {
+			t.Fatalf("expected audit events %v, got %v", want, audit.events)
+		}
+	}
+}
+
+func TestRecoveryEmailAttemptLimit(t *testing.T) {
+	ctx := context.Background()
+	mailer := &testMailer{}
+	rs := &RecoveryService{
+		Users:  &testUserRepo{},
+		Emails: &testRecoveryRepo{rows: map[string]*models.RecoveryEmail{}},
+		Audit:  &testAuditRepo{},
+		Tx:     &fakeTx{},
+		Mail:   mailer,
+	}
+
+	if _, err := rs.SetRecoveryEmail(ctx, "u1", "backup@example.com", ClientInfo{}); err != nil {
+		t.Fatalf("SetRecoveryEmail failed: %v", err)
+	}
+	code := codePattern.FindString(mailer.sent[0].Body)
+	for i := 0; i < recoveryCodeMaxAttempts; i++ {
+		_ = rs.VerifyRecoveryEmail(ctx, "u1", "wrong", ClientInfo{})
+	}
+	if err := rs.VerifyRecoveryEmail(ctx, "u1", code, ClientInfo{}); err == nil {
+		t.Fatal("expected the right code to be refused after too many attempts")
+	}
+}
@@ -0,0 +1,80 @@
+package services
+
+import "context"
+
+// A cluster rejects scripts touching keys of different hash slots, and the
+// keys of a refresh token, its successor and the user's session index
+// generally live in different slots. On a cluster, rotation and logout
+// everywhere therefore fall back to single-key steps: a refresh token is
+// still consumed exactly once, but a rotation racing with RevokeAllSessions
+// may leave the new token in place, and concurrent logins may exceed the
+// cap on sessions.
+
+// consumeScript deletes the refresh token KEYS[1] if it belongs to ARGV[1].
+var consumeScript = `
+if redis.call("EXISTS", KEYS[1]) == 0 then
+  return {err="old_not_found"}
+end
+local uid = redis.call("HGET", KEYS[1], "user_id")
+if ARGV[1] ~= "" and uid ~= ARGV[1] then
+  return {err="user_mismatch"}
+end
+redis.call("DEL", KEYS[1])
+return {ok="ok"}
+`
+
+// rotateClustered is the cluster-safe counterpart of rotateScript. The new
+// token has already been stored by issue.
+func (s *TokenService) rotateClustered(ctx context.Context, oldKey, userID, sessionID, newHash string) error {
+	if err := s.rdb.Eval(ctx, consumeScript, []string{oldKey}, userID).Err(); err != nil {
+		return err
+	}
+	if sessionID == "" {
+		return nil
+	}
+	return s.indexSession(ctx, userID, sessionID, newHash)
+}
+
+// revokeAllClustered is the cluster-safe counterpart of revokeAllScript.
+func (s *TokenService) revokeAllClustered(ctx context.Context, userID, keep string) (int, error) {
+	indexKey := userSessionsKey(userID)
+	index, err := s.rdb.HGetAll(ctx, indexKey).Result()
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for sid, hash := range index {
+		if sid == keep {
+			continue
+		}
+		deleted, err := s.rdb.Del(ctx, redisKey(hash)).Result()
+		if err != nil {
+			return n, err
+		}
+		n += int(deleted)
+		if err := s.rdb.HDel(ctx, indexKey, sid).Err(); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// indexCappedClustered is the cluster-safe counterpart of indexCappedScript.
+func (s *TokenService) indexCappedClustered(ctx context.Context, userID, sessionID, hash string, max int) (bool, error) {
+	index, err := s.rdb.HGetAll(ctx, userSessionsKey(userID)).Result()
+	if err != nil {
+		return false, err
+	}
+	live := 0
+	for _, h := range index {
+		n, err := s.rdb.Exists(ctx, redisKey(h)).Result()
+		if err != nil {
+			return false, err
+		}
+		live += int(n)
+	}
+	if live >= max {
+		return false, nil
+	}
+	return true, s.indexSession(ctx, userID, sessionID, hash)
+}
@@ -0,0 +1,84 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/andro-kes/auth_service/internal/autherr"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestClusterMode(t *testing.T) {
+	srv, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("failed to start miniredis: %v", err)
+	}
+	defer srv.Close()
+
+	rdb := redis.NewClusterClient(&redis.ClusterOptions{Addrs: []string{srv.Addr()}})
+	defer rdb.Close()
+	svc, err := NewTokenService(rdb, "012345678901234567890123456789ab", time.Minute, time.Minute*5)
+	if err != nil {
+		t.Fatalf("failed to create TokenService: %v", err)
+	}
+	if !svc.cluster {
+		t.Fatal("expected a cluster client")
+	}
+
+	ctx := t.Context()
+	_, refresh, _, _, err := svc.GenerateTokens(ctx, "user-123")
+	if err != nil {
+		t.Fatalf("GenerateTokens failed: %v", err)
+	}
+	_, rotated, _, _, err := svc.RotateRefresh(ctx, refresh, "user-123")
+	if err != nil {
+		t.Fatalf("RotateRefresh failed: %v", err)
+	}
+	if _, _, _, _, err := svc.RotateRefresh(ctx, refresh, "user-123"); err != autherr.ErrInvalidToken {
+		t.Fatalf("expected the old token to be consumed, got %v", err)
+	}
+	if _, _, _, _, err := svc.RotateRefresh(ctx, rotated, "someone-else"); err != autherr.ErrInvalidToken {
+		t.Fatalf("expected a user mismatch to be rejected, got %v", err)
+	}
+
+	sessions, err := svc.ListSessions(ctx, "user-123")
+	if err != nil || len(sessions) != 1 {
+		t.Fatalf("expected one session, got %d, %v", len(sessions), err)
+	}
+	if n, err := svc.RevokeAllSessions(ctx, "user-123", ""); err != nil || n != 1 {
+		t.Fatalf("RevokeAllSessions = %d, %v; want 1", n, err)
+	}
+	if _, err := svc.ValidateRefresh(ctx, rotated); err != autherr.ErrInvalidToken {
+		t.Fatalf("expected the session to be revoked, got %v", err)
+	}
+}
+
+func TestRedisAuthAndDB(t *testing.T) {
+	srv, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("failed to start miniredis: %v", err)
+	}
+	defer srv.Close()
+	srv.RequireUserAuth("auth-service", "s3cret")
+
+	anon := redis.NewClient(&redis.Options{Addr: srv.Addr(), DB: 3})
+	defer anon.Close()
+	if _, err := NewTokenService(anon, "012345678901234567890123456789ab", time.Minute, time.Minute*5); err == nil {
+		t.Fatal("expected unauthenticated connection to fail")
+	}
+
+	rdb := redis.NewClient(&redis.Options{Addr: srv.Addr(), DB: 3, Username: "auth-service", Password: "s3cret"})
+	defer rdb.Close()
+	svc, err := NewTokenService(rdb, "012345678901234567890123456789ab", time.Minute, time.Minute*5)
+	if err != nil {
+		t.Fatalf("failed to create TokenService: %v", err)
+	}
+
+	if _, _, _, _, err := svc.GenerateTokens(t.Context(), "user-123"); err != nil {
+		t.Fatalf("GenerateTokens failed: %v", err)
+	}
+	if len(srv.DB(3).Keys()) == 0 || len(srv.DB(0).Keys()) != 0 {
+		t.Fatalf("expected keys in database 3 only, got %v and %v", srv.DB(3).Keys(), srv.DB(0).Keys())
+	}
+}
@@ -0,0 +1,263 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/andro-kes/auth_service/internal/autherr"
+	"github.com/andro-kes/auth_service/internal/paseto"
+	"github.com/redis/go-redis/v9"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// referencePrefix marks opaque reference access tokens. Their claims live in
+// Redis and are resolved on validation or through Introspect.
+const referencePrefix = "ref_"
+
+// Token types reported by Introspect, as registered for RFC 7662.
+const (
+	TokenTypeAccess  = "access_token"
+	TokenTypeRefresh = "refresh_token"
+)
+
+// AsReferenceToken issues the access token as an opaque reference instead of
+// a JWT, for clients whose gateways cannot carry large claim sets in headers.
+func AsReferenceToken() IssueOption {
+	return func(p *issueParams) {
+		p.reference = true
+	}
+}
+
+// WithOpaqueAccessTokens issues every access token as a reference, for
+// deployments that want instant revocation and no client-side parsing.
+func WithOpaqueAccessTokens() Option {
+	return func(s *TokenService) {
+		s.opaqueAccess = true
+	}
+}
+
+// encodeAccess signs claims as a JWT or PASETO or, for reference tokens,
+// stores them until the token expires and returns the opaque reference.
+func (s *TokenService) encodeAccess(ctx context.Context, claims tokenClaims, reference bool) (string, error) {
+	if claims.Issuer == "" {
+		claims.Issuer = s.issuer
+	}
+	if !reference && !s.opaqueAccess {
+		return s.encodeSelfContained(claims)
+	}
+
+	raw, err := randomBase64(s.crypto.Rand(), 32)
+	if err != nil {
+		return "", autherr.ErrTokenGeneration.WithMessage(err.Error())
+	}
+	ref := referencePrefix + raw
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", autherr.ErrTokenGeneration.WithMessage(err.Error())
+	}
+	if err := s.rdb.Set(ctx, referenceKey(ref), payload, claims.ExpiresAt.Time.Sub(s.now())).Err(); err != nil {
+		return "", autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	return ref, nil
+}
+
+// encodeSelfContained signs claims as a JWT or, when configured, a PASETO.
+func (s *TokenService) encodeSelfContained(claims tokenClaims) (string, error) {
+	if claims.Issuer == "" {
+		claims.Issuer = s.issuer
+	}
+	if s.paseto != nil {
+		return s.paseto.encode(s.crypto.Rand(), claims)
+	}
+	signed, err := s.keys.Signer().Sign(claims)
+	if err != nil {
+		return "", autherr.ErrTokenGeneration.WithMessage(err.Error())
+	}
+	return signed, nil
+}
+
+// accessClaims parses a JWT or PASETO access token or resolves a reference
+// token.
+func (s *TokenService) accessClaims(ctx context.Context, tokenStr string) (*tokenClaims, error) {
+	if paseto.IsToken(tokenStr) {
+		if s.paseto == nil {
+			return nil, autherr.ErrInvalidToken
+		}
+		return s.paseto.decode(tokenStr, s.now(), s.leeway)
+	}
+	if !isReference(tokenStr) {
+		return s.parseAndMapErr(tokenStr)
+	}
+	payload, err := s.rdb.Get(ctx, referenceKey(tokenStr)).Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, autherr.ErrInvalidToken
+		}
+		return nil, autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	var claims tokenClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, autherr.ErrInvalidToken
+	}
+	if claims.ExpiresAt == nil || !s.now().Before(claims.ExpiresAt.Time) {
+		return nil, autherr.ErrTokenExpired
+	}
+	return &claims, nil
+}
+
+// Introspection is the RFC 7662 view of an access token. Only Active is set
+// for tokens that are invalid, expired or revoked.
+type Introspection struct {
+	Active      bool
+	TokenType   string
+	UserID      string
+	Scope       string
+	SessionID   string
+	SubjectType string
+	JTI         string
+	DPoPJKT     string
+	OneTime     bool
+	Roles       []string
+	Metadata    map[string]any
+	Actors      []string
+	IssuedAt    time.Time
+	ExpiresAt   time.Time
+}
+
+// Introspect returns the claims of a JWT, reference or refresh token. It
+// does not consume one-time tokens; that is up to the resource server.
+// DPoP-bound access tokens come back inactive, as they need a proof; see
+// IntrospectForRequest.
+func (s *TokenService) Introspect(ctx context.Context, tokenStr string) (Introspection, error) {
+	return s.IntrospectForRequest(ctx, tokenStr, DPoPRequest{})
+}
+
+// IntrospectForRequest is Introspect for a token a resource server received
+// with req: DPoP-bound access tokens are only active with a valid proof of
+// their key created for that request.
+func (s *TokenService) IntrospectForRequest(ctx context.Context, tokenStr string, req DPoPRequest) (Introspection, error) {
+	claims, err := s.accessClaims(ctx, tokenStr)
+	if err == autherr.ErrInvalidToken && !isReference(tokenStr) {
+		return s.introspectRefresh(ctx, tokenStr)
+	}
+	if tokenRejected(err) {
+		return Introspection{}, nil
+	}
+	if err != nil {
+		return Introspection{}, err
+	}
+	in, err := s.activeAccess(ctx, claims)
+	if err == nil {
+		err = s.checkBinding(ctx, claims, tokenStr, req)
+	}
+	if tokenRejected(err) {
+		return Introspection{}, nil
+	}
+	return in, err
+}
+
+// tokenRejected reports whether err rejects a token, e.g. as invalid,
+// revoked or belonging to a disabled account, rather than failing to check
+// it. Introspection reports such tokens as inactive.
+func tokenRejected(err error) bool {
+	switch status.Code(err) {
+	case codes.Unauthenticated, codes.PermissionDenied:
+		return true
+	}
+	return false
+}
+
+// activeAccess checks that verified access token claims were not revoked
+// and returns their introspection, or the error rejecting them.
+func (s *TokenService) activeAccess(ctx context.Context, claims *tokenClaims) (Introspection, error) {
+	if claims.Typ != "access" || s.isRevokedLocally(claims) {
+		return Introspection{}, autherr.ErrInvalidToken
+	}
+	if err := s.checkDenylist(ctx, claims); err != nil {
+		return Introspection{}, err
+	}
+	if err := s.checkTokenVersion(ctx, claims); err != nil {
+		return Introspection{}, err
+	}
+	if err := s.checkPasswordChange(ctx, claims); err != nil {
+		return Introspection{}, err
+	}
+	if err := s.checkUserStatus(ctx, claims.UserID); err != nil {
+		return Introspection{}, err
+	}
+	in := Introspection{
+		Active:      true,
+		TokenType:   TokenTypeAccess,
+		UserID:      claims.UserID,
+		Scope:       claims.Scope,
+		SessionID:   claims.SessionID,
+		SubjectType: claims.SubType,
+		JTI:         claims.ID,
+		OneTime:     claims.OneTime,
+		Roles:       claims.Roles,
+		Metadata:    claims.Metadata,
+		Actors:      claims.Act.chain(),
+		ExpiresAt:   claims.ExpiresAt.Time,
+	}
+	if claims.IssuedAt != nil {
+		in.IssuedAt = claims.IssuedAt.Time
+	}
+	if claims.Cnf != nil {
+		in.DPoPJKT = claims.Cnf.JKT
+	}
+	return in, nil
+}
+
+// introspectRefresh reports on a refresh token. Honeytokens trip their alarm
+// and come back inactive like any unknown token.
+func (s *TokenService) introspectRefresh(ctx context.Context, raw string) (Introspection, error) {
+	h, ok, err := s.resolveRefresh(ctx, raw)
+	if err != nil || !ok {
+		return Introspection{}, err
+	}
+	key := redisKey(h)
+	vals, err := s.rdb.HMGet(ctx, key, "user_id", "issued_at", "sid", "canary").Result()
+	if err != nil {
+		return Introspection{}, autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	if label, _ := vals[3].(string); label != "" {
+		s.tripCanary(ctx, label, h)
+		return Introspection{}, nil
+	}
+	userID, _ := vals[0].(string)
+	if userID == "" {
+		return Introspection{}, nil
+	}
+	in := Introspection{Active: true, TokenType: TokenTypeRefresh, UserID: userID}
+	in.SessionID, _ = vals[2].(string)
+	if issuedAt, _ := vals[1].(string); issuedAt != "" {
+		if sec, err := strconv.ParseInt(issuedAt, 10, 64); err == nil {
+			if s.watermarks.revoked(userID, time.Unix(sec, 0)) {
+				return Introspection{}, nil
+			}
+			in.IssuedAt = time.Unix(sec, 0)
+		}
+	}
+	ttl, err := s.rdb.PTTL(ctx, key).Result()
+	if err != nil {
+		return Introspection{}, autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	if ttl <= 0 {
+		return Introspection{}, nil
+	}
+	in.ExpiresAt = s.now().Add(ttl)
+	return in, nil
+}
+
+func isReference(tokenStr string) bool {
+	return strings.HasPrefix(tokenStr, referencePrefix)
+}
+
+func referenceKey(ref string) string {
+	return "access:ref:" + sha256Hex(ref)
+}
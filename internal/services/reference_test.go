@@ -0,0 +1,146 @@
+package services
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andro-kes/auth_service/internal/autherr"
+)
+
+func TestReferenceAccessToken(t *testing.T) {
+	svc, srv := newTestTokenService(t)
+
+	ctx := t.Context()
+	ref, _, _, _, err := svc.GenerateTokens(ctx, "alice", AsReferenceToken())
+	if err != nil {
+		t.Fatalf("GenerateTokens failed: %v", err)
+	}
+	if !strings.HasPrefix(ref, referencePrefix) || strings.Count(ref, ".") != 0 {
+		t.Fatalf("expected an opaque reference token, got %q", ref)
+	}
+	if claims, err := svc.ValidateAccess(ref); err != nil || claims.UserID != "alice" {
+		t.Fatalf("expected reference token to validate, got %+v, %v", claims, err)
+	}
+
+	in, err := svc.Introspect(ctx, ref)
+	if err != nil {
+		t.Fatalf("Introspect failed: %v", err)
+	}
+	if !in.Active || in.UserID != "alice" || in.SessionID == "" || in.JTI == "" {
+		t.Fatalf("unexpected introspection %+v", in)
+	}
+
+	if in, err := svc.Introspect(ctx, referencePrefix+"unknown"); err != nil || in.Active {
+		t.Fatalf("expected unknown reference to be inactive, got %+v, %v", in, err)
+	}
+
+	srv.FastForward(2 * time.Minute)
+	if in, err := svc.Introspect(ctx, ref); err != nil || in.Active {
+		t.Fatalf("expected expired reference to be inactive, got %+v, %v", in, err)
+	}
+}
+
+func TestIntrospectForRequest_DPoP(t *testing.T) {
+	svc, _ := newTestTokenService(t)
+	ctx := t.Context()
+
+	key, jkt := newTestKey(t)
+	access, _, _, _, err := svc.GenerateTokens(ctx, "alice", WithDPoPKey(jkt))
+	if err != nil {
+		t.Fatalf("GenerateTokens failed: %v", err)
+	}
+	const htu = "https://api.example.com/orders"
+
+	if in, err := svc.Introspect(ctx, access); err != nil || in.Active {
+		t.Fatalf("expected a DPoP-bound token without proof to be inactive, got %+v, %v", in, err)
+	}
+	in, err := svc.IntrospectForRequest(ctx, access, DPoPRequest{Proof: newTestProof(t, key, "GET", htu, access), Method: "GET", Target: htu})
+	if err != nil || !in.Active || in.DPoPJKT != jkt {
+		t.Fatalf("expected a valid proof to make the token active, got %+v, %v", in, err)
+	}
+
+	other, _ := newTestKey(t)
+	for name, req := range map[string]DPoPRequest{
+		"other key":    {Proof: newTestProof(t, other, "GET", htu, access), Method: "GET", Target: htu},
+		"other url":    {Proof: newTestProof(t, key, "GET", "https://evil.example.com/", access), Method: "GET", Target: htu},
+		"other method": {Proof: newTestProof(t, key, "GET", htu, access), Method: "DELETE", Target: htu},
+	} {
+		if in, err := svc.IntrospectForRequest(ctx, access, req); err != nil || in.Active {
+			t.Fatalf("%s: expected the token to be inactive, got %+v, %v", name, in, err)
+		}
+	}
+
+	// tokens without a binding ignore the proof
+	bearer, _, _, _, err := svc.GenerateTokens(ctx, "bob")
+	if err != nil {
+		t.Fatalf("GenerateTokens failed: %v", err)
+	}
+	if in, err := svc.Introspect(ctx, bearer); err != nil || !in.Active {
+		t.Fatalf("expected a bearer token to be active, got %+v, %v", in, err)
+	}
+}
+
+func TestIntrospectRefreshToken(t *testing.T) {
+	svc, _ := newTestTokenService(t)
+
+	ctx := t.Context()
+	access, refresh, _, _, err := svc.GenerateTokens(ctx, "alice")
+	if err != nil {
+		t.Fatalf("GenerateTokens failed: %v", err)
+	}
+
+	in, err := svc.Introspect(ctx, access)
+	if err != nil || !in.Active || in.TokenType != TokenTypeAccess {
+		t.Fatalf("expected active access token, got %+v, %v", in, err)
+	}
+
+	in, err = svc.Introspect(ctx, refresh)
+	if err != nil {
+		t.Fatalf("Introspect failed: %v", err)
+	}
+	if !in.Active || in.TokenType != TokenTypeRefresh || in.UserID != "alice" || in.SessionID == "" {
+		t.Fatalf("unexpected refresh introspection: %+v", in)
+	}
+	if d := time.Until(in.ExpiresAt); d <= 0 || d > time.Minute*5 {
+		t.Fatalf("expected expiry within refresh TTL, got %v", d)
+	}
+
+	if err := svc.RevokeRefreshByRaw(ctx, refresh); err != nil {
+		t.Fatalf("RevokeRefreshByRaw failed: %v", err)
+	}
+	if in, err := svc.Introspect(ctx, refresh); err != nil || in.Active {
+		t.Fatalf("expected revoked refresh token to be inactive, got %+v, %v", in, err)
+	}
+}
+
+func TestOpaqueAccessTokens(t *testing.T) {
+	svc, _ := newTestTokenService(t, WithOpaqueAccessTokens())
+
+	ctx := t.Context()
+	access, _, _, _, err := svc.GenerateTokens(ctx, "alice")
+	if err != nil {
+		t.Fatalf("GenerateTokens failed: %v", err)
+	}
+	if !strings.HasPrefix(access, referencePrefix) {
+		t.Fatalf("expected opaque access token, got %q", access)
+	}
+	if claims, err := svc.ValidateAccess(access); err != nil || claims.UserID != "alice" {
+		t.Fatalf("ValidateAccess = %+v, %v", claims, err)
+	}
+
+	scoped, _, err := svc.IssueScopedAccess(ctx, "alice", "files:read", 0, false)
+	if err != nil {
+		t.Fatalf("IssueScopedAccess failed: %v", err)
+	}
+	if !strings.HasPrefix(scoped, referencePrefix) {
+		t.Fatalf("expected opaque scoped token, got %q", scoped)
+	}
+
+	if err := svc.RevokeAccess(ctx, access); err != nil {
+		t.Fatalf("RevokeAccess failed: %v", err)
+	}
+	if _, err := svc.ValidateAccess(access); err != autherr.ErrInvalidToken {
+		t.Fatalf("expected revoked opaque token to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,124 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/andro-kes/auth_service/internal/autherr"
+	"github.com/andro-kes/auth_service/internal/logger"
+	"github.com/andro-kes/auth_service/internal/models"
+	"github.com/andro-kes/auth_service/internal/repo"
+	"go.uber.org/zap"
+)
+
+// WithRefreshStore makes store the source of truth for refresh tokens: every
+// token is written to it as well as to Redis, and tokens missing from Redis,
+// e.g. after a flush, are restored from it on first use. Redis stays the
+// fast path.
+func WithRefreshStore(store repo.RefreshTokenRepo) Option {
+	return func(s *TokenService) {
+		s.refreshStore = store
+	}
+}
+
+// persistRefresh writes the durable copy of a refresh token.
+func (s *TokenService) persistRefresh(ctx context.Context, hash, userID, sessionID string, fields map[string]any, expiresAt time.Time) error {
+	if s.refreshStore == nil {
+		return nil
+	}
+	stored := make(map[string]string, len(fields))
+	for k, v := range fields {
+		stored[k] = fmt.Sprint(v)
+	}
+	err := s.refreshStore.Save(ctx, &models.RefreshToken{
+		Hash:      hash,
+		UserID:    userID,
+		SessionID: sessionID,
+		Fields:    stored,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		return autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	return nil
+}
+
+// forgetRefresh deletes the durable copies of refresh tokens, so that they
+// cannot be restored after they were revoked in Redis.
+func (s *TokenService) forgetRefresh(ctx context.Context, hashes ...string) error {
+	if s.refreshStore == nil {
+		return nil
+	}
+	if err := s.refreshStore.Delete(ctx, hashes...); err != nil {
+		return autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	return nil
+}
+
+// ensureRefresh reports whether the refresh token hash is in Redis, restoring
+// it from the durable store if needed.
+func (s *TokenService) ensureRefresh(ctx context.Context, hash string) (bool, error) {
+	key := redisKey(hash)
+	exists, err := s.rdb.Exists(ctx, key).Result()
+	if err != nil {
+		return false, autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	if exists > 0 || s.refreshStore == nil {
+		return exists > 0, nil
+	}
+
+	token, err := s.refreshStore.Find(ctx, hash)
+	if err == autherr.ErrNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	ttl := token.ExpiresAt.Sub(s.now())
+	if ttl <= 0 {
+		return false, nil
+	}
+	fields := make(map[string]any, len(token.Fields))
+	for k, v := range token.Fields {
+		fields[k] = v
+	}
+	pipe := s.rdb.TxPipeline()
+	pipe.HSet(ctx, key, fields)
+	pipe.PExpire(ctx, key, ttl)
+	if label := token.Fields["canary"]; label != "" {
+		pipe.HSet(ctx, canaryTokensKey, hash, label)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return false, autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	if token.SessionID != "" {
+		if err := s.indexSession(ctx, token.UserID, token.SessionID, hash); err != nil {
+			return false, err
+		}
+	}
+	logger.FromContext(ctx).Info("Refresh token restored from durable store",
+		zap.String("user_id", token.UserID),
+		zap.String("session_id", token.SessionID))
+	return true, nil
+}
+
+// PruneRefreshStore deletes expired tokens from the durable store every
+// interval until ctx is done. It returns at once without a store.
+func (s *TokenService) PruneRefreshStore(ctx context.Context, every time.Duration) {
+	if s.refreshStore == nil {
+		return
+	}
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := s.refreshStore.DeleteExpired(ctx); err != nil && ctx.Err() == nil {
+				logger.FromContext(ctx).Warn("Failed to prune refresh token store", zap.Error(err))
+			}
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package services
+
+import (
+	"context"
+	"maps"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/andro-kes/auth_service/internal/autherr"
+	"github.com/andro-kes/auth_service/internal/models"
+)
+
+type testRefreshTokenRepo struct {
+	mu     sync.Mutex
+	tokens map[string]models.RefreshToken
+}
+
+func newTestRefreshTokenRepo() *testRefreshTokenRepo {
+	return &testRefreshTokenRepo{tokens: make(map[string]models.RefreshToken)}
+}
+
+func (r *testRefreshTokenRepo) Save(ctx context.Context, token *models.RefreshToken) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	t := *token
+	t.Fields = maps.Clone(token.Fields)
+	r.tokens[t.Hash] = t
+	return nil
+}
+
+func (r *testRefreshTokenRepo) Find(ctx context.Context, hash string) (*models.RefreshToken, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	t, ok := r.tokens[hash]
+	if !ok || !time.Now().Before(t.ExpiresAt) {
+		return nil, autherr.ErrNotFound
+	}
+	return &t, nil
+}
+
+func (r *testRefreshTokenRepo) Delete(ctx context.Context, hashes ...string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, h := range hashes {
+		delete(r.tokens, h)
+	}
+	return nil
+}
+
+func (r *testRefreshTokenRepo) DeleteByUser(ctx context.Context, userID, keepSession string) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var n int64
+	for h, t := range r.tokens {
+		if t.UserID == userID && (keepSession == "" || t.SessionID != keepSession) {
+			delete(r.tokens, h)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (r *testRefreshTokenRepo) DeleteExpired(ctx context.Context) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var n int64
+	for h, t := range r.tokens {
+		if !time.Now().Before(t.ExpiresAt) {
+			delete(r.tokens, h)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func TestRefreshStore_SurvivesRedisFlush(t *testing.T) {
+	store := newTestRefreshTokenRepo()
+	svc, srv := newTestTokenService(t, WithRefreshStore(store))
+
+	ctx := t.Context()
+	_, refresh, _, _, err := svc.GenerateTokens(ctx, "alice", WithClientInfo(ClientInfo{DeviceID: "phone"}))
+	if err != nil {
+		t.Fatalf("GenerateTokens failed: %v", err)
+	}
+	_, revoked, _, _, err := svc.GenerateTokens(ctx, "alice")
+	if err != nil {
+		t.Fatalf("GenerateTokens failed: %v", err)
+	}
+	if err := svc.RevokeRefreshByRaw(ctx, revoked); err != nil {
+		t.Fatalf("RevokeRefreshByRaw failed: %v", err)
+	}
+
+	srv.FlushAll()
+
+	_, rotated, _, _, err := svc.RotateRefresh(ctx, refresh, "alice", WithClientInfo(ClientInfo{DeviceID: "phone"}))
+	if err != nil {
+		t.Fatalf("expected the token to be restored after a flush, got %v", err)
+	}
+	sessions, err := svc.ListSessions(ctx, "alice")
+	if err != nil || len(sessions) != 1 || sessions[0].Client.DeviceID != "phone" {
+		t.Fatalf("expected the restored session to be listed, got %+v, %v", sessions, err)
+	}
+	if _, err := svc.ValidateRefresh(ctx, revoked); err != autherr.ErrInvalidToken {
+		t.Fatalf("expected a revoked token to stay revoked, got %v", err)
+	}
+
+	// the rotated-out token is gone from both stores
+	srv.FlushAll()
+	if _, err := svc.ValidateRefresh(ctx, refresh); err != autherr.ErrInvalidToken {
+		t.Fatalf("expected the rotated-out token not to be restored, got %v", err)
+	}
+	if _, err := svc.ValidateRefresh(ctx, rotated); err != nil {
+		t.Fatalf("expected the rotated token to be restored, got %v", err)
+	}
+
+	if n, err := svc.RevokeAllSessions(ctx, "alice", ""); err != nil || n != 1 {
+		t.Fatalf("RevokeAllSessions = %d, %v; want 1", n, err)
+	}
+	srv.FlushAll()
+	if _, err := svc.ValidateRefresh(ctx, rotated); err != autherr.ErrInvalidToken {
+		t.Fatalf("expected logged out sessions not to be restored, got %v", err)
+	}
+}
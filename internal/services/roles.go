@@ -0,0 +1,200 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/andro-kes/auth_service/internal/autherr"
+	"github.com/andro-kes/auth_service/internal/logger"
+	"github.com/andro-kes/auth_service/internal/models"
+	"github.com/andro-kes/auth_service/internal/repo"
+	"github.com/andro-kes/auth_service/internal/repo/db"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+)
+
+// foreignKeyViolation is the Postgres error code of foreign key violations.
+const foreignKeyViolation = "23503"
+
+// maxRoleNameLen bounds role and permission names.
+const maxRoleNameLen = 64
+
+// WithRoles embeds the names of the user's roles in every access token as
+// "roles". Roles are read when tokens are issued or rotated, so changes
+// reach tokens with the next refresh; RoleService.HasPermission sees them
+// immediately.
+func WithRoles(roles repo.RoleRepo) Option {
+	return func(s *TokenService) {
+		s.roles = roles
+	}
+}
+
+// stampRoles sets the "roles" claim when roles are enabled.
+func (s *TokenService) stampRoles(ctx context.Context, claims *tokenClaims) error {
+	if s.roles == nil {
+		return nil
+	}
+	roles, err := s.roles.UserRoles(ctx, claims.UserID)
+	if err != nil {
+		return autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	claims.Roles = roles
+	return nil
+}
+
+// RoleService manages roles, their permissions and their assignment to
+// users.
+type RoleService struct {
+	Repo repo.RoleRepo
+	Tx   db.Tx
+}
+
+func NewRoleService(ctx context.Context, pool *pgxpool.Pool) *RoleService {
+	return &RoleService{
+		Repo: repo.NewRoleRepo(ctx, pool),
+		Tx:   db.NewTx(pool),
+	}
+}
+
+// CreateRole creates a role granting permissions.
+func (rs *RoleService) CreateRole(ctx context.Context, name, description string, permissions []string) error {
+	if err := checkRoleName("role", name); err != nil {
+		return err
+	}
+	seen := make(map[string]struct{}, len(permissions))
+	role := &models.Role{Name: name, Description: description}
+	for _, p := range permissions {
+		if err := checkRoleName("permission", p); err != nil {
+			return err
+		}
+		if _, ok := seen[p]; !ok {
+			seen[p] = struct{}{}
+			role.Permissions = append(role.Permissions, p)
+		}
+	}
+
+	err := rs.Tx.RunInTx(ctx, func(ctx context.Context, q db.Querier) error {
+		return rs.Repo.Create(ctx, q, role)
+	})
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+			return autherr.ErrConflict.WithMessage("role already exists")
+		}
+		logger.FromContext(ctx).Error("Failed to create role", zap.Error(err))
+		return autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	return nil
+}
+
+// AssignRole gives the user a role. Assigning a role twice is a no-op.
+func (rs *RoleService) AssignRole(ctx context.Context, userID, role string) error {
+	if userID == "" || role == "" {
+		return autherr.ErrBadRequest.WithMessage("user_id and role are required")
+	}
+	err := rs.Tx.RunInTx(ctx, func(ctx context.Context, q db.Querier) error {
+		return rs.Repo.Assign(ctx, q, userID, role)
+	})
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == foreignKeyViolation {
+			return autherr.ErrNotFound.WithMessage("unknown user or role")
+		}
+		logger.FromContext(ctx).Error("Failed to assign role", zap.Error(err))
+		return autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	logger.FromContext(ctx).Info("Role assigned", zap.String("user_id", userID), zap.String("role", role))
+	return nil
+}
+
+// RevokeRole takes a role away from the user.
+func (rs *RoleService) RevokeRole(ctx context.Context, userID, role string) error {
+	if userID == "" || role == "" {
+		return autherr.ErrBadRequest.WithMessage("user_id and role are required")
+	}
+	var had bool
+	err := rs.Tx.RunInTx(ctx, func(ctx context.Context, q db.Querier) error {
+		var err error
+		had, err = rs.Repo.Revoke(ctx, q, userID, role)
+		return err
+	})
+	if err != nil {
+		logger.FromContext(ctx).Error("Failed to revoke role", zap.Error(err))
+		return autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	if !had {
+		return autherr.ErrNotFound.WithMessage("user does not have the role")
+	}
+	logger.FromContext(ctx).Info("Role revoked", zap.String("user_id", userID), zap.String("role", role))
+	return nil
+}
+
+// UserRoles returns the names of the user's roles.
+func (rs *RoleService) UserRoles(ctx context.Context, userID string) ([]string, error) {
+	roles, err := rs.Repo.UserRoles(ctx, userID)
+	if err != nil {
+		logger.FromContext(ctx).Error("Failed to get user roles", zap.Error(err))
+		return nil, autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	return roles, nil
+}
+
+// HasPermission reports whether any of the user's current roles grants
+// permission.
+func (rs *RoleService) HasPermission(ctx context.Context, userID, permission string) (bool, error) {
+	if permission == "" {
+		return false, autherr.ErrBadRequest.WithMessage("permission is required")
+	}
+	ok, err := rs.Repo.HasPermission(ctx, userID, permission)
+	if err != nil {
+		logger.FromContext(ctx).Error("Failed to check permission", zap.Error(err))
+		return false, autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	return ok, nil
+}
+
+// SetRoleMFARequired makes a second factor mandatory for every holder of
+// role, or optional again. Holders without MFA are asked to enroll TOTP at
+// their next login.
+func (rs *RoleService) SetRoleMFARequired(ctx context.Context, role string, require bool) error {
+	if role == "" {
+		return autherr.ErrBadRequest.WithMessage("role is required")
+	}
+	var found bool
+	err := rs.Tx.RunInTx(ctx, func(ctx context.Context, q db.Querier) error {
+		var err error
+		found, err = rs.Repo.SetRequireMFA(ctx, q, role, require)
+		return err
+	})
+	if err != nil {
+		logger.FromContext(ctx).Error("Failed to set role mfa policy", zap.Error(err))
+		return autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	if !found {
+		return autherr.ErrNotFound.WithMessage("unknown role")
+	}
+	logger.FromContext(ctx).Info("Role mfa policy set", zap.String("role", role), zap.Bool("require_mfa", require))
+	return nil
+}
+
+// RequiresMFA reports whether a role of the user requires a second factor.
+func (rs *RoleService) RequiresMFA(ctx context.Context, userID string) (bool, error) {
+	ok, err := rs.Repo.RequiresMFA(ctx, userID)
+	if err != nil {
+		logger.FromContext(ctx).Error("Failed to check role mfa policy", zap.Error(err))
+		return false, autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	return ok, nil
+}
+
+// checkRoleName accepts names such as "admin" or "orders:read".
+func checkRoleName(kind, name string) error {
+	if name == "" || len(name) > maxRoleNameLen || strings.ContainsFunc(name, func(r rune) bool {
+		return r <= ' ' || r == 0x7f
+	}) {
+		return autherr.ErrBadRequest.WithMessage(kind + " names must be 1 to 64 characters without spaces")
+	}
+	return nil
+}
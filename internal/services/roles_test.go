@@ -0,0 +1,179 @@
+package services
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/andro-kes/auth_service/internal/models"
+	"github.com/andro-kes/auth_service/internal/repo/db"
+	"github.com/jackc/pgx/v5/pgconn"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type testRoleRepo struct {
+	roles      map[string]*models.Role
+	users      map[string][]string
+	requireMFA map[string]bool
+}
+
+func (r *testRoleRepo) Create(ctx context.Context, q db.Querier, role *models.Role) error {
+	if _, ok := r.roles[role.Name]; ok {
+		return &pgconn.PgError{Code: uniqueViolation}
+	}
+	if r.roles == nil {
+		r.roles = map[string]*models.Role{}
+	}
+	r.roles[role.Name] = role
+	return nil
+}
+
+func (r *testRoleRepo) Assign(ctx context.Context, q db.Querier, userID, role string) error {
+	if _, ok := r.roles[role]; !ok {
+		return &pgconn.PgError{Code: foreignKeyViolation}
+	}
+	if r.users == nil {
+		r.users = map[string][]string{}
+	}
+	if !slices.Contains(r.users[userID], role) {
+		r.users[userID] = append(r.users[userID], role)
+		slices.Sort(r.users[userID])
+	}
+	return nil
+}
+
+func (r *testRoleRepo) Revoke(ctx context.Context, q db.Querier, userID, role string) (bool, error) {
+	i := slices.Index(r.users[userID], role)
+	if i < 0 {
+		return false, nil
+	}
+	r.users[userID] = slices.Delete(r.users[userID], i, i+1)
+	return true, nil
+}
+
+func (r *testRoleRepo) UserRoles(ctx context.Context, userID string) ([]string, error) {
+	return slices.Clone(r.users[userID]), nil
+}
+
+func (r *testRoleRepo) HasPermission(ctx context.Context, userID, permission string) (bool, error) {
+	for _, name := range r.users[userID] {
+		if slices.Contains(r.roles[name].Permissions, permission) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (r *testRoleRepo) SetRequireMFA(ctx context.Context, q db.Querier, role string, require bool) (bool, error) {
+	if _, ok := r.roles[role]; !ok {
+		return false, nil
+	}
+	if r.requireMFA == nil {
+		r.requireMFA = map[string]bool{}
+	}
+	r.requireMFA[role] = require
+	return true, nil
+}
+
+func (r *testRoleRepo) RequiresMFA(ctx context.Context, userID string) (bool, error) {
+	for _, name := range r.users[userID] {
+		if r.requireMFA[name] {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func TestRoles(t *testing.T) {
+	roles := &testRoleRepo{}
+	tokens, _ := newTestTokenService(t, WithRoles(roles))
+	rs := &RoleService{Repo: roles, Tx: &fakeTx{}}
+
+	ctx := t.Context()
+	if err := rs.CreateRole(ctx, "bad name", "", nil); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected a name with spaces to be rejected, got %v", err)
+	}
+	if err := rs.CreateRole(ctx, "editor", "Edits orders", []string{"orders:read", "orders:write", "orders:read"}); err != nil {
+		t.Fatalf("CreateRole failed: %v", err)
+	}
+	if got := roles.roles["editor"].Permissions; !slices.Equal(got, []string{"orders:read", "orders:write"}) {
+		t.Fatalf("expected duplicate permissions to be dropped, got %v", got)
+	}
+	if err := rs.CreateRole(ctx, "editor", "", nil); status.Code(err) != codes.AlreadyExists {
+		t.Fatalf("expected a duplicate role to be rejected, got %v", err)
+	}
+	if err := rs.AssignRole(ctx, "user-1", "owner"); status.Code(err) != codes.NotFound {
+		t.Fatalf("expected an unknown role to be NotFound, got %v", err)
+	}
+	if err := rs.AssignRole(ctx, "user-1", "editor"); err != nil {
+		t.Fatalf("AssignRole failed: %v", err)
+	}
+
+	access, refresh, _, _, err := tokens.GenerateTokens(ctx, "user-1")
+	if err != nil {
+		t.Fatalf("GenerateTokens failed: %v", err)
+	}
+	claims, err := tokens.ValidateAccess(access)
+	if err != nil || !slices.Equal(claims.Roles, []string{"editor"}) {
+		t.Fatalf("expected editor role in claims, got claims=%+v err=%v", claims, err)
+	}
+	if ok, err := rs.HasPermission(ctx, "user-1", "orders:write"); err != nil || !ok {
+		t.Fatalf("expected orders:write to be granted, got %v, %v", ok, err)
+	}
+
+	if err := rs.RevokeRole(ctx, "user-1", "editor"); err != nil {
+		t.Fatalf("RevokeRole failed: %v", err)
+	}
+	if err := rs.RevokeRole(ctx, "user-1", "editor"); status.Code(err) != codes.NotFound {
+		t.Fatalf("expected revoking a missing role to be NotFound, got %v", err)
+	}
+	if ok, err := rs.HasPermission(ctx, "user-1", "orders:write"); err != nil || ok {
+		t.Fatalf("expected the permission to be gone at once, got %v, %v", ok, err)
+	}
+
+	// the claim follows with the next refresh
+	access, _, _, _, err = tokens.RotateRefresh(ctx, refresh, "user-1")
+	if err != nil {
+		t.Fatalf("RotateRefresh failed: %v", err)
+	}
+	if claims, err := tokens.ValidateAccess(access); err != nil || claims.Roles != nil {
+		t.Fatalf("expected no roles after the refresh, got claims=%+v err=%v", claims, err)
+	}
+}
+
+func TestRoleMFAPolicy(t *testing.T) {
+	roles := &testRoleRepo{}
+	rs := &RoleService{Repo: roles, Tx: &fakeTx{}}
+	ctx := t.Context()
+
+	if err := rs.SetRoleMFARequired(ctx, "admin", true); status.Code(err) != codes.NotFound {
+		t.Fatalf("expected an unknown role to be NotFound, got %v", err)
+	}
+	for _, name := range []string{"admin", "viewer"} {
+		if err := rs.CreateRole(ctx, name, "", nil); err != nil {
+			t.Fatalf("CreateRole failed: %v", err)
+		}
+	}
+	if err := rs.AssignRole(ctx, "user-1", "viewer"); err != nil {
+		t.Fatalf("AssignRole failed: %v", err)
+	}
+	if err := rs.SetRoleMFARequired(ctx, "admin", true); err != nil {
+		t.Fatalf("SetRoleMFARequired failed: %v", err)
+	}
+	if ok, err := rs.RequiresMFA(ctx, "user-1"); err != nil || ok {
+		t.Fatalf("expected no mfa requirement for viewers, got %v, %v", ok, err)
+	}
+	if err := rs.AssignRole(ctx, "user-1", "admin"); err != nil {
+		t.Fatalf("AssignRole failed: %v", err)
+	}
+	if ok, err := rs.RequiresMFA(ctx, "user-1"); err != nil || !ok {
+		t.Fatalf("expected admins to require mfa, got %v, %v", ok, err)
+	}
+	if err := rs.SetRoleMFARequired(ctx, "admin", false); err != nil {
+		t.Fatalf("SetRoleMFARequired failed: %v", err)
+	}
+	if ok, err := rs.RequiresMFA(ctx, "user-1"); err != nil || ok {
+		t.Fatalf("expected the requirement to be lifted, got %v, %v", ok, err)
+	}
+}
@@ -0,0 +1,49 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"github.com/andro-kes/auth_service/internal/autherr"
+	"github.com/andro-kes/auth_service/internal/logger"
+	"go.uber.org/zap"
+)
+
+// rotationLockTTL bounds how long a rotation that crashed midway blocks its
+// token.
+const rotationLockTTL = 5 * time.Second
+
+var unlockScript = `
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+  return redis.call("DEL", KEYS[1])
+end
+return 0
+`
+
+// lockRotation takes the rotation lock of a stored refresh token, so that of
+// concurrent rotations of the same token exactly one proceeds; the others
+// fail with ErrRotationInProgress. The returned func releases the lock.
+func (s *TokenService) lockRotation(ctx context.Context, hash string) (func(), error) {
+	owner, err := randomHex(s.crypto.Rand(), 16)
+	if err != nil {
+		return nil, autherr.ErrTokenGeneration.WithMessage(err.Error())
+	}
+	key := rotationLockKey(hash)
+	ok, err := s.rdb.SetNX(ctx, key, owner, rotationLockTTL).Result()
+	if err != nil {
+		return nil, autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	if !ok {
+		return nil, autherr.ErrRotationInProgress
+	}
+	return func() {
+		// release even if the caller gave up; only our own lock is deleted
+		if err := s.rdb.Eval(context.WithoutCancel(ctx), unlockScript, []string{key}, owner).Err(); err != nil {
+			logger.FromContext(ctx).Warn("Failed to release refresh rotation lock", zap.Error(err))
+		}
+	}, nil
+}
+
+func rotationLockKey(hash string) string {
+	return "refresh:lock:" + hash
+}
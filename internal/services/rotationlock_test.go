@@ -0,0 +1,72 @@
+package services
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/andro-kes/auth_service/internal/autherr"
+)
+
+func TestRotateRefresh_Concurrent(t *testing.T) {
+	svc, srv := newTestTokenService(t)
+	ctx := t.Context()
+
+	_, refresh, _, _, err := svc.GenerateTokens(ctx, "alice")
+	if err != nil {
+		t.Fatalf("GenerateTokens failed: %v", err)
+	}
+
+	const callers = 16
+	errs := make(chan error, callers)
+	start := make(chan struct{})
+	for range callers {
+		go func() {
+			<-start
+			_, _, _, _, err := svc.RotateRefresh(ctx, refresh, "alice")
+			errs <- err
+		}()
+	}
+	close(start)
+	won := 0
+	for range callers {
+		switch err := <-errs; err {
+		case nil:
+			won++
+		case autherr.ErrRotationInProgress, autherr.ErrInvalidToken:
+		default:
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if won != 1 {
+		t.Fatalf("%d rotations won, want exactly 1", won)
+	}
+	var tokens []string
+	for _, k := range srv.Keys() {
+		if strings.HasPrefix(k, "refresh:th:") {
+			tokens = append(tokens, k)
+		}
+	}
+	if len(tokens) != 1 {
+		t.Fatalf("session has %d refresh tokens, want 1", len(tokens))
+	}
+
+	// a crashed rotation holds the token only until its lock expires
+	_, refresh, _, _, err = svc.GenerateTokens(ctx, "bob")
+	if err != nil {
+		t.Fatalf("GenerateTokens failed: %v", err)
+	}
+	if err := srv.Set(rotationLockKey(sha256Hex(refresh)), "crashed"); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	srv.SetTTL(rotationLockKey(sha256Hex(refresh)), rotationLockTTL)
+	if _, _, _, _, err := svc.RotateRefresh(ctx, refresh, "bob"); err != autherr.ErrRotationInProgress {
+		t.Fatalf("rotation of a locked token: %v, want ErrRotationInProgress", err)
+	}
+	srv.FastForward(rotationLockTTL)
+	if _, _, _, _, err := svc.RotateRefresh(ctx, refresh, "bob"); err != nil {
+		t.Fatalf("rotation after the lock expired: %v", err)
+	}
+	if srv.Exists(rotationLockKey(sha256Hex(refresh))) {
+		t.Fatal("rotation lock not released")
+	}
+}
@@ -0,0 +1,61 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"github.com/andro-kes/auth_service/internal/alert"
+	"github.com/andro-kes/auth_service/internal/logger"
+	"github.com/andro-kes/auth_service/internal/repo"
+	"github.com/andro-kes/auth_service/internal/repo/db"
+	"go.uber.org/zap"
+)
+
+// AuditCanaryTriggered is recorded whenever a honeytoken is used.
+const AuditCanaryTriggered = "security.canary_triggered"
+
+// SecurityEvents records high-severity events: an error log line, an audit
+// event and, when configured, an external alert.
+type SecurityEvents struct {
+	Audit repo.AuditRepo
+	DB    db.Querier
+	// Alerts is optional.
+	Alerts alert.Sender
+}
+
+// CanaryTriggered reports the use of a honeytoken. Recording outlives the
+// request: a caller hanging up must not suppress the event.
+func (se *SecurityEvents) CanaryTriggered(ctx context.Context, ev CanaryEvent, client ClientInfo) {
+	fields := map[string]string{
+		"kind":    ev.Kind,
+		"label":   ev.Label,
+		"subject": ev.Subject,
+	}
+	logger.FromContext(ctx).Error("SECURITY: honeytoken used",
+		zap.String("severity", "critical"),
+		zap.String("kind", ev.Kind),
+		zap.String("label", ev.Label),
+		zap.String("subject", ev.Subject),
+		zap.String("ip", client.IP),
+		zap.String("user_agent", client.UserAgent))
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer cancel()
+	if err := se.Audit.Insert(ctx, se.DB, auditEvent(AuditCanaryTriggered, "", client, fields)); err != nil {
+		logger.FromContext(ctx).Error("Failed to audit honeytoken use", zap.Error(err))
+	}
+
+	if se.Alerts == nil {
+		return
+	}
+	fields["ip"] = client.IP
+	fields["user_agent"] = client.UserAgent
+	a := alert.Alert{Severity: "critical", Title: "Honeytoken used", Fields: fields, Time: time.Now().UTC()}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := se.Alerts.Send(ctx, a); err != nil {
+			logger.FromContext(ctx).Error("Failed to send security alert", zap.Error(err))
+		}
+	}()
+}
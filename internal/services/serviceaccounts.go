@@ -0,0 +1,270 @@
+package services
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/andro-kes/auth_service/internal/autherr"
+	"github.com/andro-kes/auth_service/internal/logger"
+	"github.com/andro-kes/auth_service/internal/models"
+	"github.com/andro-kes/auth_service/internal/repo"
+	"github.com/andro-kes/auth_service/internal/repo/db"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+)
+
+// SubjectServiceAccount is the sub_type claim of tokens issued to service
+// accounts.
+const SubjectServiceAccount = "service_account"
+
+const (
+	// assertionMaxLifetime bounds exp-iat of accepted assertions, which also
+	// bounds how long their jti must be remembered.
+	assertionMaxLifetime = time.Hour
+	assertionLeeway      = 30 * time.Second
+)
+
+// assertionAlgs are the asymmetric algorithms accepted for assertions.
+var assertionAlgs = []string{"RS256", "PS256", "ES256", "ES384", "EdDSA"}
+
+// AsServiceAccount marks the issued token's subject as a service account.
+func AsServiceAccount() IssueOption {
+	return func(p *issueParams) {
+		p.subjectType = SubjectServiceAccount
+	}
+}
+
+// ServiceAccountService manages service accounts and implements the JWT
+// bearer grant (RFC 7523): an account signs an assertion with one of its
+// registered keys and exchanges it for a scoped access token.
+type ServiceAccountService struct {
+	Repo   repo.ServiceAccountRepo
+	Tx     db.Tx
+	Tokens *TokenService
+	// Audience is the "aud" assertions must carry; empty disables the grant.
+	Audience string
+}
+
+func NewServiceAccountService(ctx context.Context, pool *pgxpool.Pool, tokens *TokenService, audience string) *ServiceAccountService {
+	return &ServiceAccountService{
+		Repo:     repo.NewServiceAccountRepo(ctx, pool),
+		Tx:       db.NewTx(pool),
+		Tokens:   tokens,
+		Audience: audience,
+	}
+}
+
+// CreateAccount registers a service account allowed to request scopes.
+func (ss *ServiceAccountService) CreateAccount(ctx context.Context, name string, scopes []string) (string, error) {
+	if name == "" {
+		return "", autherr.ErrBadRequest.WithMessage("name is required")
+	}
+	account := &models.ServiceAccount{ID: uuid.New().String(), Name: name, AllowedScopes: scopes}
+	if account.AllowedScopes == nil {
+		account.AllowedScopes = []string{}
+	}
+	err := ss.Tx.RunInTx(ctx, func(ctx context.Context, q db.Querier) error {
+		return ss.Repo.Create(ctx, q, account)
+	})
+	if err != nil {
+		logger.FromContext(ctx).Error("Failed to create service account", zap.Error(err))
+		return "", autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	return account.ID, nil
+}
+
+// AddKey registers a PEM-encoded public key and returns its key ID, the
+// base64url SHA-256 of the key's DER encoding.
+func (ss *ServiceAccountService) AddKey(ctx context.Context, accountID, publicKeyPEM string) (string, error) {
+	der, err := parseAssertionKeyPEM(publicKeyPEM)
+	if err != nil {
+		return "", err
+	}
+	if _, err := ss.account(ctx, accountID); err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(der)
+	key := &models.ServiceAccountKey{
+		ID:        base64.RawURLEncoding.EncodeToString(sum[:]),
+		AccountID: accountID,
+		PublicKey: publicKeyPEM,
+	}
+	err = ss.Tx.RunInTx(ctx, func(ctx context.Context, q db.Querier) error {
+		return ss.Repo.AddKey(ctx, q, key)
+	})
+	if err != nil {
+		logger.FromContext(ctx).Error("Failed to add service account key", zap.Error(err))
+		return "", autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	return key.ID, nil
+}
+
+// RevokeKey stops accepting assertions signed with the key.
+func (ss *ServiceAccountService) RevokeKey(ctx context.Context, accountID, keyID string) error {
+	var found bool
+	err := ss.Tx.RunInTx(ctx, func(ctx context.Context, q db.Querier) error {
+		var err error
+		found, err = ss.Repo.RevokeKey(ctx, q, accountID, keyID)
+		return err
+	})
+	if err != nil {
+		logger.FromContext(ctx).Error("Failed to revoke service account key", zap.Error(err))
+		return autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	if !found {
+		return autherr.ErrNotFound
+	}
+	return nil
+}
+
+// ExchangeAssertion verifies a JWT bearer assertion and issues an access
+// token for scope. The assertion must name the account in both "iss" and
+// "sub", target Audience, live at most an hour and carry a "jti", which is
+// accepted only once.
+func (ss *ServiceAccountService) ExchangeAssertion(ctx context.Context, assertion, scope string) (string, time.Time, error) {
+	if ss.Audience == "" {
+		return "", time.Time{}, autherr.ErrForbidden.WithMessage("JWT bearer grant is disabled")
+	}
+	if scope == "" {
+		return "", time.Time{}, autherr.ErrBadRequest.WithMessage("scope is required")
+	}
+
+	var (
+		account   *models.ServiceAccount
+		lookupErr error
+	)
+	claims := &jwt.RegisteredClaims{}
+	parser := jwt.NewParser(
+		jwt.WithValidMethods(assertionAlgs),
+		jwt.WithAudience(ss.Audience),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(assertionLeeway),
+		jwt.WithTimeFunc(ss.Tokens.now),
+	)
+	_, err := parser.ParseWithClaims(assertion, claims, func(t *jwt.Token) (any, error) {
+		if claims.Issuer == "" || claims.Subject != claims.Issuer {
+			return nil, autherr.ErrInvalidToken
+		}
+		if account, lookupErr = ss.account(ctx, claims.Issuer); lookupErr != nil {
+			return nil, lookupErr
+		}
+		keys, err := ss.Repo.ActiveKeys(ctx, account.ID)
+		if err != nil {
+			lookupErr = autherr.ErrStorageError.WithMessage(err.Error())
+			return nil, lookupErr
+		}
+		kid, _ := t.Header["kid"].(string)
+		var set jwt.VerificationKeySet
+		for _, k := range keys {
+			if kid != "" && k.ID != kid {
+				continue
+			}
+			if pub, err := parseAssertionKey(k.PublicKey); err == nil {
+				set.Keys = append(set.Keys, pub)
+			}
+		}
+		if len(set.Keys) == 0 {
+			return nil, autherr.ErrInvalidToken
+		}
+		return set, nil
+	})
+	if lookupErr != nil && lookupErr != autherr.ErrNotFound {
+		return "", time.Time{}, lookupErr
+	}
+	if err != nil {
+		logger.FromContext(ctx).Debug("Rejected JWT bearer assertion", zap.Error(err))
+		return "", time.Time{}, autherr.ErrInvalidToken
+	}
+
+	issuedAt := ss.Tokens.now()
+	if claims.IssuedAt != nil {
+		issuedAt = claims.IssuedAt.Time
+	}
+	if claims.ExpiresAt.Sub(issuedAt) > assertionMaxLifetime {
+		return "", time.Time{}, autherr.ErrInvalidToken.WithMessage("assertion lifetime too long")
+	}
+	if claims.ID == "" {
+		return "", time.Time{}, autherr.ErrInvalidToken.WithMessage("assertion jti is required")
+	}
+	if err := ss.consumeAssertion(ctx, claims); err != nil {
+		return "", time.Time{}, err
+	}
+
+	if !slices.Contains(account.AllowedScopes, scope) {
+		return "", time.Time{}, autherr.ErrForbidden.WithMessage("scope not allowed for service account")
+	}
+	return ss.Tokens.IssueScopedAccess(ctx, account.ID, scope, 0, false, AsServiceAccount())
+}
+
+func (ss *ServiceAccountService) consumeAssertion(ctx context.Context, claims *jwt.RegisteredClaims) error {
+	key := "assertion:jti:" + sha256Hex(claims.Issuer+":"+claims.ID)
+	ttl := claims.ExpiresAt.Sub(ss.Tokens.now()) + assertionLeeway
+	ok, err := ss.Tokens.rdb.SetNX(ctx, key, 1, ttl).Result()
+	if err != nil {
+		return autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	if !ok {
+		return autherr.ErrTokenReplayed
+	}
+	return nil
+}
+
+func (ss *ServiceAccountService) account(ctx context.Context, id string) (*models.ServiceAccount, error) {
+	account, err := ss.Repo.FindByID(ctx, id)
+	if err != nil {
+		if err == autherr.ErrNotFound {
+			return nil, autherr.ErrNotFound
+		}
+		logger.FromContext(ctx).Error("Failed to get service account", zap.Error(err))
+		return nil, autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	return account, nil
+}
+
+// parseAssertionKeyPEM validates a PKIX public key usable with assertionAlgs
+// and returns its DER encoding.
+func parseAssertionKeyPEM(s string) ([]byte, error) {
+	block, _ := pem.Decode([]byte(strings.TrimSpace(s)))
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return nil, autherr.ErrBadRequest.WithMessage("public key must be a PEM \"PUBLIC KEY\" block")
+	}
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, autherr.ErrBadRequest.WithMessage("invalid public key")
+	}
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		if k.N.BitLen() < 2048 {
+			return nil, autherr.ErrBadRequest.WithMessage("RSA keys must be at least 2048 bits")
+		}
+	case *ecdsa.PublicKey:
+		if k.Curve != elliptic.P256() && k.Curve != elliptic.P384() {
+			return nil, autherr.ErrBadRequest.WithMessage("ECDSA keys must use P-256 or P-384")
+		}
+	case ed25519.PublicKey:
+	default:
+		return nil, autherr.ErrBadRequest.WithMessage("unsupported public key type")
+	}
+	return block.Bytes, nil
+}
+
+func parseAssertionKey(s string) (jwt.VerificationKey, error) {
+	der, err := parseAssertionKeyPEM(s)
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParsePKIXPublicKey(der)
+}
@@ -0,0 +1,288 @@
+package services
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/andro-kes/auth_service/internal/autherr"
+	"github.com/andro-kes/auth_service/internal/models"
+	"github.com/andro-kes/auth_service/internal/repo/db"
+	"github.com/golang-jwt/jwt/v5"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type testServiceAccountRepo struct {
+	accounts map[string]*models.ServiceAccount
+	keys     []models.ServiceAccountKey
+	tokens   map[string]*models.ServiceToken
+}
+
+func (r *testServiceAccountRepo) Create(ctx context.Context, q db.Querier, account *models.ServiceAccount) error {
+	if r.accounts == nil {
+		r.accounts = map[string]*models.ServiceAccount{}
+	}
+	r.accounts[account.ID] = account
+	return nil
+}
+
+func (r *testServiceAccountRepo) FindByID(ctx context.Context, id string) (*models.ServiceAccount, error) {
+	if a, ok := r.accounts[id]; ok {
+		return a, nil
+	}
+	return nil, autherr.ErrNotFound
+}
+
+func (r *testServiceAccountRepo) AddKey(ctx context.Context, q db.Querier, key *models.ServiceAccountKey) error {
+	r.keys = append(r.keys, *key)
+	return nil
+}
+
+func (r *testServiceAccountRepo) ActiveKeys(ctx context.Context, accountID string) ([]models.ServiceAccountKey, error) {
+	var out []models.ServiceAccountKey
+	for _, k := range r.keys {
+		if k.AccountID == accountID && k.RevokedAt == nil {
+			out = append(out, k)
+		}
+	}
+	return out, nil
+}
+
+func (r *testServiceAccountRepo) RevokeKey(ctx context.Context, q db.Querier, accountID, keyID string) (bool, error) {
+	for i, k := range r.keys {
+		if k.AccountID == accountID && k.ID == keyID && k.RevokedAt == nil {
+			now := time.Now()
+			r.keys[i].RevokedAt = &now
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (r *testServiceAccountRepo) AddToken(ctx context.Context, q db.Querier, token *models.ServiceToken) error {
+	if r.tokens == nil {
+		r.tokens = map[string]*models.ServiceToken{}
+	}
+	r.tokens[token.ID] = token
+	return nil
+}
+
+func (r *testServiceAccountRepo) FindToken(ctx context.Context, id string) (*models.ServiceToken, error) {
+	if t, ok := r.tokens[id]; ok {
+		return t, nil
+	}
+	return nil, autherr.ErrNotFound
+}
+
+func (r *testServiceAccountRepo) RevokeToken(ctx context.Context, q db.Querier, accountID, tokenID string) (bool, error) {
+	t, ok := r.tokens[tokenID]
+	if !ok || t.AccountID != accountID || t.RevokedAt != nil {
+		return false, nil
+	}
+	now := time.Now()
+	t.RevokedAt = &now
+	return true, nil
+}
+
+func TestExchangeAssertion(t *testing.T) {
+	tokens, _ := newTestTokenService(t)
+	ss := &ServiceAccountService{Repo: &testServiceAccountRepo{}, Tx: &fakeTx{}, Tokens: tokens, Audience: "https://auth.example"}
+
+	ctx := t.Context()
+	accountID, err := ss.CreateAccount(ctx, "billing", []string{"invoices:read"})
+	if err != nil {
+		t.Fatalf("CreateAccount failed: %v", err)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kid, err := ss.AddKey(ctx, accountID, string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})))
+	if err != nil {
+		t.Fatalf("AddKey failed: %v", err)
+	}
+
+	sign := func(aud, jti string) string {
+		now := time.Now()
+		tok := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.RegisteredClaims{
+			Issuer:    accountID,
+			Subject:   accountID,
+			Audience:  jwt.ClaimStrings{aud},
+			ID:        jti,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Minute)),
+		})
+		tok.Header["kid"] = kid
+		s, err := tok.SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+
+	assertion := sign("https://auth.example", "a1")
+	access, _, err := ss.ExchangeAssertion(ctx, assertion, "invoices:read")
+	if err != nil {
+		t.Fatalf("ExchangeAssertion failed: %v", err)
+	}
+	if claims, err := tokens.ValidateAccess(access); err != nil || claims.UserID != accountID {
+		t.Fatalf("expected access token for %s, got %+v, %v", accountID, claims, err)
+	}
+
+	if _, _, err := ss.ExchangeAssertion(ctx, assertion, "invoices:read"); err != autherr.ErrTokenReplayed {
+		t.Fatalf("expected replayed assertion to be rejected, got %v", err)
+	}
+	if _, _, err := ss.ExchangeAssertion(ctx, sign("https://other.example", "a2"), "invoices:read"); err != autherr.ErrInvalidToken {
+		t.Fatalf("expected wrong audience to be rejected, got %v", err)
+	}
+	if _, _, err := ss.ExchangeAssertion(ctx, sign("https://auth.example", "a3"), "invoices:write"); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("expected disallowed scope to be rejected, got %v", err)
+	}
+
+	if err := ss.RevokeKey(ctx, accountID, kid); err != nil {
+		t.Fatalf("RevokeKey failed: %v", err)
+	}
+	if _, _, err := ss.ExchangeAssertion(ctx, sign("https://auth.example", "a4"), "invoices:read"); err != autherr.ErrInvalidToken {
+		t.Fatalf("expected assertion signed with revoked key to be rejected, got %v", err)
+	}
+}
+
+func TestServiceTokens(t *testing.T) {
+	tokens, srv := newTestTokenService(t)
+	ss := &ServiceAccountService{Repo: &testServiceAccountRepo{}, Tx: &fakeTx{}, Tokens: tokens}
+
+	ctx := t.Context()
+	accountID, err := ss.CreateAccount(ctx, "billing", []string{"invoices:read", "invoices:write"})
+	if err != nil {
+		t.Fatalf("CreateAccount failed: %v", err)
+	}
+	if _, _, _, err := ss.MintToken(ctx, accountID, "users:read", 0); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("expected disallowed scope to be rejected, got %v", err)
+	}
+
+	token, tokenID, exp, err := ss.MintToken(ctx, accountID, "", 0)
+	if err != nil {
+		t.Fatalf("MintToken failed: %v", err)
+	}
+	if d := time.Until(exp); d < 89*24*time.Hour {
+		t.Fatalf("expected a long-lived token, got %v", d)
+	}
+
+	in, err := ss.Introspect(ctx, token)
+	if err != nil || !in.Active || in.TokenType != TokenTypeService || in.UserID != accountID || in.JTI != tokenID {
+		t.Fatalf("unexpected introspection %+v, %v", in, err)
+	}
+	if in.Scope != "invoices:read invoices:write" || in.SubjectType != SubjectServiceAccount {
+		t.Fatalf("unexpected claims %+v", in)
+	}
+	if _, err := tokens.ValidateAccess(token); err != autherr.ErrInvalidToken {
+		t.Fatalf("expected a service token to be rejected as an access token, got %v", err)
+	}
+	access, _, _, _, err := tokens.GenerateTokens(ctx, "alice")
+	if err != nil {
+		t.Fatalf("GenerateTokens failed: %v", err)
+	}
+	if _, err := ss.ValidateToken(ctx, access); err != autherr.ErrInvalidToken {
+		t.Fatalf("expected an access token to be rejected as a service token, got %v", err)
+	}
+
+	// a cold cache falls back to the store
+	srv.Del(serviceTokenKey(tokenID))
+	if sub, err := ss.ValidateToken(ctx, token); err != nil || sub != accountID {
+		t.Fatalf("ValidateToken = %q, %v", sub, err)
+	}
+
+	if err := ss.RevokeToken(ctx, "other-account", tokenID); err != autherr.ErrNotFound {
+		t.Fatalf("expected revocation through another account to fail, got %v", err)
+	}
+	if err := ss.RevokeToken(ctx, accountID, tokenID); err != nil {
+		t.Fatalf("RevokeToken failed: %v", err)
+	}
+	if _, err := ss.ValidateToken(ctx, token); err != autherr.ErrInvalidToken {
+		t.Fatalf("expected a revoked token to be rejected, got %v", err)
+	}
+	srv.Del(serviceTokenKey(tokenID))
+	if _, err := ss.ValidateToken(ctx, token); err != autherr.ErrInvalidToken {
+		t.Fatalf("expected the revocation to survive a cache miss, got %v", err)
+	}
+}
+
+func TestOnBehalfOf(t *testing.T) {
+	tokens, _ := newTestTokenService(t)
+	ss := &ServiceAccountService{Repo: &testServiceAccountRepo{}, Tx: &fakeTx{}, Tokens: tokens}
+
+	ctx := t.Context()
+	gateway, err := ss.CreateAccount(ctx, "gateway", []string{"delegate:orders"})
+	if err != nil {
+		t.Fatalf("CreateAccount failed: %v", err)
+	}
+	orders, err := ss.CreateAccount(ctx, "orders", []string{"delegate:billing"})
+	if err != nil {
+		t.Fatalf("CreateAccount failed: %v", err)
+	}
+	gatewayToken, _, _, err := ss.MintToken(ctx, gateway, "", 0)
+	if err != nil {
+		t.Fatalf("MintToken failed: %v", err)
+	}
+	ordersToken, _, _, err := ss.MintToken(ctx, orders, "", 0)
+	if err != nil {
+		t.Fatalf("MintToken failed: %v", err)
+	}
+	access, _, _, _, err := tokens.GenerateTokens(ctx, "alice")
+	if err != nil {
+		t.Fatalf("GenerateTokens failed: %v", err)
+	}
+
+	if _, _, err := ss.OnBehalfOf(ctx, gatewayToken, access, "billing", "invoices:read"); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("expected an audience the service may not delegate to to be rejected, got %v", err)
+	}
+	if _, _, err := ss.OnBehalfOf(ctx, access, access, "orders", "orders:read"); err != autherr.ErrInvalidToken {
+		t.Fatalf("expected a user token to be rejected as the service token, got %v", err)
+	}
+	if _, _, err := ss.OnBehalfOf(ctx, gatewayToken, gatewayToken, "orders", "orders:read"); err != autherr.ErrInvalidToken {
+		t.Fatalf("expected a service token to be rejected as the subject token, got %v", err)
+	}
+
+	delegated, exp, err := ss.OnBehalfOf(ctx, gatewayToken, access, "orders", "orders:read invoices:read")
+	if err != nil {
+		t.Fatalf("OnBehalfOf failed: %v", err)
+	}
+	if d := time.Until(exp); d > time.Minute {
+		t.Fatalf("expected the token to expire with the access TTL, got %v", d)
+	}
+	claims, err := tokens.ValidateAccess(delegated)
+	if err != nil {
+		t.Fatalf("ValidateAccess failed: %v", err)
+	}
+	if claims.UserID != "alice" || claims.Scope != "orders:read invoices:read" ||
+		len(claims.Audience) != 1 || claims.Audience[0] != "orders" ||
+		len(claims.Actors) != 1 || claims.Actors[0] != gateway {
+		t.Fatalf("unexpected claims %+v", claims)
+	}
+
+	// the next service in the chain can only narrow the scope further
+	if _, _, err := ss.OnBehalfOf(ctx, ordersToken, delegated, "billing", "invoices:write"); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("expected a wider scope to be rejected, got %v", err)
+	}
+	chained, _, err := ss.OnBehalfOf(ctx, ordersToken, delegated, "billing", "invoices:read")
+	if err != nil {
+		t.Fatalf("OnBehalfOf with a delegated token failed: %v", err)
+	}
+	in, err := tokens.Introspect(ctx, chained)
+	if err != nil || !in.Active || in.UserID != "alice" || in.Scope != "invoices:read" {
+		t.Fatalf("unexpected introspection %+v, %v", in, err)
+	}
+	if len(in.Actors) != 2 || in.Actors[0] != orders || in.Actors[1] != gateway {
+		t.Fatalf("unexpected delegation chain %v", in.Actors)
+	}
+}
@@ -0,0 +1,194 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/andro-kes/auth_service/internal/autherr"
+	"github.com/andro-kes/auth_service/internal/logger"
+	"github.com/andro-kes/auth_service/internal/metrics"
+	"github.com/andro-kes/auth_service/internal/models"
+	"github.com/andro-kes/auth_service/internal/repo/db"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// TokenTypeService is the introspected type of service tokens.
+const TokenTypeService = "service_token"
+
+const (
+	defaultServiceTokenTTL = 90 * 24 * time.Hour
+	maxServiceTokenTTL     = 365 * 24 * time.Hour
+	// serviceTokenStatusTTL bounds how long the state of a service token
+	// read from Postgres is cached in Redis. Revocations overwrite it.
+	serviceTokenStatusTTL = 10 * time.Minute
+)
+
+// MintToken issues a long-lived service token for the account: a JWT (or
+// PASETO) with "typ" service that cannot be refreshed and is not accepted
+// where user access tokens are. scope is a space-separated subset of the
+// account's allowed scopes; empty grants all of them. A zero ttl means 90
+// days. The token's ID is needed to revoke it.
+func (ss *ServiceAccountService) MintToken(ctx context.Context, accountID, scope string, ttl time.Duration) (token, tokenID string, expiresAt time.Time, err error) {
+	if ttl == 0 {
+		ttl = defaultServiceTokenTTL
+	}
+	if ttl < 0 || ttl > maxServiceTokenTTL {
+		return "", "", time.Time{}, autherr.ErrBadRequest.WithMessage("ttl must be at most 365 days")
+	}
+	account, err := ss.account(ctx, accountID)
+	if err != nil {
+		return "", "", time.Time{}, err
+	}
+	scopes := strings.Fields(scope)
+	if len(scopes) == 0 {
+		scopes = account.AllowedScopes
+	}
+	for _, sc := range scopes {
+		if !slices.Contains(account.AllowedScopes, sc) {
+			return "", "", time.Time{}, autherr.ErrForbidden.WithMessage("scope not allowed for service account")
+		}
+	}
+
+	tokens := ss.Tokens
+	jti, err := randomHex(tokens.crypto.Rand(), 16)
+	if err != nil {
+		return "", "", time.Time{}, autherr.ErrTokenGeneration.WithMessage(err.Error())
+	}
+	now := tokens.now().UTC()
+	expiresAt = now.Add(ttl)
+	record := &models.ServiceToken{ID: jti, AccountID: account.ID, Scope: strings.Join(scopes, " "), ExpiresAt: expiresAt}
+	err = ss.Tx.RunInTx(ctx, func(ctx context.Context, q db.Querier) error {
+		return ss.Repo.AddToken(ctx, q, record)
+	})
+	if err != nil {
+		logger.FromContext(ctx).Error("Failed to store service token", zap.Error(err))
+		return "", "", time.Time{}, autherr.ErrStorageError.WithMessage(err.Error())
+	}
+
+	token, err = tokens.encodeSelfContained(tokenClaims{
+		UserID:  account.ID,
+		Typ:     "service",
+		Scope:   record.Scope,
+		SubType: SubjectServiceAccount,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        jti,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			NotBefore: jwt.NewNumericDate(now),
+		},
+	})
+	if err != nil {
+		return "", "", time.Time{}, err
+	}
+	logger.FromContext(ctx).Info("Service token minted",
+		zap.String("account_id", account.ID),
+		zap.String("token_id", jti),
+		zap.Time("expires_at", expiresAt))
+	metrics.TokensIssued.WithLabelValues("service").Inc()
+	return token, jti, expiresAt, nil
+}
+
+// RevokeToken stops accepting the service token at once.
+func (ss *ServiceAccountService) RevokeToken(ctx context.Context, accountID, tokenID string) error {
+	var found bool
+	err := ss.Tx.RunInTx(ctx, func(ctx context.Context, q db.Querier) error {
+		var err error
+		found, err = ss.Repo.RevokeToken(ctx, q, accountID, tokenID)
+		return err
+	})
+	if err != nil {
+		logger.FromContext(ctx).Error("Failed to revoke service token", zap.Error(err))
+		return autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	if !found {
+		return autherr.ErrNotFound
+	}
+	if err := ss.Tokens.rdb.Set(ctx, serviceTokenKey(tokenID), "0", serviceTokenStatusTTL).Err(); err != nil {
+		// validation would keep trusting the cached state
+		return autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	return nil
+}
+
+// Introspect validates a service token. Anything else, including user access
+// tokens, is reported inactive.
+func (ss *ServiceAccountService) Introspect(ctx context.Context, tokenStr string) (Introspection, error) {
+	tokens := ss.Tokens
+	claims, err := tokens.accessClaims(ctx, tokenStr)
+	if err == autherr.ErrInvalidToken || err == autherr.ErrTokenExpired {
+		return Introspection{}, nil
+	}
+	if err != nil {
+		return Introspection{}, err
+	}
+	if claims.Typ != "service" || claims.ID == "" || tokens.isRevokedLocally(claims) {
+		return Introspection{}, nil
+	}
+	active, err := ss.tokenActive(ctx, claims)
+	if err != nil || !active {
+		return Introspection{}, err
+	}
+
+	in := Introspection{
+		Active:      true,
+		TokenType:   TokenTypeService,
+		UserID:      claims.UserID,
+		Scope:       claims.Scope,
+		SubjectType: claims.SubType,
+		JTI:         claims.ID,
+		ExpiresAt:   claims.ExpiresAt.Time,
+	}
+	if claims.IssuedAt != nil {
+		in.IssuedAt = claims.IssuedAt.Time
+	}
+	return in, nil
+}
+
+// ValidateToken returns the account a service token was minted for.
+func (ss *ServiceAccountService) ValidateToken(ctx context.Context, tokenStr string) (string, error) {
+	in, err := ss.Introspect(ctx, tokenStr)
+	if err != nil {
+		return "", err
+	}
+	if !in.Active {
+		return "", autherr.ErrInvalidToken
+	}
+	return in.UserID, nil
+}
+
+// tokenActive reports whether the token is still on record and not revoked,
+// consulting the Redis cache before Postgres.
+func (ss *ServiceAccountService) tokenActive(ctx context.Context, claims *tokenClaims) (bool, error) {
+	rdb := ss.Tokens.rdb
+	key := serviceTokenKey(claims.ID)
+	state, err := rdb.Get(ctx, key).Result()
+	if err == nil {
+		return state == "1", nil
+	}
+	if !errors.Is(err, redis.Nil) {
+		return false, autherr.ErrStorageError.WithMessage(err.Error())
+	}
+
+	record, err := ss.Repo.FindToken(ctx, claims.ID)
+	if err != nil && err != autherr.ErrNotFound {
+		return false, autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	active := err == nil && record.RevokedAt == nil && record.AccountID == claims.UserID
+	state = "0"
+	if active {
+		state = "1"
+	}
+	if err := rdb.Set(ctx, key, state, serviceTokenStatusTTL).Err(); err != nil {
+		logger.FromContext(ctx).Warn("Failed to cache service token state", zap.Error(err))
+	}
+	return active, nil
+}
+
+func serviceTokenKey(id string) string {
+	return "service:token:" + id
+}
@@ -0,0 +1,370 @@
+package services
+
+import (
+	"context"
+	"crypto/subtle"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/andro-kes/auth_service/internal/autherr"
+	"github.com/andro-kes/auth_service/internal/logger"
+	"github.com/andro-kes/auth_service/internal/metrics"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// ClientInfo describes the client a session was started or last used from.
+type ClientInfo struct {
+	IP        string
+	UserAgent string
+	DeviceID  string
+	// Location is an opaque, human-readable location supplied by the edge
+	// (e.g. "Berlin, DE"); the service does no geo lookup itself.
+	Location string
+}
+
+// WithClientInfo records the caller's client metadata on the session.
+func WithClientInfo(ci ClientInfo) IssueOption {
+	return func(p *issueParams) {
+		p.client = ci
+	}
+}
+
+func (ci ClientInfo) addTo(fields map[string]any) {
+	for k, v := range map[string]string{
+		"ip":         ci.IP,
+		"user_agent": ci.UserAgent,
+		"device_id":  ci.DeviceID,
+		"location":   ci.Location,
+	} {
+		if v != "" {
+			fields[k] = v
+		}
+	}
+}
+
+// DeviceBinding is the policy applied when a refresh token is rotated from a
+// different device than the one it was issued to.
+type DeviceBinding string
+
+const (
+	// DeviceBindingOff ignores the presenting device.
+	DeviceBindingOff DeviceBinding = "off"
+	// DeviceBindingWarn logs mismatches but rotates the token.
+	DeviceBindingWarn DeviceBinding = "warn"
+	// DeviceBindingEnforce rejects the rotation of mismatched tokens.
+	DeviceBindingEnforce DeviceBinding = "enforce"
+)
+
+// WithMaxSessionLifetime caps sessions at d from their start: every rotation
+// still extends the refresh window, but never beyond that point, after which
+// the user has to log in again. Zero leaves sessions unbounded.
+func WithMaxSessionLifetime(d time.Duration) Option {
+	return func(s *TokenService) {
+		s.maxSessionLifetime = d
+	}
+}
+
+// WithRememberMeTTL sets the refresh token lifetime of sessions started with
+// WithRememberMe. Zero gives them the regular lifetime.
+func WithRememberMeTTL(d time.Duration) Option {
+	return func(s *TokenService) {
+		s.rememberMeTTL = d
+	}
+}
+
+// WithRememberMe starts a long-lived session: its refresh tokens live for the
+// remember-me lifetime instead of the regular one, across rotations.
+func WithRememberMe() IssueOption {
+	return func(p *issueParams) {
+		p.rememberMe = true
+	}
+}
+
+// WithSessionLimit refuses to start the session with ErrTooManySessions
+// when the user already has max live sessions; 0 means no limit. Counting
+// the sessions and adding the new one to the index are one atomic step, so
+// concurrent logins cannot both take the last slot.
+func WithSessionLimit(max int) IssueOption {
+	return func(p *issueParams) {
+		p.maxSessions = max
+	}
+}
+
+// refreshLifetime is the TTL of a refresh token issued at now for a session
+// created at created: the sliding window, capped by the session lifetime.
+// Sessions of unknown age are not capped.
+func (s *TokenService) refreshLifetime(created, now time.Time, rememberMe bool) time.Duration {
+	ttl := s.refreshTTL
+	if rememberMe && s.rememberMeTTL > 0 {
+		ttl = s.rememberMeTTL
+	}
+	if s.maxSessionLifetime <= 0 || created.IsZero() {
+		return ttl
+	}
+	return min(ttl, created.Add(s.maxSessionLifetime).Sub(now))
+}
+
+// longestRefreshTTL bounds the lifetime of any refresh token.
+func (s *TokenService) longestRefreshTTL() time.Duration {
+	return max(s.refreshTTL, s.rememberMeTTL)
+}
+
+// WithDeviceBinding sets the policy for refresh tokens presented from another
+// device. Devices are told apart by their device ID or, for clients that send
+// none, by their user agent.
+func WithDeviceBinding(policy DeviceBinding) Option {
+	return func(s *TokenService) {
+		s.deviceBinding = policy
+	}
+}
+
+// checkDeviceBinding applies the device binding policy to the rotation of the
+// refresh token stored as stored by the client ci.
+func (s *TokenService) checkDeviceBinding(ctx context.Context, userID string, stored map[string]string, ci ClientInfo) error {
+	if s.deviceBinding != DeviceBindingWarn && s.deviceBinding != DeviceBindingEnforce {
+		return nil
+	}
+	field, bound, presented := "device_id", stored["device_id"], ci.DeviceID
+	if bound == "" {
+		field, bound, presented = "user_agent", stored["user_agent"], ci.UserAgent
+	}
+	if bound == "" || subtle.ConstantTimeCompare([]byte(bound), []byte(presented)) == 1 {
+		return nil
+	}
+	enforce := s.deviceBinding == DeviceBindingEnforce
+	logger.FromContext(ctx).Warn("Refresh token presented from another device",
+		zap.String("user_id", userID),
+		zap.String("session_id", stored["sid"]),
+		zap.String("mismatch", field),
+		zap.String("ip", ci.IP),
+		zap.Bool("rejected", enforce),
+	)
+	if enforce {
+		return autherr.ErrInvalidToken
+	}
+	return nil
+}
+
+// Session is an active refresh token chain of a user. Its ID stays the same
+// across rotations.
+type Session struct {
+	ID     string
+	UserID string
+	Client ClientInfo
+
+	CreatedAt time.Time
+	// IssuedAt is when the current refresh token of the chain was issued.
+	IssuedAt   time.Time
+	LastUsedAt time.Time
+	ExpiresAt  time.Time
+}
+
+// ListSessions returns the active sessions of userID, most recently used first.
+// Index entries whose token has expired or was revoked are pruned.
+func (s *TokenService) ListSessions(ctx context.Context, userID string) ([]Session, error) {
+	index, err := s.rdb.HGetAll(ctx, userSessionsKey(userID)).Result()
+	if err != nil {
+		return nil, autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	if len(index) == 0 {
+		return nil, nil
+	}
+
+	type pending struct {
+		sid    string
+		fields *redis.MapStringStringCmd
+		ttl    *redis.DurationCmd
+	}
+	cmds := make([]pending, 0, len(index))
+	pipe := s.rdb.Pipeline()
+	for sid, hash := range index {
+		key := redisKey(hash)
+		cmds = append(cmds, pending{sid: sid, fields: pipe.HGetAll(ctx, key), ttl: pipe.TTL(ctx, key)})
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, autherr.ErrStorageError.WithMessage(err.Error())
+	}
+
+	now := s.now().UTC()
+	sessions := make([]Session, 0, len(cmds))
+	var stale []string
+	for _, c := range cmds {
+		f := c.fields.Val()
+		if len(f) == 0 || f["user_id"] != userID {
+			stale = append(stale, c.sid)
+			continue
+		}
+		sess := Session{
+			ID:     c.sid,
+			UserID: userID,
+			Client: ClientInfo{
+				IP:        f["ip"],
+				UserAgent: f["user_agent"],
+				DeviceID:  f["device_id"],
+				Location:  f["location"],
+			},
+			CreatedAt:  unixField(f, "created_at"),
+			IssuedAt:   unixField(f, "issued_at"),
+			LastUsedAt: unixField(f, "last_used"),
+		}
+		if ttl := c.ttl.Val(); ttl > 0 {
+			sess.ExpiresAt = now.Add(ttl).Truncate(time.Second)
+		}
+		sessions = append(sessions, sess)
+	}
+	if len(stale) > 0 {
+		_ = s.rdb.HDel(ctx, userSessionsKey(userID), stale...).Err()
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastUsedAt.After(sessions[j].LastUsedAt)
+	})
+	return sessions, nil
+}
+
+// SessionOf returns the session ID carried by a validated access token, or ""
+// for tokens not tied to a session.
+func (s *TokenService) SessionOf(ctx context.Context, tokenStr string) string {
+	claims, err := s.accessClaims(ctx, tokenStr)
+	if err != nil {
+		return ""
+	}
+	return claims.SessionID
+}
+
+// RevokeSession ends a session of userID by deleting its current refresh token.
+// Access tokens already issued for the session stay valid until they expire.
+func (s *TokenService) RevokeSession(ctx context.Context, userID, sessionID string) error {
+	indexKey := userSessionsKey(userID)
+	hash, err := s.rdb.HGet(ctx, indexKey, sessionID).Result()
+	if err == redis.Nil {
+		return autherr.ErrNotFound
+	}
+	if err != nil {
+		return autherr.ErrStorageError.WithMessage(err.Error())
+	}
+
+	pipe := s.rdb.TxPipeline()
+	pipe.Del(ctx, redisKey(hash))
+	pipe.HDel(ctx, indexKey, sessionID)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	metrics.Revocations.WithLabelValues("session").Inc()
+	return s.forgetRefresh(ctx, hash)
+}
+
+// revokeAllScript deletes the refresh tokens of every session in the index
+// KEYS[1] except ARGV[1], so that a concurrent rotation cannot slip a new
+// token past it. It returns the number of tokens deleted.
+var revokeAllScript = `
+local index = redis.call("HGETALL", KEYS[1])
+local n = 0
+for i = 1, #index, 2 do
+  if index[i] ~= ARGV[1] then
+    n = n + redis.call("DEL", ARGV[2] .. index[i+1])
+    redis.call("HDEL", KEYS[1], index[i])
+  end
+end
+return n
+`
+
+// RevokeAllSessions ends every session of userID except keep, which may be
+// empty, logging the user out everywhere. It returns how many sessions were
+// ended. Access tokens already issued stay valid until they expire.
+func (s *TokenService) RevokeAllSessions(ctx context.Context, userID, keep string) (int, error) {
+	var n int
+	var err error
+	if s.cluster {
+		n, err = s.revokeAllClustered(ctx, userID, keep)
+	} else {
+		n, err = s.rdb.Eval(ctx, revokeAllScript, []string{userSessionsKey(userID)}, keep, redisKey("")).Int()
+	}
+	if err != nil {
+		return 0, autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	if s.refreshStore != nil {
+		// also covers sessions not restored to Redis since a flush
+		stored, err := s.refreshStore.DeleteByUser(ctx, userID, keep)
+		if err != nil {
+			return 0, autherr.ErrStorageError.WithMessage(err.Error())
+		}
+		n = max(n, int(stored))
+	}
+	metrics.Revocations.WithLabelValues("session").Add(float64(n))
+	return n, nil
+}
+
+// indexCappedScript records ARGV[1] as pointing to the refresh token hash
+// ARGV[2] in the session index KEYS[1], unless the index already holds
+// ARGV[3] sessions whose refresh token (prefix ARGV[5]) is live; entries of
+// gone tokens are dropped on the way. ARGV[4] is the index TTL in seconds.
+// It returns 0 when the cap was reached.
+var indexCappedScript = `
+local index = redis.call("HGETALL", KEYS[1])
+local live = 0
+for i = 1, #index, 2 do
+  if redis.call("EXISTS", ARGV[5] .. index[i+1]) == 1 then
+    live = live + 1
+  else
+    redis.call("HDEL", KEYS[1], index[i])
+  end
+end
+if live >= tonumber(ARGV[3]) then
+  return 0
+end
+redis.call("HSET", KEYS[1], ARGV[1], ARGV[2])
+redis.call("EXPIRE", KEYS[1], tonumber(ARGV[4]))
+return 1
+`
+
+// indexNewSession indexes a new session like indexSession, refusing it with
+// ErrTooManySessions when userID already has max live sessions.
+func (s *TokenService) indexNewSession(ctx context.Context, userID, sessionID, hash string, max int) error {
+	if max <= 0 {
+		return s.indexSession(ctx, userID, sessionID, hash)
+	}
+	var added bool
+	var err error
+	if s.cluster {
+		added, err = s.indexCappedClustered(ctx, userID, sessionID, hash, max)
+	} else {
+		ttl := int64(s.longestRefreshTTL().Seconds())
+		added, err = s.rdb.Eval(ctx, indexCappedScript, []string{userSessionsKey(userID)},
+			sessionID, hash, max, ttl, redisKey("")).Bool()
+	}
+	if err != nil {
+		return autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	if !added {
+		return autherr.ErrTooManySessions
+	}
+	return nil
+}
+
+// indexSession records sessionID of userID as pointing to the refresh token hash.
+func (s *TokenService) indexSession(ctx context.Context, userID, sessionID, hash string) error {
+	key := userSessionsKey(userID)
+	pipe := s.rdb.TxPipeline()
+	pipe.HSet(ctx, key, sessionID, hash)
+	pipe.Expire(ctx, key, s.longestRefreshTTL())
+	if _, err := pipe.Exec(ctx); err != nil {
+		return autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	return nil
+}
+
+// userSessionsKey is a Redis hash of session ID -> current refresh token hash.
+func userSessionsKey(userID string) string {
+	return "refresh:user:" + userID
+}
+
+func unixField(fields map[string]string, name string) time.Time {
+	sec, err := strconv.ParseInt(fields[name], 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(sec, 0).UTC()
+}
@@ -0,0 +1,292 @@
+package services
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/andro-kes/auth_service/internal/autherr"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestSessions(t *testing.T) {
+	svc, _ := newTestTokenService(t)
+
+	ctx := t.Context()
+
+	phoneAccess, phone, _, _, err := svc.GenerateTokens(ctx, "user-123", WithClientInfo(ClientInfo{DeviceID: "phone", IP: "10.0.0.1"}))
+	if err != nil {
+		t.Fatalf("GenerateTokens failed: %v", err)
+	}
+	if _, _, _, _, err := svc.GenerateTokens(ctx, "user-123", WithClientInfo(ClientInfo{DeviceID: "laptop"})); err != nil {
+		t.Fatalf("GenerateTokens failed: %v", err)
+	}
+
+	sessions, err := svc.ListSessions(ctx, "user-123")
+	if err != nil {
+		t.Fatalf("ListSessions failed: %v", err)
+	}
+	if len(sessions) != 2 {
+		t.Fatalf("expected 2 sessions, got %d", len(sessions))
+	}
+
+	var phoneID string
+	for _, s := range sessions {
+		if s.IssuedAt.IsZero() {
+			t.Fatalf("session %s lacks issued_at", s.ID)
+		}
+		if s.Client.DeviceID == "phone" {
+			phoneID = s.ID
+		}
+	}
+	if phoneID == "" {
+		t.Fatal("phone session not listed")
+	}
+	if got := svc.SessionOf(ctx, phoneAccess); got != phoneID {
+		t.Fatalf("SessionOf = %q, want %q", got, phoneID)
+	}
+
+	// rotation keeps the session identity
+	if _, phone, _, _, err = svc.RotateRefresh(ctx, phone, "user-123"); err != nil {
+		t.Fatalf("RotateRefresh failed: %v", err)
+	}
+	sessions, err = svc.ListSessions(ctx, "user-123")
+	if err != nil {
+		t.Fatalf("ListSessions failed: %v", err)
+	}
+	if len(sessions) != 2 {
+		t.Fatalf("expected 2 sessions after rotation, got %d", len(sessions))
+	}
+
+	if err := svc.RevokeSession(ctx, "other-user", phoneID); err != autherr.ErrNotFound {
+		t.Fatalf("expected ErrNotFound for another user's session, got %v", err)
+	}
+	if err := svc.RevokeSession(ctx, "user-123", phoneID); err != nil {
+		t.Fatalf("RevokeSession failed: %v", err)
+	}
+	if _, err := svc.ValidateRefresh(ctx, phone); err != autherr.ErrInvalidToken {
+		t.Fatalf("expected revoked session token to be invalid, got %v", err)
+	}
+
+	sessions, err = svc.ListSessions(ctx, "user-123")
+	if err != nil {
+		t.Fatalf("ListSessions failed: %v", err)
+	}
+	if len(sessions) != 1 || sessions[0].Client.DeviceID != "laptop" {
+		t.Fatalf("expected only the laptop session to remain, got %+v", sessions)
+	}
+}
+
+func TestSessionLimit(t *testing.T) {
+	svc, _ := newTestTokenService(t)
+	ctx := t.Context()
+
+	// concurrent logins cannot both take the last slot
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var started, refused int
+	var refresh []string
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, rt, _, _, err := svc.GenerateTokens(ctx, "alice", WithSessionLimit(3))
+			mu.Lock()
+			defer mu.Unlock()
+			switch err {
+			case nil:
+				started++
+				refresh = append(refresh, rt)
+			case autherr.ErrTooManySessions:
+				refused++
+			default:
+				t.Errorf("GenerateTokens failed: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if started != 3 || refused != 5 {
+		t.Fatalf("expected 3 sessions started and 5 refused, got %d and %d", started, refused)
+	}
+	if sessions, _ := svc.ListSessions(ctx, "alice"); len(sessions) != 3 {
+		t.Fatalf("expected 3 sessions, got %d", len(sessions))
+	}
+
+	// rotation keeps its session; an ended session frees its slot
+	if _, _, _, _, err := svc.RotateRefresh(ctx, refresh[0], "alice", WithSessionLimit(3)); err != nil {
+		t.Fatalf("expected rotation at the cap to succeed, got %v", err)
+	}
+	if err := svc.RevokeRefreshByRaw(ctx, refresh[1]); err != nil {
+		t.Fatalf("RevokeRefreshByRaw failed: %v", err)
+	}
+	if _, _, _, _, err := svc.GenerateTokens(ctx, "alice", WithSessionLimit(3)); err != nil {
+		t.Fatalf("expected a freed slot to be reused, got %v", err)
+	}
+	if _, _, _, _, err := svc.GenerateTokens(ctx, "bob", WithSessionLimit(3)); err != nil {
+		t.Fatalf("expected other users not to be affected, got %v", err)
+	}
+}
+
+func TestRotateRefresh_DeviceBinding(t *testing.T) {
+	srv, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("failed to start miniredis: %v", err)
+	}
+	defer srv.Close()
+
+	rdb := redis.NewClient(&redis.Options{Addr: srv.Addr()})
+	defer rdb.Close()
+
+	ctx := t.Context()
+	phone := WithClientInfo(ClientInfo{DeviceID: "phone", UserAgent: "app/1.0"})
+	laptop := WithClientInfo(ClientInfo{DeviceID: "laptop", UserAgent: "app/1.0"})
+
+	for _, tc := range []struct {
+		policy DeviceBinding
+		want   error
+	}{
+		{DeviceBindingOff, nil},
+		{DeviceBindingWarn, nil},
+		{DeviceBindingEnforce, autherr.ErrInvalidToken},
+	} {
+		svc, err := NewTokenService(rdb, "012345678901234567890123456789ab", time.Second*5, time.Minute*5, WithDeviceBinding(tc.policy))
+		if err != nil {
+			t.Fatalf("failed to create TokenService: %v", err)
+		}
+		_, refresh, _, _, err := svc.GenerateTokens(ctx, "user-123", phone)
+		if err != nil {
+			t.Fatalf("GenerateTokens failed: %v", err)
+		}
+		if _, _, _, _, err := svc.RotateRefresh(ctx, refresh, "user-123", laptop); err != tc.want {
+			t.Fatalf("%s: expected %v from another device, got %v", tc.policy, tc.want, err)
+		}
+		if tc.want == nil {
+			continue
+		}
+		if _, _, _, _, err := svc.RotateRefresh(ctx, refresh, "user-123", phone); err != nil {
+			t.Fatalf("%s: rotation from the bound device failed: %v", tc.policy, err)
+		}
+	}
+}
+
+func TestRevokeAllSessions(t *testing.T) {
+	svc, _ := newTestTokenService(t)
+
+	ctx := t.Context()
+	var refreshes []string
+	for range 3 {
+		_, refresh, _, _, err := svc.GenerateTokens(ctx, "user-123")
+		if err != nil {
+			t.Fatalf("GenerateTokens failed: %v", err)
+		}
+		refreshes = append(refreshes, refresh)
+	}
+	access, kept, _, _, err := svc.GenerateTokens(ctx, "user-123")
+	if err != nil {
+		t.Fatalf("GenerateTokens failed: %v", err)
+	}
+	_, other, _, _, err := svc.GenerateTokens(ctx, "user-456")
+	if err != nil {
+		t.Fatalf("GenerateTokens failed: %v", err)
+	}
+
+	n, err := svc.RevokeAllSessions(ctx, "user-123", svc.SessionOf(ctx, access))
+	if err != nil {
+		t.Fatalf("RevokeAllSessions failed: %v", err)
+	}
+	if n != 3 {
+		t.Fatalf("expected 3 revoked sessions, got %d", n)
+	}
+	for _, r := range refreshes {
+		if _, err := svc.ValidateRefresh(ctx, r); err != autherr.ErrInvalidToken {
+			t.Fatalf("expected revoked refresh token, got %v", err)
+		}
+	}
+	for _, r := range []string{kept, other} {
+		if _, err := svc.ValidateRefresh(ctx, r); err != nil {
+			t.Fatalf("expected refresh token to survive, got %v", err)
+		}
+	}
+
+	if n, err := svc.RevokeAllSessions(ctx, "user-123", ""); err != nil || n != 1 {
+		t.Fatalf("RevokeAllSessions = %d, %v; want 1", n, err)
+	}
+	if sessions, err := svc.ListSessions(ctx, "user-123"); err != nil || len(sessions) != 0 {
+		t.Fatalf("expected no sessions left, got %d, %v", len(sessions), err)
+	}
+}
+
+func TestRotateRefresh_MaxSessionLifetime(t *testing.T) {
+	svc, srv := newTestTokenService(t, WithMaxSessionLifetime(24*time.Hour))
+
+	ctx := t.Context()
+	_, refresh, _, refreshExp, err := svc.GenerateTokens(ctx, "user-123")
+	if err != nil {
+		t.Fatalf("GenerateTokens failed: %v", err)
+	}
+	if d := time.Until(refreshExp); d < 4*time.Minute {
+		t.Fatalf("expected a full refresh window, got %v", d)
+	}
+
+	// near the end of the session the window shrinks to what is left
+	started := time.Now().Add(-24*time.Hour + 30*time.Second)
+	srv.HSet(redisKey(sha256Hex(refresh)), "created_at", strconv.FormatInt(started.Unix(), 10))
+	_, rotated, _, refreshExp, err := svc.RotateRefresh(ctx, refresh, "user-123")
+	if err != nil {
+		t.Fatalf("RotateRefresh failed: %v", err)
+	}
+	if d := time.Until(refreshExp); d > 31*time.Second {
+		t.Fatalf("expected the window to be capped by the session lifetime, got %v", d)
+	}
+	if ttl := srv.TTL(redisKey(sha256Hex(rotated))); ttl > 31*time.Second {
+		t.Fatalf("expected capped Redis TTL, got %v", ttl)
+	}
+
+	srv.HSet(redisKey(sha256Hex(rotated)), "created_at", strconv.FormatInt(time.Now().Add(-25*time.Hour).Unix(), 10))
+	if _, _, _, _, err := svc.RotateRefresh(ctx, rotated, "user-123"); err != autherr.ErrInvalidToken {
+		t.Fatalf("expected an expired session to be rejected, got %v", err)
+	}
+}
+
+func TestRotateRefresh_RememberMe(t *testing.T) {
+	svc, srv := newTestTokenService(t, WithRememberMeTTL(30*24*time.Hour))
+
+	ctx := t.Context()
+	_, short, _, refreshExp, err := svc.GenerateTokens(ctx, "user-123")
+	if err != nil {
+		t.Fatalf("GenerateTokens failed: %v", err)
+	}
+	if d := time.Until(refreshExp); d > time.Hour {
+		t.Fatalf("expected the regular refresh window, got %v", d)
+	}
+	if _, _, _, refreshExp, err = svc.RotateRefresh(ctx, short, "user-123"); err != nil {
+		t.Fatalf("RotateRefresh failed: %v", err)
+	}
+	if d := time.Until(refreshExp); d > time.Hour {
+		t.Fatalf("expected rotation to keep the regular window, got %v", d)
+	}
+
+	_, long, _, refreshExp, err := svc.GenerateTokens(ctx, "user-123", WithRememberMe())
+	if err != nil {
+		t.Fatalf("GenerateTokens failed: %v", err)
+	}
+	if d := time.Until(refreshExp); d < 29*24*time.Hour {
+		t.Fatalf("expected the remember-me window, got %v", d)
+	}
+	_, rotated, _, refreshExp, err := svc.RotateRefresh(ctx, long, "user-123")
+	if err != nil {
+		t.Fatalf("RotateRefresh failed: %v", err)
+	}
+	if d := time.Until(refreshExp); d < 29*24*time.Hour {
+		t.Fatalf("expected rotation to keep the remember-me window, got %v", d)
+	}
+	if ttl := srv.TTL(redisKey(sha256Hex(rotated))); ttl < 29*24*time.Hour {
+		t.Fatalf("expected remember-me Redis TTL, got %v", ttl)
+	}
+	// the session index must outlive the longest session
+	if ttl := srv.TTL(userSessionsKey("user-123")); ttl < 29*24*time.Hour {
+		t.Fatalf("session index expires before the session: %v", ttl)
+	}
+}
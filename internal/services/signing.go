@@ -0,0 +1,228 @@
+package services
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/andro-kes/auth_service/internal/metrics"
+	"github.com/andro-kes/auth_service/internal/signing"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// WithSigningMigration signs new access tokens with next. Tokens signed with
+// the service secret keep validating until cutoff, so that a key or
+// algorithm change (e.g. HS256 to RS256) does not log anybody out. A zero
+// cutoff accepts next-signed tokens only.
+func WithSigningMigration(next *signing.Key, cutoff time.Time) Option {
+	return func(s *TokenService) {
+		s.nextKey = next
+		s.migrationCutoff = cutoff
+	}
+}
+
+// WithPreviousKeys keeps accepting tokens signed with keys rotated out
+// earlier, identified by their "kid". They verify until removed from the
+// options; only the newest key signs.
+func WithPreviousKeys(keys ...*signing.Key) Option {
+	return func(s *TokenService) {
+		s.previousKeys = append(s.previousKeys, keys...)
+	}
+}
+
+// WithPreviousSecrets keeps accepting tokens signed with HMAC secrets
+// rotated out earlier, so that replacing the service secret does not
+// invalidate every outstanding access token at once. The service secret
+// alone signs.
+func WithPreviousSecrets(secrets ...string) Option {
+	return func(s *TokenService) {
+		for _, secret := range secrets {
+			s.previousSecrets = append(s.previousSecrets, []byte(secret))
+		}
+	}
+}
+
+// WithIssuer stamps tokens with issuer as their "iss" claim, the URL the
+// OIDC discovery document is served at. Tokens without it stay valid, so
+// that configuring an issuer does not invalidate outstanding ones.
+func WithIssuer(issuer string) Option {
+	return func(s *TokenService) {
+		s.issuer = issuer
+	}
+}
+
+// keyStats counts tokens verified with one key of the ring. The counters
+// are per instance; metrics aggregate them.
+type keyStats struct {
+	verified atomic.Int64
+	lastSeen atomic.Int64 // Unix nanoseconds
+}
+
+var (
+	verifiedCurrent  = metrics.AccessTokensVerified.WithLabelValues("current")
+	verifiedPrevious = metrics.AccessTokensVerified.WithLabelValues("previous")
+)
+
+// setupSigning builds the key ring: the newest key signs, the service
+// secret (when replaced, until the migration cutoff) and previously rotated
+// keys and secrets still verify.
+func (s *TokenService) setupSigning() {
+	secretKey := signing.HMAC(s.crypto.SigningMethod(), s.secret)
+	if s.nextKey == nil {
+		s.keys = signing.NewKeyRing(secretKey)
+	} else {
+		s.keys = signing.NewKeyRing(s.nextKey)
+	}
+	for _, k := range s.previousKeys {
+		s.keys.Accept(k, time.Time{})
+	}
+	for _, secret := range s.previousSecrets {
+		s.keys.Accept(signing.HMAC(s.crypto.SigningMethod(), secret), time.Time{})
+	}
+	if s.nextKey != nil && s.migrationCutoff.After(s.now()) {
+		s.keys.Accept(secretKey, s.migrationCutoff)
+		metrics.SigningMigrationCutoff.Set(float64(s.migrationCutoff.Unix()))
+	}
+
+	s.keyStats = make(map[*signing.Key]*keyStats)
+	for _, k := range s.keys.Keys() {
+		s.keyStats[k] = &keyStats{}
+	}
+	s.signingSince = s.now()
+}
+
+// verifyAccessJWT checks tokenStr against the keys it may have been signed
+// with, selected by its "kid" and algorithm.
+func (s *TokenService) verifyAccessJWT(tokenStr string) (*tokenClaims, error) {
+	tok, _, err := jwt.NewParser().ParseUnverified(tokenStr, &tokenClaims{})
+	if err != nil {
+		return nil, err
+	}
+	err = errNoMatchingKey
+	for _, key := range s.keys.Candidates(tok) {
+		var claims *tokenClaims
+		if claims, err = parseWith(tokenStr, key, jwt.WithLeeway(s.leeway), jwt.WithTimeFunc(s.now)); err == nil {
+			s.observe(key)
+			return claims, nil
+		}
+	}
+	return nil, err
+}
+
+func (s *TokenService) observe(key *signing.Key) {
+	if key == s.keys.Signer() {
+		verifiedCurrent.Inc()
+	} else {
+		verifiedPrevious.Inc()
+	}
+	if st := s.keyStats[key]; st != nil {
+		st.verified.Add(1)
+		st.lastSeen.Store(s.now().UnixNano())
+	}
+}
+
+// parseWith verifies tokenStr with key and validates its registered claims.
+func parseWith(tokenStr string, key *signing.Key, opts ...jwt.ParserOption) (*tokenClaims, error) {
+	opts = append(opts, jwt.WithValidMethods([]string{key.Method.Alg()}))
+	tok, err := jwt.ParseWithClaims(tokenStr, &tokenClaims{}, func(t *jwt.Token) (any, error) {
+		return key.VerificationKey(), nil
+	}, opts...)
+	if err != nil {
+		return nil, err
+	}
+	claims, ok := tok.Claims.(*tokenClaims)
+	if !ok || !tok.Valid {
+		return nil, jwt.ErrTokenInvalidClaims
+	}
+	return claims, nil
+}
+
+var errNoMatchingKey = errors.New("no signing key matches the token")
+
+// KeyStatus describes one key of the ring as observed by this instance.
+type KeyStatus struct {
+	ID        string
+	Algorithm string
+	Signing   bool
+	// RetireAt is when an older key stops verifying; zero if it is kept
+	// until removed from the configuration.
+	RetireAt time.Time
+	Verified int64
+	LastSeen time.Time
+}
+
+// SigningStatus describes the key ring and the progress of a rotation away
+// from the older keys, as observed by this instance.
+type SigningStatus struct {
+	KeyID     string
+	Algorithm string
+	Keys      []KeyStatus
+
+	// Migrating is set while tokens of older keys are accepted.
+	Migrating         bool
+	PreviousAlgorithm string
+	// Cutoff is the earliest retirement of an older key, if any.
+	Cutoff           time.Time
+	VerifiedCurrent  int64
+	VerifiedPrevious int64
+	LastPrevious     time.Time
+	// SafeToRetire is set once no older-key token can still be valid: none
+	// has been seen for a full access token lifetime.
+	SafeToRetire bool
+}
+
+// SigningStatus reports the key ring and rotation progress.
+func (s *TokenService) SigningStatus() SigningStatus {
+	signer := s.keys.Signer()
+	st := SigningStatus{KeyID: signer.ID, Algorithm: signer.Method.Alg()}
+	quietSince := s.signingSince
+	for _, k := range s.keys.Keys() {
+		ks := KeyStatus{
+			ID:        k.ID,
+			Algorithm: k.Method.Alg(),
+			Signing:   k == signer,
+			RetireAt:  s.keys.RetireAt(k),
+		}
+		if stats := s.keyStats[k]; stats != nil {
+			ks.Verified = stats.verified.Load()
+			if ns := stats.lastSeen.Load(); ns != 0 {
+				ks.LastSeen = time.Unix(0, ns).UTC()
+			}
+		}
+		st.Keys = append(st.Keys, ks)
+
+		if ks.Signing {
+			st.VerifiedCurrent = ks.Verified
+			continue
+		}
+		st.Migrating = true
+		if st.PreviousAlgorithm == "" {
+			st.PreviousAlgorithm = ks.Algorithm
+		}
+		if !ks.RetireAt.IsZero() && (st.Cutoff.IsZero() || ks.RetireAt.Before(st.Cutoff)) {
+			st.Cutoff = ks.RetireAt
+		}
+		st.VerifiedPrevious += ks.Verified
+		if ks.LastSeen.After(st.LastPrevious) {
+			st.LastPrevious = ks.LastSeen
+		}
+	}
+	if !st.Migrating {
+		return st
+	}
+
+	// new tokens are never signed with older keys, so once the last one seen
+	// (or the switch to the signer) is older than an access token lifetime
+	// all of them have expired
+	if st.LastPrevious.After(quietSince) {
+		quietSince = st.LastPrevious
+	}
+	st.SafeToRetire = s.now().Sub(quietSince) > s.accessTTL
+	return st
+}
+
+// VerificationKeys returns the keys access tokens are accepted with, the
+// signing key first.
+func (s *TokenService) VerificationKeys() []*signing.Key {
+	return s.keys.Keys()
+}
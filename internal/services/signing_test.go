@@ -0,0 +1,194 @@
+package services
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/andro-kes/auth_service/internal/autherr"
+	"github.com/andro-kes/auth_service/internal/signing"
+	"github.com/redis/go-redis/v9"
+)
+
+func newTestSigningKey(t *testing.T) *signing.Key {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := signing.ParsePrivateKeyPEM(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	if err != nil {
+		t.Fatalf("ParsePrivateKeyPEM failed: %v", err)
+	}
+	return key
+}
+
+func TestSigningMigration(t *testing.T) {
+	const secret = "012345678901234567890123456789ab"
+	next := newTestSigningKey(t)
+
+	before, _ := newTestTokenService(t)
+	rdb := before.rdb
+	old, _, _, _, err := before.GenerateTokens(t.Context(), "alice")
+	if err != nil {
+		t.Fatalf("GenerateTokens failed: %v", err)
+	}
+
+	during, err := NewTokenService(rdb, secret, time.Minute, time.Minute*5, WithSigningMigration(next, time.Now().Add(time.Hour)))
+	if err != nil {
+		t.Fatalf("failed to create TokenService: %v", err)
+	}
+	if _, err := during.ValidateAccess(old); err != nil {
+		t.Fatalf("expected token of the previous key to validate before the cutoff, got %v", err)
+	}
+	fresh, _, _, _, err := during.GenerateTokens(t.Context(), "alice")
+	if err != nil {
+		t.Fatalf("GenerateTokens failed: %v", err)
+	}
+	if _, err := during.ValidateAccess(fresh); err != nil {
+		t.Fatalf("expected token of the new key to validate, got %v", err)
+	}
+	if _, err := before.ValidateAccess(fresh); err != autherr.ErrInvalidToken {
+		t.Fatalf("expected the old key not to accept new tokens, got %v", err)
+	}
+
+	st := during.SigningStatus()
+	if !st.Migrating || st.Algorithm != "EdDSA" || st.PreviousAlgorithm != "HS256" || st.KeyID != next.ID {
+		t.Fatalf("unexpected status %+v", st)
+	}
+	if st.VerifiedPrevious != 1 || st.VerifiedCurrent != 1 || st.LastPrevious.IsZero() || st.SafeToRetire {
+		t.Fatalf("unexpected migration progress %+v", st)
+	}
+
+	after, err := NewTokenService(rdb, secret, time.Minute, time.Minute*5, WithSigningMigration(next, time.Time{}))
+	if err != nil {
+		t.Fatalf("failed to create TokenService: %v", err)
+	}
+	if _, err := after.ValidateAccess(old); err != autherr.ErrInvalidToken {
+		t.Fatalf("expected token of the previous key to be rejected after the migration, got %v", err)
+	}
+	if after.SigningStatus().Migrating {
+		t.Fatal("expected no migration without a cutoff")
+	}
+}
+
+func TestSigningKeyRotation(t *testing.T) {
+	const secret = "012345678901234567890123456789ab"
+	oldKey, newKey := newTestSigningKey(t), newTestSigningKey(t)
+
+	before, _ := newTestTokenService(t, WithSigningMigration(oldKey, time.Time{}))
+	rdb := before.rdb
+	old, _, _, _, err := before.GenerateTokens(t.Context(), "alice")
+	if err != nil {
+		t.Fatalf("GenerateTokens failed: %v", err)
+	}
+
+	rotated, err := NewTokenService(rdb, secret, time.Minute, time.Minute*5,
+		WithSigningMigration(newKey, time.Time{}), WithPreviousKeys(oldKey))
+	if err != nil {
+		t.Fatalf("failed to create TokenService: %v", err)
+	}
+	if _, err := rotated.ValidateAccess(old); err != nil {
+		t.Fatalf("expected token of the rotated-out key to validate, got %v", err)
+	}
+	fresh, _, _, _, err := rotated.GenerateTokens(t.Context(), "alice")
+	if err != nil {
+		t.Fatalf("GenerateTokens failed: %v", err)
+	}
+	if _, err := before.ValidateAccess(fresh); err != autherr.ErrInvalidToken {
+		t.Fatalf("expected the old ring not to know the new kid, got %v", err)
+	}
+
+	st := rotated.SigningStatus()
+	if len(st.Keys) != 2 || !st.Keys[0].Signing || st.Keys[0].ID != newKey.ID || st.Keys[1].ID != oldKey.ID {
+		t.Fatalf("unexpected key ring status %+v", st.Keys)
+	}
+	if st.Keys[1].Verified != 1 || !st.Migrating || !st.Cutoff.IsZero() {
+		t.Fatalf("unexpected rotation progress %+v", st)
+	}
+
+	retired, err := NewTokenService(rdb, secret, time.Minute, time.Minute*5, WithSigningMigration(newKey, time.Time{}))
+	if err != nil {
+		t.Fatalf("failed to create TokenService: %v", err)
+	}
+	if _, err := retired.ValidateAccess(old); err != autherr.ErrInvalidToken {
+		t.Fatalf("expected token of a retired key to be rejected, got %v", err)
+	}
+}
+
+func TestPreviousSecrets(t *testing.T) {
+	srv, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("failed to start miniredis: %v", err)
+	}
+	defer srv.Close()
+
+	rdb := redis.NewClient(&redis.Options{Addr: srv.Addr()})
+	defer rdb.Close()
+
+	const oldSecret = "012345678901234567890123456789ab"
+	const newSecret = "ba987654321098765432109876543210"
+	before, err := NewTokenService(rdb, oldSecret, time.Minute, time.Minute*5)
+	if err != nil {
+		t.Fatalf("failed to create TokenService: %v", err)
+	}
+	after, err := NewTokenService(rdb, newSecret, time.Minute, time.Minute*5, WithPreviousSecrets(oldSecret))
+	if err != nil {
+		t.Fatalf("failed to create TokenService: %v", err)
+	}
+
+	ctx := t.Context()
+	oldToken, _, _, _, err := before.GenerateTokens(ctx, "alice")
+	if err != nil {
+		t.Fatalf("GenerateTokens failed: %v", err)
+	}
+	newToken, _, _, _, err := after.GenerateTokens(ctx, "bob")
+	if err != nil {
+		t.Fatalf("GenerateTokens failed: %v", err)
+	}
+
+	if claims, err := after.ValidateAccess(oldToken); err != nil || claims.UserID != "alice" {
+		t.Fatalf("expected token of the previous secret to validate, got %+v, %v", claims, err)
+	}
+	if claims, err := after.ValidateAccess(newToken); err != nil || claims.UserID != "bob" {
+		t.Fatalf("expected token of the current secret to validate, got %+v, %v", claims, err)
+	}
+	if _, err := before.ValidateAccess(newToken); err != autherr.ErrInvalidToken {
+		t.Fatalf("expected the previous secret not to sign, got %v", err)
+	}
+}
+
+func TestIssuer(t *testing.T) {
+	const secret = "012345678901234567890123456789ab"
+	before, _ := newTestTokenService(t)
+	rdb := before.rdb
+	old, _, _, _, err := before.GenerateTokens(t.Context(), "alice")
+	if err != nil {
+		t.Fatalf("GenerateTokens failed: %v", err)
+	}
+
+	s, err := NewTokenService(rdb, secret, time.Minute, time.Minute*5, WithIssuer("https://auth.example.com"))
+	if err != nil {
+		t.Fatalf("failed to create TokenService: %v", err)
+	}
+	access, _, _, _, err := s.GenerateTokens(t.Context(), "alice")
+	if err != nil {
+		t.Fatalf("GenerateTokens failed: %v", err)
+	}
+	claims, err := s.accessClaims(t.Context(), access)
+	if err != nil || claims.Issuer != "https://auth.example.com" {
+		t.Fatalf("unexpected claims %+v, %v", claims, err)
+	}
+	// tokens issued without it stay valid
+	if _, err := s.ValidateAccess(old); err != nil {
+		t.Fatalf("expected a token without iss to validate, got %v", err)
+	}
+}
@@ -0,0 +1,153 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/andro-kes/auth_service/internal/autherr"
+	"github.com/andro-kes/auth_service/internal/logger"
+	"github.com/andro-kes/auth_service/internal/models"
+	"github.com/andro-kes/auth_service/internal/repo"
+	"github.com/andro-kes/auth_service/internal/repo/db"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// SetStatus changes the account status of userID. Disabled and banned users
+// can no longer log in; TokenService.SetUserStatus makes their tokens stop
+// working as well.
+func (us *UserService) SetStatus(ctx context.Context, userID, status string) error {
+	if userID == "" {
+		return autherr.ErrBadRequest.WithMessage("user_id is required")
+	}
+	if !validStatus(status) {
+		return autherr.ErrBadRequest.WithMessage("unknown account status")
+	}
+	err := us.Tx.RunInTx(ctx, func(ctx context.Context, q db.Querier) error {
+		return us.Repo.SetStatus(ctx, q, userID, status)
+	})
+	if err == autherr.ErrNotFound {
+		return autherr.ErrNotFound
+	}
+	if err != nil {
+		logger.FromContext(ctx).Error("Failed to set account status", zap.Error(err))
+		return autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	logger.FromContext(ctx).Warn("Account status changed",
+		zap.String("user_id", userID),
+		zap.String("status", status))
+	return nil
+}
+
+// ApproveUser activates a registration awaiting approval.
+func (us *UserService) ApproveUser(ctx context.Context, userID string) error {
+	user, err := us.GetUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if user.Status != models.UserStatusPending {
+		return autherr.ErrBadRequest.WithMessage("user is not awaiting approval")
+	}
+	return us.SetStatus(ctx, userID, models.UserStatusActive)
+}
+
+func validStatus(status string) bool {
+	switch status {
+	case models.UserStatusActive, models.UserStatusDisabled, models.UserStatusBanned, models.UserStatusPending:
+		return true
+	}
+	return false
+}
+
+// statusError is the error returned to a user whose account is not active.
+func statusError(status string) error {
+	switch status {
+	case "", models.UserStatusActive:
+		return nil
+	case models.UserStatusBanned:
+		return autherr.ErrAccountDisabled.WithMessage("account banned")
+	case models.UserStatusPending:
+		return autherr.ErrAccountDisabled.WithMessage("account awaiting approval")
+	default:
+		return autherr.ErrAccountDisabled
+	}
+}
+
+// SetUserStatus makes token validation follow the account status of userID:
+// the access and refresh tokens of disabled and banned users are rejected
+// with ErrAccountDisabled until the account is active again.
+func (s *TokenService) SetUserStatus(ctx context.Context, userID, status string) error {
+	if !validStatus(status) {
+		return autherr.ErrBadRequest.WithMessage("unknown account status")
+	}
+	var err error
+	if status == models.UserStatusActive {
+		err = s.rdb.Del(ctx, userStatusKey(userID)).Err()
+	} else {
+		err = s.rdb.Set(ctx, userStatusKey(userID), status, 0).Err()
+	}
+	if err != nil {
+		return autherr.ErrStorageError.WithMessage(err.Error())
+	}
+
+	if s.cache != nil {
+		s.cache.Purge()
+	}
+	payload, err := json.Marshal(revocationMessage{UserID: userID, Status: status})
+	if err != nil {
+		return autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	if err := s.rdb.Publish(ctx, revocationChannel, payload).Err(); err != nil {
+		logger.FromContext(ctx).Warn("Failed to publish account status", zap.Error(err))
+	}
+	return nil
+}
+
+// userStatusTTL bounds how long a status read from Postgres is cached in
+// Redis. SetUserStatus overwrites the cached value, so it only matters for
+// statuses changed behind the service's back.
+const userStatusTTL = 10 * time.Minute
+
+// WithUserStatuses reads the account status from users when Redis has none
+// cached for a user, instead of treating the user as active, and caches it.
+func WithUserStatuses(users repo.UserRepo) Option {
+	return func(s *TokenService) {
+		s.statuses = users
+	}
+}
+
+// checkUserStatus rejects the tokens of users that are not active.
+func (s *TokenService) checkUserStatus(ctx context.Context, userID string) error {
+	st, err := s.rdb.Get(ctx, userStatusKey(userID)).Result()
+	if err == nil {
+		return statusError(st)
+	}
+	if err != redis.Nil && !s.redisDown() {
+		return autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	if s.statuses == nil {
+		// in degraded mode tokens are trusted until they expire
+		return nil
+	}
+
+	// subjects without a user record, such as service accounts, are active
+	st = models.UserStatusActive
+	user, err := s.statuses.FindByID(ctx, userID)
+	if err != nil && err != autherr.ErrNotFound {
+		return autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	if err == nil && user.Status != "" {
+		st = user.Status
+	}
+	if !s.redisDown() {
+		if err := s.rdb.Set(ctx, userStatusKey(userID), st, userStatusTTL).Err(); err != nil {
+			logger.FromContext(ctx).Warn("Failed to cache account status", zap.Error(err))
+		}
+	}
+	return statusError(st)
+}
+
+func userStatusKey(userID string) string {
+	return "user:status:" + userID
+}
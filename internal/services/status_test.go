@@ -0,0 +1,74 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/andro-kes/auth_service/internal/models"
+	"github.com/andro-kes/auth_service/internal/tokencache"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestUserStatus(t *testing.T) {
+	svc, _ := newTestTokenService(t, WithValidationCache(tokencache.New(16, time.Minute)))
+
+	ctx := t.Context()
+	access, refresh, _, _, err := svc.GenerateTokens(ctx, "alice")
+	if err != nil {
+		t.Fatalf("GenerateTokens failed: %v", err)
+	}
+	if _, err := svc.ValidateAccess(access); err != nil {
+		t.Fatalf("ValidateAccess failed: %v", err)
+	}
+
+	if err := svc.SetUserStatus(ctx, "alice", models.UserStatusDisabled); err != nil {
+		t.Fatalf("SetUserStatus failed: %v", err)
+	}
+	if _, err := svc.ValidateAccess(access); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("expected PermissionDenied for a disabled user, got %v", err)
+	}
+	if _, _, _, _, err := svc.RotateRefresh(ctx, refresh, ""); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("expected PermissionDenied on refresh, got %v", err)
+	}
+	if in, err := svc.Introspect(ctx, access); err != nil || in.Active {
+		t.Fatalf("expected the token of a disabled user to be inactive, got %+v, %v", in, err)
+	}
+
+	if err := svc.SetUserStatus(ctx, "alice", models.UserStatusActive); err != nil {
+		t.Fatalf("SetUserStatus failed: %v", err)
+	}
+	if _, err := svc.ValidateAccess(access); err != nil {
+		t.Fatalf("ValidateAccess failed after re-enabling: %v", err)
+	}
+	if _, _, _, _, err := svc.RotateRefresh(ctx, refresh, ""); err != nil {
+		t.Fatalf("RotateRefresh failed after re-enabling: %v", err)
+	}
+}
+
+func TestUserStatusFromDatabase(t *testing.T) {
+	users := &testUserRepo{status: models.UserStatusBanned}
+	svc, srv := newTestTokenService(t, WithUserStatuses(users))
+
+	ctx := t.Context()
+	access, _, _, _, err := svc.GenerateTokens(ctx, "alice")
+	if err != nil {
+		t.Fatalf("GenerateTokens failed: %v", err)
+	}
+	// nothing is cached in Redis, so the status comes from the database
+	if _, err := svc.ValidateAccess(access); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("expected PermissionDenied for a banned user, got %v", err)
+	}
+	if got, _ := srv.Get(userStatusKey("alice")); got != models.UserStatusBanned {
+		t.Fatalf("expected the status cached, got %q", got)
+	}
+
+	users.status = models.UserStatusActive
+	srv.FastForward(userStatusTTL)
+	if _, err := svc.ValidateAccess(access); err != nil {
+		t.Fatalf("ValidateAccess failed once the cached status expired: %v", err)
+	}
+	if got, _ := srv.Get(userStatusKey("alice")); got != models.UserStatusActive {
+		t.Fatalf("expected the active status cached, got %q", got)
+	}
+}
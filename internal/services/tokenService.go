@@ -2,67 +2,224 @@ package services
 
 import (
 	"context"
-	"crypto/rand"
 	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/base64"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
-	"os"
+	"io"
+	"math"
+	"strconv"
 	"time"
 
 	"github.com/andro-kes/auth_service/internal/autherr"
+	"github.com/andro-kes/auth_service/internal/cryptoprov"
+	"github.com/andro-kes/auth_service/internal/dpop"
+	"github.com/andro-kes/auth_service/internal/logger"
+	"github.com/andro-kes/auth_service/internal/metrics"
+	"github.com/andro-kes/auth_service/internal/repo"
+	"github.com/andro-kes/auth_service/internal/signing"
+	"github.com/andro-kes/auth_service/internal/tokencache"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
 )
 
 type TokenService struct {
 	secret     []byte
+	pepper     []byte
 	accessTTL  time.Duration
 	refreshTTL time.Duration
-	rdb        *redis.Client
+	rdb        redis.UniversalClient
+	cache      *tokencache.Cache
+	// cluster is set on Redis Cluster, which rules out multi-key scripts
+	cluster    bool
+	outage     *outage
+	watermarks watermarks
+	onCanary   CanaryHandler
+	crypto     cryptoprov.Provider
+	clock      Clock
+	leeway     time.Duration
+	issuer     string
+
+	opaqueAccess  bool
+	deviceBinding DeviceBinding
+	// maxSessionLifetime caps the sliding refresh window; 0 lets sessions
+	// live as long as they are used.
+	maxSessionLifetime time.Duration
+	rememberMeTTL      time.Duration
+	rotationGrace      time.Duration
+	refreshStore       repo.RefreshTokenRepo
+	versions           repo.UserRepo
+	passwordChanges    repo.UserRepo
+	statuses           repo.UserRepo
+	roles              repo.RoleRepo
+	metaUsers          repo.UserRepo
+	metaKeys           []string
+	paseto             *pasetoCodec
+
+	keys            *signing.KeyRing
+	keyStats        map[*signing.Key]*keyStats
+	signingSince    time.Time
+	nextKey         *signing.Key
+	previousKeys    []*signing.Key
+	previousSecrets [][]byte
+	migrationCutoff time.Time
+}
+
+// Option configures optional TokenService behaviour.
+type Option func(*TokenService)
+
+// WithCryptoProvider replaces the standard crypto provider.
+func WithCryptoProvider(p cryptoprov.Provider) Option {
+	return func(s *TokenService) {
+		s.crypto = p
+	}
+}
+
+// WithValidationCache makes ValidateAccess and ValidateAccessForRequest
+// serve recently validated tokens from c, skipping the revocation, token
+// version, password change and account status lookups, and honour
+// revocations received through SyncRevocations. DPoP proofs are checked on
+// every call; one-time tokens are never cached.
+func WithValidationCache(c *tokencache.Cache) Option {
+	return func(s *TokenService) {
+		s.cache = c
+	}
+}
+
+// IssueOption customizes a single token issuance or rotation.
+type IssueOption func(*issueParams)
+
+type issueParams struct {
+	certThumbprint string
+	dpopJKT        string
+	client         ClientInfo
+	subjectType    string
+	reference      bool
+	rememberMe     bool
+	clientID       string
+	audience       string
+	maxSessions    int
+
+	// set internally when rotating an existing session
+	rotating       bool
+	sessionID      string
+	sessionCreated time.Time
+}
+
+// WithCertThumbprint binds the issued refresh token to a client certificate
+// (base64url SHA-256 of its DER encoding). Rotation of a bound token requires
+// the same thumbprint to be presented again.
+func WithCertThumbprint(x5t string) IssueOption {
+	return func(p *issueParams) {
+		p.certThumbprint = x5t
+	}
+}
+
+// WithDPoPKey binds the issued access token to a DPoP key by embedding its
+// JWK thumbprint in the "cnf" claim, and the refresh token by storing it:
+// rotation then requires a proof made with the same key. Use
+// VerifyDPoPProof to obtain it.
+func WithDPoPKey(jkt string) IssueOption {
+	return func(p *issueParams) {
+		p.dpopJKT = jkt
+	}
+}
+
+func newIssueParams(opts []IssueOption) issueParams {
+	var p issueParams
+	for _, opt := range opts {
+		opt(&p)
+	}
+	return p
 }
 
 type tokenClaims struct {
-	UserID string `json:"uid"`
-	Typ    string `json:"typ"`
+	UserID    string         `json:"uid"`
+	Typ       string         `json:"typ"`
+	SessionID string         `json:"sid,omitempty"`
+	Scope     string         `json:"scope,omitempty"`
+	SubType   string         `json:"sub_type,omitempty"`
+	OneTime   bool           `json:"ott,omitempty"`
+	Version   int64          `json:"ver,omitempty"`
+	Roles     []string       `json:"roles,omitempty"`
+	Metadata  map[string]any `json:"meta,omitempty"`
+	Cnf       *confirmation  `json:"cnf,omitempty"`
+	Act       *actor         `json:"act,omitempty"`
 	jwt.RegisteredClaims
 }
 
-func NewTokenService(secret string, accessTTL, refreshTTL time.Duration) (*TokenService, error) {
+// confirmation is the RFC 7800 "cnf" claim of sender-constrained tokens.
+type confirmation struct {
+	JKT string `json:"jkt,omitempty"`
+}
+
+// NewTokenService stores refresh tokens, revocations and sessions in rdb,
+// which may be a single node, a Sentinel-managed master or a cluster. The
+// caller owns the client and closes it.
+func NewTokenService(rdb redis.UniversalClient, secret string, accessTTL, refreshTTL time.Duration, opts ...Option) (*TokenService, error) {
 	if len(secret) < 32 {
 		return nil, autherr.ErrBadRequest.WithMessage("secret must be at least 32 bytes")
 	}
-	addr := os.Getenv("REDIS_ADDR")
-	if addr == "" {
-		addr = "localhost:6379"
+	if rdb == nil {
+		return nil, autherr.ErrBadRequest.WithMessage("redis client is required")
+	}
+	s := &TokenService{
+		rdb:        rdb,
+		secret:     []byte(secret),
+		accessTTL:  accessTTL,
+		refreshTTL: refreshTTL,
+		crypto:     cryptoprov.Standard(),
+		clock:      systemClock{},
+	}
+	for _, opt := range opts {
+		opt(s)
 	}
-	rdb := redis.NewClient(&redis.Options{Addr: addr})
+	s.setupSigning()
+	if s.outage != nil {
+		rdb.AddHook(outageHook{s.outage})
+	}
+
+	_, s.cluster = rdb.(*redis.ClusterClient)
 	ctx := context.Background()
 	if err := rdb.Ping(ctx).Err(); err != nil {
 		return nil, autherr.ErrStorageError.WithMessage(err.Error())
 	}
-	return &TokenService{
-		secret:     []byte(secret),
-		accessTTL:  accessTTL,
-		refreshTTL: refreshTTL,
-		rdb:        rdb,
-	}, nil
+	if err := s.loadWatermarks(ctx); err != nil {
+		return nil, err
+	}
+	return s, nil
 }
 
-func (s *TokenService) Close() error {
-	return s.rdb.Close()
+func (s *TokenService) GenerateTokens(ctx context.Context, userID string, opts ...IssueOption) (accessToken, refreshToken string, accessExp, refreshExp time.Time, err error) {
+	accessToken, refreshToken, accessExp, refreshExp, err = s.issue(ctx, userID, newIssueParams(opts))
+	return accessToken, refreshToken, accessExp, refreshExp, s.degrade(err)
 }
 
-func (s *TokenService) GenerateTokens(ctx context.Context, userID string) (accessToken, refreshToken string, accessExp, refreshExp time.Time, err error) {
-	now := time.Now().UTC()
+// issue creates a token pair. A new session is started unless params carries
+// the session being rotated.
+func (s *TokenService) issue(ctx context.Context, userID string, params issueParams) (accessToken, refreshToken string, accessExp, refreshExp time.Time, err error) {
+	now := s.now().UTC()
 	accessExp = now.Add(s.accessTTL)
-	atJti, err := randomHex(16)
+	atJti, err := randomHex(s.crypto.Rand(), 16)
 	if err != nil {
 		return "", "", time.Time{}, time.Time{}, autherr.ErrTokenGeneration.WithMessage(err.Error())
 	}
+
+	sessionID, sessionCreated := params.sessionID, params.sessionCreated
+	if sessionID == "" {
+		if sessionID, err = randomHex(s.crypto.Rand(), 16); err != nil {
+			return "", "", time.Time{}, time.Time{}, autherr.ErrTokenGeneration.WithMessage(err.Error())
+		}
+		sessionCreated = now
+	}
+
 	accessClaims := tokenClaims{
-		UserID: userID,
-		Typ:    "access",
+		UserID:    userID,
+		Typ:       "access",
+		SessionID: sessionID,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ID:        atJti,
 			IssuedAt:  jwt.NewNumericDate(now),
@@ -70,60 +227,402 @@ func (s *TokenService) GenerateTokens(ctx context.Context, userID string) (acces
 			NotBefore: jwt.NewNumericDate(now),
 		},
 	}
-	at := jwt.NewWithClaims(jwt.SigningMethodHS256, accessClaims)
-	signedAccess, err := at.SignedString(s.secret)
+	if params.audience != "" {
+		accessClaims.Audience = jwt.ClaimStrings{params.audience}
+	}
+	if params.dpopJKT != "" {
+		accessClaims.Cnf = &confirmation{JKT: params.dpopJKT}
+	}
+	if err := s.stampVersion(ctx, &accessClaims); err != nil {
+		return "", "", time.Time{}, time.Time{}, err
+	}
+	if err := s.stampRoles(ctx, &accessClaims); err != nil {
+		return "", "", time.Time{}, time.Time{}, err
+	}
+	if err := s.stampMetadata(ctx, &accessClaims); err != nil {
+		return "", "", time.Time{}, time.Time{}, err
+	}
+	signedAccess, err := s.encodeAccess(ctx, accessClaims, params.reference)
 	if err != nil {
-		return "", "", time.Time{}, time.Time{}, autherr.ErrTokenGeneration.WithMessage(err.Error())
+		return "", "", time.Time{}, time.Time{}, err
 	}
 
-	refreshExp = now.Add(s.refreshTTL)
-	rawRefresh, err := randomBase64(64)
+	refreshTTL := s.refreshLifetime(sessionCreated, now, params.rememberMe)
+	if refreshTTL <= 0 {
+		// the session outlived its absolute lifetime
+		return "", "", time.Time{}, time.Time{}, autherr.ErrInvalidToken
+	}
+	refreshExp = now.Add(refreshTTL)
+	rawRefresh, err := randomBase64(s.crypto.Rand(), 64)
 	if err != nil {
 		return "", "", time.Time{}, time.Time{}, autherr.ErrTokenGeneration.WithMessage(err.Error())
 	}
-	refreshHash := sha256Hex(rawRefresh)
+	refreshHash := s.refreshHash(rawRefresh)
 	key := redisKey(refreshHash)
 
-	if err := s.rdb.HSet(ctx, key, map[string]any{
-		"user_id":   userID,
-		"issued_at": now.Unix(),
-	}).Err(); err != nil {
-		return "", "", time.Time{}, time.Time{}, autherr.ErrStorageError.WithMessage(err.Error())
+	fields := map[string]any{
+		"user_id":    userID,
+		"issued_at":  now.Unix(),
+		"sid":        sessionID,
+		"created_at": sessionCreated.Unix(),
+		"last_used":  now.Unix(),
+	}
+	if params.certThumbprint != "" {
+		fields["cnf_x5t"] = params.certThumbprint
+	}
+	if params.dpopJKT != "" {
+		fields["cnf_jkt"] = params.dpopJKT
 	}
-	if err := s.rdb.Expire(ctx, key, s.refreshTTL).Err(); err != nil {
+	if params.rememberMe {
+		fields["remember"] = "1"
+	}
+	if params.clientID != "" {
+		fields["client_id"] = params.clientID
+		fields["aud"] = params.audience
+	}
+	params.client.addTo(fields)
+	// a token must never be stored without its expiry
+	pipe := s.rdb.TxPipeline()
+	pipe.HSet(ctx, key, fields)
+	pipe.Expire(ctx, key, refreshTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
 		return "", "", time.Time{}, time.Time{}, autherr.ErrStorageError.WithMessage(err.Error())
 	}
+	if err := s.persistRefresh(ctx, refreshHash, userID, sessionID, fields, refreshExp); err != nil {
+		_ = s.rdb.Del(ctx, key).Err()
+		return "", "", time.Time{}, time.Time{}, err
+	}
+	// rotation moves the index entry atomically together with the old token
+	if !params.rotating {
+		if err := s.indexNewSession(ctx, userID, sessionID, refreshHash, params.maxSessions); err != nil {
+			// a session missing from the index could not be listed or revoked
+			_ = s.rdb.Del(ctx, key).Err()
+			_ = s.forgetRefresh(ctx, refreshHash)
+			return "", "", time.Time{}, time.Time{}, err
+		}
+	}
 
+	metrics.TokensIssued.WithLabelValues("access").Inc()
+	metrics.TokensIssued.WithLabelValues("refresh").Inc()
 	return signedAccess, rawRefresh, accessExp, refreshExp, nil
 }
 
-func (s *TokenService) ValidateAccess(tokenStr string) (string, error) {
-	claims, err := s.parseAndMapErr(tokenStr)
+// ValidateAccess validates a bearer access token and returns its claims.
+func (s *TokenService) ValidateAccess(tokenStr string) (*Claims, error) {
+	claims, err := s.validateAccess(tokenStr)
+	err = s.degrade(err)
+	observeValidation(err)
+	return claims, err
+}
+
+func (s *TokenService) validateAccess(tokenStr string) (*Claims, error) {
+	// DPoP-bound tokens cached by ValidateAccessForRequest still need a proof
+	if s.cache != nil {
+		if e, ok := s.cache.Get(tokenStr); ok && e.DPoPJKT == "" {
+			return claimsFromCache(e), nil
+		}
+	}
+
+	claims, err := s.accessClaims(context.Background(), tokenStr)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	if claims.Typ != "access" {
-		return "", autherr.ErrInvalidToken
+		return nil, autherr.ErrInvalidToken
+	}
+	// sender-constrained and one-time tokens need ValidateAccessForCall
+	if (claims.Cnf != nil && claims.Cnf.JKT != "") || claims.OneTime {
+		return nil, autherr.ErrInvalidToken
+	}
+	if s.isRevokedLocally(claims) {
+		return nil, autherr.ErrInvalidToken
+	}
+	if err := s.checkDenylist(context.Background(), claims); err != nil {
+		return nil, err
+	}
+	if err := s.checkTokenVersion(context.Background(), claims); err != nil {
+		return nil, err
+	}
+	if err := s.checkPasswordChange(context.Background(), claims); err != nil {
+		return nil, err
+	}
+	if err := s.checkUserStatus(context.Background(), claims.UserID); err != nil {
+		return nil, err
+	}
+
+	c := newClaims(claims)
+	if s.cache != nil {
+		s.cache.Put(tokenStr, c.cacheEntry())
+	}
+	return c, nil
+}
+
+// ValidateAccessForCall validates an access token presented for one call of
+// target (the gRPC full method name). DPoP-bound tokens require a proof for
+// that call, other tokens ignore it. One-time tokens are consumed: any later
+// presentation fails with ErrTokenReplayed.
+func (s *TokenService) ValidateAccessForCall(ctx context.Context, tokenStr, proof, target string) (*Claims, error) {
+	return s.ValidateAccessForRequest(ctx, tokenStr, proof, dpop.MethodPOST, target)
+}
+
+// ValidateAccessForRequest validates an access token presented to a resource
+// server in an HTTP request with the given method and URL. DPoP proofs must
+// have been created for that request; otherwise it behaves like
+// ValidateAccessForCall.
+func (s *TokenService) ValidateAccessForRequest(ctx context.Context, tokenStr, proof, method, target string) (*Claims, error) {
+	claims, err := s.validateAccessForRequest(ctx, tokenStr, proof, method, target)
+	err = s.degrade(err)
+	observeValidation(err)
+	return claims, err
+}
+
+func (s *TokenService) validateAccessForRequest(ctx context.Context, tokenStr, proof, method, target string) (*Claims, error) {
+	claims, err := s.accessClaims(ctx, tokenStr)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Typ != "access" {
+		return nil, autherr.ErrInvalidToken
+	}
+	// the proof is checked on every call, cached or not
+	if err := s.checkBinding(ctx, claims, tokenStr, DPoPRequest{Proof: proof, Method: method, Target: target}); err != nil {
+		return nil, err
+	}
+	// one-time tokens are consumed by every presentation, so never cached
+	cached := s.cache != nil && !claims.OneTime
+	if cached {
+		if e, ok := s.cache.Get(tokenStr); ok {
+			return claimsFromCache(e), nil
+		}
+	}
+	if s.isRevokedLocally(claims) {
+		return nil, autherr.ErrInvalidToken
+	}
+	if err := s.checkDenylist(ctx, claims); err != nil {
+		return nil, err
+	}
+	if err := s.checkTokenVersion(ctx, claims); err != nil {
+		return nil, err
+	}
+	if err := s.checkPasswordChange(ctx, claims); err != nil {
+		return nil, err
+	}
+	if err := s.checkUserStatus(ctx, claims.UserID); err != nil {
+		return nil, err
+	}
+	if claims.OneTime {
+		if err := s.consumeOnce(ctx, claims); err != nil {
+			return nil, err
+		}
+	}
+
+	c := newClaims(claims)
+	if cached {
+		s.cache.Put(tokenStr, c.cacheEntry())
+	}
+	return c, nil
+}
+
+// revocationChannel distributes access token revocations between instances.
+const revocationChannel = "auth:revocations"
+
+// revocationMessage carries either a single revoked jti (JTI, Until), a
+// not-before watermark (NotBefore, with UserID empty for all users), a token
+// version bump or an account status change.
+type revocationMessage struct {
+	JTI       string `json:"jti,omitempty"`
+	Until     int64  `json:"until,omitempty"`
+	UserID    string `json:"uid,omitempty"`
+	NotBefore int64  `json:"nbf,omitempty"`
+	Version   int64  `json:"ver,omitempty"`
+	Status    string `json:"status,omitempty"`
+	// PasswordChanged is the Unix time of the user's password change.
+	PasswordChanged int64 `json:"pwd,omitempty"`
+}
+
+// PublishRevocation announces that the access token jti must no longer be
+// accepted. until is the token expiry; afterwards the revocation is dropped.
+func (s *TokenService) PublishRevocation(ctx context.Context, jti string, until time.Time) error {
+	if s.cache != nil {
+		s.cache.Revoke(jti, until)
+	}
+	payload, err := json.Marshal(revocationMessage{JTI: jti, Until: until.Unix()})
+	if err != nil {
+		return autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	if err := s.rdb.Publish(ctx, revocationChannel, payload).Err(); err != nil {
+		return autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	return nil
+}
+
+// SyncRevocations applies revocations and watermarks published by any
+// instance to the local state. It blocks until ctx is done.
+func (s *TokenService) SyncRevocations(ctx context.Context) error {
+	sub := s.rdb.Subscribe(ctx, revocationChannel)
+	defer sub.Close()
+
+	// catch up on watermarks set while we were not subscribed
+	if _, err := sub.Receive(ctx); err != nil {
+		return autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	if err := s.loadWatermarks(ctx); err != nil {
+		return err
+	}
+
+	ch := sub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case msg, ok := <-ch:
+			if !ok {
+				return nil
+			}
+			var rm revocationMessage
+			if err := json.Unmarshal([]byte(msg.Payload), &rm); err != nil {
+				logger.FromContext(ctx).Warn("Malformed revocation message", zap.Error(err))
+				continue
+			}
+			switch {
+			case rm.NotBefore != 0:
+				s.applyWatermark(rm.UserID, time.Unix(rm.NotBefore, 0).UTC())
+			case rm.Version != 0, rm.Status != "", rm.PasswordChanged != 0:
+				// the new version, status or password change is already in
+				// Redis; drop cached validations
+				if s.cache != nil {
+					s.cache.Purge()
+				}
+			case s.cache != nil:
+				s.cache.Revoke(rm.JTI, time.Unix(rm.Until, 0))
+			}
+		}
+	}
+}
+
+// isRevokedLocally checks the token against the locally known jti revocations
+// and not-before watermarks.
+func (s *TokenService) isRevokedLocally(claims *tokenClaims) bool {
+	if claims.IssuedAt != nil && s.watermarks.revoked(claims.UserID, claims.IssuedAt.Time) {
+		return true
+	}
+	return s.cache != nil && s.cache.IsRevoked(claims.ID)
+}
+
+// VerifyDPoPProof verifies a proof presented at token issuance for target
+// (the gRPC full method name) and returns the thumbprint of its key.
+func (s *TokenService) VerifyDPoPProof(ctx context.Context, proof, target string) (string, error) {
+	return s.verifyDPoPProof(ctx, proof, dpop.MethodPOST, target, "")
+}
+
+// DPoPRequest is a DPoP proof together with the HTTP method and URL of the
+// request it was presented with.
+type DPoPRequest struct {
+	Proof  string
+	Method string
+	Target string
+}
+
+// checkBinding checks that DPoP-bound access token claims come with a valid
+// proof of their key for req; other tokens need none.
+func (s *TokenService) checkBinding(ctx context.Context, claims *tokenClaims, tokenStr string, req DPoPRequest) error {
+	if claims.Cnf == nil || claims.Cnf.JKT == "" {
+		return nil
+	}
+	if req.Proof == "" {
+		return autherr.ErrInvalidDPoPProof.WithMessage("DPoP proof required")
 	}
-	return claims.UserID, nil
+	jkt, err := s.verifyDPoPProof(ctx, req.Proof, req.Method, req.Target, tokenStr)
+	if err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare([]byte(jkt), []byte(claims.Cnf.JKT)) != 1 {
+		return autherr.ErrInvalidDPoPProof.WithMessage("DPoP key does not match token binding")
+	}
+	return nil
 }
 
+func (s *TokenService) verifyDPoPProof(ctx context.Context, proof, method, target, accessToken string) (string, error) {
+	p, err := dpop.Verify(proof, method, target, accessToken, s.now().UTC(), dpop.Options{})
+	if err != nil {
+		return "", autherr.ErrInvalidDPoPProof
+	}
+
+	// each proof may be used once; remember its jti for the acceptance window
+	ok, err := s.rdb.SetNX(ctx, dpopReplayKey(p.JKT, p.ID), 1, dpopReplayTTL).Result()
+	if err != nil {
+		return "", autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	if !ok {
+		return "", autherr.ErrInvalidDPoPProof.WithMessage("DPoP proof replayed")
+	}
+	return p.JKT, nil
+}
+
+// ValidateRefresh returns the owner of a refresh token and records its use
+// as the last activity of the session.
 func (s *TokenService) ValidateRefresh(ctx context.Context, rawRefresh string) (string, error) {
-	h := sha256Hex(rawRefresh)
-	key := redisKey(h)
-	exists, err := s.rdb.Exists(ctx, key).Result()
+	userID, h, err := s.validateRefresh(ctx, rawRefresh)
+	if err != nil {
+		return "", s.degrade(err)
+	}
+	s.touchRefresh(ctx, h)
+	return userID, nil
+}
+
+// touchScript updates last_used without recreating a token deleted
+// concurrently, which would leave it without an expiry.
+var touchScript = `
+if redis.call("EXISTS", KEYS[1]) == 1 then
+  redis.call("HSET", KEYS[1], "last_used", ARGV[1])
+end
+return 0
+`
+
+// touchRefresh records that the stored refresh token was just used. Failures
+// only cost the session its activity timestamp.
+func (s *TokenService) touchRefresh(ctx context.Context, h string) {
+	if err := s.rdb.Eval(ctx, touchScript, []string{redisKey(h)}, s.now().Unix()).Err(); err != nil {
+		logger.FromContext(ctx).Warn("Failed to record refresh token use", zap.Error(err))
+	}
+}
+
+// validateRefresh returns the owner of a refresh token and the hash it is
+// stored under.
+func (s *TokenService) validateRefresh(ctx context.Context, rawRefresh string) (string, string, error) {
+	h, exists, err := s.resolveRefresh(ctx, rawRefresh)
+	if err != nil {
+		return "", "", err
+	}
+	if !exists {
+		return "", "", autherr.ErrInvalidToken
+	}
+	userID, err := s.checkRefresh(ctx, h)
+	if err != nil {
+		return "", "", err
+	}
+	return userID, h, nil
+}
+
+// checkRefresh returns the owner of the stored refresh token hash unless it
+// is a honeytoken or was revoked.
+func (s *TokenService) checkRefresh(ctx context.Context, h string) (string, error) {
+	vals, err := s.rdb.HMGet(ctx, redisKey(h), "user_id", "issued_at", "canary").Result()
 	if err != nil {
 		return "", autherr.ErrStorageError.WithMessage(err.Error())
 	}
-	if exists == 0 {
+	if label, _ := vals[2].(string); label != "" {
+		s.tripCanary(ctx, label, h)
 		return "", autherr.ErrInvalidToken
 	}
-	userID, err := s.rdb.HGet(ctx, key, "user_id").Result()
-	if err == redis.Nil || userID == "" {
+	userID, _ := vals[0].(string)
+	if userID == "" {
 		return "", autherr.ErrInvalidToken
 	}
-	if err != nil {
-		return "", autherr.ErrStorageError.WithMessage(err.Error())
+	if issuedAt, _ := vals[1].(string); issuedAt != "" {
+		if sec, err := strconv.ParseInt(issuedAt, 10, 64); err == nil && s.watermarks.revoked(userID, time.Unix(sec, 0)) {
+			return "", autherr.ErrInvalidToken
+		}
 	}
 	return userID, nil
 }
@@ -139,75 +638,206 @@ end
 redis.call("HSET", KEYS[2], "user_id", ARGV[1], "issued_at", ARGV[2])
 redis.call("EXPIRE", KEYS[2], tonumber(ARGV[3]))
 redis.call("DEL", KEYS[1])
+redis.call("HSET", KEYS[3], ARGV[4], ARGV[5])
+redis.call("EXPIRE", KEYS[3], tonumber(ARGV[3]))
 return {ok="ok"}
 `
 
-func (s *TokenService) RotateRefresh(ctx context.Context, oldRaw string, expectedUserID string) (newAccess, newRefresh string, accessExp, refreshExp time.Time, err error) {
-	userID, err := s.ValidateRefresh(ctx, oldRaw)
+func (s *TokenService) RotateRefresh(ctx context.Context, oldRaw string, expectedUserID string, opts ...IssueOption) (newAccess, newRefresh string, accessExp, refreshExp time.Time, err error) {
+	defer func() { observeRotation(err) }()
+	defer func() { err = s.degrade(err) }()
+
+	userID, oldHash, err := s.validateRefresh(ctx, oldRaw)
+	retry := false
+	if err == autherr.ErrInvalidToken && s.rotationGrace > 0 {
+		userID, oldHash, err = s.validateGraceRetry(ctx, oldRaw)
+		retry = err == nil
+	}
 	if err != nil {
 		return "", "", time.Time{}, time.Time{}, err
 	}
 	if expectedUserID != "" && userID != expectedUserID {
 		return "", "", time.Time{}, time.Time{}, autherr.ErrInvalidToken
 	}
+	if err := s.checkUserStatus(ctx, userID); err != nil {
+		return "", "", time.Time{}, time.Time{}, err
+	}
 
-	now := time.Now().UTC()
-	newAccess, newRefresh, accessExp, refreshExp, err = s.GenerateTokens(ctx, userID)
+	unlock, err := s.lockRotation(ctx, oldHash)
 	if err != nil {
 		return "", "", time.Time{}, time.Time{}, err
 	}
+	defer unlock()
 
-	newHash := sha256Hex(newRefresh)
-	oldHash := sha256Hex(oldRaw)
 	oldKey := redisKey(oldHash)
+	old, err := s.rdb.HGetAll(ctx, oldKey).Result()
+	if err != nil {
+		return "", "", time.Time{}, time.Time{}, autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	if len(old) == 0 {
+		// rotated by a concurrent call between validation and locking
+		return "", "", time.Time{}, time.Time{}, autherr.ErrInvalidToken
+	}
+	params := newIssueParams(opts)
+	if err := checkCertBinding(old, params); err != nil {
+		return "", "", time.Time{}, time.Time{}, err
+	}
+	if err := checkDPoPBinding(old, params); err != nil {
+		return "", "", time.Time{}, time.Time{}, err
+	}
+	if err := s.checkDeviceBinding(ctx, userID, old, params.client); err != nil {
+		return "", "", time.Time{}, time.Time{}, err
+	}
+	if err := checkClient(old, &params); err != nil {
+		return "", "", time.Time{}, time.Time{}, err
+	}
+	params.rotating = true
+	params.rememberMe = old["remember"] == "1"
+	params.sessionID = old["sid"]
+	if created, err := strconv.ParseInt(old["created_at"], 10, 64); err == nil && params.sessionID != "" {
+		params.sessionCreated = time.Unix(created, 0).UTC()
+	}
+
+	now := s.now().UTC()
+	newAccess, newRefresh, accessExp, refreshExp, err = s.issue(ctx, userID, params)
+	if err != nil {
+		return "", "", time.Time{}, time.Time{}, err
+	}
+
+	newHash := s.refreshHash(newRefresh)
 	newKey := redisKey(newHash)
 	issuedAt := now.Unix()
-	ttl := int(s.refreshTTL.Seconds())
+	ttl := int(math.Ceil(refreshExp.Sub(now).Seconds()))
 
-	cmd := s.rdb.Eval(ctx, rotateScript, []string{oldKey, newKey}, userID, issuedAt, ttl)
-	if cmd.Err() != nil {
+	// legacy tokens without a session get the one started by issue
+	sessionID := params.sessionID
+	if sessionID == "" {
+		sessionID, _ = s.rdb.HGet(ctx, newKey, "sid").Result()
+	}
+
+	if s.cluster {
+		err = s.rotateClustered(ctx, oldKey, userID, sessionID, newHash)
+	} else {
+		keys := []string{oldKey, newKey, userSessionsKey(userID)}
+		err = s.rdb.Eval(ctx, rotateScript, keys, userID, issuedAt, ttl, sessionID, newHash).Err()
+	}
+	if err != nil {
 		// rollback attempt: delete newKey if created
 		_ = s.rdb.Del(ctx, newKey).Err()
+		_ = s.forgetRefresh(ctx, newHash)
 		// map specific errors
-		if cmd.Err().Error() == "ERR old_not_found" || cmd.Err().Error() == "old_not_found" {
+		if err.Error() == "ERR old_not_found" || err.Error() == "old_not_found" {
 			return "", "", time.Time{}, time.Time{}, autherr.ErrInvalidToken
 		}
-		if cmd.Err().Error() == "ERR user_mismatch" || cmd.Err().Error() == "user_mismatch" {
+		if err.Error() == "ERR user_mismatch" || err.Error() == "user_mismatch" {
 			return "", "", time.Time{}, time.Time{}, autherr.ErrInvalidToken
 		}
-		return "", "", time.Time{}, time.Time{}, autherr.ErrStorageError.WithMessage(cmd.Err().Error())
+		return "", "", time.Time{}, time.Time{}, autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	if err := s.forgetRefresh(ctx, oldHash); err != nil {
+		// the old token is gone from Redis; only a flush could bring it back
+		logger.FromContext(ctx).Warn("Failed to delete rotated refresh token from durable store", zap.Error(err))
+	}
+	// a retry must not extend the grace period of the token it retried
+	if !retry {
+		s.rememberRotated(ctx, oldRaw, userID, sessionID)
 	}
 
 	return newAccess, newRefresh, accessExp, refreshExp, nil
 }
 
-func (s *TokenService) RevokeRefreshByRaw(ctx context.Context, raw string) error {
-	h := sha256Hex(raw)
+// checkCertBinding rejects the rotation of a certificate-bound refresh token
+// when the caller does not present the same client certificate.
+func checkCertBinding(stored map[string]string, params issueParams) error {
+	bound := stored["cnf_x5t"]
+	if bound == "" {
+		return nil
+	}
+	if subtle.ConstantTimeCompare([]byte(bound), []byte(params.certThumbprint)) != 1 {
+		return autherr.ErrInvalidToken
+	}
+	return nil
+}
+
+// checkDPoPBinding rejects the rotation of a refresh token issued together
+// with a DPoP-bound access token unless the caller proves possession of the
+// same key, so that a stolen refresh token cannot mint access tokens bound
+// to the thief's key.
+func checkDPoPBinding(stored map[string]string, params issueParams) error {
+	bound := stored["cnf_jkt"]
+	if bound == "" {
+		return nil
+	}
+	if params.dpopJKT == "" {
+		return autherr.ErrInvalidDPoPProof.WithMessage("DPoP proof required")
+	}
+	if subtle.ConstantTimeCompare([]byte(bound), []byte(params.dpopJKT)) != 1 {
+		return autherr.ErrInvalidDPoPProof.WithMessage("DPoP key does not match refresh token binding")
+	}
+	return nil
+}
+
+// checkClient keeps a session with the client it was started by: a refresh
+// naming no client continues with the stored one, a refresh naming another
+// client is rejected.
+func checkClient(stored map[string]string, params *issueParams) error {
+	if params.clientID == "" {
+		params.clientID, params.audience = stored["client_id"], stored["aud"]
+		return nil
+	}
+	if params.clientID != stored["client_id"] {
+		return autherr.ErrInvalidToken
+	}
+	return nil
+}
+
+func (s *TokenService) RevokeRefreshByRaw(ctx context.Context, raw string) (err error) {
+	defer func() { err = s.degrade(err) }()
+
+	h, _, err := s.resolveRefresh(ctx, raw)
+	if err != nil {
+		return err
+	}
 	key := redisKey(h)
-	_, err := s.rdb.Del(ctx, key).Result()
+	vals, err := s.rdb.HMGet(ctx, key, "user_id", "sid", "canary").Result()
 	if err != nil {
 		return autherr.ErrStorageError.WithMessage(err.Error())
 	}
+	// honeytokens report the attempt and stay in place
+	if label, _ := vals[2].(string); label != "" {
+		s.tripCanary(ctx, label, h)
+		return nil
+	}
+	keys := []string{key}
+	if s.rotationGrace > 0 {
+		keys = append(keys, graceKey(s.refreshHash(raw)))
+	}
+	_, err = s.rdb.Del(ctx, keys...).Result()
+	if err != nil {
+		return autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	if vals[0] != nil {
+		metrics.Revocations.WithLabelValues("refresh").Inc()
+	}
+	if err := s.forgetRefresh(ctx, h); err != nil {
+		return err
+	}
+	userID, _ := vals[0].(string)
+	sessionID, _ := vals[1].(string)
+	if userID != "" && sessionID != "" {
+		_ = s.rdb.HDel(ctx, userSessionsKey(userID), sessionID).Err()
+	}
 	return nil
 }
 
 func (s *TokenService) parseAndMapErr(tokenStr string) (*tokenClaims, error) {
-	tok, err := jwt.ParseWithClaims(tokenStr, &tokenClaims{}, func(t *jwt.Token) (interface{}, error) {
-		if t.Method.Alg() != jwt.SigningMethodHS256.Alg() {
-			return nil, autherr.ErrInvalidToken
-		}
-		return s.secret, nil
-	})
+	claims, err := s.verifyAccessJWT(tokenStr)
 	if err != nil {
 		if errors.Is(err, jwt.ErrTokenExpired) {
 			return nil, autherr.ErrTokenExpired
 		}
 		return nil, autherr.ErrInvalidToken
 	}
-	claims, ok := tok.Claims.(*tokenClaims)
-	if !ok || !tok.Valid {
-		return nil, autherr.ErrInvalidToken
-	}
 	return claims, nil
 }
 
@@ -215,9 +845,16 @@ func redisKey(hash string) string {
 	return "refresh:th:" + hash
 }
 
-func randomBase64(n int) (string, error) {
+// dpopReplayTTL covers the default proof max age plus leeway.
+const dpopReplayTTL = 6 * time.Minute
+
+func dpopReplayKey(jkt, jti string) string {
+	return "dpop:jti:" + sha256Hex(jkt+":"+jti)
+}
+
+func randomBase64(r io.Reader, n int) (string, error) {
 	b := make([]byte, n)
-	if _, err := rand.Read(b); err != nil {
+	if _, err := io.ReadFull(r, b); err != nil {
 		return "", err
 	}
 	return base64.RawURLEncoding.EncodeToString(b), nil
@@ -228,9 +865,9 @@ func sha256Hex(s string) string {
 	return hex.EncodeToString(h[:])
 }
 
-func randomHex(n int) (string, error) {
+func randomHex(r io.Reader, n int) (string, error) {
 	b := make([]byte, n)
-	if _, err := rand.Read(b); err != nil {
+	if _, err := io.ReadFull(r, b); err != nil {
 		return "", err
 	}
 	return hex.EncodeToString(b), nil
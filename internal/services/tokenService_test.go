@@ -1,16 +1,90 @@
 package services
 
 import (
-	"os"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"reflect"
+	"slices"
+	"strings"
 	"testing"
 	"time"
 
 	miniredis "github.com/alicebob/miniredis/v2"
+	"github.com/andro-kes/auth_service/internal/autherr"
+	"github.com/andro-kes/auth_service/internal/dpop"
+	"github.com/andro-kes/auth_service/internal/models"
+	"github.com/andro-kes/auth_service/internal/tokencache"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 	"github.com/redis/go-redis/v9"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
+// newTestTokenService returns a TokenService backed by a fresh miniredis
+// instance; both are closed when the test ends.
+func newTestTokenService(tb testing.TB, opts ...Option) (*TokenService, *miniredis.Miniredis) {
+	tb.Helper()
+	srv := miniredis.RunT(tb)
+	rdb := redis.NewClient(&redis.Options{Addr: srv.Addr()})
+	tb.Cleanup(func() { _ = rdb.Close() })
+
+	svc, err := NewTokenService(rdb, "012345678901234567890123456789ab", time.Minute, time.Minute*5, opts...)
+	if err != nil {
+		tb.Fatalf("failed to create TokenService: %v", err)
+	}
+	return svc, srv
+}
+
+// newTestKey returns a fresh P-256 key for DPoP proofs and its thumbprint.
+func newTestKey(tb testing.TB) (*ecdsa.PrivateKey, string) {
+	tb.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		tb.Fatalf("failed to generate key: %v", err)
+	}
+	const htu = "https://api.example.com/"
+	p, err := dpop.Verify(newTestProof(tb, key, dpop.MethodPOST, htu, ""), dpop.MethodPOST, htu, "", time.Now(), dpop.Options{})
+	if err != nil {
+		tb.Fatalf("Verify failed: %v", err)
+	}
+	return key, p.JKT
+}
+
+// newTestProof returns a DPoP proof of key for an htm request to htu
+// presenting accessToken, if any.
+func newTestProof(tb testing.TB, key *ecdsa.PrivateKey, htm, htu, accessToken string) string {
+	tb.Helper()
+	claims := jwt.MapClaims{
+		"jti": uuid.NewString(),
+		"htm": htm,
+		"htu": htu,
+		"iat": time.Now().Unix(),
+	}
+	if accessToken != "" {
+		claims["ath"] = dpop.AccessTokenHash(accessToken)
+	}
+	tok := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+	tok.Header["typ"] = dpop.HeaderType
+	tok.Header["jwk"] = map[string]string{
+		"kty": "EC",
+		"crv": "P-256",
+		"x":   base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+		"y":   base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32))),
+	}
+	proof, err := tok.SignedString(key)
+	if err != nil {
+		tb.Fatalf("failed to sign proof: %v", err)
+	}
+	return proof
+}
+
 func TestNewTokenService_SecretTooShort(t *testing.T) {
-	_, err := NewTokenService("short-secret", time.Minute, time.Hour*24)
+	_, err := NewTokenService(nil, "short-secret", time.Minute, time.Hour*24)
 	if err == nil {
 		t.Fatalf("expected error for short secret, got nil")
 	}
@@ -23,10 +97,11 @@ func TestGenerateAndRevoke(t *testing.T) {
 	}
 	defer srv.Close()
 
-	os.Setenv("REDIS_ADDR", srv.Addr())
+	rdb := redis.NewClient(&redis.Options{Addr: srv.Addr()})
+	defer rdb.Close()
 
 	secret := "012345678901234567890123456789ab"
-	svc, err := NewTokenService(secret, time.Second*5, time.Minute*5)
+	svc, err := NewTokenService(rdb, secret, time.Second*5, time.Minute*5)
 	if err != nil {
 		t.Fatalf("failed to create TokenService: %v", err)
 	}
@@ -58,12 +133,384 @@ func TestGenerateAndRevoke(t *testing.T) {
 		t.Fatalf("RevokeRefreshByRaw failed on second call: %v", err)
 	}
 
-	// check safety
-	rdb := redis.NewClient(&redis.Options{Addr: srv.Addr()})
-	defer rdb.Close()
-
 	keys := srv.Keys()
 	if len(keys) != 0 {
 		t.Logf("remaining keys in miniredis: %v", keys)
 	}
 }
+
+func TestRotateRefresh_CertBinding(t *testing.T) {
+	srv, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("failed to start miniredis: %v", err)
+	}
+	defer srv.Close()
+
+	rdb := redis.NewClient(&redis.Options{Addr: srv.Addr()})
+	defer rdb.Close()
+
+	secret := "012345678901234567890123456789ab"
+	svc, err := NewTokenService(rdb, secret, time.Second*5, time.Minute*5)
+	if err != nil {
+		t.Fatalf("failed to create TokenService: %v", err)
+	}
+
+	ctx := t.Context()
+
+	_, refresh, _, _, err := svc.GenerateTokens(ctx, "user-123", WithCertThumbprint("thumb-a"))
+	if err != nil {
+		t.Fatalf("GenerateTokens failed: %v", err)
+	}
+
+	if _, _, _, _, err := svc.RotateRefresh(ctx, refresh, "user-123"); err != autherr.ErrInvalidToken {
+		t.Fatalf("expected ErrInvalidToken without certificate, got %v", err)
+	}
+	if _, _, _, _, err := svc.RotateRefresh(ctx, refresh, "user-123", WithCertThumbprint("thumb-b")); err != autherr.ErrInvalidToken {
+		t.Fatalf("expected ErrInvalidToken with another certificate, got %v", err)
+	}
+
+	_, rotated, _, _, err := svc.RotateRefresh(ctx, refresh, "user-123", WithCertThumbprint("thumb-a"))
+	if err != nil {
+		t.Fatalf("RotateRefresh with bound certificate failed: %v", err)
+	}
+
+	// the binding is carried over to the rotated token
+	if _, _, _, _, err := svc.RotateRefresh(ctx, rotated, "user-123"); err != autherr.ErrInvalidToken {
+		t.Fatalf("expected rotated token to stay bound, got %v", err)
+	}
+}
+
+func TestValidateAccess_CachedRevocation(t *testing.T) {
+	svc, _ := newTestTokenService(t, WithValidationCache(tokencache.New(16, time.Minute)))
+
+	ctx := t.Context()
+
+	access, _, accessExp, _, err := svc.GenerateTokens(ctx, "user-123")
+	if err != nil {
+		t.Fatalf("GenerateTokens failed: %v", err)
+	}
+	first, err := svc.ValidateAccess(access)
+	if err != nil || first.UserID != "user-123" || first.SessionID == "" || !first.ExpiresAt.Equal(accessExp.Truncate(time.Second)) {
+		t.Fatalf("ValidateAccess failed: claims=%+v err=%v", first, err)
+	}
+	if cached, err := svc.ValidateAccess(access); err != nil || !reflect.DeepEqual(cached, first) {
+		t.Fatalf("cached claims differ: %+v, %v; want %+v", cached, err, first)
+	}
+
+	claims, err := svc.parseAndMapErr(access)
+	if err != nil {
+		t.Fatalf("failed to parse access token: %v", err)
+	}
+	if err := svc.PublishRevocation(ctx, claims.ID, accessExp); err != nil {
+		t.Fatalf("PublishRevocation failed: %v", err)
+	}
+	if _, err := svc.ValidateAccess(access); err != autherr.ErrInvalidToken {
+		t.Fatalf("expected revoked token to be rejected, got %v", err)
+	}
+}
+
+func TestValidateAccessForCall_Cached(t *testing.T) {
+	svc, srv := newTestTokenService(t, WithValidationCache(tokencache.New(16, time.Minute)))
+
+	ctx := t.Context()
+	const method = "/auth.AuthService/ValidateToken"
+
+	access, _, accessExp, _, err := svc.GenerateTokens(ctx, "alice")
+	if err != nil {
+		t.Fatalf("GenerateTokens failed: %v", err)
+	}
+	first, err := svc.ValidateAccessForCall(ctx, access, "", method)
+	if err != nil {
+		t.Fatalf("ValidateAccessForCall failed: %v", err)
+	}
+
+	// a status written behind the service's back is not seen until the entry expires
+	srv.Set(userStatusKey("alice"), models.UserStatusDisabled)
+	if cached, err := svc.ValidateAccessForCall(ctx, access, "", method); err != nil || !reflect.DeepEqual(cached, first) {
+		t.Fatalf("expected the cached validation, got %+v, %v", cached, err)
+	}
+	if _, err := svc.ValidateAccess(access); err != nil {
+		t.Fatalf("expected ValidateAccess to share the cache, got %v", err)
+	}
+
+	if err := svc.PublishRevocation(ctx, first.JTI, accessExp); err != nil {
+		t.Fatalf("PublishRevocation failed: %v", err)
+	}
+	if _, err := svc.ValidateAccessForCall(ctx, access, "", method); err != autherr.ErrInvalidToken {
+		t.Fatalf("expected revoked token to be rejected, got %v", err)
+	}
+
+	// neither proofs nor one-time tokens are served from the cache
+	bound, _, _, _, err := svc.GenerateTokens(ctx, "bob", WithDPoPKey("key-a"))
+	if err != nil {
+		t.Fatalf("GenerateTokens failed: %v", err)
+	}
+	if _, err := svc.ValidateAccessForCall(ctx, bound, "", method); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected a DPoP-bound token without proof to be rejected, got %v", err)
+	}
+	svc.cache.Put(bound, (&Claims{UserID: "bob", DPoPJKT: "key-a", ExpiresAt: time.Now().Add(time.Minute)}).cacheEntry())
+	if _, err := svc.ValidateAccess(bound); err != autherr.ErrInvalidToken {
+		t.Fatalf("expected a cached DPoP-bound token to still need a proof, got %v", err)
+	}
+	once, _, err := svc.IssueScopedAccess(ctx, "bob", "payments", 30*time.Second, true)
+	if err != nil {
+		t.Fatalf("IssueScopedAccess failed: %v", err)
+	}
+	if _, err := svc.ValidateAccessForCall(ctx, once, "", method); err != nil {
+		t.Fatalf("expected first use to succeed, got %v", err)
+	}
+	if _, err := svc.ValidateAccessForCall(ctx, once, "", method); err != autherr.ErrTokenReplayed {
+		t.Fatalf("expected replay to be rejected, got %v", err)
+	}
+}
+
+func BenchmarkGenerateTokens(b *testing.B) {
+	svc, _ := newTestTokenService(b)
+	ctx := b.Context()
+
+	b.ReportAllocs()
+	for b.Loop() {
+		if _, _, _, _, err := svc.GenerateTokens(ctx, "user-123"); err != nil {
+			b.Fatalf("GenerateTokens failed: %v", err)
+		}
+	}
+}
+
+func BenchmarkValidateAccess(b *testing.B) {
+	for _, tc := range []struct {
+		name string
+		opts []Option
+	}{
+		{name: "uncached"},
+		{name: "cached", opts: []Option{WithValidationCache(tokencache.New(1024, time.Minute))}},
+	} {
+		b.Run(tc.name, func(b *testing.B) {
+			svc, _ := newTestTokenService(b, tc.opts...)
+			access, _, _, _, err := svc.GenerateTokens(b.Context(), "user-123")
+			if err != nil {
+				b.Fatalf("GenerateTokens failed: %v", err)
+			}
+
+			b.ReportAllocs()
+			for b.Loop() {
+				if _, err := svc.ValidateAccess(access); err != nil {
+					b.Fatalf("ValidateAccess failed: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkRotateRefresh(b *testing.B) {
+	svc, _ := newTestTokenService(b)
+	ctx := b.Context()
+
+	_, refresh, _, _, err := svc.GenerateTokens(ctx, "user-123")
+	if err != nil {
+		b.Fatalf("GenerateTokens failed: %v", err)
+	}
+
+	b.ReportAllocs()
+	for b.Loop() {
+		_, refresh, _, _, err = svc.RotateRefresh(ctx, refresh, "user-123")
+		if err != nil {
+			b.Fatalf("RotateRefresh failed: %v", err)
+		}
+	}
+}
+
+func TestDPoPBoundRefresh(t *testing.T) {
+	svc, srv := newTestTokenService(t)
+	ctx := t.Context()
+
+	_, refresh, _, _, err := svc.GenerateTokens(ctx, "alice", WithDPoPKey("key-a"))
+	if err != nil {
+		t.Fatalf("GenerateTokens failed: %v", err)
+	}
+
+	// a stolen refresh token is of no use without the key
+	if _, _, _, _, err := svc.RotateRefresh(ctx, refresh, "alice"); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected rotation without a proof to be rejected, got %v", err)
+	}
+	if _, _, _, _, err := svc.RotateRefresh(ctx, refresh, "alice", WithDPoPKey("key-b")); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected rotation with another key to be rejected, got %v", err)
+	}
+
+	_, rotated, _, _, err := svc.RotateRefresh(ctx, refresh, "alice", WithDPoPKey("key-a"))
+	if err != nil {
+		t.Fatalf("expected rotation with the bound key to succeed, got %v", err)
+	}
+	// the binding carries over to the rotated token
+	if jkt := srv.HGet(redisKey(sha256Hex(rotated)), "cnf_jkt"); jkt != "key-a" {
+		t.Fatalf("expected the rotated token bound to key-a, got %q", jkt)
+	}
+	if _, _, _, _, err := svc.RotateRefresh(ctx, rotated, "alice", WithDPoPKey("key-b")); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected the rotated token to stay bound, got %v", err)
+	}
+
+	// tokens issued without DPoP rotate as before
+	_, plain, _, _, err := svc.GenerateTokens(ctx, "alice")
+	if err != nil {
+		t.Fatalf("GenerateTokens failed: %v", err)
+	}
+	if _, _, _, _, err := svc.RotateRefresh(ctx, plain, "alice"); err != nil {
+		t.Fatalf("expected an unbound token to rotate, got %v", err)
+	}
+}
+
+// failingHook fails every command, or pipeline containing a command, that
+// fail matches, as if the connection dropped before it was sent.
+type failingHook struct {
+	fail func(cmd redis.Cmder) bool
+}
+
+func (h failingHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (h failingHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		if h.fail(cmd) {
+			cmd.SetErr(errConnDropped)
+			return errConnDropped
+		}
+		return next(ctx, cmd)
+	}
+}
+
+func (h failingHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		if slices.ContainsFunc(cmds, h.fail) {
+			for _, cmd := range cmds {
+				cmd.SetErr(errConnDropped)
+			}
+			return errConnDropped
+		}
+		return next(ctx, cmds)
+	}
+}
+
+var errConnDropped = errors.New("connection dropped")
+
+func commandOn(name, keyPrefix string) func(redis.Cmder) bool {
+	return func(cmd redis.Cmder) bool {
+		args := cmd.Args()
+		if cmd.Name() != name || len(args) < 2 {
+			return false
+		}
+		key, _ := args[1].(string)
+		return strings.HasPrefix(key, keyPrefix)
+	}
+}
+
+func TestGenerateTokens_AtomicWrite(t *testing.T) {
+	srv, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("failed to start miniredis: %v", err)
+	}
+	defer srv.Close()
+
+	secret := "012345678901234567890123456789ab"
+	ctx := t.Context()
+	refreshKeys := func() []string {
+		var keys []string
+		for _, k := range srv.Keys() {
+			if strings.HasPrefix(k, "refresh:th:") {
+				keys = append(keys, k)
+			}
+		}
+		return keys
+	}
+
+	rdb := redis.NewClient(&redis.Options{Addr: srv.Addr()})
+	defer rdb.Close()
+	svc, err := NewTokenService(rdb, secret, time.Minute, time.Hour)
+	if err != nil {
+		t.Fatalf("failed to create TokenService: %v", err)
+	}
+	_, refresh, _, _, err := svc.GenerateTokens(ctx, "alice")
+	if err != nil {
+		t.Fatalf("GenerateTokens failed: %v", err)
+	}
+	if _, _, _, _, err := svc.RotateRefresh(ctx, refresh, "alice"); err != nil {
+		t.Fatalf("RotateRefresh failed: %v", err)
+	}
+	for _, k := range refreshKeys() {
+		if srv.TTL(k) <= 0 {
+			t.Fatalf("refresh key %s stored without expiry", k)
+		}
+	}
+	srv.FlushAll()
+
+	for _, tc := range []struct {
+		name string
+		fail func(redis.Cmder) bool
+	}{
+		{"expire lost", commandOn("expire", "refresh:th:")},
+		{"session index lost", commandOn("hset", "refresh:user:")},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			defer srv.FlushAll()
+			rdb := redis.NewClient(&redis.Options{Addr: srv.Addr()})
+			defer rdb.Close()
+			rdb.AddHook(failingHook{fail: tc.fail})
+			svc, err := NewTokenService(rdb, secret, time.Minute, time.Hour)
+			if err != nil {
+				t.Fatalf("failed to create TokenService: %v", err)
+			}
+			if _, _, _, _, err := svc.GenerateTokens(ctx, "alice"); err == nil {
+				t.Fatal("expected GenerateTokens to fail")
+			}
+			if keys := refreshKeys(); len(keys) != 0 {
+				t.Fatalf("partial write left refresh keys behind: %v", keys)
+			}
+		})
+	}
+}
+
+func TestValidateRefresh_LastUsed(t *testing.T) {
+	srv, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("failed to start miniredis: %v", err)
+	}
+	defer srv.Close()
+
+	rdb := redis.NewClient(&redis.Options{Addr: srv.Addr()})
+	defer rdb.Close()
+
+	clock := &fixedClock{t: time.Now().Add(-time.Hour).Truncate(time.Second)}
+	svc, err := NewTokenService(rdb, "012345678901234567890123456789ab", time.Minute, time.Hour*2, WithClock(clock))
+	if err != nil {
+		t.Fatalf("failed to create TokenService: %v", err)
+	}
+	ctx := t.Context()
+
+	_, refresh, _, _, err := svc.GenerateTokens(ctx, "alice")
+	if err != nil {
+		t.Fatalf("GenerateTokens failed: %v", err)
+	}
+	clock.t = clock.t.Add(10 * time.Minute)
+	if _, err := svc.ValidateRefresh(ctx, refresh); err != nil {
+		t.Fatalf("ValidateRefresh failed: %v", err)
+	}
+
+	sessions, err := svc.ListSessions(ctx, "alice")
+	if err != nil || len(sessions) != 1 {
+		t.Fatalf("ListSessions = %v, %v", sessions, err)
+	}
+	if got := sessions[0].LastUsedAt; !got.Equal(clock.t) {
+		t.Fatalf("last used at %v, want %v", got, clock.t)
+	}
+	if sessions[0].IssuedAt.Equal(sessions[0].LastUsedAt) {
+		t.Fatal("validation changed the issue time")
+	}
+
+	// a token revoked concurrently is not brought back without expiry
+	if err := svc.RevokeRefreshByRaw(ctx, refresh); err != nil {
+		t.Fatalf("RevokeRefreshByRaw failed: %v", err)
+	}
+	svc.touchRefresh(ctx, svc.refreshHash(refresh))
+	if srv.Exists(redisKey(svc.refreshHash(refresh))) {
+		t.Fatal("touch recreated a revoked token")
+	}
+}
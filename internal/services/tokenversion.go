@@ -0,0 +1,141 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/andro-kes/auth_service/internal/autherr"
+	"github.com/andro-kes/auth_service/internal/logger"
+	"github.com/andro-kes/auth_service/internal/repo"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// tokenVersionTTL bounds how long a version read from Postgres is cached in
+// Redis. Bumps overwrite the cached value, so it only matters for versions
+// changed behind the service's back.
+const tokenVersionTTL = 10 * time.Minute
+
+// WithTokenVersions embeds the user's token version from users in every
+// access token and rejects tokens carrying an older one, so that all of a
+// user's tokens can be invalidated with BumpTokenVersion. Versions are
+// cached in Redis.
+func WithTokenVersions(users repo.UserRepo) Option {
+	return func(s *TokenService) {
+		s.versions = users
+	}
+}
+
+// tokenVersion returns the current token version of userID. Subjects
+// without a user record, such as service accounts, are at version 0.
+func (s *TokenService) tokenVersion(ctx context.Context, userID string) (int64, error) {
+	key := tokenVersionKey(userID)
+	cached, err := s.rdb.Get(ctx, key).Result()
+	if err == nil {
+		if v, err := strconv.ParseInt(cached, 10, 64); err == nil {
+			return v, nil
+		}
+	} else if !errors.Is(err, redis.Nil) && !s.redisDown() {
+		// in degraded mode the version is read from the database alone
+		return 0, autherr.ErrStorageError.WithMessage(err.Error())
+	}
+
+	v, err := s.versions.TokenVersion(ctx, userID)
+	if err != nil && err != autherr.ErrNotFound {
+		return 0, autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	if s.redisDown() {
+		return v, nil
+	}
+	if err := s.rdb.Set(ctx, key, v, tokenVersionTTL).Err(); err != nil {
+		logger.FromContext(ctx).Warn("Failed to cache token version", zap.Error(err))
+	}
+	return v, nil
+}
+
+// stampVersion sets the "ver" claim when token versions are enabled.
+func (s *TokenService) stampVersion(ctx context.Context, claims *tokenClaims) error {
+	if s.versions == nil {
+		return nil
+	}
+	v, err := s.tokenVersion(ctx, claims.UserID)
+	if err != nil {
+		return err
+	}
+	claims.Version = v
+	return nil
+}
+
+// checkTokenVersion rejects tokens issued before the user's version was
+// bumped. Tokens without the claim are at version 0.
+func (s *TokenService) checkTokenVersion(ctx context.Context, claims *tokenClaims) error {
+	if s.versions == nil {
+		return nil
+	}
+	v, err := s.tokenVersion(ctx, claims.UserID)
+	if err != nil {
+		return err
+	}
+	if claims.Version != v {
+		return autherr.ErrInvalidToken
+	}
+	return nil
+}
+
+// BumpTokenVersion invalidates every access token of userID and revokes all
+// of their sessions, e.g. after a password change or a compromise. It
+// returns the new version.
+func (s *TokenService) BumpTokenVersion(ctx context.Context, userID string) (int64, error) {
+	if s.versions == nil {
+		return 0, autherr.ErrBadRequest.WithMessage("token versions are disabled")
+	}
+	v, err := s.versions.BumpTokenVersion(ctx, userID)
+	if err == autherr.ErrNotFound {
+		return 0, autherr.ErrNotFound
+	}
+	if err != nil {
+		return 0, autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	if err := s.rdb.Set(ctx, tokenVersionKey(userID), v, tokenVersionTTL).Err(); err != nil {
+		// validation would keep trusting the stale cached version
+		return 0, autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	if _, err := s.RevokeAllSessions(ctx, userID, ""); err != nil {
+		return 0, err
+	}
+
+	if s.cache != nil {
+		s.cache.Purge()
+	}
+	payload, err := json.Marshal(revocationMessage{UserID: userID, Version: v})
+	if err != nil {
+		return 0, autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	if err := s.rdb.Publish(ctx, revocationChannel, payload).Err(); err != nil {
+		logger.FromContext(ctx).Warn("Failed to publish token version", zap.Error(err))
+	}
+
+	logger.FromContext(ctx).Warn("Token version bumped",
+		zap.String("user_id", userID),
+		zap.Int64("token_version", v))
+	return v, nil
+}
+
+// RotateUserTokens invalidates the tokens of userID so that tokens issued
+// afterwards reflect changed user data: it bumps the token version when
+// versions are enabled and otherwise revokes all of their sessions.
+func (s *TokenService) RotateUserTokens(ctx context.Context, userID string) error {
+	if s.versions == nil {
+		_, err := s.RevokeAllSessions(ctx, userID, "")
+		return err
+	}
+	_, err := s.BumpTokenVersion(ctx, userID)
+	return err
+}
+
+func tokenVersionKey(userID string) string {
+	return "user:ver:" + userID
+}
@@ -0,0 +1,60 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/andro-kes/auth_service/internal/autherr"
+	"github.com/andro-kes/auth_service/internal/tokencache"
+)
+
+func TestBumpTokenVersion(t *testing.T) {
+	users := &testUserRepo{}
+	svc, srv := newTestTokenService(t, WithTokenVersions(users), WithValidationCache(tokencache.New(16, time.Minute)))
+
+	ctx := t.Context()
+	access, refresh, _, _, err := svc.GenerateTokens(ctx, "alice")
+	if err != nil {
+		t.Fatalf("GenerateTokens failed: %v", err)
+	}
+	bobAccess, _, _, _, err := svc.GenerateTokens(ctx, "bob")
+	if err != nil {
+		t.Fatalf("GenerateTokens failed: %v", err)
+	}
+	if _, err := svc.ValidateAccess(access); err != nil {
+		t.Fatalf("ValidateAccess failed: %v", err)
+	}
+
+	if v, err := svc.BumpTokenVersion(ctx, "alice"); err != nil || v != 1 {
+		t.Fatalf("BumpTokenVersion = %d, %v; want 1", v, err)
+	}
+	if _, err := svc.ValidateAccess(access); err != autherr.ErrInvalidToken {
+		t.Fatalf("expected token of the old version to be rejected, got %v", err)
+	}
+	if in, err := svc.Introspect(ctx, access); err != nil || in.Active {
+		t.Fatalf("expected token of the old version to be inactive, got %+v, %v", in, err)
+	}
+	if _, err := svc.ValidateRefresh(ctx, refresh); err != autherr.ErrInvalidToken {
+		t.Fatalf("expected sessions to be revoked, got %v", err)
+	}
+	if _, err := svc.ValidateAccess(bobAccess); err != nil {
+		t.Fatalf("expected other users' tokens to stay valid, got %v", err)
+	}
+
+	fresh, _, _, _, err := svc.GenerateTokens(ctx, "alice")
+	if err != nil {
+		t.Fatalf("GenerateTokens failed: %v", err)
+	}
+	if _, err := svc.ValidateAccess(fresh); err != nil {
+		t.Fatalf("expected token of the new version to validate, got %v", err)
+	}
+
+	// a cold cache falls back to the store
+	srv.Del(tokenVersionKey("alice"))
+	if in, err := svc.Introspect(ctx, fresh); err != nil || !in.Active {
+		t.Fatalf("expected token to be active after a cache miss, got %+v, %v", in, err)
+	}
+	if _, err := svc.Introspect(ctx, access); err != nil || !srv.Exists(tokenVersionKey("alice")) {
+		t.Fatalf("expected the version to be cached again, got %v", err)
+	}
+}
@@ -0,0 +1,77 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/andro-kes/auth_service/internal/logger"
+	"github.com/andro-kes/auth_service/internal/mail"
+	"go.uber.org/zap"
+)
+
+// PurposeAccountUnlock is the purpose of the tokens mailed to users whose
+// account was locked after too many failed logins.
+const PurposeAccountUnlock = "account_unlock"
+
+// AccountUnlockService mails users whose account got locked a one-time token
+// to unlock it before the lockout ends, in case it was them and not someone
+// guessing their password.
+type AccountUnlockService struct {
+	Users  *UserService
+	Tokens *TokenService
+	// Recovery, when set, sends the token to the user's verified recovery
+	// email instead of the primary one.
+	Recovery *RecoveryService
+	Mail     mail.Sender
+	// URL is the page the mailed link opens, with the token in the "token"
+	// query parameter. When empty the bare token is mailed.
+	URL string
+}
+
+// SendUnlock mails the user userID a token that unlocks their account, valid
+// as long as the lockout lasts. Users without an email are skipped silently;
+// the mail is sent in the background.
+func (us *AccountUnlockService) SendUnlock(ctx context.Context, userID string, lockout time.Duration) error {
+	user, err := us.Users.findByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	to := user.Email
+	if us.Recovery != nil {
+		if to, err = us.Recovery.ContactAddress(ctx, user); err != nil {
+			return err
+		}
+	}
+	if to == "" {
+		return nil
+	}
+
+	ttl := min(lockout, maxPurposeTokenTTL)
+	token, _, err := us.Tokens.IssuePurposeToken(ctx, PurposeAccountUnlock, user.ID, ttl)
+	if err != nil {
+		return err
+	}
+	msg := mail.Message{
+		To:      to,
+		Subject: "Your account was locked",
+		Body: fmt.Sprintf("Your account was locked for %d minutes after too many failed sign-in attempts. "+
+			"If it was you, use this link to unlock it now:\n\n%s\n\n"+
+			"If it was not you, someone may be guessing your password: consider changing it.\n",
+			int(lockout.Minutes()), mailedLink(us.URL, token)),
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), sendLinkTimeout)
+	go func() {
+		defer cancel()
+		if err := us.Mail.Send(ctx, msg); err != nil {
+			logger.FromContext(ctx).Error("Failed to send unlock link", zap.String("user_id", user.ID), zap.Error(err))
+		}
+	}()
+	return nil
+}
+
+// Unlock redeems an unlock token and returns the ID of the user whose
+// account it unlocks.
+func (us *AccountUnlockService) Unlock(ctx context.Context, token string) (string, error) {
+	return us.Tokens.ConsumePurposeToken(ctx, PurposeAccountUnlock, token)
+}
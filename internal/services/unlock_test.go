@@ -0,0 +1,66 @@
+package services
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andro-kes/auth_service/internal/autherr"
+	"github.com/andro-kes/auth_service/internal/mail"
+	"github.com/andro-kes/auth_service/internal/models"
+)
+
+func TestAccountUnlock(t *testing.T) {
+	tokens, srv := newTestTokenService(t)
+	users := &testUserRepo{emails: []string{"alice@example.com"}, userID: "alice"}
+	verified := time.Now()
+	emails := &testRecoveryRepo{rows: map[string]*models.RecoveryEmail{
+		"alice": {UserID: "alice", Email: "backup@example.com", VerifiedAt: &verified},
+	}}
+	mailer := make(chanMailer, 1)
+	us := &AccountUnlockService{
+		Users:    &UserService{Repo: users},
+		Tokens:   tokens,
+		Recovery: &RecoveryService{Users: users, Emails: emails},
+		Mail:     mailer,
+	}
+	ctx := t.Context()
+
+	// the username and the email of a user are the same account
+	if id := us.Users.LoginUserID(ctx, "Alice"); id != "alice" {
+		t.Fatalf("expected the username to resolve to alice, got %q", id)
+	}
+	if id := us.Users.LoginUserID(ctx, "alice@example.com"); id != "alice" {
+		t.Fatalf("expected the email to resolve to alice, got %q", id)
+	}
+
+	if err := us.SendUnlock(ctx, "alice", 30*time.Minute); err != nil {
+		t.Fatalf("SendUnlock failed: %v", err)
+	}
+	var msg mail.Message
+	select {
+	case msg = <-mailer:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected an unlock token to be mailed")
+	}
+	if msg.To != "backup@example.com" {
+		t.Fatalf("expected the token sent to the recovery email, got %q", msg.To)
+	}
+	var token string
+	for _, line := range strings.Split(msg.Body, "\n") {
+		if line != "" && !strings.Contains(line, " ") {
+			token = line
+		}
+	}
+	if ttl := srv.TTL(purposeTokenKey(PurposeAccountUnlock, token)); ttl != 30*time.Minute {
+		t.Fatalf("expected the token to last as long as the lockout, got %v", ttl)
+	}
+
+	userID, err := us.Unlock(ctx, token)
+	if err != nil || userID != "alice" {
+		t.Fatalf("expected the token to unlock alice, got %q, %v", userID, err)
+	}
+	if _, err := us.Unlock(ctx, token); err != autherr.ErrInvalidToken {
+		t.Fatalf("expected a used token to be rejected, got %v", err)
+	}
+}
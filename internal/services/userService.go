@@ -2,52 +2,137 @@ package services
 
 import (
 	"context"
+	"errors"
+	netmail "net/mail"
+	"strings"
+	"time"
 
 	"github.com/andro-kes/auth_service/internal/autherr"
+	"github.com/andro-kes/auth_service/internal/cryptoprov"
 	"github.com/andro-kes/auth_service/internal/logger"
 	"github.com/andro-kes/auth_service/internal/models"
+	"github.com/andro-kes/auth_service/internal/passwordpolicy"
 	"github.com/andro-kes/auth_service/internal/repo"
 	"github.com/andro-kes/auth_service/internal/repo/db"
+	"github.com/andro-kes/auth_service/internal/workpool"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"go.uber.org/zap"
-	"golang.org/x/crypto/bcrypt"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 )
 
 type UserService struct {
 	Repo repo.UserRepo
 	Tx   db.Tx
+	// Hashing bounds concurrent password hashing. When nil, hashing runs inline.
+	Hashing *workpool.Pool
+	// Crypto hashes passwords. When nil, the standard provider is used.
+	Crypto cryptoprov.Provider
+	// Policy checks new passwords. When nil, any password is accepted.
+	Policy *passwordpolicy.Policy
+	// History keeps previous password hashes. HistorySize of them, plus the
+	// current one, cannot be reused; 0 disables the check.
+	History     repo.PasswordHistoryRepo
+	HistorySize int
+	// Attempts stores failed logins. When nil, they are not stored.
+	Attempts repo.LoginAttemptRepo
+	// RequireApproval makes Register create pending users, who cannot log
+	// in until ApproveUser.
+	RequireApproval bool
+	// Invites stores registration invite codes.
+	Invites repo.InviteRepo
+	// RequireInvite makes Register require a valid invite code.
+	RequireInvite bool
+	// Usernames keeps previous usernames, which stay reserved for
+	// UsernameGrace. Users may change their username once per
+	// UsernameCooldown.
+	Usernames        repo.UsernameChangeRepo
+	UsernameCooldown time.Duration
+	UsernameGrace    time.Duration
 }
 
-func NewUserService(ctx context.Context, pool *pgxpool.Pool) *UserService {
+func NewUserService(ctx context.Context, pool *pgxpool.Pool, hashing *workpool.Pool, crypto cryptoprov.Provider) *UserService {
 	return &UserService{
-		Repo: repo.NewUserRepo(ctx, pool),
-		Tx:   db.NewTx(pool),
+		Repo:      repo.NewUserRepo(ctx, pool),
+		History:   repo.NewPasswordHistoryRepo(ctx, pool),
+		Attempts:  repo.NewLoginAttemptRepo(ctx, pool),
+		Invites:   repo.NewInviteRepo(ctx, pool),
+		Usernames: repo.NewUsernameChangeRepo(ctx, pool),
+		Tx:        db.NewTx(pool),
+		Hashing:   hashing,
+		Crypto:    crypto,
 	}
 }
 
-func (us *UserService) Register(ctx context.Context, username, password string) (string, error) {
-	hash, err := bcrypt.GenerateFromPassword([]byte(password), 12)
+// uniqueViolation is the Postgres error code of unique constraint violations.
+const uniqueViolation = "23505"
+
+// Register creates a user. email is optional; when set the user can log in
+// with it as well as with the username, which therefore must not contain "@".
+func (us *UserService) Register(ctx context.Context, username, email, password string) (string, error) {
+	return us.RegisterWithInvite(ctx, username, email, password, "")
+}
+
+// RegisterWithInvite is Register with an invite code, which is required
+// when RequireInvite is set and otherwise checked only if given.
+func (us *UserService) RegisterWithInvite(ctx context.Context, username, email, password, invite string) (string, error) {
+	if err := us.checkInvite(invite); err != nil {
+		return "", err
+	}
+	username = normalizeUsername(username)
+	if err := checkUsername(username); err != nil {
+		return "", err
+	}
+	if email != "" {
+		var err error
+		if email, err = normalizeEmail(email); err != nil {
+			return "", err
+		}
+	}
+	if err := us.checkPassword(password, username, email); err != nil {
+		return "", err
+	}
+	return us.register(ctx, username, email, password, invite)
+}
+
+// register creates a user without checking the password against the policy.
+func (us *UserService) register(ctx context.Context, username, email, password, invite string) (string, error) {
+	hash, err := us.hashPassword(ctx, password)
 	if err != nil {
-		logger.Logger().Error("Failed to hash password", zap.Error(err))
-		return "", autherr.ErrHashPassword
+		return "", err
 	}
 
 	user := &models.User{
 		ID:       uuid.New().String(),
 		Username: username,
-		Password: string(hash),
+		Email:    email,
+		Password: hash,
+		Status:   models.UserStatusActive,
+	}
+	if us.RequireApproval {
+		user.Status = models.UserStatusPending
 	}
 
 	var userId string
 	err = us.Tx.RunInTx(ctx, func(ctx context.Context, q db.Querier) error {
+		if err := us.checkReserved(ctx, q, username, ""); err != nil {
+			return err
+		}
+		if user.InviteID, err = us.useInvite(ctx, q, invite); err != nil {
+			return err
+		}
 		userId, err = us.Repo.Create(ctx, q, user)
 		if err != nil {
-			logger.Logger().Error("Failed to create user", zap.Error(err))
+			var authErr *autherr.AuthError
+			if errors.As(err, &authErr) {
+				// ErrUserExists
+				return err
+			}
+			logger.FromContext(ctx).Error("Failed to create user", zap.Error(err))
 			return autherr.ErrCreateUser
 		}
 
-		logger.Logger().Info("User created", zap.String("user_id", user.ID))
+		logger.FromContext(ctx).Info("User created", zap.String("user_id", user.ID))
 		return nil
 	})
 	if err != nil {
@@ -57,19 +142,172 @@ func (us *UserService) Register(ctx context.Context, username, password string)
 	return userId, nil
 }
 
-func (us *UserService) Login(ctx context.Context, username, password string) (*models.User, error) {
-	user, err := us.Repo.FindByUsername(ctx, username)
+// Login authenticates a user by username or, for logins containing "@", by
+// email. Usernames containing "@" from before emails were introduced still
+// work.
+func (us *UserService) Login(ctx context.Context, login, password string) (*models.User, error) {
+	if err := checkLoginInput(login, password); err != nil {
+		return nil, err
+	}
+	if !strings.Contains(login, "@") {
+		login = normalizeUsername(login)
+	}
+	user, err := us.findByLogin(ctx, login)
 	if err != nil {
 		if err == autherr.ErrNotFound {
 			return nil, autherr.ErrNotFound
 		}
-		logger.Logger().Error("Failed to get user by username", zap.Error(err))
+		logger.FromContext(ctx).Error("Failed to get user by username", zap.Error(err))
 		return nil, autherr.ErrStorageError.WithMessage(err.Error())
 	}
 
-	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
-		return nil, autherr.ErrLoginUser
+	if err := us.comparePassword(ctx, user.Password, password); err != nil {
+		return nil, err
 	}
+	// checked after the password so that the status is not disclosed
+	if err := statusError(user.Status); err != nil {
+		return nil, err
+	}
+	us.rehash(ctx, user, password)
 
 	return user, nil
 }
+
+// recordLoginTimeout bounds the background update of RecordLogin.
+const recordLoginTimeout = 5 * time.Second
+
+// RecordLogin stores the time and client IP of a successful login of userID
+// in the background, so that it does not delay the login; failures are
+// logged only.
+func (us *UserService) RecordLogin(ctx context.Context, userID, ip string) {
+	at := time.Now().UTC()
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), recordLoginTimeout)
+	go func() {
+		defer cancel()
+		if err := us.Repo.RecordLogin(ctx, userID, at, ip); err != nil {
+			logger.FromContext(ctx).Warn("Failed to record login", zap.String("user_id", userID), zap.Error(err))
+		}
+	}()
+}
+
+// rehash replaces a verified hash made with an outdated algorithm or cost.
+// Failures are logged only: the old hash keeps working and the next login
+// tries again.
+func (us *UserService) rehash(ctx context.Context, user *models.User, password string) {
+	if !us.crypto().NeedsRehash(user.Password) {
+		return
+	}
+	hash, err := us.hashPassword(ctx, password)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Failed to rehash password", zap.String("user_id", user.ID), zap.Error(err))
+		return
+	}
+	err = us.Tx.RunInTx(ctx, func(ctx context.Context, q db.Querier) error {
+		return us.Repo.UpdatePassword(ctx, q, user.ID, hash)
+	})
+	if err != nil {
+		logger.FromContext(ctx).Warn("Failed to store rehashed password", zap.String("user_id", user.ID), zap.Error(err))
+		return
+	}
+	user.Password = hash
+	logger.FromContext(ctx).Info("Password rehashed", zap.String("user_id", user.ID))
+}
+
+func (us *UserService) findByLogin(ctx context.Context, login string) (*models.User, error) {
+	if !strings.Contains(login, "@") {
+		return us.Repo.FindByUsername(ctx, login)
+	}
+	if email, err := normalizeEmail(login); err == nil {
+		user, err := us.Repo.FindByEmail(ctx, email)
+		if err != autherr.ErrNotFound {
+			return user, err
+		}
+	}
+	return us.Repo.FindByUsername(ctx, login)
+}
+
+// checkPassword applies the input rules and the password policy, reporting
+// every violation as a BadRequest field violation of "password".
+func (us *UserService) checkPassword(password string, userInputs ...string) error {
+	br := &errdetails.BadRequest{FieldViolations: passwordViolations(password)}
+	vs := us.Policy.Check(password, userInputs...)
+	if len(vs) == 0 && len(br.FieldViolations) == 0 {
+		return nil
+	}
+	for _, v := range vs {
+		br.FieldViolations = append(br.FieldViolations, &errdetails.BadRequest_FieldViolation{
+			Field:       "password",
+			Reason:      v.Rule,
+			Description: "password " + v.Description,
+		})
+	}
+	return autherr.ErrBadRequest.WithMessage("password does not meet the policy").WithDetails(br)
+}
+
+// normalizeEmail validates a bare email address and lower-cases it.
+func normalizeEmail(email string) (string, error) {
+	addr, err := netmail.ParseAddress(strings.TrimSpace(email))
+	if err != nil || addr.Name != "" {
+		return "", autherr.ErrBadRequest.WithMessage("invalid email address")
+	}
+	return strings.ToLower(addr.Address), nil
+}
+
+func (us *UserService) crypto() cryptoprov.Provider {
+	if us.Crypto == nil {
+		return cryptoprov.Standard()
+	}
+	return us.Crypto
+}
+
+func (us *UserService) hashPassword(ctx context.Context, password string) (string, error) {
+	var (
+		hash    string
+		hashErr error
+	)
+	if err := us.runHashing(ctx, func() {
+		hash, hashErr = us.crypto().HashPassword(password)
+	}); err != nil {
+		return "", err
+	}
+	if hashErr != nil {
+		logger.FromContext(ctx).Error("Failed to hash password", zap.Error(hashErr))
+		return "", autherr.ErrHashPassword
+	}
+	return hash, nil
+}
+
+func (us *UserService) comparePassword(ctx context.Context, hash, password string) error {
+	var cmpErr error
+	if err := us.runHashing(ctx, func() {
+		cmpErr = us.crypto().VerifyPassword(hash, password)
+	}); err != nil {
+		return err
+	}
+	if errors.Is(cmpErr, cryptoprov.ErrUnsupportedHash) {
+		logger.FromContext(ctx).Warn("Password hash not verifiable by crypto provider",
+			zap.String("provider", us.crypto().Name()))
+	}
+	if cmpErr != nil {
+		return autherr.ErrLoginUser
+	}
+	return nil
+}
+
+// runHashing executes fn on the hashing pool, mapping saturation to ErrOverloaded.
+func (us *UserService) runHashing(ctx context.Context, fn func()) error {
+	if us.Hashing == nil {
+		fn()
+		return nil
+	}
+	err := us.Hashing.Do(ctx, fn)
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, workpool.ErrSaturated), errors.Is(err, workpool.ErrQueueTimeout):
+		logger.FromContext(ctx).Warn("Password hashing pool saturated", zap.Error(err))
+		return autherr.ErrOverloaded
+	default:
+		return err
+	}
+}
@@ -2,13 +2,24 @@ package services
 
 import (
 	"context"
+	"fmt"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/andro-kes/auth_service/internal/autherr"
+	"github.com/andro-kes/auth_service/internal/cryptoprov"
 	"github.com/andro-kes/auth_service/internal/models"
+	"github.com/andro-kes/auth_service/internal/passwordpolicy"
+	"github.com/andro-kes/auth_service/internal/repo"
 	"github.com/andro-kes/auth_service/internal/repo/db"
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
 	"golang.org/x/crypto/bcrypt"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 type fakeTx struct {
@@ -26,6 +37,27 @@ type testUserRepo struct {
 	newUser       *models.User
 	createError   error
 	notFoundError error
+	versions      map[string]int64
+	// emails are the emails FindByEmail finds a user for
+	emails []string
+	// passwords are the password hashes by user ID
+	passwords map[string]string
+	profiles  map[string]models.Profile
+	deleted   map[string]time.Time
+	// status is the account status of every user
+	status string
+	// listed are the users List pages through, in order
+	listed    []*models.User
+	lastQuery repo.UserQuery
+	// logins receives the users RecordLogin is called for
+	logins chan string
+	// usernames are the usernames by user ID, "user-<id>" by default
+	usernames map[string]string
+	// changed are the password change times by user ID
+	changed map[string]time.Time
+	// userID is the ID of the users found by username or email, a new
+	// random one per lookup when empty
+	userID string
 }
 
 func (tur *testUserRepo) Create(ctx context.Context, q db.Querier, user *models.User) (string, error) {
@@ -33,6 +65,7 @@ func (tur *testUserRepo) Create(ctx context.Context, q db.Querier, user *models.
 		return "", tur.createError
 	}
 	tur.newUser = user
+	tur.status = user.Status
 	return user.ID, nil
 }
 
@@ -46,13 +79,219 @@ func (tur *testUserRepo) FindByUsername(ctx context.Context, username string) (*
 		return nil, err
 	}
 
+	id := tur.userID
+	if id == "" {
+		id = uuid.New().String()
+	}
 	return &models.User{
-		ID:       uuid.New().String(),
+		ID:       id,
 		Username: username,
 		Password: string(hash),
+		Status:   tur.status,
 	}, nil
 }
 
+func (tur *testUserRepo) FindByEmail(ctx context.Context, email string) (*models.User, error) {
+	if tur.notFoundError != nil || !slices.Contains(tur.emails, email) {
+		return nil, autherr.ErrNotFound
+	}
+	user, err := tur.FindByUsername(ctx, "owner-of-"+email)
+	if err != nil {
+		return nil, err
+	}
+	user.Email = email
+	return user, nil
+}
+
+func (tur *testUserRepo) FindByID(ctx context.Context, id string) (*models.User, error) {
+	if _, ok := tur.deleted[id]; ok || tur.notFoundError != nil {
+		return nil, autherr.ErrNotFound
+	}
+	username, ok := tur.usernames[id]
+	if !ok {
+		username = "user-" + id
+	}
+	return &models.User{ID: id, Username: username, Password: tur.passwords[id], Profile: tur.profiles[id], Status: tur.status}, nil
+}
+
+func (tur *testUserRepo) UpdateProfile(ctx context.Context, q db.Querier, id string, profile *models.Profile, columns []string) (*models.User, error) {
+	if tur.notFoundError != nil {
+		return nil, autherr.ErrNotFound
+	}
+	if tur.profiles == nil {
+		tur.profiles = make(map[string]models.Profile)
+	}
+	p := tur.profiles[id]
+	for _, col := range columns {
+		switch col {
+		case "first_name":
+			p.FirstName = profile.FirstName
+		case "last_name":
+			p.LastName = profile.LastName
+		case "display_name":
+			p.DisplayName = profile.DisplayName
+		case "metadata":
+			p.Metadata = profile.Metadata
+		}
+	}
+	tur.profiles[id] = p
+	return tur.FindByID(ctx, id)
+}
+
+func (tur *testUserRepo) UpdatePassword(ctx context.Context, q db.Querier, id, hash string) error {
+	if tur.notFoundError != nil {
+		return autherr.ErrNotFound
+	}
+	if tur.passwords == nil {
+		tur.passwords = make(map[string]string)
+	}
+	tur.passwords[id] = hash
+	return nil
+}
+
+func (tur *testUserRepo) ChangePassword(ctx context.Context, q db.Querier, id, hash string) error {
+	if err := tur.UpdatePassword(ctx, q, id, hash); err != nil {
+		return err
+	}
+	if tur.changed == nil {
+		tur.changed = make(map[string]time.Time)
+	}
+	tur.changed[id] = time.Now()
+	return nil
+}
+
+func (tur *testUserRepo) PasswordChangedAt(ctx context.Context, id string) (time.Time, error) {
+	return tur.changed[id], nil
+}
+
+type testHistoryRepo struct {
+	hashes map[string][]string
+}
+
+func (th *testHistoryRepo) Add(ctx context.Context, q db.Querier, userID, hash string) error {
+	if th.hashes == nil {
+		th.hashes = make(map[string][]string)
+	}
+	th.hashes[userID] = append([]string{hash}, th.hashes[userID]...)
+	return nil
+}
+
+func (th *testHistoryRepo) Recent(ctx context.Context, userID string, n int) ([]string, error) {
+	h := th.hashes[userID]
+	return h[:min(n, len(h))], nil
+}
+
+func (th *testHistoryRepo) Trim(ctx context.Context, q db.Querier, userID string, keep int) error {
+	h := th.hashes[userID]
+	th.hashes[userID] = h[:min(keep, len(h))]
+	return nil
+}
+
+func (tur *testUserRepo) SetStatus(ctx context.Context, q db.Querier, id, status string) error {
+	if tur.notFoundError != nil {
+		return autherr.ErrNotFound
+	}
+	tur.status = status
+	return nil
+}
+
+func (tur *testUserRepo) ChangeUsername(ctx context.Context, q db.Querier, id, username string) error {
+	for other, name := range tur.usernames {
+		if other != id && strings.EqualFold(name, username) {
+			return autherr.ErrUserExists
+		}
+	}
+	if tur.usernames == nil {
+		tur.usernames = make(map[string]string)
+	}
+	tur.usernames[id] = username
+	return nil
+}
+
+func (tur *testUserRepo) List(ctx context.Context, query repo.UserQuery) ([]*models.User, error) {
+	tur.lastQuery = query
+	users := tur.listed
+	if query.After != nil {
+		i := slices.IndexFunc(users, func(u *models.User) bool { return u.ID == query.After.ID })
+		users = users[i+1:]
+	}
+	return users[:min(query.Limit, len(users))], nil
+}
+
+func (tur *testUserRepo) Search(ctx context.Context, text string, fuzzy bool, limit int) ([]*models.User, error) {
+	tur.lastQuery = repo.UserQuery{UsernamePrefix: text, Limit: limit}
+	var users []*models.User
+	for _, u := range tur.listed {
+		if strings.HasPrefix(u.Username, text) || fuzzy {
+			users = append(users, u)
+		}
+	}
+	return users[:min(limit, len(users))], nil
+}
+
+func (tur *testUserRepo) RecordLogin(ctx context.Context, id string, at time.Time, ip string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if tur.logins != nil {
+		tur.logins <- id + " " + ip
+	}
+	return nil
+}
+
+func (tur *testUserRepo) Erase(ctx context.Context, q db.Querier, id string) (string, string, error) {
+	if tur.notFoundError != nil {
+		return "", "", autherr.ErrNotFound
+	}
+	if tur.deleted == nil {
+		tur.deleted = make(map[string]time.Time)
+	}
+	tur.deleted[id] = time.Now()
+	delete(tur.passwords, id)
+	delete(tur.profiles, id)
+	return "user-" + id, "", nil
+}
+
+func (tur *testUserRepo) SoftDelete(ctx context.Context, q db.Querier, id string) error {
+	if _, ok := tur.deleted[id]; ok || tur.notFoundError != nil {
+		return autherr.ErrNotFound
+	}
+	if tur.deleted == nil {
+		tur.deleted = make(map[string]time.Time)
+	}
+	tur.deleted[id] = time.Now()
+	return nil
+}
+
+func (tur *testUserRepo) PurgeDeleted(ctx context.Context, cutoff time.Time) (int64, error) {
+	var n int64
+	for id, at := range tur.deleted {
+		if at.Before(cutoff) {
+			delete(tur.deleted, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (tur *testUserRepo) TokenVersion(ctx context.Context, id string) (int64, error) {
+	if tur.notFoundError != nil {
+		return 0, autherr.ErrNotFound
+	}
+	return tur.versions[id], nil
+}
+
+func (tur *testUserRepo) BumpTokenVersion(ctx context.Context, id string) (int64, error) {
+	if tur.notFoundError != nil {
+		return 0, autherr.ErrNotFound
+	}
+	if tur.versions == nil {
+		tur.versions = make(map[string]int64)
+	}
+	tur.versions[id]++
+	return tur.versions[id], nil
+}
+
 func TestRegister(t *testing.T) {
 	ctx := context.Background()
 	repo := &testUserRepo{}
@@ -62,7 +301,7 @@ func TestRegister(t *testing.T) {
 		Tx:   &fakeTx{},
 	}
 
-	userId, err := us.Register(ctx, "test_user", "test_password")
+	userId, err := us.Register(ctx, "test_user", "", "test_password")
 	if err != nil {
 		t.Fatalf("Failed to register user: %s", err.Error())
 	}
@@ -91,7 +330,7 @@ func TestRegisterCreateFails(t *testing.T) {
 		Tx:   &fakeTx{},
 	}
 
-	_, err := us.Register(ctx, "bob", "pwd")
+	_, err := us.Register(ctx, "bob", "", "pwd")
 	if err == nil {
 		t.Fatalf("expected error, got nil")
 	}
@@ -134,3 +373,648 @@ func TestLoginFail(t *testing.T) {
 		t.Fatal("User must be nil")
 	}
 }
+
+func TestRegisterEmail(t *testing.T) {
+	ctx := context.Background()
+	repo := &testUserRepo{}
+	us := &UserService{
+		Repo: repo,
+		Tx:   &fakeTx{},
+	}
+
+	if _, err := us.Register(ctx, "alice", " Alice@Example.COM ", "pwd"); err != nil {
+		t.Fatalf("Failed to register user: %v", err)
+	}
+	if repo.newUser.Email != "alice@example.com" {
+		t.Fatalf("Expected normalized email, got: %q", repo.newUser.Email)
+	}
+	if _, err := us.Register(ctx, "bob", "Bob <bob@example.com>", "pwd"); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("Expected invalid email to be rejected, got %v", err)
+	}
+	if _, err := us.Register(ctx, "bob@example.com", "", "pwd"); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("Expected username with @ to be rejected, got %v", err)
+	}
+
+	repo.createError = autherr.ErrUserExists.WithMessage("email already taken")
+	if _, err := us.Register(ctx, "carol", "alice@example.com", "pwd"); status.Code(err) != codes.AlreadyExists {
+		t.Fatalf("Expected taken email to be rejected, got %v", err)
+	}
+	repo.createError = &pgconn.PgError{Code: "08006"}
+	if _, err := us.Register(ctx, "carol", "carol@example.com", "pwd"); err != autherr.ErrCreateUser {
+		t.Fatalf("Expected ErrCreateUser for a database failure, got %v", err)
+	}
+}
+
+func TestLoginEmail(t *testing.T) {
+	ctx := context.Background()
+	repo := &testUserRepo{emails: []string{"kevin@example.com"}}
+	us := &UserService{
+		Repo: repo,
+		Tx:   &fakeTx{},
+	}
+
+	user, err := us.Login(ctx, "Kevin@Example.com", "supersecret123")
+	if err != nil {
+		t.Fatalf("Login by email failed: %v", err)
+	}
+	if user.Email != "kevin@example.com" {
+		t.Fatalf("Expected user found by email, got: %+v", user)
+	}
+
+	// usernames with @ registered before emails keep working
+	user, err = us.Login(ctx, "legacy@example.com", "supersecret123")
+	if err != nil {
+		t.Fatalf("Login by legacy username failed: %v", err)
+	}
+	if user.Username != "legacy@example.com" {
+		t.Fatalf("Expected user found by username, got: %+v", user)
+	}
+}
+
+func TestRegisterPasswordPolicy(t *testing.T) {
+	ctx := context.Background()
+	repo := &testUserRepo{}
+	us := &UserService{
+		Repo:   repo,
+		Tx:     &fakeTx{},
+		Policy: passwordpolicy.New(passwordpolicy.Config{MinLength: 10, MinClasses: 3}),
+	}
+
+	_, err := us.Register(ctx, "alice", "", "password")
+	st := status.Convert(err)
+	if st.Code() != codes.InvalidArgument {
+		t.Fatalf("Expected InvalidArgument, got %v", err)
+	}
+	var reasons []string
+	for _, d := range st.Details() {
+		if br, ok := d.(*errdetails.BadRequest); ok {
+			for _, v := range br.FieldViolations {
+				if v.Field != "password" {
+					t.Fatalf("Expected violation of password, got %q", v.Field)
+				}
+				reasons = append(reasons, v.Reason)
+			}
+		}
+	}
+	want := []string{passwordpolicy.RuleMinLength, passwordpolicy.RuleCharClass, passwordpolicy.RuleBanned}
+	if !slices.Equal(reasons, want) {
+		t.Fatalf("Expected violations %v, got %v", want, reasons)
+	}
+	if repo.newUser != nil {
+		t.Fatal("Expected no user to be created")
+	}
+
+	if _, err := us.Register(ctx, "alice", "", "Tr0ub4dor&3"); err != nil {
+		t.Fatalf("Failed to register user with a compliant password: %v", err)
+	}
+}
+
+func TestChangePasswordHistory(t *testing.T) {
+	ctx := context.Background()
+	hash, err := bcrypt.GenerateFromPassword([]byte("first-secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	users := &testUserRepo{passwords: map[string]string{"u1": string(hash)}}
+	history := &testHistoryRepo{}
+	us := &UserService{Repo: users, Tx: &fakeTx{}, History: history, HistorySize: 2}
+
+	if err := us.ChangePassword(ctx, "u1", "wrong", "second-secret"); err != autherr.ErrLoginUser {
+		t.Fatalf("Expected ErrLoginUser for a wrong current password, got %v", err)
+	}
+	if err := us.ChangePassword(ctx, "u1", "first-secret", "second-secret"); err != nil {
+		t.Fatalf("Failed to change password: %v", err)
+	}
+	if err := us.ChangePassword(ctx, "u1", "second-secret", "third-secret"); err != nil {
+		t.Fatalf("Failed to change password: %v", err)
+	}
+	if n := len(history.hashes["u1"]); n != 2 {
+		t.Fatalf("Expected 2 previous hashes, got %d", n)
+	}
+
+	for _, reused := range []string{"first-secret", "second-secret", "third-secret"} {
+		err := us.ResetPassword(ctx, "u1", reused)
+		st := status.Convert(err)
+		if st.Code() != codes.InvalidArgument {
+			t.Fatalf("Expected InvalidArgument reusing %q, got %v", reused, err)
+		}
+		br, ok := st.Details()[0].(*errdetails.BadRequest)
+		if !ok || br.FieldViolations[0].Reason != reasonReused {
+			t.Fatalf("Expected a %q violation, got %v", reasonReused, st.Details())
+		}
+	}
+
+	if err := us.ResetPassword(ctx, "u1", "fourth-secret"); err != nil {
+		t.Fatalf("Failed to reset password: %v", err)
+	}
+	// first-secret has dropped out of a history of 2
+	if err := us.ResetPassword(ctx, "u1", "first-secret"); err != nil {
+		t.Fatalf("Expected a password older than the history to be accepted, got %v", err)
+	}
+}
+
+func TestLoginRehash(t *testing.T) {
+	ctx := context.Background()
+	crypto, err := cryptoprov.New(cryptoprov.ModeStandard, cryptoprov.Passwords{Algorithm: cryptoprov.AlgorithmArgon2id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := &testUserRepo{}
+	us := &UserService{Repo: repo, Tx: &fakeTx{}, Crypto: crypto}
+
+	// testUserRepo stores bcrypt hashes
+	user, err := us.Login(ctx, "alice", "supersecret123")
+	if err != nil {
+		t.Fatalf("Failed to login user: %v", err)
+	}
+	stored := repo.passwords[user.ID]
+	if !strings.HasPrefix(stored, "$argon2id$") || user.Password != stored {
+		t.Fatalf("Expected the bcrypt hash to be replaced with Argon2id, got %q", stored)
+	}
+	if err := crypto.VerifyPassword(stored, "supersecret123"); err != nil {
+		t.Fatalf("Rehashed password does not verify: %v", err)
+	}
+}
+
+func TestUpdateProfile(t *testing.T) {
+	ctx := context.Background()
+	us := &UserService{Repo: &testUserRepo{}, Tx: &fakeTx{}}
+
+	user, err := us.UpdateProfile(ctx, "u1", &models.Profile{
+		FirstName: "Ada",
+		LastName:  "Lovelace",
+		Metadata:  map[string]any{"theme": "dark"},
+	}, nil)
+	if err != nil {
+		t.Fatalf("Failed to update profile: %v", err)
+	}
+	if user.FirstName != "Ada" || user.LastName != "Lovelace" || user.Metadata["theme"] != "dark" {
+		t.Fatalf("Unexpected profile %+v", user.Profile)
+	}
+
+	// only the listed fields change
+	user, err = us.UpdateProfile(ctx, "u1", &models.Profile{DisplayName: "ada"}, []string{"display_name"})
+	if err != nil {
+		t.Fatalf("Failed to update profile: %v", err)
+	}
+	if user.DisplayName != "ada" || user.FirstName != "Ada" || user.Metadata["theme"] != "dark" {
+		t.Fatalf("Expected other fields to be kept, got %+v", user.Profile)
+	}
+
+	if _, err := us.UpdateProfile(ctx, "u1", &models.Profile{}, []string{"password"}); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("Expected an unknown field to be rejected, got %v", err)
+	}
+	long := &models.Profile{FirstName: strings.Repeat("a", maxProfileNameLen+1)}
+	if _, err := us.UpdateProfile(ctx, "u1", long, nil); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("Expected a long name to be rejected, got %v", err)
+	}
+	big := &models.Profile{Metadata: map[string]any{"blob": strings.Repeat("x", maxMetadataBytes)}}
+	if _, err := us.UpdateProfile(ctx, "u1", big, []string{"metadata"}); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("Expected large metadata to be rejected, got %v", err)
+	}
+}
+
+func TestGetUser(t *testing.T) {
+	ctx := context.Background()
+	us := &UserService{Repo: &testUserRepo{}, Tx: &fakeTx{}}
+
+	user, err := us.GetUser(ctx, "u1")
+	if err != nil || user.ID != "u1" {
+		t.Fatalf("Failed to get user: %+v, %v", user, err)
+	}
+	if _, err := us.GetUser(ctx, ""); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("Expected a missing ID to be rejected, got %v", err)
+	}
+
+	us.Repo = &testUserRepo{notFoundError: autherr.ErrNotFound}
+	if _, err := us.GetUser(ctx, "u1"); status.Code(err) != codes.NotFound {
+		t.Fatalf("Expected NotFound, got %v", err)
+	}
+}
+
+func TestDeleteUser(t *testing.T) {
+	ctx := context.Background()
+	hash, err := bcrypt.GenerateFromPassword([]byte("supersecret123"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := &testUserRepo{passwords: map[string]string{"u1": string(hash)}}
+	us := &UserService{Repo: repo, Tx: &fakeTx{}}
+
+	if err := us.VerifyPassword(ctx, "u1", "wrong"); err != autherr.ErrLoginUser {
+		t.Fatalf("Expected ErrLoginUser, got %v", err)
+	}
+	if err := us.VerifyPassword(ctx, "u1", "supersecret123"); err != nil {
+		t.Fatalf("Failed to verify password: %v", err)
+	}
+	if err := us.DeleteUser(ctx, "u1"); err != nil {
+		t.Fatalf("Failed to delete user: %v", err)
+	}
+	if _, err := us.GetUser(ctx, "u1"); status.Code(err) != codes.NotFound {
+		t.Fatalf("Expected a deleted user to be NotFound, got %v", err)
+	}
+	if err := us.DeleteUser(ctx, "u1"); status.Code(err) != codes.NotFound {
+		t.Fatalf("Expected deleting twice to be NotFound, got %v", err)
+	}
+
+	purgeCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	us.PurgeDeletedUsers(purgeCtx, time.Millisecond, 0)
+	if len(repo.deleted) != 0 {
+		t.Fatal("Expected the deleted user to be purged")
+	}
+}
+
+func TestLoginAccountStatus(t *testing.T) {
+	ctx := context.Background()
+	us := &UserService{Repo: &testUserRepo{}, Tx: &fakeTx{}}
+
+	if err := us.SetStatus(ctx, "u1", "suspended"); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected InvalidArgument for an unknown status, got %v", err)
+	}
+	if err := us.SetStatus(ctx, "u1", models.UserStatusDisabled); err != nil {
+		t.Fatalf("SetStatus failed: %v", err)
+	}
+	if _, err := us.Login(ctx, "test_user", "wrong_password"); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected Unauthenticated for a wrong password, got %v", err)
+	}
+	if _, err := us.Login(ctx, "test_user", "supersecret123"); err != autherr.ErrAccountDisabled {
+		t.Fatalf("expected ErrAccountDisabled, got %v", err)
+	}
+	if err := us.SetStatus(ctx, "u1", models.UserStatusBanned); err != nil {
+		t.Fatalf("SetStatus failed: %v", err)
+	}
+	if _, err := us.Login(ctx, "test_user", "supersecret123"); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("expected PermissionDenied for a banned user, got %v", err)
+	}
+	if err := us.SetStatus(ctx, "u1", models.UserStatusActive); err != nil {
+		t.Fatalf("SetStatus failed: %v", err)
+	}
+	if _, err := us.Login(ctx, "test_user", "supersecret123"); err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+}
+
+func TestRegistrationApproval(t *testing.T) {
+	ctx := context.Background()
+	users := &testUserRepo{}
+	us := &UserService{Repo: users, Tx: &fakeTx{}, RequireApproval: true}
+
+	id, err := us.Register(ctx, "test_user", "", "supersecret123")
+	if err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+	if users.newUser.Status != models.UserStatusPending {
+		t.Fatalf("expected a pending user, got %q", users.newUser.Status)
+	}
+	if _, err := us.Login(ctx, "test_user", "supersecret123"); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("expected PermissionDenied before approval, got %v", err)
+	}
+	if err := us.ApproveUser(ctx, id); err != nil {
+		t.Fatalf("ApproveUser failed: %v", err)
+	}
+	if _, err := us.Login(ctx, "test_user", "supersecret123"); err != nil {
+		t.Fatalf("Login failed after approval: %v", err)
+	}
+	if err := us.ApproveUser(ctx, id); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected InvalidArgument for an active user, got %v", err)
+	}
+}
+
+func TestListUsers(t *testing.T) {
+	ctx := context.Background()
+	users := &testUserRepo{listed: []*models.User{
+		{ID: "u1", Username: "alice"},
+		{ID: "u2", Username: "bob"},
+		{ID: "u3", Username: "carol"},
+	}}
+	us := &UserService{Repo: users, Tx: &fakeTx{}}
+
+	params := ListUsersParams{PageSize: 2, OrderBy: repo.UserOrderUsername, Status: models.UserStatusActive}
+	page, next, err := us.ListUsers(ctx, params)
+	if err != nil {
+		t.Fatalf("ListUsers failed: %v", err)
+	}
+	if len(page) != 2 || page[1].ID != "u2" || next == "" {
+		t.Fatalf("unexpected first page: %d users, next %q", len(page), next)
+	}
+
+	params.PageToken = next
+	page, next, err = us.ListUsers(ctx, params)
+	if err != nil {
+		t.Fatalf("ListUsers failed: %v", err)
+	}
+	if len(page) != 1 || page[0].ID != "u3" || next != "" {
+		t.Fatalf("unexpected last page: %d users, next %q", len(page), next)
+	}
+	if after := users.lastQuery.After; after == nil || after.ID != "u2" || after.Username != "bob" {
+		t.Fatalf("page did not continue after the previous one: %+v", after)
+	}
+
+	params.Descending = true
+	if _, _, err := us.ListUsers(ctx, params); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected InvalidArgument for a token of another order, got %v", err)
+	}
+	if _, _, err := us.ListUsers(ctx, ListUsersParams{PageToken: "garbage"}); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected InvalidArgument for a malformed token, got %v", err)
+	}
+	if _, _, err := us.ListUsers(ctx, ListUsersParams{Status: "suspended"}); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected InvalidArgument for an unknown status, got %v", err)
+	}
+}
+
+func TestRegisterInputValidation(t *testing.T) {
+	ctx := context.Background()
+	repo := &testUserRepo{}
+	us := &UserService{Repo: repo, Tx: &fakeTx{}}
+
+	cases := []struct {
+		username, password string
+		field, reason      string
+	}{
+		{"ab", "test_password", "username", reasonLength},
+		{strings.Repeat("a", 33), "test_password", "username", reasonLength},
+		{"bob smith", "test_password", "username", reasonCharacters},
+		{"bob@example.com", "test_password", "username", reasonCharacters},
+		{"bob", "test\x00password", "password", reasonControl},
+		{"bob", strings.Repeat("a", maxPasswordBytes+1), "password", reasonLength},
+	}
+	for _, c := range cases {
+		_, err := us.Register(ctx, c.username, "", c.password)
+		if status.Code(err) != codes.InvalidArgument {
+			t.Fatalf("Register(%q, %q): expected InvalidArgument, got %v", c.username, c.password, err)
+		}
+		var br *errdetails.BadRequest
+		for _, d := range status.Convert(err).Details() {
+			if b, ok := d.(*errdetails.BadRequest); ok {
+				br = b
+			}
+		}
+		if br == nil || br.FieldViolations[0].Field != c.field || br.FieldViolations[0].Reason != c.reason {
+			t.Fatalf("Register(%q, %q): expected %s violation %s, got %v", c.username, c.password, c.field, c.reason, br)
+		}
+	}
+
+	if _, err := us.Register(ctx, " éric ", "", "test_password"); err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+	if repo.newUser.Username != "éric" {
+		t.Fatalf("expected trimmed NFC username, got %q", repo.newUser.Username)
+	}
+
+	if _, err := us.Login(ctx, "test_user", strings.Repeat("a", maxPasswordBytes+1)); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected InvalidArgument for an overlong password, got %v", err)
+	}
+}
+
+func TestSearchUsers(t *testing.T) {
+	ctx := context.Background()
+	users := &testUserRepo{listed: []*models.User{
+		{ID: "u1", Username: "alice"},
+		{ID: "u2", Username: "alicia"},
+		{ID: "u3", Username: "bob"},
+	}}
+	us := &UserService{Repo: users, Tx: &fakeTx{}}
+
+	found, err := us.SearchUsers(ctx, " ali ", false, 0)
+	if err != nil {
+		t.Fatalf("SearchUsers failed: %v", err)
+	}
+	if len(found) != 2 || users.lastQuery.UsernamePrefix != "ali" || users.lastQuery.Limit != defaultSearchLimit {
+		t.Fatalf("unexpected search: %d users, query %+v", len(found), users.lastQuery)
+	}
+	if _, err := us.SearchUsers(ctx, "alice", true, 1000); err != nil || users.lastQuery.Limit != maxSearchLimit {
+		t.Fatalf("expected the limit capped at %d, got %d (%v)", maxSearchLimit, users.lastQuery.Limit, err)
+	}
+
+	for _, c := range []struct {
+		text  string
+		fuzzy bool
+	}{{"", false}, {"  ", false}, {"al", true}, {"a\x00b", false}} {
+		if _, err := us.SearchUsers(ctx, c.text, c.fuzzy, 0); status.Code(err) != codes.InvalidArgument {
+			t.Fatalf("SearchUsers(%q, %v): expected InvalidArgument, got %v", c.text, c.fuzzy, err)
+		}
+	}
+}
+
+func TestRecordLogin(t *testing.T) {
+	users := &testUserRepo{logins: make(chan string, 1)}
+	us := &UserService{Repo: users, Tx: &fakeTx{}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	us.RecordLogin(ctx, "u1", "203.0.113.7")
+	// the update outlives the request
+	cancel()
+	select {
+	case got := <-users.logins:
+		if got != "u1 203.0.113.7" {
+			t.Fatalf("unexpected login recorded: %q", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("login was not recorded")
+	}
+}
+
+type testAttemptRepo struct {
+	inserted chan *models.LoginAttempt
+	stored   []models.LoginAttempt
+}
+
+func (ta *testAttemptRepo) Insert(ctx context.Context, attempt *models.LoginAttempt) error {
+	ta.inserted <- attempt
+	return nil
+}
+
+func (ta *testAttemptRepo) ListByLogin(ctx context.Context, logins []string) ([]models.LoginAttempt, error) {
+	var attempts []models.LoginAttempt
+	for _, a := range ta.stored {
+		if slices.ContainsFunc(logins, func(l string) bool { return strings.EqualFold(l, a.Username) }) {
+			attempts = append(attempts, a)
+		}
+	}
+	return attempts, nil
+}
+
+func (ta *testAttemptRepo) DeleteByLogin(ctx context.Context, q db.Querier, logins []string) error {
+	ta.stored = slices.DeleteFunc(ta.stored, func(a models.LoginAttempt) bool {
+		return slices.ContainsFunc(logins, func(l string) bool { return strings.EqualFold(l, a.Username) })
+	})
+	return nil
+}
+
+func (ta *testAttemptRepo) Purge(ctx context.Context, cutoff time.Time) (int64, error) {
+	return 0, nil
+}
+
+func TestRecordFailedLogin(t *testing.T) {
+	ctx := context.Background()
+	attempts := &testAttemptRepo{inserted: make(chan *models.LoginAttempt, 1)}
+	us := &UserService{Repo: &testUserRepo{}, Tx: &fakeTx{}, Attempts: attempts}
+
+	_, err := us.Login(ctx, "test_user", "wrong_password")
+	reason := LoginFailureReason(err)
+	if reason != LoginFailureInvalidPassword {
+		t.Fatalf("expected reason %q, got %q (%v)", LoginFailureInvalidPassword, reason, err)
+	}
+	us.RecordFailedLogin(ctx, "test_user", "203.0.113.7", reason)
+	select {
+	case a := <-attempts.inserted:
+		if a.Username != "test_user" || a.IP != "203.0.113.7" || a.Reason != reason || a.CreatedAt.IsZero() {
+			t.Fatalf("unexpected attempt: %+v", a)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("attempt was not recorded")
+	}
+
+	// storage failures are not attempts
+	us.RecordFailedLogin(ctx, "test_user", "203.0.113.7", LoginFailureReason(autherr.ErrStorageError))
+	select {
+	case a := <-attempts.inserted:
+		t.Fatalf("unexpected attempt: %+v", a)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	for err, want := range map[error]string{
+		autherr.ErrNotFound:        LoginFailureUnknownUser,
+		autherr.ErrAccountDisabled: LoginFailureAccountDisabled,
+		autherr.ErrBadRequest:      LoginFailureInvalidInput,
+	} {
+		if got := LoginFailureReason(err); got != want {
+			t.Fatalf("LoginFailureReason(%v) = %q, want %q", err, got, want)
+		}
+	}
+}
+
+type testInviteRepo struct {
+	invites []*models.Invite
+}
+
+func (ti *testInviteRepo) Create(ctx context.Context, q db.Querier, invite *models.Invite) error {
+	ti.invites = append(ti.invites, invite)
+	return nil
+}
+
+func (ti *testInviteRepo) Use(ctx context.Context, q db.Querier, codeHash string) (string, error) {
+	for _, inv := range ti.invites {
+		if inv.CodeHash == codeHash && inv.Uses < inv.MaxUses && time.Now().Before(inv.ExpiresAt) {
+			inv.Uses++
+			return inv.ID, nil
+		}
+	}
+	return "", autherr.ErrNotFound
+}
+
+func TestRegisterWithInvite(t *testing.T) {
+	ctx := context.Background()
+	users := &testUserRepo{}
+	invites := &testInviteRepo{}
+	us := &UserService{Repo: users, Tx: &fakeTx{}, Invites: invites, RequireInvite: true}
+
+	if _, err := us.Register(ctx, "test_user", "", "supersecret123"); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected InvalidArgument without an invite code, got %v", err)
+	}
+	if _, _, err := us.CreateInvite(ctx, 0, 100*24*time.Hour); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected InvalidArgument for a too long ttl, got %v", err)
+	}
+	code, invite, err := us.CreateInvite(ctx, 2, 0)
+	if err != nil {
+		t.Fatalf("CreateInvite failed: %v", err)
+	}
+	if invite.CodeHash == code || invite.MaxUses != 2 {
+		t.Fatalf("unexpected invite: %+v", invite)
+	}
+
+	for i := range 2 {
+		if _, err := us.RegisterWithInvite(ctx, fmt.Sprintf("user%d", i), "", "supersecret123", code); err != nil {
+			t.Fatalf("RegisterWithInvite #%d failed: %v", i, err)
+		}
+		if users.newUser.InviteID != invite.ID {
+			t.Fatalf("user not linked to the invite: %q", users.newUser.InviteID)
+		}
+	}
+	if _, err := us.RegisterWithInvite(ctx, "user2", "", "supersecret123", code); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected InvalidArgument for a used up invite, got %v", err)
+	}
+
+	expired, _, err := us.CreateInvite(ctx, 1, time.Hour)
+	if err != nil {
+		t.Fatalf("CreateInvite failed: %v", err)
+	}
+	invites.invites[1].ExpiresAt = time.Now().Add(-time.Minute)
+	if _, err := us.RegisterWithInvite(ctx, "user3", "", "supersecret123", expired); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected InvalidArgument for an expired invite, got %v", err)
+	}
+}
+
+type testUsernameRepo struct {
+	changes []models.UsernameChange
+}
+
+func (tn *testUsernameRepo) Insert(ctx context.Context, q db.Querier, change *models.UsernameChange) error {
+	tn.changes = append(tn.changes, *change)
+	return nil
+}
+
+func (tn *testUsernameRepo) LastChange(ctx context.Context, userID string) (time.Time, error) {
+	var last time.Time
+	for _, c := range tn.changes {
+		if c.UserID == userID && c.ChangedAt.After(last) {
+			last = c.ChangedAt
+		}
+	}
+	return last, nil
+}
+
+func (tn *testUsernameRepo) ReservedBy(ctx context.Context, q db.Querier, username string, now time.Time) (string, error) {
+	for _, c := range tn.changes {
+		if strings.EqualFold(c.OldUsername, username) && c.ReservedUntil.After(now) {
+			return c.UserID, nil
+		}
+	}
+	return "", nil
+}
+
+func (tn *testUsernameRepo) DeleteByUser(ctx context.Context, q db.Querier, userID string) error {
+	tn.changes = slices.DeleteFunc(tn.changes, func(c models.UsernameChange) bool { return c.UserID == userID })
+	return nil
+}
+
+func TestChangeUsername(t *testing.T) {
+	ctx := context.Background()
+	users := &testUserRepo{usernames: map[string]string{"u1": "alice", "u2": "bob"}}
+	names := &testUsernameRepo{}
+	us := &UserService{Repo: users, Tx: &fakeTx{}, Usernames: names, UsernameCooldown: time.Hour, UsernameGrace: 24 * time.Hour}
+
+	if err := us.ChangeUsername(ctx, "u1", "alice"); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected InvalidArgument for an unchanged username, got %v", err)
+	}
+	if err := us.ChangeUsername(ctx, "u1", "bob"); status.Code(err) != codes.AlreadyExists {
+		t.Fatalf("expected AlreadyExists for a taken username, got %v", err)
+	}
+	if err := us.ChangeUsername(ctx, "u1", "alicia"); err != nil {
+		t.Fatalf("ChangeUsername failed: %v", err)
+	}
+	if users.usernames["u1"] != "alicia" || len(names.changes) != 1 || names.changes[0].OldUsername != "alice" {
+		t.Fatalf("unexpected state after rename: %q, %+v", users.usernames["u1"], names.changes)
+	}
+
+	err := us.ChangeUsername(ctx, "u1", "alice")
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("expected FailedPrecondition within the cooldown, got %v", err)
+	}
+	if _, ok := status.Convert(err).Details()[0].(*errdetails.RetryInfo); !ok {
+		t.Fatalf("expected RetryInfo, got %v", status.Convert(err).Details())
+	}
+
+	// the old name stays reserved for its previous owner only
+	if err := us.ChangeUsername(ctx, "u2", "Alice"); status.Code(err) != codes.AlreadyExists {
+		t.Fatalf("expected AlreadyExists for a reserved username, got %v", err)
+	}
+	if _, err := us.Register(ctx, "alice", "", "supersecret123"); status.Code(err) != codes.AlreadyExists {
+		t.Fatalf("expected AlreadyExists when registering a reserved username, got %v", err)
+	}
+	names.changes[0].ChangedAt = time.Now().Add(-2 * time.Hour)
+	if err := us.ChangeUsername(ctx, "u1", "alice"); err != nil {
+		t.Fatalf("ChangeUsername back to the reserved name failed: %v", err)
+	}
+}
@@ -0,0 +1,95 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/andro-kes/auth_service/internal/autherr"
+	"github.com/andro-kes/auth_service/internal/logger"
+	"github.com/andro-kes/auth_service/internal/models"
+	"github.com/andro-kes/auth_service/internal/repo/db"
+	"go.uber.org/zap"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// ChangeUsername renames userID. Users may rename themselves once per
+// UsernameCooldown; the old name stays reserved for them for UsernameGrace,
+// so that nobody else can take it over while links to it are still around.
+// Changing only the case of the name is allowed and also counts. Rotating
+// the user's tokens is up to the caller.
+func (us *UserService) ChangeUsername(ctx context.Context, userID, username string) error {
+	username = normalizeUsername(username)
+	if err := checkUsername(username); err != nil {
+		return err
+	}
+	user, err := us.GetUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if user.Username == username {
+		return autherr.ErrBadRequest.WithMessage("username is unchanged")
+	}
+
+	now := time.Now().UTC()
+	last, err := us.Usernames.LastChange(ctx, userID)
+	if err != nil {
+		logger.FromContext(ctx).Error("Failed to get last username change", zap.Error(err))
+		return autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	if next := last.Add(us.UsernameCooldown); !last.IsZero() && now.Before(next) {
+		return autherr.ErrTooSoon.WithMessage("username was changed recently").WithDetails(&errdetails.RetryInfo{
+			RetryDelay: durationpb.New(next.Sub(now).Round(time.Second)),
+		})
+	}
+
+	err = us.Tx.RunInTx(ctx, func(ctx context.Context, q db.Querier) error {
+		if err := us.checkReserved(ctx, q, username, userID); err != nil {
+			return err
+		}
+		if err := us.Repo.ChangeUsername(ctx, q, userID, username); err != nil {
+			return err
+		}
+		return us.Usernames.Insert(ctx, q, &models.UsernameChange{
+			UserID:        userID,
+			OldUsername:   user.Username,
+			ChangedAt:     now,
+			ReservedUntil: now.Add(us.UsernameGrace),
+		})
+	})
+	if err != nil {
+		var authErr *autherr.AuthError
+		if errors.As(err, &authErr) {
+			// ErrNotFound, ErrUserExists
+			return err
+		}
+		logger.FromContext(ctx).Error("Failed to change username", zap.Error(err))
+		return autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	logger.FromContext(ctx).Info("Username changed", zap.String("user_id", userID))
+	return nil
+}
+
+// checkReserved returns ErrUserExists if username is a previous name of a
+// user other than userID that is still reserved for them.
+func (us *UserService) checkReserved(ctx context.Context, q db.Querier, username, userID string) error {
+	if us.Usernames == nil {
+		return nil
+	}
+	owner, err := us.Usernames.ReservedBy(ctx, q, username, time.Now())
+	if err != nil {
+		logger.FromContext(ctx).Error("Failed to check reserved usernames", zap.Error(err))
+		return autherr.ErrStorageError.WithMessage(err.Error())
+	}
+	if owner != "" && owner != userID {
+		return autherr.ErrUserExists.WithMessage("username already taken").WithDetails(&errdetails.BadRequest{
+			FieldViolations: []*errdetails.BadRequest_FieldViolation{{
+				Field:       "username",
+				Reason:      "taken",
+				Description: "username is already taken",
+			}},
+		})
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package services
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/andro-kes/auth_service/internal/autherr"
+	"golang.org/x/text/unicode/norm"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+)
+
+const (
+	minUsernameLen = 3
+	maxUsernameLen = 32
+	// maxLoginBytes bounds what Login looks up: the longest email address.
+	maxLoginBytes = 254
+	// maxPasswordBytes bounds what is hashed at all; the policy usually
+	// caps new passwords far lower.
+	maxPasswordBytes = 1024
+)
+
+// Reasons of input field violations.
+const (
+	reasonLength     = "length"
+	reasonCharacters = "characters"
+	reasonControl    = "control_characters"
+)
+
+// normalizeUsername trims and NFC-normalizes a username, so that visually
+// identical names are the same account.
+func normalizeUsername(username string) string {
+	return norm.NFC.String(strings.TrimSpace(username))
+}
+
+// checkUsername validates a normalized username for registration: 3 to 32
+// letters, digits, '.', '_' or '-'.
+func checkUsername(username string) error {
+	if n := utf8.RuneCountInString(username); n < minUsernameLen || n > maxUsernameLen {
+		return inputError("username", reasonLength, "username must be 3 to 32 characters long")
+	}
+	for _, r := range username {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && !strings.ContainsRune("._-", r) {
+			return inputError("username", reasonCharacters, "username may only contain letters, digits, '.', '_' and '-'")
+		}
+	}
+	return nil
+}
+
+// checkLoginInput rejects Login input that cannot belong to any account
+// before it reaches the database and the password hasher.
+func checkLoginInput(login, password string) error {
+	if len(login) > maxLoginBytes || hasControl(login) {
+		return inputError("username", reasonLength, "invalid username")
+	}
+	if len(password) > maxPasswordBytes {
+		return inputError("password", reasonLength, "password is too long")
+	}
+	return nil
+}
+
+// passwordViolations are the input rules a new password breaks, on top of
+// the password policy.
+func passwordViolations(password string) []*errdetails.BadRequest_FieldViolation {
+	var vs []*errdetails.BadRequest_FieldViolation
+	if len(password) > maxPasswordBytes {
+		vs = append(vs, &errdetails.BadRequest_FieldViolation{
+			Field: "password", Reason: reasonLength, Description: "password is too long",
+		})
+	}
+	if hasControl(password) {
+		vs = append(vs, &errdetails.BadRequest_FieldViolation{
+			Field: "password", Reason: reasonControl, Description: "password must not contain control characters",
+		})
+	}
+	return vs
+}
+
+func hasControl(s string) bool {
+	return strings.ContainsFunc(s, unicode.IsControl)
+}
+
+// inputError is ErrBadRequest with a single field violation.
+func inputError(field, reason, description string) error {
+	return autherr.ErrBadRequest.WithMessage(description).WithDetails(&errdetails.BadRequest{
+		FieldViolations: []*errdetails.BadRequest_FieldViolation{{
+			Field:       field,
+			Reason:      reason,
+			Description: description,
+		}},
+	})
+}
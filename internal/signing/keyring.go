@@ -0,0 +1,77 @@
+package signing
+
+import (
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// KeyRing is the set of keys access tokens are signed and verified with: the
+// newest key signs, older keys only verify until they are retired. A ring is
+// built once at startup and is safe for concurrent reads.
+type KeyRing struct {
+	signer *Key
+	older  []olderKey
+	now    func() time.Time
+}
+
+type olderKey struct {
+	*Key
+	// retireAt is when the key stops verifying; zero keeps it until it is
+	// removed from the configuration.
+	retireAt time.Time
+}
+
+// NewKeyRing returns a ring signing with signer.
+func NewKeyRing(signer *Key) *KeyRing {
+	return &KeyRing{signer: signer, now: time.Now}
+}
+
+// Accept adds an older key that keeps verifying tokens until retireAt.
+// Keys are tried in the order they were added.
+func (r *KeyRing) Accept(k *Key, retireAt time.Time) {
+	r.older = append(r.older, olderKey{Key: k, retireAt: retireAt})
+}
+
+// Signer returns the key new tokens are signed with.
+func (r *KeyRing) Signer() *Key {
+	return r.signer
+}
+
+// Keys returns the signer followed by the older keys not yet retired.
+func (r *KeyRing) Keys() []*Key {
+	now := r.now()
+	keys := []*Key{r.signer}
+	for _, k := range r.older {
+		if k.active(now) {
+			keys = append(keys, k.Key)
+		}
+	}
+	return keys
+}
+
+// Candidates returns the active keys that may have signed t, signer first.
+func (r *KeyRing) Candidates(t *jwt.Token) []*Key {
+	var keys []*Key
+	for _, k := range r.Keys() {
+		if k.Matches(t) {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// RetireAt returns when an older key stops verifying; zero for the signer
+// and for keys kept until removed.
+func (r *KeyRing) RetireAt(k *Key) time.Time {
+	for _, o := range r.older {
+		if o.Key == k {
+			return o.retireAt
+		}
+	}
+	return time.Time{}
+}
+
+func (k olderKey) active(now time.Time) bool {
+	return k.retireAt.IsZero() || now.Before(k.retireAt)
+}
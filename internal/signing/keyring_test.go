@@ -0,0 +1,69 @@
+package signing
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func newEd25519Key(t *testing.T) *Key {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ParsePrivateKeyPEM(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestKeyRing(t *testing.T) {
+	newest, older := newEd25519Key(t), newEd25519Key(t)
+	secret := HMAC(jwt.SigningMethodHS256, []byte("012345678901234567890123456789ab"))
+
+	now := time.Now()
+	ring := NewKeyRing(newest)
+	ring.now = func() time.Time { return now }
+	ring.Accept(older, time.Time{})
+	ring.Accept(secret, now.Add(time.Hour))
+
+	if ring.Signer() != newest || len(ring.Keys()) != 3 {
+		t.Fatalf("unexpected ring keys %v", ring.Keys())
+	}
+
+	signed, err := older.Sign(jwt.RegisteredClaims{Subject: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tok, _, err := jwt.NewParser().ParseUnverified(signed, &jwt.RegisteredClaims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := ring.Candidates(tok); len(c) != 1 || c[0] != older {
+		t.Fatalf("expected the token's kid to select the older key, got %v", c)
+	}
+
+	legacy := &jwt.Token{Method: jwt.SigningMethodHS256, Header: map[string]any{"alg": "HS256"}}
+	if c := ring.Candidates(legacy); len(c) != 1 || c[0] != secret {
+		t.Fatalf("expected a token without kid to select the secret, got %v", c)
+	}
+
+	now = now.Add(2 * time.Hour)
+	if c := ring.Candidates(legacy); len(c) != 0 {
+		t.Fatalf("expected the retired secret to be dropped, got %v", c)
+	}
+	if len(ring.Keys()) != 2 || !ring.RetireAt(secret).Before(now) {
+		t.Fatalf("unexpected ring keys after retirement %v", ring.Keys())
+	}
+}
@@ -0,0 +1,182 @@
+// Package signing holds the keys access tokens are signed and verified with:
+// HMAC secrets and asymmetric private keys loaded from PEM files.
+package signing
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Key signs or verifies access tokens. Tokens carry the key ID in their "kid"
+// header unless ID is empty.
+type Key struct {
+	ID     string
+	Method jwt.SigningMethod
+
+	signKey   any
+	verifyKey any
+}
+
+// HMAC returns a symmetric key. HMAC keys have no ID: their tokens carry no
+// "kid", like all tokens issued before asymmetric keys were introduced.
+func HMAC(method jwt.SigningMethod, secret []byte) *Key {
+	return &Key{Method: method, signKey: secret, verifyKey: secret}
+}
+
+// ParsePrivateKeyPEM loads a PKCS#8, PKCS#1 or SEC 1 private key. The
+// algorithm follows the key: RS256 for RSA (2048 bits or more), ES256 or
+// ES384 for ECDSA P-256 or P-384, EdDSA for Ed25519. The key ID is the
+// base64url SHA-256 of the public key's DER encoding.
+func ParsePrivateKeyPEM(data []byte) (*Key, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("signing: no PEM block found")
+	}
+	priv, err := parsePrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	var method jwt.SigningMethod
+	var pub crypto.PublicKey
+	switch k := priv.(type) {
+	case *rsa.PrivateKey:
+		if k.N.BitLen() < 2048 {
+			return nil, errors.New("signing: RSA keys must be at least 2048 bits")
+		}
+		method, pub = jwt.SigningMethodRS256, &k.PublicKey
+	case *ecdsa.PrivateKey:
+		switch k.Curve {
+		case elliptic.P256():
+			method = jwt.SigningMethodES256
+		case elliptic.P384():
+			method = jwt.SigningMethodES384
+		default:
+			return nil, errors.New("signing: ECDSA keys must use P-256 or P-384")
+		}
+		pub = &k.PublicKey
+	case ed25519.PrivateKey:
+		method, pub = jwt.SigningMethodEdDSA, k.Public()
+	default:
+		return nil, fmt.Errorf("signing: unsupported private key type %T", priv)
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(der)
+	return &Key{
+		ID:        base64.RawURLEncoding.EncodeToString(sum[:]),
+		Method:    method,
+		signKey:   priv,
+		verifyKey: pub,
+	}, nil
+}
+
+func parsePrivateKey(der []byte) (any, error) {
+	if k, err := x509.ParsePKCS8PrivateKey(der); err == nil {
+		return k, nil
+	}
+	if k, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return k, nil
+	}
+	if k, err := x509.ParseECPrivateKey(der); err == nil {
+		return k, nil
+	}
+	return nil, errors.New("signing: unsupported private key encoding")
+}
+
+// Sign returns the signed token.
+func (k *Key) Sign(claims jwt.Claims) (string, error) {
+	tok := jwt.NewWithClaims(k.Method, claims)
+	if k.ID != "" {
+		tok.Header["kid"] = k.ID
+	}
+	return tok.SignedString(k.signKey)
+}
+
+// Matches reports whether t may have been signed with k, judging by its
+// header: the algorithm must be k's and the "kid" k's ID (both absent for
+// HMAC keys). The signature itself is checked by the parser.
+func (k *Key) Matches(t *jwt.Token) bool {
+	kid, _ := t.Header["kid"].(string)
+	return kid == k.ID && t.Method.Alg() == k.Method.Alg()
+}
+
+// VerificationKey is the key to hand to the JWT parser.
+func (k *Key) VerificationKey() any {
+	return k.verifyKey
+}
+
+// PublicKey returns the public half of asymmetric keys and nil for HMAC.
+func (k *Key) PublicKey() crypto.PublicKey {
+	if _, ok := k.verifyKey.([]byte); ok {
+		return nil
+	}
+	return k.verifyKey
+}
+
+// JWK is a public key in JSON Web Key format (RFC 7517).
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// EC and OKP
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKSet is the document served at a JWKS endpoint.
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWK returns the public key in JWK format; ok is false for HMAC keys, which
+// must never be published.
+func (k *Key) JWK() (jwk JWK, ok bool) {
+	b64 := base64.RawURLEncoding.EncodeToString
+	jwk = JWK{Use: "sig", Alg: k.Method.Alg(), Kid: k.ID}
+	switch pub := k.PublicKey().(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = b64(pub.N.Bytes())
+		jwk.E = b64(big.NewInt(int64(pub.E)).Bytes())
+	case *ecdsa.PublicKey:
+		ecdh, err := pub.ECDH()
+		if err != nil {
+			return JWK{}, false
+		}
+		// uncompressed point: 0x04 || X || Y
+		point := ecdh.Bytes()[1:]
+		size := len(point) / 2
+		jwk.Kty = "EC"
+		jwk.Crv = pub.Curve.Params().Name
+		jwk.X = b64(point[:size])
+		jwk.Y = b64(point[size:])
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = b64(pub)
+	default:
+		return JWK{}, false
+	}
+	return jwk, true
+}
@@ -0,0 +1,66 @@
+package signing
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestParsePrivateKeyPEM(t *testing.T) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ParsePrivateKeyPEM(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	if err != nil {
+		t.Fatalf("ParsePrivateKeyPEM failed: %v", err)
+	}
+	if key.Method != jwt.SigningMethodES256 || key.ID == "" || key.PublicKey() == nil {
+		t.Fatalf("unexpected key %+v", key)
+	}
+
+	signed, err := key.Sign(jwt.RegisteredClaims{Subject: "alice"})
+	if err != nil {
+		t.Fatalf("Sign failed: %v", err)
+	}
+	tok, err := jwt.Parse(signed, func(tok *jwt.Token) (any, error) {
+		if !key.Matches(tok) {
+			t.Fatalf("expected token header to match the key: %v", tok.Header)
+		}
+		return key.VerificationKey(), nil
+	})
+	if err != nil || !tok.Valid {
+		t.Fatalf("expected signature to verify, got %v", err)
+	}
+}
+
+func TestHMAC(t *testing.T) {
+	secret := []byte("012345678901234567890123456789ab")
+	key := HMAC(jwt.SigningMethodHS256, secret)
+	signed, err := key.Sign(jwt.RegisteredClaims{Subject: "alice"})
+	if err != nil {
+		t.Fatalf("Sign failed: %v", err)
+	}
+	tok, _, err := jwt.NewParser().ParseUnverified(signed, &jwt.RegisteredClaims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := tok.Header["kid"]; ok {
+		t.Fatal("expected no kid header for a key without ID")
+	}
+	if key.PublicKey() != nil {
+		t.Fatal("expected HMAC keys to have no public key")
+	}
+	if !key.Matches(tok) || key.Matches(&jwt.Token{Method: jwt.SigningMethodRS256, Header: tok.Header}) {
+		t.Fatal("expected keys to match tokens by algorithm")
+	}
+}
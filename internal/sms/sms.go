@@ -0,0 +1,33 @@
+// Package sms delivers text messages such as one-time codes through a
+// pluggable provider.
+package sms
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+)
+
+// Message is a text message to a phone number in E.164 format.
+type Message struct {
+	To   string
+	Body string
+}
+
+// Sender delivers messages.
+type Sender interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// LogSender writes messages to the log instead of delivering them. It is
+// meant for development: bodies, which contain codes, are logged at debug
+// level only.
+type LogSender struct {
+	Logger *zap.Logger
+}
+
+func (s LogSender) Send(_ context.Context, msg Message) error {
+	s.Logger.Info("sms not delivered: no provider configured", zap.String("to", msg.To))
+	s.Logger.Debug("sms body", zap.String("to", msg.To), zap.String("body", msg.Body))
+	return nil
+}
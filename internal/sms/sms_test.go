@@ -0,0 +1,60 @@
+package sms
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestSignV4 uses the example request of the AWS Signature Version 4
+// documentation.
+func TestSignV4(t *testing.T) {
+	req, err := http.NewRequest(http.MethodGet, "https://iam.amazonaws.com/?Action=ListUsers&Version=2010-05-08", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	now := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+	signV4(req, nil, "AKIDEXAMPLE", "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", "us-east-1", "iam", now)
+
+	want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/iam/aws4_request, " +
+		"SignedHeaders=content-type;host;x-amz-date, " +
+		"Signature=5d672d79c15b13162d9279b0855cfba6789a8edb4c82c400e06b5924a6f2b5d7"
+	if got := req.Header.Get("Authorization"); got != want {
+		t.Fatalf("unexpected Authorization header:\n got %s\nwant %s", got, want)
+	}
+}
+
+func TestTwilioSend(t *testing.T) {
+	var form map[string]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, _ := r.BasicAuth(); user != "AC123" || pass != "token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path != "/Accounts/AC123/Messages.json" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		r.ParseForm()
+		form = map[string]string{"To": r.PostForm.Get("To"), "From": r.PostForm.Get("From"), "Body": r.PostForm.Get("Body")}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer srv.Close()
+
+	tw := NewTwilio("AC123", "token", "+15550000000")
+	tw.baseURL = srv.URL
+	if err := tw.Send(context.Background(), Message{To: "+15551234567", Body: "code 123456"}); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if form["To"] != "+15551234567" || form["From"] != "+15550000000" || form["Body"] != "code 123456" {
+		t.Fatalf("unexpected form: %v", form)
+	}
+
+	tw.authToken = "wrong"
+	if err := tw.Send(context.Background(), Message{To: "+15551234567", Body: "x"}); err == nil {
+		t.Fatal("expected an error for a rejected request")
+	}
+}
@@ -0,0 +1,172 @@
+// Package tokencache keeps an in-process cache of recently validated access
+// tokens together with a small set of revoked token IDs. Revocations are
+// distributed between instances through Redis pub/sub, so a cached token stops
+// validating on every instance shortly after it is revoked anywhere.
+package tokencache
+
+import (
+	"crypto/sha256"
+	"sync"
+	"time"
+)
+
+// Entry is the cached result of a successful validation.
+type Entry struct {
+	UserID      string
+	JTI         string
+	SessionID   string
+	Scope       string
+	SubjectType string
+	DPoPJKT     string
+	Audience    []string
+	Roles       []string
+	Metadata    map[string]any
+	Actors      []string
+	IssuedAt    time.Time
+	ExpiresAt   time.Time
+}
+
+type cached struct {
+	Entry
+	until time.Time
+}
+
+// Cache is safe for concurrent use.
+type Cache struct {
+	mu      sync.Mutex
+	entries map[[sha256.Size]byte]cached
+	revoked map[string]time.Time
+
+	maxEntries int
+	ttl        time.Duration
+	now        func() time.Time
+}
+
+// New creates a cache holding at most maxEntries validations, each trusted for
+// at most ttl (and never past the token's own expiry).
+func New(maxEntries int, ttl time.Duration) *Cache {
+	return &Cache{
+		entries:    make(map[[sha256.Size]byte]cached),
+		revoked:    make(map[string]time.Time),
+		maxEntries: maxEntries,
+		ttl:        ttl,
+		now:        time.Now,
+	}
+}
+
+// Get returns the cached validation of token if it is still fresh and its jti
+// has not been revoked.
+func (c *Cache) Get(token string) (Entry, bool) {
+	key := sha256.Sum256([]byte(token))
+	now := c.now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		return Entry{}, false
+	}
+	if !now.Before(e.until) {
+		delete(c.entries, key)
+		return Entry{}, false
+	}
+	if c.isRevokedLocked(e.JTI, now) {
+		delete(c.entries, key)
+		return Entry{}, false
+	}
+	return e.Entry, true
+}
+
+// Put stores a successful validation.
+func (c *Cache) Put(token string, e Entry) {
+	now := c.now()
+	until := now.Add(c.ttl)
+	if e.ExpiresAt.Before(until) {
+		until = e.ExpiresAt
+	}
+	if !now.Before(until) {
+		return
+	}
+
+	key := sha256.Sum256([]byte(token))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= c.maxEntries {
+		c.evictLocked(now)
+	}
+	c.entries[key] = cached{Entry: e, until: until}
+}
+
+// Revoke marks jti as revoked until the given time (normally the token expiry,
+// after which the token is rejected anyway).
+func (c *Cache) Revoke(jti string, until time.Time) {
+	now := c.now()
+	if jti == "" || !now.Before(until) {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.revoked[jti] = until
+	for k, t := range c.revoked {
+		if !now.Before(t) {
+			delete(c.revoked, k)
+		}
+	}
+}
+
+// IsRevoked reports whether jti is in the local revocation set.
+func (c *Cache) IsRevoked(jti string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.isRevokedLocked(jti, c.now())
+}
+
+// Purge drops all cached validations; revocations are kept.
+func (c *Cache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	clear(c.entries)
+}
+
+// Len returns the number of cached validations.
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+func (c *Cache) isRevokedLocked(jti string, now time.Time) bool {
+	until, ok := c.revoked[jti]
+	if !ok {
+		return false
+	}
+	if !now.Before(until) {
+		delete(c.revoked, jti)
+		return false
+	}
+	return true
+}
+
+// evictLocked drops expired entries and, if the cache is still full, an
+// arbitrary one to make room.
+func (c *Cache) evictLocked(now time.Time) {
+	for k, e := range c.entries {
+		if !now.Before(e.until) {
+			delete(c.entries, k)
+		}
+	}
+	if len(c.entries) < c.maxEntries {
+		return
+	}
+	for k := range c.entries {
+		delete(c.entries, k)
+		if len(c.entries) < c.maxEntries {
+			return
+		}
+	}
+}
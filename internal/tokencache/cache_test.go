@@ -0,0 +1,51 @@
+package tokencache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCacheRevocation(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	c := New(2, 30*time.Second)
+	c.now = func() time.Time { return now }
+
+	c.Put("token-a", Entry{UserID: "u1", JTI: "a", ExpiresAt: now.Add(time.Minute)})
+	if e, ok := c.Get("token-a"); !ok || e.UserID != "u1" {
+		t.Fatalf("expected cached entry, got %+v ok=%v", e, ok)
+	}
+
+	c.Revoke("a", now.Add(time.Minute))
+	if _, ok := c.Get("token-a"); ok {
+		t.Fatal("revoked token must not be served from cache")
+	}
+	if !c.IsRevoked("a") {
+		t.Fatal("expected jti to be revoked")
+	}
+
+	// entries are trusted for the cache TTL only
+	c.Put("token-b", Entry{UserID: "u2", JTI: "b", ExpiresAt: now.Add(time.Minute)})
+	now = now.Add(31 * time.Second)
+	if _, ok := c.Get("token-b"); ok {
+		t.Fatal("expected entry to expire after cache TTL")
+	}
+
+	// revocations are dropped once the token itself has expired
+	now = now.Add(time.Minute)
+	if c.IsRevoked("a") {
+		t.Fatal("expected revocation to expire with the token")
+	}
+}
+
+func TestCacheBounded(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	c := New(2, time.Minute)
+	c.now = func() time.Time { return now }
+
+	for _, tok := range []string{"a", "b", "c"} {
+		c.Put(tok, Entry{UserID: tok, JTI: tok, ExpiresAt: now.Add(time.Minute)})
+	}
+	if c.Len() != 2 {
+		t.Fatalf("expected cache to hold 2 entries, got %d", c.Len())
+	}
+}
@@ -0,0 +1,61 @@
+package totp
+
+import (
+	"bytes"
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA1 key of the RFC 6238 test vectors.
+var rfcSecret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+func TestCodeRFC6238(t *testing.T) {
+	// the RFC lists 8 digits; authenticator apps use the last 6
+	for unix, want := range map[int64]string{
+		59:          "94287082",
+		1111111109:  "07081804",
+		1111111111:  "14050471",
+		1234567890:  "89005924",
+		2000000000:  "69279037",
+		20000000000: "65353130",
+	} {
+		got, err := Code(rfcSecret, Counter(time.Unix(unix, 0)))
+		if err != nil {
+			t.Fatalf("Code failed: %v", err)
+		}
+		if got != want[2:] {
+			t.Errorf("code at %d: got %s, want %s", unix, got, want[2:])
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	code, _ := Code(rfcSecret, Counter(now)-1)
+	step, ok := Validate(rfcSecret, code[:3]+" "+code[3:], now, 1)
+	if !ok || step != Counter(now)-1 {
+		t.Fatalf("expected the previous step to match, got %d, %v", step, ok)
+	}
+	if _, ok := Validate(rfcSecret, code, now, 0); ok {
+		t.Fatal("expected the previous step to be rejected without skew")
+	}
+	if _, ok := Validate(rfcSecret, "12345", now, 1); ok {
+		t.Fatal("expected a short code to be rejected")
+	}
+}
+
+func TestSecretAndURI(t *testing.T) {
+	secret, err := NewSecret(bytes.NewReader(bytes.Repeat([]byte{1}, 20)))
+	if err != nil {
+		t.Fatalf("NewSecret failed: %v", err)
+	}
+	if len(secret) != 32 || strings.Contains(secret, "=") {
+		t.Fatalf("unexpected secret %q", secret)
+	}
+	uri := URI("Acme", "alice@example.com", secret)
+	if !strings.HasPrefix(uri, "otpauth://totp/Acme:alice@example.com?") || !strings.Contains(uri, "secret="+secret) {
+		t.Fatalf("unexpected URI %q", uri)
+	}
+}
@@ -0,0 +1,84 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+
+	miniredis "github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"google.golang.org/grpc"
+	grpccodes "google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func newRecorder(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	rec := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec))
+	prevProvider, prevPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(prevProvider)
+		otel.SetTextMapPropagator(prevPropagator)
+	})
+	return rec
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	rec := newRecorder(t)
+
+	srv, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("failed to start miniredis: %v", err)
+	}
+	defer srv.Close()
+	rdb := redis.NewClient(&redis.Options{Addr: srv.Addr()})
+	defer rdb.Close()
+	rdb.AddHook(RedisHook())
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	ctx := metadata.NewIncomingContext(t.Context(), metadata.Pairs("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01"))
+	info := &grpc.UnaryServerInfo{FullMethod: "/auth.AuthService/Login"}
+	handler := func(ctx context.Context, req any) (any, error) {
+		if err := rdb.Get(ctx, "missing").Err(); err != redis.Nil {
+			t.Errorf("expected redis.Nil, got %v", err)
+		}
+		return nil, status.Error(grpccodes.Unauthenticated, "bad password")
+	}
+	if _, err := UnaryServerInterceptor()(ctx, nil, info, handler); status.Code(err) != grpccodes.Unauthenticated {
+		t.Fatalf("expected the handler's error, got %v", err)
+	}
+
+	// the connection handshake is traced too
+	spans := rec.Ended()
+	cmd, rpc := spans[len(spans)-2], spans[len(spans)-1]
+	if rpc.Name() != "auth.AuthService/Login" || rpc.SpanContext().TraceID().String() != traceID {
+		t.Fatalf("expected rpc span to continue the caller's trace, got %q in %s", rpc.Name(), rpc.SpanContext().TraceID())
+	}
+	if rpc.Status().Code == codes.Error {
+		t.Fatalf("client errors must not fail the span")
+	}
+	if cmd.Name() != "redis get" || cmd.Parent().SpanID() != rpc.SpanContext().SpanID() {
+		t.Fatalf("expected redis span to be a child of the rpc span, got %q", cmd.Name())
+	}
+	if cmd.Status().Code == codes.Error {
+		t.Fatalf("a missing key must not fail the span")
+	}
+
+	// server faults do
+	failing := func(ctx context.Context, req any) (any, error) {
+		return nil, status.Error(grpccodes.Internal, "storage error")
+	}
+	_, _ = UnaryServerInterceptor()(t.Context(), nil, info, failing)
+	spans = rec.Ended()
+	if last := spans[len(spans)-1]; last.Status().Code != codes.Error {
+		t.Fatalf("expected internal error to fail the span, got %v", last.Status())
+	}
+}
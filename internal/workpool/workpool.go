@@ -0,0 +1,116 @@
+// Package workpool bounds the concurrency of CPU-heavy work such as password
+// hashing. Callers beyond the parallelism limit wait in a bounded queue; when
+// the queue is full they fail fast instead of piling up behind the CPU.
+package workpool
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// ErrSaturated is returned when both the workers and the queue are busy.
+	ErrSaturated = errors.New("workpool: queue is full")
+	// ErrQueueTimeout is returned when a task waited in the queue for too long.
+	ErrQueueTimeout = errors.New("workpool: timed out waiting for a worker")
+)
+
+// Config controls the pool limits.
+type Config struct {
+	// MaxParallel is the number of tasks that may run at the same time.
+	MaxParallel int
+	// QueueDepth is the number of tasks that may wait for a worker.
+	QueueDepth int
+	// QueueTimeout bounds the time a task waits for a worker. Zero means the
+	// wait is only bounded by the caller's context.
+	QueueTimeout time.Duration
+}
+
+// Stats is a point-in-time snapshot of the pool state.
+type Stats struct {
+	MaxParallel int
+	QueueDepth  int
+	InFlight    int
+	Queued      int
+	// Rejected and TimedOut are cumulative counters.
+	Rejected uint64
+	TimedOut uint64
+}
+
+// Pool runs tasks with bounded parallelism. The zero value is not usable; use New.
+type Pool struct {
+	cfg      Config
+	slots    chan struct{}
+	admitted chan struct{}
+
+	rejected atomic.Uint64
+	timedOut atomic.Uint64
+}
+
+// New creates a pool. MaxParallel defaults to 1 and negative QueueDepth to 0.
+func New(cfg Config) *Pool {
+	if cfg.MaxParallel < 1 {
+		cfg.MaxParallel = 1
+	}
+	if cfg.QueueDepth < 0 {
+		cfg.QueueDepth = 0
+	}
+	return &Pool{
+		cfg:      cfg,
+		slots:    make(chan struct{}, cfg.MaxParallel),
+		admitted: make(chan struct{}, cfg.MaxParallel+cfg.QueueDepth),
+	}
+}
+
+// Do runs fn once a worker slot is available. It returns ErrSaturated without
+// waiting when the queue is full, ErrQueueTimeout when the queue wait exceeds
+// the configured timeout, or the context error if ctx is done first.
+func (p *Pool) Do(ctx context.Context, fn func()) error {
+	select {
+	case p.admitted <- struct{}{}:
+	default:
+		p.rejected.Add(1)
+		return ErrSaturated
+	}
+	defer func() { <-p.admitted }()
+
+	waitCtx := ctx
+	if p.cfg.QueueTimeout > 0 {
+		var cancel context.CancelFunc
+		waitCtx, cancel = context.WithTimeout(ctx, p.cfg.QueueTimeout)
+		defer cancel()
+	}
+
+	select {
+	case p.slots <- struct{}{}:
+	case <-waitCtx.Done():
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		p.timedOut.Add(1)
+		return ErrQueueTimeout
+	}
+	defer func() { <-p.slots }()
+
+	fn()
+	return nil
+}
+
+// Stats returns the current pool state.
+func (p *Pool) Stats() Stats {
+	inFlight := len(p.slots)
+	queued := len(p.admitted) - inFlight
+	if queued < 0 {
+		queued = 0
+	}
+	return Stats{
+		MaxParallel: p.cfg.MaxParallel,
+		QueueDepth:  p.cfg.QueueDepth,
+		InFlight:    inFlight,
+		Queued:      queued,
+		Rejected:    p.rejected.Load(),
+		TimedOut:    p.timedOut.Load(),
+	}
+}
@@ -0,0 +1,61 @@
+package workpool
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestPoolSaturation(t *testing.T) {
+	p := New(Config{MaxParallel: 1, QueueDepth: 1, QueueTimeout: 50 * time.Millisecond})
+	ctx := context.Background()
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	go func() {
+		_ = p.Do(ctx, func() {
+			close(started)
+			<-release
+		})
+	}()
+	<-started
+
+	// the single queue slot is taken by a waiter that eventually times out
+	queued := make(chan error, 1)
+	go func() {
+		queued <- p.Do(ctx, func() {})
+	}()
+	waitFor(t, func() bool { return p.Stats().Queued == 1 })
+
+	if err := p.Do(ctx, func() {}); !errors.Is(err, ErrSaturated) {
+		t.Fatalf("expected ErrSaturated, got %v", err)
+	}
+	if err := <-queued; !errors.Is(err, ErrQueueTimeout) {
+		t.Fatalf("expected ErrQueueTimeout, got %v", err)
+	}
+
+	close(release)
+	waitFor(t, func() bool { return p.Stats().InFlight == 0 })
+
+	ran := false
+	if err := p.Do(ctx, func() { ran = true }); err != nil || !ran {
+		t.Fatalf("expected task to run after release, err=%v", err)
+	}
+
+	st := p.Stats()
+	if st.Rejected != 1 || st.TimedOut != 1 {
+		t.Fatalf("unexpected stats: %+v", st)
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,391 @@
+// Package authtest provides an in-memory implementation of the AuthService
+// gRPC API for tests of services that depend on it.
+//
+// The fake covers the token lifecycle only: Register, Login, Refresh,
+// Revoke, Validate, ValidateToken and Logout. It rejects requests with the
+// same error codes, messages and details as the real server, but skips what
+// needs configuration or storage: password policy, MFA, lockouts, scopes,
+// DPoP and one-time tokens. Every other RPC, such as sessions, profile, MFA
+// and password management, returns codes.Unimplemented.
+//
+// Tokens are deterministic ("access-1", "refresh-1", ...), time is driven by
+// a controllable Clock, and any method can be scripted to fail:
+//
+//	fake := authtest.New()
+//	client, stop := fake.Start()
+//	defer stop()
+//
+//	fake.AddUser("alice", "secret")
+//	fake.FailNext(authtest.MethodLogin, status.Error(codes.Unavailable, "down"))
+package authtest
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andro-kes/auth_service/internal/autherr"
+	pb "github.com/andro-kes/auth_service/proto"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Method names accepted by FailNext and FailAlways.
+const (
+	MethodLogin         = "Login"
+	MethodRegister      = "Register"
+	MethodRefresh       = "Refresh"
+	MethodRevoke        = "Revoke"
+	MethodValidate      = "Validate"
+	MethodValidateToken = "ValidateToken"
+	MethodLogout        = "Logout"
+)
+
+// Default token lifetimes, matching the real service defaults.
+const (
+	DefaultAccessTTL  = 5 * time.Minute
+	DefaultRefreshTTL = 7 * 24 * time.Hour
+)
+
+// Clock is a manually advanced clock. The zero value starts at the Unix epoch.
+type Clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewClock returns a clock set to t.
+func NewClock(t time.Time) *Clock {
+	return &Clock{now: t}
+}
+
+// Now returns the current fake time.
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Advance moves the clock forward by d.
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+type user struct {
+	id       string
+	password string
+}
+
+type issued struct {
+	userID    string
+	issuedAt  time.Time
+	expiresAt time.Time
+}
+
+// Server is an in-memory AuthService. It is safe for concurrent use.
+type Server struct {
+	pb.UnimplementedAuthServiceServer
+
+	// Clock drives token expiry. It may be replaced before the first call.
+	Clock      *Clock
+	AccessTTL  time.Duration
+	RefreshTTL time.Duration
+
+	mu       sync.Mutex
+	seq      int
+	users    map[string]*user
+	emails   map[string]*user
+	access   map[string]issued
+	refresh  map[string]issued
+	failNext map[string][]error
+	failAll  map[string]error
+}
+
+// New creates an empty fake with the default TTLs and a clock at 2024-01-01 UTC.
+func New() *Server {
+	return &Server{
+		Clock:      NewClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)),
+		AccessTTL:  DefaultAccessTTL,
+		RefreshTTL: DefaultRefreshTTL,
+		users:      make(map[string]*user),
+		emails:     make(map[string]*user),
+		access:     make(map[string]issued),
+		refresh:    make(map[string]issued),
+		failNext:   make(map[string][]error),
+		failAll:    make(map[string]error),
+	}
+}
+
+// Start serves the fake over an in-memory connection and returns a client for
+// it together with a function that shuts both down.
+func (s *Server) Start() (pb.AuthServiceClient, func()) {
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer()
+	pb.RegisterAuthServiceServer(srv, s)
+	go func() { _ = srv.Serve(lis) }()
+
+	conn, err := grpc.NewClient("passthrough:///authtest",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		srv.Stop()
+		panic("authtest: failed to create client: " + err.Error())
+	}
+
+	return pb.NewAuthServiceClient(conn), func() {
+		_ = conn.Close()
+		srv.Stop()
+	}
+}
+
+// AddUser registers a user directly and returns its ID.
+func (s *Server) AddUser(username, password string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.addUserLocked(username, password)
+}
+
+// FailNext makes the next call of method return err. Calls queue up in order.
+func (s *Server) FailNext(method string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failNext[method] = append(s.failNext[method], err)
+}
+
+// FailAlways makes every call of method return err until it is reset with a nil err.
+func (s *Server) FailAlways(method string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err == nil {
+		delete(s.failAll, method)
+		return
+	}
+	s.failAll[method] = err
+}
+
+// UserIDForAccessToken reports the user an unexpired access token was issued to,
+// mirroring what a resource server would learn from validating it.
+func (s *Server) UserIDForAccessToken(token string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.access[token]
+	if !ok || !s.Clock.Now().Before(t.expiresAt) {
+		return "", false
+	}
+	return t.userID, true
+}
+
+func (s *Server) Login(_ context.Context, req *pb.LoginRequest) (*pb.TokenResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.scriptedLocked(MethodLogin); err != nil {
+		return nil, err
+	}
+
+	u, ok := s.users[req.Username]
+	if !ok {
+		u, ok = s.emails[strings.ToLower(req.Username)]
+	}
+	if !ok {
+		return nil, autherr.ErrNotFound
+	}
+	if u.password != req.Password {
+		return nil, autherr.ErrLoginUser
+	}
+	return s.issueLocked(u.id), nil
+}
+
+func (s *Server) Register(_ context.Context, req *pb.RegisterRequest) (*pb.RegisterResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.scriptedLocked(MethodRegister); err != nil {
+		return &pb.RegisterResponse{}, err
+	}
+
+	email := strings.ToLower(strings.TrimSpace(req.Email))
+	if strings.Contains(req.Username, "@") {
+		const msg = "username may only contain letters, digits, '.', '_' and '-'"
+		return &pb.RegisterResponse{}, fieldError(autherr.ErrBadRequest, msg, "username", "characters", msg)
+	}
+	if _, exists := s.users[req.Username]; exists {
+		return &pb.RegisterResponse{}, userExists("username")
+	}
+	if _, exists := s.emails[email]; exists && email != "" {
+		return &pb.RegisterResponse{}, userExists("email")
+	}
+	id := s.addUserLocked(req.Username, req.Password)
+	if email != "" {
+		s.emails[email] = s.users[req.Username]
+	}
+	return &pb.RegisterResponse{UserId: id}, nil
+}
+
+func (s *Server) Refresh(_ context.Context, req *pb.RefreshRequest) (*pb.TokenResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.scriptedLocked(MethodRefresh); err != nil {
+		return nil, err
+	}
+
+	t, ok := s.refresh[req.RefreshToken]
+	if !ok || !s.Clock.Now().Before(t.expiresAt) {
+		return nil, autherr.ErrInvalidToken
+	}
+	if req.ExpectedUserId != "" && req.ExpectedUserId != t.userID {
+		return nil, autherr.ErrInvalidToken
+	}
+	delete(s.refresh, req.RefreshToken)
+	return s.issueLocked(t.userID), nil
+}
+
+func (s *Server) Revoke(_ context.Context, req *pb.RevokeRequest) (*pb.RevokeResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.scriptedLocked(MethodRevoke); err != nil {
+		return &pb.RevokeResponse{Error: "failed to revoke token"}, err
+	}
+
+	delete(s.refresh, req.RefreshToken)
+	delete(s.access, req.AccessToken)
+	return &pb.RevokeResponse{Error: "Token revoked"}, nil
+}
+
+// Validate returns the user and lifetime of an unexpired, unrevoked access
+// token; the fake issues no scoped, one-time or DPoP-bound tokens.
+func (s *Server) Validate(_ context.Context, req *pb.ValidateRequest) (*pb.ValidateTokenResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.scriptedLocked(MethodValidate); err != nil {
+		return nil, err
+	}
+	return s.validateLocked(req.AccessToken)
+}
+
+// ValidateToken is Validate for the bearer token in the "authorization"
+// metadata.
+func (s *Server) ValidateToken(ctx context.Context, _ *pb.ValidateTokenRequest) (*pb.ValidateTokenResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.scriptedLocked(MethodValidateToken); err != nil {
+		return nil, err
+	}
+	return s.validateLocked(bearerToken(ctx))
+}
+
+// Logout revokes the caller's bearer token and the refresh token in the
+// "x-refresh-token" metadata, which must belong to the same user.
+func (s *Server) Logout(ctx context.Context, _ *pb.LogoutRequest) (*pb.LogoutResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.scriptedLocked(MethodLogout); err != nil {
+		return nil, err
+	}
+
+	access := bearerToken(ctx)
+	caller, err := s.validateLocked(access)
+	if err != nil {
+		return nil, err
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	refresh := md.Get("x-refresh-token")
+	if len(refresh) == 0 || refresh[0] == "" {
+		return nil, autherr.ErrBadRequest.WithMessage("refresh token is required")
+	}
+	if t, ok := s.refresh[refresh[0]]; ok && t.userID != caller.UserId {
+		return nil, autherr.ErrForbidden.WithMessage("refresh token belongs to another user")
+	}
+	delete(s.refresh, refresh[0])
+	delete(s.access, access)
+	return &pb.LogoutResponse{}, nil
+}
+
+func (s *Server) scriptedLocked(method string) error {
+	if q := s.failNext[method]; len(q) > 0 {
+		s.failNext[method] = q[1:]
+		return q[0]
+	}
+	return s.failAll[method]
+}
+
+func (s *Server) validateLocked(token string) (*pb.ValidateTokenResponse, error) {
+	if token == "" {
+		return nil, autherr.ErrNoToken
+	}
+	t, ok := s.access[token]
+	if !ok {
+		return nil, autherr.ErrInvalidToken
+	}
+	if !s.Clock.Now().Before(t.expiresAt) {
+		return nil, autherr.ErrTokenExpired
+	}
+	return &pb.ValidateTokenResponse{
+		UserId:    t.userID,
+		Jti:       token,
+		IssuedAt:  timestamppb.New(t.issuedAt),
+		ExpiresAt: timestamppb.New(t.expiresAt),
+	}, nil
+}
+
+// userExists is the error of the real server for a taken username or email.
+func userExists(field string) error {
+	return fieldError(autherr.ErrUserExists, field+" already taken", field, "taken", field+" is already taken")
+}
+
+// fieldError is sentinel with message and a BadRequest violation of field,
+// like the errors the real server returns for rejected input.
+func fieldError(sentinel *autherr.AuthError, message, field, reason, description string) error {
+	return sentinel.WithMessage(message).WithDetails(&errdetails.BadRequest{
+		FieldViolations: []*errdetails.BadRequest_FieldViolation{{
+			Field:       field,
+			Reason:      reason,
+			Description: description,
+		}},
+	})
+}
+
+// bearerToken returns the token of "authorization: Bearer <token>" metadata.
+func bearerToken(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, v := range md.Get("authorization") {
+		if scheme, token, ok := strings.Cut(v, " "); ok && strings.EqualFold(scheme, "bearer") {
+			return strings.TrimSpace(token)
+		}
+	}
+	return ""
+}
+
+func (s *Server) addUserLocked(username, password string) string {
+	s.seq++
+	id := fmt.Sprintf("user-%d", s.seq)
+	s.users[username] = &user{id: id, password: password}
+	return id
+}
+
+func (s *Server) issueLocked(userID string) *pb.TokenResponse {
+	s.seq++
+	now := s.Clock.Now()
+	access := fmt.Sprintf("access-%d", s.seq)
+	refresh := fmt.Sprintf("refresh-%d", s.seq)
+	s.access[access] = issued{userID: userID, issuedAt: now, expiresAt: now.Add(s.AccessTTL)}
+	s.refresh[refresh] = issued{userID: userID, issuedAt: now, expiresAt: now.Add(s.RefreshTTL)}
+
+	return &pb.TokenResponse{
+		AccessToken:      access,
+		RefreshToken:     refresh,
+		AccessExpiresIn:  durationpb.New(s.AccessTTL),
+		RefreshExpiresIn: durationpb.New(s.RefreshTTL),
+		UserId:           userID,
+	}
+}
@@ -0,0 +1,148 @@
+package authtest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	pb "github.com/andro-kes/auth_service/proto"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestFakeServer(t *testing.T) {
+	fake := New()
+	client, stop := fake.Start()
+	defer stop()
+
+	ctx := context.Background()
+
+	reg, err := client.Register(ctx, &pb.RegisterRequest{Username: "alice", Email: "Alice@example.com", Password: "secret"})
+	if err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+
+	login, err := client.Login(ctx, &pb.LoginRequest{Username: "alice", Password: "secret"})
+	if err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+	if login.UserId != reg.UserId || login.AccessToken != "access-2" {
+		t.Fatalf("unexpected login response: %+v", login)
+	}
+	if uid, ok := fake.UserIDForAccessToken(login.AccessToken); !ok || uid != reg.UserId {
+		t.Fatalf("expected access token to belong to %s", reg.UserId)
+	}
+
+	if byEmail, err := client.Login(ctx, &pb.LoginRequest{Username: "alice@example.com", Password: "secret"}); err != nil || byEmail.UserId != reg.UserId {
+		t.Fatalf("Login by email = %+v, %v", byEmail, err)
+	}
+	if _, err := client.Login(ctx, &pb.LoginRequest{Username: "alice", Password: "wrong"}); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected Unauthenticated, got %v", err)
+	}
+
+	fake.FailNext(MethodRefresh, status.Error(codes.Unavailable, "redis down"))
+	if _, err := client.Refresh(ctx, &pb.RefreshRequest{RefreshToken: login.RefreshToken}); status.Code(err) != codes.Unavailable {
+		t.Fatalf("expected scripted Unavailable, got %v", err)
+	}
+
+	refreshed, err := client.Refresh(ctx, &pb.RefreshRequest{RefreshToken: login.RefreshToken})
+	if err != nil {
+		t.Fatalf("Refresh failed: %v", err)
+	}
+	if _, err := client.Refresh(ctx, &pb.RefreshRequest{RefreshToken: login.RefreshToken}); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected rotated token to be rejected, got %v", err)
+	}
+
+	v, err := client.Validate(ctx, &pb.ValidateRequest{AccessToken: refreshed.AccessToken})
+	if err != nil || v.UserId != reg.UserId {
+		t.Fatalf("Validate = %+v, %v", v, err)
+	}
+	authCtx := metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+refreshed.AccessToken)
+	if v, err := client.ValidateToken(authCtx, &pb.ValidateTokenRequest{}); err != nil || v.UserId != reg.UserId {
+		t.Fatalf("ValidateToken = %+v, %v", v, err)
+	}
+	if _, err := client.ValidateToken(ctx, &pb.ValidateTokenRequest{}); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected Unauthenticated without a token, got %v", err)
+	}
+	if _, err := client.Revoke(ctx, &pb.RevokeRequest{AccessToken: login.AccessToken}); err != nil {
+		t.Fatalf("Revoke failed: %v", err)
+	}
+	if _, err := client.Validate(ctx, &pb.ValidateRequest{AccessToken: login.AccessToken}); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected revoked access token to be rejected, got %v", err)
+	}
+	fake.AddUser("bob", "pw")
+	bob, err := client.Login(ctx, &pb.LoginRequest{Username: "bob", Password: "pw"})
+	if err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+	bobCtx := metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+bob.AccessToken, "x-refresh-token", refreshed.RefreshToken)
+	if _, err := client.Logout(bobCtx, &pb.LogoutRequest{}); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("expected PermissionDenied for another user's refresh token, got %v", err)
+	}
+	bobCtx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+bob.AccessToken, "x-refresh-token", bob.RefreshToken)
+	if _, err := client.Logout(bobCtx, &pb.LogoutRequest{}); err != nil {
+		t.Fatalf("Logout failed: %v", err)
+	}
+	if _, err := client.Validate(ctx, &pb.ValidateRequest{AccessToken: bob.AccessToken}); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected the access token revoked by Logout, got %v", err)
+	}
+	if _, err := client.Refresh(ctx, &pb.RefreshRequest{RefreshToken: bob.RefreshToken}); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected the refresh token revoked by Logout, got %v", err)
+	}
+
+	fake.Clock.Advance(DefaultAccessTTL)
+	if _, err := client.Validate(ctx, &pb.ValidateRequest{AccessToken: refreshed.AccessToken}); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected expired access token to be rejected, got %v", err)
+	}
+
+	fake.Clock.Advance(DefaultRefreshTTL + time.Second)
+	if _, err := client.Refresh(ctx, &pb.RefreshRequest{RefreshToken: refreshed.RefreshToken}); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected expired token to be rejected, got %v", err)
+	}
+}
+
+func TestFakeServerErrors(t *testing.T) {
+	fake := New()
+	client, stop := fake.Start()
+	defer stop()
+
+	ctx := context.Background()
+	if _, err := client.Register(ctx, &pb.RegisterRequest{Username: "alice", Email: "alice@example.com", Password: "secret"}); err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+
+	// the real server reports taken usernames and emails as AlreadyExists
+	// naming the field
+	for field, req := range map[string]*pb.RegisterRequest{
+		"username": {Username: "alice", Password: "secret"},
+		"email":    {Username: "bob", Email: "Alice@example.com", Password: "secret"},
+	} {
+		_, err := client.Register(ctx, req)
+		st := status.Convert(err)
+		if st.Code() != codes.AlreadyExists || st.Message() != field+" already taken" {
+			t.Fatalf("duplicate %s: got %v, want AlreadyExists", field, err)
+		}
+		if v := fieldViolation(st); v == nil || v.Field != field || v.Reason != "taken" {
+			t.Fatalf("duplicate %s: unexpected details %v", field, st.Details())
+		}
+	}
+	_, err := client.Register(ctx, &pb.RegisterRequest{Username: "carol@example.com", Password: "secret"})
+	if st := status.Convert(err); st.Code() != codes.InvalidArgument || fieldViolation(st).GetField() != "username" {
+		t.Fatalf("expected InvalidArgument for a username with @, got %v", err)
+	}
+
+	if _, err := client.ListSessions(ctx, &pb.ListSessionsRequest{}); status.Code(err) != codes.Unimplemented {
+		t.Fatalf("expected Unimplemented for an RPC outside the fake, got %v", err)
+	}
+}
+
+func fieldViolation(st *status.Status) *errdetails.BadRequest_FieldViolation {
+	for _, d := range st.Details() {
+		if br, ok := d.(*errdetails.BadRequest); ok && len(br.FieldViolations) > 0 {
+			return br.FieldViolations[0]
+		}
+	}
+	return nil
+}
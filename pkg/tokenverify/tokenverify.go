@@ -0,0 +1,333 @@
+// Package tokenverify validates access tokens of the auth service offline,
+// against the public keys it publishes at /.well-known/jwks.json:
+//
+//	v, err := tokenverify.New(tokenverify.Config{JWKSURL: "https://auth.example/.well-known/jwks.json"})
+//	...
+//	claims, err := v.Verify(ctx, bearer)
+//	switch {
+//	case errors.Is(err, tokenverify.ErrTokenExpired):
+//		// ask the client to refresh
+//	case err != nil:
+//		// reject
+//	}
+//
+// Only JWTs signed with asymmetric keys (RS256, ES256, ES384, EdDSA) can be
+// verified this way. Tokens signed with the shared HMAC secret, opaque
+// reference tokens and PASETO tokens need the Introspect RPC, as does
+// anything that is decided server-side: revoked jtis, token versions,
+// consumed one-time tokens and DPoP proofs.
+package tokenverify
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	// ErrInvalidToken is returned for malformed tokens, bad signatures,
+	// tokens that are not yet valid, tokens of the wrong type and tokens
+	// signed with the shared HMAC secret.
+	ErrInvalidToken = errors.New("tokenverify: invalid token")
+	// ErrTokenExpired is returned for well-formed tokens past their expiry;
+	// the client should refresh.
+	ErrTokenExpired = errors.New("tokenverify: token expired")
+	// ErrUnknownKey is returned when the token's "kid" is not in the key set,
+	// even after refetching it.
+	ErrUnknownKey = errors.New("tokenverify: unknown signing key")
+	// ErrKeysUnavailable is returned when the key set cannot be fetched and
+	// no cached copy is available.
+	ErrKeysUnavailable = errors.New("tokenverify: signing keys unavailable")
+)
+
+// Token types of the "typ" claim.
+const (
+	TypeAccess  = "access"
+	TypeService = "service"
+)
+
+// Claims are the claims of an access or service token.
+type Claims struct {
+	UserID    string `json:"uid"`
+	Type      string `json:"typ"`
+	SessionID string `json:"sid,omitempty"`
+	Scope     string `json:"scope,omitempty"`
+	// SubjectType is "service_account" for tokens of service accounts.
+	SubjectType string `json:"sub_type,omitempty"`
+	// OneTime tokens are valid for a single call; only the auth service can
+	// enforce that.
+	OneTime bool  `json:"ott,omitempty"`
+	Version int64 `json:"ver,omitempty"`
+	// Roles are the names of the user's roles when the token was issued.
+	Roles []string `json:"roles,omitempty"`
+	// Confirmation binds the token to a DPoP key.
+	Confirmation *Confirmation `json:"cnf,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// Confirmation is the RFC 7800 "cnf" claim.
+type Confirmation struct {
+	JKT string `json:"jkt,omitempty"`
+}
+
+// HasScope reports whether scope is among the token's space-separated scopes.
+func (c *Claims) HasScope(scope string) bool {
+	return slices.Contains(strings.Fields(c.Scope), scope)
+}
+
+// Config configures a Verifier.
+type Config struct {
+	// JWKSURL is the auth service's /.well-known/jwks.json.
+	JWKSURL string
+	// HTTPClient fetches the key set. Default: a client with a 10s timeout.
+	HTTPClient *http.Client
+	// CacheTTL is how long a fetched key set is used. Default: 5 minutes.
+	CacheTTL time.Duration
+	// MinRefetchInterval limits refetches triggered by unknown key IDs.
+	// Default: 30 seconds.
+	MinRefetchInterval time.Duration
+	// Leeway tolerates clock skew in "exp" and "nbf". Default: none.
+	Leeway time.Duration
+	// Types lists the accepted "typ" claims. Default: access tokens only.
+	Types []string
+	// Now replaces the clock. Default: time.Now.
+	Now func() time.Time
+}
+
+// Verifier validates tokens against a cached JWK set. It is safe for
+// concurrent use.
+type Verifier struct {
+	cfg    Config
+	parser *jwt.Parser
+
+	mu        sync.Mutex
+	keys      map[string]publicKey
+	fetchedAt time.Time
+}
+
+type publicKey struct {
+	alg string
+	key crypto.PublicKey
+}
+
+// New returns a Verifier. Keys are fetched on first use.
+func New(cfg Config) (*Verifier, error) {
+	if cfg.JWKSURL == "" {
+		return nil, errors.New("tokenverify: JWKSURL is required")
+	}
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	if cfg.CacheTTL == 0 {
+		cfg.CacheTTL = 5 * time.Minute
+	}
+	if cfg.MinRefetchInterval == 0 {
+		cfg.MinRefetchInterval = 30 * time.Second
+	}
+	if len(cfg.Types) == 0 {
+		cfg.Types = []string{TypeAccess}
+	}
+	if cfg.Now == nil {
+		cfg.Now = time.Now
+	}
+	return &Verifier{
+		cfg: cfg,
+		parser: jwt.NewParser(
+			jwt.WithValidMethods([]string{"RS256", "ES256", "ES384", "EdDSA"}),
+			jwt.WithExpirationRequired(),
+			jwt.WithLeeway(cfg.Leeway),
+			jwt.WithTimeFunc(cfg.Now),
+		),
+	}, nil
+}
+
+// Verify checks the token's signature, time claims and type and returns its
+// claims.
+func (v *Verifier) Verify(ctx context.Context, token string) (*Claims, error) {
+	claims := &Claims{}
+	if err := v.VerifyClaims(ctx, token, claims); err != nil {
+		return nil, err
+	}
+	if !slices.Contains(v.cfg.Types, claims.Type) {
+		return nil, fmt.Errorf("%w: unexpected token type %q", ErrInvalidToken, claims.Type)
+	}
+	return claims, nil
+}
+
+// VerifyClaims checks the signature and time claims of any JWT signed with a
+// key of the set and decodes its claims into claims. Unlike Verify it does
+// not check "typ", so it also verifies tokens of other issuers, such as the
+// ID tokens of an OpenID provider; their issuer and audience are left to
+// the caller.
+func (v *Verifier) VerifyClaims(ctx context.Context, token string, claims jwt.Claims) error {
+	var keyErr error
+	_, err := v.parser.ParseWithClaims(token, claims, func(t *jwt.Token) (any, error) {
+		kid, _ := t.Header["kid"].(string)
+		if kid == "" {
+			return nil, ErrInvalidToken
+		}
+		k, err := v.key(ctx, kid)
+		if err != nil {
+			keyErr = err
+			return nil, err
+		}
+		if k.alg != t.Method.Alg() {
+			return nil, ErrInvalidToken
+		}
+		return k.key, nil
+	})
+	switch {
+	case keyErr != nil:
+		return keyErr
+	case errors.Is(err, jwt.ErrTokenExpired):
+		return ErrTokenExpired
+	case err != nil:
+		return fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	return nil
+}
+
+// key returns the key kid, refetching the set when it is stale or, at most
+// every MinRefetchInterval, when kid is unknown.
+func (v *Verifier) key(ctx context.Context, kid string) (publicKey, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	now := v.cfg.Now()
+	k, ok := v.keys[kid]
+	stale := now.Sub(v.fetchedAt) >= v.cfg.CacheTTL
+	if ok && !stale {
+		return k, nil
+	}
+	if !stale && now.Sub(v.fetchedAt) < v.cfg.MinRefetchInterval {
+		return publicKey{}, ErrUnknownKey
+	}
+
+	keys, err := v.fetch(ctx)
+	if err != nil {
+		if ok {
+			// keep verifying with the cached key while the service is down
+			return k, nil
+		}
+		return publicKey{}, fmt.Errorf("%w: %v", ErrKeysUnavailable, err)
+	}
+	v.keys, v.fetchedAt = keys, now
+	if k, ok = keys[kid]; !ok {
+		return publicKey{}, ErrUnknownKey
+	}
+	return k, nil
+}
+
+func (v *Verifier) fetch(ctx context.Context) (map[string]publicKey, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.cfg.JWKSURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := v.cfg.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]publicKey, len(set.Keys))
+	for _, j := range set.Keys {
+		if j.Kid == "" || (j.Use != "" && j.Use != "sig") {
+			continue
+		}
+		// skip keys of types this version does not know
+		if pub, err := j.publicKey(); err == nil {
+			keys[j.Kid] = publicKey{alg: j.Alg, key: pub}
+		}
+	}
+	return keys, nil
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (j jwk) publicKey() (crypto.PublicKey, error) {
+	dec := base64.RawURLEncoding.DecodeString
+	switch j.Kty {
+	case "RSA":
+		n, err := dec(j.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := dec(j.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch j.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", j.Crv)
+		}
+		x, err := dec(j.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := dec(j.Y)
+		if err != nil {
+			return nil, err
+		}
+		pub := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		// rejects points that are not on the curve
+		if _, err := pub.ECDH(); err != nil {
+			return nil, err
+		}
+		return pub, nil
+	case "OKP":
+		if j.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", j.Crv)
+		}
+		x, err := dec(j.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", j.Kty)
+	}
+}
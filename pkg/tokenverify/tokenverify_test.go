@@ -0,0 +1,142 @@
+package tokenverify
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/andro-kes/auth_service/internal/signing"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func newKey(t *testing.T, priv any) *signing.Key {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := signing.ParsePrivateKeyPEM(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// jwksServer serves the JWK set of keys, which tests may change.
+type jwksServer struct {
+	mu      sync.Mutex
+	keys    []*signing.Key
+	down    bool
+	fetches int
+}
+
+func (s *jwksServer) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fetches++
+	if s.down {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	set := signing.JWKSet{Keys: []signing.JWK{}}
+	for _, k := range s.keys {
+		if jwk, ok := k.JWK(); ok {
+			set.Keys = append(set.Keys, jwk)
+		}
+	}
+	_ = json.NewEncoder(w).Encode(set)
+}
+
+func TestVerify(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	current, next := newKey(t, ecKey), newKey(t, edKey)
+
+	keys := &jwksServer{keys: []*signing.Key{current}}
+	srv := httptest.NewServer(keys)
+	defer srv.Close()
+
+	now := time.Now()
+	v, err := New(Config{JWKSURL: srv.URL, Now: func() time.Time { return now }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sign := func(key *signing.Key, typ string, exp time.Time) string {
+		t.Helper()
+		s, err := key.Sign(jwt.MapClaims{"uid": "alice", "typ": typ, "scope": "files:read files:write", "exp": exp.Unix()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	ctx := t.Context()
+
+	claims, err := v.Verify(ctx, sign(current, TypeAccess, now.Add(time.Minute)))
+	if err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	if claims.UserID != "alice" || !claims.HasScope("files:write") || claims.HasScope("files") {
+		t.Fatalf("unexpected claims %+v", claims)
+	}
+
+	if _, err := v.Verify(ctx, sign(current, TypeAccess, now.Add(-time.Minute))); !errors.Is(err, ErrTokenExpired) {
+		t.Fatalf("expected ErrTokenExpired, got %v", err)
+	}
+	if _, err := v.Verify(ctx, sign(current, TypeService, now.Add(time.Minute))); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("expected a service token to be rejected by default, got %v", err)
+	}
+	secret := signing.HMAC(jwt.SigningMethodHS256, []byte("012345678901234567890123456789ab"))
+	if _, err := v.Verify(ctx, sign(secret, TypeAccess, now.Add(time.Minute))); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("expected an HMAC token to be unverifiable offline, got %v", err)
+	}
+
+	// a rotated-in key is picked up on its first token, but refetches are
+	// rate limited
+	keys.mu.Lock()
+	keys.keys = append(keys.keys, next)
+	keys.mu.Unlock()
+	rotated := sign(next, TypeAccess, now.Add(time.Hour))
+	if _, err := v.Verify(ctx, rotated); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("expected the refetch to be rate limited, got %v", err)
+	}
+	now = now.Add(time.Minute)
+	if _, err := v.Verify(ctx, rotated); err != nil {
+		t.Fatalf("expected the new key to be fetched, got %v", err)
+	}
+	if keys.fetches != 2 {
+		t.Fatalf("expected 2 fetches, got %d", keys.fetches)
+	}
+
+	// cached keys keep working while the service is unreachable
+	keys.mu.Lock()
+	keys.down = true
+	keys.mu.Unlock()
+	now = now.Add(10 * time.Minute)
+	if _, err := v.Verify(ctx, rotated); err != nil {
+		t.Fatalf("expected the cached key to be used, got %v", err)
+	}
+
+	cold, err := New(Config{JWKSURL: srv.URL, Types: []string{TypeAccess, TypeService}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cold.Verify(ctx, rotated); !errors.Is(err, ErrKeysUnavailable) {
+		t.Fatalf("expected ErrKeysUnavailable, got %v", err)
+	}
+}